@@ -0,0 +1,447 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// DefaultAgentTimeout is how long a remote agent can go without contacting the
+// server before its jobs are interrupted
+const DefaultAgentTimeout = time.Minute
+
+// DefaultMaxRequeues is how many times an interrupted job is requeued before
+// it's left interrupted, when WithMaxRequeues isn't set
+const DefaultMaxRequeues = 3
+
+var (
+	// ErrNoJobAvailable is returned when an agent claims a job but none are waiting that it can run
+	ErrNoJobAvailable = errors.New("no job available")
+	// ErrAgentNameInUse is returned when a remote agent uses the name of the server's local agent
+	ErrAgentNameInUse = errors.New("agent name is in use by the server")
+)
+
+// AgentInfo describes an agent that runs jobs
+type AgentInfo struct {
+	Name string
+	// Labels advertise what the agent provides, such as its OS, architecture
+	// or tools like "docker" and "gpu". Jobs only run on agents that have
+	// every label in their RunsOn.
+	Labels []string
+	// Capacity is how many jobs the agent runs at once. For the local agent,
+	// 0 is unlimited.
+	Capacity int
+}
+
+// AgentPool hands jobs to remote agents. Agents poll for work, run jobs
+// themselves and report their output back. Every call counts as a heartbeat,
+// agents that stop calling are removed and their jobs interrupted.
+type AgentPool interface {
+	// ClaimJob registers the agent, or updates its registration, and assigns it
+	// the oldest waiting job it can run. It returns ErrNoJobAvailable if there
+	// isn't one or the agent is already at capacity.
+	ClaimJob(agent AgentInfo) (Job, error)
+	// ReportLogs appends lines to the logs of a job running on the agent and
+	// renews the agent's lease on the job, even if there are no lines. It
+	// returns ErrJobFinished once the job has been cancelled or its lease has
+	// expired, so the agent can stop running it.
+	ReportLogs(agentName string, jobID JobID, lines []string) error
+	// FinishJob records the result of a job run by the agent. A cancelled job
+	// keeps its slot on the agent until then, and FinishJob frees the slot
+	// and returns ErrJobFinished.
+	FinishJob(agentName string, jobID JobID, result JobResult) error
+}
+
+// DefaultAgentLabels are advertised by every agent: the OS and architecture it runs on
+func DefaultAgentLabels() []string {
+	return []string{runtime.GOOS, runtime.GOARCH}
+}
+
+// WithAgentLabels adds labels to the server's local agent, in addition to
+// DefaultAgentLabels
+func WithAgentLabels(labels ...string) Option {
+	return func(s *CIServer) {
+		s.localLabels = append(s.localLabels, labels...)
+	}
+}
+
+// WithLocalAgent controls whether the server runs jobs itself. When disabled,
+// jobs wait for a remote agent to claim them.
+func WithLocalAgent(enabled bool) Option {
+	return func(s *CIServer) {
+		s.localDisabled = !enabled
+	}
+}
+
+// WithAgentTimeout sets how long a remote agent can go without contacting the
+// server before it is considered gone, defaulting to DefaultAgentTimeout
+func WithAgentTimeout(timeout time.Duration) Option {
+	return func(s *CIServer) {
+		s.agentTimeout = timeout
+	}
+}
+
+// WithJobLease sets how long a remote agent can go without reporting a job's
+// logs before it loses the job, which is interrupted even if the agent is
+// still claiming others. It defaults to the agent timeout.
+func WithJobLease(lease time.Duration) Option {
+	return func(s *CIServer) {
+		s.jobLease = lease
+	}
+}
+
+// WithRequeueInterrupted schedules a copy of each job interrupted by its agent
+// disappearing, so it can run on another agent
+func WithRequeueInterrupted(requeue bool) Option {
+	return func(s *CIServer) {
+		s.requeueInterrupted = requeue
+	}
+}
+
+// WithMaxRequeues limits how many times WithRequeueInterrupted requeues a job
+// that keeps being interrupted, defaulting to DefaultMaxRequeues. Negative
+// values requeue jobs every time.
+func WithMaxRequeues(requeues int) Option {
+	return func(s *CIServer) {
+		s.maxRequeues = requeues
+	}
+}
+
+// agent tracks the jobs assigned to an agent
+type agent struct {
+	AgentInfo
+	local   bool
+	running map[JobID]bool
+	// leases are when a remote agent's jobs were last renewed
+	leases map[JobID]time.Time
+	// lastSeen is when a remote agent last contacted the server
+	lastSeen time.Time
+}
+
+func newAgent(info AgentInfo, local bool) *agent {
+	return &agent{
+		AgentInfo: info,
+		local:     local,
+		running:   make(map[JobID]bool),
+		leases:    make(map[JobID]time.Time),
+	}
+}
+
+// free returns true if the agent can start another job
+func (a *agent) free() bool {
+	return (a.local && a.Capacity == 0) || len(a.running) < a.Capacity
+}
+
+// expired returns true if a remote agent hasn't contacted the server within the
+// timeout, so its slots shouldn't be counted even before it's removed
+func (a *agent) expired(now time.Time, timeout time.Duration) bool {
+	return !a.local && now.Sub(a.lastSeen) > timeout
+}
+
+// canRun returns true if the agent has every label the job requires
+func (a *agent) canRun(job *Job) bool {
+	for _, required := range job.RunsOn {
+		found := false
+		for _, label := range a.Labels {
+			if label == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// allAgents returns the local agent, if enabled, and remote agents sorted by
+// name. The caller must hold jobMutex.
+func (s *CIServer) allAgents() []*agent {
+	var agents []*agent
+	if s.local != nil {
+		agents = append(agents, s.local)
+	}
+	var remote []*agent
+	for _, a := range s.agents {
+		remote = append(remote, a)
+	}
+	sort.Slice(remote, func(i, j int) bool {
+		return remote[i].Name < remote[j].Name
+	})
+	return append(agents, remote...)
+}
+
+// release frees a job's slot on the agent it was assigned to. The caller must
+// hold jobMutex.
+func (s *CIServer) release(job *Job) {
+	if s.local != nil && s.local.running[job.ID] {
+		delete(s.local.running, job.ID)
+		return
+	}
+	if a, ok := s.agents[job.Agent]; ok {
+		delete(a.running, job.ID)
+		delete(a.leases, job.ID)
+	}
+}
+
+// remoteAgent returns the remote agent a job is running on. The caller must hold
+// jobMutex.
+func (s *CIServer) remoteAgent(job *Job) (*agent, bool) {
+	a, ok := s.agents[job.Agent]
+	if !ok || !a.running[job.ID] {
+		return nil, false
+	}
+	return a, true
+}
+
+func (s *CIServer) ClaimJob(info AgentInfo) (Job, error) {
+	if info.Capacity < 1 {
+		info.Capacity = 1
+	}
+
+	s.jobMutex.Lock()
+	if s.local != nil && info.Name == s.local.Name {
+		s.jobMutex.Unlock()
+		return Job{}, ErrAgentNameInUse
+	}
+	a, ok := s.agents[info.Name]
+	if !ok {
+		a = newAgent(info, false)
+		s.agents[info.Name] = a
+	}
+	a.AgentInfo = info
+	a.lastSeen = s.clock.Now()
+	s.monitorOnce.Do(func() {
+		go s.monitorAgents()
+	})
+	if !a.free() {
+		s.jobMutex.Unlock()
+		return Job{}, ErrNoJobAvailable
+	}
+
+	// Only the server can restore artifacts from the jobs a job needs
+	i := s.nextJob(s.runningByProject(), func(job *Job) bool {
+		return !needsArtifacts(job) && a.canRun(job)
+	})
+	if i < 0 {
+		s.jobMutex.Unlock()
+		return Job{}, ErrNoJobAvailable
+	}
+	queued := s.waiting[i]
+	job := queued.job
+	s.waiting = append(s.waiting[:i:i], s.waiting[i+1:]...)
+	a.running[job.ID] = true
+	a.leases[job.ID] = s.clock.Now()
+	job.Agent = a.Name
+	if s.cluster != nil {
+		s.jobMutex.Unlock()
+		claimed := s.claimRun(queued)
+		s.jobMutex.Lock()
+		// The job may have been cancelled while it was claimed
+		if !claimed || job.Status != JobStatusPending {
+			delete(a.running, job.ID)
+			delete(a.leases, job.ID)
+			s.jobMutex.Unlock()
+			return Job{}, ErrNoJobAvailable
+		}
+	}
+	// The status is updated before releasing the lock, so a cancellation
+	// can't be overwritten
+	event := s.transition(job, JobStatusRunning)
+	s.jobMutex.Unlock()
+
+	s.emit(event)
+	s.appendLog(job, "Running on agent "+a.Name)
+	return s.JobDetail(job.ID), nil
+}
+
+func (s *CIServer) ReportLogs(agentName string, jobID JobID, lines []string) error {
+	s.jobMutex.Lock()
+	s.touch(agentName)
+	job, ok := s.jobs[jobID]
+	if !ok || job.Agent != agentName {
+		s.jobMutex.Unlock()
+		return ErrJobNotFound
+	}
+	a, running := s.remoteAgent(job)
+	if job.Status.Done() || !running {
+		s.jobMutex.Unlock()
+		return ErrJobFinished
+	}
+	a.leases[jobID] = s.clock.Now()
+	s.jobMutex.Unlock()
+
+	for _, line := range lines {
+		s.appendLog(job, line)
+	}
+	return nil
+}
+
+func (s *CIServer) FinishJob(agentName string, jobID JobID, result JobResult) error {
+	if status := result.Status; status != JobStatusSuccess && status != JobStatusFailure && status != JobStatusCancelled {
+		return fmt.Errorf("invalid final status %q", status)
+	}
+
+	s.jobMutex.Lock()
+	s.touch(agentName)
+	job, ok := s.jobs[jobID]
+	if !ok || job.Agent != agentName {
+		s.jobMutex.Unlock()
+		return ErrJobNotFound
+	}
+	if _, running := s.remoteAgent(job); !running {
+		s.jobMutex.Unlock()
+		return ErrJobFinished
+	}
+	s.release(job)
+	// The job was cancelled, and the agent has now stopped running it
+	if job.Status.Done() {
+		s.jobMutex.Unlock()
+		s.dispatch()
+		return ErrJobFinished
+	}
+	job.Outputs = result.Outputs
+	job.Tests = result.Tests
+	job.Coverage = result.Coverage
+	job.Hooks = result.Hooks
+	job.FailedPhase = result.FailedPhase
+	job.TimedOut = result.TimedOut
+	job.Stalled = result.Stalled
+	job.CommitHash = result.CommitHash
+	event := s.transition(job, result.Status)
+	s.jobMutex.Unlock()
+
+	s.clearCancel(jobID)
+	s.emit(event)
+	// Jobs that need this one may now be able to start
+	s.dispatch()
+	return nil
+}
+
+// touch records that a remote agent has contacted the server. The caller must
+// hold jobMutex.
+func (s *CIServer) touch(agentName string) {
+	if a, ok := s.agents[agentName]; ok {
+		a.lastSeen = s.clock.Now()
+	}
+}
+
+// monitorAgents periodically removes agents that have stopped responding and
+// interrupts jobs whose leases have expired
+func (s *CIServer) monitorAgents() {
+	for {
+		s.expireAgents(<-s.clock.After(min(s.timeout(), s.lease()) / 4))
+	}
+}
+
+func (s *CIServer) timeout() time.Duration {
+	if s.agentTimeout <= 0 {
+		return DefaultAgentTimeout
+	}
+	return s.agentTimeout
+}
+
+func (s *CIServer) lease() time.Duration {
+	if s.jobLease <= 0 {
+		return s.timeout()
+	}
+	return s.jobLease
+}
+
+// expireAgents removes remote agents that haven't been seen within the
+// timeout and takes jobs from agents that haven't renewed their leases,
+// interrupting the jobs and requeueing them if enabled
+func (s *CIServer) expireAgents(now time.Time) {
+	type interruption struct {
+		job    *Job
+		event  JobEvent
+		reason string
+	}
+	var interrupted []interruption
+
+	s.jobMutex.Lock()
+	for name, a := range s.agents {
+		gone := a.expired(now, s.timeout())
+		if gone {
+			delete(s.agents, name)
+		}
+		for jobID := range a.running {
+			reason := "stopped responding"
+			if !gone {
+				if now.Sub(a.leases[jobID]) <= s.lease() {
+					continue
+				}
+				reason = "stopped renewing the job's lease"
+			}
+			job := s.jobs[jobID]
+			s.release(job)
+			// Cancelled jobs were only holding the slot until the agent stopped
+			if job.Status.Done() {
+				continue
+			}
+			interrupted = append(interrupted, interruption{job: job, event: s.transition(job, JobStatusInterrupted), reason: reason})
+		}
+	}
+	s.jobMutex.Unlock()
+
+	for _, i := range interrupted {
+		s.appendLog(i.job, fmt.Sprintf("Agent %s %s, job interrupted", i.event.Job.Agent, i.reason))
+		s.clearCancel(i.job.ID)
+		s.emit(i.event)
+		s.requeue(i.job, i.event.Job, now)
+	}
+	if len(interrupted) > 0 {
+		s.dispatch()
+	}
+}
+
+// requeue schedules a copy of an interrupted job if WithRequeueInterrupted is
+// set, unless it has already been requeued the maximum number of times
+func (s *CIServer) requeue(job *Job, interrupted Job, now time.Time) {
+	if !s.requeueInterrupted {
+		return
+	}
+	attempt := max(interrupted.Attempt, 1)
+	maxRequeues := s.maxRequeues
+	if maxRequeues == 0 {
+		maxRequeues = DefaultMaxRequeues
+	}
+	if maxRequeues > 0 && attempt > maxRequeues {
+		s.appendLog(job, fmt.Sprintf("Not requeued, the job was already attempted %d times", attempt))
+		return
+	}
+	requeued := newJob(interrupted.Spec(), now)
+	requeued.RequeuedFrom = job.ID
+	requeued.Attempt = attempt + 1
+	s.appendLog(job, "Requeued as job "+string(requeued.ID))
+	s.enqueue(requeued)
+}
+
+// agentStats describes an agent's utilization. The caller must hold jobMutex.
+func (s *CIServer) agentStats(a *agent, running []Job) WorkerStats {
+	worker := WorkerStats{
+		Name:     a.Name,
+		Labels:   a.Labels,
+		Capacity: a.Capacity,
+		LastSeen: a.lastSeen,
+	}
+	for _, job := range running {
+		if a.running[job.ID] {
+			worker.Running = append(worker.Running, job.ID)
+		}
+	}
+	return worker
+}
+
+// newLocalAgent describes the server itself as an agent
+func (s *CIServer) newLocalAgent() *agent {
+	labels := append(DefaultAgentLabels(), s.localLabels...)
+	return newAgent(AgentInfo{
+		Name:     hostname(),
+		Labels:   labels,
+		Capacity: s.maxConcurrent,
+	}, true)
+}
@@ -0,0 +1,233 @@
+package minici
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/gittools"
+)
+
+func TestRemoteAgents(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false))
+	pool := ci.(AgentPool)
+
+	gpuJob, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "train", RunsOn: []string{"gpu"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyJob, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build", Env: map[string]string{"FOO": "bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The older GPU job is skipped by an agent without the label
+	cpu := AgentInfo{Name: "cpu-box", Labels: []string{"linux"}}
+	job, err := pool.ClaimJob(cpu)
+	if err != nil {
+		t.Fatalf("Expected cpu agent to claim a job, got %v", err)
+	}
+	if job.ID != anyJob || job.Status != JobStatusRunning || job.Agent != "cpu-box" || job.Env["FOO"] != "bar" {
+		t.Errorf("Expected cpu agent to be assigned the unrestricted job, got %+v", job)
+	}
+	if _, err := pool.ClaimJob(cpu); !errors.Is(err, ErrNoJobAvailable) {
+		t.Errorf("Expected agent at capacity to get no job, got %v", err)
+	}
+
+	gpu := AgentInfo{Name: "gpu-box", Labels: []string{"linux", "gpu"}, Capacity: 2}
+	job, err = pool.ClaimJob(gpu)
+	if err != nil || job.ID != gpuJob {
+		t.Fatalf("Expected gpu agent to claim the gpu job, got %+v, %v", job, err)
+	}
+
+	stats := ci.QueueStats()
+	if len(stats.Workers) != 2 || stats.Workers[0].Name != "cpu-box" || stats.Workers[1].Name != "gpu-box" {
+		t.Fatalf("Expected both agents as workers, got %+v", stats.Workers)
+	}
+	if stats.Capacity != 3 || len(stats.Workers[1].Running) != 1 || len(stats.Workers[1].Labels) != 2 {
+		t.Errorf("Unexpected worker stats: %+v", stats)
+	}
+
+	// Agents can only report on their own jobs
+	if err := pool.ReportLogs("gpu-box", anyJob, []string{"hello"}); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected another agent's job not to be found, got %v", err)
+	}
+	if err := pool.ReportLogs("cpu-box", anyJob, []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.FinishJob("cpu-box", anyJob, JobResult{Status: JobStatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	detail := ci.JobDetail(anyJob)
+	if logs := ci.JobLogs(anyJob); detail.Status != JobStatusSuccess || logs[len(logs)-1] != "hello" {
+		t.Errorf("Expected job to succeed with reported logs, got %+v", detail)
+	}
+
+	// Cancelling finishes the job immediately, the agent finds out when it next reports
+	if err := ci.CancelJob(gpuJob); err != nil {
+		t.Fatal(err)
+	}
+	if status := ci.JobDetail(gpuJob).Status; status != JobStatusCancelled {
+		t.Errorf("Expected gpu job to be cancelled, got %s", status)
+	}
+	if err := pool.ReportLogs("gpu-box", gpuJob, nil); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Expected cancelled job to report finished, got %v", err)
+	}
+	if err := pool.FinishJob("gpu-box", gpuJob, JobResult{Status: JobStatusFailure}); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Expected finishing a cancelled job to fail, got %v", err)
+	}
+	if running := ci.QueueStats().Running; running != 0 {
+		t.Errorf("Expected no running jobs, got %d", running)
+	}
+}
+
+func TestCancelHoldsAgentSlot(t *testing.T) {
+	for _, acknowledged := range []bool{true, false} {
+		ci := NewCIServer(WithLocalAgent(false), WithAgentTimeout(time.Minute), WithJobLease(20*time.Second))
+		server := ci.(*CIServer)
+		agent := AgentInfo{Name: "box"}
+
+		first, _ := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build"})
+		second, _ := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "test"})
+		if job, err := server.ClaimJob(agent); err != nil || job.ID != first {
+			t.Fatalf("Expected the first job to be claimed, got %+v, %v", job, err)
+		}
+		if err := ci.CancelJob(first); err != nil {
+			t.Fatal(err)
+		}
+
+		// The agent may still be running the job until it finds out
+		if _, err := server.ClaimJob(agent); !errors.Is(err, ErrNoJobAvailable) {
+			t.Errorf("Expected the cancelled job to hold the agent's slot, got %v", err)
+		}
+		if acknowledged {
+			if err := server.ReportLogs("box", first, nil); !errors.Is(err, ErrJobFinished) {
+				t.Errorf("Expected the agent to be told the job finished, got %v", err)
+			}
+			if err := server.FinishJob("box", first, JobResult{Status: JobStatusCancelled}); !errors.Is(err, ErrJobFinished) {
+				t.Errorf("Expected finishing a cancelled job to report it finished, got %v", err)
+			}
+		} else {
+			// Agents that never acknowledge the cancellation lose the slot
+			// with the job's lease
+			server.expireAgents(time.Now().Add(30 * time.Second))
+		}
+		if status := ci.JobDetail(first).Status; status != JobStatusCancelled {
+			t.Errorf("Expected the first job to stay cancelled, got %s", status)
+		}
+		if job, err := server.ClaimJob(agent); err != nil || job.ID != second {
+			t.Errorf("Expected the slot to be freed, got %+v, %v", job, err)
+		}
+	}
+}
+
+func TestAgentNameInUse(t *testing.T) {
+	ci := NewCIServer()
+	local := ci.QueueStats().Workers[0].Name
+	if _, err := ci.(AgentPool).ClaimJob(AgentInfo{Name: local}); !errors.Is(err, ErrAgentNameInUse) {
+		t.Errorf("Expected local agent name to be rejected, got %v", err)
+	}
+}
+
+func TestLocalAgentLabels(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("ciserver_labels_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	executor := &recordingExecutor{specs: make(chan JobSpec, 2)}
+	ci := NewCIServer(WithExecutor(executor), WithAgentLabels("docker"))
+
+	gpuJob, _ := ci.Submit(JobSpec{RepoURI: barePath, Commit: "HEAD", Command: "train", RunsOn: []string{"gpu"}})
+	dockerJob, _ := ci.Submit(JobSpec{RepoURI: barePath, Commit: "HEAD", Command: "build", RunsOn: []string{runtime.GOOS, "docker"}})
+
+	select {
+	case spec := <-executor.specs:
+		if spec.Command != "build" {
+			t.Errorf("Expected the docker job to run, got %+v", spec)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for executor to run")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for ci.JobDetail(dockerJob).Status != JobStatusSuccess {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for docker job, status: %s", ci.JobDetail(dockerJob).Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// No agent can run the GPU job, so it waits
+	if status := ci.JobDetail(gpuJob).Status; status != JobStatusPending {
+		t.Errorf("Expected gpu job to be pending, got %s", status)
+	}
+	if queue := ci.QueueStats().Queue; len(queue) != 1 || queue[0].Job.ID != gpuJob {
+		t.Errorf("Expected gpu job to be queued, got %+v", queue)
+	}
+}
+
+func TestAgentTimeout(t *testing.T) {
+	for _, requeue := range []bool{false, true} {
+		ci := NewCIServer(WithLocalAgent(false), WithAgentTimeout(time.Minute), WithRequeueInterrupted(requeue))
+		server := ci.(*CIServer)
+
+		jobID, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build", RunsOn: []string{"linux"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.ClaimJob(AgentInfo{Name: "flaky", Labels: []string{"linux"}}); err != nil {
+			t.Fatal(err)
+		}
+		// An agent with nothing to run is removed too
+		if _, err := server.ClaimJob(AgentInfo{Name: "idle", Labels: []string{"linux"}}); !errors.Is(err, ErrNoJobAvailable) {
+			t.Fatalf("Expected no job for the idle agent, got %v", err)
+		}
+
+		// Agents that have been seen recently are kept
+		server.expireAgents(time.Now().Add(30 * time.Second))
+		if status := ci.JobDetail(jobID).Status; status != JobStatusRunning {
+			t.Fatalf("Expected job to still be running, got %s", status)
+		}
+
+		server.expireAgents(time.Now().Add(2 * time.Minute))
+		job := ci.JobDetail(jobID)
+		if job.Status != JobStatusInterrupted {
+			t.Errorf("Expected job to be interrupted, got %s", job.Status)
+		}
+		if workers := ci.QueueStats().Workers; len(workers) != 0 {
+			t.Errorf("Expected agents to be removed, got %+v", workers)
+		}
+		server.jobMutex.RLock()
+		remaining := len(server.agents)
+		server.jobMutex.RUnlock()
+		if remaining != 0 {
+			t.Errorf("Expected both agents to be removed, %d remain", remaining)
+		}
+		// The agent is told to stop if it comes back
+		if err := server.ReportLogs("flaky", jobID, []string{"still here"}); !errors.Is(err, ErrJobFinished) {
+			t.Errorf("Expected interrupted job to report finished, got %v", err)
+		}
+
+		queue := ci.QueueStats().Queue
+		if !requeue {
+			if len(queue) != 0 {
+				t.Errorf("Expected nothing to be requeued, got %+v", queue)
+			}
+			continue
+		}
+		if len(queue) != 1 {
+			t.Fatalf("Expected interrupted job to be requeued, got %+v", queue)
+		}
+		requeued := queue[0].Job
+		if requeued.RequeuedFrom != jobID || requeued.Command != "build" || len(requeued.RunsOn) != 1 {
+			t.Errorf("Expected a copy of the interrupted job, got %+v", requeued)
+		}
+		logs := ci.JobLogs(jobID)
+		if logs[len(logs)-1] != "Requeued as job "+string(requeued.ID) {
+			t.Errorf("Expected requeue to be logged, got %v", logs)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package minici
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Severity is how serious a problem found in a job's logs is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// maxAnnotations limits how many problems are recorded for a job, so a
+// build spewing errors can't grow it without bound
+const maxAnnotations = 200
+
+// maxAnnotationMessage limits the length of an annotation's message
+const maxAnnotationMessage = 500
+
+// Annotation marks a line in a job's logs that looks like a problem
+type Annotation struct {
+	// Line is the index of the line in the job's logs
+	Line     int
+	Severity Severity
+	Message  string
+}
+
+// AnnotationPattern classifies log lines matching a regular expression.
+// Patterns are matched against command output without its "> " prefix.
+type AnnotationPattern struct {
+	Severity Severity
+	Pattern  *regexp.Regexp
+}
+
+// DefaultAnnotationPatterns recognize common compiler errors, panics and test
+// failures. The first pattern to match a line decides its severity.
+var DefaultAnnotationPatterns = []AnnotationPattern{
+	// gcc, clang and similar, e.g. "main.c:10:5: warning: unused variable"
+	{SeverityWarning, regexp.MustCompile(`^\S+:\d+(:\d+)?: warning: `)},
+	// Go, gcc and most compilers, e.g. "main.go:12:5: undefined: x"
+	{SeverityError, regexp.MustCompile(`^\S+\.\w+:\d+(:\d+)?: `)},
+	// TypeScript, e.g. "src/app.ts(3,7): error TS2322: ..."
+	{SeverityError, regexp.MustCompile(`\berror TS\d+: `)},
+	// Rust, npm, make and others, e.g. "error[E0425]: cannot find value"
+	{SeverityError, regexp.MustCompile(`(?i)^(error|fatal)(\[\w+\])?:`)},
+	{SeverityError, regexp.MustCompile(`^npm ERR! `)},
+	{SeverityError, regexp.MustCompile(`^make(\[\d+\])?: \*\*\* `)},
+	{SeverityError, regexp.MustCompile(`^(panic|fatal error): `)},
+	{SeverityError, regexp.MustCompile(`^Traceback \(most recent call last\):`)},
+	// go test
+	{SeverityError, regexp.MustCompile(`^\s*--- FAIL: `)},
+	{SeverityError, regexp.MustCompile(`^FAIL(\s|$)`)},
+	// minici's own failures, such as a clone that failed
+	{SeverityError, regexp.MustCompile(`^Failed to `)},
+	{SeverityWarning, regexp.MustCompile(`(?i)^warn(ing)?(\[\w+\])?:`)},
+	{SeverityWarning, regexp.MustCompile(`^npm WARN `)},
+}
+
+// ParseAnnotationPattern parses a pattern written as severity=regexp, such as
+// "error=^ERROR ". Lines matching it are recorded with that severity.
+func ParseAnnotationPattern(value string) (AnnotationPattern, error) {
+	severity, expr, ok := strings.Cut(value, "=")
+	if !ok {
+		return AnnotationPattern{}, fmt.Errorf("invalid annotation pattern %q, expected severity=regexp", value)
+	}
+	switch Severity(severity) {
+	case SeverityError, SeverityWarning:
+	default:
+		return AnnotationPattern{}, fmt.Errorf("invalid annotation severity %q, expected error or warning", severity)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return AnnotationPattern{}, fmt.Errorf("invalid annotation pattern %q: %w", expr, err)
+	}
+	return AnnotationPattern{Severity: Severity(severity), Pattern: re}, nil
+}
+
+// WithAnnotationPatterns adds patterns for problems in job logs. They're
+// checked before the defaults, so they can also reclassify lines the defaults
+// would match.
+func WithAnnotationPatterns(patterns ...AnnotationPattern) Option {
+	return func(s *CIServer) {
+		s.annotationPatterns = append(s.annotationPatterns, patterns...)
+	}
+}
+
+// Annotate classifies each of the lines, returning an annotation for those
+// that look like problems
+func Annotate(lines []string, patterns []AnnotationPattern) []Annotation {
+	var annotations []Annotation
+	for i, line := range lines {
+		if annotation, ok := annotateLine(i, line, patterns); ok {
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations
+}
+
+func annotateLine(index int, line string, patterns []AnnotationPattern) (Annotation, bool) {
+	text := strings.TrimPrefix(line, "> ")
+	for _, pattern := range patterns {
+		if pattern.Pattern.MatchString(text) {
+			if len(text) > maxAnnotationMessage {
+				text = text[:maxAnnotationMessage]
+			}
+			return Annotation{Line: index, Severity: pattern.Severity, Message: strings.TrimSpace(text)}, true
+		}
+	}
+	return Annotation{}, false
+}
+
+// annotate records a problem if the job's newest log line, at index, looks
+// like one. The caller must hold jobMutex.
+func (s *CIServer) annotate(job *Job, index int, line string) {
+	if len(job.Annotations) >= maxAnnotations {
+		return
+	}
+	if annotation, ok := annotateLine(index, line, s.annotationPatterns); ok {
+		job.Annotations = append(job.Annotations, annotation)
+	}
+}
@@ -0,0 +1,83 @@
+package minici
+
+import (
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	lines := []string{
+		"Cloning repository: https://github.com/ocuroot/minici",
+		"> # github.com/ocuroot/minici",
+		"> ./ciserver.go:12:5: undefined: foo",
+		"> main.c:10:5: warning: unused variable 'x'",
+		"> error[E0425]: cannot find value `y` in this scope",
+		"> panic: runtime error: index out of range",
+		"> --- FAIL: TestWait (1.00s)",
+		">     ciserver_test.go:40: expected success",
+		"> FAIL\tgithub.com/ocuroot/minici\t1.2s",
+		"> npm WARN deprecated inflight@1.0.6",
+		"> npm ERR! code ELIFECYCLE",
+		"> ok  \tgithub.com/ocuroot/minici/notify\t0.004s",
+		"Failed to clone repository: authentication required",
+	}
+	expected := map[int]Severity{
+		2:  SeverityError,
+		3:  SeverityWarning,
+		4:  SeverityError,
+		5:  SeverityError,
+		6:  SeverityError,
+		8:  SeverityError,
+		9:  SeverityWarning,
+		10: SeverityError,
+		12: SeverityError,
+	}
+
+	annotations := Annotate(lines, DefaultAnnotationPatterns)
+	found := map[int]Severity{}
+	for _, annotation := range annotations {
+		found[annotation.Line] = annotation.Severity
+	}
+	for line, severity := range expected {
+		if found[line] != severity {
+			t.Errorf("Expected line %d %q to be a %s, got %q", line, lines[line], severity, found[line])
+		}
+	}
+	for line := range found {
+		if _, ok := expected[line]; !ok {
+			t.Errorf("Expected line %d %q not to be annotated", line, lines[line])
+		}
+	}
+	if annotations[0].Message != "./ciserver.go:12:5: undefined: foo" {
+		t.Errorf("Expected the message without the output prefix, got %q", annotations[0].Message)
+	}
+}
+
+func TestAnnotationPatterns(t *testing.T) {
+	for _, value := range []string{"error", "fatal=^x", "error=("} {
+		if _, err := ParseAnnotationPattern(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+	pattern, err := ParseAnnotationPattern("warning=^FAIL.*flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Configured patterns take precedence over the defaults
+	s := NewCIServer(WithLocalAgent(false), WithAnnotationPatterns(pattern)).(*CIServer)
+	job := &Job{ID: "01ABC"}
+	s.jobs[job.ID] = job
+	s.appendLog(job, "> FAIL\texample.com/flaky")
+	s.appendLog(job, "> FAIL\texample.com/broken")
+	s.appendLog(job, "> ok")
+	if len(job.Annotations) != 2 || job.Annotations[0].Severity != SeverityWarning || job.Annotations[1].Severity != SeverityError {
+		t.Errorf("Unexpected annotations: %+v", job.Annotations)
+	}
+
+	for i := 0; i < maxAnnotations+10; i++ {
+		s.appendLog(job, "> FAIL")
+	}
+	if len(job.Annotations) != maxAnnotations {
+		t.Errorf("Expected annotations to be limited to %d, got %d", maxAnnotations, len(job.Annotations))
+	}
+}
@@ -0,0 +1,387 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrArtifactNotFound is returned when a job has no artifact with the requested name
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// Artifact describes a file saved from a job's workspace
+type Artifact struct {
+	// Name is the file's path relative to the repository root, using forward slashes
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ArtifactJob summarizes the artifacts saved for a job
+type ArtifactJob struct {
+	JobID JobID
+	// Repo is the repository the job ran for, if it was recorded
+	Repo  string
+	Count int
+	// Size is the total size of the job's artifacts in bytes
+	Size int64
+	// ModTime is when the job's most recent artifact was saved
+	ModTime time.Time
+}
+
+// ArtifactReader reads an artifact's content. It can seek, so downloads can
+// be resumed part way through.
+type ArtifactReader interface {
+	io.ReadSeekCloser
+	Artifact() Artifact
+}
+
+// ArtifactStore keeps the files that jobs produce after their workspaces have
+// been removed
+type ArtifactStore interface {
+	// Save stores size bytes of content as a job's artifact, replacing any
+	// existing artifact with the same name
+	Save(ctx context.Context, jobID JobID, name string, content io.Reader, size int64) error
+	// SetRepo records the repository a job ran for, so retention can be
+	// applied per repo
+	SetRepo(ctx context.Context, jobID JobID, repo string) error
+	// List returns a job's artifacts, sorted by name
+	List(ctx context.Context, jobID JobID) ([]Artifact, error)
+	// Jobs summarizes every job that has artifacts, sorted by job ID so the
+	// oldest jobs are first
+	Jobs(ctx context.Context) ([]ArtifactJob, error)
+	// Open returns a reader for a job's artifact, or ErrArtifactNotFound
+	Open(ctx context.Context, jobID JobID, name string) (ArtifactReader, error)
+	// Delete removes all of a job's artifacts
+	Delete(ctx context.Context, jobID JobID) error
+}
+
+// WithArtifactStore saves the files matching a repo's artifact patterns to
+// store after each job's command has run
+func WithArtifactStore(store ArtifactStore) Option {
+	return func(s *CIServer) {
+		s.artifacts = store
+	}
+}
+
+// validArtifactName returns an error unless name is a relative slash-separated
+// path that stays within the job's artifacts
+func validArtifactName(name string) error {
+	if name == "" || !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid artifact name %q", name)
+	}
+	return nil
+}
+
+// collectArtifacts saves the files in dir matching patterns to store. Patterns
+// use filepath.Match syntax relative to dir, and matching a directory saves
+// every file inside it. Symlinks aren't followed, so a job can't save files
+// from outside its workspace.
+func collectArtifacts(ctx context.Context, store ArtifactStore, job Job, dir string, patterns []string, log func(string)) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, pattern := range patterns {
+		if !filepath.IsLocal(filepath.FromSlash(pattern)) {
+			return fmt.Errorf("invalid artifact pattern %q: must be relative to the repository root", pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			log("No artifacts matched " + pattern)
+		}
+		for _, match := range matches {
+			// A symlinked parent directory could point outside the workspace
+			resolved, err := filepath.EvalSymlinks(filepath.Dir(match))
+			if err != nil {
+				return err
+			}
+			if relative, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(relative) {
+				continue
+			}
+			err = filepath.WalkDir(match, func(file string, entry fs.DirEntry, err error) error {
+				if err != nil || !entry.Type().IsRegular() {
+					return err
+				}
+				name, err := filepath.Rel(dir, file)
+				if err != nil {
+					return err
+				}
+				names[filepath.ToSlash(name)] = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	if len(sorted) == 0 {
+		return nil
+	}
+	if err := store.SetRepo(ctx, job.ID, job.RepoURI); err != nil {
+		return fmt.Errorf("failed to save artifacts: %w", err)
+	}
+	for _, name := range sorted {
+		size, err := saveArtifact(ctx, store, job.ID, filepath.Join(dir, filepath.FromSlash(name)), name)
+		if err != nil {
+			return fmt.Errorf("failed to save artifact %s: %w", name, err)
+		}
+		log(fmt.Sprintf("Saved artifact %s (%d bytes)", name, size))
+	}
+	return nil
+}
+
+// restoreArtifacts copies the artifacts a job declared from the jobs it needs
+// into dir, at the same paths they were saved from. It returns an error if a
+// pattern matches none of the needed job's artifacts.
+func restoreArtifacts(ctx context.Context, store ArtifactStore, dir string, needs []JobNeed, log func(string)) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, need := range needs {
+		if len(need.Artifacts) == 0 {
+			continue
+		}
+		artifacts, err := store.List(ctx, need.Job)
+		if err != nil {
+			return fmt.Errorf("failed to list artifacts of job %s: %w", need.Job, err)
+		}
+		for _, pattern := range need.Artifacts {
+			matched := false
+			for _, artifact := range artifacts {
+				if ok, _ := path.Match(pattern, artifact.Name); !ok && !strings.HasPrefix(artifact.Name, pattern+"/") {
+					continue
+				}
+				matched = true
+				if err := restoreArtifact(ctx, store, need.Job, root, artifact.Name); err != nil {
+					return fmt.Errorf("failed to restore artifact %s of job %s: %w", artifact.Name, need.Job, err)
+				}
+				log(fmt.Sprintf("Restored artifact %s from job %s", artifact.Name, need.Job))
+			}
+			if !matched {
+				return fmt.Errorf("no artifacts of job %s matched %s", need.Job, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func restoreArtifact(ctx context.Context, store ArtifactStore, jobID JobID, root, name string) error {
+	reader, err := store.Open(ctx, jobID, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	file := filepath.Join(root, filepath.FromSlash(name))
+	// The repository could contain a symlink where the artifact's directory should be
+	if err := checkWithin(root, filepath.Dir(file)); err != nil {
+		return err
+	}
+	return extractFile(reader, file, 0o644)
+}
+
+func saveArtifact(ctx context.Context, store ArtifactStore, jobID JobID, file, name string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), store.Save(ctx, jobID, name, f, info.Size())
+}
+
+// FileArtifactStore keeps artifacts in a directory on the local filesystem.
+// Each job has a subdirectory, holding its artifacts in "files" and the
+// repository it ran for in "repo".
+type FileArtifactStore struct {
+	Dir string
+}
+
+func (s *FileArtifactStore) path(jobID JobID, name string) (string, error) {
+	if err := validArtifactName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.filesDir(jobID), filepath.FromSlash(name)), nil
+}
+
+func (s *FileArtifactStore) jobDir(jobID JobID) string {
+	return filepath.Join(s.Dir, filepath.Base(string(jobID)))
+}
+
+func (s *FileArtifactStore) filesDir(jobID JobID) string {
+	return filepath.Join(s.jobDir(jobID), "files")
+}
+
+func (s *FileArtifactStore) SetRepo(ctx context.Context, jobID JobID, repo string) error {
+	if err := os.MkdirAll(s.jobDir(jobID), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.jobDir(jobID), "repo"), []byte(repo), 0o644)
+}
+
+func (s *FileArtifactStore) Save(ctx context.Context, jobID JobID, name string, content io.Reader, size int64) error {
+	file, err := s.path(jobID, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial artifact
+	temp, err := os.CreateTemp(filepath.Dir(file), ".artifact-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	written, err := io.Copy(temp, content)
+	if err != nil {
+		temp.Close()
+		return err
+	}
+	if written != size {
+		temp.Close()
+		return fmt.Errorf("expected %d bytes, read %d", size, written)
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+func (s *FileArtifactStore) List(ctx context.Context, jobID JobID) ([]Artifact, error) {
+	dir := s.filesDir(jobID)
+	var artifacts []Artifact
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file == dir {
+			return fs.SkipAll
+		}
+		if err != nil || !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".artifact-") {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, Artifact{
+			Name:    filepath.ToSlash(name),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Name < artifacts[j].Name
+	})
+	return artifacts, nil
+}
+
+func (s *FileArtifactStore) Jobs(ctx context.Context) ([]ArtifactJob, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadDir sorts by name, so jobs are in ID order
+	var jobs []ArtifactJob
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		jobID := JobID(entry.Name())
+		artifacts, err := s.List(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := os.ReadFile(filepath.Join(s.jobDir(jobID), "repo"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		jobs = append(jobs, summarizeArtifacts(jobID, string(repo), artifacts))
+	}
+	return jobs, nil
+}
+
+// summarizeArtifacts totals a job's artifacts
+func summarizeArtifacts(jobID JobID, repo string, artifacts []Artifact) ArtifactJob {
+	job := ArtifactJob{JobID: jobID, Repo: repo, Count: len(artifacts)}
+	for _, artifact := range artifacts {
+		job.Size += artifact.Size
+		if artifact.ModTime.After(job.ModTime) {
+			job.ModTime = artifact.ModTime
+		}
+	}
+	return job
+}
+
+func (s *FileArtifactStore) Open(ctx context.Context, jobID JobID, name string) (ArtifactReader, error) {
+	file, err := s.path(jobID, name)
+	if err != nil {
+		return nil, ErrArtifactNotFound
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrArtifactNotFound
+	}
+	return &fileArtifact{
+		File:     f,
+		artifact: Artifact{Name: path.Clean(name), Size: info.Size(), ModTime: info.ModTime()},
+	}, nil
+}
+
+func (s *FileArtifactStore) Delete(ctx context.Context, jobID JobID) error {
+	return os.RemoveAll(s.jobDir(jobID))
+}
+
+type fileArtifact struct {
+	*os.File
+	artifact Artifact
+}
+
+func (f *fileArtifact) Artifact() Artifact {
+	return f.artifact
+}
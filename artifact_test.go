@@ -0,0 +1,165 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArtifactStore saves, lists, reads and deletes artifacts in store
+func testArtifactStore(t *testing.T, store ArtifactStore) {
+	ctx := context.Background()
+	for name, content := range map[string]string{
+		"dist/app":     "binary",
+		"coverage.out": "mode: set",
+		"dist/a b+c":   "spaces",
+	} {
+		if err := store.Save(ctx, "01JOB", name, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Failed to save %s: %v", name, err)
+		}
+	}
+	if err := store.Save(ctx, "01OTHER", "other", strings.NewReader("x"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRepo(ctx, "01JOB", "https://example.com/repo.git"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, "01JOB", "../escape", strings.NewReader("x"), 1); err == nil {
+		t.Error("Expected names outside the job to be rejected")
+	}
+
+	artifacts, err := store.List(ctx, "01JOB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, artifact := range artifacts {
+		names = append(names, artifact.Name)
+	}
+	if strings.Join(names, ",") != "coverage.out,dist/a b+c,dist/app" {
+		t.Errorf("Unexpected artifacts %v", names)
+	}
+	if artifacts[2].Size != 6 {
+		t.Errorf("Expected size 6, got %d", artifacts[2].Size)
+	}
+
+	jobs, err := store.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].JobID != "01JOB" || jobs[1].JobID != "01OTHER" {
+		t.Fatalf("Expected both jobs in ID order, got %+v", jobs)
+	}
+	if jobs[0].Repo != "https://example.com/repo.git" || jobs[0].Count != 3 || jobs[0].Size != 21 || jobs[0].ModTime.IsZero() {
+		t.Errorf("Unexpected summary %+v", jobs[0])
+	}
+	if jobs[1].Repo != "" || jobs[1].Size != 1 {
+		t.Errorf("Unexpected summary %+v", jobs[1])
+	}
+
+	reader, err := store.Open(ctx, "01JOB", "dist/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Artifact().Size != 6 {
+		t.Errorf("Expected size 6, got %+v", reader.Artifact())
+	}
+	if _, err := reader.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "ary" {
+		t.Errorf("Expected content from offset 3, got %q", content)
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = io.ReadAll(reader)
+	if string(content) != "binary" {
+		t.Errorf("Expected full content after seeking back, got %q", content)
+	}
+	reader.Close()
+
+	if _, err := store.Open(ctx, "01JOB", "missing"); !errors.Is(err, ErrArtifactNotFound) {
+		t.Errorf("Expected ErrArtifactNotFound, got %v", err)
+	}
+
+	if err := store.Delete(ctx, "01JOB"); err != nil {
+		t.Fatal(err)
+	}
+	if artifacts, err := store.List(ctx, "01JOB"); err != nil || len(artifacts) != 0 {
+		t.Errorf("Expected no artifacts after deleting, got %v, %v", artifacts, err)
+	}
+	if artifacts, _ := store.List(ctx, "01OTHER"); len(artifacts) != 1 {
+		t.Errorf("Expected other jobs' artifacts to be kept, got %v", artifacts)
+	}
+	if jobs, _ := store.Jobs(ctx); len(jobs) != 1 {
+		t.Errorf("Expected the deleted job to be removed from the summary, got %+v", jobs)
+	}
+}
+
+func TestFileArtifactStore(t *testing.T) {
+	testArtifactStore(t, &FileArtifactStore{Dir: t.TempDir()})
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"dist/app", "dist/docs/index.html", "coverage.out", "main.go"} {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "dist", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Dir(secret), filepath.Join(dir, "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &FileArtifactStore{Dir: t.TempDir()}
+	job := Job{ID: "01JOB", RepoURI: "https://example.com/repo.git"}
+	var lines []string
+	err := collectArtifacts(context.Background(), store, job, dir, []string{"dist", "*.out", "dist/app", "*.zip", "outside/*"}, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts, err := store.List(context.Background(), "01JOB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, artifact := range artifacts {
+		names = append(names, artifact.Name)
+	}
+	if strings.Join(names, ",") != "coverage.out,dist/app,dist/docs/index.html" {
+		t.Errorf("Unexpected artifacts %v", names)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "No artifacts matched *.zip") {
+		t.Errorf("Expected unmatched patterns to be logged, got %v", lines)
+	}
+
+	if err := collectArtifacts(context.Background(), store, job, dir, []string{"["}, func(string) {}); err == nil {
+		t.Error("Expected an invalid pattern to return an error")
+	}
+	if err := collectArtifacts(context.Background(), store, job, dir, []string{"../*"}, func(string) {}); err == nil {
+		t.Error("Expected a pattern outside the workspace to return an error")
+	}
+}
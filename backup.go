@@ -0,0 +1,454 @@
+package minici
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBackupInterval is how often backups are taken when Backups
+	// doesn't say
+	DefaultBackupInterval = time.Hour
+	// DefaultBackupKeep is how many backups are kept when Backups doesn't say
+	DefaultBackupKeep = 24
+)
+
+// backupPrefix and backupSuffix surround the time in each backup's name, so
+// names sort in the order backups were taken
+const (
+	backupPrefix = "minici-backup-"
+	backupSuffix = ".jsonl.gz"
+)
+
+// ErrBackupNotFound is returned when opening a backup that doesn't exist
+var ErrBackupNotFound = errors.New("backup not found")
+
+// BackupStore keeps backups by name
+type BackupStore interface {
+	Save(ctx context.Context, name string, content io.Reader, size int64) error
+	// List returns the names of the backups in the store, in name order
+	List(ctx context.Context) ([]string, error)
+	// Open returns ErrBackupNotFound if the backup doesn't exist
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Backups periodically saves every job and its logs to a BackupStore, keeping
+// the most recent backups
+type Backups struct {
+	Store BackupStore
+	// Interval defaults to DefaultBackupInterval
+	Interval time.Duration
+	// Keep is how many backups to keep, defaults to DefaultBackupKeep
+	Keep int
+	// Artifacts includes a list of each job's artifacts, though not their
+	// contents, which stay in the artifact store
+	Artifacts bool
+	// OnError is called with errors taking or rotating backups, if set
+	OnError func(error)
+}
+
+// WithBackups takes backups of the server's jobs in the background
+func WithBackups(backups Backups) Option {
+	return func(s *CIServer) {
+		if backups.Interval <= 0 {
+			backups.Interval = DefaultBackupInterval
+		}
+		if backups.Keep <= 0 {
+			backups.Keep = DefaultBackupKeep
+		}
+		s.backups = &backups
+	}
+}
+
+// Restorer is implemented by CI servers that can restore backups
+type Restorer interface {
+	Restore(jobs []BackupJob) error
+}
+
+// BackupJob is a job in a backup, with its logs
+type BackupJob struct {
+	Job  Job
+	Logs []string
+	// Artifacts were saved by the job, if the backup lists them
+	Artifacts []Artifact `json:",omitempty"`
+}
+
+// backupHeader is the first line of a backup
+type backupHeader struct {
+	Version   int
+	CreatedAt time.Time
+}
+
+// BackupName names a backup taken at a time
+func BackupName(taken time.Time) string {
+	return backupPrefix + taken.UTC().Format("20060102T150405Z") + backupSuffix
+}
+
+// isBackupName returns true for names given by BackupName
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix)
+}
+
+// LatestBackup returns the name of the most recent backup in a store
+func LatestBackup(ctx context.Context, store BackupStore) (string, error) {
+	names, err := store.List(ctx)
+	if err != nil {
+		return "", err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		if isBackupName(names[i]) {
+			return names[i], nil
+		}
+	}
+	return "", ErrBackupNotFound
+}
+
+// WriteBackup writes every job and its logs to w as gzipped lines of JSON,
+// and the list of each job's artifacts if artifacts is set
+func (s *CIServer) WriteBackup(ctx context.Context, w io.Writer, artifacts bool) error {
+	s.jobMutex.RLock()
+	ids := make([]JobID, 0, len(s.jobs))
+	for id := range s.jobs {
+		ids = append(ids, id)
+	}
+	s.jobMutex.RUnlock()
+	// IDs are ULIDs, so jobs are written in the order they were created
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	zw := gzip.NewWriter(w)
+	encoder := json.NewEncoder(zw)
+	if err := encoder.Encode(backupHeader{Version: 1, CreatedAt: s.clock.Now()}); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.jobMutex.RLock()
+		record := BackupJob{Job: *s.jobs[id], Logs: s.logs.lines(id, 0)}
+		s.jobMutex.RUnlock()
+		if artifacts && s.artifacts != nil {
+			list, err := s.artifacts.List(ctx, id)
+			if err != nil && !errors.Is(err, ErrArtifactNotFound) {
+				return fmt.Errorf("failed to list artifacts of job %s: %w", id, err)
+			}
+			record.Artifacts = list
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ReadBackup reads the jobs in a backup written by WriteBackup
+func ReadBackup(r io.Reader) ([]BackupJob, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	decoder := json.NewDecoder(bufio.NewReader(zr))
+	var header backupHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	if header.Version != 1 {
+		return nil, fmt.Errorf("unsupported backup version %d", header.Version)
+	}
+	var jobs []BackupJob
+	for {
+		var record BackupJob
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup: %w", err)
+		}
+		jobs = append(jobs, record)
+	}
+}
+
+// Restore adds the jobs in a backup to the server, skipping any it already
+// has. Pending jobs are queued again, and jobs that were running when the
+// backup was taken are interrupted, then requeued if WithRequeueInterrupted is
+// set. Clustered servers share their jobs through the cluster store, so
+// backups are restored into the store instead.
+func (s *CIServer) Restore(jobs []BackupJob) error {
+	if s.cluster != nil {
+		return errors.New("clustered servers can't restore backups, restore them into the cluster store instead")
+	}
+	now := s.clock.Now()
+	type interruption struct {
+		job   *Job
+		event JobEvent
+	}
+	var interrupted []interruption
+	s.jobMutex.Lock()
+	for _, record := range jobs {
+		if _, ok := s.jobs[record.Job.ID]; ok {
+			continue
+		}
+		job := &Job{}
+		*job = record.Job
+		id := job.ID
+		s.jobs[id] = job
+		s.buildNumbers[job.RepoURI] = max(s.buildNumbers[job.RepoURI], job.Number)
+		// Annotations and sections come with the job, so lines are only indexed
+		for _, line := range record.Logs {
+			index := s.logs.append(id, line)
+			s.logIndex.add(id, index, line)
+		}
+
+		switch {
+		case job.Status == JobStatusPending:
+			// Jobs dispatched through an external queue are still in it
+			ctx, cancel := context.WithCancel(context.Background())
+			s.cancels[id] = cancel
+			if s.external == nil {
+				s.waiting = append(s.waiting, queuedJob{job: job, ctx: ctx})
+			}
+		case !job.Status.Done():
+			interrupted = append(interrupted, interruption{job: job, event: s.transition(job, JobStatusInterrupted)})
+		default:
+			if job.Status == JobStatusSuccess || job.Status == JobStatusFailure {
+				s.repoStats.add(*job)
+			}
+			s.logs.done(id)
+		}
+	}
+	s.jobsUpdated()
+	s.jobMutex.Unlock()
+
+	for _, i := range interrupted {
+		s.appendLog(i.job, "Server was restored from a backup, job interrupted")
+		s.emit(i.event)
+		s.requeue(i.job, i.event.Job, now)
+	}
+	s.dispatch()
+	return nil
+}
+
+// takeBackups saves a backup every interval
+func (s *CIServer) takeBackups() {
+	for {
+		<-s.clock.After(s.backups.Interval)
+		ctx, cancel := context.WithTimeout(context.Background(), s.backups.Interval)
+		if _, err := s.Backup(ctx); err != nil && s.backups.OnError != nil {
+			s.backups.OnError(err)
+		}
+		cancel()
+	}
+}
+
+// Backup saves a backup to the store given to WithBackups now, then removes
+// the oldest backups beyond those to keep. It returns the backup's name.
+func (s *CIServer) Backup(ctx context.Context) (string, error) {
+	if s.backups == nil {
+		return "", errors.New("backups aren't enabled")
+	}
+	// The backup is written to a file first, as stores need to know its size
+	temp, err := os.CreateTemp("", backupPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	if err := s.WriteBackup(ctx, temp, s.backups.Artifacts); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	size, err := temp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	name := BackupName(s.clock.Now())
+	if err := s.backups.Store.Save(ctx, name, temp, size); err != nil {
+		return "", fmt.Errorf("failed to save backup %s: %w", name, err)
+	}
+
+	names, err := s.backups.Store.List(ctx)
+	if err != nil {
+		return name, fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []string
+	for _, existing := range names {
+		if isBackupName(existing) {
+			backups = append(backups, existing)
+		}
+	}
+	for _, old := range backups[:max(len(backups)-s.backups.Keep, 0)] {
+		if err := s.backups.Store.Delete(ctx, old); err != nil {
+			return name, fmt.Errorf("failed to remove backup %s: %w", old, err)
+		}
+	}
+	return name, nil
+}
+
+// DirBackupStore keeps backups as files in a directory
+type DirBackupStore struct {
+	Dir string
+}
+
+var _ BackupStore = &DirBackupStore{}
+
+func (d *DirBackupStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+	return filepath.Join(d.Dir, name), nil
+}
+
+func (d *DirBackupStore) Save(ctx context.Context, name string, content io.Reader, size int64) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return err
+	}
+	// Write a copy and rename it into place, so a backup is never half written
+	temp, err := os.CreateTemp(d.Dir, ".backup-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = io.Copy(temp, content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+func (d *DirBackupStore) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d *DirBackupStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, ErrBackupNotFound
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBackupNotFound
+	}
+	return file, err
+}
+
+func (d *DirBackupStore) Delete(ctx context.Context, name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// S3BackupStore keeps backups in an S3 bucket, named after the backup with the
+// store's prefix. Bucket holds the connection settings, and its artifact
+// methods aren't used.
+type S3BackupStore struct {
+	Bucket *S3ArtifactStore
+}
+
+var _ BackupStore = &S3BackupStore{}
+
+func (s *S3BackupStore) Save(ctx context.Context, name string, content io.Reader, size int64) error {
+	// Hide other methods, such as Seek, so the body is streamed as is
+	body := struct{ io.Reader }{content}
+	resp, err := s.Bucket.do(ctx, http.MethodPut, s.Bucket.Prefix+name, nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3BackupStore) List(ctx context.Context) ([]string, error) {
+	objects, err := s.Bucket.listObjects(ctx, s.Bucket.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, object := range objects {
+		// Objects in "directories" below the prefix aren't backups
+		if name := strings.TrimPrefix(object.Key, s.Bucket.Prefix); !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *S3BackupStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.Bucket.do(ctx, http.MethodGet, s.Bucket.Prefix+name, nil, nil, nil, 0)
+	if errors.Is(err, ErrArtifactNotFound) {
+		return nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3BackupStore) Delete(ctx context.Context, name string) error {
+	resp, err := s.Bucket.do(ctx, http.MethodDelete, s.Bucket.Prefix+name, nil, nil, nil, 0)
+	if errors.Is(err, ErrArtifactNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RestoreClusterStore saves the jobs in a backup and their logs to a cluster
+// store, copying only the log lines the store is missing for jobs it already
+// has. Servers using the store mirror the jobs on their next sync, and
+// interrupt the ones that were running, as their servers have no heartbeat.
+func RestoreClusterStore(ctx context.Context, store ClusterStore, jobs []BackupJob) (MigrationStats, error) {
+	var stats MigrationStats
+	for _, record := range jobs {
+		copied, err := migrateLogs(ctx, store, record.Job.ID, record.Logs)
+		if err != nil {
+			return stats, err
+		}
+		stats.Lines += copied
+		if err := store.SaveJob(ctx, record.Job); err != nil {
+			return stats, fmt.Errorf("failed to save job %s: %w", record.Job.ID, err)
+		}
+		stats.Jobs++
+	}
+	return stats, nil
+}
@@ -0,0 +1,186 @@
+package minici
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stepClock moves forward a minute each time it's read
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(time.Minute)
+	return c.now
+}
+
+func (c *stepClock) After(d time.Duration) <-chan time.Time {
+	return nil
+}
+
+func backupServer(opts ...Option) *CIServer {
+	opts = append([]Option{
+		WithGitClient(&fakeGit{}),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	}, opts...)
+	return NewCIServer(opts...).(*CIServer)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	s := backupServer()
+	done := s.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	if job := s.JobDetail(done); job.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %s", job.Status)
+	}
+
+	var backup bytes.Buffer
+	if err := s.WriteBackup(ctx, &backup, false); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := ReadBackup(&backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Job.ID != done || !reflect.DeepEqual(jobs[0].Logs, s.JobLogs(done)) {
+		t.Fatalf("Unexpected jobs in backup %+v", jobs)
+	}
+	// A job was running when the backup was taken
+	running := NewJobID()
+	jobs = append(jobs, BackupJob{
+		Job:  Job{ID: running, Status: JobStatusRunning, Number: 2, RepoURI: "https://example.com/repo.git", Commit: "main", Command: "sleep 10"},
+		Logs: []string{"sleeping"},
+	})
+
+	restored := backupServer()
+	if err := restored.Restore(jobs); err != nil {
+		t.Fatal(err)
+	}
+	if job := restored.JobDetail(done); job.Status != JobStatusSuccess || job.Number != 1 {
+		t.Errorf("Expected the finished job to be restored, got %+v", job)
+	}
+	if logs := restored.JobLogs(done); !reflect.DeepEqual(logs, s.JobLogs(done)) {
+		t.Errorf("Expected the job's logs to be restored, got %q", logs)
+	}
+	if job := restored.JobDetail(running); job.Status != JobStatusInterrupted {
+		t.Errorf("Expected the running job to be interrupted, got %s", job.Status)
+	}
+	if logs := restored.JobLogs(running); len(logs) != 2 || !strings.Contains(logs[1], "restored from a backup") {
+		t.Errorf("Expected the interruption to be logged, got %q", logs)
+	}
+	next := restored.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	if job := restored.JobDetail(next); job.Number != 3 {
+		t.Errorf("Expected build numbers to follow the restored jobs, got %d", job.Number)
+	}
+
+	// Restoring again leaves the jobs as they are
+	if err := restored.Restore(jobs); err != nil {
+		t.Fatal(err)
+	}
+	if logs := restored.JobLogs(running); len(logs) != 2 {
+		t.Errorf("Expected restored jobs to be skipped, got %q", logs)
+	}
+}
+
+func TestBackupRotation(t *testing.T) {
+	ctx := context.Background()
+	store := &DirBackupStore{Dir: t.TempDir()}
+	s := backupServer(WithClock(&stepClock{now: time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)}),
+		WithBackups(Backups{Store: store, Keep: 2}))
+	s.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+
+	var taken []string
+	for range 3 {
+		name, err := s.Backup(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taken = append(taken, name)
+	}
+	names, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, taken[1:]) {
+		t.Errorf("Expected the oldest backup to be removed, got %v from %v", names, taken)
+	}
+	if latest, err := LatestBackup(ctx, store); err != nil || latest != taken[2] {
+		t.Errorf("Expected the latest backup to be %s, got %s, %v", taken[2], latest, err)
+	}
+}
+
+func testBackupStore(t *testing.T, store BackupStore) {
+	ctx := context.Background()
+	for _, name := range []string{BackupName(time.Unix(2, 0)), BackupName(time.Unix(1, 0))} {
+		if err := store.Save(ctx, name, strings.NewReader(name), int64(len(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != BackupName(time.Unix(1, 0)) {
+		t.Fatalf("Expected backups in name order, got %v", names)
+	}
+	file, err := store.Open(ctx, names[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != names[1] {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if err := store.Delete(ctx, names[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, names[1]); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("Expected a deleted backup to be missing, got %v", err)
+	}
+}
+
+func TestDirBackupStore(t *testing.T) {
+	testBackupStore(t, &DirBackupStore{Dir: t.TempDir()})
+}
+
+func TestS3BackupStore(t *testing.T) {
+	fake := &fakeS3{objects: map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	testBackupStore(t, &S3BackupStore{Bucket: &S3ArtifactStore{
+		Endpoint:        server.URL,
+		Bucket:          "bucket",
+		Prefix:          "backups/",
+		PathStyle:       true,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}})
+}
+
+func TestRestoreClusterStore(t *testing.T) {
+	store := newMemoryClusterStore()
+	id := NewJobID()
+	jobs := []BackupJob{{Job: Job{ID: id, Status: JobStatusSuccess, Number: 1}, Logs: []string{"one", "two"}}}
+	stats, err := RestoreClusterStore(context.Background(), store, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Jobs != 1 || stats.Lines != 2 || store.jobs[id].Status != JobStatusSuccess {
+		t.Errorf("Unexpected stats %+v and jobs %v", stats, store.jobs)
+	}
+}
@@ -0,0 +1,82 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrBisectInconclusive is returned when a build while bisecting neither
+// succeeds nor fails, such as if it's cancelled
+var ErrBisectInconclusive = errors.New("build was neither good nor bad")
+
+// BisectStep is a commit built while bisecting
+type BisectStep struct {
+	Commit string
+	JobID  JobID
+	Status JobStatus
+}
+
+// Bisection is the outcome of bisecting a range of commits
+type Bisection struct {
+	// FirstBad is the first commit whose build failed
+	FirstBad string
+	// Steps are the commits built to find it, in the order they were built
+	Steps []BisectStep
+}
+
+// BisectBuild builds a commit while bisecting, returning once the build has
+// finished
+type BisectBuild func(ctx context.Context, commit string) (BisectStep, error)
+
+// Bisect finds the first commit whose build fails by binary search. commits
+// are the commits after a known good one, oldest first, ending with a known
+// bad one, which isn't built again. onStep is called after each build if it
+// isn't nil.
+func Bisect(ctx context.Context, commits []string, build BisectBuild, onStep func(BisectStep)) (Bisection, error) {
+	var bisection Bisection
+	if len(commits) == 0 {
+		return bisection, fmt.Errorf("no commits to bisect")
+	}
+	// The first bad commit is always in commits[good:bad+1]
+	good, bad := 0, len(commits)-1
+	for good < bad {
+		mid := (good + bad) / 2
+		step, err := build(ctx, commits[mid])
+		if err != nil {
+			return bisection, fmt.Errorf("failed to build %s: %w", commits[mid], err)
+		}
+		bisection.Steps = append(bisection.Steps, step)
+		if onStep != nil {
+			onStep(step)
+		}
+		switch step.Status {
+		case JobStatusSuccess:
+			good = mid + 1
+		case JobStatusFailure:
+			bad = mid
+		default:
+			return bisection, fmt.Errorf("%s %w: %s", commits[mid], ErrBisectInconclusive, step.Status)
+		}
+	}
+	bisection.FirstBad = commits[bad]
+	return bisection, nil
+}
+
+// BisectJobs returns a BisectBuild that schedules spec on ci for each commit
+// and waits for it to finish
+func BisectJobs(ci CI, spec JobSpec) BisectBuild {
+	return func(ctx context.Context, commit string) (BisectStep, error) {
+		spec := spec
+		spec.Commit = commit
+		id, err := ci.Submit(spec)
+		if err != nil {
+			return BisectStep{}, err
+		}
+		jobs, err := ci.WaitForJobs(ctx, id)
+		if err != nil {
+			return BisectStep{Commit: commit, JobID: id}, err
+		}
+		return BisectStep{Commit: commit, JobID: id, Status: jobs[0].Status}, nil
+	}
+}
@@ -0,0 +1,56 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBisect(t *testing.T) {
+	commits := []string{"c1", "c2", "c3", "c4", "c5", "c6", "c7"}
+	for firstBad := range commits {
+		var built []string
+		build := func(_ context.Context, commit string) (BisectStep, error) {
+			built = append(built, commit)
+			status := JobStatusSuccess
+			for _, bad := range commits[firstBad:] {
+				if commit == bad {
+					status = JobStatusFailure
+				}
+			}
+			return BisectStep{Commit: commit, JobID: JobID("job-" + commit), Status: status}, nil
+		}
+		var steps []BisectStep
+		bisection, err := Bisect(context.Background(), commits, build, func(step BisectStep) {
+			steps = append(steps, step)
+		})
+		if err != nil {
+			t.Fatalf("Failed to bisect: %v", err)
+		}
+		if bisection.FirstBad != commits[firstBad] {
+			t.Errorf("Expected %s to be the first bad commit, got %s", commits[firstBad], bisection.FirstBad)
+		}
+		if len(built) > 3 {
+			t.Errorf("Expected at most 3 builds for 7 commits, built %v", built)
+		}
+		for _, commit := range built {
+			if commit == "c7" {
+				t.Error("Expected the known bad commit not to be built")
+			}
+		}
+		if !reflect.DeepEqual(steps, bisection.Steps) {
+			t.Errorf("Expected each step to be reported, got %+v and %+v", steps, bisection.Steps)
+		}
+	}
+
+	cancelled := func(_ context.Context, commit string) (BisectStep, error) {
+		return BisectStep{Commit: commit, Status: JobStatusCancelled}, nil
+	}
+	if _, err := Bisect(context.Background(), commits, cancelled, nil); !errors.Is(err, ErrBisectInconclusive) {
+		t.Errorf("Expected a cancelled build to stop the bisection, got %v", err)
+	}
+	if _, err := Bisect(context.Background(), nil, cancelled, nil); err == nil {
+		t.Error("Expected bisecting no commits to fail")
+	}
+}
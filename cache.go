@@ -0,0 +1,272 @@
+package minici
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheConfig describes directories to carry over between a repo's jobs, such
+// as node_modules or a Go module cache inside the workspace
+type CacheConfig struct {
+	// Paths are directories relative to the repository root
+	Paths []string `yaml:"paths"`
+	// KeyFiles are files, typically lock files, whose contents decide which
+	// cache is restored. Changing any of them starts a new cache.
+	KeyFiles []string `yaml:"key_files"`
+	// Key is optional text added to the cache key, which can be changed to
+	// discard existing caches
+	Key string `yaml:"key"`
+}
+
+// BuildCache keeps caches in a local directory, as a compressed archive per
+// cache key. Caches are never modified once saved, so a job always restores
+// exactly what a previous job saved.
+type BuildCache struct {
+	Dir string
+}
+
+// WithBuildCache restores and saves the caches in repo configs using cache
+func WithBuildCache(cache *BuildCache) Option {
+	return func(s *CIServer) {
+		s.cache = cache
+	}
+}
+
+// cacheKey identifies a cache by the repo, its configuration and the contents
+// of its key files
+func cacheKey(repo, workspace string, config CacheConfig) (string, error) {
+	for _, path := range append(append([]string{}, config.Paths...), config.KeyFiles...) {
+		if !filepath.IsLocal(filepath.FromSlash(path)) {
+			return "", fmt.Errorf("invalid cache path %q: must be relative to the repository root", path)
+		}
+	}
+	if len(config.Paths) == 0 {
+		return "", errors.New("cache needs at least one path")
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "repo %q\nkey %q\n", repo, config.Key)
+	for _, path := range config.Paths {
+		fmt.Fprintf(hash, "path %q\n", path)
+	}
+	for _, file := range config.KeyFiles {
+		content, err := os.ReadFile(filepath.Join(workspace, filepath.FromSlash(file)))
+		if err != nil {
+			return "", fmt.Errorf("failed to read cache key file: %w", err)
+		}
+		sum := sha256.Sum256(content)
+		fmt.Fprintf(hash, "file %q %x\n", file, sum)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *BuildCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".tar.gz")
+}
+
+// restore extracts the cache for config into workspace, returning its key and
+// whether it was found
+func (c *BuildCache) restore(repo, workspace string, config CacheConfig) (string, bool, error) {
+	key, err := cacheKey(repo, workspace, config)
+	if err != nil {
+		return "", false, err
+	}
+	file, err := os.Open(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return key, false, nil
+	}
+	if err != nil {
+		return key, false, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return key, false, err
+	}
+	if err := extractTar(reader, workspace); err != nil {
+		return key, false, err
+	}
+	return key, true, nil
+}
+
+// save archives config's paths from workspace under key, unless a cache with
+// that key already exists. It returns the archive's size, or 0 if nothing was saved.
+func (c *BuildCache) save(key, workspace string, config CacheConfig) (int64, error) {
+	if _, err := os.Stat(c.path(key)); err == nil {
+		return 0, nil
+	}
+	var paths []string
+	for _, path := range config.Paths {
+		if _, err := os.Lstat(filepath.Join(workspace, filepath.FromSlash(path))); err == nil {
+			paths = append(paths, filepath.FromSlash(path))
+		}
+	}
+	if len(paths) == 0 {
+		return 0, nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	// Write to a temporary file first so concurrent jobs never restore a partial cache
+	temp, err := os.CreateTemp(c.Dir, ".cache-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	compressed := gzip.NewWriter(temp)
+	if err := writeTar(compressed, workspace, paths...); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := compressed.Close(); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	info, err := temp.Stat()
+	if err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := temp.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(temp.Name(), c.path(key))
+}
+
+// extractTar unpacks an archive into dir. Entries must stay within dir, and
+// symlinks are created last so no entry can be written through one.
+func extractTar(r io.Reader, dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(r)
+	var links []*tar.Header
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path %q in archive", header.Name)
+		}
+		path := filepath.Join(root, name)
+		// The workspace itself may contain symlinks pointing elsewhere
+		if err := checkWithin(root, filepath.Dir(path)); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, header.FileInfo().Mode().Perm()|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(archive, path, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			links = append(links, header)
+		}
+	}
+
+	for _, link := range links {
+		path := filepath.Join(root, filepath.FromSlash(link.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := checkWithin(root, filepath.Dir(path)); err != nil {
+			return err
+		}
+		os.Remove(path)
+		if err := os.Symlink(link.Linkname, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWithin returns an error if the existing part of dir resolves to
+// somewhere outside root
+func checkWithin(root, dir string) error {
+	existing := dir
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if errors.Is(err, fs.ErrNotExist) && existing != root {
+			existing = filepath.Dir(existing)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("%s is outside the workspace", dir)
+		}
+		return nil
+	}
+}
+
+func extractFile(r io.Reader, path string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Replace rather than write through anything already at the path
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// restoreCaches restores each of the repo's caches into the workspace,
+// returning their keys so they can be saved once the job has succeeded
+func restoreCaches(cache *BuildCache, repo, workspace string, configs []CacheConfig, log func(string)) ([]string, error) {
+	keys := make([]string, len(configs))
+	for i, config := range configs {
+		key, found, err := cache.restore(repo, workspace, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore cache of %s: %w", strings.Join(config.Paths, ", "), err)
+		}
+		keys[i] = key
+		if found {
+			log(fmt.Sprintf("Restored cache of %s (%s)", strings.Join(config.Paths, ", "), key[:12]))
+		} else {
+			log(fmt.Sprintf("No cache of %s found (%s)", strings.Join(config.Paths, ", "), key[:12]))
+		}
+	}
+	return keys, nil
+}
+
+// saveCaches saves any of the repo's caches that didn't already exist
+func saveCaches(cache *BuildCache, keys []string, workspace string, configs []CacheConfig, log func(string)) {
+	for i, config := range configs {
+		size, err := cache.save(keys[i], workspace, config)
+		if err != nil {
+			log(fmt.Sprintf("Failed to save cache of %s: %v", strings.Join(config.Paths, ", "), err))
+			continue
+		}
+		if size > 0 {
+			log(fmt.Sprintf("Saved cache of %s (%s, %d bytes)", strings.Join(config.Paths, ", "), keys[i][:12], size))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package minici
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	cache := &BuildCache{Dir: t.TempDir()}
+	configs := []CacheConfig{{Paths: []string{"node_modules"}, KeyFiles: []string{"package-lock.json"}}}
+	var lines []string
+	log := func(line string) {
+		lines = append(lines, line)
+	}
+
+	// The first job misses and saves the cache once it has built node_modules
+	first := t.TempDir()
+	writeFiles(t, first, map[string]string{"package-lock.json": "v1"})
+	keys, err := restoreCaches(cache, "repo", first, configs, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, first, map[string]string{"node_modules/left-pad/index.js": "module.exports = pad"})
+	if err := os.Symlink("../left-pad/index.js", filepath.Join(first, "node_modules", "pad")); err != nil {
+		t.Fatal(err)
+	}
+	saveCaches(cache, keys, first, configs, log)
+	output := strings.Join(lines, "\n")
+	if !strings.Contains(output, "No cache of node_modules found") || !strings.Contains(output, "Saved cache of node_modules") {
+		t.Errorf("Expected a miss then a save, got %v", lines)
+	}
+
+	// A job with the same lock file restores it
+	lines = nil
+	second := t.TempDir()
+	writeFiles(t, second, map[string]string{"package-lock.json": "v1"})
+	if _, err := restoreCaches(cache, "repo", second, configs, log); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(second, "node_modules", "left-pad", "index.js"))
+	if err != nil || string(content) != "module.exports = pad" {
+		t.Errorf("Expected the cache to be restored, got %q, %v", content, err)
+	}
+	if link, err := os.Readlink(filepath.Join(second, "node_modules", "pad")); err != nil || link != "../left-pad/index.js" {
+		t.Errorf("Expected symlinks to be restored, got %q, %v", link, err)
+	}
+	if !strings.HasPrefix(lines[0], "Restored cache of node_modules") {
+		t.Errorf("Expected a hit, got %v", lines)
+	}
+
+	// Changing the lock file or repo uses a different cache
+	for _, test := range []struct{ repo, lock string }{{"repo", "v2"}, {"other", "v1"}} {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"package-lock.json": test.lock})
+		if _, err := restoreCaches(cache, test.repo, dir, configs, log); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "node_modules")); err == nil {
+			t.Errorf("Expected no cache for %+v", test)
+		}
+	}
+
+	if _, err := restoreCaches(cache, "repo", second, []CacheConfig{{Paths: []string{"../outside"}}}, log); err == nil {
+		t.Error("Expected paths outside the workspace to be rejected")
+	}
+	if _, err := restoreCaches(cache, "repo", second, []CacheConfig{{Paths: []string{"vendor"}, KeyFiles: []string{"missing.lock"}}}, log); err == nil {
+		t.Error("Expected a missing key file to return an error")
+	}
+}
+
+func TestExtractTarOutsideWorkspace(t *testing.T) {
+	archive := func(entries ...*tar.Header) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		w := tar.NewWriter(buf)
+		for _, header := range entries {
+			if err := w.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+		return buf
+	}
+
+	outside := t.TempDir()
+	dir := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, buf := range map[string]*bytes.Buffer{
+		"parent path":       archive(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o644}),
+		"workspace symlink": archive(&tar.Header{Name: "escape/evil", Typeflag: tar.TypeReg, Mode: 0o644}),
+		"archive symlink": archive(
+			&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+			&tar.Header{Name: "link/evil", Typeflag: tar.TypeSymlink, Linkname: "x"},
+		),
+	} {
+		if err := extractTar(buf, dir); err == nil {
+			t.Errorf("%s: expected extraction outside the workspace to fail", name)
+		}
+	}
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 0 {
+		t.Errorf("Expected nothing to be written outside the workspace, found %v", entries)
+	}
+}
@@ -1,6 +1,10 @@
 package minici
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -89,3 +93,333 @@ func TestCIServer(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildNumbers(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false))
+	var refs []string
+	for _, repo := range []string{"https://github.com/ocuroot/minici.git", "git@github.com:ocuroot/ui", "https://github.com/ocuroot/minici.git"} {
+		id, err := ci.Submit(JobSpec{RepoURI: repo, Commit: "main", Command: "make", Name: "Release"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := ci.JobDetail(id)
+		if job.Name != "Release" {
+			t.Errorf("Expected the job's name to be kept, got %q", job.Name)
+		}
+		refs = append(refs, job.Reference())
+	}
+	if expected := []string{"minici #1", "ui #1", "minici #2"}; !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected builds %v, got %v", expected, refs)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("ciserver_cancel_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	ci := NewCIServer()
+
+	if err := ci.CancelJob("missing"); err != ErrJobNotFound {
+		t.Errorf("Expected ErrJobNotFound, but got %v", err)
+	}
+
+	jobID := ci.ScheduleJob(barePath, "HEAD", "sleep 30")
+
+	// Wait for the command to start
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		logs := ci.JobLogs(jobID)
+		if len(logs) > 0 && logs[len(logs)-1] == "Executing command: sleep 30" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job to start, logs: %v", logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := ci.CancelJob(jobID); err != nil {
+		t.Fatalf("Expected job to be cancelled, but got %v", err)
+	}
+
+	for ci.JobDetail(jobID).Status != JobStatusCancelled {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job to be cancelled, status: %s", ci.JobDetail(jobID).Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := ci.CancelJob(jobID); err != ErrJobFinished {
+		t.Errorf("Expected ErrJobFinished, but got %v", err)
+	}
+}
+
+func TestLogListener(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("ciserver_log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	var lines []string
+	done := make(chan Job, 1)
+	ci := NewCIServer(
+		WithLogListener(func(jobID JobID, line string) {
+			lines = append(lines, line)
+		}),
+		WithJobListener(func(event JobEvent) {
+			if event.Job.Status.Done() {
+				done <- event.Job
+			}
+		}),
+	)
+
+	jobID := ci.ScheduleJob(barePath, "HEAD", "echo hello")
+
+	select {
+	case job := <-done:
+		if job.Status != JobStatusSuccess {
+			t.Fatalf("Expected job status to be success, but found %s", job.Status)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for job to complete")
+	}
+
+	logs := ci.JobLogs(jobID)
+	if len(lines) != len(logs) {
+		t.Fatalf("Expected listener to receive %d lines, but got %d", len(logs), len(lines))
+	}
+	found := false
+	for i, line := range lines {
+		if line != logs[i] {
+			t.Errorf("Expected line %d to be %q, but got %q", i, logs[i], line)
+		}
+		if line == "> hello" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected command output in logs, got %v", lines)
+	}
+
+	if tail := ci.JobLogsFrom(jobID, len(logs)-2); !reflect.DeepEqual(tail, logs[len(logs)-2:]) {
+		t.Errorf("Expected the last two lines, got %q", tail)
+	}
+	if tail := ci.JobLogsFrom(jobID, len(logs)); len(tail) != 0 {
+		t.Errorf("Expected no lines past the end, got %q", tail)
+	}
+	// Lines added to the returned slice don't change the job's logs
+	_ = append(ci.JobLogsFrom(jobID, 0), "extra")
+	if after := ci.JobLogs(jobID); len(after) != len(logs) {
+		t.Errorf("Expected the job's logs to be unchanged, got %q", after)
+	}
+}
+
+func TestMaxConcurrentJobs(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("ciserver_queue_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	ci := NewCIServer(WithMaxConcurrentJobs(1))
+
+	first := ci.ScheduleJob(barePath, "HEAD", "sleep 30")
+	second := ci.ScheduleJob(barePath, "HEAD", "sleep 30")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for ci.JobDetail(first).Status != JobStatusRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for first job to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := ci.QueueStats()
+	if stats.Capacity != 1 || stats.Running != 1 {
+		t.Errorf("Expected 1 of 1 slots to be running, got %d of %d", stats.Running, stats.Capacity)
+	}
+	if len(stats.Queue) != 1 || stats.Queue[0].Job.ID != second {
+		t.Fatalf("Expected second job to be queued, got %+v", stats.Queue)
+	}
+	if len(stats.Workers) != 1 || len(stats.Workers[0].Running) != 1 || stats.Workers[0].Running[0] != first {
+		t.Errorf("Expected worker to be running the first job, got %+v", stats.Workers)
+	}
+	if status := ci.JobDetail(second).Status; status != JobStatusPending {
+		t.Errorf("Expected second job to be pending, but found %s", status)
+	}
+
+	// Cancelling a queued job finishes it without running
+	if err := ci.CancelJob(second); err != nil {
+		t.Fatalf("Expected queued job to be cancelled, but got %v", err)
+	}
+	if status := ci.JobDetail(second).Status; status != JobStatusCancelled {
+		t.Errorf("Expected second job to be cancelled, but found %s", status)
+	}
+	if len(ci.QueueStats().Queue) != 0 {
+		t.Errorf("Expected queue to be empty after cancelling")
+	}
+
+	// A free slot starts the next job
+	third := ci.ScheduleJob(barePath, "HEAD", "echo hello")
+	if err := ci.CancelJob(first); err != nil {
+		t.Fatalf("Expected first job to be cancelled, but got %v", err)
+	}
+	for ci.JobDetail(third).Status != JobStatusSuccess {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for third job, status: %s", ci.JobDetail(third).Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recordingExecutor records the jobs it runs and fails commands starting with "fail"
+type recordingExecutor struct {
+	specs chan JobSpec
+}
+
+func (e *recordingExecutor) Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error) {
+	e.specs <- spec
+	workspace.Log("custom executor in " + workspace.Dir)
+	if strings.HasPrefix(spec.Command, "fail") {
+		return Result{ExitCode: 2}, errors.New("exit status 2")
+	}
+	return Result{}, nil
+}
+
+func TestExecutor(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("ciserver_executor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	executor := &recordingExecutor{specs: make(chan JobSpec, 2)}
+	done := make(chan Job, 2)
+	ci := NewCIServer(
+		WithExecutor(executor),
+		WithJobListener(func(event JobEvent) {
+			if event.Job.Status.Done() {
+				done <- event.Job
+			}
+		}),
+	)
+
+	for _, command := range []string{"build", "fail"} {
+		ci.ScheduleJob(barePath, "HEAD", command)
+
+		select {
+		case spec := <-executor.specs:
+			if spec.Command != command || spec.RepoURI != barePath {
+				t.Errorf("Unexpected spec passed to executor: %+v", spec)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for executor to run")
+		}
+
+		job := <-done
+		expected := JobStatusSuccess
+		if command == "fail" {
+			expected = JobStatusFailure
+		}
+		if job.Status != expected {
+			t.Errorf("Expected %s to finish with %s, but found %s", command, expected, job.Status)
+		}
+		if logs := ci.JobLogs(job.ID); !strings.HasPrefix(logs[len(logs)-1], "custom executor in ") {
+			t.Errorf("Expected executor output in logs, got %v", logs)
+		}
+	}
+}
+
+func TestJobStarter(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("ciserver_starter_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	// Jobs run inline finish before scheduling returns, in the order they were queued
+	executor := &recordingExecutor{specs: make(chan JobSpec, 3)}
+	ci := NewCIServer(WithExecutor(executor), WithMaxConcurrentJobs(1), WithJobStarter(func(run func()) {
+		run()
+	}))
+	for _, command := range []string{"build", "fail", "test"} {
+		id := ci.ScheduleJob(barePath, "HEAD", command)
+		if status := ci.JobDetail(id).Status; !status.Done() {
+			t.Errorf("Expected %s to have finished, got %s", command, status)
+		}
+	}
+	close(executor.specs)
+	var commands []string
+	for spec := range executor.specs {
+		commands = append(commands, spec.Command)
+	}
+	if !reflect.DeepEqual(commands, []string{"build", "fail", "test"}) {
+		t.Errorf("Expected jobs to run in order, got %v", commands)
+	}
+}
+
+func TestRunSetup(t *testing.T) {
+	executor := &recordingExecutor{specs: make(chan JobSpec, 3)}
+	var lines []string
+	workspace := Workspace{JobID: "01ABC", Dir: t.TempDir(), Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	spec := JobSpec{Command: "make test", Image: "golang:1.24"}
+
+	if err := runSetup(context.Background(), executor, workspace, spec, []string{"asdf install", "./bootstrap.sh"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"asdf install", "./bootstrap.sh"} {
+		setup := <-executor.specs
+		if setup.Command != expected || setup.Image != "golang:1.24" {
+			t.Errorf("Expected setup %q with the job's image, got %+v", expected, setup)
+		}
+	}
+	if lines[0] != "=== Setup 1/2: asdf install" || !strings.HasPrefix(lines[2], "=== Setup finished in ") {
+		t.Errorf("Expected setup sections with timing, got %v", lines)
+	}
+	if lines[len(lines)-1] != "=== Running make test" {
+		t.Errorf("Expected a section for the command, got %v", lines)
+	}
+
+	lines = nil
+	if err := runSetup(context.Background(), executor, workspace, spec, []string{"fail setup", "never run"}); err == nil {
+		t.Error("Expected a failing setup command to return an error")
+	}
+	<-executor.specs
+	if len(executor.specs) != 0 {
+		t.Error("Expected setup to stop at the first failure")
+	}
+	if !strings.HasPrefix(lines[len(lines)-1], "=== Setup failed after ") {
+		t.Errorf("Expected the failure to be logged, got %v", lines)
+	}
+}
+
+func TestLocalExecutor(t *testing.T) {
+	var lines []string
+	workspace := Workspace{Dir: t.TempDir(), Log: func(line string) {
+		lines = append(lines, line)
+	}}
+
+	result, err := (&LocalExecutor{}).Run(context.Background(), workspace, JobSpec{Command: "echo hello"})
+	if err != nil || result.ExitCode != 0 {
+		t.Fatalf("Expected command to succeed, got %v (exit code %d)", err, result.ExitCode)
+	}
+	if len(lines) != 3 || lines[1] != "> hello" {
+		t.Errorf("Unexpected logs: %v", lines)
+	}
+
+	result, err = (&LocalExecutor{}).Run(context.Background(), workspace, JobSpec{Command: "false"})
+	if err == nil || result.ExitCode != 1 {
+		t.Errorf("Expected command to fail, got exit code %d", result.ExitCode)
+	}
+
+	result, err = (&LocalExecutor{}).Run(context.Background(), workspace, JobSpec{Command: "does-not-exist"})
+	if err == nil || result.ExitCode != -1 {
+		t.Errorf("Expected missing command to fail with -1, got %v (exit code %d)", err, result.ExitCode)
+	}
+}
@@ -1,13 +1,18 @@
 package minici
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"os/exec"
+	"runtime/debug"
+	"runtime/pprof"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/ocuroot/gittools"
 	"github.com/oklog/ulid/v2"
 )
 
@@ -24,159 +29,1099 @@ const (
 	JobStatusRunning JobStatus = "running"
 	JobStatusSuccess JobStatus = "success"
 	JobStatusFailure JobStatus = "failure"
+	// JobStatusCancelled is set when a job is cancelled before it completes
+	JobStatusCancelled JobStatus = "cancelled"
+	// JobStatusInterrupted is set when the agent running a job stops responding
+	JobStatusInterrupted JobStatus = "interrupted"
+)
+
+var (
+	// ErrJobNotFound is returned when operating on a job that doesn't exist
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when cancelling a job that has already completed
+	ErrJobFinished = errors.New("job already finished")
 )
 
 type CI interface {
+	// ScheduleJob returns an empty ID if the job can't be scheduled
 	ScheduleJob(repoURI string, commit string, command string) JobID
+	Submit(spec JobSpec) (JobID, error)
 	ListJobs() []JobID
 	AllJobDetail() []Job
+	// QueryJobs returns a page of the jobs matching a filter, oldest first
+	QueryJobs(filter JobFilter) ([]Job, Cursor, error)
+	// WaitForJobs blocks until the jobs have finished, or every job if no
+	// IDs are given
+	WaitForJobs(ctx context.Context, ids ...JobID) ([]Job, error)
 	JobDetail(jobID JobID) Job
+	// JobLogs returns a job's log lines. They are shared with the server
+	// rather than copied, so must not be modified.
 	JobLogs(jobID JobID) []string
+	// JobLogsFrom returns a job's log lines from index from onwards, so
+	// followers can read only the lines added since they last looked
+	JobLogsFrom(jobID JobID, from int) []string
+	CancelJob(jobID JobID) error
+	QueueStats() QueueStats
+}
+
+// Done returns true if the status is final and will not change again
+func (s JobStatus) Done() bool {
+	return s == JobStatusSuccess || s == JobStatusFailure || s == JobStatusCancelled || s == JobStatusInterrupted
+}
+
+// JobSpec describes a job to be scheduled
+type JobSpec struct {
+	RepoURI string
+	Commit  string
+	Command string
+
+	// Branch is the branch Commit is on, for jobs scheduled by commit hash,
+	// so their outcomes can be compared with the branch's earlier jobs
+	Branch string
+
+	// Name optionally describes the job for people, such as "Nightly release"
+	Name string
+
+	// Project is the team the job belongs to, empty for jobs outside any project
+	Project string
+
+	// Environment is the environment the job deploys to, such as staging or
+	// prod. Once the job succeeds, its commit is what's deployed there.
+	Environment string
+
+	// Group is shared by related jobs, such as the jobs building a range of
+	// commits, so they can be followed together
+	Group string
+
+	// Labels are arbitrary key/value pairs used to categorize jobs
+	Labels map[string]string
+
+	// Image optionally names a container image to run the command in,
+	// overriding the image in the repo's config
+	Image string
+	// Env sets environment variables for the command, in addition to any in the repo's config
+	Env map[string]string
+	// Inputs are values for the inputs the repo's config declares, such as
+	// the version to deploy
+	Inputs map[string]string
+
+	// RunsOn lists labels an agent must have to run the job
+	RunsOn []string
+
+	// Needs lists jobs that must succeed before this one starts. If any of
+	// them doesn't, this job is cancelled.
+	Needs []JobNeed
+
+	// StallTimeout stops the job if its commands produce no output for this
+	// long, overriding Timeouts.Stall. Zero uses the server's default.
+	StallTimeout time.Duration
+
+	// PullRequest describes the pull request the job builds, nil for other
+	// jobs. Commit is usually its merge ref, such as refs/pull/42/merge.
+	PullRequest *PullRequest
+
+	// Urgent jobs start even during maintenance windows
+	Urgent bool
+}
+
+// Validate checks that all required fields are set
+func (s JobSpec) Validate() error {
+	if s.RepoURI == "" || s.Commit == "" || s.Command == "" {
+		return fmt.Errorf("repo URI, commit and command are required")
+	}
+	if s.Environment != "" && !environmentNamePattern.MatchString(s.Environment) {
+		return fmt.Errorf("invalid environment %q: must only contain letters, digits, ., _ and -", s.Environment)
+	}
+	if s.Group != "" && !groupIDPattern.MatchString(s.Group) {
+		return fmt.Errorf("invalid group %q: must only contain letters, digits, ., _ and -", s.Group)
+	}
+	for name := range s.Inputs {
+		if !inputNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid input name %q: must only contain letters, digits and _", name)
+		}
+	}
+	if s.StallTimeout < 0 {
+		return fmt.Errorf("stall timeout can't be negative")
+	}
+	if s.PullRequest != nil && s.PullRequest.Number <= 0 {
+		return fmt.Errorf("pull request number must be positive")
+	}
+	return nil
 }
 
 type Job struct {
 	ID     JobID
 	Status JobStatus
+	// Number counts the jobs scheduled for the repo, starting from 1
+	Number int
 
 	RepoURI string
 	Commit  string
+	// Branch is the branch Commit is on, if the job was scheduled with one
+	Branch  string
 	Command string
-	Logs    []string
+	Name    string
+	Project string
+	// Environment is the environment the job deploys to, if any
+	Environment string
+	// Group is shared by related jobs, if any
+	Group  string
+	Labels map[string]string
+	Image  string
+	Env    map[string]string
+	Inputs map[string]string
+	RunsOn []string
+	Needs  []JobNeed
+	// StallTimeout is how long the job's commands can go without output, zero
+	// for the server's default
+	StallTimeout time.Duration
+	// PullRequest is the pull request the job builds, if any
+	PullRequest *PullRequest
+	// Urgent jobs start even during maintenance windows
+	Urgent bool
+	// Outputs are values the job recorded, such as the digests of images it pushed
+	Outputs map[string]string
+	// Tests are the results from the job's test reports
+	Tests []TestResult
+	// Coverage is the percentage from the job's coverage report, nil if it had none
+	Coverage *float64
+	// Hooks are the outcomes of the repo's hooks, which don't change Status
+	Hooks []HookResult
+	// Annotations mark the lines in Logs that look like problems
+	Annotations []Annotation
+	// Sections group lines in Logs that the job marked as collapsible
+	Sections []LogSection
+	// FailedPhase is the phase the job failed or was cancelled in, empty if it succeeded
+	FailedPhase JobPhase
+	// TimedOut is set if the job failed because FailedPhase ran out of time
+	TimedOut bool
+	// Stalled is set if the job was stopped because its commands stopped
+	// producing output
+	Stalled bool
+	// CommitHash is the full hash of the commit the job checked out, which
+	// Commit may name as a branch or ref. It's empty until the job finishes.
+	CommitHash string
+	// Progress is what the job's commands last reported through a
+	// heartbeat, nil if they haven't sent one
+	Progress *JobProgress
+	// Token lets the job's commands send heartbeats, and is never shown to
+	// users
+	Token string
+
+	// Agent is the name of the agent the job was assigned to
+	Agent string
+	// RequeuedFrom is the interrupted job this one replaces, if it was requeued
+	RequeuedFrom JobID
+	// Attempt counts this job and the interrupted jobs it was requeued from,
+	// starting from 1
+	Attempt int
+	// Pinned jobs keep their artifacts however old they are, see PinJob
+	Pinned bool
+
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
 }
 
-func NewCIServer() CI {
-	return &CIServer{
-		jobs: make(map[JobID]*Job),
+// Spec returns the spec the job was scheduled with
+func (j Job) Spec() JobSpec {
+	return JobSpec{
+		RepoURI:     j.RepoURI,
+		Commit:      j.Commit,
+		Branch:      j.Branch,
+		Command:     j.Command,
+		Name:        j.Name,
+		Project:     j.Project,
+		Environment: j.Environment,
+		Group:       j.Group,
+		Labels:      j.Labels,
+		Image:       j.Image,
+		Env:         j.Env,
+		Inputs:      j.Inputs,
+		RunsOn:      j.RunsOn,
+		Needs:       j.Needs,
+
+		StallTimeout: j.StallTimeout,
+		PullRequest:  j.PullRequest,
+		Urgent:       j.Urgent,
 	}
 }
 
-type CIServer struct {
-	jobMutex sync.RWMutex
+// Reference returns a short name for the job that people can use, such as
+// "minici #142" for the 142nd job of https://github.com/ocuroot/minici.git
+func (j Job) Reference() string {
+	repo := strings.TrimSuffix(strings.TrimRight(j.RepoURI, "/"), ".git")
+	if i := strings.LastIndexAny(repo, "/:"); i >= 0 {
+		repo = repo[i+1:]
+	}
+	return fmt.Sprintf("%s #%d", repo, j.Number)
+}
 
-	jobs map[JobID]*Job
+// Duration returns how long the job has been running, or how long it ran for
+// if it has finished.
+func (j Job) Duration() time.Duration {
+	if j.StartedAt.IsZero() {
+		return 0
+	}
+	if j.FinishedAt.IsZero() {
+		return time.Since(j.StartedAt)
+	}
+	return j.FinishedAt.Sub(j.StartedAt)
+}
+
+// JobEvent describes a change in a job's status
+type JobEvent struct {
+	Job            Job
+	PreviousStatus JobStatus
+}
+
+// JobListener is called whenever a job changes status.
+// Listeners are called synchronously from the job's goroutine, so should not block.
+type JobListener func(event JobEvent)
+
+// LogListener is called whenever a line is appended to a job's logs.
+// Listeners are called synchronously from the job's goroutine, so should not block.
+type LogListener func(jobID JobID, line string)
+
+// Option configures a CIServer
+type Option func(*CIServer)
+
+// WithJobListener registers a listener that is called on every job status change
+func WithJobListener(listener JobListener) Option {
+	return func(s *CIServer) {
+		s.listeners = append(s.listeners, listener)
+	}
 }
 
-// executeCommand runs a command in the specified directory and captures its output.
-// The command output is appended to the job's logs.
-func executeCommand(command, dir string, job *Job) error {
-	job.Logs = append(job.Logs, "Executing command: "+command)
+// WithLogListener registers a listener that is called for every log line as it is produced
+func WithLogListener(listener LogListener) Option {
+	return func(s *CIServer) {
+		s.logListeners = append(s.logListeners, listener)
+	}
+}
+
+// WithExecutor sets how job commands are run. By default they run as local
+// processes, or in a docker container if the job has an image.
+func WithExecutor(executor Executor) Option {
+	return func(s *CIServer) {
+		s.executor = executor
+	}
+}
+
+// WithMaxConcurrentJobs limits how many jobs run at once. Further jobs wait in
+// a queue, and are started in the order they were scheduled, taking turns
+// between projects. The default of 0 runs every job immediately.
+func WithMaxConcurrentJobs(n int) Option {
+	return func(s *CIServer) {
+		s.maxConcurrent = n
+	}
+}
 
-	// Split the command string into the command and its arguments
-	cmdParts := strings.Fields(command)
-	if len(cmdParts) == 0 {
-		job.Logs = append(job.Logs, "Error: empty command")
-		return fmt.Errorf("empty command")
+// WithWorkspaceDir sets the directory repos are cloned into for jobs run on
+// the server. It defaults to the system temp directory.
+func WithWorkspaceDir(dir string) Option {
+	return func(s *CIServer) {
+		s.workspaceDir = dir
 	}
+}
 
-	// Create the command
-	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
-	cmd.Dir = dir
+func NewCIServer(opts ...Option) CI {
+	s := &CIServer{
+		jobs:    make(map[JobID]*Job),
+		logs:    memoryLogs{},
+		cancels: make(map[JobID]context.CancelFunc),
+		agents:  make(map[string]*agent),
 
-	// Capture the combined output
-	output, err := cmd.CombinedOutput()
+		buildNumbers: make(map[string]int),
+		jobsChanged:  make(chan struct{}),
 
-	// Append the output to logs, line by line
-	for _, line := range strings.Split(string(output), "\n") {
-		if line != "" {
-			job.Logs = append(job.Logs, "> "+line)
+		executor: &ContainerExecutor{},
+		git:      GitCLI{},
+		clock:    SystemClock,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.startJob == nil {
+		s.startJob = startWorkers(s.maxConcurrent)
+	}
+	if logs, ok := s.logs.(*fileLogs); ok {
+		logs.keyring = s.logKeyring
+	}
+	s.annotationPatterns = append(s.annotationPatterns, DefaultAnnotationPatterns...)
+	s.git = LimitClones(s.git, s.maxClones)
+	if !s.localDisabled {
+		s.local = s.newLocalAgent()
+		if s.adaptive != nil && s.maxConcurrent > 0 {
+			go s.adaptConcurrency(*s.adaptive)
+		}
+	}
+	if s.cluster != nil {
+		go s.syncCluster()
+	}
+	if s.external != nil {
+		if s.external.Consumer == "" && s.cluster != nil {
+			s.external.Consumer = s.cluster.Instance
 		}
+		if s.external.Consumer == "" {
+			s.external.Consumer = hostname()
+		}
+		go s.consumeJobs()
+		go s.extendDeliveries()
+	}
+	if s.backups != nil {
+		go s.takeBackups()
 	}
+	return s
+}
 
-	if err != nil {
-		job.Logs = append(job.Logs, "Command execution failed: "+err.Error())
-		return err
+type CIServer struct {
+	jobMutex sync.RWMutex
+
+	jobs map[JobID]*Job
+	// logs are each job's log lines, kept apart from jobs so snapshots of a
+	// job don't include them, guarded by jobMutex
+	logs logStore
+	// logKeyring encrypts the lines written to log files, if set
+	logKeyring *Keyring
+	cancels    map[JobID]context.CancelFunc
+	listeners  []JobListener
+
+	logListeners []LogListener
+
+	executor Executor
+	git      GitClient
+	// maxClones limits how many repos are cloned at once, 0 is unlimited
+	maxClones int
+	timeouts  Timeouts
+	serverURL string
+	clock     Clock
+	// startJob starts a job assigned to the local agent
+	startJob  func(run func())
+	artifacts ArtifactStore
+	cache     *BuildCache
+	tools     *ToolCaches
+	images    *ImageBuilder
+	templates TemplateStore
+	// workspaceDir is where jobs' repos are cloned, the system temp directory if empty
+	workspaceDir string
+
+	// annotationPatterns classify log lines, configured patterns first
+	annotationPatterns []AnnotationPattern
+	// logIndex finds the lines containing each word, guarded by jobMutex
+	logIndex logIndex
+	// logTimes records when each line was logged, guarded by jobMutex
+	logTimes logTimes
+	// repoStats summarizes each repo's finished jobs, guarded by jobMutex
+	repoStats repoStats
+
+	// maxConcurrent limits how many jobs the local agent runs at once, 0 is unlimited
+	maxConcurrent int
+	// adaptive scales the local agent's capacity to the host's load if set
+	adaptive *AdaptiveConcurrency
+	// cluster shares jobs with other servers if set
+	cluster *clusterState
+	// external dispatches jobs through an external queue if set
+	external *externalQueue
+	// backups are taken periodically if set
+	backups *Backups
+	// maxQueued limits how many jobs can wait to run, 0 is unlimited
+	maxQueued int
+	// quotas limit each project's jobs, guarded by jobMutex
+	quotas map[string]ProjectQuota
+	// buildNumbers are the last number given to a job for each repo, guarded by jobMutex
+	buildNumbers map[string]int
+	// jobsChanged is closed and replaced whenever a job is added or changes
+	// status, guarded by jobMutex
+	jobsChanged   chan struct{}
+	localLabels   []string
+	localDisabled bool
+	// local is the server's own agent, nil if disabled
+	local *agent
+	// agents are remote agents by name
+	agents             map[string]*agent
+	agentTimeout       time.Duration
+	jobLease           time.Duration
+	requeueInterrupted bool
+	maxRequeues        int
+	monitorOnce        sync.Once
+	waiting            []queuedJob
+	// maintenance pauses job starts during its windows, guarded by jobMutex
+	maintenance MaintenanceSchedule
+	// resumeAt is when jobs held back by maintenance will next be
+	// dispatched, zero if they won't be
+	resumeAt time.Time
+}
+
+// lineWriter splits written output into lines, passing each complete line to log
+type lineWriter struct {
+	log     func(string)
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
 	}
+	return len(p), nil
+}
 
-	job.Logs = append(job.Logs, "Command executed successfully")
-	return nil
+// Flush passes any trailing output without a newline to log
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.log(string(w.partial))
+		w.partial = nil
+	}
 }
 
-// cloneAndCheckout clones a repository and checks out a specific commit.
-// It returns the path to the cloned repository and any error encountered.
-// Progress and errors are logged to the job's logs.
-func cloneAndCheckout(repoURI, commit string, job *Job) (string, error) {
-	// Create a temporary directory for the job
-	tempDir, err := os.MkdirTemp("", "ocuroot-ci-job-")
-	if err != nil {
-		job.Logs = append(job.Logs, "Failed to create temp directory: "+err.Error())
-		return "", err
+// cloneAndCheckout clones a repository into a new directory within dir and
+// checks out a specific commit, limiting each to its timeout. It returns the
+// path to the cloned repository and the hash of the commit checked out. If it
+// fails, the result describes the phase that did. Progress and errors are
+// passed to log.
+func cloneAndCheckout(ctx context.Context, git GitClient, timeouts Timeouts, dir, repoURI, commit string, log func(string)) (string, string, *JobResult) {
+	failed := func(phase JobPhase, timedOut bool) *JobResult {
+		return &JobResult{Status: failureStatus(ctx), FailedPhase: phase, TimedOut: timedOut}
 	}
 
-	// Clone the repository
-	job.Logs = append(job.Logs, "Cloning repository: "+repoURI)
-	client := &gittools.Client{}
-	_, err = client.Clone(repoURI, tempDir)
+	// Create a temporary directory for the job
+	tempDir, err := os.MkdirTemp(dir, "ocuroot-ci-job-")
 	if err != nil {
-		job.Logs = append(job.Logs, "Failed to clone repository: "+err.Error())
-		os.RemoveAll(tempDir)
-		return "", err
+		log("Failed to create temp directory: " + err.Error())
+		return "", "", failed(PhaseClone, false)
 	}
 
-	// Open the repository
-	repo, err := gittools.Open(tempDir)
+	// Clone the repository
+	log("Cloning repository: " + repoURI)
+	timedOut, err := runPhase(ctx, PhaseClone, timeouts.Clone, log, func(ctx context.Context) error {
+		return git.Clone(ctx, repoURI, tempDir)
+	})
 	if err != nil {
-		job.Logs = append(job.Logs, "Failed to open repository: "+err.Error())
+		log("Failed to clone repository: " + err.Error())
 		os.RemoveAll(tempDir)
-		return "", err
+		return "", "", failed(PhaseClone, timedOut)
 	}
 
 	// Checkout the specific commit
-	job.Logs = append(job.Logs, "Checking out commit: "+commit)
-	err = repo.Checkout(commit)
+	log("Checking out commit: " + commit)
+	var hash string
+	timedOut, err = runPhase(ctx, PhaseCheckout, timeouts.Checkout, log, func(ctx context.Context) error {
+		hash, err = git.Checkout(ctx, tempDir, commit)
+		return err
+	})
 	if err != nil {
-		job.Logs = append(job.Logs, "Failed to checkout commit: "+err.Error())
+		log("Failed to checkout commit: " + err.Error())
 		os.RemoveAll(tempDir)
-		return "", err
+		return "", "", failed(PhaseCheckout, timedOut)
 	}
 
-	job.Logs = append(job.Logs, "Repository ready at "+tempDir)
-	return tempDir, nil
+	log("Repository ready at " + tempDir)
+	return tempDir, hash, nil
 }
 
-func (s *CIServer) saveJob(job *Job) {
+// setStatus transitions a job to a new status, recording timings and
+// notifying listeners.
+func (s *CIServer) setStatus(job *Job, status JobStatus) {
 	s.jobMutex.Lock()
-	defer s.jobMutex.Unlock()
-	s.jobs[job.ID] = job
+	event := s.transition(job, status)
+	s.jobMutex.Unlock()
+
+	s.emit(event)
+}
+
+// finish records a job's result, notifying listeners
+func (s *CIServer) finish(job *Job, result JobResult) {
+	s.jobMutex.Lock()
+	job.Outputs = result.Outputs
+	job.Tests = result.Tests
+	job.Coverage = result.Coverage
+	job.Hooks = result.Hooks
+	job.FailedPhase = result.FailedPhase
+	job.TimedOut = result.TimedOut
+	job.Stalled = result.Stalled
+	job.CommitHash = result.CommitHash
+	event := s.transition(job, result.Status)
+	s.jobMutex.Unlock()
+
+	s.emit(event)
+}
+
+// transition updates a job's status and timings, returning the event for
+// listeners. The caller must hold jobMutex.
+func (s *CIServer) transition(job *Job, status JobStatus) JobEvent {
+	previous := job.Status
+	job.Status = status
+	s.jobsUpdated()
+	if s.cluster != nil {
+		s.cluster.changed(job.ID)
+	}
+	now := s.clock.Now()
+	if status == JobStatusRunning {
+		job.StartedAt = now
+	}
+	if status.Done() {
+		job.FinishedAt = now
+		s.logs.done(job.ID)
+		s.delivered(job.ID)
+	}
+	if status == JobStatusSuccess || status == JobStatusFailure {
+		s.repoStats.add(*job)
+	}
+	return JobEvent{
+		Job:            *job,
+		PreviousStatus: previous,
+	}
+}
+
+// appendLog adds a line to a job's logs and notifies log listeners
+func (s *CIServer) appendLog(job *Job, line string) {
+	s.jobMutex.Lock()
+	index := s.logs.append(job.ID, line)
+	s.logTimes.add(job.ID, index, s.clock.Now())
+	s.annotate(job, index, line)
+	s.section(job, index, line)
+	s.logIndex.add(job.ID, index, line)
+	if s.cluster != nil {
+		s.cluster.appended(job.ID)
+	}
+	s.jobMutex.Unlock()
+
+	for _, listener := range s.logListeners {
+		listener(job.ID, line)
+	}
+}
+
+// emit passes an event to all registered listeners
+func (s *CIServer) emit(event JobEvent) {
+	for _, listener := range s.listeners {
+		listener(event)
+	}
 }
 
+// ScheduleJob schedules a job, returning an empty ID if it can't be, such as
+// when the queue is full. Use Submit to find out why.
 func (s *CIServer) ScheduleJob(repoURI string, commit string, command string) JobID {
-	job := &Job{
-		ID:      NewJobID(),
-		Status:  JobStatusPending,
+	id, _ := s.Submit(JobSpec{
 		RepoURI: repoURI,
 		Commit:  commit,
 		Command: command,
-		Logs:    []string{},
+	})
+	return id
+}
+
+// Submit schedules a job described by a spec, returning an error if the spec
+// is invalid or the queue is full
+func (s *CIServer) Submit(spec JobSpec) (JobID, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	job := newJob(spec, s.clock.Now())
+	s.jobMutex.Lock()
+	err := s.validateNeeds(spec)
+	if err == nil {
+		err = s.checkQueue(spec.Project)
+	}
+	if err != nil {
+		s.jobMutex.Unlock()
+		return "", err
+	}
+	s.queue(job)
+	s.jobMutex.Unlock()
+	s.emit(JobEvent{Job: *job})
+
+	s.publish(job)
+	s.dispatch()
+	return job.ID, nil
+}
+
+func newJob(spec JobSpec, created time.Time) *Job {
+	return &Job{
+		ID:          NewJobID(),
+		Status:      JobStatusPending,
+		RepoURI:     spec.RepoURI,
+		Commit:      spec.Commit,
+		Branch:      spec.Branch,
+		Command:     spec.Command,
+		Name:        spec.Name,
+		Project:     spec.Project,
+		Environment: spec.Environment,
+		Group:       spec.Group,
+		Labels:      spec.Labels,
+		Image:       spec.Image,
+		Env:         spec.Env,
+		Inputs:      spec.Inputs,
+		RunsOn:      spec.RunsOn,
+		Needs:       spec.Needs,
+		Attempt:     1,
+		Token:       newJobToken(),
+
+		StallTimeout: spec.StallTimeout,
+		PullRequest:  spec.PullRequest,
+		Urgent:       spec.Urgent,
+
+		CreatedAt: created,
+	}
+}
+
+// enqueue adds a new job to the queue and starts it if a slot is free
+func (s *CIServer) enqueue(job *Job) {
+	s.jobMutex.Lock()
+	s.queue(job)
+	s.jobMutex.Unlock()
+	s.emit(JobEvent{Job: *job})
+
+	s.publish(job)
+	s.dispatch()
+}
+
+// queue numbers a new job and adds it to the end of the queue, or holds it
+// until it's delivered if jobs are dispatched through an external queue. The
+// caller must hold jobMutex.
+func (s *CIServer) queue(job *Job) {
+	s.buildNumbers[job.RepoURI]++
+	job.Number = s.buildNumbers[job.RepoURI]
+	ctx, cancel := context.WithCancel(context.Background())
+	s.jobs[job.ID] = job
+	s.cancels[job.ID] = cancel
+	if s.external != nil {
+		s.external.published[job.ID] = queuedJob{job: job, ctx: ctx}
+	} else {
+		s.waiting = append(s.waiting, queuedJob{job: job, ctx: ctx})
+	}
+	s.jobsUpdated()
+	if s.cluster != nil {
+		s.cluster.own(job.ID)
+	}
+}
+
+// queuedJob is a job waiting for a free slot to run in
+type queuedJob struct {
+	job *Job
+	ctx context.Context
+}
+
+// dispatch starts waiting jobs on the local agent, in the order chosen by
+// nextJob, until it has no free slots. Jobs the local agent can't run are left
+// for remote agents, and jobs still waiting for the jobs they need are left
+// until those finish.
+func (s *CIServer) dispatch() {
+	s.jobMutex.Lock()
+	var unmet []*Job
+	var failedNeeds []Job
+	remaining := s.waiting[:0:0]
+	for _, queued := range s.waiting {
+		if _, failed := s.blockedBy(queued.job); failed != nil {
+			unmet = append(unmet, queued.job)
+			failedNeeds = append(failedNeeds, *failed)
+			continue
+		}
+		remaining = append(remaining, queued)
+	}
+	s.waiting = remaining
+
+	var start []queuedJob
+	if s.local != nil {
+		running := s.runningByProject()
+		for s.local.free() {
+			i := s.nextJob(running, s.local.canRun)
+			if i < 0 {
+				break
+			}
+			queued := s.waiting[i]
+			s.waiting = append(s.waiting[:i:i], s.waiting[i+1:]...)
+			s.local.running[queued.job.ID] = true
+			queued.job.Agent = s.local.Name
+			running[queued.job.Project]++
+			start = append(start, queued)
+		}
+		s.resumeAfter(s.maintenance.Active(s.clock.Now()))
+	}
+	s.jobMutex.Unlock()
+
+	for _, queued := range start {
+		s.startJob(func() {
+			// Labelling the job's goroutines shows which job each belongs to
+			// in goroutine profiles
+			pprof.Do(queued.ctx, pprof.Labels("job_id", string(queued.job.ID)), func(ctx context.Context) {
+				s.run(queued.job, ctx)
+			})
+		})
+	}
+	// Cancelling these may in turn cancel jobs that need them
+	for i, job := range unmet {
+		s.appendLog(job, fmt.Sprintf("Needed job %s finished with status %s", failedNeeds[i].ID, failedNeeds[i].Status))
+		s.clearCancel(job.ID)
+		s.setStatus(job, JobStatusCancelled)
+	}
+	if len(unmet) > 0 {
+		s.dispatch()
+	}
+}
+
+// run executes a job, then frees its slot for the next waiting job
+func (s *CIServer) run(job *Job, ctx context.Context) {
+	defer s.dispatch()
+	defer func() {
+		s.jobMutex.Lock()
+		s.release(job)
+		s.jobMutex.Unlock()
+	}()
+	if !s.claimRun(queuedJob{job: job, ctx: ctx}) {
+		return
+	}
+	defer s.clearCancel(job.ID)
+
+	if ctx.Err() != nil {
+		s.setStatus(job, JobStatusCancelled)
+		return
+	}
+
+	s.setStatus(job, JobStatusRunning)
+	s.jobMutex.RLock()
+	snapshot := *job
+	snapshot.Needs = s.resolvedNeeds(job.Needs)
+	s.jobMutex.RUnlock()
+
+	stores := JobStores{Git: s.git, Timeouts: s.timeouts, Artifacts: s.artifacts, Cache: s.cache, Tools: s.tools, Images: s.images, Templates: s.templates, WorkspaceDir: s.workspaceDir, ServerURL: s.serverURL}
+	result := RunJob(ctx, snapshot, s.executor, stores, func(line string) {
+		s.appendLog(job, line)
+	})
+	s.finish(job, result)
+}
+
+// JobResult is how a job finished
+type JobResult struct {
+	Status JobStatus
+	// Outputs are values the job recorded, such as the digests of images it pushed
+	Outputs map[string]string
+	// Tests are the results from the job's test reports
+	Tests []TestResult
+	// Coverage is the percentage from the job's coverage report
+	Coverage *float64
+	// Hooks are the outcomes of the repo's hooks, which don't change Status
+	Hooks []HookResult
+	// FailedPhase is the phase the job failed or was cancelled in, empty if it succeeded
+	FailedPhase JobPhase
+	// TimedOut is set if the job failed because FailedPhase ran out of time
+	TimedOut bool
+	// Stalled is set if the job was stopped because its commands stopped
+	// producing output
+	Stalled bool
+	// CommitHash is the hash of the commit checked out, empty if the job
+	// failed before checking it out
+	CommitHash string
+}
+
+// JobStores are where a job keeps its files. Nil stores are disabled.
+type JobStores struct {
+	// WorkspaceDir is where the repo is cloned, defaults to the system temp directory
+	WorkspaceDir string
+	// Git clones the repo, defaults to GitCLI
+	Git GitClient
+	// Timeouts limit how long each phase of the job can take
+	Timeouts Timeouts
+	// Artifacts receives the files matching the repo's artifact patterns
+	Artifacts ArtifactStore
+	// Cache restores and saves the repo's caches
+	Cache *BuildCache
+	// Tools provides tool cache directories to the job's commands
+	Tools *ToolCaches
+	// Images builds and pushes the repo's images
+	Images *ImageBuilder
+	// Templates provides the templates the repo's config includes
+	Templates TemplateStore
+	// ServerURL is where the job's commands can reach the server to send
+	// heartbeats, which are disabled if it's empty
+	ServerURL string
+}
+
+// RunJob clones a job's repository and runs its command with the executor,
+// passing progress and output to log. The result's status is
+// JobStatusCancelled if ctx was cancelled. If the job panics, such as in a
+// custom executor, it fails with the stack logged rather than crashing the
+// process.
+func RunJob(ctx context.Context, job Job, executor Executor, stores JobStores, log func(string)) (result JobResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log(fmt.Sprintf("Job panicked: %v", r))
+			for _, line := range strings.Split(strings.TrimSpace(string(debug.Stack())), "\n") {
+				log(line)
+			}
+			result = JobResult{Status: JobStatusFailure}
+		}
+	}()
+	return runJob(ctx, job, executor, stores, log)
+}
+
+func runJob(ctx context.Context, job Job, executor Executor, stores JobStores, log func(string)) (result JobResult) {
+	log("Starting job execution")
+
+	git := stores.Git
+	if git == nil {
+		git = GitCLI{}
+	}
+
+	// Clone the repository and checkout the commit
+	tempDir, commit, failed := cloneAndCheckout(ctx, git, stores.Timeouts, stores.WorkspaceDir, job.RepoURI, job.Commit, log)
+	if failed != nil {
+		return *failed
+	}
+	defer os.RemoveAll(tempDir)
+	defer func() { result.CommitHash = commit }()
+
+	// Repository is ready for job execution
+	log("Repository ready for job execution")
+
+	config, err := loadJobConfig(ctx, git, job, tempDir, commit, stores.Templates, log)
+	if err != nil {
+		log(err.Error())
+		return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+	}
+	spec := config.Apply(job.Spec())
+	inputs, err := resolveInputs(config.Inputs, job.Inputs)
+	if err != nil {
+		log(err.Error())
+		return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+	}
+	// Variables describing the job, for sending heartbeats and from inputs
+	// take precedence over the env from the spec and the repo's config
+	env := maps.Clone(spec.Env)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	maps.Copy(env, jobEnv(job, commit))
+	maps.Copy(env, heartbeatEnv(job, stores.ServerURL))
+	maps.Copy(env, inputs)
+	spec.Env = env
+
+	// The scratch directory is kept apart from the checkout, so temporary
+	// files don't end up in artifacts or confuse tools looking at the repo
+	scratchDir, err := os.MkdirTemp(stores.WorkspaceDir, "ocuroot-ci-scratch-")
+	if err != nil {
+		log(fmt.Sprintf("Failed to create scratch directory: %v", err))
+		return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+	}
+	defer os.RemoveAll(scratchDir)
+	workspace := Workspace{JobID: job.ID, Dir: tempDir, ScratchDir: scratchDir, Commit: commit, Log: log}
+	if stores.Tools != nil {
+		workspace.Caches, err = stores.Tools.dirs(job.RepoURI)
+		if err != nil {
+			log(err.Error())
+			return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+		}
+	}
+
+	var cacheKeys []string
+	if stores.Cache != nil && len(config.Cache) > 0 {
+		cacheKeys, err = restoreCaches(stores.Cache, job.RepoURI, tempDir, config.Cache, log)
+		if err != nil {
+			log(err.Error())
+			return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+		}
 	}
-	s.saveJob(job)
 
-	go func() {
-		job.Status = JobStatusRunning
-		job.Logs = append(job.Logs, "Starting job execution")
+	if needsArtifacts(&job) {
+		if stores.Artifacts == nil {
+			log("Artifacts from needed jobs can't be restored without an artifact store")
+			return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+		}
+		if err := restoreArtifacts(ctx, stores.Artifacts, tempDir, job.Needs, log); err != nil {
+			log(err.Error())
+			return JobResult{Status: failureStatus(ctx), FailedPhase: PhasePrepare}
+		}
+	}
 
-		// Clone the repository and checkout the commit
-		tempDir, err := cloneAndCheckout(repoURI, commit, job)
+	steps, err := parseSteps(config.Steps)
+	if err != nil {
+		log(err.Error())
+		return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+	}
+	hooks, err := parseHooks(config)
+	if err != nil {
+		log(err.Error())
+		return JobResult{Status: JobStatusFailure, FailedPhase: PhasePrepare}
+	}
+	var conditions *conditionContext
+	if len(steps) > 0 || len(hooks) > 0 {
+		conditions, err = stepConditions(ctx, git, job, spec, tempDir, commit)
 		if err != nil {
-			job.Status = JobStatusFailure
-			return
+			log(err.Error())
+			return JobResult{Status: failureStatus(ctx), FailedPhase: PhasePrepare}
 		}
-		defer os.RemoveAll(tempDir)
+	}
 
-		// Repository is ready for job execution
-		job.Logs = append(job.Logs, "Repository ready for job execution")
+	// Execute the setup commands, the command and the steps in the cloned
+	// repository
+	stallTimeout := stores.Timeouts.Stall
+	if job.StallTimeout > 0 {
+		stallTimeout = job.StallTimeout
+	}
+	var stalled bool
+	timedOut, err := runPhase(ctx, PhaseCommand, stores.Timeouts.Command, log, func(ctx context.Context) error {
+		var err error
+		stalled, err = runWatched(ctx, stallTimeout, workspace, func(ctx context.Context, workspace Workspace) error {
+			err := runSetup(ctx, executor, workspace, spec, config.Setup)
+			if err == nil {
+				_, err = executor.Run(ctx, workspace, spec)
+			}
+			if len(steps) > 0 {
+				err = runSteps(ctx, executor, workspace, spec, steps, conditions, err)
+			}
+			return err
+		})
+		return err
+	})
+	if err != nil {
+		result.FailedPhase = PhaseCommand
+		result.TimedOut = timedOut
+		result.Stalled = stalled
+	}
+	// Hooks run outside the command's timeout, so they can report on a
+	// command that ran out of time, and before the reports and artifacts
+	// are collected, so they can add to them
+	if len(hooks) > 0 {
+		result.Hooks = runHooks(ctx, executor, workspace, spec, hooks, conditions, err)
+	}
+	if len(config.TestReports) > 0 {
+		result.Tests = collectTestReports(tempDir, config.TestReports, log)
+	}
+	if config.Coverage != "" {
+		result.Coverage = collectCoverage(tempDir, config.Coverage, log)
+	}
+	// Artifacts are saved even if the command failed, since they may include
+	// reports explaining why
+	if stores.Artifacts != nil && len(config.Artifacts) > 0 {
+		if saveErr := collectArtifacts(ctx, stores.Artifacts, job, tempDir, config.Artifacts, log); saveErr != nil {
+			log(saveErr.Error())
+			result.Status = failureStatus(ctx)
+			if err == nil {
+				result.FailedPhase = PhasePublish
+			}
+			return result
+		}
+	}
+	if err != nil {
+		result.Status = failureStatus(ctx)
+		return result
+	}
+	if len(config.Images) > 0 {
+		if stores.Images == nil {
+			log("Building images isn't enabled")
+			result.Status = JobStatusFailure
+			result.FailedPhase = PhasePublish
+			return result
+		}
+		result.Outputs, err = buildImages(ctx, stores.Images.forProject(job.Project), workspace, config.Images)
+		if err != nil {
+			result.Status = failureStatus(ctx)
+			result.FailedPhase = PhasePublish
+			return result
+		}
+	}
+	if cacheKeys != nil {
+		saveCaches(stores.Cache, cacheKeys, tempDir, config.Cache, log)
+	}
+
+	// At this point, the job completed successfully
+	result.Status = JobStatusSuccess
+	return result
+}
 
-		// Execute the command in the cloned repository
-		err = executeCommand(command, tempDir, job)
+// runSetup runs the repo's setup commands before the job's command, logging
+// each as its own section with how long it took
+func runSetup(ctx context.Context, executor Executor, workspace Workspace, spec JobSpec, commands []string) error {
+	for i, command := range commands {
+		workspace.Log(fmt.Sprintf("=== Setup %d/%d: %s", i+1, len(commands), command))
+		start := time.Now()
+		setup := spec
+		setup.Command = command
+		_, err := executor.Run(ctx, workspace, setup)
+		elapsed := time.Since(start).Round(time.Millisecond)
 		if err != nil {
-			job.Status = JobStatusFailure
-			return
+			workspace.Log(fmt.Sprintf("=== Setup failed after %s", elapsed))
+			return err
 		}
+		workspace.Log(fmt.Sprintf("=== Setup finished in %s", elapsed))
+	}
+	if len(commands) > 0 {
+		workspace.Log("=== Running " + spec.Command)
+	}
+	return nil
+}
 
-		// At this point, the job completed successfully
-		job.Status = JobStatusSuccess
-	}()
+// failureStatus returns the status for a job that stopped early, which depends
+// on whether it was cancelled.
+func failureStatus(ctx context.Context) JobStatus {
+	if ctx.Err() != nil {
+		return JobStatusCancelled
+	}
+	return JobStatusFailure
+}
+
+func (s *CIServer) clearCancel(jobID JobID) {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	if cancel, ok := s.cancels[jobID]; ok {
+		cancel()
+		delete(s.cancels, jobID)
+	}
+}
+
+// CancelJob stops a pending or running job. Running commands are killed.
+func (s *CIServer) CancelJob(jobID JobID) error {
+	if err := s.claimCancel(jobID); err != nil {
+		return err
+	}
+	s.jobMutex.Lock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		s.jobMutex.Unlock()
+		return ErrJobNotFound
+	}
+	cancel, ok := s.cancels[jobID]
+	if !ok || job.Status.Done() {
+		s.jobMutex.Unlock()
+		return ErrJobFinished
+	}
+	// Jobs that haven't started yet are removed from the queue and finish immediately
+	finish := false
+	for i, queued := range s.waiting {
+		if queued.job.ID == jobID {
+			s.waiting = append(s.waiting[:i:i], s.waiting[i+1:]...)
+			finish = true
+			break
+		}
+	}
+	if s.external != nil {
+		if _, ok := s.external.published[jobID]; ok {
+			delete(s.external.published, jobID)
+			finish = true
+		}
+	}
+	// So do jobs on remote agents, which find out when they next report logs.
+	// Their slot is held until the agent finishes the job, so it isn't given
+	// another before it has stopped this one.
+	if _, remote := s.remoteAgent(job); remote {
+		finish = true
+	}
+	s.jobMutex.Unlock()
 
-	return job.ID
+	s.appendLog(job, "Job cancelled")
+	cancel()
+	if finish {
+		s.clearCancel(jobID)
+		s.setStatus(job, JobStatusCancelled)
+		// Jobs that need this one are cancelled too
+		s.dispatch()
+	}
+	return nil
 }
 
 func (s *CIServer) ListJobs() []JobID {
@@ -217,9 +1162,12 @@ func (s *CIServer) JobDetail(jobID JobID) Job {
 }
 
 func (s *CIServer) JobLogs(jobID JobID) []string {
-	job, ok := s.jobs[jobID]
-	if !ok {
-		return []string{}
-	}
-	return job.Logs
+	return s.JobLogsFrom(jobID, 0)
+}
+
+func (s *CIServer) JobLogsFrom(jobID JobID, from int) []string {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+
+	return s.logs.lines(jobID, from)
 }
@@ -0,0 +1,43 @@
+package citest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// AssertStatus fails the test if a job doesn't have a status
+func AssertStatus(t testing.TB, ci minici.CI, id minici.JobID, status minici.JobStatus) {
+	t.Helper()
+	if actual := ci.JobDetail(id).Status; actual != status {
+		t.Errorf("Expected job %s to be %s, got %s", id, status, actual)
+	}
+}
+
+// AssertLogContains fails the test if none of a job's log lines contain text
+func AssertLogContains(t testing.TB, ci minici.CI, id minici.JobID, text string) {
+	t.Helper()
+	logs := ci.JobLogs(id)
+	for _, line := range logs {
+		if strings.Contains(line, text) {
+			return
+		}
+	}
+	t.Errorf("Expected job %s logs to contain %q, got %q", id, text, logs)
+}
+
+// WaitForDone waits for a job to finish, failing the test if it hasn't after
+// timeout. It works with any CI, such as a minici.CIServer running real jobs.
+func WaitForDone(t testing.TB, ci minici.CI, id minici.JobID, timeout time.Duration) minici.Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	jobs, err := ci.WaitForJobs(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed waiting for job %s, still %s: %v", id, ci.JobDetail(id).Status, err)
+	}
+	return jobs[0]
+}
@@ -0,0 +1,86 @@
+package citest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// Clock is a minici.Clock that only moves when Advance is called, so tests of
+// timeouts run instantly
+type Clock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+var _ minici.Clock = (*Clock)(nil)
+
+// NewClock returns a clock stopped at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by d
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock forward, waking anything waiting until then
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			remaining = append(remaining, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = remaining
+}
+
+// Waiters returns how many calls to After are still waiting, so a test can
+// tell when a goroutine has started waiting before advancing the clock
+func (c *Clock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+// WaitForWaiters polls until n calls to After are waiting, failing the test
+// if they aren't within a second
+func (c *Clock) WaitForWaiters(t testing.TB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for c.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d timers, %d waiting", n, c.Waiters())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
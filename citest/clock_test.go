@@ -0,0 +1,35 @@
+package citest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	clock := NewClock(Start)
+	short, long := clock.After(time.Second), clock.After(time.Minute)
+
+	clock.Advance(30 * time.Second)
+	select {
+	case now := <-short:
+		if !now.Equal(Start.Add(30 * time.Second)) {
+			t.Errorf("Expected the time the clock was advanced to, got %v", now)
+		}
+	default:
+		t.Fatal("Expected the short timer to fire")
+	}
+	select {
+	case <-long:
+		t.Fatal("Expected the long timer to keep waiting")
+	default:
+	}
+	if clock.Waiters() != 1 {
+		t.Errorf("Expected one waiter, got %d", clock.Waiters())
+	}
+
+	clock.Advance(time.Minute)
+	<-long
+	if !clock.Now().Equal(Start.Add(90 * time.Second)) {
+		t.Errorf("Unexpected time %v", clock.Now())
+	}
+}
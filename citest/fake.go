@@ -0,0 +1,413 @@
+// Package citest provides an in-memory fake of minici.CI and helpers for
+// asserting on jobs, for testing programs that schedule and report on jobs
+// without running them.
+package citest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// Start is when the default clock begins
+var Start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Fake is a CI that never runs commands. Jobs stay pending until they are
+// moved along with Run, Log and Finish, unless an outcome is set with
+// WithOutcome. Job IDs are job-1, job-2 and so on, in the order jobs are
+// submitted, and timestamps come from a clock that advances a second each
+// time it is read, so results are the same on every run.
+type Fake struct {
+	mutex     sync.Mutex
+	jobs      map[minici.JobID]*minici.Job
+	logs      map[minici.JobID][]string
+	order     []minici.JobID
+	ids       int
+	numbers   map[string]int
+	now       func() time.Time
+	outcome   func(minici.JobSpec) minici.JobResult
+	listeners []minici.JobListener
+	submitErr error
+	capacity  int
+	// changed is closed and replaced whenever a job is added or changes status
+	changed chan struct{}
+}
+
+// Option configures a Fake
+type Option func(*Fake)
+
+// WithClock sets where job timestamps come from
+func WithClock(now func() time.Time) Option {
+	return func(f *Fake) {
+		f.now = now
+	}
+}
+
+// WithOutcome finishes every job as soon as it is submitted, with the result
+// returned for its spec
+func WithOutcome(outcome func(spec minici.JobSpec) minici.JobResult) Option {
+	return func(f *Fake) {
+		f.outcome = outcome
+	}
+}
+
+// WithJobListener registers a function called whenever a job changes status
+func WithJobListener(listener minici.JobListener) Option {
+	return func(f *Fake) {
+		f.listeners = append(f.listeners, listener)
+	}
+}
+
+// WithCapacity sets the capacity reported by QueueStats
+func WithCapacity(n int) Option {
+	return func(f *Fake) {
+		f.capacity = n
+	}
+}
+
+// New returns an empty Fake
+func New(opts ...Option) *Fake {
+	clock := Start
+	f := &Fake{
+		jobs:    map[minici.JobID]*minici.Job{},
+		logs:    map[minici.JobID][]string{},
+		numbers: map[string]int{},
+		changed: make(chan struct{}),
+		now: func() time.Time {
+			clock = clock.Add(time.Second)
+			return clock
+		},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+var _ minici.CI = (*Fake)(nil)
+var _ minici.JobPinner = (*Fake)(nil)
+
+// FailSubmit makes Submit and ScheduleJob fail with err until it is called
+// again with nil. ScheduleJob returns an empty ID when failing.
+func (f *Fake) FailSubmit(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.submitErr = err
+}
+
+// Add inserts a job as it is, such as one that has already finished. Its ID
+// and CreatedAt are filled in if empty.
+func (f *Fake) Add(job minici.Job) minici.JobID {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if job.ID == "" {
+		job.ID = f.nextID()
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = f.now()
+	}
+	if job.Status == "" {
+		job.Status = minici.JobStatusPending
+	}
+	if _, exists := f.jobs[job.ID]; !exists {
+		f.order = append(f.order, job.ID)
+	}
+	f.jobs[job.ID] = &job
+	f.updated()
+	return job.ID
+}
+
+// nextID returns the next unused job ID. The caller must hold mutex.
+func (f *Fake) nextID() minici.JobID {
+	for {
+		f.ids++
+		id := minici.JobID("job-" + strconv.Itoa(f.ids))
+		if _, exists := f.jobs[id]; !exists {
+			return id
+		}
+	}
+}
+
+func (f *Fake) ScheduleJob(repoURI string, commit string, command string) minici.JobID {
+	id, _ := f.Submit(minici.JobSpec{RepoURI: repoURI, Commit: commit, Command: command})
+	return id
+}
+
+func (f *Fake) Submit(spec minici.JobSpec) (minici.JobID, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	f.mutex.Lock()
+	if f.submitErr != nil {
+		err := f.submitErr
+		f.mutex.Unlock()
+		return "", err
+	}
+	f.numbers[spec.RepoURI]++
+	job := &minici.Job{
+		ID:          f.nextID(),
+		Status:      minici.JobStatusPending,
+		Number:      f.numbers[spec.RepoURI],
+		RepoURI:     spec.RepoURI,
+		Commit:      spec.Commit,
+		Branch:      spec.Branch,
+		Command:     spec.Command,
+		Name:        spec.Name,
+		Project:     spec.Project,
+		Environment: spec.Environment,
+		Group:       spec.Group,
+		Labels:      spec.Labels,
+		Image:       spec.Image,
+		Env:         spec.Env,
+		Inputs:      spec.Inputs,
+		RunsOn:      spec.RunsOn,
+		Needs:       spec.Needs,
+		CreatedAt:   f.now(),
+
+		StallTimeout: spec.StallTimeout,
+		PullRequest:  spec.PullRequest,
+		Urgent:       spec.Urgent,
+	}
+	f.jobs[job.ID] = job
+	f.order = append(f.order, job.ID)
+	f.updated()
+	event := minici.JobEvent{Job: *job}
+	f.mutex.Unlock()
+	f.emit(event)
+
+	if f.outcome != nil {
+		if err := f.Run(job.ID); err != nil {
+			return job.ID, err
+		}
+		if err := f.Finish(job.ID, f.outcome(spec)); err != nil {
+			return job.ID, err
+		}
+	}
+	return job.ID, nil
+}
+
+// Run moves a pending job to running
+func (f *Fake) Run(id minici.JobID) error {
+	return f.transition(id, func(job *minici.Job) error {
+		if job.Status != minici.JobStatusPending {
+			return fmt.Errorf("job %s is %s, not pending", id, job.Status)
+		}
+		job.Status = minici.JobStatusRunning
+		job.StartedAt = f.now()
+		return nil
+	})
+}
+
+// Log appends lines to a job's logs
+func (f *Fake) Log(id minici.JobID, lines ...string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return minici.ErrJobNotFound
+	}
+	f.logs[job.ID] = append(f.logs[job.ID], lines...)
+	return nil
+}
+
+// Finish completes a job with a result, running it first if it is pending
+func (f *Fake) Finish(id minici.JobID, result minici.JobResult) error {
+	if !result.Status.Done() {
+		return fmt.Errorf("invalid final status %q", result.Status)
+	}
+	return f.transition(id, func(job *minici.Job) error {
+		if job.Status.Done() {
+			return minici.ErrJobFinished
+		}
+		now := f.now()
+		if job.StartedAt.IsZero() {
+			job.StartedAt = now
+		}
+		job.Status = result.Status
+		job.Outputs = result.Outputs
+		job.Tests = result.Tests
+		job.Coverage = result.Coverage
+		job.Hooks = result.Hooks
+		job.FailedPhase = result.FailedPhase
+		job.TimedOut = result.TimedOut
+		job.Stalled = result.Stalled
+		job.CommitHash = result.CommitHash
+		job.FinishedAt = now
+		return nil
+	})
+}
+
+// Succeed finishes a job successfully
+func (f *Fake) Succeed(id minici.JobID) error {
+	return f.Finish(id, minici.JobResult{Status: minici.JobStatusSuccess})
+}
+
+// Fail finishes a job with a failure, logging the reason
+func (f *Fake) Fail(id minici.JobID, reason string) error {
+	if err := f.Log(id, reason); err != nil {
+		return err
+	}
+	return f.Finish(id, minici.JobResult{Status: minici.JobStatusFailure})
+}
+
+// transition changes a job with update, notifying listeners if it succeeds
+func (f *Fake) transition(id minici.JobID, update func(*minici.Job) error) error {
+	f.mutex.Lock()
+	job, ok := f.jobs[id]
+	if !ok {
+		f.mutex.Unlock()
+		return minici.ErrJobNotFound
+	}
+	previous := job.Status
+	if err := update(job); err != nil {
+		f.mutex.Unlock()
+		return err
+	}
+	f.updated()
+	event := minici.JobEvent{Job: *job, PreviousStatus: previous}
+	f.mutex.Unlock()
+	f.emit(event)
+	return nil
+}
+
+// updated wakes anything waiting in WaitForJobs. The caller must hold mutex.
+func (f *Fake) updated() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// WaitForJobs blocks until the jobs have finished, such as once a test has
+// moved them along from another goroutine. Without IDs, it waits for every
+// job, and for at least one to be submitted.
+func (f *Fake) WaitForJobs(ctx context.Context, ids ...minici.JobID) ([]minici.Job, error) {
+	for {
+		f.mutex.Lock()
+		waitFor := ids
+		if len(waitFor) == 0 {
+			waitFor = f.order
+		}
+		var jobs []minici.Job
+		done := len(waitFor) > 0
+		for _, id := range waitFor {
+			job, ok := f.jobs[id]
+			if !ok {
+				f.mutex.Unlock()
+				return nil, fmt.Errorf("job %s: %w", id, minici.ErrJobNotFound)
+			}
+			jobs = append(jobs, *job)
+			done = done && job.Status.Done()
+		}
+		changed := f.changed
+		f.mutex.Unlock()
+		if done {
+			return jobs, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (f *Fake) emit(event minici.JobEvent) {
+	for _, listener := range f.listeners {
+		listener(event)
+	}
+}
+
+func (f *Fake) ListJobs() []minici.JobID {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]minici.JobID{}, f.order...)
+}
+
+// AllJobDetail returns every job in the order they were added
+func (f *Fake) AllJobDetail() []minici.Job {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var jobs []minici.Job
+	for _, id := range f.order {
+		jobs = append(jobs, *f.jobs[id])
+	}
+	return jobs
+}
+
+func (f *Fake) QueryJobs(filter minici.JobFilter) ([]minici.Job, minici.Cursor, error) {
+	return minici.FilterJobs(f.AllJobDetail(), filter)
+}
+
+// JobDetail returns a job, or a failed job with only the ID if it doesn't
+// exist, as minici.CIServer does
+func (f *Fake) JobDetail(id minici.JobID) minici.Job {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return minici.Job{ID: id, Status: minici.JobStatusFailure}
+	}
+	return *job
+}
+
+func (f *Fake) JobLogs(id minici.JobID) []string {
+	return f.JobLogsFrom(id, 0)
+}
+
+func (f *Fake) JobLogsFrom(id minici.JobID, from int) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	logs := f.logs[id]
+	if from >= len(logs) {
+		return []string{}
+	}
+	return append([]string{}, logs[max(from, 0):]...)
+}
+
+func (f *Fake) CancelJob(id minici.JobID) error {
+	return f.transition(id, func(job *minici.Job) error {
+		if job.Status.Done() {
+			return minici.ErrJobFinished
+		}
+		job.Status = minici.JobStatusCancelled
+		job.FinishedAt = f.now()
+		return nil
+	})
+}
+
+func (f *Fake) PinJob(id minici.JobID, pinned bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return minici.ErrJobNotFound
+	}
+	job.Pinned = pinned
+	f.updated()
+	return nil
+}
+
+// QueueStats reports pending jobs as queued and running jobs on a single
+// worker named fake
+func (f *Fake) QueueStats() minici.QueueStats {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	stats := minici.QueueStats{Capacity: f.capacity}
+	worker := minici.WorkerStats{Name: "fake", Capacity: f.capacity}
+	for _, id := range f.order {
+		job := f.jobs[id]
+		switch job.Status {
+		case minici.JobStatusRunning:
+			stats.Running++
+			worker.Running = append(worker.Running, job.ID)
+		case minici.JobStatusPending:
+			stats.Queue = append(stats.Queue, minici.QueuedJob{Job: *job})
+		}
+	}
+	stats.Workers = []minici.WorkerStats{worker}
+	return stats
+}
@@ -0,0 +1,86 @@
+package citest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+func TestFake(t *testing.T) {
+	var events []minici.JobStatus
+	ci := New(WithJobListener(func(event minici.JobEvent) {
+		events = append(events, event.Job.Status)
+	}))
+
+	id, err := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "make", Name: "Build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "job-1" {
+		t.Errorf("Expected the first job to be job-1, got %s", id)
+	}
+	AssertStatus(t, ci, id, minici.JobStatusPending)
+	if queued := ci.QueueStats().Queue; len(queued) != 1 {
+		t.Errorf("Expected the pending job to be queued, got %+v", queued)
+	}
+
+	if err := ci.Run(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.Fail(id, "exit status 2"); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, ci, id, minici.JobStatusFailure)
+	AssertLogContains(t, ci, id, "status 2")
+	if err := ci.Succeed(id); !errors.Is(err, minici.ErrJobFinished) {
+		t.Errorf("Expected finishing twice to fail, got %v", err)
+	}
+	if err := ci.CancelJob(id); !errors.Is(err, minici.ErrJobFinished) {
+		t.Errorf("Expected cancelling a finished job to fail, got %v", err)
+	}
+
+	job := ci.JobDetail(id)
+	if job.Number != 1 || job.Name != "Build" || job.CreatedAt != Start.Add(time.Second) || job.Duration() != time.Second {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+	if len(events) != 3 || events[0] != minici.JobStatusPending || events[2] != minici.JobStatusFailure {
+		t.Errorf("Expected an event for each status, got %v", events)
+	}
+
+	ci.FailSubmit(errors.New("unavailable"))
+	if _, err := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "make"}); err == nil || err.Error() != "unavailable" {
+		t.Errorf("Expected the injected error, got %v", err)
+	}
+	if id := ci.ScheduleJob("repo", "main", "make"); id != "" {
+		t.Errorf("Expected no job to be scheduled, got %s", id)
+	}
+	ci.FailSubmit(nil)
+
+	// Added jobs keep their state, and don't reuse IDs
+	ci.Add(minici.Job{ID: "job-2", Status: minici.JobStatusSuccess, RepoURI: "other"})
+	if id := ci.ScheduleJob("repo", "main", "make"); id != "job-3" {
+		t.Errorf("Expected the next free ID, got %s", id)
+	}
+	jobs, _, err := ci.QueryJobs(minici.JobFilter{RepoURI: "repo"})
+	if err != nil || len(jobs) != 2 || jobs[1].Number != 2 {
+		t.Errorf("Expected both jobs for the repo, got %+v, %v", jobs, err)
+	}
+}
+
+func TestFakeOutcome(t *testing.T) {
+	ci := New(WithOutcome(func(spec minici.JobSpec) minici.JobResult {
+		if spec.Command == "make lint" {
+			return minici.JobResult{Status: minici.JobStatusFailure}
+		}
+		return minici.JobResult{Status: minici.JobStatusSuccess, Outputs: map[string]string{"command": spec.Command}}
+	}))
+	test := ci.ScheduleJob("repo", "main", "make test")
+	lint := ci.ScheduleJob("repo", "main", "make lint")
+
+	if job := WaitForDone(t, ci, test, time.Second); job.Status != minici.JobStatusSuccess || job.Outputs["command"] != "make test" {
+		t.Errorf("Unexpected result: %+v", job)
+	}
+	AssertStatus(t, ci, lint, minici.JobStatusFailure)
+}
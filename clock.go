@@ -0,0 +1,42 @@
+package minici
+
+import "time"
+
+// Clock tells the time and waits for it to pass, so that tests can use a fake
+// clock to check timeouts without waiting for them
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the real time, used unless WithClock is given
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock sets the clock used to time jobs and expire agents
+func WithClock(clock Clock) Option {
+	return func(s *CIServer) {
+		s.clock = clock
+	}
+}
+
+// WithJobStarter sets how jobs assigned to the local agent are started. By
+// default they run on a pool of WithMaxConcurrentJobs goroutines, or each in
+// its own goroutine if unlimited. Tests can pass a function that calls run
+// directly, so jobs start in the order they were assigned and have finished by
+// the time Submit returns.
+func WithJobStarter(start func(run func())) Option {
+	return func(s *CIServer) {
+		s.startJob = start
+	}
+}
@@ -0,0 +1,126 @@
+package minici_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/citest"
+)
+
+func TestAgentTimeoutWithClock(t *testing.T) {
+	clock := citest.NewClock(citest.Start)
+	ci := minici.NewCIServer(minici.WithLocalAgent(false), minici.WithAgentTimeout(time.Minute), minici.WithClock(clock))
+	id, err := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ci.(minici.AgentPool).ClaimJob(minici.AgentInfo{Name: "flaky"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Agents are checked every quarter of the timeout
+	for i := 0; i < 4; i++ {
+		clock.WaitForWaiters(t, 1)
+		clock.Advance(15 * time.Second)
+	}
+	clock.WaitForWaiters(t, 1)
+	citest.AssertStatus(t, ci, id, minici.JobStatusRunning)
+
+	clock.Advance(15 * time.Second)
+	job := citest.WaitForDone(t, ci, id, time.Second)
+	if job.Status != minici.JobStatusInterrupted || !job.FinishedAt.Equal(citest.Start.Add(75*time.Second)) {
+		t.Errorf("Expected the job to be interrupted by the clock, got %s at %v", job.Status, job.FinishedAt)
+	}
+}
+
+func TestIdleAgentExpiresWithClock(t *testing.T) {
+	clock := citest.NewClock(citest.Start)
+	ci := minici.NewCIServer(minici.WithLocalAgent(false), minici.WithAgentTimeout(time.Minute), minici.WithClock(clock))
+	if _, err := ci.(minici.AgentPool).ClaimJob(minici.AgentInfo{Name: "idle", Capacity: 2}); !errors.Is(err, minici.ErrNoJobAvailable) {
+		t.Fatalf("Expected no job to be available, got %v", err)
+	}
+	if stats := ci.QueueStats(); len(stats.Workers) != 1 || stats.Capacity != 2 {
+		t.Fatalf("Expected the agent's capacity to count, got %+v", stats)
+	}
+
+	// The agent's capacity stops counting once it's gone quiet, whether or not
+	// the monitor has removed it yet
+	clock.WaitForWaiters(t, 1)
+	clock.Advance(61 * time.Second)
+	if stats := ci.QueueStats(); len(stats.Workers) != 0 || stats.Capacity != 0 {
+		t.Errorf("Expected the idle agent to be dropped, got %+v", stats)
+	}
+}
+
+func TestJobLeaseWithClock(t *testing.T) {
+	clock := citest.NewClock(citest.Start)
+	ci := minici.NewCIServer(minici.WithLocalAgent(false), minici.WithAgentTimeout(time.Minute), minici.WithJobLease(20*time.Second),
+		minici.WithRequeueInterrupted(true), minici.WithMaxRequeues(1), minici.WithClock(clock))
+	pool := ci.(minici.AgentPool)
+	agent := minici.AgentInfo{Name: "busy", Capacity: 2}
+	renewed, _ := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "build"})
+	stalled, _ := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "test"})
+	for range 2 {
+		if _, err := pool.ClaimJob(agent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The agent keeps renewing one job, so only the other loses its lease
+	renew := func(steps int) {
+		for range steps {
+			clock.WaitForWaiters(t, 1)
+			if err := pool.ReportLogs("busy", renewed, nil); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(5 * time.Second)
+		}
+	}
+	renew(5)
+	job := citest.WaitForDone(t, ci, stalled, time.Second)
+	if job.Status != minici.JobStatusInterrupted {
+		t.Fatalf("Expected the stalled job to be interrupted, got %s", job.Status)
+	}
+	citest.AssertStatus(t, ci, renewed, minici.JobStatusRunning)
+	if err := pool.ReportLogs("busy", stalled, []string{"late"}); err == nil {
+		t.Error("Expected the agent to be told it lost the job")
+	}
+
+	// The requeued job is the second attempt, and isn't requeued again
+	requeued := waitForQueued(t, ci)
+	if requeued.RequeuedFrom != stalled || requeued.Attempt != 2 {
+		t.Fatalf("Expected the stalled job to be requeued as attempt 2, got %+v", requeued)
+	}
+	if claimed, err := pool.ClaimJob(agent); err != nil || claimed.ID != requeued.ID {
+		t.Fatalf("Expected the agent to claim the requeued job, got %+v, %v", claimed, err)
+	}
+	renew(5)
+	citest.WaitForDone(t, ci, requeued.ID, time.Second)
+	// The requeue is decided after the interruption is announced
+	deadline := time.Now().Add(time.Second)
+	for logs := ci.JobLogs(requeued.ID); logs[len(logs)-1] != "Not requeued, the job was already attempted 2 times"; logs = ci.JobLogs(requeued.ID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the job not to be requeued again, got %q", logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queue := ci.QueueStats().Queue; len(queue) != 0 {
+		t.Errorf("Expected nothing to be requeued, got %+v", queue)
+	}
+}
+
+func waitForQueued(t *testing.T, ci minici.CI) minici.Job {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if queue := ci.QueueStats().Queue; len(queue) > 0 {
+			return queue[0].Job
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a job to be queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+package minici
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatchShipper sends job log lines to CloudWatch Logs, with a log stream
+// per job named after its ID. The log group must already exist. Each event is
+// a JSON object with the job_id, repo, commit and line, so they can be
+// queried with Logs Insights.
+type CloudWatchShipper struct {
+	// Endpoint defaults to AWS's endpoint for the region
+	Endpoint string
+	// Region defaults to us-east-1
+	Region   string
+	LogGroup string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is needed for temporary credentials
+	SessionToken string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+
+	mutex sync.Mutex
+	// streams are the log streams already created
+	streams map[JobID]bool
+}
+
+// maxCloudWatchBatch is the most events PutLogEvents accepts at once, well
+// within its size limit for typical log lines
+const maxCloudWatchBatch = 1000
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+type cloudWatchMessage struct {
+	JobID   JobID  `json:"job_id"`
+	RepoURI string `json:"repo,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Line    string `json:"line"`
+}
+
+func (c *CloudWatchShipper) region() string {
+	if c.Region == "" {
+		return "us-east-1"
+	}
+	return c.Region
+}
+
+func (c *CloudWatchShipper) Ship(ctx context.Context, entries []LogEntry) error {
+	// Events are sent to each job's stream in the order they were logged
+	var order []JobID
+	byJob := map[JobID][]cloudWatchEvent{}
+	for _, entry := range entries {
+		message, err := json.Marshal(cloudWatchMessage{JobID: entry.JobID, RepoURI: entry.RepoURI, Commit: entry.Commit, Line: entry.Line})
+		if err != nil {
+			return err
+		}
+		if _, ok := byJob[entry.JobID]; !ok {
+			order = append(order, entry.JobID)
+		}
+		byJob[entry.JobID] = append(byJob[entry.JobID], cloudWatchEvent{Timestamp: entry.Time.UnixMilli(), Message: string(message)})
+	}
+
+	for _, jobID := range order {
+		if err := c.createStream(ctx, jobID); err != nil {
+			return err
+		}
+		events := byJob[jobID]
+		for len(events) > 0 {
+			batch := events[:min(len(events), maxCloudWatchBatch)]
+			events = events[len(batch):]
+			if err := c.call(ctx, "PutLogEvents", map[string]interface{}{
+				"logGroupName":  c.LogGroup,
+				"logStreamName": string(jobID),
+				"logEvents":     batch,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createStream creates the job's log stream the first time it's shipped to
+func (c *CloudWatchShipper) createStream(ctx context.Context, jobID JobID) error {
+	c.mutex.Lock()
+	created := c.streams[jobID]
+	c.mutex.Unlock()
+	if created {
+		return nil
+	}
+
+	err := c.call(ctx, "CreateLogStream", map[string]string{
+		"logGroupName":  c.LogGroup,
+		"logStreamName": string(jobID),
+	})
+	if err != nil && !strings.Contains(err.Error(), "ResourceAlreadyExistsException") {
+		return err
+	}
+
+	c.mutex.Lock()
+	if c.streams == nil {
+		c.streams = make(map[JobID]bool)
+	}
+	c.streams[jobID] = true
+	c.mutex.Unlock()
+	return nil
+}
+
+// call makes a signed request to the CloudWatch Logs JSON API
+func (c *CloudWatchShipper) call(ctx context.Context, action string, input interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://logs.%s.amazonaws.com", c.region())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cloudwatch: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	signAWSRequest(req, "logs", c.region(), c.AccessKeyID, c.SecretAccessKey, c.SessionToken, sha256Hex(string(body)), time.Now())
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudwatch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("cloudwatch: %s failed with %s: %s", action, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
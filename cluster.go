@@ -0,0 +1,482 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultClusterSyncInterval is how often a clustered server shares its jobs
+// when Cluster doesn't set an interval
+const DefaultClusterSyncInterval = 2 * time.Second
+
+// clusterCallTimeout limits each call a server makes to its cluster's store
+const clusterCallTimeout = 10 * time.Second
+
+// ErrJobRunElsewhere is returned when cancelling a job another server in the
+// cluster is running
+var ErrJobRunElsewhere = errors.New("job is running on another server")
+
+// ClusterStore is shared by the servers in a cluster. Each server saves the
+// jobs it schedules and runs, and claims jobs before running them, so any
+// server can run a queued job but only one does.
+type ClusterStore interface {
+	// SaveJob records a job's current state, replacing any earlier state
+	SaveJob(ctx context.Context, job Job) error
+	// Jobs returns every saved job, oldest first
+	Jobs(ctx context.Context) ([]Job, error)
+	// AppendLogs adds lines to the end of a job's logs
+	AppendLogs(ctx context.Context, id JobID, lines []string) error
+	// Logs returns a job's log lines from index from onwards
+	Logs(ctx context.Context, id JobID, from int) ([]string, error)
+	// Claim takes key for owner unless it has already been taken, returning
+	// whoever holds it. Claims never expire, so each key is only taken once.
+	Claim(ctx context.Context, key, owner string) (string, error)
+	// Claimant returns who holds key, or "" if nobody has claimed it
+	Claimant(ctx context.Context, key string) (string, error)
+	// Heartbeat marks a server as alive for ttl
+	Heartbeat(ctx context.Context, instance string, ttl time.Duration) error
+	// Alive reports whether a server's last heartbeat is still live
+	Alive(ctx context.Context, instance string) (bool, error)
+}
+
+// Cluster shares a server's jobs with other servers using the same store.
+// Every server lists and serves the logs of every job, and queued jobs run on
+// whichever server claims them first. When a server stops sending heartbeats,
+// the jobs it was running are interrupted by another server, and requeued if
+// WithRequeueInterrupted is set. Job listeners and log listeners are only
+// called on the server that scheduled or ran the job.
+type Cluster struct {
+	Store ClusterStore
+	// Instance names this server to the others, defaults to its host name
+	Instance string
+	// SyncInterval is how often jobs are shared, defaults to DefaultClusterSyncInterval
+	SyncInterval time.Duration
+	// InstanceTimeout is how long a server can go without a heartbeat before
+	// its jobs are interrupted, defaults to DefaultAgentTimeout
+	InstanceTimeout time.Duration
+	// OnError is called with errors reaching the store, if set
+	OnError func(error)
+}
+
+// WithCluster shares jobs with the other servers using cluster's store
+func WithCluster(cluster Cluster) Option {
+	return func(s *CIServer) {
+		if cluster.Instance == "" {
+			cluster.Instance = hostname()
+		}
+		if cluster.SyncInterval <= 0 {
+			cluster.SyncInterval = DefaultClusterSyncInterval
+		}
+		if cluster.InstanceTimeout <= 0 {
+			cluster.InstanceTimeout = DefaultAgentTimeout
+		}
+		s.cluster = &clusterState{
+			Cluster:  cluster,
+			owned:    make(map[JobID]bool),
+			dirty:    make(map[JobID]bool),
+			logged:   make(map[JobID]bool),
+			shipped:  make(map[JobID]int),
+			mirrored: make(map[JobID]int),
+		}
+	}
+}
+
+// clusterState tracks which jobs a server saves to its cluster, guarded by
+// jobMutex
+type clusterState struct {
+	Cluster
+	// owned are the jobs this server saves: those it scheduled, until another
+	// server claims them, and those it claimed
+	owned map[JobID]bool
+	// dirty are owned jobs that changed since they were last saved
+	dirty map[JobID]bool
+	// logged are owned jobs with lines that haven't been saved yet
+	logged map[JobID]bool
+	// shipped counts the lines of each owned job that have been saved
+	shipped map[JobID]int
+	// mirrored counts the lines of each other server's job that have been read
+	mirrored map[JobID]int
+	// unclaimed are jobs that couldn't be claimed because the store failed,
+	// queued again on the next sync
+	unclaimed []queuedJob
+}
+
+// own makes this server responsible for saving a job
+func (c *clusterState) own(id JobID) {
+	c.owned[id] = true
+	c.dirty[id] = true
+}
+
+// disown leaves a job for another server to save
+func (c *clusterState) disown(id JobID) {
+	delete(c.owned, id)
+	delete(c.dirty, id)
+	delete(c.logged, id)
+}
+
+// changed marks a job to be saved if this server owns it
+func (c *clusterState) changed(id JobID) {
+	if c.owned[id] {
+		c.dirty[id] = true
+	}
+}
+
+// appended marks a job's new lines to be saved if this server owns it
+func (c *clusterState) appended(id JobID) {
+	if c.owned[id] {
+		c.logged[id] = true
+	}
+}
+
+func (c *clusterState) report(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// runClaim is the key claimed by the server that runs a job
+func runClaim(id JobID) string {
+	return "run:" + string(id)
+}
+
+// interruptClaim is the key claimed by the server that interrupts a job whose
+// server stopped responding
+func interruptClaim(id JobID) string {
+	return "interrupt:" + string(id)
+}
+
+// claimRun claims a job for this server before it runs, so each job in a
+// cluster runs once. Jobs another server claimed first are left to it, and
+// jobs that can't be claimed are queued again on the next sync.
+func (s *CIServer) claimRun(queued queuedJob) bool {
+	if s.cluster == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+	owner, err := s.cluster.Store.Claim(ctx, runClaim(queued.job.ID), s.cluster.Instance)
+	cancel()
+
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	switch {
+	case err != nil:
+		s.cluster.report(fmt.Errorf("failed to claim job %s: %w", queued.job.ID, err))
+		s.cluster.unclaimed = append(s.cluster.unclaimed, queued)
+		return false
+	case owner != s.cluster.Instance:
+		s.cluster.disown(queued.job.ID)
+		return false
+	}
+	s.cluster.own(queued.job.ID)
+	return true
+}
+
+// claimCancel claims a pending job so no other server starts it while this
+// one cancels it. Jobs running on other servers can't be cancelled here.
+func (s *CIServer) claimCancel(jobID JobID) error {
+	if s.cluster == nil {
+		return nil
+	}
+	s.jobMutex.RLock()
+	job, ok := s.jobs[jobID]
+	var status JobStatus
+	if ok {
+		status = job.Status
+	}
+	owned := s.cluster.owned[jobID]
+	s.jobMutex.RUnlock()
+	if !ok || status.Done() || (status != JobStatusPending && owned) {
+		return nil
+	}
+	if status != JobStatusPending {
+		return ErrJobRunElsewhere
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+	defer cancel()
+	owner, err := s.cluster.Store.Claim(ctx, runClaim(jobID), s.cluster.Instance)
+	if err != nil {
+		return fmt.Errorf("failed to claim job %s: %w", jobID, err)
+	}
+	if owner != s.cluster.Instance {
+		return ErrJobRunElsewhere
+	}
+	s.jobMutex.Lock()
+	s.cluster.own(jobID)
+	s.jobMutex.Unlock()
+	return nil
+}
+
+// syncCluster shares jobs with the cluster every interval
+func (s *CIServer) syncCluster() {
+	for {
+		<-s.clock.After(s.cluster.SyncInterval)
+		ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+		if err := s.syncClusterOnce(ctx); err != nil {
+			s.cluster.report(err)
+		}
+		cancel()
+	}
+}
+
+// syncClusterOnce saves this server's changes, reads every other server's,
+// interrupts the jobs of servers that stopped responding and queues any jobs
+// that couldn't be claimed earlier
+func (s *CIServer) syncClusterOnce(ctx context.Context) error {
+	c := s.cluster
+	if err := c.Store.Heartbeat(ctx, c.Instance, c.InstanceTimeout); err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	if err := s.saveOwnedJobs(ctx); err != nil {
+		return err
+	}
+	jobs, err := c.Store.Jobs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+	if err := s.mirrorJobs(ctx, jobs); err != nil {
+		return err
+	}
+	if err := s.interruptOrphans(ctx, jobs); err != nil {
+		return err
+	}
+
+	s.jobMutex.Lock()
+	for _, queued := range c.unclaimed {
+		if queued.job.Status == JobStatusPending {
+			s.waiting = append(s.waiting, queued)
+		}
+	}
+	c.unclaimed = nil
+	s.jobMutex.Unlock()
+	s.dispatch()
+	return nil
+}
+
+// saveOwnedJobs saves the new lines and changes of this server's jobs. Lines
+// are saved first, so other servers have every line once they see a job is done.
+func (s *CIServer) saveOwnedJobs(ctx context.Context) error {
+	c := s.cluster
+	type newLines struct {
+		id    JobID
+		lines []string
+	}
+	var logs []newLines
+	var jobs []Job
+	s.jobMutex.Lock()
+	for id := range c.logged {
+		logs = append(logs, newLines{id: id, lines: s.logs.lines(id, c.shipped[id])})
+	}
+	for id := range c.dirty {
+		jobs = append(jobs, *s.jobs[id])
+	}
+	c.logged = make(map[JobID]bool)
+	c.dirty = make(map[JobID]bool)
+	s.jobMutex.Unlock()
+
+	var err error
+	for i, log := range logs {
+		if err = c.Store.AppendLogs(ctx, log.id, log.lines); err != nil {
+			err = fmt.Errorf("failed to save logs of job %s: %w", log.id, err)
+			logs = logs[i:]
+			break
+		}
+		s.jobMutex.Lock()
+		c.shipped[log.id] += len(log.lines)
+		s.jobMutex.Unlock()
+	}
+	if err == nil {
+		logs = nil
+		for i, job := range jobs {
+			if err = c.Store.SaveJob(ctx, job); err != nil {
+				err = fmt.Errorf("failed to save job %s: %w", job.ID, err)
+				jobs = jobs[i:]
+				break
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	// Whatever wasn't saved is tried again on the next sync
+	s.jobMutex.Lock()
+	for _, log := range logs {
+		c.appended(log.id)
+	}
+	for _, job := range jobs {
+		c.changed(job.ID)
+	}
+	s.jobMutex.Unlock()
+	return err
+}
+
+// mirrorJobs updates this server's copies of other servers' jobs, and reads
+// their new lines
+func (s *CIServer) mirrorJobs(ctx context.Context, jobs []Job) error {
+	var read []JobID
+	s.jobMutex.Lock()
+	for _, job := range jobs {
+		if s.mirrorJob(job) {
+			read = append(read, job.ID)
+		}
+	}
+	s.jobMutex.Unlock()
+
+	for _, id := range read {
+		s.jobMutex.RLock()
+		from := s.cluster.mirrored[id]
+		s.jobMutex.RUnlock()
+		lines, err := s.cluster.Store.Logs(ctx, id, from)
+		if err != nil {
+			return fmt.Errorf("failed to read logs of job %s: %w", id, err)
+		}
+
+		s.jobMutex.Lock()
+		// Annotations and sections come with the job, so lines are only indexed
+		for _, line := range lines {
+			index := s.logs.append(id, line)
+			s.logIndex.add(id, index, line)
+		}
+		s.cluster.mirrored[id] += len(lines)
+		if job := s.jobs[id]; job.Status.Done() {
+			s.logs.done(id)
+		}
+		s.jobMutex.Unlock()
+	}
+	return nil
+}
+
+// mirrorJob updates this server's copy of a job another server saved,
+// returning whether it may have new lines. Pending jobs are queued here too,
+// to run on whichever server claims them first. The caller must hold jobMutex.
+func (s *CIServer) mirrorJob(remote Job) bool {
+	c := s.cluster
+	local, ok := s.jobs[remote.ID]
+	if ok && c.owned[remote.ID] {
+		// Another server claimed a job scheduled here before this one could
+		if local.Status != JobStatusPending || remote.Status == JobStatusPending {
+			return false
+		}
+		c.disown(remote.ID)
+	}
+	// Jobs being claimed here are left alone until the claim is settled
+	if ok && (local.Status.Done() || s.assigned(remote.ID)) {
+		return false
+	}
+	if !ok {
+		local = &Job{}
+		s.jobs[remote.ID] = local
+		s.buildNumbers[remote.RepoURI] = max(s.buildNumbers[remote.RepoURI], remote.Number)
+	}
+
+	previous := local.Status
+	*local = remote
+	// With an external queue, jobs are only queued once they're delivered
+	if remote.Status == JobStatusPending {
+		if previous != JobStatusPending && s.external == nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			s.cancels[remote.ID] = cancel
+			s.waiting = append(s.waiting, queuedJob{job: local, ctx: ctx})
+		}
+	} else {
+		s.unqueue(remote.ID)
+	}
+	if remote.Status.Done() {
+		if cancel, ok := s.cancels[remote.ID]; ok {
+			cancel()
+			delete(s.cancels, remote.ID)
+		}
+		if remote.Status == JobStatusSuccess || remote.Status == JobStatusFailure {
+			s.repoStats.add(remote)
+		}
+		s.delivered(remote.ID)
+	}
+	s.jobsUpdated()
+	return true
+}
+
+// assigned returns true if a job has been handed to the local agent or a
+// remote agent. The caller must hold jobMutex.
+func (s *CIServer) assigned(id JobID) bool {
+	if s.local != nil && s.local.running[id] {
+		return true
+	}
+	for _, a := range s.agents {
+		if a.running[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// unqueue removes a job from the queue, if it's waiting. The caller must hold
+// jobMutex.
+func (s *CIServer) unqueue(id JobID) {
+	for i, queued := range s.waiting {
+		if queued.job.ID == id {
+			s.waiting = append(s.waiting[:i:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// interruptOrphans interrupts jobs being run by servers that stopped sending
+// heartbeats. Only the server that claims the interruption updates the job,
+// so it's requeued at most once.
+func (s *CIServer) interruptOrphans(ctx context.Context, jobs []Job) error {
+	c := s.cluster
+	for _, remote := range jobs {
+		s.jobMutex.RLock()
+		owned := c.owned[remote.ID]
+		s.jobMutex.RUnlock()
+		if remote.Status != JobStatusRunning || owned {
+			continue
+		}
+
+		instance, err := c.Store.Claimant(ctx, runClaim(remote.ID))
+		if err != nil {
+			return fmt.Errorf("failed to find the server running job %s: %w", remote.ID, err)
+		}
+		if instance == "" {
+			continue
+		}
+		// A job claimed by this server that it doesn't own was running when
+		// the server restarted
+		if instance != c.Instance {
+			alive, err := c.Store.Alive(ctx, instance)
+			if err != nil {
+				return fmt.Errorf("failed to check server %s: %w", instance, err)
+			}
+			if alive {
+				continue
+			}
+		}
+		winner, err := c.Store.Claim(ctx, interruptClaim(remote.ID), c.Instance)
+		if err != nil {
+			return fmt.Errorf("failed to claim job %s: %w", remote.ID, err)
+		}
+		if winner != c.Instance {
+			continue
+		}
+
+		s.jobMutex.Lock()
+		job, ok := s.jobs[remote.ID]
+		if !ok || job.Status != JobStatusRunning {
+			s.jobMutex.Unlock()
+			continue
+		}
+		c.own(job.ID)
+		c.shipped[job.ID] = c.mirrored[job.ID]
+		s.unqueue(job.ID)
+		event := s.transition(job, JobStatusInterrupted)
+		s.jobMutex.Unlock()
+
+		s.appendLog(job, fmt.Sprintf("Server %s stopped responding, job interrupted", instance))
+		s.emit(event)
+		s.requeue(job, event.Job, s.clock.Now())
+	}
+	return nil
+}
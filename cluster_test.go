@@ -0,0 +1,202 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryClusterStore is a ClusterStore for servers in the same process
+type memoryClusterStore struct {
+	mu     sync.Mutex
+	jobs   map[JobID]Job
+	logs   map[JobID][]string
+	claims map[string]string
+	alive  map[string]bool
+}
+
+func newMemoryClusterStore() *memoryClusterStore {
+	return &memoryClusterStore{
+		jobs:   make(map[JobID]Job),
+		logs:   make(map[JobID][]string),
+		claims: make(map[string]string),
+		alive:  make(map[string]bool),
+	}
+}
+
+func (m *memoryClusterStore) SaveJob(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memoryClusterStore) Jobs(ctx context.Context) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []Job
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+func (m *memoryClusterStore) AppendLogs(ctx context.Context, id JobID, lines []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logs[id] = append(m.logs[id], lines...)
+	return nil
+}
+
+func (m *memoryClusterStore) Logs(ctx context.Context, id JobID, from int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if from >= len(m.logs[id]) {
+		return nil, nil
+	}
+	return append([]string(nil), m.logs[id][from:]...), nil
+}
+
+func (m *memoryClusterStore) Claim(ctx context.Context, key, owner string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.claims[key]; !ok {
+		m.claims[key] = owner
+	}
+	return m.claims[key], nil
+}
+
+func (m *memoryClusterStore) Claimant(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.claims[key], nil
+}
+
+func (m *memoryClusterStore) Heartbeat(ctx context.Context, instance string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alive[instance] = true
+	return nil
+}
+
+func (m *memoryClusterStore) Alive(ctx context.Context, instance string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.alive[instance], nil
+}
+
+// clusterServer creates a server that only syncs with the cluster when the
+// test calls syncClusterOnce
+func clusterServer(store ClusterStore, instance string, git GitClient, opts ...Option) *CIServer {
+	opts = append([]Option{
+		WithCluster(Cluster{Store: store, Instance: instance, SyncInterval: time.Hour}),
+		WithGitClient(git),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	}, opts...)
+	return NewCIServer(opts...).(*CIServer)
+}
+
+func syncAll(t *testing.T, servers ...*CIServer) {
+	t.Helper()
+	for _, s := range servers {
+		if err := s.syncClusterOnce(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	store := newMemoryClusterStore()
+	// The first server can't run jobs, so the second picks them up
+	a := clusterServer(store, "a", &fakeGit{}, WithLocalAgent(false))
+	b := clusterServer(store, "b", &fakeGit{})
+
+	id := a.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	syncAll(t, a, b, b, a)
+
+	if job := b.JobDetail(id); job.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to run on b, got %s: %q", job.Status, b.JobLogs(id))
+	}
+	if job := a.JobDetail(id); job.Status != JobStatusSuccess {
+		t.Errorf("Expected a to see the job succeed, got %s", job.Status)
+	}
+	if logs := a.JobLogs(id); !reflect.DeepEqual(logs, b.JobLogs(id)) {
+		t.Errorf("Expected a to read the job's logs from b, got %q", logs)
+	}
+	if owner := store.claims[runClaim(id)]; owner != "b" {
+		t.Errorf("Expected b to claim the job, got %q", owner)
+	}
+
+	// Jobs another server is running can't be cancelled, but pending jobs can
+	pending := a.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	running := NewJobID()
+	store.jobs[running] = Job{ID: running, Status: JobStatusRunning, RepoURI: "https://example.com/repo.git", Commit: "main", Command: "sleep 60"}
+	store.claims[runClaim(running)] = "b"
+	syncAll(t, a)
+	if err := a.CancelJob(running); !errors.Is(err, ErrJobRunElsewhere) {
+		t.Errorf("Expected cancelling b's job on a to fail, got %v", err)
+	}
+	if err := a.CancelJob(pending); err != nil {
+		t.Fatal(err)
+	}
+	syncAll(t, a, b)
+	if job := b.JobDetail(pending); job.Status != JobStatusCancelled {
+		t.Errorf("Expected b to see the cancelled job, got %s", job.Status)
+	}
+}
+
+func TestClusterRunsJobsOnce(t *testing.T) {
+	store := newMemoryClusterStore()
+	var started []func()
+	aGit, bGit := &fakeGit{}, &fakeGit{}
+	// Jobs on a are held until the test starts them, so b claims them first
+	a := clusterServer(store, "a", aGit, WithJobStarter(func(run func()) { started = append(started, run) }))
+	b := clusterServer(store, "b", bGit)
+
+	id := a.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	syncAll(t, a, b)
+	for _, run := range started {
+		run()
+	}
+	syncAll(t, b, a)
+
+	if len(aGit.cloned) != 0 || len(bGit.cloned) != 1 {
+		t.Errorf("Expected the job to run once, on b, got %d runs on a and %d on b", len(aGit.cloned), len(bGit.cloned))
+	}
+	if job := a.JobDetail(id); job.Status != JobStatusSuccess {
+		t.Errorf("Expected a to see the job succeed, got %s", job.Status)
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	store := newMemoryClusterStore()
+	// A server that has stopped sending heartbeats was running a job
+	id := NewJobID()
+	store.jobs[id] = Job{ID: id, Status: JobStatusRunning, Number: 1, RepoURI: "https://example.com/repo.git", Commit: "main", Command: "echo hello", Agent: "gone"}
+	store.claims[runClaim(id)] = "gone"
+
+	b := clusterServer(store, "b", &fakeGit{}, WithRequeueInterrupted(true))
+	syncAll(t, b, b)
+
+	if job := b.JobDetail(id); job.Status != JobStatusInterrupted {
+		t.Fatalf("Expected the job to be interrupted, got %s", job.Status)
+	}
+	if saved := store.jobs[id]; saved.Status != JobStatusInterrupted {
+		t.Errorf("Expected the interruption to be saved, got %s", saved.Status)
+	}
+	var requeued Job
+	for _, job := range b.AllJobDetail() {
+		if job.RequeuedFrom == id {
+			requeued = job
+		}
+	}
+	if requeued.Status != JobStatusSuccess || requeued.Number != 2 {
+		t.Errorf("Expected the job to be requeued as build 2 and succeed, got %+v", requeued)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+)
+
+// runAgent polls a server for jobs and runs them on this machine
+func runAgent(args []string) error {
+	flags, settings := clientFlags("agent", "agent [flags]")
+	defaultName, _ := os.Hostname()
+	name := flags.String("name", defaultName, "Name to register with the server. Defaults to the hostname")
+	var labels listFlag
+	flags.Var(&labels, "labels", "Labels to advertise in addition to the OS and architecture, such as docker or gpu. May be repeated or comma separated")
+	capacity := flags.Int("capacity", 1, "Number of jobs to run at once")
+	maxClones := flags.Int("max-concurrent-clones", 0, "Maximum number of repos to clone at once, further jobs wait to clone. 0 is unlimited")
+	containerRuntime := flags.String("container-runtime", "", "Container CLI used to run jobs that specify an image, such as docker or podman. Defaults to docker if installed, otherwise podman")
+	containerUserNS := flags.String("container-userns", "", "User namespace mode for job containers, passed to the runtime's --userns flag. Defaults to keep-id with podman, so the workspace stays owned by this user")
+	cacheDir := flags.String("cache-dir", "", "Directory to keep build caches in, so jobs that configure a cache start from the last one saved on this machine")
+	toolCaches := toolCacheFlags(flags)
+	templateStore := templateFlags(flags)
+	gitClient := gitFlags(flags)
+	timeouts := timeoutFlags(flags)
+	secretsFile := flags.String("secrets-file", "", "Path to a YAML file of registry credentials for pushing the images in repo configs")
+	encryptionKeyFile := flags.String("encryption-key-file", "", "File of base64 encoded keys, one per line, to decrypt -secrets-file with")
+	pollInterval := flags.Duration("poll-interval", 2*time.Second, "How often to check for jobs while idle")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return fmt.Errorf("--name is required")
+	}
+	if *capacity < 1 {
+		return fmt.Errorf("--capacity must be at least 1")
+	}
+	if *maxClones < 0 {
+		return fmt.Errorf("--max-concurrent-clones can't be negative")
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	agent := &agentRunner{
+		client: client,
+		name:   *name,
+		request: restapi.AgentRequest{
+			Labels:   append(minici.DefaultAgentLabels(), labels...),
+			Capacity: *capacity,
+		},
+		executor:     &minici.ContainerExecutor{Runtime: *containerRuntime, UserNS: *containerUserNS},
+		pollInterval: *pollInterval,
+	}
+	git, err := gitClient()
+	if err != nil {
+		return err
+	}
+	agent.stores.Git = minici.LimitClones(git, *maxClones)
+	agent.stores.Timeouts = timeouts()
+	agent.stores.ServerURL = client.Server
+	if *cacheDir != "" {
+		agent.stores.Cache = &minici.BuildCache{Dir: *cacheDir}
+	}
+	agent.stores.Tools = toolCaches()
+	agent.stores.Templates, err = templateStore(git)
+	if err != nil {
+		return err
+	}
+	keyring, err := loadKeyring(*encryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	agent.stores.Images, err = imageBuilder(*containerRuntime, *secretsFile, keyring)
+	if err != nil {
+		return err
+	}
+
+	// Interrupting stops claiming jobs and cancels any that are running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Agent %s waiting for jobs, labels: %s\n", agent.name, strings.Join(agent.request.Labels, ", "))
+	errs := make(chan error, *capacity)
+	var wg sync.WaitGroup
+	for i := 0; i < *capacity; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := agent.loop(ctx); err != nil {
+				errs <- err
+				stop()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// agentRunner claims and runs jobs, one at a time per loop
+type agentRunner struct {
+	client   *restapi.Client
+	name     string
+	request  restapi.AgentRequest
+	executor minici.Executor
+	// stores has no artifact store, so artifacts are only kept for jobs the
+	// server runs itself
+	stores       minici.JobStores
+	pollInterval time.Duration
+}
+
+// loop runs jobs until ctx is cancelled. It returns an error if the server
+// will never accept the agent.
+func (a *agentRunner) loop(ctx context.Context) error {
+	for ctx.Err() == nil {
+		job, err := a.client.ClaimJob(a.name, a.request)
+		var apiErr *restapi.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to claim job: %v\n", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(a.pollInterval):
+			}
+			continue
+		}
+		a.run(ctx, *job)
+	}
+	return nil
+}
+
+// run runs a claimed job, streaming its logs to the server
+func (a *agentRunner) run(ctx context.Context, job restapi.AgentJobResponse) {
+	fmt.Fprintf(os.Stderr, "Running job %s\n", job.ID)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reporter := &logReporter{client: a.client, agent: a.name, jobID: job.ID, cancel: cancel}
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				reporter.flush()
+			}
+		}
+	}()
+
+	result := minici.RunJob(ctx, minici.Job{
+		ID:          minici.JobID(job.ID),
+		Number:      job.Number,
+		RepoURI:     job.RepoURI,
+		Commit:      job.Commit,
+		Branch:      job.Branch,
+		Command:     job.Command,
+		Name:        job.Name,
+		Project:     job.Project,
+		Environment: job.Environment,
+		Group:       job.Group,
+		Labels:      job.Labels,
+		Image:       job.Image,
+		Env:         job.Env,
+		Inputs:      job.Inputs,
+		RunsOn:      job.RunsOn,
+		Token:       job.Token,
+
+		StallTimeout: time.Duration(job.StallTimeoutSeconds * float64(time.Second)),
+		PullRequest:  job.PullRequest.Spec(),
+	}, a.executor, a.stores, reporter.add)
+
+	close(done)
+	<-stopped
+	reporter.flush()
+
+	// Cancelled jobs are finished too, telling the server the job has stopped
+	// so it can give the agent another. The server has already finished them,
+	// so it responds with a conflict.
+	err := a.client.FinishJob(a.name, job.ID, restapi.NewAgentFinishRequest(result))
+	var apiErr *restapi.APIError
+	if reporter.cancelled || (errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict) {
+		fmt.Fprintf(os.Stderr, "Job %s was cancelled\n", job.ID)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to report status of job %s: %v\n", job.ID, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Job %s finished with status %s\n", job.ID, result.Status)
+}
+
+// logReporter buffers a job's log lines and sends them to the server in batches
+type logReporter struct {
+	client *restapi.Client
+	agent  string
+	jobID  string
+	// cancel stops the job, and is called if the server has cancelled it
+	cancel    func()
+	cancelled bool
+	// lastReport is when the server was last contacted
+	lastReport time.Time
+
+	mutex   sync.Mutex
+	pending []string
+}
+
+func (r *logReporter) add(line string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending = append(r.pending, line)
+}
+
+// flush sends pending lines to the server. Lines that fail to send are
+// retried on the next flush. While the job is quiet, an empty batch is sent
+// every few seconds to find out if it has been cancelled. It must not be
+// called concurrently.
+func (r *logReporter) flush() {
+	r.mutex.Lock()
+	lines := r.pending
+	r.pending = nil
+	r.mutex.Unlock()
+	if r.cancelled || (len(lines) == 0 && time.Since(r.lastReport) < 5*time.Second) {
+		return
+	}
+
+	r.lastReport = time.Now()
+	err := r.client.ReportLogs(r.agent, r.jobID, lines)
+	var apiErr *restapi.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		r.cancelled = true
+		r.cancel()
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to report logs for job %s: %v\n", r.jobID, err)
+		r.mutex.Lock()
+		r.pending = append(lines, r.pending...)
+		r.mutex.Unlock()
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ocuroot/gittools"
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAgentTestClient(t *testing.T) *restapi.Client {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := restapi.NewServer(ci, ":0")
+	restServer.EnableAgents(ci.(minici.AgentPool))
+	srv := httptest.NewServer(restServer)
+	t.Cleanup(srv.Close)
+
+	return restapi.NewClient(srv.URL, "")
+}
+
+func TestAgentAPI(t *testing.T) {
+	client := newAgentTestClient(t)
+
+	resp, err := client.Submit(restapi.JobRequest{
+		RepoURI: "https://github.com/ocuroot/minici",
+		Commit:  "main",
+		Command: "go test ./...",
+		Env:     map[string]string{"FOO": "bar"},
+		RunsOn:  []string{"gpu"},
+	})
+	require.NoError(t, err)
+
+	job, err := client.ClaimJob("laptop", restapi.AgentRequest{Labels: []string{"linux"}})
+	require.NoError(t, err)
+	assert.Nil(t, job, "agent without the label should not be assigned the job")
+
+	job, err = client.ClaimJob("gpu-box", restapi.AgentRequest{Labels: []string{"linux", "gpu"}})
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, resp.ID, job.ID)
+	assert.Equal(t, "bar", job.Env["FOO"])
+
+	require.NoError(t, client.ReportLogs("gpu-box", job.ID, []string{"ok"}))
+	err = client.ReportLogs("laptop", job.ID, []string{"ok"})
+	var apiErr *restapi.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	err = client.FinishJob("gpu-box", job.ID, restapi.AgentFinishRequest{Status: "running"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	outputs := map[string]string{"ghcr.io/example/app": "sha256:abc"}
+	require.NoError(t, client.FinishJob("gpu-box", job.ID, restapi.AgentFinishRequest{Status: "success", Outputs: outputs}))
+	status, err := client.Status(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "success", status.Status)
+	assert.Equal(t, "gpu-box", status.Agent)
+	assert.Equal(t, []string{"gpu"}, status.RunsOn)
+	assert.Equal(t, outputs, status.Outputs)
+
+	logs, err := client.Logs(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", logs[len(logs)-1])
+
+	// Reporting after the job finished tells the agent to stop
+	err = client.ReportLogs("gpu-box", job.ID, nil)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+}
+
+func TestAgentFailedPhase(t *testing.T) {
+	client := newAgentTestClient(t)
+	_, err := client.Submit(restapi.JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make"})
+	require.NoError(t, err)
+	job, err := client.ClaimJob("laptop", restapi.AgentRequest{})
+	require.NoError(t, err)
+	require.NotNil(t, job)
+
+	require.NoError(t, client.FinishJob("laptop", job.ID, restapi.AgentFinishRequest{Status: "failure", FailedPhase: "clone", TimedOut: true}))
+	status, err := client.Status(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "clone", status.FailedPhase)
+	assert.True(t, status.TimedOut)
+
+	var out bytes.Buffer
+	printJob(&out, status)
+	assert.Contains(t, out.String(), "Failed:  clone timed out\n")
+}
+
+func TestAgentRunner(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("agent_runner_test")
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+
+	client := newAgentTestClient(t)
+	resp, err := client.Submit(restapi.JobRequest{RepoURI: barePath, Commit: "HEAD", Command: "echo hello from agent"})
+	require.NoError(t, err)
+
+	agent := &agentRunner{
+		client:   client,
+		name:     "runner",
+		request:  restapi.AgentRequest{Labels: minici.DefaultAgentLabels()},
+		executor: &minici.LocalExecutor{},
+	}
+	job, err := client.ClaimJob(agent.name, agent.request)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	agent.run(context.Background(), *job)
+
+	status, err := client.Status(resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "success", status.Status)
+	logs, err := client.Logs(resp.ID)
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(logs, "\n"), "> hello from agent")
+}
+
+func TestAgentRunnerCancelled(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("agent_runner_cancel_test")
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+
+	client := newAgentTestClient(t)
+	first, err := client.Submit(restapi.JobRequest{RepoURI: barePath, Commit: "HEAD", Command: "sleep 30"})
+	require.NoError(t, err)
+	second, err := client.Submit(restapi.JobRequest{RepoURI: barePath, Commit: "HEAD", Command: "true"})
+	require.NoError(t, err)
+
+	agent := &agentRunner{
+		client:   client,
+		name:     "runner",
+		request:  restapi.AgentRequest{Labels: minici.DefaultAgentLabels()},
+		executor: &minici.LocalExecutor{},
+	}
+	job, err := client.ClaimJob(agent.name, agent.request)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	_, err = client.Cancel(first.ID)
+	require.NoError(t, err)
+
+	// The agent stops the job and finishes it, freeing its slot
+	agent.run(context.Background(), *job)
+	status, err := client.Status(first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", status.Status)
+	job, err = client.ClaimJob(agent.name, agent.request)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, second.ID, job.ID)
+}
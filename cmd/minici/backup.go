@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ocuroot/minici"
+)
+
+// backupStoreFlags registers the flags choosing where backups are kept,
+// returning a function that reads them after parsing. It returns nil if no
+// store was given.
+func backupStoreFlags(flags *flag.FlagSet) func() (minici.BackupStore, error) {
+	dir := flags.String("backup-dir", "", "Directory to keep backups of the server's jobs and logs in")
+	bucket := flags.String("backup-s3-bucket", "", "S3 bucket to keep backups in, instead of a directory. Credentials are read from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN")
+	endpoint := flags.String("backup-s3-endpoint", "", "URL of an S3 compatible service such as MinIO. Defaults to AWS")
+	region := flags.String("backup-s3-region", os.Getenv("AWS_REGION"), "Region of the backup bucket. Defaults to $AWS_REGION, or us-east-1")
+	prefix := flags.String("backup-s3-prefix", "", "Prefix for backup object names, such as minici/backups/")
+	pathStyle := flags.Bool("backup-s3-path-style", false, "Put the backup bucket in the URL path instead of the host name, as MinIO requires")
+	return func() (minici.BackupStore, error) {
+		switch {
+		case *dir != "" && *bucket != "":
+			return nil, errors.New("-backup-dir and -backup-s3-bucket can't be used together")
+		case *dir != "":
+			return &minici.DirBackupStore{Dir: *dir}, nil
+		case *bucket != "":
+			return &minici.S3BackupStore{Bucket: &minici.S3ArtifactStore{
+				Endpoint:        *endpoint,
+				Region:          *region,
+				Bucket:          *bucket,
+				Prefix:          *prefix,
+				PathStyle:       *pathStyle,
+				AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			}}, nil
+		}
+		return nil, nil
+	}
+}
+
+// readBackup reads a backup from a store, the most recent one if name is
+// "latest"
+func readBackup(ctx context.Context, store minici.BackupStore, name string) (string, []minici.BackupJob, error) {
+	if name == "latest" {
+		latest, err := minici.LatestBackup(ctx, store)
+		if errors.Is(err, minici.ErrBackupNotFound) {
+			return "", nil, errors.New("there are no backups to restore")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		name = latest
+	}
+	file, err := store.Open(ctx, name)
+	if err != nil {
+		return name, nil, fmt.Errorf("failed to open backup %s: %w", name, err)
+	}
+	defer file.Close()
+	jobs, err := minici.ReadBackup(file)
+	if err != nil {
+		return name, nil, fmt.Errorf("failed to read backup %s: %w", name, err)
+	}
+	return name, jobs, nil
+}
+
+// runRestore lists backups, or restores one into a cluster store. Servers
+// that aren't clustered restore backups with serve's -restore-backup flag, as
+// they keep their jobs in memory.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: minici restore --backup-dir <dir> --to <url> [--backup <name>]\n       minici restore --backup-dir <dir> --list\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	backupStore := backupStoreFlags(flags)
+	backup := flags.String("backup", "latest", "Name of the backup to restore, or latest for the most recent one")
+	list := flags.Bool("list", false, "List the backups in the store instead of restoring one")
+	to := flags.String("to", "", "URL of the cluster store to restore into, such as redis://:password@host:6379/0")
+	toPrefix := flags.String("to-prefix", "", "Prefix of the keys in the cluster store. Defaults to minici:")
+	toKeyFile := flags.String("to-key-file", "", "Encryption keys to encrypt the restored jobs and logs with, if the cluster store is encrypted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := backupStore()
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("--backup-dir or --backup-s3-bucket is required")
+	}
+
+	ctx := context.Background()
+	if *list {
+		names, err := store.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	if *to == "" {
+		return fmt.Errorf("--to is required. To restore a server that isn't clustered, start it with --restore-backup")
+	}
+	keyring, err := loadKeyring(*toKeyFile)
+	if err != nil {
+		return err
+	}
+	destination, err := openClusterStore(*to, *toPrefix, keyring)
+	if err != nil {
+		return err
+	}
+	name, jobs, err := readBackup(ctx, store, *backup)
+	if err != nil {
+		return err
+	}
+	stats, err := minici.RestoreClusterStore(ctx, destination, jobs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d jobs and %d log lines from %s\n", stats.Jobs, stats.Lines, name)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ocuroot/gittools"
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+)
+
+// BisectOutput is the machine readable output of the bisect command
+type BisectOutput struct {
+	FirstBad string                `json:"first_bad"`
+	Steps    []restapi.JobResponse `json:"steps"`
+}
+
+// runBisect finds the first commit whose job fails by scheduling it on the
+// server at commits chosen by binary search. The commits are listed from the
+// git checkout in the current directory, so it must have the ones to bisect.
+func runBisect(args []string) error {
+	flags, settings := clientFlags("bisect", "bisect [flags] --good <ref> [--bad <ref>] [--] <command>")
+	good := flags.String("good", "", "Commit, branch or tag whose job succeeds")
+	bad := flags.String("bad", "HEAD", "Commit, branch or tag whose job fails")
+	interval := flags.Duration("interval", time.Second, "How often to poll the status of each job")
+	req, err := parseJobRequest(flags, args, func() (restapi.JobRequest, error) {
+		def, err := settings.jobDefaults()
+		def.Commit = *bad
+		return def, err
+	})
+	if err != nil {
+		return err
+	}
+	if *good == "" {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	repo, err := gittools.Open(".")
+	if err != nil {
+		return fmt.Errorf("bisect must be run in a checkout of the repo: %w", err)
+	}
+	commits, err := repo.RevList(gittools.RevListOptions{Range: *good + ".." + req.Commit, AncestryPath: true})
+	if err != nil {
+		return err
+	}
+	// rev-list lists the newest commit first
+	slices.Reverse(commits)
+	fmt.Fprintf(os.Stderr, "Bisecting %d commits\n", len(commits))
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	output := BisectOutput{Steps: []restapi.JobResponse{}}
+	build := func(_ context.Context, commit string) (minici.BisectStep, error) {
+		req := req
+		req.Commit = commit
+		resp, err := client.Submit(req)
+		if err != nil {
+			return minici.BisectStep{}, err
+		}
+		jobs, err := client.WaitJobs([]string{resp.ID}, *interval)
+		if err != nil {
+			return minici.BisectStep{}, err
+		}
+		output.Steps = append(output.Steps, jobs[0])
+		return minici.BisectStep{Commit: commit, JobID: minici.JobID(resp.ID), Status: minici.JobStatus(jobs[0].Status)}, nil
+	}
+	bisection, err := minici.Bisect(context.Background(), commits, build, func(step minici.BisectStep) {
+		fmt.Fprintf(os.Stderr, "%s\t%s\t%s\n", step.Commit, step.JobID, step.Status)
+	})
+	if err != nil {
+		return err
+	}
+	output.FirstBad = bisection.FirstBad
+	return settings.output.print(output, func(w io.Writer) {
+		fmt.Fprintln(w, output.FirstBad)
+	})
+}
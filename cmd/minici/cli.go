@@ -0,0 +1,1081 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+)
+
+// command is a CLI subcommand that talks to a minici server
+type command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) error
+
+	// JobArgs is set for commands that take job IDs as arguments, so they can be completed
+	JobArgs bool
+	// Hidden commands are not listed in usage or completions
+	Hidden bool
+}
+
+// exitCode is returned by commands to exit with a specific code without printing an error
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
+func commands() []command {
+	return []command{
+		{Name: "serve", Usage: "serve [flags]", Description: "Run the CI server (the default when no command is given)"},
+		{Name: "submit", Usage: "submit [flags] --repo <uri> --commit <ref> [--] <command>", Description: "Schedule a job and print its ID", Run: runSubmit},
+		{Name: "run", Usage: "run [flags] --repo <uri> --commit <ref> [--] <command>", Description: "Schedule a job, stream its logs and exit non-zero if it fails", Run: runRun},
+		{Name: "local", Usage: "local [flags] [--] <command>", Description: "Run a job in-process without a server, exiting non-zero if it fails", Run: runLocal},
+		{Name: "agent", Usage: "agent [flags]", Description: "Run jobs claimed from a server on this machine", Run: runAgent},
+		{Name: "list", Usage: "list [flags]", Description: "List job IDs", Run: runList},
+		{Name: "environments", Usage: "environments [flags]", Description: "Show the commit each repo last deployed to each environment", Run: runEnvironments},
+		{Name: "rollback", Usage: "rollback [flags] <environment>", Description: "Redeploy the commit an environment had before its current one, printing the job's ID", Run: runRollback},
+		{Name: "bisect", Usage: "bisect [flags] --good <ref> [--bad <ref>] [--] <command>", Description: "Find the first commit whose job fails by scheduling it at commits chosen by binary search, printing the commit", Run: runBisect},
+		{Name: "status", Usage: "status [flags] [job-id]", Description: "Show a job's status and configuration, choosing the job interactively if no ID is given", Run: runStatus, JobArgs: true},
+		{Name: "logs", Usage: "logs [flags] [job-id]", Description: "Print a job's logs, optionally following them until the job completes. Chooses the job interactively if no ID is given", Run: runLogs, JobArgs: true},
+		{Name: "rerun", Usage: "rerun [flags] <job-id>", Description: "Schedule a copy of a job, optionally changing its commit, command, env or inputs, and print its ID", Run: runRerun, JobArgs: true},
+		{Name: "cancel", Usage: "cancel [flags] <job-id>", Description: "Cancel a pending or running job", Run: runCancel, JobArgs: true},
+		{Name: "pin", Usage: "pin [flags] <job-id>", Description: "Pin a job so retention never removes its artifacts, or unpin it with --remove", Run: runPin, JobArgs: true},
+		{Name: "group", Usage: "group [flags] <group-id>", Description: "Show the status of a group of jobs, optionally waiting for them or cancelling them. Exits non-zero if a waited for group failed", Run: runGroup},
+		{Name: "wait", Usage: "wait [flags] [job-id...]", Description: "Wait for jobs to complete, exiting non-zero if any failed. Waits for all jobs if no IDs are given", Run: runWait, JobArgs: true},
+		{Name: "validate", Usage: "validate [flags] [file]", Description: "Check a .minici.yml for mistakes without scheduling anything, exiting non-zero if it has errors", Run: runValidate},
+		{Name: "migrate-store", Usage: "migrate-store --from <url> --to <url>", Description: "Copy the jobs, logs and claims in one Redis cluster store to another, checking the copy", Run: runMigrateStore},
+		{Name: "restore", Usage: "restore --backup-dir <dir> --to <url> [--backup <name>]", Description: "Restore a backup of a server's jobs into a cluster store, or list backups", Run: runRestore},
+		{Name: "encrypt-file", Usage: "encrypt-file --key-file <file> <file>...", Description: "Encrypt files such as a secrets file in place, or re-encrypt them with a new key", Run: runEncryptFile},
+		{Name: "completion", Usage: "completion bash|zsh|fish", Description: "Print a shell completion script", Run: runCompletion},
+		{Name: "__complete", Usage: "__complete [words...]", Description: "Print completions for a partial command line", Run: runComplete, Hidden: true},
+	}
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: minici <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands() {
+		if cmd.Hidden {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.Name, cmd.Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'minici <command> --help' for a command's flags.\n")
+}
+
+// runClientCommand runs a subcommand, returning the process exit code
+func runClientCommand(name string, args []string) int {
+	if name == "help" || name == "--help" || name == "-h" {
+		printUsage()
+		return 0
+	}
+
+	for _, cmd := range commands() {
+		if cmd.Name != name || cmd.Run == nil {
+			continue
+		}
+
+		err := cmd.Run(args)
+		var code exitCode
+		if errors.As(err, &code) {
+			return int(code)
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage()
+	return 2
+}
+
+// clientSettings holds the connection flags shared by all client commands
+type clientSettings struct {
+	profile string
+	flags   Profile
+	output  *outputFormat
+}
+
+// clientFlags creates a flag set with the flags shared by all client commands.
+// The returned settings are resolved once flags have been parsed.
+func clientFlags(name, usage string) (*flag.FlagSet, *clientSettings) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: minici %s\n\nFlags:\n", usage)
+		flags.PrintDefaults()
+	}
+
+	settings := &clientSettings{}
+	flags.StringVar(&settings.profile, "profile", os.Getenv("MINICI_PROFILE"), "Profile to load from the config file. Defaults to $MINICI_PROFILE")
+	flags.StringVar(&settings.flags.Server, "server", "", "URL of the minici server. Defaults to $MINICI_SERVER, then the profile, then "+defaultServer)
+	flags.StringVar(&settings.flags.Token, "token", "", "Bearer token for the server. Defaults to $MINICI_TOKEN, then the profile")
+	settings.output = outputFlag(flags)
+
+	return flags, settings
+}
+
+// Resolve combines the selected profile, environment variables and flags, in
+// increasing order of precedence
+func (s *clientSettings) Resolve() (Profile, error) {
+	config, err := loadCLIConfig(cliConfigPath())
+	if err != nil {
+		return Profile{}, err
+	}
+	profile, err := config.Profile(s.profile)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	profile = profile.override(envProfile()).override(s.flags)
+	if profile.Server == "" {
+		profile.Server = defaultServer
+	}
+	return profile, nil
+}
+
+// Client builds a client using the resolved settings
+func (s *clientSettings) Client() (*restapi.Client, error) {
+	profile, err := s.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return restapi.NewClient(profile.Server, profile.Token), nil
+}
+
+// jobDefaults returns the defaults for job flags from the resolved settings
+func (s *clientSettings) jobDefaults() (restapi.JobRequest, error) {
+	profile, err := s.Resolve()
+	return restapi.JobRequest{RepoURI: profile.Repo}, err
+}
+
+// parseJobID parses flags and returns the single job ID argument
+func parseJobID(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return "", exitCode(2)
+	}
+	return flags.Arg(0), nil
+}
+
+// parseJobIDOrPick parses flags and returns the single job ID argument. If no
+// ID is given and the CLI is used from a terminal, the user is asked to pick a job.
+func parseJobIDOrPick(flags *flag.FlagSet, args []string, settings *clientSettings) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() == 1 {
+		return flags.Arg(0), nil
+	}
+	if flags.NArg() != 0 || !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		flags.Usage()
+		return "", exitCode(2)
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return "", err
+	}
+	return pickJob(client, os.Stdin, os.Stderr)
+}
+
+// labelFlags collects repeated key=value flags
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	var pairs []string
+	for key, value := range l {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("must be in the form key=value")
+	}
+	l[key] = val
+	return nil
+}
+
+// listFlag collects values from repeated or comma separated flags
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// toolCacheFlags registers the flags configuring tool caches, returning a
+// function that builds them once the flags are parsed. It returns nil if they
+// are disabled.
+func toolCacheFlags(flags *flag.FlagSet) func() *minici.ToolCaches {
+	dir := flags.String("tool-cache-dir", "", "Directory to keep tool caches such as GOMODCACHE in, which are shared by each repo's jobs. If empty, tool caches are disabled")
+	var env, shared listFlag
+	flags.Var(&env, "tool-caches", "Environment variables to point at a tool cache for each repo. Defaults to "+strings.Join(minici.DefaultToolCaches, ","))
+	flags.Var(&shared, "shared-tool-caches", "Environment variables to point at a tool cache shared by every repo, such as GOMODCACHE. May be repeated or comma separated")
+	return func() *minici.ToolCaches {
+		if *dir == "" {
+			return nil
+		}
+		return &minici.ToolCaches{Dir: *dir, Env: env, Shared: shared}
+	}
+}
+
+// templateFlags registers the flags choosing where the templates repo
+// configs include are kept, returning a function that reads them after
+// parsing. It returns nil if no store was given.
+func templateFlags(flags *flag.FlagSet) func(git minici.GitClient) (minici.TemplateStore, error) {
+	dir := flags.String("template-dir", "", "Directory of shared config templates that repo configs can include")
+	repo := flags.String("template-repo", "", "Git repo of shared config templates that repo configs can include, instead of a directory")
+	ref := flags.String("template-ref", "", "Branch, tag or commit of -template-repo to read. Defaults to its default branch")
+	repoDir := flags.String("template-repo-dir", "", "Directory in -template-repo holding the templates. Defaults to its root")
+	refresh := flags.Duration("template-refresh", minici.DefaultTemplateRefresh, "How often to fetch -template-repo for changes")
+	return func(git minici.GitClient) (minici.TemplateStore, error) {
+		switch {
+		case *dir != "" && *repo != "":
+			return nil, errors.New("-template-dir and -template-repo can't be used together")
+		case *dir != "":
+			return &minici.DirTemplates{Dir: *dir}, nil
+		case *repo != "":
+			return &minici.GitTemplates{RepoURI: *repo, Ref: *ref, Dir: *repoDir, Refresh: *refresh, Git: git}, nil
+		}
+		return nil, nil
+	}
+}
+
+// gitFlags registers the flag choosing how repos are cloned, returning a
+// function that reads it after parsing
+func gitFlags(flags *flag.FlagSet) func() (minici.GitClient, error) {
+	backend := flags.String("git-backend", "cli", "How to clone repos: cli runs the git command line, go-git clones without it")
+	return func() (minici.GitClient, error) {
+		switch *backend {
+		case "cli":
+			return minici.GitCLI{}, nil
+		case "go-git":
+			return minici.GoGit{}, nil
+		}
+		return nil, fmt.Errorf("unknown git backend %q, expected cli or go-git", *backend)
+	}
+}
+
+// timeoutFlags registers the flags limiting each phase of a job, returning a
+// function that reads them after parsing
+func timeoutFlags(flags *flag.FlagSet) func() minici.Timeouts {
+	clone := flags.Duration("clone-timeout", 0, "How long cloning a job's repo can take before the job fails. 0 is unlimited")
+	checkout := flags.Duration("checkout-timeout", 0, "How long checking out a job's commit can take before the job fails. 0 is unlimited")
+	command := flags.Duration("command-timeout", 0, "How long a job's setup commands and command can take together before the job fails. 0 is unlimited")
+	stall := flags.Duration("stall-timeout", 0, "How long a job's commands can go without output before the job is stopped, for jobs that don't set their own. 0 is unlimited")
+	return func() minici.Timeouts {
+		return minici.Timeouts{Clone: *clone, Checkout: *checkout, Command: *command, Stall: *stall}
+	}
+}
+
+// imageBuilder builds images with the container runtime, using the
+// credentials in secretsFile if set, which may be encrypted with keyring
+func imageBuilder(runtime, secretsFile string, keyring *minici.Keyring) (*minici.ImageBuilder, error) {
+	builder := &minici.ImageBuilder{Runtime: runtime}
+	if secretsFile != "" {
+		secrets, err := minici.LoadSecretsWithKeyring(secretsFile, keyring)
+		if err != nil {
+			return nil, err
+		}
+		builder.Secrets = secrets
+	}
+	return builder, nil
+}
+
+// adaptiveConcurrency builds the settings for scaling the server's concurrency
+// to its host's load, or nil if neither limit is set
+func adaptiveConcurrency(maxConcurrent, minConcurrent int, maxLoad float64, minFreeMemory string) (*minici.AdaptiveConcurrency, error) {
+	if maxLoad == 0 && minFreeMemory == "" {
+		return nil, nil
+	}
+	if maxConcurrent <= 0 {
+		return nil, fmt.Errorf("-max-load and -min-free-memory require -max-concurrent-jobs")
+	}
+	if maxLoad < 0 {
+		return nil, fmt.Errorf("-max-load can't be negative")
+	}
+	if minConcurrent < 1 || minConcurrent > maxConcurrent {
+		return nil, fmt.Errorf("-min-concurrent-jobs must be between 1 and -max-concurrent-jobs")
+	}
+	adaptive := &minici.AdaptiveConcurrency{Min: minConcurrent, MaxLoad: maxLoad}
+	if minFreeMemory != "" {
+		free, err := minici.ParseByteSize(minFreeMemory)
+		if err != nil {
+			return nil, fmt.Errorf("-min-free-memory: %w", err)
+		}
+		adaptive.MinFreeMemory = free
+	}
+	return adaptive, nil
+}
+
+// parseJobRequest registers the flags describing a job, parses them and builds
+// the request. The command may be given with --command or as trailing arguments.
+// Repo and commit flags that aren't given are filled in by defaults.
+func parseJobRequest(flags *flag.FlagSet, args []string, defaults func() (restapi.JobRequest, error)) (restapi.JobRequest, error) {
+	repo := flags.String("repo", "", "URI of the git repository to build")
+	commit := flags.String("commit", "", "Commit, branch or tag to check out")
+	branch := flags.String("branch", "", "Branch the commit is on, when --commit is a hash, so notifications compare the job with the branch's earlier jobs")
+	cmd := flags.String("command", "", "Command to run, alternatively pass the command as arguments")
+	name := flags.String("name", "", "Name or description of the job, such as \"Nightly release\"")
+	project := flags.String("project", "", "Project to schedule the job in. Jobs submitted with a project token are always in its project")
+	environment := flags.String("environment", "", "Environment the job deploys to, such as prod. Its commit is recorded as deployed there once it succeeds")
+	group := flags.String("group", "", "Group to put the job in, shared by related jobs")
+	labels := labelFlags{}
+	flags.Var(labels, "label", "Label to attach to the job as key=value, may be repeated")
+	image := flags.String("image", "", "Container image to run the command in, overriding the repo's config")
+	env := labelFlags{}
+	flags.Var(env, "env", "Environment variable for the command as KEY=value, may be repeated")
+	inputs := labelFlags{}
+	flags.Var(inputs, "input", "Value for an input the repo's config declares as name=value, may be repeated")
+	var runsOn listFlag
+	flags.Var(&runsOn, "runs-on", "Label an agent must have to run the job, may be repeated or comma separated")
+	var needs []restapi.JobNeed
+	flags.Func("needs", "ID of a job that must succeed first, optionally followed by =pattern to restore its matching artifacts. May be repeated", func(value string) error {
+		id, pattern, _ := strings.Cut(value, "=")
+		if id == "" {
+			return fmt.Errorf("must be a job ID, optionally followed by =pattern")
+		}
+		// Repeating a job adds patterns rather than needing it twice
+		for i := range needs {
+			if needs[i].Job == id {
+				if pattern != "" {
+					needs[i].Artifacts = append(needs[i].Artifacts, pattern)
+				}
+				return nil
+			}
+		}
+		need := restapi.JobNeed{Job: id}
+		if pattern != "" {
+			need.Artifacts = []string{pattern}
+		}
+		needs = append(needs, need)
+		return nil
+	})
+	stallTimeout := flags.Duration("stall-timeout", 0, "Stop the job if its commands go this long without output, overriding the server's default")
+	urgent := flags.Bool("urgent", false, "Start the job even during a maintenance window")
+	if err := flags.Parse(args); err != nil {
+		return restapi.JobRequest{}, err
+	}
+
+	def, err := defaults()
+	if err != nil {
+		return restapi.JobRequest{}, err
+	}
+	if *repo == "" {
+		*repo = def.RepoURI
+	}
+	if *commit == "" {
+		*commit = def.Commit
+	}
+
+	command := *cmd
+	if command == "" {
+		command = strings.Join(flags.Args(), " ")
+	}
+	if *repo == "" || *commit == "" || command == "" {
+		flags.Usage()
+		return restapi.JobRequest{}, exitCode(2)
+	}
+
+	req := restapi.JobRequest{
+		RepoURI:     *repo,
+		Commit:      *commit,
+		Branch:      *branch,
+		Command:     command,
+		Name:        *name,
+		Project:     *project,
+		Environment: *environment,
+		Group:       *group,
+	}
+	if len(labels) > 0 {
+		req.Labels = labels
+	}
+	req.Image = *image
+	if len(env) > 0 {
+		req.Env = env
+	}
+	if len(inputs) > 0 {
+		req.Inputs = inputs
+	}
+	req.RunsOn = runsOn
+	req.Needs = needs
+	req.StallTimeoutSeconds = stallTimeout.Seconds()
+	req.Urgent = *urgent
+	return req, nil
+}
+
+func runSubmit(args []string) error {
+	flags, settings := clientFlags("submit", "submit [flags] --repo <uri> --commit <ref> [--] <command>")
+	dryRun := flags.Bool("dry-run", false, "Check the job would be scheduled and print it, without scheduling it")
+	checkRepo := flags.Bool("check-repo", false, "With -dry-run, also check the server can reach the repo and find the commit")
+	req, err := parseJobRequest(flags, args, settings.jobDefaults)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		resp, err := client.DryRun(req, *checkRepo)
+		if err != nil {
+			return err
+		}
+		return settings.output.print(resp, func(w io.Writer) {
+			printDryRun(w, resp)
+		})
+	}
+	resp, err := client.Submit(req)
+	if err != nil {
+		return err
+	}
+	job := restapi.JobResponse{
+		ID:          resp.ID,
+		Number:      resp.Number,
+		RepoURI:     req.RepoURI,
+		Commit:      req.Commit,
+		Branch:      req.Branch,
+		Command:     req.Command,
+		Name:        req.Name,
+		Project:     req.Project,
+		Environment: req.Environment,
+		Group:       req.Group,
+		Labels:      req.Labels,
+		Image:       req.Image,
+		Inputs:      req.Inputs,
+		RunsOn:      req.RunsOn,
+		Needs:       req.Needs,
+
+		StallTimeoutSeconds: req.StallTimeoutSeconds,
+		Urgent:              req.Urgent,
+	}
+	return settings.output.print(job, func(w io.Writer) {
+		fmt.Fprintln(w, job.ID)
+	})
+}
+
+// printDryRun describes the job a dry run would have scheduled
+func printDryRun(w io.Writer, resp restapi.DryRunResponse) {
+	fmt.Fprintf(w, "Would schedule build #%d of %s at %s\n", resp.Job.Number, resp.Job.RepoURI, resp.Job.Commit)
+	if resp.ResolvedCommit != "" && resp.ResolvedCommit != resp.Job.Commit {
+		fmt.Fprintf(w, "Commit:  %s\n", resp.ResolvedCommit)
+	}
+	fmt.Fprintf(w, "Command: %s\n", resp.Job.Command)
+	for _, warning := range resp.Warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+}
+
+func runRun(args []string) error {
+	flags, settings := clientFlags("run", "run [flags] --repo <uri> --commit <ref> [--] <command>")
+	req, err := parseJobRequest(flags, args, settings.jobDefaults)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Submit(req)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Scheduled job %s\n", resp.ID)
+
+	// Interrupting cancels the remote job, then waits for it to stop.
+	// A second interrupt exits immediately.
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+	go func() {
+		<-interrupts
+		fmt.Fprintf(os.Stderr, "Cancelling job %s\n", resp.ID)
+		if _, err := client.Cancel(resp.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to cancel job: %v\n", err)
+		}
+		<-interrupts
+		os.Exit(130)
+	}()
+
+	return followLogs(client, resp.ID, restapi.LogOptions{}, *settings.output)
+}
+
+func runList(args []string) error {
+	flags, settings := clientFlags("list", "list [flags]")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	jobs, err := client.List()
+	if err != nil {
+		return err
+	}
+	if jobs == nil {
+		jobs = []string{}
+	}
+	return settings.output.print(restapi.ListJobsResponse{Jobs: jobs}, func(w io.Writer) {
+		for _, jobID := range jobs {
+			fmt.Fprintln(w, jobID)
+		}
+	})
+}
+
+func runEnvironments(args []string) error {
+	flags, settings := clientFlags("environments", "environments [flags]")
+	repo := flags.String("repo", "", "Only show deployments of this repository")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	environments, err := client.Environments(*repo)
+	if err != nil {
+		return err
+	}
+	if environments == nil {
+		environments = []restapi.EnvironmentResponse{}
+	}
+	return settings.output.print(restapi.EnvironmentsResponse{Environments: environments}, func(w io.Writer) {
+		printEnvironments(w, environments)
+	})
+}
+
+func runRollback(args []string) error {
+	flags, settings := clientFlags("rollback", "rollback [flags] <environment>")
+	repo := flags.String("repo", "", "Repository to roll back, required if several deploy to the environment")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	job, err := client.Rollback(flags.Arg(0), *repo)
+	if err != nil {
+		return err
+	}
+	return settings.output.print(job, func(w io.Writer) {
+		fmt.Fprintln(w, job.ID)
+	})
+}
+
+func runRerun(args []string) error {
+	flags, settings := clientFlags("rerun", "rerun [flags] <job-id>")
+	commit := flags.String("commit", "", "Commit, branch or tag to check out instead of the job's")
+	cmd := flags.String("command", "", "Command to run instead of the job's")
+	name := flags.String("name", "", "Name or description of the new job, instead of the job's")
+	image := flags.String("image", "", "Container image to run the command in, instead of the job's")
+	env := labelFlags{}
+	flags.Var(env, "env", "Environment variable for the command as KEY=value, replacing the job's. May be repeated")
+	inputs := labelFlags{}
+	flags.Var(inputs, "input", "Value for an input as name=value, replacing the job's. May be repeated")
+	labels := labelFlags{}
+	flags.Var(labels, "label", "Label to attach to the job as key=value, replacing the job's. May be repeated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	req := restapi.RerunRequest{Commit: *commit, Command: *cmd, Name: *name, Image: *image}
+	if len(env) > 0 {
+		req.Env = env
+	}
+	if len(inputs) > 0 {
+		req.Inputs = inputs
+	}
+	if len(labels) > 0 {
+		req.Labels = labels
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	job, err := client.Rerun(flags.Arg(0), req)
+	if err != nil {
+		return err
+	}
+	return settings.output.print(job, func(w io.Writer) {
+		fmt.Fprintln(w, job.ID)
+	})
+}
+
+func printEnvironments(w io.Writer, environments []restapi.EnvironmentResponse) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, environment := range environments {
+		for _, deployment := range environment.Deployments {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", environment.Name, deployment.RepoURI, deployment.Commit, deployment.JobID, deployment.DeployedAt.Format(time.RFC3339))
+		}
+	}
+	tw.Flush()
+}
+
+func printJob(w io.Writer, job restapi.JobResponse) {
+	fmt.Fprintf(w, "ID:      %s\n", job.ID)
+	fmt.Fprintf(w, "Status:  %s\n", job.Status)
+	if job.FailedPhase != "" && job.Status == string(minici.JobStatusFailure) {
+		if job.TimedOut {
+			fmt.Fprintf(w, "Failed:  %s timed out\n", job.FailedPhase)
+		} else if job.Stalled {
+			fmt.Fprintf(w, "Failed:  %s stalled\n", job.FailedPhase)
+		} else {
+			fmt.Fprintf(w, "Failed:  in %s\n", job.FailedPhase)
+		}
+	}
+	fmt.Fprintf(w, "Repo:    %s\n", job.RepoURI)
+	fmt.Fprintf(w, "Commit:  %s\n", job.Commit)
+	if job.Branch != "" {
+		fmt.Fprintf(w, "Branch:  %s\n", job.Branch)
+	}
+	fmt.Fprintf(w, "Command: %s\n", job.Command)
+	if job.Number > 0 {
+		fmt.Fprintf(w, "Build:   %s\n", minici.Job{RepoURI: job.RepoURI, Number: job.Number}.Reference())
+	}
+	if job.Name != "" {
+		fmt.Fprintf(w, "Name:    %s\n", job.Name)
+	}
+	if job.Project != "" {
+		fmt.Fprintf(w, "Project: %s\n", job.Project)
+	}
+	if job.Environment != "" {
+		fmt.Fprintf(w, "Deploys: %s\n", job.Environment)
+	}
+	if job.Group != "" {
+		fmt.Fprintf(w, "Group:   %s\n", job.Group)
+	}
+	if pr := job.PullRequest; pr != nil {
+		fmt.Fprintf(w, "PR:      #%d %s into %s by %s\n", pr.Number, pr.SourceBranch, pr.TargetBranch, pr.Author)
+	}
+	if job.Image != "" {
+		fmt.Fprintf(w, "Image:   %s\n", job.Image)
+	}
+	if len(job.RunsOn) > 0 {
+		fmt.Fprintf(w, "Runs on: %s\n", strings.Join(job.RunsOn, ", "))
+	}
+	for _, need := range job.Needs {
+		if len(need.Artifacts) > 0 {
+			fmt.Fprintf(w, "Needs:   %s (artifacts %s)\n", need.Job, strings.Join(need.Artifacts, ", "))
+		} else {
+			fmt.Fprintf(w, "Needs:   %s\n", need.Job)
+		}
+	}
+	if job.Agent != "" {
+		fmt.Fprintf(w, "Agent:   %s\n", job.Agent)
+	}
+	if job.Progress != nil {
+		var parts []string
+		if job.Progress.Percent != nil {
+			parts = append(parts, fmt.Sprintf("%.0f%%", *job.Progress.Percent))
+		}
+		if job.Progress.Message != "" {
+			parts = append(parts, job.Progress.Message)
+		}
+		parts = append(parts, fmt.Sprintf("(heartbeat at %s)", job.Progress.UpdatedAt.Format(time.RFC3339)))
+		fmt.Fprintf(w, "Progress: %s\n", strings.Join(parts, " "))
+	}
+	if job.RequeuedFrom != "" {
+		fmt.Fprintf(w, "Requeued from: %s (attempt %d)\n", job.RequeuedFrom, job.Attempt)
+	}
+	if job.Pinned {
+		fmt.Fprintln(w, "Pinned:  artifacts are kept")
+	}
+	if job.Urgent {
+		fmt.Fprintln(w, "Urgent:  starts during maintenance windows")
+	}
+	keys := make([]string, 0, len(job.Labels))
+	for key := range job.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "Label:   %s=%s\n", key, job.Labels[key])
+	}
+	inputs := make([]string, 0, len(job.Inputs))
+	for key := range job.Inputs {
+		inputs = append(inputs, key)
+	}
+	sort.Strings(inputs)
+	for _, key := range inputs {
+		fmt.Fprintf(w, "Input:   %s=%s\n", key, job.Inputs[key])
+	}
+	outputs := make([]string, 0, len(job.Outputs))
+	for key := range job.Outputs {
+		outputs = append(outputs, key)
+	}
+	sort.Strings(outputs)
+	for _, key := range outputs {
+		fmt.Fprintf(w, "Output:  %s=%s\n", key, job.Outputs[key])
+	}
+	if len(job.Annotations) > 0 {
+		var errorCount, warningCount int
+		var first *restapi.AnnotationResponse
+		for i, annotation := range job.Annotations {
+			if annotation.Severity == string(minici.SeverityError) {
+				errorCount++
+				if first == nil {
+					first = &job.Annotations[i]
+				}
+			} else {
+				warningCount++
+			}
+		}
+		fmt.Fprintf(w, "Problems: %d errors, %d warnings\n", errorCount, warningCount)
+		if first != nil {
+			// Numbered from 1, as in the dashboard
+			fmt.Fprintf(w, "First error: line %d: %s\n", first.Line+1, first.Message)
+		}
+	}
+	if job.Coverage != nil {
+		fmt.Fprintf(w, "Coverage: %.1f%%\n", *job.Coverage)
+	}
+	for _, hook := range job.Hooks {
+		name := hook.Name
+		if name == "" {
+			name = hook.Run
+		}
+		fmt.Fprintf(w, "Hook:    %s (%s): %s\n", name, hook.Phase, hook.Outcome)
+	}
+	if job.Tests != nil {
+		fmt.Fprintf(w, "Tests:   %d passed, %d failed, %d skipped\n", job.Tests.Passed, job.Tests.Failed, job.Tests.Skipped)
+		for _, failure := range job.Tests.Failures {
+			name := failure.Name
+			if failure.Suite != "" {
+				name = failure.Suite + " " + name
+			}
+			fmt.Fprintf(w, "Failed:  %s\n", name)
+		}
+	}
+}
+
+func runStatus(args []string) error {
+	flags, settings := clientFlags("status", "status [flags] [job-id]")
+	jobID, err := parseJobIDOrPick(flags, args, settings)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	job, err := client.Status(jobID)
+	if err != nil {
+		return err
+	}
+	return settings.output.print(job, func(w io.Writer) {
+		printJob(w, job)
+	})
+}
+
+func runLogs(args []string) error {
+	flags, settings := clientFlags("logs", "logs [flags] [job-id]")
+	follow := flags.Bool("follow", false, "Stream new log lines until the job completes, exiting non-zero if it didn't succeed")
+	flags.BoolVar(follow, "f", false, "Shorthand for --follow")
+	timestamps := flags.String("timestamps", "", "Prefix each line with when it was logged: absolute for the UTC time, or elapsed for the time since the job started")
+	ansi := flags.String("ansi", "", "What to do with color escape sequences: keep them (the default), strip them, or html to render them as HTML")
+	jobID, err := parseJobIDOrPick(flags, args, settings)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	opts := restapi.LogOptions{Timestamps: *timestamps, ANSI: *ansi}
+	if *follow {
+		return followLogs(client, jobID, opts, *settings.output)
+	}
+
+	logs, err := client.LogsWithOptions(jobID, opts)
+	if err != nil {
+		return err
+	}
+	if logs == nil {
+		logs = []string{}
+	}
+	return settings.output.print(LogsOutput{ID: jobID, Logs: logs}, func(w io.Writer) {
+		for _, line := range logs {
+			fmt.Fprintln(w, line)
+		}
+	})
+}
+
+// followLogs prints a job's logs as they are produced and returns an exit code
+// matching the job's outcome. Machine readable output is written as a stream of
+// LogEvents.
+func followLogs(client *restapi.Client, jobID string, opts restapi.LogOptions, output outputFormat) error {
+	var writeErr error
+	status, err := client.FollowLogsWithOptions(jobID, opts, func(line string) {
+		if writeErr == nil {
+			writeErr = output.event(os.Stdout, LogEvent{Event: "log", Line: line})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	if output != outputTable {
+		job, err := client.Status(jobID)
+		if err != nil {
+			return err
+		}
+		if err := output.event(os.Stdout, LogEvent{Event: "done", Job: &job}); err != nil {
+			return err
+		}
+	}
+	if status != string(minici.JobStatusSuccess) {
+		fmt.Fprintf(os.Stderr, "Job %s finished with status %s\n", jobID, status)
+		return exitCode(1)
+	}
+	return nil
+}
+
+func runCancel(args []string) error {
+	flags, settings := clientFlags("cancel", "cancel [flags] <job-id>")
+	jobID, err := parseJobID(flags, args)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	job, err := client.Cancel(jobID)
+	if err != nil {
+		return err
+	}
+	return settings.output.print(job, func(w io.Writer) {
+		// Cancellation is asynchronous, so the job may still be running briefly
+		fmt.Fprintf(w, "Cancelling job %s\n", job.ID)
+	})
+}
+
+func runPin(args []string) error {
+	flags, settings := clientFlags("pin", "pin [flags] <job-id>")
+	remove := flags.Bool("remove", false, "Unpin the job, letting retention remove its artifacts again")
+	jobID, err := parseJobID(flags, args)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	job, err := client.Pin(jobID, !*remove)
+	if err != nil {
+		return err
+	}
+	return settings.output.print(job, func(w io.Writer) {
+		if job.Pinned {
+			fmt.Fprintf(w, "Pinned job %s\n", job.ID)
+		} else {
+			fmt.Fprintf(w, "Unpinned job %s\n", job.ID)
+		}
+	})
+}
+
+func runGroup(args []string) error {
+	flags, settings := clientFlags("group", "group [flags] <group-id>")
+	wait := flags.Bool("wait", false, "Wait for every job in the group to finish")
+	cancel := flags.Bool("cancel", false, "Cancel the group's pending and running jobs")
+	id, err := parseJobID(flags, args)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+	var group restapi.GroupResponse
+	switch {
+	case *cancel:
+		group, err = client.CancelGroup(id)
+	case *wait:
+		group, err = waitRepeatedly(func() (restapi.GroupResponse, error) {
+			return client.WaitGroup(id, 4*time.Minute)
+		})
+	default:
+		group, err = client.Group(id)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = settings.output.print(group, func(w io.Writer) {
+		printGroup(w, group)
+	})
+	if err != nil {
+		return err
+	}
+	if *wait && group.Status != string(minici.JobStatusSuccess) {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// waitRepeatedly calls wait until it doesn't time out, as the server's waits
+// are limited to a few minutes
+func waitRepeatedly(wait func() (restapi.GroupResponse, error)) (restapi.GroupResponse, error) {
+	for {
+		group, err := wait()
+		var apiErr *restapi.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusRequestTimeout {
+			return group, err
+		}
+	}
+}
+
+// printGroup describes a group's status and lists its jobs
+func printGroup(w io.Writer, group restapi.GroupResponse) {
+	statuses := make([]string, 0, len(group.Counts))
+	for status := range group.Counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	counts := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		counts = append(counts, fmt.Sprintf("%d %s", group.Counts[status], status))
+	}
+	fmt.Fprintf(w, "Group %s is %s (%s)\n", group.ID, group.Status, strings.Join(counts, ", "))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, job := range group.Jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", job.ID, job.Commit, job.Status)
+	}
+	tw.Flush()
+}
+
+func runWait(args []string) error {
+	flags, settings := clientFlags("wait", "wait [flags] [job-id...]")
+	interval := flags.Duration("interval", time.Second, "How often to poll job status when waiting for specific jobs")
+	group := flags.String("group", "", "Wait for the jobs in a group")
+	labels := labelFlags{}
+	flags.Var(labels, "label", "Wait for the jobs with a label, as key=value. May be repeated")
+	since := flags.String("since", "", "With -group or -label, only wait for jobs created after an RFC 3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filtered := *group != "" || len(labels) > 0
+	if (filtered && flags.NArg() > 0) || (*since != "" && !filtered) {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	client, err := settings.Client()
+	if err != nil {
+		return err
+	}
+
+	var result WaitOutput
+	if filtered {
+		query := url.Values{}
+		if *group != "" {
+			query.Set("group", *group)
+		}
+		for key, value := range labels {
+			query.Add("label", key+"="+value)
+		}
+		if *since != "" {
+			query.Set("since", *since)
+		}
+		matched, err := waitRepeatedly(func() (restapi.GroupResponse, error) {
+			return client.WaitMatching(query, 4*time.Minute)
+		})
+		if err != nil {
+			return err
+		}
+		result = WaitOutput{Success: matched.Status == string(minici.JobStatusSuccess), Jobs: matched.Jobs}
+	} else if flags.NArg() == 0 {
+		ok, message, err := client.WaitAll()
+		if err != nil {
+			return err
+		}
+		result = WaitOutput{Success: ok, Message: message}
+	} else {
+		jobs, err := client.WaitJobs(flags.Args(), *interval)
+		if err != nil {
+			return err
+		}
+		result = WaitOutput{Success: true, Jobs: jobs}
+		for _, job := range jobs {
+			if job.Status != string(minici.JobStatusSuccess) {
+				result.Success = false
+			}
+		}
+	}
+
+	err = settings.output.print(result, func(w io.Writer) {
+		if result.Message != "" {
+			fmt.Fprintln(w, result.Message)
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, job := range result.Jobs {
+			fmt.Fprintf(tw, "%s\t%s\n", job.ID, job.Status)
+		}
+		tw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return exitCode(1)
+	}
+	return nil
+}
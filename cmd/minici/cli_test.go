@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici/restapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout runs fn with os.Stdout sent to a pipe, returning what it wrote
+func captureStdout(t *testing.T, fn func()) string {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	var output bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&output, reader)
+		close(copied)
+	}()
+	fn()
+	writer.Close()
+	<-copied
+	return output.String()
+}
+
+func TestRun(t *testing.T) {
+	client := newAgentTestClient(t)
+	t.Setenv("MINICI_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("MINICI_PROFILE", "")
+	t.Setenv("MINICI_SERVER", client.Server)
+	t.Setenv("MINICI_TOKEN", "")
+
+	// The test plays the agent that runs the job run schedules
+	agent := func(lines []string, status string) {
+		var job *restapi.AgentJobResponse
+		claimed := assert.Eventually(t, func() bool {
+			var err error
+			job, err = client.ClaimJob("laptop", restapi.AgentRequest{})
+			return err == nil && job != nil
+		}, 5*time.Second, 10*time.Millisecond)
+		if !claimed {
+			return
+		}
+		assert.Equal(t, "https://github.com/ocuroot/minici", job.RepoURI)
+		assert.Equal(t, "main", job.Commit)
+		assert.Equal(t, "make test", job.Command)
+		assert.NoError(t, client.ReportLogs("laptop", job.ID, lines))
+		assert.NoError(t, client.FinishJob("laptop", job.ID, restapi.AgentFinishRequest{Status: status}))
+	}
+	run := func() error {
+		return runRun([]string{"--repo", "https://github.com/ocuroot/minici", "--commit", "main", "make", "test"})
+	}
+
+	var err error
+	go agent([]string{"ok 1", "ok 2"}, "success")
+	output := captureStdout(t, func() { err = run() })
+	assert.NoError(t, err)
+	assert.Contains(t, output, "ok 1\nok 2\n")
+
+	// run exits non-zero when the job fails
+	go agent([]string{"FAIL"}, "failure")
+	output = captureStdout(t, func() { err = run() })
+	assert.Equal(t, exitCode(1), err)
+	assert.Contains(t, output, "FAIL\n")
+}
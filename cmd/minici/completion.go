@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const bashCompletion = `# bash completion for minici
+_minici() {
+    local IFS=$'\n'
+    COMPREPLY=($(minici __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _minici minici
+`
+
+const zshCompletion = `#compdef minici
+_minici() {
+    local -a candidates
+    candidates=(${(f)"$(minici __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    if (( ${#candidates} )); then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+compdef _minici minici
+`
+
+const fishCompletion = `# fish completion for minici
+function __minici_complete
+    set -l tokens (commandline -opc)
+    set -l current (commandline -ct)
+    minici __complete $tokens[2..-1] "$current" 2>/dev/null
+end
+complete -c minici -f -a '(__minici_complete)'
+`
+
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: minici completion bash|zsh|fish\n\n")
+		fmt.Fprintf(os.Stderr, "Load completions in the current shell with, for example:\n  source <(minici completion bash)\n")
+		return exitCode(2)
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", args[0])
+	}
+	return nil
+}
+
+// runComplete prints completions for the final word of a partial command line,
+// one per line. It is called by the completion scripts.
+func runComplete(args []string) error {
+	for _, candidate := range complete(args) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// complete returns the candidates for the last of words, which are the
+// arguments following "minici"
+func complete(words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	current, previous := words[len(words)-1], words[:len(words)-1]
+
+	if len(previous) == 0 {
+		var names []string
+		for _, cmd := range commands() {
+			if !cmd.Hidden {
+				names = append(names, cmd.Name)
+			}
+		}
+		return withPrefix(names, current)
+	}
+
+	var cmd *command
+	for _, c := range commands() {
+		if c.Name == previous[0] {
+			cmd = &c
+			break
+		}
+	}
+	if cmd == nil {
+		return nil
+	}
+
+	switch previous[len(previous)-1] {
+	case "--output", "-output", "-o", "--o":
+		return withPrefix([]string{string(outputTable), string(outputJSON), string(outputYAML)}, current)
+	case "--profile", "-profile":
+		return withPrefix(profileNames(), current)
+	}
+	if cmd.Name == "completion" && len(previous) == 1 {
+		return withPrefix([]string{"bash", "zsh", "fish"}, current)
+	}
+	if !cmd.JobArgs || strings.HasPrefix(current, "-") {
+		return nil
+	}
+
+	client, err := completionSettings(previous[1:]).Client()
+	if err != nil {
+		return nil
+	}
+	jobs, err := client.List()
+	if err != nil {
+		return nil
+	}
+	// Job IDs are ULIDs, so the most recent come first when sorted in reverse
+	sort.Sort(sort.Reverse(sort.StringSlice(jobs)))
+	return withPrefix(jobs, current)
+}
+
+// completionSettings extracts the connection flags from a partial command line
+func completionSettings(args []string) *clientSettings {
+	settings := &clientSettings{profile: os.Getenv("MINICI_PROFILE")}
+	targets := map[string]*string{
+		"profile": &settings.profile,
+		"server":  &settings.flags.Server,
+		"token":   &settings.flags.Token,
+	}
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		target, ok := targets[name]
+		if !ok || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				continue
+			}
+			value = args[i+1]
+		}
+		*target = value
+	}
+	return settings
+}
+
+// profileNames returns the profiles in the CLI config file
+func profileNames() []string {
+	config, err := loadCLIConfig(cliConfigPath())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func withPrefix(candidates []string, prefix string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
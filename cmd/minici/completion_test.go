@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCompletedJobsClient returns a client for a server with three finished
+// jobs, restored from a backup so their IDs are known
+func newCompletedJobsClient(t *testing.T) *restapi.Client {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	var jobs []minici.BackupJob
+	for _, job := range []minici.Job{
+		{ID: "01A", RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "go test ./..."},
+		{ID: "01B", RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "go vet ./..."},
+		{ID: "02A", RepoURI: "https://github.com/ocuroot/other", Commit: "main", Command: "make"},
+	} {
+		job.Status = minici.JobStatusSuccess
+		jobs = append(jobs, minici.BackupJob{Job: job})
+	}
+	require.NoError(t, ci.(minici.Restorer).Restore(jobs))
+	srv := httptest.NewServer(restapi.NewServer(ci, ":0"))
+	t.Cleanup(srv.Close)
+	return restapi.NewClient(srv.URL, "")
+}
+
+func TestComplete(t *testing.T) {
+	client := newCompletedJobsClient(t)
+
+	t.Setenv("MINICI_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("MINICI_PROFILE", "")
+	t.Setenv("MINICI_SERVER", client.Server)
+	t.Setenv("MINICI_TOKEN", "")
+
+	assert.Equal(t, []string{"serve", "submit", "status"}, complete([]string{"s"}))
+	assert.NotContains(t, complete([]string{""}), "__complete")
+	assert.Equal(t, []string{"json"}, complete([]string{"list", "--output", "j"}))
+	assert.Equal(t, []string{"zsh"}, complete([]string{"completion", "z"}))
+
+	assert.Equal(t, []string{"02A", "01B", "01A"}, complete([]string{"logs", ""}))
+	assert.Equal(t, []string{"01B", "01A"}, complete([]string{"status", "--output", "json", "01"}))
+	assert.Empty(t, complete([]string{"submit", ""}))
+
+	t.Setenv("MINICI_SERVER", "http://127.0.0.1:1")
+	assert.Equal(t, []string{"02A", "01B", "01A"}, complete([]string{"cancel", "--server", client.Server, ""}))
+	assert.Empty(t, complete([]string{"cancel", ""}))
+}
+
+func TestPickJob(t *testing.T) {
+	client := newCompletedJobsClient(t)
+
+	t.Run("number", func(t *testing.T) {
+		var out bytes.Buffer
+		jobID, err := pickJob(client, strings.NewReader("2\n"), &out)
+		require.NoError(t, err)
+		// The most recent jobs are listed first
+		assert.Equal(t, "01B", jobID)
+		assert.Contains(t, out.String(), "go vet ./...")
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		var out bytes.Buffer
+		jobID, err := pickJob(client, strings.NewReader("gtst\n\n"), &out)
+		require.NoError(t, err)
+		assert.Equal(t, "01A", jobID)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		var out bytes.Buffer
+		jobID, err := pickJob(client, strings.NewReader("zzz\n3\n"), &out)
+		require.NoError(t, err)
+		assert.Equal(t, "01A", jobID)
+		assert.Contains(t, out.String(), `No jobs match "zzz"`)
+	})
+
+	t.Run("eof", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := pickJob(client, strings.NewReader(""), &out)
+		assert.Error(t, err)
+	})
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	assert.True(t, fuzzyMatch("gvt", "go vet ./..."))
+	assert.True(t, fuzzyMatch("MINI", "ocuroot/minici"))
+	assert.False(t, fuzzyMatch("tv", "go vet"))
+}
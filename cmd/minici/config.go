@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// CLIConfig is the file format for configuring the command line client
+type CLIConfig struct {
+	// CurrentProfile is used when no profile is selected with --profile or $MINICI_PROFILE.
+	// If empty, a profile named "default" is used if there is one.
+	CurrentProfile string             `yaml:"current_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds the settings for a single server
+type Profile struct {
+	Server string `yaml:"server"`
+	Token  string `yaml:"token"`
+	// Repo is the repository used by submit and run when --repo isn't given
+	Repo string `yaml:"repo"`
+}
+
+// cliConfigPath returns the location of the CLI config file, which can be
+// overridden with $MINICI_CONFIG
+func cliConfigPath() string {
+	if path := os.Getenv("MINICI_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "minici", "config.yaml")
+}
+
+// loadCLIConfig reads the CLI config file. A missing file is treated as empty.
+func loadCLIConfig(path string) (CLIConfig, error) {
+	var config CLIConfig
+	if path == "" {
+		return config, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read CLI config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("failed to parse CLI config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Profile returns the named profile, falling back to the current profile if
+// name is empty. It is an error to name a profile that doesn't exist.
+func (c CLIConfig) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.CurrentProfile
+	}
+	if name == "" {
+		return c.Profiles["default"], nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// override replaces any settings in p that are set in other
+func (p Profile) override(other Profile) Profile {
+	if other.Server != "" {
+		p.Server = other.Server
+	}
+	if other.Token != "" {
+		p.Token = other.Token
+	}
+	if other.Repo != "" {
+		p.Repo = other.Repo
+	}
+	return p
+}
+
+// envProfile returns the settings provided by environment variables
+func envProfile() Profile {
+	return Profile{
+		Server: os.Getenv("MINICI_SERVER"),
+		Token:  os.Getenv("MINICI_TOKEN"),
+		Repo:   os.Getenv("MINICI_REPO"),
+	}
+}
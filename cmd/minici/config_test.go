@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+current_profile: staging
+profiles:
+  staging:
+    server: https://ci.staging.example.com
+    token: staging-token
+    repo: https://github.com/ocuroot/minici
+  prod:
+    server: https://ci.example.com
+    token: prod-token
+`), 0o600)
+	require.NoError(t, err)
+
+	t.Setenv("MINICI_CONFIG", path)
+	t.Setenv("MINICI_PROFILE", "")
+	t.Setenv("MINICI_SERVER", "")
+	t.Setenv("MINICI_TOKEN", "")
+	t.Setenv("MINICI_REPO", "")
+
+	resolve := func(args ...string) (Profile, error) {
+		flags, settings := clientFlags("test", "test")
+		require.NoError(t, flags.Parse(args))
+		return settings.Resolve()
+	}
+
+	t.Run("current profile", func(t *testing.T) {
+		profile, err := resolve()
+		require.NoError(t, err)
+		assert.Equal(t, "https://ci.staging.example.com", profile.Server)
+		assert.Equal(t, "staging-token", profile.Token)
+		assert.Equal(t, "https://github.com/ocuroot/minici", profile.Repo)
+	})
+
+	t.Run("profile flag", func(t *testing.T) {
+		profile, err := resolve("--profile", "prod")
+		require.NoError(t, err)
+		assert.Equal(t, "https://ci.example.com", profile.Server)
+		assert.Equal(t, "prod-token", profile.Token)
+		assert.Empty(t, profile.Repo)
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		t.Setenv("MINICI_SERVER", "https://env.example.com")
+		t.Setenv("MINICI_TOKEN", "env-token")
+
+		profile, err := resolve("--token", "flag-token")
+		require.NoError(t, err)
+		assert.Equal(t, "https://env.example.com", profile.Server)
+		assert.Equal(t, "flag-token", profile.Token)
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := resolve("--profile", "missing")
+		assert.ErrorContains(t, err, `unknown profile "missing"`)
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		t.Setenv("MINICI_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+		profile, err := resolve()
+		require.NoError(t, err)
+		assert.Equal(t, defaultServer, profile.Server)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ocuroot/minici"
+)
+
+// loadKeyring loads the encryption keys in path, or returns nil if it's empty
+func loadKeyring(path string) (*minici.Keyring, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return minici.LoadKeyring(path)
+}
+
+// runEncryptFile encrypts files in place, such as a secrets file, or
+// re-encrypts them with the first key to rotate keys
+func runEncryptFile(args []string) error {
+	flags := flag.NewFlagSet("encrypt-file", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: minici encrypt-file --key-file <file> <file>...\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	keyFile := flags.String("key-file", "", "File of base64 encoded 32 byte keys, one per line. Files are encrypted with the first key, and may have been encrypted with any of them")
+	decrypt := flags.Bool("decrypt", false, "Decrypt the files instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return fmt.Errorf("--key-file is required")
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no files given")
+	}
+	keyring, err := minici.LoadKeyring(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range flags.Args() {
+		if err := encryptFile(keyring, path, *decrypt); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// encryptFile replaces a file with its encrypted or decrypted contents,
+// decrypting it first if it was already encrypted
+func encryptFile(keyring *minici.Keyring, path string, decrypt bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if minici.IsEncrypted(content) {
+		if content, err = keyring.Decrypt(content); err != nil {
+			return err
+		}
+	}
+	if !decrypt {
+		if content, err = keyring.Encrypt(content); err != nil {
+			return err
+		}
+		content = append(content, '\n')
+	}
+
+	// Write a copy and rename it over the original, so the file is never
+	// left half written
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(info.Mode().Perm()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
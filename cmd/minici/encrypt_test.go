@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptFile(t *testing.T) {
+	dir := t.TempDir()
+	oldKeys := filepath.Join(dir, "old.keys")
+	newKeys := filepath.Join(dir, "new.keys")
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, os.WriteFile(oldKeys, []byte(oldKey+"\n"), 0o600))
+	require.NoError(t, os.WriteFile(newKeys, []byte(newKey+"\n"+oldKey+"\n"), 0o600))
+	secrets := filepath.Join(dir, "secrets.yaml")
+	plain := "registries:\n  ghcr.io:\n    password: hunter2\n"
+	require.NoError(t, os.WriteFile(secrets, []byte(plain), 0o600))
+
+	require.NoError(t, runEncryptFile([]string{"--key-file", oldKeys, secrets}))
+	encrypted, err := os.ReadFile(secrets)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "hunter2")
+	info, err := os.Stat(secrets)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Rotating re-encrypts with the new key, so the old one can be dropped
+	require.NoError(t, runEncryptFile([]string{"--key-file", newKeys, secrets}))
+	assert.Error(t, runEncryptFile([]string{"--key-file", oldKeys, "--decrypt", secrets}))
+	require.NoError(t, runEncryptFile([]string{"--key-file", newKeys, "--decrypt", secrets}))
+	decrypted, err := os.ReadFile(secrets)
+	require.NoError(t, err)
+	assert.Equal(t, plain, string(decrypted))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// listenOn opens a listener on address, either host:port or unix: followed by
+// the path of a Unix socket to create
+func listenOn(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		// A socket left behind by a previous run would stop it being created
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// sideServers serves endpoints, such as the admin API, on listeners besides
+// the API's. Endpoints given the same address share a listener.
+type sideServers struct {
+	addresses []string
+	muxes     map[string]*http.ServeMux
+	// tls is set for listeners serving an endpoint that needs the API's TLS
+	// certificate
+	tls map[string]bool
+}
+
+// handle serves handler at pattern on the listener for address
+func (s *sideServers) handle(address, pattern string, handler http.Handler, tls bool) {
+	if s.muxes == nil {
+		s.muxes = make(map[string]*http.ServeMux)
+		s.tls = make(map[string]bool)
+	}
+	mux, ok := s.muxes[address]
+	if !ok {
+		mux = http.NewServeMux()
+		s.muxes[address] = mux
+		s.addresses = append(s.addresses, address)
+	}
+	mux.Handle(pattern, handler)
+	s.tls[address] = s.tls[address] || tls
+}
+
+// start opens each listener with open and serves its endpoints, with the TLS
+// certificate if set and any of them need it
+func (s *sideServers) start(open func(address string) (net.Listener, error), certFile, keyFile string) ([]*http.Server, error) {
+	var servers []*http.Server
+	for _, address := range s.addresses {
+		listener, err := open(address)
+		if err != nil {
+			for _, server := range servers {
+				server.Close()
+			}
+			return nil, err
+		}
+		server := &http.Server{Handler: s.muxes[address], ReadHeaderTimeout: 15 * time.Second}
+		servers = append(servers, server)
+		useTLS := s.tls[address] && certFile != ""
+		slog.Info("Side server starting", "address", listener.Addr().String(), "tls", useTLS)
+		go func() {
+			var err error
+			if useTLS {
+				err = server.ServeTLS(listener, certFile, keyFile)
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				slog.Error("Side server stopped", "address", address, "error", err)
+			}
+		}()
+	}
+	return servers, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSideServers(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	var side sideServers
+	side.handle("unix:"+socket, "/api/admin/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "admin")
+	}), true)
+	side.handle("unix:"+socket, "/debug/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "debug")
+	}), false)
+	servers, err := side.start(listenOn, "", "")
+	require.NoError(t, err)
+	require.Len(t, servers, 1)
+	t.Cleanup(func() { servers[0].Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	for path, want := range map[string]string{"/api/admin/reload": "admin", "/debug/goroutines": "debug"} {
+		resp, err := client.Get("http://minici" + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, want, string(body))
+	}
+
+}
+
+func TestListenOnStaleSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "minici.sock")
+	// A server that crashed leaves its socket behind
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenOn("unix:" + socket)
+	require.NoError(t, err)
+	listener.Close()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ocuroot/gittools"
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+)
+
+// runLocal runs a job in-process, without a server, printing its logs as they
+// are produced. This allows pipelines to be tried out before pushing them.
+func runLocal(args []string) error {
+	flags := flag.NewFlagSet("local", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: minici local [flags] [--] <command>\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	output := outputFlag(flags)
+	req, err := parseJobRequest(flags, args, func() (restapi.JobRequest, error) {
+		return restapi.JobRequest{RepoURI: ".", Commit: "HEAD"}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	repoURI, commit, err := resolveLocalRepo(req.RepoURI, req.Commit)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan minici.Job, 1)
+	ci := minici.NewCIServer(
+		minici.WithLogListener(func(_ minici.JobID, line string) {
+			output.event(os.Stdout, LogEvent{Event: "log", Line: line})
+		}),
+		minici.WithJobListener(func(event minici.JobEvent) {
+			if event.Job.Status.Done() {
+				done <- event.Job
+			}
+		}),
+	)
+
+	spec := req.Spec()
+	spec.RepoURI, spec.Commit = repoURI, commit
+	jobID, err := ci.Submit(spec)
+	if err != nil {
+		return err
+	}
+
+	// Interrupting cancels the job, then waits for it to stop.
+	// A second interrupt exits immediately.
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+	go func() {
+		<-interrupts
+		ci.CancelJob(jobID)
+		<-interrupts
+		os.Exit(130)
+	}()
+
+	job := <-done
+	if *output != outputTable {
+		response := restapi.NewJobResponse(job)
+		err := output.event(os.Stdout, LogEvent{Event: "done", Job: &response})
+		if err != nil {
+			return err
+		}
+	}
+	if job.Status != minici.JobStatusSuccess {
+		fmt.Fprintf(os.Stderr, "Job finished with status %s after %s\n", job.Status, job.Duration())
+		return exitCode(1)
+	}
+	fmt.Fprintf(os.Stderr, "Job succeeded after %s\n", job.Duration())
+	return nil
+}
+
+// resolveLocalRepo converts a repository on disk to an absolute path and its
+// ref to a commit hash, so HEAD refers to the local checkout rather than the
+// clone. Other URIs are returned unchanged.
+func resolveLocalRepo(repoURI, commit string) (string, string, error) {
+	if info, err := os.Stat(repoURI); err != nil || !info.IsDir() {
+		return repoURI, commit, nil
+	}
+
+	repo, err := gittools.Open(repoURI)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open repository %s: %w", repoURI, err)
+	}
+	hash, err := repo.RevParse("--verify", commit+"^{commit}")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve %s: %w", commit, err)
+	}
+	return repo.RepoPath, hash, nil
+}
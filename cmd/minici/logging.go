@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ocuroot/minici"
+)
+
+// loggingFlags registers the flags configuring the server's logs, returning a
+// function that builds the logger after parsing. Native logs are written to
+// as source.
+func loggingFlags(flags *flag.FlagSet) func(stderr io.Writer, source string) (*slog.Logger, error) {
+	level := flags.String("log-level", "info", "Least severe server log messages to write: debug, info, warn or error")
+	format := flags.String("log-format", "text", "Format of server log messages: text for key=value pairs, or json for one object per line")
+	output := flags.String("log-output", "stderr", "Where to write server log messages: stderr, syslog on Unix or eventlog for the Windows Event Log")
+	return func(stderr io.Writer, source string) (*slog.Logger, error) {
+		if *output == "stderr" {
+			return newLogger(stderr, *level, *format)
+		}
+		native, err := openNativeLog(*output, source)
+		if err != nil {
+			return nil, err
+		}
+		return newNativeLogger(native, *level, *format)
+	}
+}
+
+// newLogger builds a logger writing messages at level and above to w in format
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	options, err := handlerOptions(level)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := newHandler(w, format, options)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(handler), nil
+}
+
+func handlerOptions(level string) (*slog.HandlerOptions, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+	return &slog.HandlerOptions{Level: minLevel}, nil
+}
+
+func newHandler(w io.Writer, format string, options *slog.HandlerOptions) (slog.Handler, error) {
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+}
+
+// nativeLog is the platform's own log, such as syslog or the Windows Event
+// Log, which records when each message was written and its severity
+type nativeLog interface {
+	write(level slog.Level, message string) error
+}
+
+// newNativeLogger builds a logger writing messages at level and above to a
+// native log, formatted without the time and level the log records itself
+func newNativeLogger(native nativeLog, level, format string) (*slog.Logger, error) {
+	options, err := handlerOptions(level)
+	if err != nil {
+		return nil, err
+	}
+	options.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	writer := &nativeWriter{log: native}
+	handler, err := newHandler(writer, format, options)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(nativeHandler{Handler: handler, writer: writer}), nil
+}
+
+// nativeHandler formats records with a text or JSON handler, passing each
+// record's level to the native log along with the formatted message
+type nativeHandler struct {
+	slog.Handler
+	writer *nativeWriter
+}
+
+func (h nativeHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.writer.mutex.Lock()
+	defer h.writer.mutex.Unlock()
+	h.writer.level = record.Level
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h nativeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return nativeHandler{Handler: h.Handler.WithAttrs(attrs), writer: h.writer}
+}
+
+func (h nativeHandler) WithGroup(name string) slog.Handler {
+	return nativeHandler{Handler: h.Handler.WithGroup(name), writer: h.writer}
+}
+
+// nativeWriter writes each formatted record to the native log, at the level
+// of the record being handled. Handlers write each record in one call.
+type nativeWriter struct {
+	mutex sync.Mutex
+	log   nativeLog
+	level slog.Level
+}
+
+func (w *nativeWriter) Write(p []byte) (int, error) {
+	if err := w.log.write(w.level, strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// fatalf logs an error that stops the server starting and exits
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// logJobEvent logs each change to a job's status
+func logJobEvent(event minici.JobEvent) {
+	slog.Info("Job status changed",
+		"job_id", event.Job.ID,
+		"status", event.Job.Status,
+		"previous_status", event.PreviousStatus,
+		"repo", event.Job.RepoURI,
+	)
+}
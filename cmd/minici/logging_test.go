@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(&out, "warn", "json")
+	require.NoError(t, err)
+	logger.Info("Hidden")
+	logger.Warn("Shown", "job_id", "job-1")
+	assert.Contains(t, out.String(), `"msg":"Shown","job_id":"job-1"`)
+	assert.NotContains(t, out.String(), "Hidden")
+
+	out.Reset()
+	logger, err = newLogger(&out, "DEBUG", "text")
+	require.NoError(t, err)
+	logger.Debug("Shown", "job_id", "job-1")
+	assert.Contains(t, out.String(), "level=DEBUG msg=Shown job_id=job-1")
+
+	_, err = newLogger(&out, "verbose", "text")
+	assert.Error(t, err)
+	_, err = newLogger(&out, "info", "xml")
+	assert.Error(t, err)
+}
+
+// recordedLog is a native log that remembers what was written to it
+type recordedLog struct {
+	levels   []slog.Level
+	messages []string
+}
+
+func (l *recordedLog) write(level slog.Level, message string) error {
+	l.levels = append(l.levels, level)
+	l.messages = append(l.messages, message)
+	return nil
+}
+
+func TestNativeLogger(t *testing.T) {
+	native := &recordedLog{}
+	logger, err := newNativeLogger(native, "info", "text")
+	require.NoError(t, err)
+	logger.Debug("Hidden")
+	logger.With("job_id", "job-1").Warn("Job stalled")
+	logger.Error("Backup error", "error", "disk full")
+
+	assert.Equal(t, []slog.Level{slog.LevelWarn, slog.LevelError}, native.levels)
+	// The native log records the time and level itself
+	assert.Equal(t, []string{"msg=\"Job stalled\" job_id=job-1", "msg=\"Backup error\" error=\"disk full\""}, native.messages)
+}
@@ -1,23 +1,611 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+	"github.com/ocuroot/minici/notify"
+	"github.com/ocuroot/minici/restapi"
 )
 
 func main() {
-	port := flag.Int("port", 8080, "Port to listen on")
-	flag.Parse()
+	// With no subcommand, or only flags, run the server for backwards compatibility
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		serve(os.Args[1:])
+		return
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	if command == "serve" {
+		serve(args)
+		return
+	}
+
+	os.Exit(runClientCommand(command, args))
+}
+
+// serve runs the CI server with a REST API
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("MINICI_SERVER_CONFIG"), "Path to a YAML file of settings named after these flags. Defaults to $MINICI_SERVER_CONFIG. Every flag can also be set with $MINICI_<FLAG>, such as $MINICI_MAX_CONCURRENT_JOBS, which takes precedence over the file")
+	port := flags.Int("port", 8080, "Port to listen on")
+	listen := flags.String("listen", "", "Address to listen on, such as 127.0.0.1:8080, or unix: followed by a socket path. Overrides -port")
+	adminListen := flags.String("admin-listen", "", "Address to serve the admin API on instead of -listen, such as 127.0.0.1:8081 or unix:/run/minici/admin.sock, so it can be firewalled separately. May be the same as -debug-listen")
+	debugListen := flags.String("debug-listen", "", "Address to serve pprof profiles and goroutine dumps on, such as 127.0.0.1:6060. They aren't authenticated, so only listen where admins can reach. Disabled if empty")
+	serverURL := flags.String("server-url", "", "URL jobs run on the server use to reach it, such as to send heartbeats. Defaults to the notify config's base_url, then the listening address on localhost")
+	tlsCert := flags.String("tls-cert", "", "Certificate file to serve HTTPS with, used with -tls-key")
+	tlsKey := flags.String("tls-key", "", "Private key file for -tls-cert")
+	token := flags.String("token", "", "Bearer token required to access the API, also set with $MINICI_TOKEN. If empty, the API is unauthenticated")
+	readToken := flags.String("read-token", "", "Bearer token allowing read-only access to the API and dashboard, used with -token. Also set with $MINICI_READ_TOKEN")
+	notifyConfig := flags.String("notify-config", "", "Path to a YAML file configuring job notifications")
+	logExportConfig := flags.String("log-export-config", "", "Path to a YAML file configuring where to ship job logs, such as Loki, CloudWatch or syslog")
+	maxConcurrent := flags.Int("max-concurrent-jobs", 0, "Maximum number of jobs to run at once, further jobs are queued. 0 is unlimited")
+	maxQueued := flags.Int("max-queued-jobs", 0, "Maximum number of jobs that can wait to run, further jobs are rejected with 429 Too Many Requests. 0 is unlimited")
+	maxClones := flags.Int("max-concurrent-clones", 0, "Maximum number of repos to clone at once for jobs run on the server, further jobs wait to clone. 0 is unlimited")
+	minConcurrent := flags.Int("min-concurrent-jobs", 1, "Fewest jobs to run at once when -max-load or -min-free-memory hold back jobs")
+	maxLoad := flags.Float64("max-load", 0, "Load average per CPU above which fewer jobs are run at once, down to -min-concurrent-jobs. Requires -max-concurrent-jobs. 0 is unlimited")
+	minFreeMemory := flags.String("min-free-memory", "", "Free memory below which fewer jobs are run at once, such as 2GB, down to -min-concurrent-jobs. Requires -max-concurrent-jobs")
+	logDir := flags.String("log-dir", "", "Directory to write each job's logs to, instead of keeping them in memory. They're gzipped once the job is done")
+	workspaceDir := flags.String("workspace-dir", "", "Directory to clone repos into for jobs run on the server. Defaults to the system temp directory")
+	executorName := flags.String("executor", "container", "How to run jobs: container, to run jobs with an image in a container and others locally, kubernetes, or ssh")
+	containerRuntime := flags.String("container-runtime", "", "Container CLI used to run jobs that specify an image, such as docker or podman. Defaults to docker if installed, otherwise podman")
+	containerUserNS := flags.String("container-userns", "", "User namespace mode for job containers, passed to the runtime's --userns flag. Defaults to keep-id with podman, so the workspace stays owned by this user")
+	kubeContext := flags.String("kubernetes-context", "", "Kubeconfig context to create job pods with. Defaults to the current context")
+	kubeNamespace := flags.String("kubernetes-namespace", "", "Namespace to create job pods in. Defaults to the context's namespace")
+	kubeImage := flags.String("kubernetes-image", "", "Image for job pods when the job doesn't specify one")
+	kubeServiceAccount := flags.String("kubernetes-service-account", "", "Service account for job pods")
+	kubeCPU := flags.String("kubernetes-cpu", "", "CPU requested for and limiting each job pod, such as 500m")
+	kubeMemory := flags.String("kubernetes-memory", "", "Memory requested for and limiting each job pod, such as 1Gi")
+	sshHost := flags.String("ssh-host", "", "Host to run jobs on with the ssh executor, optionally as user@host")
+	sshPort := flags.Int("ssh-port", 0, "Port to connect to the ssh host on. Defaults to the ssh configuration's port")
+	sshIdentity := flags.String("ssh-identity", "", "Private key to authenticate to the ssh host with. Defaults to the ssh configuration's keys")
+	sshWorkDir := flags.String("ssh-workdir", "/tmp", "Directory on the ssh host to create job workspaces in")
+	// Options aren't split on commas, since values like cipher lists contain them
+	var sshOptions []string
+	flags.Func("ssh-option", "Extra ssh option, such as StrictHostKeyChecking=accept-new. May be repeated", func(value string) error {
+		sshOptions = append(sshOptions, value)
+		return nil
+	})
+	localAgent := flags.Bool("local-agent", true, "Run jobs on the server itself. Disable to only run jobs on remote agents")
+	var trustedProxies listFlag
+	flags.Var(&trustedProxies, "trusted-proxies", "CIDR ranges or addresses of reverse proxies whose X-Forwarded-For and X-Real-IP headers give the client's address, or unix for proxies connecting over a Unix socket. May be repeated or comma separated")
+	var agentLabels listFlag
+	flags.Var(&agentLabels, "labels", "Labels the server advertises for running jobs itself, in addition to its OS and architecture. May be repeated or comma separated")
+	agentTimeout := flags.Duration("agent-timeout", minici.DefaultAgentTimeout, "How long a remote agent can go without contacting the server before its jobs are marked interrupted")
+	jobLease := flags.Duration("job-lease", 0, "How long a remote agent can go without reporting on a job before the job is marked interrupted, even if the agent is still polling. Defaults to --agent-timeout")
+	requeueInterrupted := flags.Bool("requeue-interrupted", false, "Schedule interrupted jobs again so they can run on another agent")
+	maxRequeues := flags.Int("max-requeues", minici.DefaultMaxRequeues, "How many times a job that keeps being interrupted is requeued. Negative values requeue it every time")
+	clusterRedis := flags.String("cluster-redis", "", "Redis URL, such as redis://:password@host:6379/0, to share jobs with other servers using the same URL. Each queued job runs on one of them")
+	clusterInstance := flags.String("cluster-instance", "", "Name of this server in the cluster. Defaults to the host name")
+	queueRedis := flags.String("queue-redis", "", "Redis URL to dispatch jobs through a Redis stream, so jobs are received by whichever server using the same URL has a free agent")
+	agentTokensFile := flags.String("agent-tokens-file", "", "File to save agent tokens minted with the admin API to. If empty, they are lost on restart")
+	artifactDir := flags.String("artifact-dir", "", "Directory to save job artifacts to")
+	artifactBucket := flags.String("artifact-s3-bucket", "", "S3 bucket to save job artifacts to, instead of a directory. Credentials are read from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN")
+	artifactEndpoint := flags.String("artifact-s3-endpoint", "", "URL of an S3 compatible service such as MinIO. Defaults to AWS")
+	artifactRegion := flags.String("artifact-s3-region", os.Getenv("AWS_REGION"), "Region of the artifact bucket. Defaults to $AWS_REGION, or us-east-1")
+	artifactPrefix := flags.String("artifact-s3-prefix", "", "Prefix for artifact object names, such as minici/")
+	artifactPathStyle := flags.Bool("artifact-s3-path-style", false, "Put the bucket in the URL path instead of the host name, as MinIO requires")
+	artifactRetention := flags.String("artifact-retention", "", "Path to a YAML file limiting how long artifacts are kept")
+	cacheDir := flags.String("cache-dir", "", "Directory to keep build caches in. If empty, the caches in repo configs are ignored")
+	toolCaches := toolCacheFlags(flags)
+	templateStore := templateFlags(flags)
+	gitClient := gitFlags(flags)
+	timeouts := timeoutFlags(flags)
+	secretsFile := flags.String("secrets-file", "", "Path to a YAML file of registry credentials for pushing the images in repo configs")
+	encryptionKeyFile := flags.String("encryption-key-file", "", "File of base64 encoded 32 byte keys, one per line, to decrypt -secrets-file with and to encrypt logs with -encrypt-logs. The first key encrypts")
+	encryptLogs := flags.Bool("encrypt-logs", false, "Encrypt the job logs written to -log-dir, and the jobs and logs saved to -cluster-redis, with -encryption-key-file")
+	// Patterns aren't split on commas, since regular expressions can contain them
+	var annotationPatterns []minici.AnnotationPattern
+	flags.Func("annotate", "Mark job log lines matching a pattern, written as error=regexp or warning=regexp. May be repeated", func(value string) error {
+		pattern, err := minici.ParseAnnotationPattern(value)
+		if err != nil {
+			return err
+		}
+		annotationPatterns = append(annotationPatterns, pattern)
+		return nil
+	})
+	projectsConfig := flags.String("projects", "", "Path to a YAML file of projects, with the tokens limited to each project's jobs")
+	jobPolicy := flags.String("job-policy", "", "Path to a YAML file restricting the repos, commands, images and environment variables jobs can be scheduled with")
+	pullRequests := flags.String("pull-requests", "", "Path to a YAML file of repos to build pull requests for when GitHub or GitLab sends webhooks to /api/hooks/pull-requests")
+	maintenance := flags.String("maintenance", "", "Path to a YAML file of recurring maintenance windows, during which only urgent jobs start")
+	deliveryDir := flags.String("delivery-dir", "", "Directory to persist pending outbound deliveries to. If empty, deliveries are held in memory")
+	backupStore := backupStoreFlags(flags)
+	backupInterval := flags.Duration("backup-interval", minici.DefaultBackupInterval, "How often to back up the server's jobs and logs to -backup-dir or -backup-s3-bucket")
+	backupKeep := flags.Int("backup-keep", minici.DefaultBackupKeep, "How many backups to keep, removing the oldest")
+	backupArtifacts := flags.Bool("backup-artifacts", false, "Include the list of each job's artifacts in backups. The artifacts themselves stay in the artifact store")
+	restoreBackup := flags.String("restore-backup", "", "Name of a backup to restore jobs from on startup, or latest for the most recent one")
+	logger := loggingFlags(flags)
+	service := flags.String("service", "", "Run as the named service: a Windows service started by the service control manager, or a daemon such as under launchd that stops cleanly on SIGTERM. Also names the server in -log-output's log")
+	flags.Parse(args)
+
+	var serverConfig ServerConfig
+	if *configFile != "" {
+		var err error
+		serverConfig, err = loadServerConfig(*configFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	given := givenFlags(flags)
+	if err := applyServerConfig(flags, given, serverConfig, os.Getenv); err != nil {
+		fatalf("%v", err)
+	}
+	logSource := "minici"
+	if *service != "" {
+		logSource = *service
+	}
+	serverLogger, err := logger(os.Stderr, logSource)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	slog.SetDefault(serverLogger)
+
+	// systemd's variables are read before any job can start, so they aren't
+	// passed on to jobs' commands
+	notifier := newSystemdNotifier()
+	watchdog, err := watchdogInterval()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	activated, err := activationListeners()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// Sockets from systemd are used for the listener their
+	// FileDescriptorName names, or the API's if it doesn't
+	opened := map[string]net.Listener{}
+	publicSockets := 0
+	for _, socket := range activated {
+		socketAddress := socket.Addr().String()
+		opened[socketAddress] = socket
+		switch socket.name {
+		case "admin":
+			*adminListen = socketAddress
+		case "debug":
+			*debugListen = socketAddress
+		default:
+			*listen = socketAddress
+			publicSockets++
+		}
+	}
+	if publicSockets > 1 {
+		fatalf("expected one socket for the API from systemd, got %d", publicSockets)
+	}
+	openListener := func(address string) (net.Listener, error) {
+		if listener, ok := opened[address]; ok {
+			return listener, nil
+		}
+		return listenOn(address)
+	}
+
 	address := fmt.Sprintf(":%d", *port)
+	if *listen != "" {
+		address = *listen
+	}
+	if (*adminListen != "" && *adminListen == address) || (*debugListen != "" && *debugListen == address) {
+		fatalf("-admin-listen and -debug-listen must differ from the API's address")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatalf("-tls-cert and -tls-key must be used together")
+	}
 
-	ciServer := minici.NewCIServer()
-	server := NewRESTServer(ciServer, address)
+	config, err := loadNotifyConfig(*notifyConfig, serverConfig.Notify)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	targets, err := config.Targets()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	rules, err := config.RoutingRules()
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	deliveryConfig := delivery.DefaultConfig()
+	deliveryConfig.Dir = *deliveryDir
+	queue, err := delivery.NewQueue(deliveryConfig)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	queue.Start()
+	defer queue.Close()
+
+	var forwarder *minici.LogForwarder
+	if *logExportConfig != "" {
+		config, err := minici.LoadLogExportConfig(*logExportConfig)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		shippers, err := config.LogShippers()
+		if err != nil {
+			fatalf("%v", err)
+		}
+		forwarder = minici.NewLogForwarder(shippers...)
+		forwarder.OnError = func(err error) {
+			slog.Error("Failed to ship logs", "error", err)
+		}
+		forwarder.Start()
+		defer forwarder.Close()
+	}
+
+	var executor minici.Executor
+	switch *executorName {
+	case "container":
+		executor = &minici.ContainerExecutor{Runtime: *containerRuntime, UserNS: *containerUserNS}
+	case "kubernetes":
+		resources := map[string]string{}
+		if *kubeCPU != "" {
+			resources["cpu"] = *kubeCPU
+		}
+		if *kubeMemory != "" {
+			resources["memory"] = *kubeMemory
+		}
+		executor = &minici.KubernetesExecutor{
+			Context:        *kubeContext,
+			Namespace:      *kubeNamespace,
+			Image:          *kubeImage,
+			ServiceAccount: *kubeServiceAccount,
+			Resources:      minici.KubernetesResources{Requests: resources, Limits: resources},
+		}
+	case "ssh":
+		if *sshHost == "" {
+			fatalf("-ssh-host is required with the ssh executor")
+		}
+		executor = &minici.SSHExecutor{
+			Host:         *sshHost,
+			Port:         *sshPort,
+			IdentityFile: *sshIdentity,
+			Options:      sshOptions,
+			WorkDir:      *sshWorkDir,
+		}
+	default:
+		fatalf("unknown executor %q, expected container, kubernetes or ssh", *executorName)
+	}
+
+	var artifacts minici.ArtifactStore
+	switch {
+	case *artifactDir != "" && *artifactBucket != "":
+		fatalf("-artifact-dir and -artifact-s3-bucket can't be used together")
+	case *artifactDir != "":
+		artifacts = &minici.FileArtifactStore{Dir: *artifactDir}
+	case *artifactBucket != "":
+		artifacts = &minici.S3ArtifactStore{
+			Endpoint:        *artifactEndpoint,
+			Region:          *artifactRegion,
+			Bucket:          *artifactBucket,
+			Prefix:          *artifactPrefix,
+			PathStyle:       *artifactPathStyle,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	var retention minici.ArtifactRetention
+	if *artifactRetention != "" {
+		if artifacts == nil {
+			fatalf("-artifact-retention requires -artifact-dir or -artifact-s3-bucket")
+		}
+		retention, err = minici.LoadArtifactRetention(*artifactRetention)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
 
-	err := server.Start()
+	adaptive, err := adaptiveConcurrency(*maxConcurrent, *minConcurrent, *maxLoad, *minFreeMemory)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	keyring, err := loadKeyring(*encryptionKeyFile)
 	if err != nil {
-		log.Fatalf("%v", err)
+		fatalf("%v", err)
+	}
+	if *encryptLogs && keyring == nil {
+		fatalf("-encrypt-logs requires -encryption-key-file")
+	}
+	var logKeyring *minici.Keyring
+	if *encryptLogs {
+		logKeyring = keyring
+	}
+
+	dispatcher := notify.NewDispatcher(queue, config.BaseURL, targets)
+	dispatcher.SetRules(rules)
+
+	if *serverURL == "" {
+		*serverURL = config.BaseURL
+	}
+	if *serverURL == "" {
+		*serverURL = localServerURL(address, *tlsCert != "")
+	}
+
+	options := []minici.Option{
+		minici.WithJobListener(logJobEvent),
+		minici.WithJobListener(dispatcher.HandleJobEvent),
+		minici.WithMaxConcurrentJobs(*maxConcurrent),
+		minici.WithMaxQueuedJobs(*maxQueued),
+		minici.WithMaxConcurrentClones(*maxClones),
+		minici.WithTimeouts(timeouts()),
+		minici.WithServerURL(*serverURL),
+		minici.WithWorkspaceDir(*workspaceDir),
+		minici.WithExecutor(executor),
+		minici.WithLocalAgent(*localAgent),
+		minici.WithAgentLabels(agentLabels...),
+		minici.WithAgentTimeout(*agentTimeout),
+		minici.WithJobLease(*jobLease),
+		minici.WithRequeueInterrupted(*requeueInterrupted),
+		minici.WithMaxRequeues(*maxRequeues),
+		minici.WithArtifactStore(artifacts),
+		minici.WithAnnotationPatterns(annotationPatterns...),
+	}
+	if forwarder != nil {
+		options = append(options, minici.WithJobListener(forwarder.HandleJobEvent), minici.WithLogListener(forwarder.HandleLog))
+	}
+	if adaptive != nil {
+		options = append(options, minici.WithAdaptiveConcurrency(*adaptive))
+	}
+	if *clusterRedis != "" {
+		client, err := minici.ParseRedisURL(*clusterRedis)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		options = append(options, minici.WithCluster(minici.Cluster{
+			Store:           &minici.RedisClusterStore{Client: client, Keyring: logKeyring},
+			Instance:        *clusterInstance,
+			InstanceTimeout: *agentTimeout,
+			OnError: func(err error) {
+				slog.Error("Cluster store error", "error", err)
+			},
+		}))
+	}
+	if *queueRedis != "" {
+		client, err := minici.ParseRedisURL(*queueRedis)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		options = append(options, minici.WithExternalQueue(minici.ExternalQueue{
+			Queue:    &minici.RedisJobQueue{Client: client},
+			Consumer: *clusterInstance,
+			OnError: func(err error) {
+				slog.Error("Job queue error", "error", err)
+			},
+		}))
+	}
+	if *logDir != "" {
+		if err := os.MkdirAll(*logDir, 0o755); err != nil {
+			fatalf("failed to create log directory: %v", err)
+		}
+		options = append(options, minici.WithLogDir(*logDir), minici.WithLogEncryption(logKeyring))
+	}
+	if *cacheDir != "" {
+		options = append(options, minici.WithBuildCache(&minici.BuildCache{Dir: *cacheDir}))
+	}
+	if tools := toolCaches(); tools != nil {
+		options = append(options, minici.WithToolCaches(tools))
+	}
+	git, err := gitClient()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	options = append(options, minici.WithGitClient(git))
+	templates, err := templateStore(git)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if templates != nil {
+		options = append(options, minici.WithTemplates(templates))
+	}
+	images, err := imageBuilder(*containerRuntime, *secretsFile, keyring)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	options = append(options, minici.WithImageBuilder(images))
+	backups, err := backupStore()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *restoreBackup != "" && backups == nil {
+		fatalf("-restore-backup requires -backup-dir or -backup-s3-bucket")
+	}
+	if backups != nil {
+		options = append(options, minici.WithBackups(minici.Backups{
+			Store:     backups,
+			Interval:  *backupInterval,
+			Keep:      *backupKeep,
+			Artifacts: *backupArtifacts,
+			OnError: func(err error) {
+				slog.Error("Backup error", "error", err)
+			},
+		}))
+	}
+	ciServer := minici.NewCIServer(options...)
+	if *restoreBackup != "" {
+		name, jobs, err := readBackup(context.Background(), backups, *restoreBackup)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		restorer, ok := ciServer.(minici.Restorer)
+		if !ok {
+			fatalf("the server can't restore backups")
+		}
+		if err := restorer.Restore(jobs); err != nil {
+			fatalf("failed to restore backup %s: %v", name, err)
+		}
+		slog.Info("Restored jobs from backup", "jobs", len(jobs), "backup", name)
+	}
+	server := restapi.NewServer(ciServer, address)
+	proxies, err := restapi.ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	server.SetTrustedProxies(proxies)
+	if *jobPolicy != "" {
+		policy, err := minici.LoadJobPolicy(*jobPolicy)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		server.SetJobPolicy(policy)
+	}
+	if *tlsCert != "" {
+		server.EnableTLS(*tlsCert, *tlsKey)
+	}
+	server.EnableWebhooks(dispatcher)
+	if pool, ok := ciServer.(minici.AgentPool); ok {
+		server.EnableAgents(pool)
+	}
+	if searcher, ok := ciServer.(minici.LogSearcher); ok {
+		server.EnableLogSearch(searcher)
+	}
+	if collector, ok := ciServer.(minici.StatsCollector); ok {
+		server.EnableRepoStats(collector)
+	}
+	if artifacts != nil {
+		server.EnableArtifacts(artifacts)
+		server.EnableArtifactRetention(retention)
+		go restapi.SweepArtifacts(context.Background(), minici.SystemClock, artifacts, server.ArtifactRetention)
+	}
+	if *token != "" {
+		server.RequireToken(*token)
+		if *readToken != "" {
+			server.AddReadOnlyToken(*readToken)
+		}
+		if err := server.EnableAgentTokens(*agentTokensFile); err != nil {
+			fatalf("%v", err)
+		}
+	} else if *readToken != "" {
+		fatalf("-read-token requires -token to be set")
+	}
+	if *projectsConfig != "" {
+		projects, err := restapi.LoadProjectsConfig(*projectsConfig)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if err := server.SetProjects(projects); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *pullRequests != "" {
+		config, err := restapi.LoadPullRequestConfig(*pullRequests)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		server.SetPullRequests(config)
+	}
+	if *maintenance != "" {
+		schedule, err := minici.LoadMaintenanceSchedule(*maintenance)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if err := server.SetMaintenance(schedule); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	reload := &reloader{
+		flags:      flags,
+		given:      given,
+		configFile: *configFile,
+		server:     server,
+		dispatcher: dispatcher,
+		artifacts:  artifacts,
+		targets:    targetNames(targets),
+	}
+	server.EnableReload(reload.Reload)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := reload.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "error", err)
+				continue
+			}
+			slog.Info("Reloaded configuration")
+		}
+	}()
+
+	var side sideServers
+	if *adminListen != "" {
+		side.handle(*adminListen, "/api/admin/", server.AdminHandler(), true)
+	}
+	if *debugListen != "" {
+		side.handle(*debugListen, "/debug/", restapi.DebugHandler(), false)
+	}
+	sideServers, err := side.start(openListener, *tlsCert, *tlsKey)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	listener, err := openListener(address)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if checker, ok := ciServer.(minici.HealthChecker); ok && watchdog > 0 {
+		go runWatchdog(context.Background(), notifier, watchdog, checker.CheckHealth)
+	}
+	serveAPI := func(ctx context.Context, ready func()) error {
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve(listener)
+		}()
+		if err := notifier.notify("READY=1\nSTATUS=Serving on " + listener.Addr().String()); err != nil {
+			slog.Error("Failed to notify systemd", "error", err)
+		}
+		ready()
+
+		select {
+		case err := <-served:
+			return err
+		case <-ctx.Done():
+		}
+		slog.Info("Stopping server")
+		notifier.notify("STOPPING=1")
+		for _, sideServer := range sideServers {
+			sideServer.Close()
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Closed connections that were still open", "error", err)
+		}
+		return nil
+	}
+	if *service != "" {
+		err = runService(*service, serveAPI)
+	} else {
+		err = serveAPI(context.Background(), func() {})
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// shutdownTimeout is how long a stopping service waits for requests to finish,
+// such as log streams, before closing their connections
+const shutdownTimeout = 10 * time.Second
+
+// localServerURL is how jobs on this machine reach a server listening on
+// address, such as http://localhost:8080 for :8080
+func localServerURL(address string, tls bool) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if tls {
+		scheme = "https"
 	}
+	return scheme + "://" + net.JoinHostPort(host, port)
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ocuroot/minici"
+)
+
+// runMigrateStore copies the jobs, logs and claims in one Redis cluster store
+// to another, so a cluster can move to a new Redis server without losing its
+// history. Everything else a server keeps is in its config files.
+func runMigrateStore(args []string) error {
+	flags := flag.NewFlagSet("migrate-store", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: minici migrate-store --from <url> --to <url>\n\nCopies the jobs, their logs and claims from one Redis cluster store to another. Heartbeats\naren't copied, and secrets and other config stay in their files.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	from := flags.String("from", "", "URL of the store to copy from, such as redis://:password@old-redis:6379/0")
+	to := flags.String("to", "", "URL of the store to copy to")
+	fromPrefix := flags.String("from-prefix", "", "Prefix of the keys in the store to copy from. Defaults to minici:")
+	toPrefix := flags.String("to-prefix", "", "Prefix of the keys in the store to copy to. Defaults to minici:")
+	fromKeyFile := flags.String("from-key-file", "", "Encryption keys to decrypt the store to copy from with, if it's encrypted")
+	toKeyFile := flags.String("to-key-file", "", "Encryption keys to encrypt the copy with. Use a file with a new key first to rotate keys")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("--from and --to are required")
+	}
+	if *from == *to && *fromPrefix == *toPrefix {
+		return fmt.Errorf("--from and --to are the same store")
+	}
+
+	fromKeyring, err := loadKeyring(*fromKeyFile)
+	if err != nil {
+		return err
+	}
+	toKeyring, err := loadKeyring(*toKeyFile)
+	if err != nil {
+		return err
+	}
+	source, err := openClusterStore(*from, *fromPrefix, fromKeyring)
+	if err != nil {
+		return err
+	}
+	destination, err := openClusterStore(*to, *toPrefix, toKeyring)
+	if err != nil {
+		return err
+	}
+	stats, err := minici.MigrateClusterStore(context.Background(), source, destination)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Copied and verified %d jobs, %d log lines and %d claims\n", stats.Jobs, stats.Lines, stats.Claims)
+	return nil
+}
+
+// openClusterStore connects to the store a URL describes, encrypting what it
+// saves with keyring if set
+func openClusterStore(raw, prefix string, keyring *minici.Keyring) (minici.ClusterStore, error) {
+	if !strings.HasPrefix(raw, "redis://") && !strings.HasPrefix(raw, "rediss://") {
+		return nil, fmt.Errorf("unsupported store %q, only redis:// and rediss:// stores are available", raw)
+	}
+	client, err := minici.ParseRedisURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &minici.RedisClusterStore{Client: client, Prefix: prefix, Keyring: keyring}, nil
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"log/syslog"
+)
+
+// syslogLog writes to the system logger as the daemon facility
+type syslogLog struct {
+	writer *syslog.Writer
+}
+
+// openNativeLog opens the platform's log named by output, which is syslog on
+// Unix, writing messages as from source
+func openNativeLog(output, source string) (nativeLog, error) {
+	if output != "syslog" {
+		return nil, fmt.Errorf("invalid log output %q, expected stderr or syslog", output)
+	}
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return syslogLog{writer: writer}, nil
+}
+
+func (l syslogLog) write(level slog.Level, message string) error {
+	switch {
+	case level >= slog.LevelError:
+		return l.writer.Err(message)
+	case level >= slog.LevelWarn:
+		return l.writer.Warning(message)
+	case level >= slog.LevelInfo:
+		return l.writer.Info(message)
+	}
+	return l.writer.Debug(message)
+}
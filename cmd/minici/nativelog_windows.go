@@ -0,0 +1,66 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procRegisterEventSourceW = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW         = advapi32.NewProc("ReportEventW")
+)
+
+// Event types of the Windows Event Log
+const (
+	eventlogError       = 0x1
+	eventlogWarning     = 0x2
+	eventlogInformation = 0x4
+)
+
+// eventLog writes to the Application log of the Windows Event Log
+type eventLog struct {
+	handle uintptr
+}
+
+// openNativeLog opens the platform's log named by output, which is eventlog on
+// Windows, writing messages as from source
+func openNativeLog(output, source string) (nativeLog, error) {
+	if output != "eventlog" {
+		return nil, fmt.Errorf("invalid log output %q, expected stderr or eventlog", output)
+	}
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(name)))
+	if handle == 0 {
+		return nil, fmt.Errorf("failed to open the event log: %w", err)
+	}
+	return eventLog{handle: handle}, nil
+}
+
+func (l eventLog) write(level slog.Level, message string) error {
+	kind := eventlogInformation
+	switch {
+	case level >= slog.LevelError:
+		kind = eventlogError
+	case level >= slog.LevelWarn:
+		kind = eventlogWarning
+	}
+	text, err := syscall.UTF16PtrFromString(strings.ReplaceAll(message, "\x00", ""))
+	if err != nil {
+		return err
+	}
+	strs := []*uint16{text}
+	// Every message has event ID 1, with the message as its only string
+	ok, _, err := procReportEventW.Call(l.handle, uintptr(kind), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if ok == 0 {
+		return fmt.Errorf("failed to write to the event log: %w", err)
+	}
+	return nil
+}
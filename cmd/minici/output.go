@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ocuroot/minici/restapi"
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormat selects how commands print their results
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+	outputYAML  outputFormat = "yaml"
+)
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(value string) error {
+	switch format := outputFormat(value); format {
+	case outputTable, outputJSON, outputYAML:
+		*f = format
+		return nil
+	default:
+		return fmt.Errorf("output must be one of table, json or yaml")
+	}
+}
+
+// outputFlag registers the --output flag, defaulting to human readable output
+func outputFlag(flags *flag.FlagSet) *outputFormat {
+	format := outputTable
+	flags.Var(&format, "output", "Output format: table, json or yaml")
+	flags.Var(&format, "o", "Shorthand for --output")
+	return &format
+}
+
+// LogsOutput is the machine readable output of the logs command
+type LogsOutput struct {
+	ID   string   `json:"id"`
+	Logs []string `json:"logs"`
+}
+
+// WaitOutput is the machine readable output of the wait command.
+// Jobs is only set when waiting for specific jobs.
+type WaitOutput struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message,omitempty"`
+	Jobs    []restapi.JobResponse `json:"jobs,omitempty"`
+}
+
+// LogEvent is written for each log line and once the job completes when
+// following logs with machine readable output
+type LogEvent struct {
+	// Event is "log" for a log line or "done" when the job has completed
+	Event string               `json:"event"`
+	Line  string               `json:"line,omitempty"`
+	Job   *restapi.JobResponse `json:"job,omitempty"`
+}
+
+// print writes v to stdout in the selected format, calling table for human readable output.
+// The YAML schema matches the JSON schema.
+func (f outputFormat) print(v interface{}, table func(w io.Writer)) error {
+	return f.write(os.Stdout, v, table)
+}
+
+func (f outputFormat) write(w io.Writer, v interface{}, table func(w io.Writer)) error {
+	switch f {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case outputYAML:
+		content, err := toYAML(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	default:
+		table(w)
+		return nil
+	}
+}
+
+// event writes a single streamed event. JSON events are written one per line
+// and YAML events as separate documents.
+func (f outputFormat) event(w io.Writer, event LogEvent) error {
+	switch f {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(event)
+	case outputYAML:
+		content, err := toYAML(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "---\n%s", content)
+		return err
+	default:
+		if event.Event == "log" {
+			_, err := fmt.Fprintln(w, event.Line)
+			return err
+		}
+		return nil
+	}
+}
+
+// toYAML encodes v as YAML using its JSON field names and ordering
+func toYAML(v interface{}) ([]byte, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(content, &node); err != nil {
+		return nil, err
+	}
+	resetStyle(&node)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
+
+// resetStyle clears the flow style JSON is parsed with, so the output is block style YAML
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
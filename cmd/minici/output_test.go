@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici/restapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOutputFormat(t *testing.T) {
+	job := restapi.JobResponse{
+		ID:      "123",
+		Status:  "success",
+		RepoURI: "https://github.com/ocuroot/minici",
+		Commit:  "main",
+		Command: "go test ./...",
+		Labels:  map[string]string{"team": "core"},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, outputJSON.write(&buf, job, nil))
+
+		var decoded restapi.JobResponse
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, job, decoded)
+	})
+
+	t.Run("yaml uses json field names", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, outputYAML.write(&buf, job, nil))
+		assert.Contains(t, buf.String(), "repo_uri: https://github.com/ocuroot/minici\n")
+
+		var decoded map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &decoded))
+		// Numeric looking strings must stay strings
+		assert.Equal(t, "123", decoded["id"])
+		assert.Equal(t, map[string]interface{}{"team": "core"}, decoded["labels"])
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, outputTable.write(&buf, job, func(w io.Writer) {
+			printJob(w, job)
+		}))
+		assert.Contains(t, buf.String(), "Status:  success\n")
+		assert.Contains(t, buf.String(), "Label:   team=core\n")
+	})
+
+	t.Run("events", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, outputJSON.event(&buf, LogEvent{Event: "log", Line: "hello"}))
+		require.NoError(t, outputJSON.event(&buf, LogEvent{Event: "done", Job: &job}))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, `{"event":"log","line":"hello"}`, lines[0])
+
+		var done LogEvent
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &done))
+		assert.Equal(t, "done", done.Event)
+		assert.Equal(t, job, *done.Job)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var format outputFormat
+		assert.Error(t, format.Set("xml"))
+	})
+}
+
+func TestPrintJob(t *testing.T) {
+	var out bytes.Buffer
+	printJob(&out, restapi.JobResponse{
+		ID:          "job-142",
+		Status:      "failure",
+		Number:      142,
+		RepoURI:     "https://github.com/ocuroot/minici.git",
+		Name:        "Nightly release",
+		Environment: "prod",
+		Annotations: []restapi.AnnotationResponse{{Line: 2, Severity: "error", Message: "main.go:3:1: syntax error"}},
+		Hooks: []restapi.HookResponse{
+			{Name: "upload", Phase: "always", Run: "./upload.sh", Outcome: "failure"},
+			{Phase: "on_failure", Run: "./collect.sh", Outcome: "success"},
+		},
+	})
+	assert.Contains(t, out.String(), "Build:   minici #142\n")
+	assert.Contains(t, out.String(), "Name:    Nightly release\n")
+	assert.Contains(t, out.String(), "Deploys: prod\n")
+	assert.Contains(t, out.String(), "Problems: 1 errors, 0 warnings")
+	assert.Contains(t, out.String(), "First error: line 3: main.go:3:1: syntax error")
+	assert.Contains(t, out.String(), "Hook:    upload (always): failure\nHook:    ./collect.sh (on_failure): success\n")
+}
+
+func TestPrintEnvironments(t *testing.T) {
+	var out bytes.Buffer
+	deployed := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	printEnvironments(&out, []restapi.EnvironmentResponse{
+		{Name: "prod", Deployments: []restapi.DeploymentResponse{
+			{RepoURI: "https://github.com/ocuroot/api", Commit: "v1.0.0", JobID: "job-1", DeployedAt: deployed},
+			{RepoURI: "https://github.com/ocuroot/web", Commit: "abc123", JobID: "job-2", DeployedAt: deployed},
+		}},
+		{Name: "staging", Deployments: []restapi.DeploymentResponse{
+			{RepoURI: "https://github.com/ocuroot/api", Commit: "v1.1.0", JobID: "job-3", DeployedAt: deployed},
+		}},
+	})
+	assert.Equal(t, `prod     https://github.com/ocuroot/api  v1.0.0  job-1  2025-06-01T12:00:00Z
+prod     https://github.com/ocuroot/web  abc123  job-2  2025-06-01T12:00:00Z
+staging  https://github.com/ocuroot/api  v1.1.0  job-3  2025-06-01T12:00:00Z
+`, out.String())
+}
+
+func TestPrintGroup(t *testing.T) {
+	var out bytes.Buffer
+	printGroup(&out, restapi.GroupResponse{
+		ID:     "backfill",
+		Status: "running",
+		Counts: map[string]int{"success": 1, "running": 1},
+		Jobs: []restapi.JobResponse{
+			{ID: "job-1", Commit: "abc123", Status: "success"},
+			{ID: "job-2", Commit: "def456", Status: "running"},
+		},
+	})
+	assert.Equal(t, `Group backfill is running (1 running, 1 success)
+job-1  abc123  success
+job-2  def456  running
+`, out.String())
+}
+
+func TestPrintDryRun(t *testing.T) {
+	var out bytes.Buffer
+	printDryRun(&out, restapi.DryRunResponse{
+		Job:            restapi.JobResponse{Number: 4, RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make test"},
+		ResolvedCommit: "0123abcd",
+	})
+	assert.Equal(t, "Would schedule build #4 of https://github.com/ocuroot/minici at main\nCommit:  0123abcd\nCommand: make test\n", out.String())
+}
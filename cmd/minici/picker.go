@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ocuroot/minici/restapi"
+)
+
+// pickerLimit is the number of recent jobs offered by the interactive picker
+const pickerLimit = 20
+
+// isTerminal returns true if the file is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// pickJob asks the user to choose one of the most recent jobs. Typing text
+// narrows the list to jobs that fuzzy match it, and entering a number picks
+// that job.
+func pickJob(client *restapi.Client, in io.Reader, out io.Writer) (string, error) {
+	ids, err := client.List()
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", errors.New("no jobs to choose from")
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	if len(ids) > pickerLimit {
+		ids = ids[:pickerLimit]
+	}
+
+	jobs := make([]restapi.JobResponse, len(ids))
+	for i, id := range ids {
+		jobs[i], err = client.Status(id)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	reader := bufio.NewScanner(in)
+	matches := jobs
+	for {
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for i, job := range matches {
+			fmt.Fprintf(tw, "%3d)\t%s\t%s\t%s@%s\t%s\n", i+1, job.ID, job.Status, job.RepoURI, job.Commit, job.Command)
+		}
+		tw.Flush()
+		fmt.Fprint(out, "Type to filter, or enter a number to choose a job: ")
+
+		if !reader.Scan() {
+			fmt.Fprintln(out)
+			if err := reader.Err(); err != nil {
+				return "", err
+			}
+			return "", errors.New("no job chosen")
+		}
+		input := strings.TrimSpace(reader.Text())
+
+		if input == "" {
+			if len(matches) == 1 {
+				return matches[0].ID, nil
+			}
+			continue
+		}
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(matches) {
+			return matches[n-1].ID, nil
+		}
+
+		var filtered []restapi.JobResponse
+		for _, job := range jobs {
+			if fuzzyMatch(input, strings.Join([]string{job.ID, job.Status, job.RepoURI, job.Commit, job.Command}, " ")) {
+				filtered = append(filtered, job)
+			}
+		}
+		if len(filtered) == 0 {
+			fmt.Fprintf(out, "No jobs match %q\n", input)
+			filtered = jobs
+		}
+		matches = filtered
+	}
+}
+
+// fuzzyMatch returns true if the characters of pattern appear in order in
+// text, ignoring case
+func fuzzyMatch(pattern, text string) bool {
+	text = strings.ToLower(text)
+	for _, r := range strings.ToLower(pattern) {
+		i := strings.IndexRune(text, r)
+		if i < 0 {
+			return false
+		}
+		text = text[i+len(string(r)):]
+	}
+	return true
+}
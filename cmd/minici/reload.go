@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"sync"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/notify"
+	"github.com/ocuroot/minici/restapi"
+)
+
+// reloadableSettings are the flags reapplied when config is reloaded. Other
+// settings need a restart.
+var reloadableSettings = []string{"token", "read-token", "notify-config", "artifact-retention", "job-policy", "projects", "pull-requests", "maintenance"}
+
+// reloader reapplies the settings that are safe to change while the server
+// runs. Running jobs and open connections are left alone.
+type reloader struct {
+	mutex      sync.Mutex
+	flags      *flag.FlagSet
+	given      map[string]bool
+	configFile string
+	server     *restapi.Server
+	dispatcher *notify.Dispatcher
+	// artifacts is the artifact store given to the server, if any
+	artifacts minici.ArtifactStore
+	// targets are the names of the notifiers from config, so that webhooks
+	// registered with the API are kept
+	targets []string
+}
+
+// Reload reads the config file, environment and any files it names again,
+// changing nothing if they are invalid
+func (r *reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var config ServerConfig
+	if r.configFile != "" {
+		var err error
+		config, err = loadServerConfig(r.configFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Flags given on the command line keep their values, the rest go back to
+	// their defaults before the environment and file are applied again
+	keep := map[string]bool{}
+	r.flags.VisitAll(func(f *flag.Flag) {
+		keep[f.Name] = true
+	})
+	for _, name := range reloadableSettings {
+		if r.given[name] {
+			continue
+		}
+		keep[name] = false
+		f := r.flags.Lookup(name)
+		f.Value.Set(f.DefValue)
+	}
+	if err := applyServerConfig(r.flags, keep, config, os.Getenv); err != nil {
+		return err
+	}
+	setting := func(name string) string {
+		return r.flags.Lookup(name).Value.String()
+	}
+
+	notifyConfig, err := loadNotifyConfig(setting("notify-config"), config.Notify)
+	if err != nil {
+		return err
+	}
+	targets, err := notifyConfig.Targets()
+	if err != nil {
+		return err
+	}
+	rules, err := notifyConfig.RoutingRules()
+	if err != nil {
+		return err
+	}
+
+	var retention minici.ArtifactRetention
+	if path := setting("artifact-retention"); path != "" {
+		if r.artifacts == nil {
+			return errors.New("-artifact-retention requires -artifact-dir or -artifact-s3-bucket")
+		}
+		retention, err = minici.LoadArtifactRetention(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var policy minici.JobPolicy
+	if path := setting("job-policy"); path != "" {
+		policy, err = minici.LoadJobPolicy(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var projects restapi.ProjectsConfig
+	if path := setting("projects"); path != "" {
+		projects, err = restapi.LoadProjectsConfig(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var pullRequests restapi.PullRequestConfig
+	if path := setting("pull-requests"); path != "" {
+		pullRequests, err = restapi.LoadPullRequestConfig(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var maintenance minici.MaintenanceSchedule
+	if path := setting("maintenance"); path != "" {
+		maintenance, err = minici.LoadMaintenanceSchedule(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.server.ReplaceTokens(setting("token"), setting("read-token")); err != nil {
+		return err
+	}
+	if err := r.server.SetProjects(projects); err != nil {
+		return err
+	}
+	if err := r.server.SetMaintenance(maintenance); err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, target := range targets {
+		r.dispatcher.AddTarget(target)
+		current[target.Name] = true
+	}
+	for _, name := range r.targets {
+		if !current[name] {
+			r.dispatcher.RemoveTarget(name)
+		}
+	}
+	r.targets = targetNames(targets)
+	r.dispatcher.SetRules(rules)
+	r.server.SetArtifactRetention(retention)
+	r.server.SetJobPolicy(policy)
+	r.server.SetPullRequests(pullRequests)
+	return nil
+}
+
+func targetNames(targets []notify.Target) []string {
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+// loadNotifyConfig reads the notification config from path, or uses the
+// server config's inline settings. Neither is an empty config.
+func loadNotifyConfig(path string, inline *notify.Config) (notify.Config, error) {
+	switch {
+	case path != "" && inline != nil:
+		return notify.Config{}, errors.New("-notify-config can't be used with notify settings in the server config")
+	case path != "":
+		return notify.LoadConfig(path)
+	case inline != nil:
+		return *inline, nil
+	}
+	return notify.Config{}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+	"github.com/ocuroot/minici/notify"
+	"github.com/ocuroot/minici/restapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "minici.yaml")
+	retentionFile := filepath.Join(dir, "retention.yaml")
+	write := func(path, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write(retentionFile, "keep_last: 5\n")
+	write(configFile, `
+token: old-token
+read-token: old-viewer
+notify:
+  notifiers:
+    - name: old
+      type: webhook
+      url: https://example.com/old
+`)
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.String("config", "", "")
+	flags.String("token", "", "")
+	flags.String("read-token", "", "")
+	flags.String("notify-config", "", "")
+	flags.String("artifact-retention", "", "")
+	flags.String("job-policy", "", "")
+	flags.String("projects", "", "")
+	flags.String("pull-requests", "", "")
+	flags.String("maintenance", "", "")
+	flags.Int("max-concurrent-jobs", 0, "")
+	require.NoError(t, flags.Parse(nil))
+
+	queue, err := delivery.NewQueue(delivery.Config{})
+	require.NoError(t, err)
+	dispatcher := notify.NewDispatcher(queue, "", nil)
+	restServer := restapi.NewServer(minici.NewCIServer(minici.WithLocalAgent(false)), ":0")
+	restServer.EnableArtifacts(&minici.FileArtifactStore{Dir: dir})
+	restServer.EnableArtifactRetention(minici.ArtifactRetention{})
+	reload := &reloader{flags: flags, given: givenFlags(flags), configFile: configFile, server: restServer, dispatcher: dispatcher, artifacts: &minici.FileArtifactStore{Dir: dir}}
+	restServer.EnableReload(reload.Reload)
+
+	// Authentication can't be turned on without a restart
+	assert.EqualError(t, reload.Reload(), "turning authentication on or off requires a restart")
+	restServer.RequireToken("old-token")
+	require.NoError(t, reload.Reload())
+
+	// A webhook registered with the API is kept when notifiers change
+	dispatcher.AddTarget(notify.Target{Name: "api-hook", Notifier: &notify.Webhook{URL: "https://example.com/api"}})
+	write(configFile, `
+token: new-token
+artifact-retention: `+retentionFile+`
+max-concurrent-jobs: 8
+notify:
+  notifiers:
+    - name: new
+      type: webhook
+      url: https://example.com/new
+`)
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/admin/reload", "old-viewer"))
+	require.Equal(t, http.StatusNoContent, request("POST", "/api/admin/reload", "old-token"))
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/jobs", "old-token"))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/jobs", "old-viewer"))
+	assert.Equal(t, http.StatusOK, request("GET", "/api/jobs", "new-token"))
+	assert.Equal(t, 5, restServer.ArtifactRetention().KeepLast)
+	assert.Equal(t, []string{"api-hook", "new"}, targetNames(dispatcher.Targets()))
+	// Settings that need a restart are left alone
+	assert.Equal(t, "0", flags.Lookup("max-concurrent-jobs").Value.String())
+
+	// An invalid config changes nothing
+	write(configFile, "token: newer-token\nartifact-retention: /missing\n")
+	assert.Equal(t, http.StatusInternalServerError, request("POST", "/api/admin/reload", "new-token"))
+	assert.Equal(t, http.StatusOK, request("GET", "/api/jobs", "new-token"))
+	assert.Equal(t, 5, restServer.ArtifactRetention().KeepLast)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ocuroot/minici/notify"
+	"gopkg.in/yaml.v3"
+)
+
+// ServerConfig is the file format for configuring the server. Settings are
+// named after serve's flags, such as max-concurrent-jobs, and lists set
+// repeatable flags once per item.
+type ServerConfig struct {
+	Settings map[string]interface{} `yaml:",inline"`
+	// Notify configures notifications inline, instead of with -notify-config
+	Notify *notify.Config `yaml:"notify"`
+}
+
+// loadServerConfig reads a server config file
+func loadServerConfig(path string) (ServerConfig, error) {
+	var config ServerConfig
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read server config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("failed to parse server config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// envName is the environment variable that sets a flag, such as
+// MINICI_MAX_CONCURRENT_JOBS for -max-concurrent-jobs
+func envName(flagName string) string {
+	return "MINICI_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// givenFlags returns the names of the flags given on the command line
+func givenFlags(flags *flag.FlagSet) map[string]bool {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// applyServerConfig sets the flags not in keep, such as those given on the
+// command line, from their environment variables, falling back to the config
+// file. The config flag itself can't be set this way.
+func applyServerConfig(flags *flag.FlagSet, keep map[string]bool, config ServerConfig, getenv func(string) string) error {
+	var names []string
+	for name := range config.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown server setting %q", name)
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || keep[f.Name] || f.Name == "config" {
+			return
+		}
+		if value := getenv(envName(f.Name)); value != "" {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid $%s: %w", envName(f.Name), setErr)
+			}
+			return
+		}
+		value := config.Settings[f.Name]
+		if value == nil {
+			return
+		}
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			if setErr := flags.Set(f.Name, fmt.Sprint(value)); setErr != nil {
+				err = fmt.Errorf("invalid server setting %s: %w", f.Name, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
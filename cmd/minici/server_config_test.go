@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "minici.yaml")
+	err := os.WriteFile(path, []byte(`
+listen: 127.0.0.1:9090
+token: file-token
+max-concurrent-jobs: 4
+local-agent: false
+labels: [docker, gpu]
+read-token:
+notify:
+  base_url: https://ci.example.com
+  notifiers:
+    - name: hooks
+      type: webhook
+      url: https://example.com/hook
+      secret: s3cret
+`), 0o600)
+	require.NoError(t, err)
+	config, err := loadServerConfig(path)
+	require.NoError(t, err)
+	require.NotNil(t, config.Notify)
+	assert.Equal(t, "s3cret", config.Notify.Notifiers[0].Secret)
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := flags.String("listen", "", "")
+	token := flags.String("token", "", "")
+	readToken := flags.String("read-token", "", "")
+	maxConcurrent := flags.Int("max-concurrent-jobs", 0, "")
+	localAgent := flags.Bool("local-agent", true, "")
+	var labels listFlag
+	flags.Var(&labels, "labels", "")
+	require.NoError(t, flags.Parse([]string{"-max-concurrent-jobs", "2"}))
+
+	// Flags take precedence over the environment, which takes precedence over the file
+	env := map[string]string{"MINICI_TOKEN": "env-token"}
+	require.NoError(t, applyServerConfig(flags, givenFlags(flags), config, func(name string) string { return env[name] }))
+	assert.Equal(t, "127.0.0.1:9090", *listen)
+	assert.Equal(t, "env-token", *token)
+	assert.Equal(t, "", *readToken)
+	assert.Equal(t, 2, *maxConcurrent)
+	assert.False(t, *localAgent)
+	assert.Equal(t, listFlag{"docker", "gpu"}, labels)
+
+	config.Settings["max-jobs"] = 4
+	err = applyServerConfig(flags, nil, config, os.Getenv)
+	assert.EqualError(t, err, `unknown server setting "max-jobs"`)
+}
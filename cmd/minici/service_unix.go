@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runService runs as a daemon, such as under launchd, calling run with a
+// context cancelled when the daemon is asked to stop with SIGTERM or SIGINT
+func runService(name string, run func(ctx context.Context, ready func()) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	return run(ctx, func() {})
+}
@@ -0,0 +1,117 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+// Values from the service control manager's API
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop     = 1
+	serviceControlShutdown = 5
+
+	// errorServiceSpecific reports that the service failed, such as when it
+	// couldn't start
+	errorServiceSpecific = 1066
+)
+
+// serviceStatus is a SERVICE_STATUS
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is a SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// runService runs as the Windows service name, which the service control
+// manager must have started. run is called with a context cancelled when the
+// service is stopped or Windows shuts down, and calls ready once it's serving.
+func runService(name string, run func(ctx context.Context, ready func()) error) error {
+	serviceName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	var handle uintptr
+	setStatus := func(state, accepted, exitCode uint32) {
+		status := serviceStatus{
+			ServiceType:      serviceWin32OwnProcess,
+			CurrentState:     state,
+			ControlsAccepted: accepted,
+			Win32ExitCode:    exitCode,
+		}
+		if exitCode == errorServiceSpecific {
+			status.ServiceSpecificExitCode = 1
+		}
+		procSetServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+	}
+
+	handler := syscall.NewCallback(func(control, eventType, eventData, handlerContext uintptr) uintptr {
+		switch control {
+		case serviceControlStop, serviceControlShutdown:
+			setStatus(serviceStopPending, 0, 0)
+			stop()
+		}
+		return 0
+	})
+
+	var runErr error
+	serviceMain := syscall.NewCallback(func(argc, argv uintptr) uintptr {
+		h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(serviceName)), handler, 0)
+		if h == 0 {
+			runErr = fmt.Errorf("failed to register the service control handler: %w", err)
+			return 0
+		}
+		handle = h
+		setStatus(serviceStartPending, 0, 0)
+		runErr = run(ctx, func() {
+			setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0)
+		})
+		var exitCode uint32
+		if runErr != nil {
+			exitCode = errorServiceSpecific
+		}
+		setStatus(serviceStopped, 0, exitCode)
+		return 0
+	})
+
+	// The dispatcher returns once the service has stopped
+	table := []serviceTableEntry{{name: serviceName, proc: serviceMain}, {}}
+	ok, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if ok == 0 {
+		return fmt.Errorf("failed to connect to the service control manager, --service only works when Windows starts minici as a service: %w", err)
+	}
+	return runErr
+}
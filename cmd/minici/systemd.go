@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets as
+const listenFDsStart = 3
+
+// systemdNotifier tells systemd about the server's state when it was started
+// as a Type=notify service. A nil notifier does nothing.
+type systemdNotifier struct {
+	socket string
+}
+
+// newSystemdNotifier reads the socket systemd listens for notifications on,
+// returning nil if there isn't one. The variable is unset so jobs' commands
+// can't send notifications of their own.
+func newSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	os.Unsetenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	return &systemdNotifier{socket: socket}
+}
+
+// notify sends state, such as READY=1, to systemd
+func (n *systemdNotifier) notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	socket := n.socket
+	// Sockets starting with @ are in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns how often systemd expects the server to ping its
+// watchdog, or 0 if WatchdogSec isn't set for the service
+func watchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	pid := os.Getenv("WATCHDOG_PID")
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	if usec == "" {
+		return 0, nil
+	}
+	// The watchdog is meant for another process, such as a wrapper script
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// runWatchdog pings systemd's watchdog twice per interval while check passes,
+// so systemd restarts the server if its scheduler gets stuck, until ctx is
+// cancelled
+func runWatchdog(ctx context.Context, notifier *systemdNotifier, interval time.Duration, check func(context.Context) error) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval/4)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			slog.Error("Skipping watchdog ping", "error", err)
+		} else if err := notifier.notify("WATCHDOG=1"); err != nil {
+			slog.Error("Failed to ping watchdog", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// activatedListener is a socket systemd opened for the server
+type activatedListener struct {
+	net.Listener
+	// name is the socket unit's FileDescriptorName, such as admin
+	name string
+}
+
+// activationListeners returns the sockets systemd opened for the server with
+// socket activation, or none if it wasn't socket activated. The variables are
+// unset so jobs' commands don't think they were passed the sockets too.
+func activationListeners() ([]activatedListener, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	listeners := make([]activatedListener, count)
+	for i := range count {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(file)
+		// FileListener duplicates the descriptor, so the original isn't needed
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s from systemd isn't a listening socket: %w", name, err)
+		}
+		listeners[i] = activatedListener{Listener: listener, name: name}
+	}
+	return listeners, nil
+}
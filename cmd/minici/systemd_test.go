@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listenNotify listens for notifications like systemd, returning the
+// notifier that sends to it
+func listenNotify(t *testing.T) (*systemdNotifier, *net.UnixConn) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	notifier := newSystemdNotifier()
+	require.NotNil(t, notifier)
+	return notifier, conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	notifier, conn := listenNotify(t)
+	assert.Empty(t, os.Getenv("NOTIFY_SOCKET"))
+
+	require.NoError(t, notifier.notify("READY=1"))
+	assert.Equal(t, "READY=1", readNotification(t, conn))
+
+	// Without systemd, notifying does nothing
+	var none *systemdNotifier
+	assert.NoError(t, none.notify("READY=1"))
+}
+
+func TestRunWatchdog(t *testing.T) {
+	notifier, conn := listenNotify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthy := make(chan bool, 1)
+	healthy <- false
+	go runWatchdog(ctx, notifier, 20*time.Millisecond, func(context.Context) error {
+		select {
+		case ok := <-healthy:
+			if !ok {
+				return errors.New("scheduler is stuck")
+			}
+		default:
+		}
+		return nil
+	})
+
+	// The first check fails, so the first ping comes from a later check
+	start := time.Now()
+	assert.Equal(t, "WATCHDOG=1", readNotification(t, conn))
+	assert.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, err := watchdogInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+	assert.Empty(t, os.Getenv("WATCHDOG_USEC"))
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "1")
+	interval, err = watchdogInterval()
+	require.NoError(t, err)
+	assert.Zero(t, interval)
+
+	t.Setenv("WATCHDOG_USEC", "soon")
+	_, err = watchdogInterval()
+	assert.Error(t, err)
+}
+
+func TestActivationListeners(t *testing.T) {
+	listeners, err := activationListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Sockets passed to another process are ignored
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err = activationListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "many")
+	_, err = activationListeners()
+	assert.Error(t, err)
+}
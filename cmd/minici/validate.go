@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+)
+
+// runValidate checks a repo config, exiting non-zero if it has errors. It's
+// checked locally unless -remote is given, which asks the server instead,
+// such as to check a config against a server running a newer version.
+// Without a file, it checks the repo config in the current directory, or the
+// pipeline program if there's no config.
+func runValidate(args []string) error {
+	flags, settings := clientFlags("validate", "validate [flags] [file]")
+	remote := flags.Bool("remote", false, "Ask the server to validate the config, rather than checking it locally")
+	strict := flags.Bool("strict", false, "Exit non-zero if the config has warnings as well as errors")
+	starlark := flags.Bool("starlark", false, "Check a Starlark pipeline program, as files ending in .star are")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitCode(2)
+	}
+	path := minici.RepoConfigFile
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	} else if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(minici.RepoPipelineFile); err == nil {
+			path = minici.RepoPipelineFile
+		}
+	}
+	if strings.HasSuffix(path, ".star") {
+		*starlark = true
+	}
+
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	var resp restapi.ValidateResponse
+	if *remote {
+		client, err := settings.Client()
+		if err != nil {
+			return err
+		}
+		validate := client.Validate
+		if *starlark {
+			validate = client.ValidatePipeline
+		}
+		if resp, err = validate(content); err != nil {
+			return err
+		}
+	} else if *starlark {
+		resp = restapi.NewValidateResponse(minici.ValidatePipeline(content))
+	} else {
+		resp = restapi.NewValidateResponse(minici.ValidateRepoConfig(content))
+	}
+
+	if err := settings.output.print(resp, func(w io.Writer) {
+		printValidation(w, path, resp)
+	}); err != nil {
+		return err
+	}
+	if !resp.Valid || (*strict && len(resp.Warnings) > 0) {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// printValidation prints a config's problems in the file:line:column form
+// editors recognize
+func printValidation(w io.Writer, path string, resp restapi.ValidateResponse) {
+	printProblem := func(severity string, problem restapi.ConfigProblem) {
+		location := path
+		if problem.Line > 0 {
+			location = fmt.Sprintf("%s:%d:%d", path, problem.Line, problem.Column)
+		}
+		if problem.Field != "" {
+			fmt.Fprintf(w, "%s: %s: %s: %s\n", location, severity, problem.Field, problem.Message)
+		} else {
+			fmt.Fprintf(w, "%s: %s: %s\n", location, severity, problem.Message)
+		}
+	}
+	for _, problem := range resp.Errors {
+		printProblem("error", problem)
+	}
+	for _, problem := range resp.Warnings {
+		printProblem("warning", problem)
+	}
+	if resp.Valid {
+		fmt.Fprintf(w, "%s is valid", path)
+		if len(resp.Warnings) > 0 {
+			fmt.Fprintf(w, ", with %d warnings", len(resp.Warnings))
+		}
+		fmt.Fprintln(w)
+	}
+}
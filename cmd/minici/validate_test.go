@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/restapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintValidation(t *testing.T) {
+	resp := restapi.NewValidateResponse(minici.ValidateRepoConfig([]byte("cache:\n  - key: deps\nartifact: []\n")))
+	var out bytes.Buffer
+	printValidation(&out, ".minici.yml", resp)
+	assert.Equal(t, ".minici.yml:2:5: error: cache[0]: cache needs at least one path\n"+
+		".minici.yml:3:1: warning: unknown field \"artifact\" is ignored, did you mean \"artifacts\"?\n", out.String())
+
+	out.Reset()
+	printValidation(&out, ".minici.yml", restapi.NewValidateResponse(minici.ValidateRepoConfig([]byte("image: golang\n"))))
+	assert.Equal(t, ".minici.yml is valid\n", out.String())
+
+	out.Reset()
+	printValidation(&out, ".minici.star", restapi.NewValidateResponse(minici.ValidatePipeline([]byte("pipeline(\n  stesp = [],\n)\n"))))
+	assert.Equal(t, ".minici.star:1:9: error: pipeline() got an unexpected keyword argument \"stesp\", did you mean \"steps\"?\n", out.String())
+}
@@ -0,0 +1,106 @@
+package minici
+
+import "strings"
+
+// minCommitPrefix is the shortest abbreviated hash matched against a
+// commit's full hash, as shorter ones are too likely to be ambiguous
+const minCommitPrefix = 7
+
+// CommitCheck is one of the checks run for a commit, such as a job of a
+// pipeline or one entry of a matrix, which may have been run more than once
+type CommitCheck struct {
+	// Name is its jobs' name, or their command if they weren't named
+	Name string
+	// Job is the latest job run for the check, which decides its outcome
+	Job Job
+	// Attempts counts the jobs run for the check, including re-runs
+	Attempts int
+}
+
+// CommitSummary is the verdict of every job run for a commit, such as for
+// gating merges on it the way branch protection does
+type CommitSummary struct {
+	Commit string
+	// Status is failure if any check's latest job didn't succeed, pending
+	// while any is still to finish, no jobs have run or a required check is
+	// missing, and success once every check has succeeded
+	Status JobStatus
+	// Checks are the checks run for the commit, in the order they were
+	// first run
+	Checks []CommitCheck
+	// Missing are the required checks no job has been run for
+	Missing []string
+}
+
+// Passed returns true if the commit passed every check
+func (s CommitSummary) Passed() bool {
+	return s.Status == JobStatusSuccess
+}
+
+// SummarizeCommit summarizes the jobs run for a commit, which must be oldest
+// first. Jobs are grouped into checks by name, so re-running a failed job
+// replaces its failure. Required checks that no job has run for keep the
+// commit pending.
+func SummarizeCommit(commit string, jobs []Job, required []string) CommitSummary {
+	summary := CommitSummary{Commit: commit}
+	checks := map[string]int{}
+	for _, job := range jobs {
+		if !job.MatchesCommit(commit) {
+			continue
+		}
+		name := job.Name
+		if name == "" {
+			name = job.Command
+		}
+		i, ok := checks[name]
+		if !ok {
+			i = len(summary.Checks)
+			checks[name] = i
+			summary.Checks = append(summary.Checks, CommitCheck{Name: name})
+		}
+		summary.Checks[i].Job = job
+		summary.Checks[i].Attempts++
+	}
+	for _, name := range required {
+		if _, ok := checks[name]; !ok {
+			summary.Missing = append(summary.Missing, name)
+		}
+	}
+
+	summary.Status = JobStatusSuccess
+	if len(summary.Checks) == 0 || len(summary.Missing) > 0 {
+		summary.Status = JobStatusPending
+	}
+	for _, check := range summary.Checks {
+		switch status := check.Job.Status; {
+		case status.Done() && status != JobStatusSuccess:
+			summary.Status = JobStatusFailure
+			return summary
+		case !status.Done():
+			summary.Status = JobStatusPending
+		}
+	}
+	return summary
+}
+
+// MatchesCommit returns true if the job was scheduled with or checked out
+// commit, which may be abbreviated
+func (j Job) MatchesCommit(commit string) bool {
+	commit = strings.ToLower(commit)
+	for _, candidate := range []string{j.CommitHash, strings.ToLower(j.Commit)} {
+		if candidate == commit {
+			return true
+		}
+		if !commitHashPattern.MatchString(candidate) || !commitHashPattern.MatchString(commit) {
+			continue
+		}
+		short, long := candidate, commit
+		if len(short) > len(long) {
+			short, long = long, short
+		}
+		if len(short) >= minCommitPrefix && strings.HasPrefix(long, short) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package minici
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeCommit(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	job := func(id, name string, status JobStatus) Job {
+		return Job{ID: JobID(id), Commit: commit, Command: "make " + name, Name: name, Status: status}
+	}
+
+	for _, test := range []struct {
+		name     string
+		jobs     []Job
+		required []string
+		expected JobStatus
+	}{
+		{"no jobs", nil, nil, JobStatusPending},
+		{"all passed", []Job{job("1", "test", JobStatusSuccess), job("2", "lint", JobStatusSuccess)}, nil, JobStatusSuccess},
+		{"running", []Job{job("1", "test", JobStatusSuccess), job("2", "lint", JobStatusRunning)}, nil, JobStatusPending},
+		{"failed while others run", []Job{job("1", "test", JobStatusFailure), job("2", "lint", JobStatusRunning)}, nil, JobStatusFailure},
+		{"cancelled", []Job{job("1", "test", JobStatusCancelled)}, nil, JobStatusFailure},
+		{"re-run passed", []Job{job("1", "test", JobStatusFailure), job("2", "test", JobStatusSuccess)}, nil, JobStatusSuccess},
+		{"re-run failed", []Job{job("1", "test", JobStatusSuccess), job("2", "test", JobStatusInterrupted)}, nil, JobStatusFailure},
+		{"required missing", []Job{job("1", "test", JobStatusSuccess)}, []string{"test", "deploy"}, JobStatusPending},
+		{"required passed", []Job{job("1", "test", JobStatusSuccess)}, []string{"test"}, JobStatusSuccess},
+	} {
+		summary := SummarizeCommit(commit, test.jobs, test.required)
+		if summary.Status != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, summary.Status)
+		}
+		if summary.Passed() != (test.expected == JobStatusSuccess) {
+			t.Errorf("%s: expected passed to follow the status", test.name)
+		}
+	}
+
+	other := job("3", "test", JobStatusFailure)
+	other.Commit = "fedcba9876543210fedcba9876543210fedcba98"
+	unnamed := job("4", "", JobStatusSuccess)
+	summary := SummarizeCommit(commit[:7], []Job{job("1", "test", JobStatusFailure), other, job("2", "test", JobStatusSuccess), unnamed}, []string{"deploy"})
+	expected := []CommitCheck{
+		{Name: "test", Job: job("2", "test", JobStatusSuccess), Attempts: 2},
+		{Name: "make ", Job: unnamed, Attempts: 1},
+	}
+	if !reflect.DeepEqual(summary.Checks, expected) {
+		t.Errorf("Expected checks %+v, got %+v", expected, summary.Checks)
+	}
+	if !reflect.DeepEqual(summary.Missing, []string{"deploy"}) {
+		t.Errorf("Expected deploy to be missing, got %v", summary.Missing)
+	}
+}
+
+func TestMatchesCommit(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	for _, test := range []struct {
+		job      Job
+		commit   string
+		expected bool
+	}{
+		{Job{Commit: hash}, hash, true},
+		{Job{Commit: hash}, "0123456", true},
+		{Job{Commit: hash}, "0123ABC", false},
+		{Job{Commit: "0123456789AB"}, hash, true},
+		{Job{Commit: hash}, "012345", false},
+		{Job{Commit: "main", CommitHash: hash}, hash[:12], true},
+		{Job{Commit: "main"}, "main", true},
+		{Job{Commit: "main"}, hash, false},
+		{Job{Commit: "refs/pull/42/merge"}, hash, false},
+	} {
+		if actual := test.job.MatchesCommit(test.commit); actual != test.expected {
+			t.Errorf("Expected %+v matching %s to be %v", test.job, test.commit, test.expected)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package minici
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLoadInterval is how often the host's load is checked when
+// AdaptiveConcurrency doesn't set an interval
+const DefaultLoadInterval = 15 * time.Second
+
+// HostLoad is how busy the machine running jobs is
+type HostLoad struct {
+	// LoadPerCPU is the one minute load average divided by the number of CPUs
+	LoadPerCPU float64
+	// FreeMemory is the memory available to start new processes with
+	FreeMemory ByteSize
+}
+
+// AdaptiveConcurrency scales how many jobs the server runs at once to the load
+// on its host, so it can share a machine with other services. The ceiling is
+// set with WithMaxConcurrentJobs. Each interval, one fewer job is run while
+// the host is over either limit, and one more once it is comfortably under
+// both: below 80% of MaxLoad and with a quarter more free memory than
+// MinFreeMemory. Running jobs are never stopped.
+type AdaptiveConcurrency struct {
+	// Min is the fewest jobs run at once however busy the host is, at least 1
+	Min int
+	// MaxLoad is the load per CPU above which fewer jobs are run, ignored if 0
+	MaxLoad float64
+	// MinFreeMemory is the free memory below which fewer jobs are run, ignored if 0
+	MinFreeMemory ByteSize
+	// Interval is how often the load is checked, defaults to DefaultLoadInterval
+	Interval time.Duration
+	// Sample reads the host's load, defaults to ReadHostLoad
+	Sample func() (HostLoad, error)
+}
+
+// WithAdaptiveConcurrency scales how many jobs the server runs at once to its
+// host's load, between adaptive.Min and WithMaxConcurrentJobs. It has no
+// effect unless WithMaxConcurrentJobs is set.
+func WithAdaptiveConcurrency(adaptive AdaptiveConcurrency) Option {
+	return func(s *CIServer) {
+		s.adaptive = &adaptive
+	}
+}
+
+// adaptConcurrency adjusts the local agent's capacity to the host's load
+// every interval
+func (s *CIServer) adaptConcurrency(adaptive AdaptiveConcurrency) {
+	interval := adaptive.Interval
+	if interval <= 0 {
+		interval = DefaultLoadInterval
+	}
+	sample := adaptive.Sample
+	if sample == nil {
+		sample = ReadHostLoad
+	}
+	for {
+		<-s.clock.After(interval)
+		// The load can't be read on every platform, so the capacity is left
+		// alone when it isn't known
+		if load, err := sample(); err == nil {
+			s.adjustConcurrency(adaptive, load)
+		}
+	}
+}
+
+// adjustConcurrency moves the local agent's capacity one step towards what
+// the host's load allows, starting jobs if it grew
+func (s *CIServer) adjustConcurrency(adaptive AdaptiveConcurrency, load HostLoad) {
+	overloaded := adaptive.MaxLoad > 0 && load.LoadPerCPU > adaptive.MaxLoad ||
+		adaptive.MinFreeMemory > 0 && load.FreeMemory < adaptive.MinFreeMemory
+	underloaded := (adaptive.MaxLoad == 0 || load.LoadPerCPU < adaptive.MaxLoad*0.8) &&
+		(adaptive.MinFreeMemory == 0 || load.FreeMemory > adaptive.MinFreeMemory+adaptive.MinFreeMemory/4)
+
+	s.jobMutex.Lock()
+	capacity := s.local.Capacity
+	switch {
+	case overloaded:
+		capacity = max(capacity-1, adaptive.Min, 1)
+	case underloaded:
+		capacity = min(capacity+1, s.maxConcurrent)
+	}
+	grew := capacity > s.local.Capacity
+	s.local.Capacity = capacity
+	s.jobMutex.Unlock()
+
+	if grew {
+		s.dispatch()
+	}
+}
+
+// ReadHostLoad reads the load average and available memory from /proc, so it
+// only works on Linux
+func ReadHostLoad() (HostLoad, error) {
+	loadavg, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return HostLoad{}, err
+	}
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return HostLoad{}, err
+	}
+	load, err := parseLoadAvg(loadavg)
+	if err != nil {
+		return HostLoad{}, err
+	}
+	free, err := parseMemAvailable(meminfo)
+	if err != nil {
+		return HostLoad{}, err
+	}
+	return HostLoad{LoadPerCPU: load / float64(runtime.NumCPU()), FreeMemory: free}, nil
+}
+
+// parseLoadAvg returns the one minute load average from /proc/loadavg
+func parseLoadAvg(content []byte) (float64, error) {
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid load average %q", content)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// parseMemAvailable returns MemAvailable from /proc/meminfo
+func parseMemAvailable(content []byte) (ByteSize, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemAvailable %q: %w", fields[1], err)
+		}
+		return ByteSize(kb * 1024), nil
+	}
+	return 0, fmt.Errorf("MemAvailable missing from meminfo")
+}
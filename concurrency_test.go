@@ -0,0 +1,53 @@
+package minici
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	adaptive := AdaptiveConcurrency{
+		Min:           2,
+		MaxLoad:       1,
+		MinFreeMemory: 1 << 30,
+		Interval:      time.Hour,
+		Sample:        func() (HostLoad, error) { return HostLoad{}, nil },
+	}
+	s := NewCIServer(WithMaxConcurrentJobs(4), WithAdaptiveConcurrency(adaptive)).(*CIServer)
+
+	steps := []struct {
+		name     string
+		load     HostLoad
+		expected int
+	}{
+		{"busy CPU", HostLoad{LoadPerCPU: 1.5, FreeMemory: 8 << 30}, 3},
+		{"low memory", HostLoad{LoadPerCPU: 0.1, FreeMemory: 512 << 20}, 2},
+		{"at the floor", HostLoad{LoadPerCPU: 3, FreeMemory: 0}, 2},
+		{"close to the limit", HostLoad{LoadPerCPU: 0.9, FreeMemory: 8 << 30}, 2},
+		{"quiet", HostLoad{LoadPerCPU: 0.2, FreeMemory: 8 << 30}, 3},
+		{"still quiet", HostLoad{LoadPerCPU: 0.2, FreeMemory: 8 << 30}, 4},
+		{"at the ceiling", HostLoad{LoadPerCPU: 0.2, FreeMemory: 8 << 30}, 4},
+	}
+	for _, step := range steps {
+		s.adjustConcurrency(adaptive, step.load)
+		if s.local.Capacity != step.expected {
+			t.Errorf("%s: expected a capacity of %d, got %d", step.name, step.expected, s.local.Capacity)
+		}
+	}
+}
+
+func TestParseHostLoad(t *testing.T) {
+	load, err := parseLoadAvg([]byte("2.50 1.20 0.80 3/512 12345\n"))
+	if err != nil || load != 2.5 {
+		t.Errorf("Expected a load of 2.5, got %v, %v", load, err)
+	}
+
+	meminfo := "MemTotal:       16384000 kB\nMemFree:         1024000 kB\nMemAvailable:    4096000 kB\n"
+	free, err := parseMemAvailable([]byte(meminfo))
+	if err != nil || free != 4096000*1024 {
+		t.Errorf("Expected 4096000 kB available, got %v, %v", free, err)
+	}
+	if _, err := parseMemAvailable([]byte("MemTotal: 16384000 kB\n")); err == nil {
+		t.Error("Expected an error without MemAvailable")
+	}
+}
@@ -0,0 +1,479 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// condition is a parsed step condition, such as
+// "branch == 'main' && changed('deploy/**')"
+type condition struct {
+	root conditionNode
+	// checksStatus is set if the condition calls success(), failure() or
+	// always(). Conditions that don't only run once everything before has
+	// succeeded.
+	checksStatus bool
+	// steps are the steps whose outcomes the condition reads
+	steps []string
+}
+
+// conditionContext is what conditions are evaluated against
+type conditionContext struct {
+	Branch  string
+	Tag     string
+	Ref     string
+	Commit  string
+	RepoURI string
+	Project string
+	Env     map[string]string
+	Labels  map[string]string
+	// Steps are the outcomes of the steps that have run or been skipped
+	Steps map[string]string
+	// Failed is set once the setup commands, the command or a step has failed
+	Failed bool
+	// Changed lists the files the commit changed, called the first time a
+	// condition needs them
+	Changed func() ([]string, error)
+}
+
+// eval reports whether the condition holds
+func (c *condition) eval(ctx *conditionContext) (bool, error) {
+	if !c.checksStatus && ctx.Failed {
+		return false, nil
+	}
+	return c.holds(ctx)
+}
+
+// holds evaluates the condition without eval's check that nothing has
+// failed, for hooks
+func (c *condition) holds(ctx *conditionContext) (bool, error) {
+	value, err := c.root.eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+// conditionNode is a part of a condition. Values are strings or bools.
+type conditionNode interface {
+	eval(ctx *conditionContext) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n literalNode) eval(*conditionContext) (any, error) {
+	return n.value, nil
+}
+
+// nameNode reads a value from the context, such as branch or env.GOOS
+type nameNode struct {
+	name string
+}
+
+func (n nameNode) eval(ctx *conditionContext) (any, error) {
+	switch n.name {
+	case "branch":
+		return ctx.Branch, nil
+	case "tag":
+		return ctx.Tag, nil
+	case "ref":
+		return ctx.Ref, nil
+	case "commit":
+		return ctx.Commit, nil
+	case "repo":
+		return ctx.RepoURI, nil
+	case "project":
+		return ctx.Project, nil
+	}
+	if key, ok := strings.CutPrefix(n.name, "env."); ok {
+		return ctx.Env[key], nil
+	}
+	if key, ok := strings.CutPrefix(n.name, "labels."); ok {
+		return ctx.Labels[key], nil
+	}
+	if step, ok := stepOutcomeName(n.name); ok {
+		return ctx.Steps[step], nil
+	}
+	return nil, fmt.Errorf("unknown name %q", n.name)
+}
+
+type notNode struct {
+	operand conditionNode
+}
+
+func (n notNode) eval(ctx *conditionContext) (any, error) {
+	value, err := n.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(value), nil
+}
+
+// logicalNode is && or ||, evaluating the right side only if needed
+type logicalNode struct {
+	and         bool
+	left, right conditionNode
+}
+
+func (n logicalNode) eval(ctx *conditionContext) (any, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(left) != n.and {
+		return truthy(left), nil
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(right), nil
+}
+
+// compareNode is == or !=, comparing values as strings
+type compareNode struct {
+	equal       bool
+	left, right conditionNode
+}
+
+func (n compareNode) eval(ctx *conditionContext) (any, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (fmt.Sprint(left) == fmt.Sprint(right)) == n.equal, nil
+}
+
+type callNode struct {
+	name string
+	args []conditionNode
+}
+
+// conditionFunctions are the functions conditions can call, with how many
+// arguments they take, or -1 for one or more
+var conditionFunctions = map[string]int{
+	"success":    0,
+	"failure":    0,
+	"always":     0,
+	"changed":    -1,
+	"startsWith": 2,
+	"endsWith":   2,
+	"contains":   2,
+}
+
+func (n callNode) eval(ctx *conditionContext) (any, error) {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = fmt.Sprint(value)
+	}
+	switch n.name {
+	case "success":
+		return !ctx.Failed, nil
+	case "failure":
+		return ctx.Failed, nil
+	case "always":
+		return true, nil
+	case "startsWith":
+		return strings.HasPrefix(args[0], args[1]), nil
+	case "endsWith":
+		return strings.HasSuffix(args[0], args[1]), nil
+	case "contains":
+		return strings.Contains(args[0], args[1]), nil
+	}
+
+	if ctx.Changed == nil {
+		return nil, errors.New("changed() isn't supported by this git client")
+	}
+	files, err := ctx.Changed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files: %w", err)
+	}
+	for _, file := range files {
+		for _, pattern := range args {
+			if matchChanged(pattern, file) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// truthy converts a value to a bool, strings being true unless empty
+func truthy(value any) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case string:
+		return value != ""
+	}
+	return false
+}
+
+// matchChanged reports whether a changed file matches a pattern. Patterns
+// use path.Match syntax, with ** matching any number of directories, and
+// naming a directory matches every file inside it.
+func matchChanged(pattern, file string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "**") {
+		for candidate := file; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+		return false
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("(/.*)?$")
+	matched, _ := regexp.MatchString(expr.String(), file)
+	return matched
+}
+
+// stepOutcomeName returns the step named by steps.<name>.outcome
+func stepOutcomeName(name string) (string, bool) {
+	rest, ok := strings.CutPrefix(name, "steps.")
+	if !ok {
+		return "", false
+	}
+	step, ok := strings.CutSuffix(rest, ".outcome")
+	return step, ok && step != ""
+}
+
+// conditionNames are the plain names conditions can read
+var conditionNames = []string{"branch", "tag", "ref", "commit", "repo", "project"}
+
+// parseCondition parses a step's if condition
+func parseCondition(source string) (*condition, error) {
+	tokens, err := lexCondition(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens, condition: &condition{}}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	p.condition.root = root
+	return p.condition, nil
+}
+
+// lexCondition splits a condition into tokens. String literals keep their
+// quotes, so they can be told apart from names.
+func lexCondition(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.HasPrefix(source[i:], "&&"), strings.HasPrefix(source[i:], "||"),
+			strings.HasPrefix(source[i:], "=="), strings.HasPrefix(source[i:], "!="):
+			tokens = append(tokens, source[i:i+2])
+			i += 2
+		case c == '!':
+			tokens = append(tokens, "!")
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(source[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, source[i:i+end+2])
+			i += end + 2
+		case isNameChar(c):
+			start := i
+			for i < len(source) && isNameChar(source[i]) {
+				i++
+			}
+			tokens = append(tokens, source[start:i])
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+// conditionParser parses conditions by recursive descent. From loosest to
+// tightest, the operators are ||, &&, == and !=, then !.
+type conditionParser struct {
+	tokens    []string
+	pos       int
+	condition *condition
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *conditionParser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			return fmt.Errorf("expected %q at the end", token)
+		}
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *conditionParser) or() (conditionNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right conditionNode
+		right, err = p.and()
+		left = logicalNode{left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *conditionParser) and() (conditionNode, error) {
+	left, err := p.compare()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right conditionNode
+		right, err = p.compare()
+		left = logicalNode{and: true, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *conditionParser) compare() (conditionNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek(); op == "==" || op == "!=" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{equal: op == "==", left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *conditionParser) unary() (conditionNode, error) {
+	if p.peek() == "!" {
+		p.next()
+		operand, err := p.unary()
+		return notNode{operand: operand}, err
+	}
+	return p.primary()
+}
+
+func (p *conditionParser) primary() (conditionNode, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, errors.New("unexpected end of condition")
+	case token == "(":
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case token[0] == '\'' || token[0] == '"':
+		return literalNode{value: token[1 : len(token)-1]}, nil
+	case token == "true" || token == "false":
+		return literalNode{value: token == "true"}, nil
+	case !isNameChar(token[0]):
+		return nil, fmt.Errorf("unexpected %q", token)
+	case p.peek() == "(":
+		return p.call(token)
+	}
+
+	if step, ok := stepOutcomeName(token); ok {
+		p.condition.steps = append(p.condition.steps, step)
+		return nameNode{name: token}, nil
+	}
+	known := strings.HasPrefix(token, "env.") && len(token) > len("env.") ||
+		strings.HasPrefix(token, "labels.") && len(token) > len("labels.")
+	for _, name := range conditionNames {
+		known = known || token == name
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown name %q, expected one of %s, env.<name>, labels.<name> or steps.<name>.outcome", token, strings.Join(conditionNames, ", "))
+	}
+	return nameNode{name: token}, nil
+}
+
+func (p *conditionParser) call(name string) (conditionNode, error) {
+	arity, ok := conditionFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s()", name)
+	}
+	p.next()
+	node := callNode{name: name}
+	for p.peek() != ")" {
+		if len(node.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, arg)
+	}
+	p.next()
+	switch {
+	case arity < 0 && len(node.args) == 0:
+		return nil, fmt.Errorf("%s() needs at least one argument", name)
+	case arity >= 0 && len(node.args) != arity:
+		return nil, fmt.Errorf("%s() takes %d arguments, got %d", name, arity, len(node.args))
+	}
+	if name == "success" || name == "failure" || name == "always" {
+		p.condition.checksStatus = true
+	}
+	return node, nil
+}
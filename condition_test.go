@@ -0,0 +1,100 @@
+package minici
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConditions(t *testing.T) {
+	ctx := &conditionContext{
+		Branch:  "main",
+		Ref:     "main",
+		Commit:  "0123abcd",
+		RepoURI: "https://github.com/ocuroot/minici",
+		Env:     map[string]string{"DEPLOY": "1"},
+		Labels:  map[string]string{"event": "push"},
+		Steps:   map[string]string{"build": StepSuccess},
+		Changed: func() ([]string, error) {
+			return []string{"README.md", "deploy/k8s/app.yaml", "cmd/minici/main.go"}, nil
+		},
+	}
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{"branch == 'main'", true},
+		{`branch != "main"`, false},
+		{"tag", false},
+		{"!tag && branch == 'main'", true},
+		{"tag || labels.event == 'push'", true},
+		{"(tag || env.DEPLOY == '1') && repo == 'https://github.com/ocuroot/minici'", true},
+		{"env.MISSING", false},
+		{"startsWith(commit, '0123') && endsWith(repo, '/minici') && contains(ref, 'ai')", true},
+		{"changed('deploy/**')", true},
+		{"changed('deploy')", true},
+		{"changed('**/*.go')", true},
+		{"changed('*.go')", false},
+		{"changed('**/*.md')", true},
+		{"changed('docs/', 'web/**')", false},
+		{"steps.build.outcome == 'success'", true},
+		{"success()", true},
+		{"failure()", false},
+		{"true", true},
+		{"false == false", true},
+	}
+	for _, test := range tests {
+		cond, err := parseCondition(test.condition)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.condition, err)
+			continue
+		}
+		if got, err := cond.eval(ctx); err != nil || got != test.expected {
+			t.Errorf("Expected %q to be %v, got %v, %v", test.condition, test.expected, got, err)
+		}
+	}
+
+	// Once something has failed, conditions that don't check the status are false
+	ctx.Failed = true
+	for condition, expected := range map[string]bool{
+		"branch == 'main'":               false,
+		"failure()":                      true,
+		"always()":                       true,
+		"failure() && branch == 'main'":  true,
+		"success() || branch == 'other'": false,
+	} {
+		cond, err := parseCondition(condition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := cond.eval(ctx); got != expected {
+			t.Errorf("Expected %q to be %v after a failure, got %v", condition, expected, got)
+		}
+	}
+
+	ctx.Changed = func() ([]string, error) { return nil, errors.New("no clone") }
+	cond, _ := parseCondition("always() && changed('docs')")
+	if _, err := cond.eval(ctx); err == nil || !strings.Contains(err.Error(), "no clone") {
+		t.Errorf("Expected the error listing changed files, got %v", err)
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	for condition, expected := range map[string]string{
+		"":                     "unexpected end of condition",
+		"branch ==":            "unexpected end of condition",
+		"branch = 'main'":      `unexpected '='`,
+		"brnch == 'main'":      `unknown name "brnch"`,
+		"'main":                "unterminated string",
+		"deploy()":             "unknown function deploy()",
+		"changed()":            "changed() needs at least one argument",
+		"startsWith(branch)":   "startsWith() takes 2 arguments, got 1",
+		"(branch == 'main'":    `expected ")" at the end`,
+		"branch == 'main' tag": `unexpected "tag"`,
+	} {
+		_, err := parseCondition(condition)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected parsing %q to fail with %q, got %v", condition, expected, err)
+		}
+	}
+}
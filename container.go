@@ -0,0 +1,150 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ContainerWorkdir is where the workspace is mounted inside containers
+const ContainerWorkdir = "/workspace"
+
+// ContainerExecutor runs commands inside a container when the job's spec names
+// an image, using the docker or podman CLI. Jobs without an image are passed to
+// Fallback.
+type ContainerExecutor struct {
+	// Runtime is the container CLI to use. Defaults to docker if it is
+	// installed, otherwise podman.
+	Runtime string
+	// UserNS is the user namespace mode passed to the runtime's --userns flag.
+	// Podman defaults to keep-id, so files the job writes to the workspace are
+	// owned by the user running minici rather than a subordinate ID it can't
+	// remove. Docker defaults to the daemon's setting.
+	UserNS string
+	// Fallback runs jobs that don't request an image, defaults to a LocalExecutor
+	Fallback Executor
+}
+
+// Run runs the command in a new container with the workspace bind mounted at
+// ContainerWorkdir. The container is removed when the command completes or the
+// job is cancelled.
+func (e *ContainerExecutor) Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error) {
+	if spec.Image == "" {
+		fallback := e.Fallback
+		if fallback == nil {
+			fallback = &LocalExecutor{}
+		}
+		return fallback.Run(ctx, workspace, spec)
+	}
+
+	log := workspace.Log
+	if len(strings.Fields(spec.Command)) == 0 {
+		log("Error: empty command")
+		return Result{ExitCode: -1}, fmt.Errorf("empty command")
+	}
+
+	runtime := e.runtime()
+	name := containerName(workspace.JobID)
+	log(fmt.Sprintf("Executing command in %s container %s: %s", spec.Image, name, spec.Command))
+
+	scratchDir := ""
+	if workspace.ScratchDir != "" {
+		scratchDir = ContainerScratchDir
+	}
+	spec.Env = cacheEnv(workspaceEnv(spec.Env, ContainerWorkdir, scratchDir), workspace, containerCachePath)
+	cmd := exec.CommandContext(ctx, runtime, e.runArgs(runtime, name, workspace, spec)...)
+	// Values are passed through the environment so they don't appear in process listings
+	cmd.Env = append(os.Environ(), envList(spec.Env)...)
+	// Killing the CLI doesn't necessarily stop the container, so remove it explicitly
+	cmd.Cancel = func() error {
+		exec.Command(runtime, "rm", "--force", name).Run()
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 10 * time.Second
+
+	err := runLogged(cmd, log)
+	result := Result{ExitCode: cmd.ProcessState.ExitCode()}
+	if err != nil {
+		log("Command execution failed: " + err.Error())
+		return result, err
+	}
+
+	log("Command executed successfully")
+	return result, nil
+}
+
+func (e *ContainerExecutor) runtime() string {
+	if e.Runtime != "" {
+		return e.Runtime
+	}
+	for _, runtime := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(runtime); err == nil {
+			return runtime
+		}
+	}
+	return "docker"
+}
+
+// isPodman reports whether the runtime is the podman CLI
+func isPodman(runtime string) bool {
+	return strings.HasPrefix(filepath.Base(runtime), "podman")
+}
+
+// userNS returns the --userns mode for the runtime, empty to leave it unset
+func (e *ContainerExecutor) userNS(runtime string) string {
+	if e.UserNS == "" && isPodman(runtime) {
+		return "keep-id"
+	}
+	return e.UserNS
+}
+
+// runArgs builds the arguments to the runtime's run command
+func (e *ContainerExecutor) runArgs(runtime, name string, workspace Workspace, spec JobSpec) []string {
+	volume := workspace.Dir + ":" + ContainerWorkdir
+	if isPodman(runtime) {
+		// Podman is commonly used on SELinux hosts, where the workspace must be
+		// relabelled before the container can access it. This is a no-op elsewhere.
+		volume += ":Z"
+	}
+	args := []string{
+		"run", "--rm",
+		"--name", name,
+		"--volume", volume,
+		"--workdir", ContainerWorkdir,
+	}
+	if userNS := e.userNS(runtime); userNS != "" {
+		args = append(args, "--userns", userNS)
+	}
+	if workspace.ScratchDir != "" {
+		scratch := workspace.ScratchDir + ":" + ContainerScratchDir
+		if isPodman(runtime) {
+			scratch += ":Z"
+		}
+		args = append(args, "--volume", scratch)
+	}
+	for _, key := range sortedKeys(workspace.Caches) {
+		volume := workspace.Caches[key] + ":" + containerCachePath(key, workspace.Caches[key])
+		if isPodman(runtime) {
+			// Lowercase z labels the cache so concurrent containers can share it
+			volume += ":z"
+		}
+		args = append(args, "--volume", volume)
+	}
+	for _, key := range sortedKeys(spec.Env) {
+		args = append(args, "--env", key)
+	}
+	args = append(args, spec.Image)
+	return append(args, strings.Fields(spec.Command)...)
+}
+
+// containerName returns a name for a job's container that can be used to remove it
+func containerName(jobID JobID) string {
+	if jobID == "" {
+		jobID = NewJobID()
+	}
+	return "minici-" + strings.ToLower(string(jobID))
+}
@@ -0,0 +1,166 @@
+package minici
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRuntime writes a script with the given name that prints its arguments
+// and the values of FOO and the workspace paths
+func fakeRuntime(t *testing.T, name string) string {
+	path := filepath.Join(t.TempDir(), name)
+	script := "#!/bin/sh\necho \"args: $*\"\necho \"FOO=$FOO\"\necho \"MINICI_WORKSPACE=$MINICI_WORKSPACE\"\necho \"MINICI_SCRATCH_DIR=$MINICI_SCRATCH_DIR\"\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestContainerExecutor(t *testing.T) {
+	var lines []string
+	dir, scratchDir := t.TempDir(), t.TempDir()
+	workspace := Workspace{JobID: "01ABC", Dir: dir, ScratchDir: scratchDir, Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	executor := &ContainerExecutor{Runtime: fakeRuntime(t, "docker")}
+
+	_, err := executor.Run(context.Background(), workspace, JobSpec{
+		Command: "go test ./...",
+		Image:   "golang:1.24",
+		Env:     map[string]string{"FOO": "bar"},
+	})
+	if err != nil {
+		t.Fatalf("Expected command to succeed, got %v", err)
+	}
+
+	expectedArgs := "> args: run --rm --name minici-01abc --volume " + dir + ":/workspace --workdir /workspace " +
+		"--volume " + scratchDir + ":/minici/scratch --env FOO --env MINICI_SCRATCH_DIR --env MINICI_WORKSPACE golang:1.24 go test ./..."
+	output := strings.Join(lines, "\n")
+	if !strings.Contains(output, expectedArgs) {
+		t.Errorf("Expected runtime to be called with %q, got:\n%s", expectedArgs, output)
+	}
+	// The value is passed through the environment rather than the arguments
+	if !strings.Contains(output, "> FOO=bar") {
+		t.Errorf("Expected env to be passed to the runtime, got:\n%s", output)
+	}
+	// Paths are where the container sees them
+	if !strings.Contains(output, "> MINICI_WORKSPACE=/workspace") || !strings.Contains(output, "> MINICI_SCRATCH_DIR=/minici/scratch") {
+		t.Errorf("Expected the workspace and scratch paths in the container, got:\n%s", output)
+	}
+}
+
+func TestContainerExecutorPodman(t *testing.T) {
+	runtime := fakeRuntime(t, "podman")
+	// Only podman is installed
+	t.Setenv("PATH", filepath.Dir(runtime))
+
+	var lines []string
+	dir := t.TempDir()
+	workspace := Workspace{JobID: "01ABC", Dir: dir, Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	executor := &ContainerExecutor{}
+
+	_, err := executor.Run(context.Background(), workspace, JobSpec{
+		Command: "make",
+		Image:   "alpine",
+	})
+	if err != nil {
+		t.Fatalf("Expected podman to be detected, got %v", err)
+	}
+
+	expectedArgs := "> args: run --rm --name minici-01abc --volume " + dir + ":/workspace:Z --workdir /workspace --userns keep-id --env MINICI_WORKSPACE alpine make"
+	output := strings.Join(lines, "\n")
+	if !strings.Contains(output, expectedArgs) {
+		t.Errorf("Expected runtime to be called with %q, got:\n%s", expectedArgs, output)
+	}
+}
+
+func TestContainerRunArgs(t *testing.T) {
+	workspace := Workspace{Dir: "/tmp/job"}
+	spec := JobSpec{Command: "make", Image: "alpine"}
+	tests := []struct {
+		name     string
+		executor ContainerExecutor
+		runtime  string
+		expected string
+	}{
+		{name: "docker", runtime: "docker", expected: "--workdir /workspace alpine"},
+		{name: "podman keeps the user's ID", runtime: "/usr/bin/podman", expected: "--workdir /workspace --userns keep-id alpine"},
+		{name: "podman override", executor: ContainerExecutor{UserNS: "host"}, runtime: "podman", expected: "--workdir /workspace --userns host alpine"},
+		{name: "docker override", executor: ContainerExecutor{UserNS: "host"}, runtime: "docker", expected: "--workdir /workspace --userns host alpine"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := strings.Join(test.executor.runArgs(test.runtime, "minici-job", workspace, spec), " ")
+			if !strings.Contains(args, test.expected) {
+				t.Errorf("Expected arguments containing %q, got %q", test.expected, args)
+			}
+		})
+	}
+}
+
+func TestContainerExecutorFallback(t *testing.T) {
+	var lines []string
+	workspace := Workspace{Dir: t.TempDir(), Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	executor := &ContainerExecutor{Runtime: "/does/not/exist"}
+
+	_, err := executor.Run(context.Background(), workspace, JobSpec{
+		Command: "sh -c echo$IFS$FOO",
+		Env:     map[string]string{"FOO": "local"},
+	})
+	if err != nil {
+		t.Fatalf("Expected command without an image to run locally, got %v", err)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "> local") {
+		t.Errorf("Expected local output, got %v", lines)
+	}
+}
+
+func TestRepoConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := LoadRepoConfig(dir)
+	if err != nil {
+		t.Fatalf("Expected missing config to be ignored, got %v", err)
+	}
+	if config.Image != "" {
+		t.Errorf("Expected empty config, got %+v", config)
+	}
+
+	content := "image: golang:1.24\nenv:\n  CGO_ENABLED: \"0\"\n  GOFLAGS: -mod=mod\nsetup:\n  - asdf install\n  - ./scripts/bootstrap.sh\n"
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadRepoConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Setup) != 2 || config.Setup[0] != "asdf install" {
+		t.Errorf("Expected setup commands from config, got %v", config.Setup)
+	}
+
+	spec := config.Apply(JobSpec{Command: "go test ./...", Env: map[string]string{"GOFLAGS": "-race"}})
+	if spec.Image != "golang:1.24" {
+		t.Errorf("Expected image from config, got %q", spec.Image)
+	}
+	if spec.Env["CGO_ENABLED"] != "0" || spec.Env["GOFLAGS"] != "-race" {
+		t.Errorf("Expected env to be merged with the spec taking precedence, got %v", spec.Env)
+	}
+
+	spec = config.Apply(JobSpec{Image: "golang:1.23"})
+	if spec.Image != "golang:1.23" {
+		t.Errorf("Expected image from spec to take precedence, got %q", spec.Image)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte("image: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRepoConfig(dir); err == nil {
+		t.Error("Expected invalid config to return an error")
+	}
+}
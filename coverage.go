@@ -0,0 +1,184 @@
+package minici
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseCoverage reads a Go coverprofile, Cobertura XML or lcov report and
+// returns the percentage of lines or statements covered
+func ParseCoverage(r io.Reader) (float64, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return parseCoverProfile(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return parseCobertura(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("TN:")), bytes.HasPrefix(trimmed, []byte("SF:")):
+		return parseLcov(trimmed)
+	}
+	return 0, errors.New("unrecognized coverage report, expected a Go coverprofile, Cobertura XML or lcov")
+}
+
+// parseCoverProfile counts statements the way go tool cover does. Blocks
+// listed more than once, as in merged profiles, count as covered if any of
+// their entries were run.
+func parseCoverProfile(content []byte) (float64, error) {
+	type block struct {
+		statements int
+		covered    bool
+	}
+	blocks := map[string]*block{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return 0, fmt.Errorf("invalid coverprofile line %q", line)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coverprofile line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coverprofile line %q", line)
+		}
+		b, ok := blocks[fields[0]]
+		if !ok {
+			b = &block{statements: statements}
+			blocks[fields[0]] = b
+		}
+		b.covered = b.covered || count > 0
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	var total, covered int
+	for _, b := range blocks {
+		total += b.statements
+		if b.covered {
+			covered += b.statements
+		}
+	}
+	return percentage(covered, total)
+}
+
+// parseCobertura prefers the line counts, and falls back to the line rate
+// for reports that don't include them
+func parseCobertura(content []byte) (float64, error) {
+	var report struct {
+		XMLName      xml.Name
+		LineRate     string `xml:"line-rate,attr"`
+		LinesCovered string `xml:"lines-covered,attr"`
+		LinesValid   string `xml:"lines-valid,attr"`
+	}
+	if err := xml.Unmarshal(content, &report); err != nil {
+		return 0, fmt.Errorf("invalid Cobertura report: %w", err)
+	}
+	if report.XMLName.Local != "coverage" {
+		return 0, fmt.Errorf("invalid Cobertura report: unexpected root element %s", report.XMLName.Local)
+	}
+	covered, coveredErr := strconv.Atoi(report.LinesCovered)
+	valid, validErr := strconv.Atoi(report.LinesValid)
+	if coveredErr == nil && validErr == nil && valid > 0 {
+		return percentage(covered, valid)
+	}
+	rate, err := strconv.ParseFloat(report.LineRate, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, errors.New("invalid Cobertura report: missing line-rate")
+	}
+	return rate * 100, nil
+}
+
+// parseLcov sums the lines found and hit in each source file's record
+func parseLcov(content []byte) (float64, error) {
+	var found, hit int
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || (key != "LF" && key != "LH") {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lcov line %s:%s", key, value)
+		}
+		if key == "LF" {
+			found += n
+		} else {
+			hit += n
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return percentage(hit, found)
+}
+
+func percentage(covered, total int) (float64, error) {
+	if total == 0 {
+		return 0, errors.New("coverage report has nothing to cover")
+	}
+	return 100 * float64(covered) / float64(total), nil
+}
+
+// collectCoverage reads the coverage report a job wrote. As with test
+// reports, problems are logged rather than failing the job.
+func collectCoverage(dir, file string, log func(string)) *float64 {
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		log(fmt.Sprintf("Invalid coverage report %q: must be relative to the repository root", file))
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		log(err.Error())
+		return nil
+	}
+	f, err := openReport(root, filepath.Join(dir, filepath.FromSlash(file)))
+	if err != nil {
+		log(fmt.Sprintf("Failed to read coverage report %s: %v", file, err))
+		return nil
+	}
+	defer f.Close()
+	coverage, err := ParseCoverage(f)
+	if err != nil {
+		log(fmt.Sprintf("Failed to read coverage report %s: %v", file, err))
+		return nil
+	}
+	log(fmt.Sprintf("Coverage %.1f%% from %s", coverage, file))
+	return &coverage
+}
+
+// LatestCoverage returns the coverage of the repo's most recently finished
+// job that reported it, if any
+func LatestCoverage(jobs []Job, repo string) (float64, bool) {
+	var covered []Job
+	for _, job := range jobs {
+		if job.RepoURI == repo && job.Coverage != nil && (job.Status == JobStatusSuccess || job.Status == JobStatusFailure) {
+			covered = append(covered, job)
+		}
+	}
+	if len(covered) == 0 {
+		return 0, false
+	}
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].FinishedAt.Before(covered[j].FinishedAt)
+	})
+	return *covered[len(covered)-1].Coverage, true
+}
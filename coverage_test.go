@@ -0,0 +1,110 @@
+package minici
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCoverage(t *testing.T) {
+	for name, test := range map[string]struct {
+		report   string
+		expected float64
+	}{
+		"coverprofile": {
+			report: `mode: set
+example.com/app/main.go:5.13,7.2 2 1
+example.com/app/main.go:9.13,11.2 1 0
+example.com/app/util.go:3.20,6.2 1 0
+example.com/app/util.go:3.20,6.2 1 1
+`,
+			// The util.go block was run by one of the merged profiles
+			expected: 75,
+		},
+		"cobertura": {
+			report: `<?xml version="1.0" ?>
+<coverage line-rate="0.5" lines-covered="41" lines-valid="50" version="1.9"><packages/></coverage>`,
+			expected: 82,
+		},
+		"cobertura line rate": {
+			report:   `<coverage line-rate="0.625"></coverage>`,
+			expected: 62.5,
+		},
+		"lcov": {
+			report: `TN:
+SF:src/app.js
+DA:1,1
+LF:10
+LH:9
+end_of_record
+SF:src/util.js
+LF:10
+LH:5
+end_of_record
+`,
+			expected: 70,
+		},
+	} {
+		coverage, err := ParseCoverage(strings.NewReader(test.report))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if math.Abs(coverage-test.expected) > 0.001 {
+			t.Errorf("%s: expected %.2f%%, got %.2f%%", name, test.expected, coverage)
+		}
+	}
+
+	for _, report := range []string{"", "mode: set\n", "coverage: 80%", "<testsuite/>", "SF:a.js\nend_of_record"} {
+		if _, err := ParseCoverage(strings.NewReader(report)); err == nil {
+			t.Errorf("Expected %q to be rejected", report)
+		}
+	}
+}
+
+func TestCollectCoverage(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"coverage.out": "mode: set\na.go:1.1,2.2 3 1\na.go:3.1,4.2 1 0\n",
+	})
+
+	var lines []string
+	log := func(line string) { lines = append(lines, line) }
+	coverage := collectCoverage(dir, "coverage.out", log)
+	if coverage == nil || *coverage != 75 {
+		t.Fatalf("Expected 75%% coverage, got %v", coverage)
+	}
+	if collectCoverage(dir, "missing.out", log) != nil || collectCoverage(dir, "../coverage.out", log) != nil {
+		t.Error("Expected missing and outside reports not to be read")
+	}
+	output := strings.Join(lines, "\n")
+	for _, expected := range []string{
+		"Coverage 75.0% from coverage.out",
+		"Failed to read coverage report missing.out",
+		`Invalid coverage report "../coverage.out"`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestLatestCoverage(t *testing.T) {
+	now := time.Now()
+	percent := func(value float64) *float64 { return &value }
+	jobs := []Job{
+		{RepoURI: "repo", Status: JobStatusSuccess, FinishedAt: now.Add(time.Minute), Coverage: percent(81)},
+		{RepoURI: "repo", Status: JobStatusSuccess, FinishedAt: now, Coverage: percent(90)},
+		// Ignored: no coverage, cancelled and another repo
+		{RepoURI: "repo", Status: JobStatusSuccess, FinishedAt: now.Add(2 * time.Minute)},
+		{RepoURI: "repo", Status: JobStatusCancelled, FinishedAt: now.Add(3 * time.Minute), Coverage: percent(10)},
+		{RepoURI: "other", Status: JobStatusSuccess, FinishedAt: now.Add(4 * time.Minute), Coverage: percent(20)},
+	}
+	if coverage, ok := LatestCoverage(jobs, "repo"); !ok || coverage != 81 {
+		t.Errorf("Expected the latest coverage of 81%%, got %v, %v", coverage, ok)
+	}
+	if _, ok := LatestCoverage(jobs, "missing"); ok {
+		t.Error("Expected no coverage for a repo without jobs")
+	}
+}
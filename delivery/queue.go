@@ -0,0 +1,362 @@
+// Package delivery provides a persistent queue for outbound HTTP calls, such as
+// commit status updates and notifications, that retries failed deliveries with
+// exponential backoff.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// Request is a single outbound HTTP call waiting to be delivered
+type Request struct {
+	ID      string            `json:"id"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+	// JobID is the job the delivery is about, if any, for logging
+	JobID string `json:"job_id,omitempty"`
+
+	// Attempts is the number of delivery attempts made so far
+	Attempts int `json:"attempts"`
+	// NextAttempt is the earliest time the next attempt can be made
+	NextAttempt time.Time `json:"next_attempt"`
+	// LastError describes why the most recent attempt failed
+	LastError string `json:"last_error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Config controls retry behaviour for a Queue
+type Config struct {
+	// Dir is the directory pending deliveries are persisted to.
+	// If empty, deliveries are only held in memory.
+	Dir string
+
+	// MaxAttempts is the number of attempts made before a delivery is abandoned
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+
+	// Client is the HTTP client used for deliveries
+	Client *http.Client
+}
+
+// DefaultConfig returns a Config with sensible retry settings for webhooks
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Client:         &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Queue delivers outbound requests in the background, retrying with exponential
+// backoff on network errors, 5xx and 429 responses.
+type Queue struct {
+	config Config
+
+	mutex   sync.Mutex
+	pending map[string]*Request
+	wake    chan struct{}
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewQueue creates a queue and loads any deliveries persisted by a previous run.
+// Call Start to begin delivering.
+func NewQueue(config Config) (*Queue, error) {
+	defaults := DefaultConfig()
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaults.InitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaults.MaxBackoff
+	}
+	if config.Client == nil {
+		config.Client = defaults.Client
+	}
+
+	q := &Queue{
+		config:  config,
+		pending: make(map[string]*Request),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if config.Dir != "" {
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// load reads pending deliveries from the queue directory
+func (q *Queue) load() error {
+	if err := os.MkdirAll(q.config.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create delivery directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(q.config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read delivery directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(q.config.Dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read delivery %s: %w", entry.Name(), err)
+		}
+		var req Request
+		if err := json.Unmarshal(content, &req); err != nil {
+			// A partially written file can't be recovered, skip it
+			continue
+		}
+		q.pending[req.ID] = &req
+	}
+	return nil
+}
+
+// Enqueue adds a request to the queue. The request is persisted before
+// Enqueue returns, so it will survive a restart.
+func (q *Queue) Enqueue(req Request) error {
+	if req.URL == "" {
+		return fmt.Errorf("delivery URL is required")
+	}
+	if req.ID == "" {
+		req.ID = ulid.Make().String()
+	}
+	if req.Method == "" {
+		req.Method = http.MethodPost
+	}
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now()
+	}
+
+	if err := q.persist(&req); err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	q.pending[req.ID] = &req
+	q.mutex.Unlock()
+
+	q.notify()
+	return nil
+}
+
+// Pending returns a copy of all deliveries that have not yet succeeded, oldest first
+func (q *Queue) Pending() []Request {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var out []Request
+	for _, req := range q.pending {
+		out = append(out, *req)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+// Start begins delivering requests in a background goroutine
+func (q *Queue) Start() {
+	go q.run()
+}
+
+// Close stops delivery. Undelivered requests remain persisted.
+func (q *Queue) Close() {
+	select {
+	case <-q.stop:
+		return
+	default:
+	}
+	close(q.stop)
+	<-q.stopped
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.stopped)
+
+	for {
+		wait := q.deliverDue()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.stop:
+			timer.Stop()
+			return
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue attempts every delivery whose next attempt time has passed and
+// returns how long to wait until the next one is due.
+func (q *Queue) deliverDue() time.Duration {
+	now := time.Now()
+	wait := time.Minute
+
+	q.mutex.Lock()
+	var due []*Request
+	for _, req := range q.pending {
+		if !req.NextAttempt.After(now) {
+			due = append(due, req)
+		} else if d := req.NextAttempt.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, req := range due {
+		select {
+		case <-q.stop:
+			return wait
+		default:
+		}
+		if next, retry := q.attempt(req); retry && next < wait {
+			wait = next
+		}
+	}
+	return wait
+}
+
+// attempt makes a single delivery attempt. If the delivery should be retried,
+// it returns the backoff before the next attempt and true.
+func (q *Queue) attempt(req *Request) (time.Duration, bool) {
+	retryable, err := q.send(req)
+
+	q.mutex.Lock()
+	req.Attempts++
+	if err == nil || !retryable || req.Attempts >= q.config.MaxAttempts {
+		delete(q.pending, req.ID)
+		q.mutex.Unlock()
+
+		if err != nil {
+			slog.Warn("Delivery abandoned", "delivery_id", req.ID, "job_id", req.JobID, "url", req.URL, "attempts", req.Attempts, "error", err)
+		}
+		q.remove(req.ID)
+		return 0, false
+	}
+
+	backoff := q.backoff(req.Attempts)
+	req.LastError = err.Error()
+	req.NextAttempt = time.Now().Add(backoff)
+	snapshot := *req
+	q.mutex.Unlock()
+
+	if err := q.persist(&snapshot); err != nil {
+		slog.Error("Failed to persist delivery", "delivery_id", req.ID, "job_id", req.JobID, "error", err)
+	}
+	return backoff, true
+}
+
+// send performs the HTTP call, reporting whether a failure is worth retrying
+func (q *Queue) send(req *Request) (bool, error) {
+	httpReq, err := http.NewRequestWithContext(context.Background(), req.Method, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := q.config.Client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("unexpected status: %s", resp.Status)
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retryable, err
+}
+
+// backoff returns the delay after the given number of attempts, doubling each
+// time up to MaxBackoff with up to 20% jitter so retries don't arrive in lockstep.
+func (q *Queue) backoff(attempts int) time.Duration {
+	backoff := q.config.InitialBackoff
+	for i := 1; i < attempts && backoff < q.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.config.MaxBackoff {
+		backoff = q.config.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(backoff)/5 + 1))
+	return backoff + jitter
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.config.Dir, id+".json")
+}
+
+// persist writes a delivery to disk atomically
+func (q *Queue) persist(req *Request) error {
+	if q.config.Dir == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery: %w", err)
+	}
+
+	tmp := q.path(req.ID) + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("failed to write delivery: %w", err)
+	}
+	if err := os.Rename(tmp, q.path(req.ID)); err != nil {
+		return fmt.Errorf("failed to write delivery: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) remove(id string) {
+	if q.config.Dir == "" {
+		return
+	}
+	os.Remove(q.path(id))
+}
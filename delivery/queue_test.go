@@ -0,0 +1,143 @@
+package delivery
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForEmpty(t *testing.T, q *Queue) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(q.Pending()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for deliveries, %d pending", len(q.Pending()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueRetriesUntilSuccess(t *testing.T) {
+	var calls atomic.Int32
+	var body atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		body.Store(string(content))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	q, err := NewQueue(Config{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	q.Start()
+	defer q.Close()
+
+	require.NoError(t, q.Enqueue(Request{URL: srv.URL, Body: []byte(`{"state":"success"}`)}))
+	waitForEmpty(t, q)
+
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, `{"state":"success"}`, body.Load())
+}
+
+func TestQueueAbandonsPermanentFailures(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	q, err := NewQueue(Config{InitialBackoff: 10 * time.Millisecond})
+	require.NoError(t, err)
+	q.Start()
+	defer q.Close()
+
+	require.NoError(t, q.Enqueue(Request{URL: srv.URL}))
+	waitForEmpty(t, q)
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestQueueAbandonsAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	q, err := NewQueue(Config{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+	require.NoError(t, err)
+	q.Start()
+	defer q.Close()
+
+	require.NoError(t, q.Enqueue(Request{URL: srv.URL}))
+	waitForEmpty(t, q)
+
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestQueuePersistsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := NewQueue(Config{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(Request{URL: "http://example.invalid/hook", Body: []byte("hello")}))
+
+	// Never started, so the delivery is still pending on disk
+	reloaded, err := NewQueue(Config{Dir: dir})
+	require.NoError(t, err)
+	pending := reloaded.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "http://example.invalid/hook", pending[0].URL)
+	assert.Equal(t, []byte("hello"), pending[0].Body)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	require.NoError(t, reloaded.Enqueue(Request{URL: srv.URL}))
+	reloaded.pending[pending[0].ID].URL = srv.URL
+	reloaded.Start()
+	defer reloaded.Close()
+	waitForEmpty(t, reloaded)
+
+	assert.Equal(t, int32(2), calls.Load())
+
+	final, err := NewQueue(Config{Dir: dir})
+	require.NoError(t, err)
+	assert.Empty(t, final.Pending())
+}
+
+func TestBackoffGrowsAndCaps(t *testing.T) {
+	q, err := NewQueue(Config{
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	assert.GreaterOrEqual(t, q.backoff(1), time.Second)
+	assert.GreaterOrEqual(t, q.backoff(3), 4*time.Second)
+	assert.Less(t, q.backoff(3), 5*time.Second)
+	assert.GreaterOrEqual(t, q.backoff(20), 10*time.Second)
+	assert.LessOrEqual(t, q.backoff(20), 12*time.Second)
+}
@@ -0,0 +1,52 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+)
+
+// DryRunner is implemented by CIs that can check a job without scheduling it
+type DryRunner interface {
+	// DryRun returns the error Submit would return for spec, without
+	// scheduling it. If resolve is set, the job's repo is also contacted to
+	// check it's reachable and find the commit spec.Commit refers to.
+	DryRun(ctx context.Context, spec JobSpec, resolve bool) (DryRun, error)
+}
+
+// DryRun describes the job Submit would schedule for a spec
+type DryRun struct {
+	// Number is the build number the job would be given, unless another job
+	// is scheduled for the repo first
+	Number int
+	// Commit is the hash spec.Commit resolved to, if it was resolved
+	Commit string
+}
+
+var _ DryRunner = &CIServer{}
+
+func (s *CIServer) DryRun(ctx context.Context, spec JobSpec, resolve bool) (DryRun, error) {
+	if err := spec.Validate(); err != nil {
+		return DryRun{}, err
+	}
+	s.jobMutex.Lock()
+	err := s.validateNeeds(spec)
+	if err == nil {
+		err = s.checkQueue(spec.Project)
+	}
+	run := DryRun{Number: s.buildNumbers[spec.RepoURI] + 1}
+	s.jobMutex.Unlock()
+	if err != nil || !resolve {
+		return run, err
+	}
+
+	resolver, ok := s.git.(RefResolver)
+	if !ok {
+		return run, ErrRefResolveUnsupported
+	}
+	commit, err := resolver.ResolveRef(ctx, spec.RepoURI, spec.Commit)
+	if err != nil {
+		return run, fmt.Errorf("failed to resolve %s in %s: %w", spec.Commit, spec.RepoURI, err)
+	}
+	run.Commit = commit
+	return run, nil
+}
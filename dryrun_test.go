@@ -0,0 +1,57 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	ci := NewCIServer(
+		WithGitClient(&fakeGit{}),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	).(DryRunner)
+	ctx := context.Background()
+	spec := JobSpec{RepoURI: "https://example.com/repo.git", Commit: "main", Command: "true"}
+
+	run, err := ci.DryRun(ctx, spec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Number != 1 || run.Commit != "0123abcd" {
+		t.Errorf("Expected build 1 at the resolved commit, got %+v", run)
+	}
+	if jobs := ci.(CI).ListJobs(); len(jobs) != 0 {
+		t.Errorf("Expected nothing to be scheduled, got %v", jobs)
+	}
+
+	ci.(CI).ScheduleJob(spec.RepoURI, spec.Commit, spec.Command)
+	if run, err := ci.DryRun(ctx, spec, false); err != nil || run.Number != 2 || run.Commit != "" {
+		t.Errorf("Expected the next build number without resolving, got %+v, %v", run, err)
+	}
+
+	missing := spec
+	missing.Commit = "missing"
+	if _, err := ci.DryRun(ctx, missing, true); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("Expected ErrRefNotFound, got %v", err)
+	}
+	needs := spec
+	needs.Needs = []JobNeed{{Job: "missing"}}
+	if _, err := ci.DryRun(ctx, needs, false); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected a missing needed job to be rejected, got %v", err)
+	}
+	if _, err := ci.DryRun(ctx, JobSpec{RepoURI: spec.RepoURI}, false); err == nil {
+		t.Error("Expected a spec without a command to be rejected")
+	}
+
+	// Without an agent to run it, the first job fills the queue
+	queued := NewCIServer(WithGitClient(struct{ GitClient }{&fakeGit{}}), WithLocalAgent(false), WithMaxQueuedJobs(1))
+	if _, err := queued.(DryRunner).DryRun(ctx, spec, true); !errors.Is(err, ErrRefResolveUnsupported) {
+		t.Errorf("Expected ErrRefResolveUnsupported for a git client that can't resolve refs, got %v", err)
+	}
+	queued.ScheduleJob(spec.RepoURI, spec.Commit, spec.Command)
+	if _, err := queued.(DryRunner).DryRun(ctx, spec, false); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+}
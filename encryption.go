@@ -0,0 +1,156 @@
+package minici
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix starts everything a Keyring encrypts, followed by the ID of
+// the key and the encoded nonce and ciphertext
+const encryptedPrefix = "minici-enc:v1:"
+
+// ErrNoKeyring is returned when reading encrypted data without a keyring
+var ErrNoKeyring = errors.New("data is encrypted, but no encryption key was given")
+
+// Keyring encrypts data at rest with AES-256-GCM. The first key encrypts and
+// every key decrypts, so a key is rotated by adding a new one first and
+// keeping the old one until nothing encrypted with it is left.
+type Keyring struct {
+	keys []keyringKey
+}
+
+type keyringKey struct {
+	// id is derived from the key, so data records which key encrypted it
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKeyring creates a keyring from 32 byte keys, the first of which encrypts
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a keyring needs at least one key")
+	}
+	keyring := &Keyring{}
+	for _, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption keys must be 32 bytes, not %d", len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		keyring.keys = append(keyring.keys, keyringKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	return keyring, nil
+}
+
+// LoadKeyring reads keys from a file of base64 encoded keys, one per line with
+// the key to encrypt with first. Blank lines and lines starting with # are
+// ignored.
+func LoadKeyring(path string) (*Keyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption keys: %w", err)
+	}
+	var keys [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key on line %d of %s: %w", line, path, err)
+		}
+		keys = append(keys, key)
+	}
+	keyring, err := NewKeyring(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption keys in %s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// IsEncrypted returns true if data was encrypted by a Keyring
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedPrefix))
+}
+
+// Encrypt encrypts data with the first key. The result is text without
+// newlines, so it can be stored anywhere a line of text can.
+func (k *Keyring) Encrypt(data []byte) ([]byte, error) {
+	key := k.keys[0]
+	nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(data)+key.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := key.aead.Seal(nonce, nonce, data, nil)
+	encoded := make([]byte, 0, len(encryptedPrefix)+len(key.id)+1+base64.RawStdEncoding.EncodedLen(len(sealed)))
+	encoded = append(encoded, encryptedPrefix...)
+	encoded = append(encoded, key.id...)
+	encoded = append(encoded, ':')
+	return base64.RawStdEncoding.AppendEncode(encoded, sealed), nil
+}
+
+// Decrypt decrypts data encrypted with any of the keys
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("data isn't encrypted")
+	}
+	id, encoded, ok := bytes.Cut(data[len(encryptedPrefix):], []byte(":"))
+	if !ok {
+		return nil, errors.New("invalid encrypted data")
+	}
+	for _, key := range k.keys {
+		if key.id != string(id) {
+			continue
+		}
+		sealed, err := base64.RawStdEncoding.AppendDecode(nil, encoded)
+		if err != nil || len(sealed) < key.aead.NonceSize() {
+			return nil, errors.New("invalid encrypted data")
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		data, err := key.aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data with key %s: %w", id, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("data was encrypted with key %s, which isn't in the keyring", id)
+}
+
+// decryptIfEncrypted decrypts data if it was encrypted, so data stored
+// before encryption was enabled can still be read
+func (k *Keyring) decryptIfEncrypted(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	return k.Decrypt(data)
+}
+
+// encryptIfEnabled encrypts data if there is a keyring
+func (k *Keyring) encryptIfEnabled(data []byte) ([]byte, error) {
+	if k == nil {
+		return data, nil
+	}
+	return k.Encrypt(data)
+}
@@ -0,0 +1,91 @@
+package minici
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 32)
+}
+
+func TestKeyring(t *testing.T) {
+	old, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := old.Encrypt([]byte("password: hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || bytes.Contains(encrypted, []byte("hunter2")) || bytes.ContainsRune(encrypted, '\n') {
+		t.Errorf("Expected a single line of ciphertext, got %q", encrypted)
+	}
+
+	// After rotating, the old key still decrypts but the new one encrypts
+	rotated, err := NewKeyring(testKey(2), testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := rotated.Decrypt(encrypted); err != nil || string(plain) != "password: hunter2" {
+		t.Errorf("Expected the old key to decrypt, got %q, %v", plain, err)
+	}
+	reencrypted, err := rotated.Encrypt([]byte("password: hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Decrypt(reencrypted); err == nil || !strings.Contains(err.Error(), "isn't in the keyring") {
+		t.Errorf("Expected the new key to be required, got %v", err)
+	}
+
+	tampered := bytes.Clone(encrypted)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := old.Decrypt(tampered); err == nil {
+		t.Error("Expected tampered data to be rejected")
+	}
+	if _, err := NewKeyring([]byte("short")); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated in March\n" + base64.StdEncoding.EncodeToString(testKey(2)) + "\n\n" + base64.StdEncoding.EncodeToString(testKey(1)) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyring.keys) != 2 {
+		t.Errorf("Expected two keys, got %d", len(keyring.keys))
+	}
+
+	os.WriteFile(path, []byte("not base64!\n"), 0o600)
+	if _, err := LoadKeyring(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected the invalid line to be reported, got %v", err)
+	}
+}
+
+func TestLoadSecretsWithKeyring(t *testing.T) {
+	keyring, _ := NewKeyring(testKey(1))
+	encrypted, _ := keyring.Encrypt([]byte("registries:\n  ghcr.io:\n    username: ci\n    password: hunter2\n"))
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	os.WriteFile(path, append(encrypted, '\n'), 0o600)
+
+	secrets, err := LoadSecretsWithKeyring(path, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Registries["ghcr.io"].Password != "hunter2" {
+		t.Errorf("Unexpected secrets %+v", secrets)
+	}
+	if _, err := LoadSecrets(path); err == nil || !strings.Contains(err.Error(), "no encryption key") {
+		t.Errorf("Expected encrypted secrets to need a key, got %v", err)
+	}
+}
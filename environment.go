@@ -0,0 +1,126 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrUnknownEnvironment is returned when rolling back an environment
+	// nothing has deployed to
+	ErrUnknownEnvironment = errors.New("nothing has been deployed to the environment")
+	// ErrRepoRequired is returned when rolling back an environment several
+	// repos deploy to without saying which
+	ErrRepoRequired = errors.New("several repos deploy to the environment, so the repo to roll back is required")
+	// ErrNoRollback is returned when rolling back a repo that has only ever
+	// deployed one commit to the environment
+	ErrNoRollback = errors.New("there's no earlier commit to roll back to")
+)
+
+// environmentNamePattern matches the names jobs can give their environments
+var environmentNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Environment is somewhere jobs deploy to, such as staging or prod, with what
+// each repo last deployed there
+type Environment struct {
+	Name string
+	// Deployments has one entry for each repo deployed to the environment,
+	// sorted by repo URI
+	Deployments []Deployment
+}
+
+// Deployment is the latest job that successfully deployed a repo to an
+// environment
+type Deployment struct {
+	RepoURI string
+	// Commit is the commit the job was given, such as a hash or tag
+	Commit string
+	JobID  JobID
+	Number int
+	// DeployedAt is when the job finished
+	DeployedAt time.Time
+}
+
+// Environments lists the environments jobs have deployed to, sorted by name,
+// with the commit each repo last deployed to them. Only jobs that succeeded
+// count, so a failed deploy leaves the previous one in place.
+func Environments(jobs []Job) []Environment {
+	latest := map[string]map[string]Job{}
+	for _, job := range jobs {
+		if job.Environment == "" || job.Status != JobStatusSuccess {
+			continue
+		}
+		repos := latest[job.Environment]
+		if repos == nil {
+			repos = map[string]Job{}
+			latest[job.Environment] = repos
+		}
+		if previous, ok := repos[job.RepoURI]; !ok || job.FinishedAt.After(previous.FinishedAt) {
+			repos[job.RepoURI] = job
+		}
+	}
+
+	environments := make([]Environment, 0, len(latest))
+	for name, repos := range latest {
+		environment := Environment{Name: name}
+		for _, job := range repos {
+			environment.Deployments = append(environment.Deployments, Deployment{
+				RepoURI:    job.RepoURI,
+				Commit:     job.Commit,
+				JobID:      job.ID,
+				Number:     job.Number,
+				DeployedAt: job.FinishedAt,
+			})
+		}
+		sort.Slice(environment.Deployments, func(i, j int) bool {
+			return environment.Deployments[i].RepoURI < environment.Deployments[j].RepoURI
+		})
+		environments = append(environments, environment)
+	}
+	sort.Slice(environments, func(i, j int) bool {
+		return environments[i].Name < environments[j].Name
+	})
+	return environments
+}
+
+// RollbackSpec returns a job that rolls a repo in an environment back to the
+// commit it deployed there before its current one, by running the job that
+// deployed that commit again. Earlier deploys of the current commit are
+// skipped, so rolling back after redeploying still changes the commit. repo
+// may be empty if only one repo has deployed to the environment.
+func RollbackSpec(jobs []Job, environment, repo string) (JobSpec, error) {
+	var deploys []Job
+	repos := map[string]bool{}
+	for _, job := range jobs {
+		if job.Environment != environment || job.Status != JobStatusSuccess {
+			continue
+		}
+		repos[job.RepoURI] = true
+		if repo == "" || job.RepoURI == repo {
+			deploys = append(deploys, job)
+		}
+	}
+	switch {
+	case len(deploys) == 0:
+		return JobSpec{}, ErrUnknownEnvironment
+	case repo == "" && len(repos) > 1:
+		return JobSpec{}, ErrRepoRequired
+	}
+	sort.SliceStable(deploys, func(i, j int) bool {
+		return deploys[i].FinishedAt.After(deploys[j].FinishedAt)
+	})
+	for _, job := range deploys[1:] {
+		if job.Commit == deploys[0].Commit {
+			continue
+		}
+		spec := job.Spec()
+		spec.Name = fmt.Sprintf("Roll back %s to %s", environment, job.Commit)
+		// The jobs it needed have already run for the original deploy
+		spec.Needs = nil
+		return spec, nil
+	}
+	return JobSpec{}, ErrNoRollback
+}
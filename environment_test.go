@@ -0,0 +1,105 @@
+package minici
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvironments(t *testing.T) {
+	now := time.Now()
+	jobs := []Job{
+		{ID: "1", RepoURI: "api", Commit: "v1", Number: 1, Environment: "prod", Status: JobStatusSuccess, FinishedAt: now},
+		{ID: "2", RepoURI: "api", Commit: "v2", Number: 2, Environment: "prod", Status: JobStatusSuccess, FinishedAt: now.Add(time.Minute)},
+		{ID: "3", RepoURI: "web", Commit: "abc123", Number: 7, Environment: "prod", Status: JobStatusSuccess, FinishedAt: now},
+		{ID: "4", RepoURI: "api", Commit: "v3", Number: 3, Environment: "staging", Status: JobStatusSuccess, FinishedAt: now},
+		// Ignored: a failed deploy, a running one and a build without an environment
+		{ID: "5", RepoURI: "api", Commit: "v4", Number: 4, Environment: "prod", Status: JobStatusFailure, FinishedAt: now.Add(2 * time.Minute)},
+		{ID: "6", RepoURI: "api", Commit: "v5", Number: 5, Environment: "prod", Status: JobStatusRunning},
+		{ID: "7", RepoURI: "api", Commit: "v6", Number: 6, Status: JobStatusSuccess, FinishedAt: now.Add(3 * time.Minute)},
+	}
+	expected := []Environment{
+		{Name: "prod", Deployments: []Deployment{
+			{RepoURI: "api", Commit: "v2", JobID: "2", Number: 2, DeployedAt: now.Add(time.Minute)},
+			{RepoURI: "web", Commit: "abc123", JobID: "3", Number: 7, DeployedAt: now},
+		}},
+		{Name: "staging", Deployments: []Deployment{
+			{RepoURI: "api", Commit: "v3", JobID: "4", Number: 3, DeployedAt: now},
+		}},
+	}
+	if got := Environments(jobs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected environments %+v, got %+v", expected, got)
+	}
+	if got := Environments(nil); len(got) != 0 {
+		t.Errorf("Expected no environments without jobs, got %+v", got)
+	}
+}
+
+func TestSubmitEnvironment(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false))
+	spec := JobSpec{RepoURI: "https://example.com/repo.git", Commit: "main", Command: "./deploy.sh", Environment: "prod us"}
+	if _, err := ci.Submit(spec); err == nil || !strings.Contains(err.Error(), `invalid environment "prod us"`) {
+		t.Errorf("Expected an invalid environment to be rejected, got %v", err)
+	}
+	spec.Environment = "prod-us.1"
+	id, err := ci.Submit(spec)
+	if err != nil {
+		t.Fatalf("Failed to submit job: %v", err)
+	}
+	if job := ci.JobDetail(id); job.Environment != "prod-us.1" || job.Spec().Environment != "prod-us.1" {
+		t.Errorf("Expected the job to record its environment, got %+v", job)
+	}
+}
+
+func TestRollbackSpec(t *testing.T) {
+	now := time.Now()
+	deploy := func(id JobID, repo, commit string, minutes int) Job {
+		return Job{
+			ID: id, RepoURI: repo, Commit: commit, Command: "./deploy.sh prod", Environment: "prod",
+			Env: map[string]string{"REGION": "eu"}, Needs: []JobNeed{{Job: "build"}},
+			Status: JobStatusSuccess, FinishedAt: now.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+	failed := deploy("4", "api", "v4", 4)
+	failed.Status = JobStatusFailure
+	jobs := []Job{
+		deploy("1", "api", "v1", 1),
+		deploy("2", "api", "v2", 2),
+		deploy("3", "api", "v3", 3),
+		// A redeploy of the current commit and a failed deploy are skipped
+		deploy("5", "api", "v3", 5),
+		failed,
+	}
+
+	spec, err := RollbackSpec(jobs, "prod", "")
+	if err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	expected := JobSpec{
+		RepoURI: "api", Commit: "v2", Command: "./deploy.sh prod", Environment: "prod",
+		Name: "Roll back prod to v2", Env: map[string]string{"REGION": "eu"},
+	}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected rollback %+v, got %+v", expected, spec)
+	}
+
+	for _, test := range []struct {
+		jobs        []Job
+		environment string
+		repo        string
+		expected    error
+	}{
+		{jobs: jobs, environment: "staging", expected: ErrUnknownEnvironment},
+		{jobs: jobs, environment: "prod", repo: "web", expected: ErrUnknownEnvironment},
+		{jobs: append(jobs, deploy("6", "web", "abc", 6)), environment: "prod", expected: ErrRepoRequired},
+		{jobs: []Job{deploy("1", "api", "v1", 1), deploy("2", "api", "v1", 2)}, environment: "prod", expected: ErrNoRollback},
+	} {
+		if _, err := RollbackSpec(test.jobs, test.environment, test.repo); err != test.expected {
+			t.Errorf("Expected %v rolling back %s, got %v", test.expected, test.environment, err)
+		}
+	}
+	if spec, err := RollbackSpec(append(jobs, deploy("6", "web", "abc", 6)), "prod", "api"); err != nil || spec.Commit != "v2" {
+		t.Errorf("Expected the repo's rollback to v2, got %+v, %v", spec, err)
+	}
+}
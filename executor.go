@@ -0,0 +1,113 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Executor runs a job's command in a workspace that has already been prepared,
+// for example as a local process or in a container.
+type Executor interface {
+	// Run executes the command described by spec, passing output to the
+	// workspace's Log as it is produced. It returns an error if the command
+	// couldn't be run or didn't succeed, and should stop promptly once ctx
+	// is cancelled.
+	Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error)
+}
+
+// Workspace is where a job runs
+type Workspace struct {
+	JobID JobID
+	// Dir contains the checked out repository
+	Dir string
+	// ScratchDir is an empty directory outside Dir for temporary files,
+	// removed when the job finishes. Executors that run commands elsewhere
+	// give them their own.
+	ScratchDir string
+	// Commit is the hash of the commit checked out
+	Commit string
+	// Log appends a line to the job's logs
+	Log func(line string)
+	// Caches maps environment variables such as GOMODCACHE to tool cache
+	// directories on this machine. Executors that run commands elsewhere
+	// ignore them.
+	Caches map[string]string
+}
+
+// Result describes how a command finished
+type Result struct {
+	// ExitCode is the command's exit code, or -1 if it didn't run to completion
+	ExitCode int
+}
+
+// LocalExecutor runs commands as processes on the local machine
+type LocalExecutor struct{}
+
+// Run runs the command in the workspace directory and logs its combined output.
+// The command string is split on whitespace, it is not passed to a shell.
+func (e *LocalExecutor) Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error) {
+	log := workspace.Log
+	log("Executing command: " + spec.Command)
+
+	// Split the command string into the command and its arguments
+	cmdParts := strings.Fields(spec.Command)
+	if len(cmdParts) == 0 {
+		log("Error: empty command")
+		return Result{ExitCode: -1}, fmt.Errorf("empty command")
+	}
+
+	// Create the command
+	cmd := exec.CommandContext(ctx, cmdParts[0], cmdParts[1:]...)
+	cmd.Dir = workspace.Dir
+	env := workspaceEnv(spec.Env, workspace.Dir, workspace.ScratchDir)
+	cmd.Env = append(os.Environ(), envList(cacheEnv(env, workspace, func(key, dir string) string {
+		return dir
+	}))...)
+
+	err := runLogged(cmd, log)
+	// ExitCode is -1 if the process didn't start or was killed
+	result := Result{ExitCode: cmd.ProcessState.ExitCode()}
+	if err != nil {
+		log("Command execution failed: " + err.Error())
+		return result, err
+	}
+
+	log("Command executed successfully")
+	return result, nil
+}
+
+// envList converts environment variables to KEY=value form, sorted by key
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for _, key := range sortedKeys(env) {
+		list = append(list, key+"="+env[key])
+	}
+	return list
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runLogged runs a command, streaming its combined output to log line by line
+func runLogged(cmd *exec.Cmd, log func(string)) error {
+	output := &lineWriter{log: func(line string) {
+		if line != "" {
+			log("> " + line)
+		}
+	}}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	output.Flush()
+	return err
+}
@@ -0,0 +1,237 @@
+package minici
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/ocuroot/gittools"
+)
+
+// GitClient fetches the repositories jobs run in. It can be replaced with
+// WithGitClient, such as to test without a git binary.
+type GitClient interface {
+	// Clone clones the repository at repoURI into dir, which exists and is empty
+	Clone(ctx context.Context, repoURI, dir string) error
+	// Checkout checks out a commit, branch or tag in the clone at dir,
+	// returning the hash of the commit checked out
+	Checkout(ctx context.Context, dir, ref string) (string, error)
+}
+
+// RefResolver is implemented by git clients that can find the commit a
+// branch or tag points to without cloning, such as to check a job's commit
+// exists before it's scheduled
+type RefResolver interface {
+	// ResolveRef returns the hash of the commit ref points to in the repo at
+	// repoURI. Commit hashes the repo doesn't name as a ref are returned as
+	// they are once the repo is found to be reachable, as checking them
+	// needs a clone.
+	ResolveRef(ctx context.Context, repoURI, ref string) (string, error)
+}
+
+// CommitLister is implemented by git clients that can list the commits in a
+// range, such as to build each of them
+type CommitLister interface {
+	// ListCommits returns the hashes of the commits in the repo at repoURI
+	// that are descendants of from and ancestors of to, including to, oldest
+	// first
+	ListCommits(ctx context.Context, repoURI, from, to string) ([]string, error)
+}
+
+var (
+	// ErrRefNotFound is returned when a repo has no branch or tag with a name
+	ErrRefNotFound = errors.New("no branch or tag found")
+	// ErrRefResolveUnsupported is returned when the git client isn't a
+	// RefResolver
+	ErrRefResolveUnsupported = errors.New("git client can't resolve refs without cloning")
+	// ErrListCommitsUnsupported is returned when the git client isn't a
+	// CommitLister
+	ErrListCommitsUnsupported = errors.New("git client can't list commits")
+)
+
+// commitHashPattern matches abbreviated and full commit hashes
+var commitHashPattern = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+
+// GitCLI is a GitClient that runs the git command line, used unless
+// WithGitClient is given
+type GitCLI struct{}
+
+var _ GitClient = GitCLI{}
+
+func (GitCLI) Clone(ctx context.Context, repoURI, dir string) error {
+	client := &gittools.Client{}
+	_, err := client.CloneWithOptions(gittools.CloneOptions{
+		URL:         repoURI,
+		Destination: dir,
+		Context:     ctx,
+	})
+	return err
+}
+
+// Checkout fetches refs outside branches and tags first, such as
+// refs/pull/42/merge, as clones only include branches and tags
+func (GitCLI) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	repo, err := gittools.Open(dir)
+	if err != nil {
+		return "", err
+	}
+	if isFetchedRef(ref) {
+		if _, err := runGit(ctx, dir, "fetch", "--quiet", "origin", "+"+ref+":"+ref); err != nil {
+			return "", err
+		}
+	}
+	if err := repo.Checkout(ref); err != nil {
+		return "", err
+	}
+	commit, err := repo.RevParse("HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(commit), nil
+}
+
+func (GitCLI) ResolveRef(ctx context.Context, repoURI, ref string) (string, error) {
+	refs, err := lsRemote(ctx, repoURI, ref)
+	if err != nil {
+		return "", err
+	}
+	// Annotated tags are listed with the commit they point to under ^{}
+	for _, name := range []string{"refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref, ref} {
+		if hash, ok := refs[name]; ok {
+			return hash, nil
+		}
+	}
+	if !commitHashPattern.MatchString(ref) {
+		return "", fmt.Errorf("%w named %q", ErrRefNotFound, ref)
+	}
+	// Listing HEAD is enough to tell the repo is reachable
+	if _, err := lsRemote(ctx, repoURI, "HEAD"); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// ListCommits fetches the repo's history without file contents to list the
+// commits in the range
+func (GitCLI) ListCommits(ctx context.Context, repoURI, from, to string) ([]string, error) {
+	dir, err := os.MkdirTemp("", "minici-commits-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := runGit(ctx, "", "clone", "--quiet", "--bare", "--filter=blob:none", "--", repoURI, dir); err != nil {
+		return nil, err
+	}
+	output, err := runGit(ctx, dir, "rev-list", "--reverse", "--ancestry-path", from+".."+to, "--")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// isFetchedRef returns true for full ref names that aren't a branch or tag,
+// which need fetching explicitly
+func isFetchedRef(ref string) bool {
+	return strings.HasPrefix(ref, "refs/") && !strings.HasPrefix(ref, "refs/heads/") && !strings.HasPrefix(ref, "refs/tags/")
+}
+
+// runGit runs a git command in dir, returning its output
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Fail rather than waiting for credentials nobody will type
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %s: %w", args[0], strings.TrimSpace(stderr.String()), err)
+	}
+	return output, nil
+}
+
+// lsRemote lists the refs in a remote repo matching pattern, by name
+func lsRemote(ctx context.Context, repoURI, pattern string) (map[string]string, error) {
+	output, err := runGit(ctx, "", "ls-remote", "--", repoURI, pattern)
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if hash, name, ok := strings.Cut(scanner.Text(), "\t"); ok {
+			refs[name] = hash
+		}
+	}
+	return refs, nil
+}
+
+// WithGitClient sets how repos are cloned for jobs run on the server
+func WithGitClient(client GitClient) Option {
+	return func(s *CIServer) {
+		s.git = client
+	}
+}
+
+// WithMaxConcurrentClones limits how many repos are cloned at once for jobs
+// run on the server, independently of how many jobs run at once. Further jobs
+// wait to clone until another clone finishes. 0 is unlimited.
+func WithMaxConcurrentClones(n int) Option {
+	return func(s *CIServer) {
+		s.maxClones = n
+	}
+}
+
+// LimitClones returns a GitClient that runs at most n of client's clones at
+// once. Checkouts aren't limited, as they only touch the local clone.
+func LimitClones(client GitClient, n int) GitClient {
+	if n <= 0 {
+		return client
+	}
+	return cloneLimiter{GitClient: client, slots: make(chan struct{}, n)}
+}
+
+type cloneLimiter struct {
+	GitClient
+	slots chan struct{}
+}
+
+func (l cloneLimiter) Clone(ctx context.Context, repoURI, dir string) error {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l.slots }()
+	return l.GitClient.Clone(ctx, repoURI, dir)
+}
+
+// ResolveRef isn't limited, as it doesn't clone
+func (l cloneLimiter) ResolveRef(ctx context.Context, repoURI, ref string) (string, error) {
+	resolver, ok := l.GitClient.(RefResolver)
+	if !ok {
+		return "", ErrRefResolveUnsupported
+	}
+	return resolver.ResolveRef(ctx, repoURI, ref)
+}
+
+// ListCommits is limited like a clone, as listing commits fetches the repo
+func (l cloneLimiter) ListCommits(ctx context.Context, repoURI, from, to string) ([]string, error) {
+	lister, ok := l.GitClient.(CommitLister)
+	if !ok {
+		return nil, ErrListCommitsUnsupported
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.slots }()
+	return lister.ListCommits(ctx, repoURI, from, to)
+}
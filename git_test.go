@@ -0,0 +1,350 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/gittools"
+)
+
+// fakeGit "clones" a repo by writing a file naming it, and only knows the main branch
+type fakeGit struct {
+	cloned []string
+}
+
+func (g *fakeGit) Clone(ctx context.Context, repoURI, dir string) error {
+	g.cloned = append(g.cloned, repoURI)
+	return os.WriteFile(filepath.Join(dir, "README"), []byte("cloned "+repoURI), 0o644)
+}
+
+func (g *fakeGit) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	if ref != "main" {
+		return "", errors.New("unknown ref " + ref)
+	}
+	return "0123abcd", nil
+}
+
+func (g *fakeGit) ResolveRef(ctx context.Context, repoURI, ref string) (string, error) {
+	if ref != "main" {
+		return "", ErrRefNotFound
+	}
+	return "0123abcd", nil
+}
+
+// fullGitClient is implemented by the git clients that work on real repos
+type fullGitClient interface {
+	GitClient
+	RefResolver
+	CommitLister
+	CheckoutDescriber
+}
+
+// gitClients are run against the same repos, so they behave alike
+var gitClients = []struct {
+	name   string
+	client fullGitClient
+}{
+	{name: "cli", client: GitCLI{}},
+	{name: "go-git", client: GoGit{}},
+}
+
+func TestGitClient(t *testing.T) {
+	git := &fakeGit{}
+	ci := NewCIServer(
+		WithGitClient(git),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	)
+
+	id := ci.ScheduleJob("https://example.com/repo.git", "main", "cat README")
+	if job := ci.JobDetail(id); job.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %s: %q", job.Status, ci.JobLogs(id))
+	}
+	if !strings.Contains(strings.Join(ci.JobLogs(id), "\n"), "cloned https://example.com/repo.git") {
+		t.Errorf("Expected the job to run in the fake clone, got %q", ci.JobLogs(id))
+	}
+	if hash := ci.JobDetail(id).CommitHash; hash != "0123abcd" {
+		t.Errorf("Expected the hash checked out to be recorded, got %q", hash)
+	}
+
+	id = ci.ScheduleJob("https://example.com/repo.git", "missing", "cat README")
+	if job := ci.JobDetail(id); job.Status != JobStatusFailure {
+		t.Errorf("Expected checking out an unknown ref to fail the job, got %s", job.Status)
+	}
+	if !strings.Contains(strings.Join(ci.JobLogs(id), "\n"), "unknown ref missing") {
+		t.Errorf("Expected the checkout error to be logged, got %q", ci.JobLogs(id))
+	}
+	if len(git.cloned) != 2 {
+		t.Errorf("Expected a clone for each job, got %v", git.cloned)
+	}
+}
+
+func TestGoGitJob(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("go_git_job_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	ci := NewCIServer(
+		WithGitClient(GoGit{}),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	)
+
+	id := ci.ScheduleJob(barePath, "master", "cat README.md")
+	if job := ci.JobDetail(id); job.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %s: %q", job.Status, ci.JobLogs(id))
+	}
+	if !strings.Contains(strings.Join(ci.JobLogs(id), "\n"), "# Test Repository") {
+		t.Errorf("Expected the job to run in the clone, got %q", ci.JobLogs(id))
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("checkout_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	ctx := context.Background()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=minici", "-c", "user.email=minici@example.com"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	// Push a branch ahead of master and an annotated tag on master
+	clone := t.TempDir()
+	if err := (GitCLI{}).Clone(ctx, barePath, clone); err != nil {
+		t.Fatal(err)
+	}
+	git(clone, "tag", "-a", "-m", "release", "v1.0.0")
+	git(clone, "commit", "-q", "--allow-empty", "-m", "feature")
+	git(clone, "push", "-q", "origin", "HEAD:refs/heads/feature", "v1.0.0")
+	hashes := strings.Fields(git(clone, "rev-parse", "HEAD", "HEAD^1"))
+	feature, master := hashes[0], hashes[1]
+
+	for _, client := range gitClients {
+		t.Run(client.name, func(t *testing.T) {
+			for ref, expected := range map[string]string{
+				"feature":   feature,
+				"v1.0.0":    master,
+				feature[:7]: feature,
+				"master":    master,
+			} {
+				dir := t.TempDir()
+				if err := client.client.Clone(ctx, barePath, dir); err != nil {
+					t.Fatal(err)
+				}
+				if commit, err := client.client.Checkout(ctx, dir, ref); err != nil || commit != expected {
+					t.Errorf("Expected %s to check out %s, got %q, %v", ref, expected, commit, err)
+				}
+				if content, err := os.ReadFile(filepath.Join(dir, "README.md")); err != nil || len(content) == 0 {
+					t.Errorf("Expected %s's files to be checked out, got %v", ref, err)
+				}
+			}
+
+			dir := t.TempDir()
+			if err := client.client.Clone(ctx, barePath, dir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.client.Checkout(ctx, dir, "missing"); err == nil {
+				t.Error("Expected checking out a missing ref to fail")
+			}
+		})
+	}
+}
+
+// blockingGit counts the clones in progress, which finish once release is closed
+type blockingGit struct {
+	fakeGit
+	active  atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *blockingGit) Clone(ctx context.Context, repoURI, dir string) error {
+	g.active.Add(1)
+	defer g.active.Add(-1)
+	g.started <- struct{}{}
+	<-g.release
+	return nil
+}
+
+func TestLimitClones(t *testing.T) {
+	git := &blockingGit{started: make(chan struct{}, 3), release: make(chan struct{})}
+	client := LimitClones(git, 1)
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			errs <- client.Clone(context.Background(), "repo", t.TempDir())
+		}()
+	}
+	<-git.started
+	select {
+	case <-git.started:
+		t.Fatal("Expected the second clone to wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Waiting clones give up when their context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Clone(ctx, "repo", t.TempDir()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled clone to stop waiting, got %v", err)
+	}
+
+	close(git.release)
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if active := git.active.Load(); active != 0 {
+		t.Errorf("Expected every clone to finish, %d still running", active)
+	}
+
+	// Checkouts aren't limited
+	if commit, err := client.Checkout(context.Background(), t.TempDir(), "main"); err != nil || commit != "0123abcd" {
+		t.Errorf("Expected the checkout to pass through, got %q, %v", commit, err)
+	}
+}
+
+func TestResolveRef(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("resolve_ref_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	ctx := context.Background()
+
+	for _, git := range gitClients {
+		t.Run(git.name, func(t *testing.T) {
+			head, err := git.client.ResolveRef(ctx, barePath, "master")
+			if err != nil || !commitHashPattern.MatchString(head) {
+				t.Fatalf("Expected the branch to resolve to a commit, got %q, %v", head, err)
+			}
+			if commit, err := git.client.ResolveRef(ctx, barePath, "HEAD"); err != nil || commit != head {
+				t.Errorf("Expected HEAD to resolve to %s, got %q, %v", head, commit, err)
+			}
+			// Hashes can't be looked up, but the repo is still checked
+			if commit, err := git.client.ResolveRef(ctx, barePath, head[:7]); err != nil || commit != head[:7] {
+				t.Errorf("Expected a hash to be returned as it is, got %q, %v", commit, err)
+			}
+			if _, err := git.client.ResolveRef(ctx, barePath, "missing"); !errors.Is(err, ErrRefNotFound) {
+				t.Errorf("Expected ErrRefNotFound for a missing branch, got %v", err)
+			}
+			if _, err := git.client.ResolveRef(ctx, filepath.Join(t.TempDir(), "missing"), head); err == nil {
+				t.Error("Expected an unreachable repo to fail")
+			}
+			// Limiting clones doesn't hide the resolver
+			if commit, err := LimitClones(git.client, 1).(RefResolver).ResolveRef(ctx, barePath, "master"); err != nil || commit != head {
+				t.Errorf("Expected the limited client to resolve refs, got %q, %v", commit, err)
+			}
+		})
+	}
+}
+
+func TestListCommits(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("list_commits_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	ctx := context.Background()
+	first, err := GitCLI{}.ResolveRef(ctx, barePath, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := t.TempDir()
+	if err := (GitCLI{}).Clone(ctx, barePath, clone); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"second", "third"} {
+		if _, err := runGit(ctx, clone, "-c", "user.name=minici", "-c", "user.email=minici@example.com", "commit", "-q", "--allow-empty", "-m", message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := runGit(ctx, clone, "push", "-q", "origin", "HEAD:master"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := runGit(ctx, clone, "rev-list", "--reverse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := strings.Fields(string(output))
+
+	for _, git := range gitClients {
+		t.Run(git.name, func(t *testing.T) {
+			commits, err := LimitClones(git.client, 1).(CommitLister).ListCommits(ctx, barePath, first, "master")
+			if err != nil {
+				t.Fatalf("Failed to list commits: %v", err)
+			}
+			if !reflect.DeepEqual(commits, all[1:]) {
+				t.Errorf("Expected the commits after %s, %v, got %v", first, all[1:], commits)
+			}
+			if _, err := git.client.ListCommits(ctx, barePath, first, "missing"); err == nil {
+				t.Error("Expected listing a missing branch to fail")
+			}
+		})
+	}
+}
+
+func TestCheckoutPullRequestRef(t *testing.T) {
+	barePath, cleanup, err := gittools.CreateTestRemoteRepo("pull_request_ref_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	ctx := context.Background()
+
+	// Push a commit only the pull request's merge ref points to, as GitHub does
+	clone := t.TempDir()
+	if err := (GitCLI{}).Clone(ctx, barePath, clone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, clone, "-c", "user.name=minici", "-c", "user.email=minici@example.com", "commit", "-q", "--allow-empty", "-m", "merge"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, clone, "push", "-q", "origin", "HEAD:refs/pull/42/merge"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := runGit(ctx, clone, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge := strings.TrimSpace(string(output))
+
+	for _, git := range gitClients {
+		t.Run(git.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := git.client.Clone(ctx, barePath, dir); err != nil {
+				t.Fatal(err)
+			}
+			if commit, err := git.client.Checkout(ctx, dir, "refs/pull/42/merge"); err != nil || commit != merge {
+				t.Errorf("Expected the merge ref to be fetched and checked out at %s, got %q, %v", merge, commit, err)
+			}
+			if _, err := git.client.Checkout(ctx, dir, "refs/pull/43/merge"); err == nil {
+				t.Error("Expected checking out a missing pull request to fail")
+			}
+			if commit, err := git.client.Checkout(ctx, dir, "master"); err != nil || commit == merge {
+				t.Errorf("Expected branches to still check out, got %q, %v", commit, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// GoGit is a GitClient built on go-git, so repos can be cloned on hosts
+// without the git command line, such as minimal containers. Repos on the
+// local filesystem are the exception, as go-git runs git-upload-pack to read
+// them.
+type GoGit struct{}
+
+var (
+	_ GitClient         = GoGit{}
+	_ RefResolver       = GoGit{}
+	_ CommitLister      = GoGit{}
+	_ CheckoutDescriber = GoGit{}
+)
+
+// Clone records the remote's default branch as origin/HEAD, as the git
+// command line does
+func (GoGit) Clone(ctx context.Context, repoURI, dir string) error {
+	repo, err := gogit.PlainCloneContext(ctx, dir, false, &gogit.CloneOptions{URL: repoURI})
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil || !head.Name().IsBranch() {
+		return nil
+	}
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(
+		plumbing.NewRemoteHEADReferenceName("origin"),
+		plumbing.NewRemoteReferenceName("origin", head.Name().Short()),
+	))
+}
+
+// Checkout fetches refs outside branches and tags first, such as
+// refs/pull/42/merge, as clones only include branches and tags. Branches are
+// checked out as a local branch and anything else with a detached HEAD.
+func (GoGit) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	if isFetchedRef(ref) {
+		err := repo.FetchContext(ctx, &gogit.FetchOptions{RefSpecs: []config.RefSpec{config.RefSpec("+" + ref + ":" + ref)}})
+		if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+			return "", err
+		}
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	options := &gogit.CheckoutOptions{}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	if remote, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true); err == nil {
+		options.Branch = plumbing.NewBranchReferenceName(branch)
+		if _, err := repo.Reference(options.Branch, false); err != nil {
+			options.Create, options.Hash = true, remote.Hash()
+		}
+	} else {
+		hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+		if err != nil {
+			return "", fmt.Errorf("failed to find %q: %w", ref, err)
+		}
+		options.Hash = *hash
+	}
+	if err := worktree.Checkout(options); err != nil {
+		return "", err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+func (GoGit) ResolveRef(ctx context.Context, repoURI, ref string) (string, error) {
+	// Listing the refs also tells the repo is reachable
+	remote := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{repoURI}})
+	listed, err := remote.ListContext(ctx, &gogit.ListOptions{PeelingOption: gogit.AppendPeeled})
+	if err != nil {
+		return "", err
+	}
+	refs := map[string]string{}
+	for _, listedRef := range listed {
+		refs[listedRef.Name().String()] = listedRef.Hash().String()
+	}
+	for _, listedRef := range listed {
+		if listedRef.Type() == plumbing.SymbolicReference {
+			refs[listedRef.Name().String()] = refs[listedRef.Target().String()]
+		}
+	}
+	// Annotated tags are listed with the commit they point to under ^{}
+	for _, name := range []string{"refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref, ref} {
+		if hash, ok := refs[name]; ok && hash != "" {
+			return hash, nil
+		}
+	}
+	if !commitHashPattern.MatchString(ref) {
+		return "", fmt.Errorf("%w named %q", ErrRefNotFound, ref)
+	}
+	return ref, nil
+}
+
+// ListCommits clones the repo without a worktree to list the commits in the
+// range
+func (GoGit) ListCommits(ctx context.Context, repoURI, from, to string) ([]string, error) {
+	dir, err := os.MkdirTemp("", "minici-commits-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	repo, err := gogit.PlainCloneContext(ctx, dir, true, &gogit.CloneOptions{URL: repoURI})
+	if err != nil {
+		return nil, err
+	}
+	fromHash, err := resolveRemoteRevision(repo, from)
+	if err != nil {
+		return nil, err
+	}
+	toHash, err := resolveRemoteRevision(repo, to)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := repo.CommitObject(toHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walking back from to, a commit is in the range if from is one of its
+	// ancestors. Parents are listed before their children.
+	descends := map[plumbing.Hash]bool{fromHash: true}
+	var commits []string
+	var visit func(commit *object.Commit) (bool, error)
+	visit = func(commit *object.Commit) (bool, error) {
+		if found, ok := descends[commit.Hash]; ok {
+			return found, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		found := false
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			parentFound, err := visit(parent)
+			found = found || parentFound
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		descends[commit.Hash] = found
+		if found {
+			commits = append(commits, commit.Hash.String())
+		}
+		return found, nil
+	}
+	if _, err := visit(tip); err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+// resolveRemoteRevision finds the commit a branch of origin, tag or commit
+// hash names in a clone
+func resolveRemoteRevision(repo *gogit.Repository, ref string) (plumbing.Hash, error) {
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	if remote, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true); err == nil {
+		return remote.Hash(), nil
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to find %q: %w", ref, err)
+	}
+	return *hash, nil
+}
+
+func (GoGit) DescribeRef(ctx context.Context, dir, ref string) (string, string, error) {
+	if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return branch, "", nil
+	}
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return "", tag, nil
+	}
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if ref == "" || ref == "HEAD" {
+		head, err := repo.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false)
+		if err != nil || head.Type() != plumbing.SymbolicReference {
+			return "", "", nil
+		}
+		return strings.TrimPrefix(head.Target().Short(), "origin/"), "", nil
+	}
+	ref = strings.TrimPrefix(ref, "origin/")
+	if _, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", ref), false); err == nil {
+		return ref, "", nil
+	}
+	if _, err := repo.Reference(plumbing.NewTagReferenceName(ref), false); err == nil {
+		return "", ref, nil
+	}
+	return "", "", nil
+}
+
+// ChangedFiles lists both paths of renamed files, as renames aren't detected
+func (GoGit) ChangedFiles(ctx context.Context, dir string) ([]string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := map[string]bool{}
+	if commit.NumParents() == 0 {
+		err := tree.Files().ForEach(func(file *object.File) error {
+			changed[file.Name] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return slices.Sorted(maps.Keys(changed)), nil
+	}
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTreeContext(ctx, parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" {
+				changed[name] = true
+			}
+		}
+	}
+	return slices.Sorted(maps.Keys(changed)), nil
+}
@@ -0,0 +1,60 @@
+package minici
+
+import (
+	"regexp"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// groupIDPattern matches the IDs jobs can give their groups
+var groupIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// NewGroupID returns a unique ID for a group of jobs
+func NewGroupID() string {
+	return ulid.Make().String()
+}
+
+// Group is a set of related jobs, such as the jobs building a range of
+// commits, with the status they have as a whole
+type Group struct {
+	ID string
+	// Status is running while any job is pending or running, then failure
+	// if any job failed or was interrupted, cancelled if any was cancelled
+	// and success once every job has succeeded. A group whose jobs are all
+	// pending is pending.
+	Status JobStatus
+	// Counts are how many of the jobs have each status
+	Counts map[JobStatus]int
+	// Jobs are the group's jobs, oldest first
+	Jobs []Job
+}
+
+// NewGroup summarizes the jobs in a group, which must be oldest first
+func NewGroup(id string, jobs []Job) Group {
+	group := Group{ID: id, Counts: map[JobStatus]int{}, Jobs: jobs}
+	for _, job := range jobs {
+		group.Counts[job.Status]++
+	}
+	switch {
+	case len(jobs) > 0 && group.Counts[JobStatusPending] == len(jobs):
+		group.Status = JobStatusPending
+	case group.Counts[JobStatusPending] > 0 || group.Counts[JobStatusRunning] > 0:
+		group.Status = JobStatusRunning
+	case group.Counts[JobStatusFailure] > 0 || group.Counts[JobStatusInterrupted] > 0:
+		group.Status = JobStatusFailure
+	case group.Counts[JobStatusCancelled] > 0:
+		group.Status = JobStatusCancelled
+	default:
+		group.Status = JobStatusSuccess
+	}
+	return group
+}
+
+// JobIDs returns the IDs of the group's jobs
+func (g Group) JobIDs() []JobID {
+	ids := make([]JobID, 0, len(g.Jobs))
+	for _, job := range g.Jobs {
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
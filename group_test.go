@@ -0,0 +1,42 @@
+package minici
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewGroup(t *testing.T) {
+	for _, test := range []struct {
+		statuses []JobStatus
+		expected JobStatus
+	}{
+		{[]JobStatus{JobStatusPending, JobStatusPending}, JobStatusPending},
+		{[]JobStatus{JobStatusPending, JobStatusSuccess}, JobStatusRunning},
+		{[]JobStatus{JobStatusRunning, JobStatusFailure}, JobStatusRunning},
+		{[]JobStatus{JobStatusSuccess, JobStatusFailure, JobStatusCancelled}, JobStatusFailure},
+		{[]JobStatus{JobStatusSuccess, JobStatusInterrupted}, JobStatusFailure},
+		{[]JobStatus{JobStatusSuccess, JobStatusCancelled}, JobStatusCancelled},
+		{[]JobStatus{JobStatusSuccess, JobStatusSuccess}, JobStatusSuccess},
+	} {
+		var jobs []Job
+		for i, status := range test.statuses {
+			jobs = append(jobs, Job{ID: JobID(rune('a' + i)), Status: status})
+		}
+		group := NewGroup("g", jobs)
+		if group.Status != test.expected {
+			t.Errorf("Expected jobs with statuses %v to be %s, got %s", test.statuses, test.expected, group.Status)
+		}
+		total := 0
+		for _, count := range group.Counts {
+			total += count
+		}
+		if total != len(jobs) || len(group.JobIDs()) != len(jobs) {
+			t.Errorf("Expected every job to be counted, got %v and %v", group.Counts, group.JobIDs())
+		}
+	}
+
+	group := NewGroup("g", []Job{{ID: "1", Status: JobStatusSuccess}, {ID: "2", Status: JobStatusFailure}, {ID: "3", Status: JobStatusFailure}})
+	if expected := map[JobStatus]int{JobStatusSuccess: 1, JobStatusFailure: 2}; !reflect.DeepEqual(group.Counts, expected) {
+		t.Errorf("Expected counts %v, got %v", expected, group.Counts)
+	}
+}
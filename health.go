@@ -0,0 +1,31 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+)
+
+// HealthChecker is implemented by CIs that can report whether their scheduler
+// is working, such as for a service manager's watchdog
+type HealthChecker interface {
+	// CheckHealth returns an error if the scheduler is stuck, giving up when
+	// ctx is done
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckHealth fails if the scheduler's lock can't be taken before ctx is
+// done, as every job and agent change needs it
+func (s *CIServer) CheckHealth(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		s.jobMutex.Lock()
+		s.jobMutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduler is stuck: %w", context.Cause(ctx))
+	}
+}
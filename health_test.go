@@ -0,0 +1,23 @@
+package minici
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+	s := NewCIServer(WithLocalAgent(false)).(*CIServer)
+	if err := s.CheckHealth(context.Background()); err != nil {
+		t.Fatalf("expected a healthy scheduler, got %v", err)
+	}
+
+	s.jobMutex.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := s.CheckHealth(ctx)
+	s.jobMutex.Unlock()
+	if err == nil {
+		t.Fatal("expected a stuck scheduler to be unhealthy")
+	}
+}
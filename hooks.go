@@ -0,0 +1,126 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Hook phases, recorded in HookResult.Phase
+const (
+	// HookOnFailure hooks run if the job's setup commands, command or steps
+	// failed
+	HookOnFailure = "on_failure"
+	// HookAlways hooks run whatever the job's outcome
+	HookAlways = "always"
+)
+
+// HookResult is the outcome of one of the repo's hooks. Hooks don't change
+// the job's status, so their outcomes are recorded separately.
+type HookResult struct {
+	Name string
+	// Phase is HookOnFailure or HookAlways
+	Phase string
+	Run   string
+	// Outcome is StepSuccess, StepFailure or StepSkipped
+	Outcome  string
+	Duration time.Duration
+}
+
+// hook is a hook with its condition parsed
+type hook struct {
+	step
+	phase string
+	label string
+}
+
+// parseHooks checks the repo's hooks as parseSteps checks its steps, on
+// failure hooks first as they run first. Hooks can't have the same names as
+// steps, and their conditions may read the outcomes of every step and of the
+// hooks before them.
+func parseHooks(config RepoConfig) ([]hook, error) {
+	names := map[string]bool{}
+	for _, step := range config.Steps {
+		names[step.Name] = true
+		for _, child := range step.Parallel {
+			names[child.Name] = true
+		}
+	}
+	var hooks []hook
+	for _, phase := range []struct {
+		name    string
+		configs []StepConfig
+	}{{HookOnFailure, config.OnFailure}, {HookAlways, config.Always}} {
+		label := func(config StepConfig, i int) string {
+			if config.Name != "" {
+				return "hook " + config.Name
+			}
+			return fmt.Sprintf("%s hook %d", phase.name, i+1)
+		}
+		for i, config := range phase.configs {
+			if len(config.Parallel) > 0 {
+				return nil, fmt.Errorf("%s can't use parallel, which is only supported in steps", label(config, i))
+			}
+		}
+		steps, err := parseStepList(phase.configs, names, label)
+		if err != nil {
+			return nil, err
+		}
+		for i, step := range steps {
+			hooks = append(hooks, hook{step: step, phase: phase.name, label: label(step.StepConfig, i)})
+		}
+	}
+	return hooks, nil
+}
+
+// runHooks runs the repo's hooks once the job's setup commands, command and
+// steps have finished with err, returning their outcomes. Each is logged
+// under its own heading like the steps, but a failing hook doesn't fail the
+// job. Conditions decide which hooks run as they do for steps, except that
+// they're evaluated even once something has failed.
+func runHooks(ctx context.Context, executor Executor, workspace Workspace, spec JobSpec, hooks []hook, conditions *conditionContext, err error) []HookResult {
+	conditions.Failed = err != nil
+	if conditions.Steps == nil {
+		conditions.Steps = map[string]string{}
+	}
+	results := make([]HookResult, len(hooks))
+	for i, hook := range hooks {
+		result := HookResult{Name: hook.Name, Phase: hook.phase, Run: hook.Run, Outcome: StepSkipped}
+		run := hook.phase == HookAlways || conditions.Failed
+		if run && hook.condition != nil {
+			var evalErr error
+			run, evalErr = hook.condition.holds(conditions)
+			if evalErr != nil {
+				workspace.Log(fmt.Sprintf("=== Failed to evaluate the condition for %s: %v", hook.label, evalErr))
+				run, result.Outcome = false, StepFailure
+			}
+		}
+		// Nothing more runs once the job is cancelled
+		if run && ctx.Err() != nil {
+			run = false
+		}
+
+		if run {
+			workspace.Log(fmt.Sprintf("=== Hook %d/%d (%s): %s", i+1, len(hooks), hook.phase, hook.Run))
+			start := time.Now()
+			command := spec
+			command.Command = hook.Run
+			_, hookErr := executor.Run(ctx, workspace, command)
+			result.Duration = time.Since(start).Round(time.Millisecond)
+			if hookErr != nil {
+				workspace.Log(fmt.Sprintf("=== Hook failed after %s", result.Duration))
+				result.Outcome = StepFailure
+			} else {
+				workspace.Log(fmt.Sprintf("=== Hook finished in %s", result.Duration))
+				result.Outcome = StepSuccess
+			}
+		} else if result.Outcome == StepSkipped {
+			workspace.Log(fmt.Sprintf("=== Skipped %s", hook.label))
+		}
+		if hook.Name != "" {
+			conditions.Steps[hook.Name] = result.Outcome
+		}
+		results[i] = result
+	}
+	return results
+}
@@ -0,0 +1,115 @@
+package minici
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	git := &configGit{
+		config: `steps:
+  - name: build
+    run: echo built
+on_failure:
+  - name: collect
+    run: echo collecting
+  - run: echo only after a failed build
+    if: steps.build.outcome == 'failure'
+always:
+  - name: report
+    run: touch coverage.out
+  - run: exit 2
+  - run: echo on main
+    if: branch == 'main' && steps.collect.outcome == 'skipped'
+coverage: coverage.out
+`,
+	}
+	run := func(command string, timeouts Timeouts) (JobResult, string) {
+		var lines []string
+		job := Job{ID: "01ABC", RepoURI: "https://example.com/repo.git", Commit: "main", Command: command}
+		result := RunJob(context.Background(), job, &LocalExecutor{}, JobStores{Git: git, Timeouts: timeouts}, func(line string) {
+			lines = append(lines, line)
+		})
+		return result, strings.Join(lines, "\n")
+	}
+	outcomes := func(result JobResult) []string {
+		var outcomes []string
+		for _, hook := range result.Hooks {
+			outcomes = append(outcomes, hook.Phase+" "+hook.Outcome)
+		}
+		return outcomes
+	}
+
+	// A failing hook doesn't fail the job, and hooks run before reports are
+	// collected
+	result, logs := run("echo command", Timeouts{})
+	if result.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed despite a failing hook, got %+v: %s", result, logs)
+	}
+	expected := []string{"on_failure skipped", "on_failure skipped", "always success", "always failure", "always success"}
+	if got := outcomes(result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected hook outcomes %v, got %v", expected, got)
+	}
+	if result.Hooks[0].Name != "collect" || result.Hooks[3].Run != "exit 2" {
+		t.Errorf("Expected the hooks' names and commands to be recorded, got %+v", result.Hooks)
+	}
+	for _, expected := range []string{"=== Skipped hook collect", "=== Hook 3/5 (always): touch", "coverage.out: unrecognized coverage report", "=== Hook failed after", "> on main"} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected %q in the logs, got:\n%s", expected, logs)
+		}
+	}
+
+	// On failure hooks run once the job fails, even if it ran out of time
+	result, logs = run("sleep 5", Timeouts{Command: 100 * time.Millisecond})
+	if result.Status != JobStatusFailure || !result.TimedOut {
+		t.Fatalf("Expected the job to time out, got %+v: %s", result, logs)
+	}
+	expected = []string{"on_failure success", "on_failure skipped", "always success", "always failure", "always skipped"}
+	if got := outcomes(result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected hook outcomes %v, got %v:\n%s", expected, got, logs)
+	}
+	if !strings.Contains(logs, "> collecting") {
+		t.Errorf("Expected the on failure hook in the logs, got:\n%s", logs)
+	}
+}
+
+func TestParseHooks(t *testing.T) {
+	tests := []struct {
+		config   RepoConfig
+		expected string
+	}{
+		{
+			config:   RepoConfig{OnFailure: []StepConfig{{Run: " "}}},
+			expected: "on_failure hook 1 has nothing to run",
+		},
+		{
+			config:   RepoConfig{Steps: []StepConfig{{Name: "build", Run: "make"}}, Always: []StepConfig{{Name: "build", Run: "make"}}},
+			expected: `step name "build" is used more than once`,
+		},
+		{
+			config:   RepoConfig{OnFailure: []StepConfig{{Name: "collect", Run: "echo", If: "steps.upload.outcome == 'failure'"}}, Always: []StepConfig{{Name: "upload", Run: "echo"}}},
+			expected: `condition for hook collect reads the outcome of "upload", which isn't an earlier step`,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseHooks(test.config)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected error %q, got %v", test.expected, err)
+		}
+	}
+
+	hooks, err := parseHooks(RepoConfig{
+		Steps:     []StepConfig{{Name: "build", Run: "make"}},
+		Always:    []StepConfig{{Run: "./upload.sh", If: "steps.build.outcome == 'success'"}},
+		OnFailure: []StepConfig{{Name: "collect", Run: "./collect.sh"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse hooks: %v", err)
+	}
+	if len(hooks) != 2 || hooks[0].phase != HookOnFailure || hooks[1].phase != HookAlways || hooks[1].label != "always hook 1" {
+		t.Errorf("Expected the on failure hook before the always hook, got %+v", hooks)
+	}
+}
@@ -0,0 +1,265 @@
+package minici
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ImageConfig describes a container image a repo builds and pushes once its
+// job's command has succeeded
+type ImageConfig struct {
+	// Image is the repository to push to, such as ghcr.io/example/app
+	Image string `yaml:"image"`
+	// Dockerfile defaults to Dockerfile in the context
+	Dockerfile string `yaml:"dockerfile"`
+	// Context is the build context relative to the repository root, defaults to the root
+	Context string `yaml:"context"`
+	// Tags default to the checked out commit's hash
+	Tags []string `yaml:"tags"`
+	// BuildArgs are passed to the build with --build-arg
+	BuildArgs map[string]string `yaml:"build_args"`
+}
+
+// Secrets holds credentials the server or agent provides to jobs, which are
+// never part of a repo's config
+type Secrets struct {
+	// Registries are credentials for pushing images, by registry host such as
+	// ghcr.io or docker.io. They are only ever sent to that registry.
+	Registries map[string]RegistryCredentials `yaml:"registries"`
+	// Projects are secrets only provided to a project's jobs, by project name.
+	// They take precedence over the shared secrets for the same registry.
+	Projects map[string]Secrets `yaml:"projects"`
+}
+
+// RegistryCredentials authenticate to a container registry
+type RegistryCredentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// LoadSecrets reads secrets from a YAML file
+func LoadSecrets(path string) (Secrets, error) {
+	return LoadSecretsWithKeyring(path, nil)
+}
+
+// LoadSecretsWithKeyring reads secrets from a YAML file, which may have been
+// encrypted with a key in keyring
+func LoadSecretsWithKeyring(path string, keyring *Keyring) (Secrets, error) {
+	var secrets Secrets
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return secrets, fmt.Errorf("failed to read secrets: %w", err)
+	}
+	content, err = keyring.decryptIfEncrypted(bytes.TrimSpace(content))
+	if err != nil {
+		return secrets, fmt.Errorf("failed to decrypt secrets %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(content, &secrets); err != nil {
+		return secrets, fmt.Errorf("failed to parse secrets %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+// ImageBuilder builds and pushes the images in repo configs with the docker
+// or podman CLI, on the machine running the job
+type ImageBuilder struct {
+	// Runtime is the container CLI to use. Defaults to docker if it is
+	// installed, otherwise podman.
+	Runtime string
+	// Secrets provide credentials for the registries images are pushed to.
+	// Images for other registries are pushed with the CLI's own login.
+	Secrets Secrets
+}
+
+// WithImageBuilder builds and pushes the images in repo configs using builder
+func WithImageBuilder(builder *ImageBuilder) Option {
+	return func(s *CIServer) {
+		s.images = builder
+	}
+}
+
+// forProject returns a builder with the secrets provided to a project's jobs
+func (b *ImageBuilder) forProject(project string) *ImageBuilder {
+	secrets, ok := b.Secrets.Projects[project]
+	if project == "" || !ok {
+		return b
+	}
+	registries := map[string]RegistryCredentials{}
+	for host, credentials := range b.Secrets.Registries {
+		registries[host] = credentials
+	}
+	for host, credentials := range secrets.Registries {
+		registries[host] = credentials
+	}
+	return &ImageBuilder{Runtime: b.Runtime, Secrets: Secrets{Registries: registries}}
+}
+
+func (b *ImageBuilder) runtime() string {
+	return (&ContainerExecutor{Runtime: b.Runtime}).runtime()
+}
+
+// imageTagPattern matches a valid image tag
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// pushDigestPattern finds the digest in docker push's output
+var pushDigestPattern = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+// buildImages builds and pushes each of the repo's images, returning their
+// digests by image
+func buildImages(ctx context.Context, builder *ImageBuilder, workspace Workspace, configs []ImageConfig) (map[string]string, error) {
+	digests := map[string]string{}
+	for _, config := range configs {
+		workspace.Log("=== Building image " + config.Image)
+		start := time.Now()
+		digest, err := builder.build(ctx, workspace, config)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			workspace.Log(fmt.Sprintf("=== Image build failed after %s: %v", elapsed, err))
+			return digests, err
+		}
+		workspace.Log(fmt.Sprintf("=== Pushed %s@%s in %s", config.Image, digest, elapsed))
+		digests[config.Image] = digest
+	}
+	return digests, nil
+}
+
+// build builds an image and pushes each of its tags, returning the pushed digest
+func (b *ImageBuilder) build(ctx context.Context, workspace Workspace, config ImageConfig) (string, error) {
+	if config.Image == "" || strings.ContainsAny(config.Image, "@ ") {
+		return "", fmt.Errorf("invalid image %q", config.Image)
+	}
+	buildContext := config.Context
+	if buildContext == "" {
+		buildContext = "."
+	}
+	dockerfile := config.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(buildContext, "Dockerfile")
+	}
+	for _, path := range []string{buildContext, dockerfile} {
+		if !filepath.IsLocal(filepath.FromSlash(path)) {
+			return "", fmt.Errorf("invalid path %q: must be relative to the repository root", path)
+		}
+	}
+	tags := config.Tags
+	if len(tags) == 0 {
+		if workspace.Commit == "" {
+			return "", fmt.Errorf("image %q has no tags and the commit isn't known", config.Image)
+		}
+		tags = []string{workspace.Commit}
+	}
+	for _, tag := range tags {
+		if !imageTagPattern.MatchString(tag) {
+			return "", fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+
+	runtime := b.runtime()
+	args := []string{"build", "--file", filepath.FromSlash(dockerfile)}
+	for _, tag := range tags {
+		args = append(args, "--tag", config.Image+":"+tag)
+	}
+	for _, key := range sortedKeys(config.BuildArgs) {
+		args = append(args, "--build-arg", key)
+	}
+	args = append(args, filepath.FromSlash(buildContext))
+	build := exec.CommandContext(ctx, runtime, args...)
+	build.Dir = workspace.Dir
+	// Values are passed through the environment so they don't appear in process listings
+	build.Env = append(os.Environ(), envList(config.BuildArgs)...)
+	if err := runLogged(build, workspace.Log); err != nil {
+		return "", err
+	}
+
+	// Credentials are written to a config only this job uses, rather than
+	// logging in with the CLI's own config
+	authDir, err := os.MkdirTemp("", "minici-registry-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(authDir)
+	authFile, err := b.writeAuth(authDir, config.Image)
+	if err != nil {
+		return "", err
+	}
+
+	var digest string
+	for _, tag := range tags {
+		args := []string{"push"}
+		digestFile := filepath.Join(authDir, "digest")
+		if isPodman(runtime) {
+			args = append(args, "--digestfile", digestFile)
+			if authFile != "" {
+				args = append(args, "--authfile", authFile)
+			}
+		}
+		push := exec.CommandContext(ctx, runtime, append(args, config.Image+":"+tag)...)
+		push.Dir = workspace.Dir
+		push.Env = os.Environ()
+		if authFile != "" && !isPodman(runtime) {
+			push.Env = append(push.Env, "DOCKER_CONFIG="+authDir)
+		}
+		var found string
+		err := runLogged(push, func(line string) {
+			if match := pushDigestPattern.FindStringSubmatch(line); match != nil {
+				found = match[1]
+			}
+			workspace.Log(line)
+		})
+		if err != nil {
+			return "", err
+		}
+		if content, err := os.ReadFile(digestFile); err == nil {
+			found = strings.TrimSpace(string(content))
+		}
+		if found == "" {
+			return "", fmt.Errorf("no digest found in output of pushing %s:%s", config.Image, tag)
+		}
+		digest = found
+	}
+	return digest, nil
+}
+
+// writeAuth writes a registry config with the credentials for an image's
+// registry to dir, returning its path or "" if there are no credentials
+func (b *ImageBuilder) writeAuth(dir, image string) (string, error) {
+	host := registryHost(image)
+	credentials, ok := b.Secrets.Registries[host]
+	if !ok {
+		return "", nil
+	}
+	if host == "docker.io" {
+		// Docker Hub credentials are stored under its legacy index URL
+		host = "https://index.docker.io/v1/"
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(credentials.Username + ":" + credentials.Password))
+	content, err := json.Marshal(map[string]any{
+		"auths": map[string]any{host: map[string]string{"auth": auth}},
+	})
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "config.json")
+	return path, os.WriteFile(path, content, 0o600)
+}
+
+// registryHost returns the registry an image is pushed to. Images without a
+// registry host, such as example/app, are on Docker Hub.
+func registryHost(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
@@ -0,0 +1,139 @@
+package minici
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// fakeImageRuntime writes a container CLI that prints its arguments, and
+// reports a digest for pushes the way docker or podman would
+func fakeImageRuntime(t *testing.T, name string) string {
+	path := filepath.Join(t.TempDir(), name)
+	script := `#!/bin/sh
+echo "args: $*"
+echo "VERSION=$VERSION"
+if [ "$1" = push ]; then
+	if [ "$2" = --digestfile ]; then
+		echo ` + testDigest + ` > "$3"
+	else
+		echo "latest: digest: ` + testDigest + ` size: 528"
+		if [ -n "$DOCKER_CONFIG" ]; then
+			cat "$DOCKER_CONFIG/config.json"
+			echo
+		fi
+	fi
+fi
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildImages(t *testing.T) {
+	var lines []string
+	workspace := Workspace{JobID: "01ABC", Dir: t.TempDir(), Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	builder := &ImageBuilder{
+		Runtime: fakeImageRuntime(t, "docker"),
+		Secrets: Secrets{Registries: map[string]RegistryCredentials{"ghcr.io": {Username: "bot", Password: "hunter2"}}},
+	}
+
+	digests, err := buildImages(context.Background(), builder, workspace, []ImageConfig{{
+		Image:     "ghcr.io/example/app",
+		Context:   "app",
+		Tags:      []string{"latest", "v1"},
+		BuildArgs: map[string]string{"VERSION": "1.0"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digests["ghcr.io/example/app"] != testDigest {
+		t.Errorf("Expected the pushed digest, got %v", digests)
+	}
+
+	output := strings.Join(lines, "\n")
+	for _, expected := range []string{
+		"> args: build --file " + filepath.Join("app", "Dockerfile") + " --tag ghcr.io/example/app:latest --tag ghcr.io/example/app:v1 --build-arg VERSION app",
+		// Build args are passed through the environment
+		"> VERSION=1.0",
+		"> args: push ghcr.io/example/app:latest",
+		"> args: push ghcr.io/example/app:v1",
+		// base64 of bot:hunter2
+		`{"auths":{"ghcr.io":{"auth":"Ym90Omh1bnRlcjI="}}}`,
+		"=== Pushed ghcr.io/example/app@" + testDigest,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, output)
+		}
+	}
+
+	// Credentials are only sent to their own registry
+	lines = nil
+	if _, err := buildImages(context.Background(), builder, workspace, []ImageConfig{{Image: "example.com/app", Tags: []string{"latest"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if output := strings.Join(lines, "\n"); strings.Contains(output, "auths") {
+		t.Errorf("Expected no credentials for another registry, got:\n%s", output)
+	}
+
+	for _, config := range []ImageConfig{
+		{Image: "example.com/app", Tags: []string{"bad tag"}},
+		{Image: "example.com/app", Tags: []string{"latest"}, Context: "../outside"},
+		{Image: "example.com/app@sha256:abc", Tags: []string{"latest"}},
+	} {
+		if _, err := buildImages(context.Background(), builder, workspace, []ImageConfig{config}); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
+
+func TestBuildImagesPodman(t *testing.T) {
+	workspace := Workspace{JobID: "01ABC", Dir: t.TempDir(), Log: func(string) {}}
+	builder := &ImageBuilder{Runtime: fakeImageRuntime(t, "podman")}
+	digests, err := buildImages(context.Background(), builder, workspace, []ImageConfig{{Image: "quay.io/example/app", Tags: []string{"latest"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digests["quay.io/example/app"] != testDigest {
+		t.Errorf("Expected the digest from podman's digest file, got %v", digests)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	for image, expected := range map[string]string{
+		"ghcr.io/example/app":   "ghcr.io",
+		"localhost:5000/app":    "localhost:5000",
+		"localhost/app":         "localhost",
+		"example/app":           "docker.io",
+		"golang":                "docker.io",
+		"registry.internal/app": "registry.internal",
+	} {
+		if host := registryHost(image); host != expected {
+			t.Errorf("Expected %s to be pushed to %s, got %s", image, expected, host)
+		}
+	}
+}
+
+func TestProjectSecrets(t *testing.T) {
+	builder := &ImageBuilder{Secrets: Secrets{
+		Registries: map[string]RegistryCredentials{"ghcr.io": {Username: "shared"}, "docker.io": {Username: "shared"}},
+		Projects: map[string]Secrets{
+			"frontend": {Registries: map[string]RegistryCredentials{"ghcr.io": {Username: "frontend"}}},
+		},
+	}}
+
+	registries := builder.forProject("frontend").Secrets.Registries
+	if registries["ghcr.io"].Username != "frontend" || registries["docker.io"].Username != "shared" {
+		t.Errorf("Expected the project's credentials to take precedence, got %+v", registries)
+	}
+	if other := builder.forProject("backend"); other.Secrets.Registries["ghcr.io"].Username != "shared" {
+		t.Errorf("Expected other projects to use the shared credentials, got %+v", other.Secrets.Registries)
+	}
+}
@@ -0,0 +1,112 @@
+package minici
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Input types. An input without a type is a string.
+const (
+	InputString = "string"
+	InputBool   = "bool"
+	InputChoice = "choice"
+)
+
+// inputNamePattern matches valid input names, which are also part of the
+// environment variables they set
+var inputNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// InputConfig declares a value jobs can be given when they're submitted, such
+// as the version to deploy
+type InputConfig struct {
+	// Type is InputString, InputBool or InputChoice
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	// Default is used for jobs that aren't given the input. Inputs without a
+	// default must be given.
+	Default *string `yaml:"default"`
+	// Options are the values a choice can be
+	Options []string `yaml:"options"`
+}
+
+// InputEnv returns the environment variable an input is passed to the job's
+// commands in, such as INPUT_VERSION for version
+func InputEnv(name string) string {
+	return "INPUT_" + strings.ToUpper(name)
+}
+
+// check reports a mistake in an input's declaration
+func (c InputConfig) check() error {
+	switch c.Type {
+	case "", InputString, InputBool:
+		if len(c.Options) > 0 {
+			return fmt.Errorf("only choices can have options")
+		}
+	case InputChoice:
+		if len(c.Options) == 0 {
+			return fmt.Errorf("choice needs at least one option")
+		}
+	default:
+		return fmt.Errorf("unknown type %q: must be %s, %s or %s", c.Type, InputString, InputBool, InputChoice)
+	}
+	if c.Default != nil {
+		if _, err := c.value(*c.Default); err != nil {
+			return fmt.Errorf("invalid default: %w", err)
+		}
+	}
+	return nil
+}
+
+// value checks a value given for the input, returning it as the job sees it
+func (c InputConfig) value(given string) (string, error) {
+	switch c.Type {
+	case InputBool:
+		switch strings.ToLower(given) {
+		case "true", "yes", "1":
+			return "true", nil
+		case "false", "no", "0":
+			return "false", nil
+		}
+		return "", fmt.Errorf("must be true or false, got %q", given)
+	case InputChoice:
+		for _, option := range c.Options {
+			if given == option {
+				return given, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s, got %q", strings.Join(c.Options, ", "), given)
+	}
+	return given, nil
+}
+
+// resolveInputs checks the inputs a job was given against the ones its repo
+// declares, filling in the defaults of those it wasn't given, and returns the
+// environment variables they set
+func resolveInputs(declared map[string]InputConfig, given map[string]string) (map[string]string, error) {
+	for _, name := range sortedKeys(given) {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("unknown input %q", name)
+		}
+	}
+	env := make(map[string]string, len(declared))
+	for _, name := range sortedKeys(declared) {
+		input := declared[name]
+		if err := input.check(); err != nil {
+			return nil, fmt.Errorf("input %q: %w", name, err)
+		}
+		value, ok := given[name]
+		if !ok {
+			if input.Default == nil {
+				return nil, fmt.Errorf("input %q is required", name)
+			}
+			value = *input.Default
+		}
+		value, err := input.value(value)
+		if err != nil {
+			return nil, fmt.Errorf("input %q %w", name, err)
+		}
+		env[InputEnv(name)] = value
+	}
+	return env, nil
+}
@@ -0,0 +1,73 @@
+package minici
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveInputs(t *testing.T) {
+	staging := "staging"
+	no := "no"
+	declared := map[string]InputConfig{
+		"version": {Description: "Version to deploy"},
+		"env":     {Type: InputChoice, Options: []string{"staging", "prod"}, Default: &staging},
+		"dry_run": {Type: InputBool, Default: &no},
+	}
+
+	env, err := resolveInputs(declared, map[string]string{"version": "1.2.3", "dry_run": "yes"})
+	if err != nil {
+		t.Fatalf("Failed to resolve inputs: %v", err)
+	}
+	expected := map[string]string{"INPUT_VERSION": "1.2.3", "INPUT_ENV": "staging", "INPUT_DRY_RUN": "true"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected env %v, got %v", expected, env)
+	}
+
+	for _, test := range []struct {
+		given map[string]string
+		err   string
+	}{
+		{map[string]string{}, `input "version" is required`},
+		{map[string]string{"version": "1", "region": "eu"}, `unknown input "region"`},
+		{map[string]string{"version": "1", "env": "dev"}, `input "env" must be one of staging, prod, got "dev"`},
+		{map[string]string{"version": "1", "dry_run": "maybe"}, `input "dry_run" must be true or false`},
+	} {
+		if _, err := resolveInputs(declared, test.given); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected error %q for %v, got %v", test.err, test.given, err)
+		}
+	}
+
+	if env, err := resolveInputs(nil, nil); err != nil || len(env) != 0 {
+		t.Errorf("Expected no env without inputs, got %v, %v", env, err)
+	}
+}
+
+func TestValidateRepoConfigInputs(t *testing.T) {
+	problems := ValidateRepoConfig([]byte(`inputs:
+  version:
+    description: Version to deploy
+  env:
+    type: choice
+    options: [staging, prod]
+    default: dev
+  dry-run:
+    type: bool
+    defualt: "false"
+  count:
+    type: number
+`))
+	var got []string
+	for _, problem := range problems {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		`5:5: error: inputs.env: invalid default: must be one of staging, prod, got "dev"`,
+		`8:3: error: inputs: invalid input name "dry-run"`,
+		`10:5: warning: inputs.dry-run: unknown field "defualt" is ignored, did you mean "default"?`,
+		`12:5: error: inputs.count: unknown type "number": must be string, bool or choice`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,64 @@
+package minici
+
+import (
+	"maps"
+	"strconv"
+)
+
+// Env vars describing the job that every command it runs is given, so build
+// scripts can find their way around without being told
+const (
+	EnvJobID = "MINICI_JOB_ID"
+	// EnvWorkspace is the checked out repo, as the command sees it
+	EnvWorkspace = "MINICI_WORKSPACE"
+	// EnvScratchDir is an empty directory outside the checkout for temporary
+	// files, removed when the job finishes
+	EnvScratchDir = "MINICI_SCRATCH_DIR"
+	// EnvCommit is the hash of the commit checked out
+	EnvCommit = "MINICI_COMMIT"
+	// EnvRepo is the URI of the job's repo
+	EnvRepo = "MINICI_REPO"
+
+	// Env vars describing the pull request a job builds, only set for pull
+	// request jobs
+	EnvPullRequestNumber       = "MINICI_PR_NUMBER"
+	EnvPullRequestSourceBranch = "MINICI_PR_SOURCE_BRANCH"
+	EnvPullRequestTargetBranch = "MINICI_PR_TARGET_BRANCH"
+	EnvPullRequestAuthor       = "MINICI_PR_AUTHOR"
+)
+
+// ContainerScratchDir is where the job's scratch directory is mounted inside
+// containers
+const ContainerScratchDir = "/minici/scratch"
+
+// jobEnv returns the env vars describing the job that are the same wherever
+// its commands run
+func jobEnv(job Job, commit string) map[string]string {
+	env := map[string]string{
+		EnvRepo:   job.RepoURI,
+		EnvCommit: commit,
+	}
+	if job.ID != "" {
+		env[EnvJobID] = string(job.ID)
+	}
+	if pr := job.PullRequest; pr != nil {
+		env[EnvPullRequestNumber] = strconv.Itoa(pr.Number)
+		env[EnvPullRequestSourceBranch] = pr.SourceBranch
+		env[EnvPullRequestTargetBranch] = pr.TargetBranch
+		env[EnvPullRequestAuthor] = pr.Author
+	}
+	return env
+}
+
+// workspaceEnv adds the locations of the workspace and scratch directory, as
+// the executor's commands see them, to env. They take precedence over any
+// set in the spec.
+func workspaceEnv(env map[string]string, dir, scratchDir string) map[string]string {
+	merged := make(map[string]string, len(env)+2)
+	maps.Copy(merged, env)
+	merged[EnvWorkspace] = dir
+	if scratchDir != "" {
+		merged[EnvScratchDir] = scratchDir
+	}
+	return merged
+}
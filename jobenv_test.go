@@ -0,0 +1,61 @@
+package minici
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJobEnv(t *testing.T) {
+	ci := NewCIServer(
+		WithGitClient(&fakeGit{}),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	)
+	id := ci.ScheduleJob("https://example.com/repo.git", "main", "env")
+	if status := ci.JobDetail(id).Status; status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %s: %q", status, ci.JobLogs(id))
+	}
+
+	env := map[string]string{}
+	for _, line := range ci.JobLogs(id) {
+		if key, value, ok := strings.Cut(strings.TrimPrefix(line, "> "), "="); ok && strings.HasPrefix(key, "MINICI_") {
+			env[key] = value
+		}
+	}
+	if env[EnvJobID] != string(id) || env[EnvRepo] != "https://example.com/repo.git" || env[EnvCommit] == "" {
+		t.Errorf("Expected the job's ID, repo and commit, got %v", env)
+	}
+	workspace, scratch := env[EnvWorkspace], env[EnvScratchDir]
+	if workspace == "" || scratch == "" || strings.HasPrefix(scratch, workspace+string(os.PathSeparator)) {
+		t.Errorf("Expected a scratch directory outside the workspace, got %q and %q", workspace, scratch)
+	}
+	if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+		t.Errorf("Expected the scratch directory to be removed once the job finished, got %v", err)
+	}
+}
+
+func TestPullRequestEnv(t *testing.T) {
+	job := Job{
+		ID:          "job",
+		RepoURI:     "https://example.com/repo.git",
+		PullRequest: &PullRequest{Number: 42, SourceBranch: "fix-typo", TargetBranch: "main", Author: "octocat"},
+	}
+	env := jobEnv(job, "0123abcd")
+	want := map[string]string{
+		EnvPullRequestNumber:       "42",
+		EnvPullRequestSourceBranch: "fix-typo",
+		EnvPullRequestTargetBranch: "main",
+		EnvPullRequestAuthor:       "octocat",
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, env[key])
+		}
+	}
+
+	job.PullRequest = nil
+	if _, ok := jobEnv(job, "0123abcd")[EnvPullRequestNumber]; ok {
+		t.Error("Expected no pull request env for other jobs")
+	}
+}
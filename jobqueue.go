@@ -0,0 +1,262 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultQueueExtendInterval is how often a server tells its external queue
+// it's still working on the jobs delivered to it, when ExternalQueue doesn't
+// set an interval
+const DefaultQueueExtendInterval = 10 * time.Second
+
+// JobQueue carries jobs from the servers that schedule them to the servers
+// that run them. Deliveries that aren't acknowledged or extended within the
+// queue's own timeout are redelivered, possibly to another consumer.
+type JobQueue interface {
+	// Publish adds a job to the end of the queue
+	Publish(ctx context.Context, job Job) error
+	// Receive waits for the next job for consumer, or a job whose delivery
+	// timed out, returning ErrNoJobAvailable if none arrives
+	Receive(ctx context.Context, consumer string) (JobDelivery, error)
+	// Extend stops deliveries being redelivered while consumer works on them
+	Extend(ctx context.Context, consumer string, deliveries []JobDelivery) error
+	// Ack removes a delivered job from the queue once it's done
+	Ack(ctx context.Context, delivery JobDelivery) error
+}
+
+// JobDelivery is a job handed to a consumer by a JobQueue
+type JobDelivery struct {
+	// ID identifies the delivery to the queue
+	ID  string
+	Job Job
+}
+
+// ExternalQueue dispatches jobs through a JobQueue rather than only the
+// server's own queue, so servers that schedule jobs needn't run them. Each
+// server receives a job when it, or one of its agents, has a free slot, and
+// acknowledges it once the job is done. If a server stops before then, the
+// job is redelivered to another server and runs again from the start.
+type ExternalQueue struct {
+	Queue JobQueue
+	// Consumer names this server to the queue, defaults to the cluster
+	// instance or the host name
+	Consumer string
+	// ExtendInterval is how often deliveries are extended, defaults to
+	// DefaultQueueExtendInterval. It must be shorter than the queue's
+	// redelivery timeout.
+	ExtendInterval time.Duration
+	// OnError is called with errors reaching the queue, if set
+	OnError func(error)
+}
+
+// WithExternalQueue dispatches jobs through an external queue
+func WithExternalQueue(queue ExternalQueue) Option {
+	return func(s *CIServer) {
+		if queue.ExtendInterval <= 0 {
+			queue.ExtendInterval = DefaultQueueExtendInterval
+		}
+		s.external = &externalQueue{
+			ExternalQueue: queue,
+			published:     make(map[JobID]queuedJob),
+			deliveries:    make(map[JobID]JobDelivery),
+		}
+	}
+}
+
+// externalQueue tracks the jobs passing through an ExternalQueue, guarded by
+// jobMutex
+type externalQueue struct {
+	ExternalQueue
+	// published are jobs scheduled here that are waiting to be delivered
+	published map[JobID]queuedJob
+	// deliveries are the jobs delivered here that aren't done yet
+	deliveries map[JobID]JobDelivery
+}
+
+func (q *externalQueue) report(err error) {
+	if q.OnError != nil {
+		q.OnError(err)
+	}
+}
+
+// publish adds a job the server just queued to the external queue. A job that
+// can't be published is queued locally instead, so it isn't lost.
+func (s *CIServer) publish(job *Job) {
+	if s.external == nil {
+		return
+	}
+	s.jobMutex.RLock()
+	snapshot := *job
+	s.jobMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+	err := s.external.Queue.Publish(ctx, snapshot)
+	cancel()
+	if err == nil {
+		return
+	}
+	s.external.report(fmt.Errorf("failed to publish job %s: %w", job.ID, err))
+	s.appendLog(job, "Failed to publish the job to the queue, running it here instead")
+	s.jobMutex.Lock()
+	if queued, ok := s.external.published[job.ID]; ok {
+		delete(s.external.published, job.ID)
+		s.waiting = append(s.waiting, queued)
+	}
+	s.jobMutex.Unlock()
+	s.dispatch()
+}
+
+// consumeJobs receives jobs from the external queue whenever the server has a
+// slot to run one
+func (s *CIServer) consumeJobs() {
+	for {
+		s.waitForSlot()
+		ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+		delivery, err := s.external.Queue.Receive(ctx, s.external.Consumer)
+		cancel()
+		if errors.Is(err, ErrNoJobAvailable) {
+			continue
+		}
+		if err != nil {
+			s.external.report(fmt.Errorf("failed to receive a job: %w", err))
+			<-s.clock.After(time.Second)
+			continue
+		}
+		s.deliver(delivery)
+	}
+}
+
+// waitForSlot blocks until nothing delivered is ready to start and an agent
+// could start another job, so servers only take the jobs they can run
+func (s *CIServer) waitForSlot() {
+	for {
+		s.jobMutex.RLock()
+		ready := false
+		for _, queued := range s.waiting {
+			if blocked, _ := s.blockedBy(queued.job); !blocked {
+				ready = true
+				break
+			}
+		}
+		free := false
+		for _, a := range s.allAgents() {
+			if a.free() {
+				free = true
+				break
+			}
+		}
+		changed := s.jobsChanged
+		s.jobMutex.RUnlock()
+		if !ready && free {
+			return
+		}
+		// Agents free up without every change being announced, so check again
+		// now and then
+		select {
+		case <-changed:
+		case <-s.clock.After(time.Second):
+		}
+	}
+}
+
+// deliver queues a job received from the external queue. Jobs scheduled on
+// another server, or on this one before it restarted, are added to its jobs.
+func (s *CIServer) deliver(delivery JobDelivery) {
+	id := delivery.Job.ID
+	s.jobMutex.Lock()
+	job, ok := s.jobs[id]
+	if ok && job.Status.Done() {
+		s.jobMutex.Unlock()
+		s.ack(delivery)
+		return
+	}
+	// Jobs running on another server in the cluster are only tracked, so
+	// they're acknowledged once they're done
+	s.external.deliveries[id] = delivery
+	var event *JobEvent
+	if queued, published := s.external.published[id]; published {
+		delete(s.external.published, id)
+		s.waiting = append(s.waiting, queued)
+	} else if !ok || (job.Status == JobStatusPending && !s.assigned(id) && !s.isWaiting(id)) {
+		if !ok {
+			job = &Job{}
+			*job = delivery.Job
+			job.Status = JobStatusPending
+			job.Agent = ""
+			job.StartedAt = time.Time{}
+			s.jobs[id] = job
+			s.buildNumbers[job.RepoURI] = max(s.buildNumbers[job.RepoURI], job.Number)
+			event = &JobEvent{Job: *job}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if previous, ok := s.cancels[id]; ok {
+			previous()
+		}
+		s.cancels[id] = cancel
+		s.waiting = append(s.waiting, queuedJob{job: job, ctx: ctx})
+	}
+	s.jobsUpdated()
+	s.jobMutex.Unlock()
+
+	if event != nil {
+		s.emit(*event)
+	}
+	s.dispatch()
+}
+
+// isWaiting returns true if a job is in the server's queue. The caller must
+// hold jobMutex.
+func (s *CIServer) isWaiting(id JobID) bool {
+	for _, queued := range s.waiting {
+		if queued.job.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// delivered acknowledges a finished job's delivery, if it has one. The caller
+// must hold jobMutex.
+func (s *CIServer) delivered(id JobID) {
+	if s.external == nil {
+		return
+	}
+	if delivery, ok := s.external.deliveries[id]; ok {
+		delete(s.external.deliveries, id)
+		go s.ack(delivery)
+	}
+}
+
+func (s *CIServer) ack(delivery JobDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+	defer cancel()
+	if err := s.external.Queue.Ack(ctx, delivery); err != nil {
+		s.external.report(fmt.Errorf("failed to acknowledge job %s: %w", delivery.Job.ID, err))
+	}
+}
+
+// extendDeliveries keeps the jobs delivered here from being redelivered
+// while they're queued or running
+func (s *CIServer) extendDeliveries() {
+	for {
+		<-s.clock.After(s.external.ExtendInterval)
+		s.jobMutex.RLock()
+		var deliveries []JobDelivery
+		for _, delivery := range s.external.deliveries {
+			deliveries = append(deliveries, delivery)
+		}
+		s.jobMutex.RUnlock()
+		if len(deliveries) == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), clusterCallTimeout)
+		if err := s.external.Queue.Extend(ctx, s.external.Consumer, deliveries); err != nil {
+			s.external.report(fmt.Errorf("failed to extend deliveries: %w", err))
+		}
+		cancel()
+	}
+}
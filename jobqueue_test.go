@@ -0,0 +1,193 @@
+package minici
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryJobQueue is a JobQueue for servers in the same process
+type memoryJobQueue struct {
+	mu             sync.Mutex
+	redeliverAfter time.Duration
+	next           int
+	entries        []*memoryQueueEntry
+	acked          []JobID
+}
+
+type memoryQueueEntry struct {
+	delivery JobDelivery
+	consumer string
+	deadline time.Time
+}
+
+func (m *memoryJobQueue) Publish(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	m.entries = append(m.entries, &memoryQueueEntry{delivery: JobDelivery{ID: strconv.Itoa(m.next), Job: job}})
+	return nil
+}
+
+func (m *memoryJobQueue) Receive(ctx context.Context, consumer string) (JobDelivery, error) {
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		for _, entry := range m.entries {
+			if entry.consumer == "" || time.Now().After(entry.deadline) {
+				entry.consumer = consumer
+				entry.deadline = time.Now().Add(m.redeliverAfter)
+				m.mu.Unlock()
+				return entry.delivery, nil
+			}
+		}
+		m.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	return JobDelivery{}, ErrNoJobAvailable
+}
+
+func (m *memoryJobQueue) Extend(ctx context.Context, consumer string, deliveries []JobDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, delivery := range deliveries {
+		for _, entry := range m.entries {
+			if entry.delivery.ID == delivery.ID && entry.consumer == consumer {
+				entry.deadline = time.Now().Add(m.redeliverAfter)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryJobQueue) Ack(ctx context.Context, delivery JobDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, entry := range m.entries {
+		if entry.delivery.ID == delivery.ID {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			m.acked = append(m.acked, delivery.Job.ID)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryJobQueue) pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func queueServer(queue JobQueue, consumer string, opts ...Option) *CIServer {
+	opts = append([]Option{
+		WithExternalQueue(ExternalQueue{Queue: queue, Consumer: consumer, ExtendInterval: 10 * time.Millisecond}),
+		WithGitClient(&fakeGit{}),
+		WithExecutor(&LocalExecutor{}),
+	}, opts...)
+	return NewCIServer(opts...).(*CIServer)
+}
+
+func waitForStatus(t *testing.T, s *CIServer, id JobID, status JobStatus) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for s.JobDetail(id).Status != status {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job to be %s, status: %s", status, s.JobDetail(id).Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExternalQueue(t *testing.T) {
+	queue := &memoryJobQueue{redeliverAfter: time.Minute}
+	// The first server can't run jobs, so the second receives them
+	a := queueServer(queue, "a", WithLocalAgent(false))
+	b := queueServer(queue, "b")
+
+	id := a.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	waitForStatus(t, b, id, JobStatusSuccess)
+
+	if job := a.JobDetail(id); job.Status != JobStatusPending {
+		t.Errorf("Expected the job to stay pending on a, got %s", job.Status)
+	}
+	if job := b.JobDetail(id); job.Number != 1 {
+		t.Errorf("Expected b to keep the job's build number, got %d", job.Number)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for queue.pending() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the job to be acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(queue.acked) != 1 || queue.acked[0] != id {
+		t.Errorf("Expected the job to be acknowledged once, got %v", queue.acked)
+	}
+}
+
+func TestExternalQueueRedelivery(t *testing.T) {
+	queue := &memoryJobQueue{redeliverAfter: 100 * time.Millisecond}
+	// A server received the job, then stopped before finishing it
+	id := NewJobID()
+	queue.Publish(context.Background(), Job{ID: id, Status: JobStatusPending, Number: 3, RepoURI: "https://example.com/repo.git", Commit: "main", Command: "echo hello"})
+	if _, err := queue.Receive(context.Background(), "gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := queueServer(queue, "b")
+	waitForStatus(t, b, id, JobStatusSuccess)
+	if job := b.ScheduleJob("https://example.com/repo.git", "main", "echo hello"); b.JobDetail(job).Number != 4 {
+		t.Errorf("Expected the next build to follow the redelivered one, got %d", b.JobDetail(job).Number)
+	}
+}
+
+func TestExternalQueueCancel(t *testing.T) {
+	queue := &memoryJobQueue{redeliverAfter: time.Minute}
+	a := queueServer(queue, "a", WithLocalAgent(false))
+
+	id := a.ScheduleJob("https://example.com/repo.git", "main", "echo hello")
+	if err := a.CancelJob(id); err != nil {
+		t.Fatal(err)
+	}
+	// The server receiving the cancelled job drops it from the queue
+	a.deliver(queue.entries[0].delivery)
+	if got := queue.pending(); got != 0 {
+		t.Errorf("Expected the cancelled job to be acknowledged, %d still queued", got)
+	}
+}
+
+func TestParseStreamReplies(t *testing.T) {
+	read := func(raw string) any {
+		reply, err := readRedisReply(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	job := `{"ID":"job1","Commit":"main"}`
+
+	deliveries, err := parseStreamRead(read("*1\r\n*2\r\n$5\r\nqueue\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$3\r\njob\r\n" + bulk(job)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].ID != "1-0" || deliveries[0].Job.ID != "job1" || deliveries[0].Job.Commit != "main" {
+		t.Errorf("Unexpected deliveries %+v", deliveries)
+	}
+	if deliveries, err := parseStreamRead(read("*-1\r\n")); err != nil || len(deliveries) != 0 {
+		t.Errorf("Expected no deliveries when the read times out, got %+v, %v", deliveries, err)
+	}
+
+	// Entries deleted while pending have no fields
+	cursor, deliveries, err := parseAutoClaim(read("*3\r\n$3\r\n5-0\r\n*2\r\n*2\r\n$3\r\n2-0\r\n*-1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$3\r\njob\r\n" + bulk(job) + "*0\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != "5-0" || len(deliveries) != 1 || deliveries[0].ID != "3-0" {
+		t.Errorf("Unexpected cursor %q and deliveries %+v", cursor, deliveries)
+	}
+}
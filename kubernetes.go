@@ -0,0 +1,365 @@
+package minici
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// KubernetesExecutor runs each job in its own pod using the kubectl CLI. The
+// workspace is copied into the pod before the command starts, and the pod is
+// deleted once the job completes or is cancelled.
+type KubernetesExecutor struct {
+	// Kubectl is the kubectl binary to use, defaults to "kubectl"
+	Kubectl string
+	// Context is the kubeconfig context to use, defaults to the current context
+	Context string
+	// Namespace pods are created in, defaults to the context's namespace
+	Namespace string
+	// Image is used for jobs that don't specify one
+	Image string
+	// HelperImage runs the init container that receives the workspace. It
+	// must provide sh and tar, and defaults to "busybox".
+	HelperImage string
+	// ServiceAccount pods run as, defaults to the namespace's default account
+	ServiceAccount string
+	// Resources requested for and limiting the job's container
+	Resources KubernetesResources
+	// StartTimeout limits how long to wait for the pod to start, including
+	// pulling images. Defaults to 10 minutes.
+	StartTimeout time.Duration
+}
+
+// KubernetesResources are resource quantities such as "cpu": "500m" or
+// "memory": "1Gi"
+type KubernetesResources struct {
+	Requests map[string]string
+	Limits   map[string]string
+}
+
+const (
+	kubernetesHelperContainer = "workspace"
+	kubernetesJobContainer    = "job"
+	// kubernetesReadyFile is created once the workspace has been copied, allowing
+	// the init container to exit
+	kubernetesReadyFile = ".minici-ready"
+)
+
+// Run creates a pod for the job, copies the workspace to it and streams the
+// command's output until it exits.
+func (e *KubernetesExecutor) Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error) {
+	log := workspace.Log
+	image := spec.Image
+	if image == "" {
+		image = e.Image
+	}
+	if image == "" {
+		log("Error: no image set for job")
+		return Result{ExitCode: -1}, fmt.Errorf("no image set for job and no default image configured")
+	}
+	if len(strings.Fields(spec.Command)) == 0 {
+		log("Error: empty command")
+		return Result{ExitCode: -1}, fmt.Errorf("empty command")
+	}
+	spec.Image = image
+
+	name := containerName(workspace.JobID)
+	log(fmt.Sprintf("Executing command in %s pod %s: %s", image, name, spec.Command))
+
+	manifest, err := json.Marshal(e.podManifest(name, workspace.JobID, spec))
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	create := e.command(ctx, "create", "-f", "-")
+	create.Stdin = strings.NewReader(string(manifest))
+	if output, err := create.CombinedOutput(); err != nil {
+		log("Failed to create pod: " + strings.TrimSpace(string(output)))
+		return Result{ExitCode: -1}, fmt.Errorf("failed to create pod: %w", err)
+	}
+	defer e.deletePod(name)
+
+	result, err := e.runPod(ctx, name, workspace)
+	if err != nil {
+		log("Command execution failed: " + err.Error())
+		return result, err
+	}
+	log("Command executed successfully")
+	return result, nil
+}
+
+func (e *KubernetesExecutor) runPod(ctx context.Context, name string, workspace Workspace) (Result, error) {
+	failed := Result{ExitCode: -1}
+	timeout := e.StartTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+	startCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := e.waitForContainer(startCtx, name, "initContainerStatuses", func(state containerState) bool {
+		return state.Running != nil
+	})
+	if err != nil {
+		return failed, fmt.Errorf("pod did not start: %w", err)
+	}
+
+	workspace.Log("Copying workspace to pod")
+	if err := e.copyWorkspace(ctx, name, workspace.Dir); err != nil {
+		return failed, fmt.Errorf("failed to copy workspace: %w", err)
+	}
+
+	_, err = e.waitForContainer(startCtx, name, "containerStatuses", func(state containerState) bool {
+		return state.Running != nil || state.Terminated != nil
+	})
+	if err != nil {
+		return failed, fmt.Errorf("job container did not start: %w", err)
+	}
+
+	logs := e.command(ctx, "logs", "--follow", "--container", kubernetesJobContainer, name)
+	if err := runLogged(logs, workspace.Log); err != nil {
+		return failed, fmt.Errorf("failed to stream logs: %w", err)
+	}
+
+	// The log stream ends when the container exits, but the pod's status may
+	// take a moment to catch up
+	state, err := e.waitForContainer(ctx, name, "containerStatuses", func(state containerState) bool {
+		return state.Terminated != nil
+	})
+	if err != nil {
+		return failed, err
+	}
+	result := Result{ExitCode: state.Terminated.ExitCode}
+	if result.ExitCode != 0 {
+		return result, fmt.Errorf("exit status %d", result.ExitCode)
+	}
+	return result, nil
+}
+
+// command builds a kubectl command using the configured context and namespace
+func (e *KubernetesExecutor) command(ctx context.Context, args ...string) *exec.Cmd {
+	kubectl := e.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+	var global []string
+	if e.Context != "" {
+		global = append(global, "--context", e.Context)
+	}
+	if e.Namespace != "" {
+		global = append(global, "--namespace", e.Namespace)
+	}
+	return exec.CommandContext(ctx, kubectl, append(global, args...)...)
+}
+
+func (e *KubernetesExecutor) deletePod(name string) {
+	// The job's context may already be cancelled, so clean up independently
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	e.command(ctx, "delete", "pod", name, "--ignore-not-found", "--wait=false").Run()
+}
+
+// containerState mirrors the state of a container in a pod's status
+type containerState struct {
+	Waiting *struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"waiting"`
+	Running    *struct{} `json:"running"`
+	Terminated *struct {
+		ExitCode int    `json:"exitCode"`
+		Reason   string `json:"reason"`
+	} `json:"terminated"`
+}
+
+// waitForContainer polls the state of the first container in the given status
+// list until done returns true. It gives up early if the container can't start.
+func (e *KubernetesExecutor) waitForContainer(ctx context.Context, name, statuses string, done func(containerState) bool) (containerState, error) {
+	jsonpath := fmt.Sprintf("jsonpath={.status.%s[0].state}", statuses)
+	for {
+		output, err := e.command(ctx, "get", "pod", name, "--output", jsonpath).Output()
+		if err == nil && len(strings.TrimSpace(string(output))) > 0 {
+			var state containerState
+			if err := json.Unmarshal(output, &state); err != nil {
+				return state, fmt.Errorf("failed to decode pod status: %w", err)
+			}
+			if done(state) {
+				return state, nil
+			}
+			if state.Waiting != nil && isFatalWaitReason(state.Waiting.Reason) {
+				return state, fmt.Errorf("%s: %s", state.Waiting.Reason, state.Waiting.Message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return containerState{}, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// isFatalWaitReason reports whether a waiting container will never start
+// without intervention
+func isFatalWaitReason(reason string) bool {
+	switch reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError":
+		return true
+	default:
+		return false
+	}
+}
+
+// copyWorkspace streams the workspace to the helper container as a tar
+// archive, then signals it to exit so the job container can start
+func (e *KubernetesExecutor) copyWorkspace(ctx context.Context, name, dir string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, dir))
+	}()
+
+	extract := e.command(ctx, "exec", "--stdin", name, "--container", kubernetesHelperContainer, "--",
+		"tar", "-x", "-f", "-", "-C", ContainerWorkdir)
+	extract.Stdin = reader
+	if output, err := extract.CombinedOutput(); err != nil {
+		reader.CloseWithError(err)
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	ready := e.command(ctx, "exec", name, "--container", kubernetesHelperContainer, "--",
+		"touch", ContainerWorkdir+"/"+kubernetesReadyFile)
+	if output, err := ready.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// writeTar archives the contents of dir, or only the given paths within it
+func writeTar(w io.Writer, dir string, paths ...string) error {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	archive := tar.NewWriter(w)
+	for _, root := range paths {
+		err := filepath.WalkDir(filepath.Join(dir, root), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			var link string
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(rel)
+			if err := archive.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(archive, file)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// podManifest describes the pod for a job. An init container holds the pod
+// until the workspace has been copied into a shared volume.
+func (e *KubernetesExecutor) podManifest(name string, jobID JobID, spec JobSpec) map[string]interface{} {
+	helperImage := e.HelperImage
+	if helperImage == "" {
+		helperImage = "busybox"
+	}
+	mounts := []map[string]interface{}{
+		{"name": "workspace", "mountPath": ContainerWorkdir},
+	}
+
+	env := []map[string]string{}
+	specEnv := workspaceEnv(spec.Env, ContainerWorkdir, ContainerScratchDir)
+	for _, key := range sortedKeys(specEnv) {
+		env = append(env, map[string]string{"name": key, "value": specEnv[key]})
+	}
+
+	job := map[string]interface{}{
+		"name":       kubernetesJobContainer,
+		"image":      spec.Image,
+		"args":       strings.Fields(spec.Command),
+		"workingDir": ContainerWorkdir,
+		"env":        env,
+		// Only the job's container needs somewhere for temporary files
+		"volumeMounts": append(mounts, map[string]interface{}{"name": "scratch", "mountPath": ContainerScratchDir}),
+	}
+	resources := map[string]interface{}{}
+	if len(e.Resources.Requests) > 0 {
+		resources["requests"] = e.Resources.Requests
+	}
+	if len(e.Resources.Limits) > 0 {
+		resources["limits"] = e.Resources.Limits
+	}
+	if len(resources) > 0 {
+		job["resources"] = resources
+	}
+
+	podSpec := map[string]interface{}{
+		"restartPolicy": "Never",
+		"initContainers": []map[string]interface{}{{
+			"name":  kubernetesHelperContainer,
+			"image": helperImage,
+			"command": []string{"sh", "-c", fmt.Sprintf(
+				"until [ -f %[1]s/%[2]s ]; do sleep 1; done; rm %[1]s/%[2]s", ContainerWorkdir, kubernetesReadyFile)},
+			"volumeMounts": mounts,
+		}},
+		"containers": []map[string]interface{}{job},
+		"volumes": []map[string]interface{}{
+			{"name": "workspace", "emptyDir": map[string]interface{}{}},
+			{"name": "scratch", "emptyDir": map[string]interface{}{}},
+		},
+	}
+	if e.ServiceAccount != "" {
+		podSpec["serviceAccountName"] = e.ServiceAccount
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"labels": map[string]string{
+				"app.kubernetes.io/managed-by": "minici",
+				"minici/job-id":                strings.ToLower(string(jobID)),
+			},
+		},
+		"spec": podSpec,
+	}
+}
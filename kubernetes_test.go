@@ -0,0 +1,158 @@
+package minici
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeKubectlScript emulates the kubectl commands used by KubernetesExecutor,
+// recording what it receives in $FAKE_KUBE
+const fakeKubectlScript = `#!/bin/sh
+echo "$@" >> "$FAKE_KUBE/calls"
+while [ "${1#--}" != "$1" ]; do shift 2; done
+case "$1" in
+create) cat > "$FAKE_KUBE/manifest.json" ;;
+get)
+	case "$*" in
+	*initContainerStatuses*) echo '{"running":{}}' ;;
+	*) echo '{"terminated":{"exitCode":3}}' ;;
+	esac ;;
+exec)
+	case "$*" in
+	*tar*) cat > "$FAKE_KUBE/workspace.tar" ;;
+	*touch*) touch "$FAKE_KUBE/ready" ;;
+	esac ;;
+logs) echo "hello from pod" ;;
+delete) touch "$FAKE_KUBE/deleted" ;;
+esac
+`
+
+func TestKubernetesExecutor(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("FAKE_KUBE", state)
+	kubectl := filepath.Join(t.TempDir(), "kubectl")
+	if err := os.WriteFile(kubectl, []byte(fakeKubectlScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	workspace := Workspace{JobID: "01ABC", Dir: dir, Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	executor := &KubernetesExecutor{
+		Kubectl:        kubectl,
+		Namespace:      "ci",
+		Image:          "golang:1.24",
+		ServiceAccount: "builder",
+		Resources: KubernetesResources{
+			Requests: map[string]string{"cpu": "500m"},
+			Limits:   map[string]string{"memory": "1Gi"},
+		},
+	}
+
+	result, err := executor.Run(context.Background(), workspace, JobSpec{
+		Command: "go test ./...",
+		Env:     map[string]string{"FOO": "bar"},
+	})
+	if err == nil {
+		t.Error("Expected a non-zero exit code to return an error")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "> hello from pod") {
+		t.Errorf("Expected pod logs to be streamed, got %v", lines)
+	}
+
+	calls, err := os.ReadFile(filepath.Join(state, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(calls), "--namespace ci create") {
+		t.Errorf("Expected pod to be created in the namespace, got:\n%s", calls)
+	}
+
+	manifestContent, err := os.ReadFile(filepath.Join(state, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			ServiceAccountName string `json:"serviceAccountName"`
+			Containers         []struct {
+				Image     string              `json:"image"`
+				Args      []string            `json:"args"`
+				Env       []map[string]string `json:"env"`
+				Resources KubernetesResources `json:"resources"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(manifestContent, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Metadata.Name != "minici-01abc" {
+		t.Errorf("Expected pod name minici-01abc, got %q", manifest.Metadata.Name)
+	}
+	if manifest.Spec.ServiceAccountName != "builder" {
+		t.Errorf("Expected service account builder, got %q", manifest.Spec.ServiceAccountName)
+	}
+	container := manifest.Spec.Containers[0]
+	if container.Image != "golang:1.24" {
+		t.Errorf("Expected default image, got %q", container.Image)
+	}
+	if strings.Join(container.Args, " ") != "go test ./..." {
+		t.Errorf("Expected command as args, got %v", container.Args)
+	}
+	expectedEnv := []map[string]string{
+		{"name": "FOO", "value": "bar"},
+		{"name": "MINICI_SCRATCH_DIR", "value": "/minici/scratch"},
+		{"name": "MINICI_WORKSPACE", "value": "/workspace"},
+	}
+	if !reflect.DeepEqual(container.Env, expectedEnv) {
+		t.Errorf("Expected env %v, got %v", expectedEnv, container.Env)
+	}
+	if container.Resources.Requests["cpu"] != "500m" || container.Resources.Limits["memory"] != "1Gi" {
+		t.Errorf("Expected resources to be set, got %+v", container.Resources)
+	}
+
+	archive, err := os.Open(filepath.Join(state, "workspace.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	header, err := tar.NewReader(archive).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "main.go" {
+		t.Errorf("Expected workspace to be copied, got %q", header.Name)
+	}
+
+	for _, file := range []string{"ready", "deleted"} {
+		if _, err := os.Stat(filepath.Join(state, file)); err != nil {
+			t.Errorf("Expected %s to be recorded: %v", file, err)
+		}
+	}
+}
+
+func TestKubernetesExecutorNoImage(t *testing.T) {
+	workspace := Workspace{Dir: t.TempDir(), Log: func(string) {}}
+	_, err := (&KubernetesExecutor{}).Run(context.Background(), workspace, JobSpec{Command: "make"})
+	if err == nil {
+		t.Error("Expected an error when no image is configured")
+	}
+}
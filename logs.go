@@ -0,0 +1,352 @@
+package minici
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// logStore holds each job's log lines. The caller must hold jobMutex, for
+// writing if it changes the logs.
+type logStore interface {
+	// append adds a line to a job's logs, returning its index
+	append(id JobID, line string) int
+	// lines returns a job's lines from index from on, which the caller may
+	// share but not append to in place
+	lines(id JobID, from int) []string
+	// line returns the line of a job's logs at index
+	line(id JobID, index int) string
+	// done is called when a job finishes, though it may still be logged to
+	// if it is requeued
+	done(id JobID)
+}
+
+// WithLogDir keeps job logs in an append-only file per job in dir, rather than
+// in memory, so the server's memory doesn't grow with the size of its logs.
+// Only the offset of each line is held in memory, and reads come straight
+// from the files. Once a job is done its file is gzipped, and decompressed as
+// it's read. Lines that can't be written are kept in memory instead.
+func WithLogDir(dir string) Option {
+	return func(s *CIServer) {
+		s.logs = &fileLogs{dir: dir, files: make(map[JobID]*logFile)}
+	}
+}
+
+// WithLogEncryption encrypts each line written to the log files of WithLogDir
+// with the keyring's first key. Files written before encryption was enabled,
+// or with older keys still in the keyring, can still be read.
+func WithLogEncryption(keyring *Keyring) Option {
+	return func(s *CIServer) {
+		s.logKeyring = keyring
+	}
+}
+
+// memoryLogs holds each job's lines in memory, used unless WithLogDir is given
+type memoryLogs map[JobID][]string
+
+func (m memoryLogs) append(id JobID, line string) int {
+	m[id] = append(m[id], line)
+	return len(m[id]) - 1
+}
+
+func (m memoryLogs) lines(id JobID, from int) []string {
+	logs := m[id]
+	if from >= len(logs) {
+		return []string{}
+	}
+	// Lines are only ever appended, so they can be shared as long as the
+	// slice can't be appended to in place
+	return logs[max(from, 0):len(logs):len(logs)]
+}
+
+func (m memoryLogs) line(id JobID, index int) string {
+	return m[id][index]
+}
+
+func (m memoryLogs) done(id JobID) {}
+
+// fileLogs keeps each job's logs in dir, in a file named after the job. Once
+// a job is done its file is compressed in the background.
+type fileLogs struct {
+	dir   string
+	files map[JobID]*logFile
+	// keyring encrypts each line, if set
+	keyring *Keyring
+	// compressing counts the files being compressed
+	compressing sync.WaitGroup
+}
+
+// logFile is a job's log file and where each of its lines ends. Its fields
+// are guarded by mu, as it's compressed without holding jobMutex.
+type logFile struct {
+	mu sync.Mutex
+	// file is open for appending while the job runs, and nil once it's done
+	file *os.File
+	// compressed is set once the file has been replaced with a gzipped copy
+	compressed bool
+	// ends holds the offset just past each line's newline, before compression
+	ends []int64
+	// unwritten holds lines that couldn't be written to the file, by index.
+	// They take up no space in the file.
+	unwritten map[int]string
+}
+
+// start returns the offset of the line at index
+func (f *logFile) start(index int) int64 {
+	if index == 0 {
+		return 0
+	}
+	return f.ends[index-1]
+}
+
+func (l *fileLogs) path(id JobID) string {
+	return filepath.Join(l.dir, string(id)+".log")
+}
+
+func (l *fileLogs) append(id JobID, line string) int {
+	f, ok := l.files[id]
+	if !ok {
+		f = &logFile{}
+		l.files[id] = f
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := len(f.ends)
+	end := f.start(index)
+	stored, err := l.keyring.encryptIfEnabled([]byte(line))
+	if err == nil {
+		err = l.write(id, f, end, string(stored))
+	}
+	if err != nil {
+		if f.unwritten == nil {
+			f.unwritten = make(map[int]string)
+		}
+		f.unwritten[index] = line
+	} else {
+		end += int64(len(stored)) + 1
+	}
+	f.ends = append(f.ends, end)
+	return index
+}
+
+// write writes a line at offset, opening the job's file if needed. Writing at
+// an offset rather than appending overwrites anything a failed write left behind.
+func (l *fileLogs) write(id JobID, f *logFile, offset int64, line string) error {
+	if f.file == nil {
+		if err := os.MkdirAll(l.dir, 0o755); err != nil {
+			return err
+		}
+		// A requeued job logs again, so its file is decompressed to append to
+		if f.compressed {
+			if err := decompressFile(l.path(id)+".gz", l.path(id)); err != nil {
+				return err
+			}
+			f.compressed = false
+		}
+		file, err := os.OpenFile(l.path(id), os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		f.file = file
+	}
+	_, err := f.file.WriteAt([]byte(line+"\n"), offset)
+	return err
+}
+
+func (l *fileLogs) lines(id JobID, from int) []string {
+	f, ok := l.files[id]
+	if !ok {
+		return []string{}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	from = max(from, 0)
+	if from >= len(f.ends) {
+		return []string{}
+	}
+	start, end := f.start(from), f.ends[len(f.ends)-1]
+	content := make([]byte, end-start)
+	if err := l.read(id, f, content, start); err != nil {
+		content = nil
+	}
+
+	lines := make([]string, 0, len(f.ends)-from)
+	for index := from; index < len(f.ends); index++ {
+		if text, ok := f.unwritten[index]; ok {
+			lines = append(lines, text)
+			continue
+		}
+		lineStart, lineEnd := f.start(index)-start, f.ends[index]-start-1
+		if content == nil || lineEnd < lineStart {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, l.decode(content[lineStart:lineEnd]))
+	}
+	return lines
+}
+
+// decode returns the text of a stored line, which is empty if it can't be
+// decrypted
+func (l *fileLogs) decode(stored []byte) string {
+	text, err := l.keyring.decryptIfEncrypted(stored)
+	if err != nil {
+		return ""
+	}
+	return string(text)
+}
+
+func (l *fileLogs) line(id JobID, index int) string {
+	f := l.files[id]
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if text, ok := f.unwritten[index]; ok {
+		return text
+	}
+	start, end := f.start(index), f.ends[index]
+	if end == start {
+		return ""
+	}
+	content := make([]byte, end-start)
+	if err := l.read(id, f, content, start); err != nil {
+		return ""
+	}
+	return l.decode(content[:len(content)-1])
+}
+
+// read fills content from the job's file at offset, opening the file if the
+// job is done
+func (l *fileLogs) read(id JobID, f *logFile, content []byte, offset int64) error {
+	if len(content) == 0 {
+		return nil
+	}
+	if f.file != nil {
+		_, err := f.file.ReadAt(content, offset)
+		return err
+	}
+	if f.compressed {
+		return readCompressed(l.path(id)+".gz", content, offset)
+	}
+	file, err := os.Open(l.path(id))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.ReadAt(content, offset)
+	return err
+}
+
+func (l *fileLogs) done(id JobID) {
+	f, ok := l.files[id]
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return
+	}
+	f.file.Close()
+	f.file = nil
+	l.compressing.Add(1)
+	go l.compress(id, f, len(f.ends))
+}
+
+// compress replaces a finished job's log file with a gzipped copy, unless the
+// job logged more lines while it was being compressed. A file that can't be
+// compressed is left as it is.
+func (l *fileLogs) compress(id JobID, f *logFile, lines int) {
+	defer l.compressing.Done()
+	path := l.path(id)
+	temp, err := compressFile(path)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil || len(f.ends) != lines || os.Rename(temp, path+".gz") != nil {
+		os.Remove(temp)
+		return
+	}
+	f.compressed = true
+	os.Remove(path)
+}
+
+// compressFile gzips the file at path into a temporary file beside it,
+// returning the temporary file's path
+func compressFile(path string) (string, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".log-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	writer := gzip.NewWriter(temp)
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// decompressFile restores a gzipped log file to path, removing the gzipped copy
+func decompressFile(gzipped, path string) error {
+	source, err := os.Open(gzipped)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	reader, err := gzip.NewReader(source)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(gzipped)
+}
+
+// readCompressed fills content from a gzipped file, starting offset bytes
+// into its uncompressed contents
+func readCompressed(path string, content []byte, offset int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(reader, content)
+	return err
+}
@@ -0,0 +1,124 @@
+package minici
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogDir(t *testing.T) {
+	dir := t.TempDir()
+	ci := NewCIServer(
+		WithLogDir(dir),
+		WithGitClient(&fakeGit{}),
+		WithExecutor(&LocalExecutor{}),
+		WithJobStarter(func(run func()) { run() }),
+	)
+
+	id := ci.ScheduleJob("https://example.com/repo.git", "main", "echo first && echo && echo third")
+	if job := ci.JobDetail(id); job.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %s: %q", job.Status, ci.JobLogs(id))
+	}
+	logs := ci.JobLogs(id)
+	ci.(*CIServer).logs.(*fileLogs).compressing.Wait()
+	if _, err := os.Stat(filepath.Join(dir, string(id)+".log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the finished job's log file to be replaced, got %v", err)
+	}
+	content, err := readGzip(filepath.Join(dir, string(id)+".log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != strings.Join(logs, "\n")+"\n" {
+		t.Errorf("Expected the compressed log file to hold the job's logs %q, got %q", logs, content)
+	}
+
+	from := len(logs) - 3
+	if tail := ci.JobLogsFrom(id, from); !reflect.DeepEqual(tail, logs[from:]) {
+		t.Errorf("Expected lines from %d to be %q, got %q", from, logs[from:], tail)
+	}
+	if past := ci.JobLogsFrom(id, len(logs)); len(past) != 0 {
+		t.Errorf("Expected no lines past the end, got %q", past)
+	}
+
+	result := ci.(LogSearcher).SearchLogs(LogSearch{Query: "third"})
+	if result.TotalJobs != 1 || !strings.HasSuffix(result.Jobs[0].Lines[0].Text, "third") {
+		t.Errorf("Expected searches to read lines from the file, got %+v", result)
+	}
+}
+
+func TestLogDirRequeued(t *testing.T) {
+	logs := &fileLogs{dir: t.TempDir(), files: make(map[JobID]*logFile)}
+	logs.append("job", "first run")
+	logs.done("job")
+	logs.compressing.Wait()
+	if line := logs.line("job", 0); line != "first run" {
+		t.Errorf("Expected to read line 0 from the compressed file, got %q", line)
+	}
+
+	// Logging again restores the file to append to, then compresses it again
+	logs.append("job", "second run")
+	logs.done("job")
+	logs.compressing.Wait()
+	if lines := logs.lines("job", 0); !reflect.DeepEqual(lines, []string{"first run", "second run"}) {
+		t.Errorf("Expected both runs' lines, got %q", lines)
+	}
+}
+
+func TestLogDirEncrypted(t *testing.T) {
+	keyring, _ := NewKeyring(testKey(1))
+	dir := t.TempDir()
+	logs := &fileLogs{dir: dir, files: make(map[JobID]*logFile), keyring: keyring}
+	logs.append("job", "token=hunter2")
+	logs.append("job", "")
+	logs.append("job", "done")
+	if lines := logs.lines("job", 1); !reflect.DeepEqual(lines, []string{"", "done"}) {
+		t.Errorf("Expected to read decrypted lines, got %q", lines)
+	}
+	logs.done("job")
+	logs.compressing.Wait()
+
+	content, err := readGzip(filepath.Join(dir, "job.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "hunter2") || strings.Count(string(content), "\n") != 3 {
+		t.Errorf("Expected one encrypted line per log line, got %q", content)
+	}
+	if line := logs.line("job", 0); line != "token=hunter2" {
+		t.Errorf("Expected to decrypt the compressed file, got %q", line)
+	}
+}
+
+func readGzip(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestLogDirUnwritable(t *testing.T) {
+	// A file where the directory should be means no log file can be created
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logs := &fileLogs{dir: dir, files: make(map[JobID]*logFile)}
+	logs.append("job", "kept")
+	logs.append("job", "in memory")
+	if lines := logs.lines("job", 0); !reflect.DeepEqual(lines, []string{"kept", "in memory"}) {
+		t.Errorf("Expected lines that can't be written to be kept, got %q", lines)
+	}
+	if line := logs.line("job", 1); line != "in memory" {
+		t.Errorf("Expected line 1 to be kept, got %q", line)
+	}
+}
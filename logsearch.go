@@ -0,0 +1,154 @@
+package minici
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// LogSearcher is implemented by CI servers that index job logs for searching
+type LogSearcher interface {
+	SearchLogs(search LogSearch) LogSearchResult
+}
+
+// LogSearch describes a search of job logs. Lines match if they contain the
+// query, ignoring case. Words in the query are matched whole, so "undefined"
+// doesn't match "undefinedVar".
+type LogSearch struct {
+	Query string
+	// RepoURI limits the search to one repo if set
+	RepoURI string
+	// Project limits the search to one project's jobs if set
+	Project string
+	// Limit is the most jobs to return, defaults to 50
+	Limit int
+	// LinesPerJob is the most matching lines to return for each job, defaults to 5
+	LinesPerJob int
+}
+
+// LogSearchResult holds the jobs with matching lines, oldest first, so the
+// first job is when the query first appeared
+type LogSearchResult struct {
+	Jobs []LogSearchMatch
+	// TotalJobs counts every job that matched, including any beyond the limit
+	TotalJobs int
+}
+
+// LogSearchMatch is a job with lines matching a search
+type LogSearchMatch struct {
+	Job   Job
+	Lines []LogLine
+	// TotalLines counts every matching line in the job's logs
+	TotalLines int
+}
+
+// LogLine is a line from a job's logs
+type LogLine struct {
+	// Index is the line's position in the job's logs, starting from 0
+	Index int
+	Text  string
+}
+
+// logIndex maps each word in job logs to the lines containing it
+type logIndex struct {
+	postings map[string][]logRef
+}
+
+type logRef struct {
+	job  JobID
+	line int
+}
+
+// logWords splits text into lowercase words of letters, digits and underscores
+func logWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// add indexes a line of a job's logs. Lines must be added in order.
+func (i *logIndex) add(jobID JobID, line int, text string) {
+	if i.postings == nil {
+		i.postings = make(map[string][]logRef)
+	}
+	ref := logRef{job: jobID, line: line}
+	for _, word := range logWords(text) {
+		postings := i.postings[word]
+		// Words repeated on a line are only indexed once
+		if len(postings) > 0 && postings[len(postings)-1] == ref {
+			continue
+		}
+		i.postings[word] = append(postings, ref)
+	}
+}
+
+// candidates returns the lines that contain every word, using the rarest
+// word's postings to check as few lines as possible
+func (i *logIndex) candidates(words []string) []logRef {
+	var rarest []logRef
+	for n, word := range words {
+		postings := i.postings[word]
+		if len(postings) == 0 {
+			return nil
+		}
+		if n == 0 || len(postings) < len(rarest) {
+			rarest = postings
+		}
+	}
+	return rarest
+}
+
+// SearchLogs finds jobs whose logs contain the query
+func (s *CIServer) SearchLogs(search LogSearch) LogSearchResult {
+	if search.Limit <= 0 {
+		search.Limit = 50
+	}
+	if search.LinesPerJob <= 0 {
+		search.LinesPerJob = 5
+	}
+	query := strings.ToLower(strings.TrimSpace(search.Query))
+	words := logWords(query)
+	if len(words) == 0 {
+		return LogSearchResult{}
+	}
+
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+
+	matches := map[JobID]*LogSearchMatch{}
+	for _, ref := range s.logIndex.candidates(words) {
+		job, ok := s.jobs[ref.job]
+		if !ok || (search.RepoURI != "" && job.RepoURI != search.RepoURI) || (search.Project != "" && job.Project != search.Project) {
+			continue
+		}
+		text := s.logs.line(ref.job, ref.line)
+		if !strings.Contains(strings.ToLower(text), query) {
+			continue
+		}
+		match, ok := matches[ref.job]
+		if !ok {
+			match = &LogSearchMatch{Job: *job}
+			matches[ref.job] = match
+		}
+		match.TotalLines++
+		if len(match.Lines) < search.LinesPerJob {
+			match.Lines = append(match.Lines, LogLine{Index: ref.line, Text: text})
+		}
+	}
+
+	result := LogSearchResult{TotalJobs: len(matches)}
+	for _, match := range matches {
+		result.Jobs = append(result.Jobs, *match)
+	}
+	sort.Slice(result.Jobs, func(i, j int) bool {
+		a, b := result.Jobs[i].Job, result.Jobs[j].Job
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	if len(result.Jobs) > search.Limit {
+		result.Jobs = result.Jobs[:search.Limit]
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package minici
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchLogs(t *testing.T) {
+	s := NewCIServer(WithLocalAgent(false)).(*CIServer)
+	start := time.Now()
+	jobs := []*Job{
+		{ID: "01A", RepoURI: "repo", CreatedAt: start},
+		{ID: "01B", RepoURI: "other", CreatedAt: start.Add(time.Minute)},
+		{ID: "01C", RepoURI: "repo", CreatedAt: start.Add(2 * time.Minute)},
+	}
+	for _, job := range jobs {
+		s.jobs[job.ID] = job
+	}
+	s.appendLog(jobs[2], "> main.go:3: Undefined: foo bar")
+	s.appendLog(jobs[2], "> undefined: foo")
+	s.appendLog(jobs[0], "> ok")
+	s.appendLog(jobs[0], "> main.go:3: undefined: foo")
+	s.appendLog(jobs[1], "> undefined: foo")
+	s.appendLog(jobs[1], "> undefined: fooBar")
+
+	// Jobs are returned oldest first, matching case-insensitively
+	result := s.SearchLogs(LogSearch{Query: "undefined: FOO"})
+	if result.TotalJobs != 3 || len(result.Jobs) != 3 {
+		t.Fatalf("Expected 3 jobs, got %+v", result)
+	}
+	if first := result.Jobs[0]; first.Job.ID != "01A" || len(first.Lines) != 1 || first.Lines[0].Index != 1 {
+		t.Errorf("Unexpected first match: %+v", first)
+	}
+	if last := result.Jobs[2]; last.TotalLines != 2 || last.Lines[0].Text != "> main.go:3: Undefined: foo bar" {
+		t.Errorf("Unexpected last match: %+v", last)
+	}
+
+	// Words are matched whole
+	result = s.SearchLogs(LogSearch{Query: "foobar"})
+	if len(result.Jobs) != 1 || result.Jobs[0].Job.ID != "01B" {
+		t.Errorf("Expected only the exact word to match, got %+v", result.Jobs)
+	}
+	if result = s.SearchLogs(LogSearch{Query: "undef"}); result.TotalJobs != 0 {
+		t.Errorf("Expected partial words not to match, got %+v", result.Jobs)
+	}
+
+	// The words must appear together as in the query
+	if result = s.SearchLogs(LogSearch{Query: "foo undefined"}); result.TotalJobs != 0 {
+		t.Errorf("Expected no matches, got %+v", result.Jobs)
+	}
+
+	result = s.SearchLogs(LogSearch{Query: "undefined", RepoURI: "repo", Limit: 1, LinesPerJob: 1})
+	if result.TotalJobs != 2 || len(result.Jobs) != 1 || result.Jobs[0].Job.ID != "01A" {
+		t.Errorf("Expected the oldest job for the repo, got %+v", result)
+	}
+}
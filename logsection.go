@@ -0,0 +1,66 @@
+package minici
+
+import (
+	"strings"
+)
+
+// maxLogSections limits how many sections are recorded for a job
+const maxLogSections = 1000
+
+// LogSection is a collapsible group of lines in a job's logs, marked by the
+// job's output in the style of GitHub Actions:
+//
+//	echo "::group::Install dependencies"
+//	npm ci
+//	echo "::endgroup::"
+type LogSection struct {
+	Name string
+	// Start is the index of the line that opened the section
+	Start int
+	// End is the index of the line that closed the section, or -1 while it's
+	// open. A section left open when the job finishes runs to the end of its logs.
+	End int
+	// Depth counts the sections this one is nested in
+	Depth int
+}
+
+// groupMarker reports whether a log line opens or closes a section. The
+// ##[group] form is accepted too, as some tools still write it.
+func groupMarker(line string) (name string, start, end bool) {
+	text := strings.TrimPrefix(line, "> ")
+	for _, prefix := range []string{"::group::", "##[group]"} {
+		if rest, ok := strings.CutPrefix(text, prefix); ok {
+			return strings.TrimSpace(rest), true, false
+		}
+	}
+	trimmed := strings.TrimSpace(text)
+	return "", false, trimmed == "::endgroup::" || trimmed == "##[endgroup]"
+}
+
+// section opens or closes a section if the job's newest log line, at index,
+// is a group marker. Stray end markers are ignored. The caller must hold
+// jobMutex.
+func (s *CIServer) section(job *Job, index int, line string) {
+	name, start, end := groupMarker(line)
+	if !start && !end {
+		return
+	}
+	open := 0
+	for _, section := range job.Sections {
+		if section.End < 0 {
+			open++
+		}
+	}
+	switch {
+	case start && len(job.Sections) < maxLogSections:
+		job.Sections = append(job.Sections, LogSection{Name: name, Start: index, End: -1, Depth: open})
+	case end && open > 0:
+		// Close the innermost open section
+		for i := len(job.Sections) - 1; i >= 0; i-- {
+			if job.Sections[i].End < 0 {
+				job.Sections[i].End = index
+				break
+			}
+		}
+	}
+}
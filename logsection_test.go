@@ -0,0 +1,40 @@
+package minici
+
+import (
+	"testing"
+)
+
+func TestLogSections(t *testing.T) {
+	s := NewCIServer(WithLocalAgent(false)).(*CIServer)
+	job := &Job{ID: "01ABC"}
+	s.jobs[job.ID] = job
+	for _, line := range []string{
+		"Starting job execution",
+		"> ::group::Install dependencies",
+		"> npm ci",
+		"> ##[group]Nested",
+		"> added 120 packages",
+		"> ##[endgroup]",
+		"> ::endgroup::",
+		"> ::endgroup::",
+		"::group:: Test ",
+		"> ok",
+	} {
+		s.appendLog(job, line)
+	}
+
+	expected := []LogSection{
+		{Name: "Install dependencies", Start: 1, End: 6, Depth: 0},
+		{Name: "Nested", Start: 3, End: 5, Depth: 1},
+		// Left open when the job finished
+		{Name: "Test", Start: 8, End: -1, Depth: 0},
+	}
+	if len(job.Sections) != len(expected) {
+		t.Fatalf("Expected %d sections, got %+v", len(expected), job.Sections)
+	}
+	for i := range expected {
+		if job.Sections[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], job.Sections[i])
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LogEntry is a line from a job's logs, with the labels shippers attach to it
+type LogEntry struct {
+	Time    time.Time
+	JobID   JobID
+	RepoURI string
+	Commit  string
+	Line    string
+}
+
+// LogShipper sends job log lines to a central logging service
+type LogShipper interface {
+	// Ship sends a batch of entries, in the order they were logged
+	Ship(ctx context.Context, entries []LogEntry) error
+}
+
+// LogForwarder collects job log lines and ships them in the background, so
+// slow or unavailable logging services never hold up jobs. Register it with
+// WithJobListener(f.HandleJobEvent) and WithLogListener(f.HandleLog).
+type LogForwarder struct {
+	// FlushInterval is how often lines are shipped, defaults to a second
+	FlushInterval time.Duration
+	// OnError is called when a batch can't be shipped or lines are dropped
+	OnError func(error)
+
+	mutex  sync.Mutex
+	jobs   map[JobID]*forwardedJob
+	queues []*shipperQueue
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// maxPendingLogs limits how many lines are held for a shipper that is
+// failing, beyond which the oldest are dropped
+const maxPendingLogs = 10000
+
+type forwardedJob struct {
+	repoURI  string
+	commit   string
+	finished time.Time
+}
+
+type shipperQueue struct {
+	shipper LogShipper
+	pending []LogEntry
+	dropped int
+}
+
+// NewLogForwarder creates a forwarder for the shippers. Call Start to begin
+// shipping.
+func NewLogForwarder(shippers ...LogShipper) *LogForwarder {
+	f := &LogForwarder{
+		jobs:    make(map[JobID]*forwardedJob),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, shipper := range shippers {
+		f.queues = append(f.queues, &shipperQueue{shipper: shipper})
+	}
+	return f
+}
+
+// HandleJobEvent is a JobListener that records the labels for a job's lines
+func (f *LogForwarder) HandleJobEvent(event JobEvent) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, ok := f.jobs[event.Job.ID]
+	if !ok {
+		job = &forwardedJob{repoURI: event.Job.RepoURI, commit: event.Job.Commit}
+		f.jobs[event.Job.ID] = job
+	}
+	if event.Job.Status.Done() {
+		job.finished = time.Now()
+	}
+}
+
+// HandleLog is a LogListener that queues a line to be shipped
+func (f *LogForwarder) HandleLog(jobID JobID, line string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	entry := LogEntry{Time: time.Now(), JobID: jobID, Line: line}
+	if job, ok := f.jobs[jobID]; ok {
+		entry.RepoURI = job.repoURI
+		entry.Commit = job.commit
+	}
+	for _, queue := range f.queues {
+		queue.add(entry)
+	}
+}
+
+func (q *shipperQueue) add(entries ...LogEntry) {
+	q.pending = append(q.pending, entries...)
+	if excess := len(q.pending) - maxPendingLogs; excess > 0 {
+		q.pending = q.pending[excess:]
+		q.dropped += excess
+	}
+}
+
+// Start ships queued lines in the background until Close is called
+func (f *LogForwarder) Start() {
+	go f.run()
+}
+
+// Close stops the forwarder once it has tried to ship any remaining lines
+func (f *LogForwarder) Close() {
+	close(f.stop)
+	<-f.stopped
+}
+
+func (f *LogForwarder) run() {
+	defer close(f.stopped)
+
+	interval := f.FlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.flush()
+		case <-f.stop:
+			f.flush()
+			return
+		}
+	}
+}
+
+// flush ships each shipper's pending lines. A batch that fails is kept to be
+// retried on the next flush.
+func (f *LogForwarder) flush() {
+	for _, queue := range f.queues {
+		f.mutex.Lock()
+		batch, dropped := queue.pending, queue.dropped
+		queue.pending, queue.dropped = nil, 0
+		f.mutex.Unlock()
+
+		if dropped > 0 {
+			f.error(fmt.Errorf("dropped %d log lines that couldn't be shipped", dropped))
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := queue.shipper.Ship(ctx, batch)
+		cancel()
+		if err != nil {
+			f.error(err)
+			f.mutex.Lock()
+			newer := queue.pending
+			queue.pending = nil
+			queue.add(batch...)
+			queue.add(newer...)
+			f.mutex.Unlock()
+		}
+	}
+
+	// Labels are kept for a while after a job finishes, for lines logged as it's cleaned up
+	f.mutex.Lock()
+	for id, job := range f.jobs {
+		if !job.finished.IsZero() && time.Since(job.finished) > time.Minute {
+			delete(f.jobs, id)
+		}
+	}
+	f.mutex.Unlock()
+}
+
+func (f *LogForwarder) error(err error) {
+	if f.OnError != nil {
+		f.OnError(err)
+	}
+}
+
+// LogExportConfig is the file format for configuring log shippers
+type LogExportConfig struct {
+	Shippers []LogShipperConfig `yaml:"shippers"`
+}
+
+// LogShipperConfig configures a single log shipper
+type LogShipperConfig struct {
+	// Type selects the backend: "loki", "cloudwatch" or "syslog"
+	Type string `yaml:"type"`
+
+	// URL, Tenant, Username, Password, Token and Labels are used by the loki backend
+	URL      string            `yaml:"url"`
+	Tenant   string            `yaml:"tenant"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Token    string            `yaml:"token"`
+	Labels   map[string]string `yaml:"labels"`
+
+	// Region, LogGroup and Endpoint are used by the cloudwatch backend, which
+	// reads credentials from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and
+	// $AWS_SESSION_TOKEN
+	Region   string `yaml:"region"`
+	LogGroup string `yaml:"log_group"`
+	Endpoint string `yaml:"endpoint"`
+
+	// Address, Facility and Tag are used by the syslog backend
+	Address  string `yaml:"address"`
+	Facility string `yaml:"facility"`
+	Tag      string `yaml:"tag"`
+}
+
+// LoadLogExportConfig reads a log export config from a YAML file
+func LoadLogExportConfig(path string) (LogExportConfig, error) {
+	var config LogExportConfig
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read log export config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("failed to parse log export config: %w", err)
+	}
+	return config, nil
+}
+
+// LogShippers builds the shippers described by the config
+func (c LogExportConfig) LogShippers() ([]LogShipper, error) {
+	var shippers []LogShipper
+	for i, sc := range c.Shippers {
+		shipper, err := sc.shipper()
+		if err != nil {
+			return nil, fmt.Errorf("log shipper %d: %w", i, err)
+		}
+		shippers = append(shippers, shipper)
+	}
+	return shippers, nil
+}
+
+func (c LogShipperConfig) shipper() (LogShipper, error) {
+	switch c.Type {
+	case "loki":
+		if c.URL == "" {
+			return nil, errors.New("loki requires a url")
+		}
+		return &LokiShipper{
+			URL:      c.URL,
+			Tenant:   c.Tenant,
+			Username: c.Username,
+			Password: c.Password,
+			Token:    c.Token,
+			Labels:   c.Labels,
+		}, nil
+	case "cloudwatch":
+		if c.LogGroup == "" {
+			return nil, errors.New("cloudwatch requires a log_group")
+		}
+		return &CloudWatchShipper{
+			Endpoint:        c.Endpoint,
+			Region:          c.Region,
+			LogGroup:        c.LogGroup,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	case "syslog":
+		if c.Address == "" {
+			return nil, errors.New("syslog requires an address")
+		}
+		facility, err := parseSyslogFacility(c.Facility)
+		if err != nil {
+			return nil, err
+		}
+		return &SyslogShipper{Address: c.Address, Facility: facility, Tag: c.Tag}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q, expected loki, cloudwatch or syslog", c.Type)
+}
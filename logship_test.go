@@ -0,0 +1,232 @@
+package minici
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeShipper records what it ships, failing while fail is set
+type fakeShipper struct {
+	mutex   sync.Mutex
+	fail    bool
+	shipped []LogEntry
+}
+
+func (f *fakeShipper) Ship(ctx context.Context, entries []LogEntry) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.fail {
+		return errors.New("unavailable")
+	}
+	f.shipped = append(f.shipped, entries...)
+	return nil
+}
+
+func TestLogForwarder(t *testing.T) {
+	shipper := &fakeShipper{fail: true}
+	forwarder := NewLogForwarder(shipper)
+	var errs []error
+	forwarder.OnError = func(err error) { errs = append(errs, err) }
+
+	forwarder.HandleJobEvent(JobEvent{Job: Job{ID: "01ABC", RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Status: JobStatusPending}})
+	forwarder.HandleLog("01ABC", "Job scheduled")
+	forwarder.flush()
+	if len(errs) != 1 {
+		t.Fatalf("Expected the failed batch to be reported, got %v", errs)
+	}
+
+	// The failed batch is retried ahead of newer lines
+	shipper.fail = false
+	forwarder.HandleLog("01ABC", "> hello")
+	forwarder.flush()
+	if len(shipper.shipped) != 2 || shipper.shipped[0].Line != "Job scheduled" || shipper.shipped[1].Line != "> hello" {
+		t.Fatalf("Expected both lines in order, got %+v", shipper.shipped)
+	}
+	if entry := shipper.shipped[1]; entry.JobID != "01ABC" || entry.RepoURI != "https://github.com/ocuroot/minici" || entry.Commit != "main" {
+		t.Errorf("Expected the job's labels, got %+v", entry)
+	}
+
+	// Lines beyond the limit are dropped, oldest first
+	shipper.fail = true
+	for i := 0; i < maxPendingLogs+5; i++ {
+		forwarder.HandleLog("01ABC", "line")
+	}
+	errs = nil
+	forwarder.flush()
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "dropped 5 log lines") {
+		t.Errorf("Expected dropped lines to be reported, got %v", errs)
+	}
+}
+
+func TestLokiShipper(t *testing.T) {
+	var push lokiPush
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" {
+			http.NotFound(w, r)
+			return
+		}
+		header = r.Header
+		json.NewDecoder(r.Body).Decode(&push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	shipper := &LokiShipper{URL: srv.URL + "/", Tenant: "ci", Token: "secret", Labels: map[string]string{"env": "prod"}}
+	now := time.Unix(1700000000, 5)
+	err := shipper.Ship(context.Background(), []LogEntry{
+		{Time: now, JobID: "01A", RepoURI: "repo", Line: "one"},
+		{Time: now, JobID: "01B", Line: "two"},
+		{Time: now, JobID: "01A", RepoURI: "repo", Line: "three"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("X-Scope-OrgID") != "ci" || header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Unexpected headers: %v", header)
+	}
+	if len(push.Streams) != 2 {
+		t.Fatalf("Expected a stream per job, got %+v", push.Streams)
+	}
+	first := push.Streams[0]
+	if first.Stream["job_id"] != "01A" || first.Stream["repo"] != "repo" || first.Stream["env"] != "prod" {
+		t.Errorf("Unexpected labels: %v", first.Stream)
+	}
+	if len(first.Values) != 2 || first.Values[0] != [2]string{"1700000000000000005", "one"} || first.Values[1][1] != "three" {
+		t.Errorf("Unexpected values: %v", first.Values)
+	}
+
+	shipper.URL = srv.URL + "/missing"
+	if err := shipper.Ship(context.Background(), []LogEntry{{JobID: "01A"}}); err == nil {
+		t.Error("Expected a failed push to return an error")
+	}
+}
+
+func TestCloudWatchShipper(t *testing.T) {
+	var mutex sync.Mutex
+	var actions []string
+	var events []cloudWatchEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-west-2/logs/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+		actions = append(actions, action)
+		var input struct {
+			LogGroupName  string
+			LogStreamName string
+			LogEvents     []cloudWatchEvent
+		}
+		json.NewDecoder(r.Body).Decode(&input)
+		if input.LogGroupName != "/minici/jobs" || input.LogStreamName != "01A" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, input.LogEvents...)
+		io.WriteString(w, "{}")
+	}))
+	defer srv.Close()
+
+	shipper := &CloudWatchShipper{Endpoint: srv.URL, Region: "us-west-2", LogGroup: "/minici/jobs", AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	now := time.UnixMilli(1700000000123)
+	for _, line := range []string{"one", "two"} {
+		if err := shipper.Ship(context.Background(), []LogEntry{{Time: now, JobID: "01A", RepoURI: "repo", Line: line}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The stream is only created once
+	if strings.Join(actions, ",") != "CreateLogStream,PutLogEvents,PutLogEvents" {
+		t.Errorf("Unexpected calls: %v", actions)
+	}
+	if len(events) != 2 || events[0].Timestamp != 1700000000123 || events[0].Message != `{"job_id":"01A","repo":"repo","line":"one"}` {
+		t.Errorf("Unexpected events: %+v", events)
+	}
+}
+
+func TestSyslogShipper(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	shipper := &SyslogShipper{Address: "tcp://" + listener.Addr().String(), Facility: 16}
+	entry := LogEntry{Time: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), JobID: "01A", RepoURI: `repo"]`, Line: "> hello"}
+	if err := shipper.Ship(context.Background(), []LogEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	message := <-received
+	expected := `<134>1 2025-01-02T03:04:05Z ` + hostname() + ` minici - - [minici@32473 job_id="01A" repo="repo\"\]"] > hello` + "\n"
+	// TCP messages are framed by their length
+	if framed := fmt.Sprintf("%d %s", len(expected), expected); message != framed {
+		t.Errorf("Unexpected message:\n%q\nexpected:\n%q", message, framed)
+	}
+}
+
+func TestLogExportConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.yml")
+	config := `shippers:
+  - type: loki
+    url: http://loki:3100
+  - type: cloudwatch
+    log_group: /minici/jobs
+  - type: syslog
+    address: udp://localhost:514
+    facility: local3
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadLogExportConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shippers, err := loaded.LogShippers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shippers) != 3 {
+		t.Fatalf("Expected 3 shippers, got %d", len(shippers))
+	}
+	if syslog := shippers[2].(*SyslogShipper); syslog.Facility != 19 {
+		t.Errorf("Expected the local3 facility, got %d", syslog.Facility)
+	}
+
+	for _, invalid := range []LogShipperConfig{
+		{Type: "loki"},
+		{Type: "cloudwatch"},
+		{Type: "syslog", Address: "udp://localhost:514", Facility: "nope"},
+		{Type: "splunk"},
+	} {
+		if _, err := (LogExportConfig{Shippers: []LogShipperConfig{invalid}}).LogShippers(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package minici
+
+import "time"
+
+// TimedLine is a line of a job's logs and when it was logged
+type TimedLine struct {
+	Line string
+	// Time is when the line was added to the logs, zero if it wasn't recorded
+	Time time.Time
+}
+
+// TimedLogReader is implemented by CIs that record when each line of a job's
+// logs was added
+type TimedLogReader interface {
+	// JobTimedLogsFrom returns a job's lines from index from on, along with
+	// when each was logged. Lines that weren't logged by this server, such as
+	// ones restored from a backup or mirrored from another server in a
+	// cluster, have zero times.
+	JobTimedLogsFrom(jobID JobID, from int) []TimedLine
+}
+
+// logTimes records when each line of a job's logs was added. Times are only
+// kept in memory, so they're lost on restart.
+type logTimes struct {
+	times map[JobID][]time.Time
+}
+
+// add records when the line at index of a job's logs was added. Earlier lines
+// that weren't recorded are given zero times.
+func (t *logTimes) add(jobID JobID, index int, at time.Time) {
+	if t.times == nil {
+		t.times = make(map[JobID][]time.Time)
+	}
+	times := t.times[jobID]
+	for len(times) < index {
+		times = append(times, time.Time{})
+	}
+	t.times[jobID] = append(times[:index], at)
+}
+
+// at returns when the line at index of a job's logs was added
+func (t *logTimes) at(jobID JobID, index int) time.Time {
+	times := t.times[jobID]
+	if index < 0 || index >= len(times) {
+		return time.Time{}
+	}
+	return times[index]
+}
+
+func (s *CIServer) JobTimedLogsFrom(jobID JobID, from int) []TimedLine {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+
+	from = max(from, 0)
+	lines := s.logs.lines(jobID, from)
+	timed := make([]TimedLine, len(lines))
+	for i, line := range lines {
+		timed[i] = TimedLine{Line: line, Time: s.logTimes.at(jobID, from+i)}
+	}
+	return timed
+}
@@ -0,0 +1,99 @@
+package minici
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// LokiShipper pushes job log lines to Grafana Loki, with a stream per job
+// labelled with its job_id and repo
+type LokiShipper struct {
+	// URL is Loki's base URL, such as http://loki:3100
+	URL string
+	// Tenant sets the X-Scope-OrgID header for multi-tenant installs
+	Tenant string
+	// Username and Password authenticate with basic auth, as Grafana Cloud
+	// expects, or Token as a bearer token
+	Username string
+	Password string
+	Token    string
+	// Labels are added to every stream, such as an environment name
+	Labels map[string]string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	// Values are pairs of a timestamp in nanoseconds and the line
+	Values [][2]string `json:"values"`
+}
+
+func (l *LokiShipper) Ship(ctx context.Context, entries []LogEntry) error {
+	var push lokiPush
+	streams := map[JobID]int{}
+	for _, entry := range entries {
+		i, ok := streams[entry.JobID]
+		if !ok {
+			labels := map[string]string{"job_id": string(entry.JobID)}
+			if entry.RepoURI != "" {
+				labels["repo"] = entry.RepoURI
+			}
+			for name, value := range l.Labels {
+				labels[name] = value
+			}
+			i = len(push.Streams)
+			streams[entry.JobID] = i
+			push.Streams = append(push.Streams, lokiStream{Stream: labels})
+		}
+		push.Streams[i].Values = append(push.Streams[i].Values, [2]string{
+			strconv.FormatInt(entry.Time.UnixNano(), 10),
+			entry.Line,
+		})
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(l.URL, "/")+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("loki: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.Tenant != "" {
+		req.Header.Set("X-Scope-OrgID", l.Tenant)
+	}
+	switch {
+	case l.Username != "":
+		req.SetBasicAuth(l.Username, l.Password)
+	case l.Token != "":
+		req.Header.Set("Authorization", "Bearer "+l.Token)
+	}
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("loki: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("loki: push failed with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
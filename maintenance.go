@@ -0,0 +1,196 @@
+package minici
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaintenanceWindow is a recurring period during which jobs queue but don't
+// start, such as while agents' hosts are patched. Running jobs carry on.
+type MaintenanceWindow struct {
+	Name string `yaml:"name"`
+	// Days are the days of the week the window starts on, such as "sunday" or
+	// "sun". It starts every day if empty.
+	Days []string `yaml:"days"`
+	// Start is the time of day it starts, as "15:04"
+	Start    string        `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// MaintenanceSchedule lists the maintenance windows that pause job starts.
+// Jobs submitted as Urgent start regardless.
+type MaintenanceSchedule struct {
+	// Timezone is the IANA name of the zone the windows' days and start times
+	// are in, defaults to UTC
+	Timezone string              `yaml:"timezone"`
+	Windows  []MaintenanceWindow `yaml:"windows"`
+
+	// location is Timezone loaded by withLocation, so checking for an active
+	// window doesn't read the zone database each time
+	location *time.Location
+}
+
+// ActiveMaintenance is a maintenance window in progress
+type ActiveMaintenance struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// MaintenanceScheduler is implemented by servers that can pause job starts
+// during maintenance windows
+type MaintenanceScheduler interface {
+	SetMaintenance(schedule MaintenanceSchedule)
+}
+
+// LoadMaintenanceSchedule reads maintenance windows from a YAML file
+func LoadMaintenanceSchedule(path string) (MaintenanceSchedule, error) {
+	var schedule MaintenanceSchedule
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return schedule, fmt.Errorf("failed to read maintenance schedule: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &schedule); err != nil {
+		return schedule, fmt.Errorf("failed to parse maintenance schedule %s: %w", path, err)
+	}
+	if err := schedule.Validate(); err != nil {
+		return schedule, fmt.Errorf("maintenance schedule %s: %w", path, err)
+	}
+	return schedule.withLocation(), nil
+}
+
+// Validate checks the timezone, days and start times can be understood
+func (s MaintenanceSchedule) Validate() error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", s.Timezone, err)
+	}
+	for i, window := range s.Windows {
+		if _, err := time.Parse("15:04", window.Start); err != nil {
+			return fmt.Errorf("windows[%d]: start must be a time of day such as 02:30, got %q", i, window.Start)
+		}
+		if window.Duration <= 0 || window.Duration > 7*24*time.Hour {
+			return fmt.Errorf("windows[%d]: duration must be positive and at most a week", i)
+		}
+		for _, day := range window.Days {
+			if _, ok := parseWeekday(day); !ok {
+				return fmt.Errorf("windows[%d]: unknown day %q", i, day)
+			}
+		}
+	}
+	return nil
+}
+
+// Active returns the window in progress at now, nil if there isn't one. Of
+// overlapping windows, the one that ends last is returned.
+func (s MaintenanceSchedule) Active(now time.Time) *ActiveMaintenance {
+	if len(s.Windows) == 0 {
+		return nil
+	}
+	location := s.withLocation().location
+	local := now.In(location)
+	var active *ActiveMaintenance
+	for _, window := range s.Windows {
+		start, err := time.Parse("15:04", window.Start)
+		if err != nil || window.Duration <= 0 {
+			continue
+		}
+		// Windows that started on earlier days may still be in progress
+		for daysAgo := 0; daysAgo <= int(window.Duration/(24*time.Hour))+1; daysAgo++ {
+			begin := time.Date(local.Year(), local.Month(), local.Day()-daysAgo, start.Hour(), start.Minute(), 0, 0, location)
+			end := begin.Add(window.Duration)
+			if !window.startsOn(begin.Weekday()) || now.Before(begin) || !now.Before(end) {
+				continue
+			}
+			if active == nil || end.After(active.End) {
+				active = &ActiveMaintenance{Name: window.Name, Start: begin, End: end}
+			}
+		}
+	}
+	return active
+}
+
+// withLocation returns the schedule with its timezone loaded, or UTC if it
+// can't be
+func (s MaintenanceSchedule) withLocation() MaintenanceSchedule {
+	if s.location != nil {
+		return s
+	}
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	s.location = location
+	return s
+}
+
+func (w MaintenanceWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if parsed, ok := parseWeekday(name); ok && parsed == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWeekday reads a day's full or three letter name, in any case
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) || strings.EqualFold(name, day.String()[:3]) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+var _ MaintenanceScheduler = &CIServer{}
+
+// WithMaintenance pauses job starts during maintenance windows. See
+// SetMaintenance.
+func WithMaintenance(schedule MaintenanceSchedule) Option {
+	return func(s *CIServer) {
+		s.maintenance = schedule.withLocation()
+	}
+}
+
+// SetMaintenance replaces the maintenance windows, starting any jobs a
+// removed window was holding back. During a window, jobs wait in the queue
+// unless they're urgent, while jobs already running carry on.
+func (s *CIServer) SetMaintenance(schedule MaintenanceSchedule) {
+	schedule = schedule.withLocation()
+	s.jobMutex.Lock()
+	s.maintenance = schedule
+	s.jobMutex.Unlock()
+	s.dispatch()
+}
+
+// resumeAfter dispatches jobs again once a maintenance window that's holding
+// them back ends, as nothing else would start them on the local agent. Remote
+// agents find them when they next ask for a job. The caller must hold
+// jobMutex.
+func (s *CIServer) resumeAfter(active *ActiveMaintenance) {
+	if active == nil || s.local == nil || len(s.waiting) == 0 {
+		return
+	}
+	// A wake up is already due by the time this window ends
+	if !s.resumeAt.IsZero() && !active.End.Before(s.resumeAt) {
+		return
+	}
+	end := active.End
+	s.resumeAt = end
+	go func() {
+		<-s.clock.After(end.Sub(s.clock.Now()))
+		s.jobMutex.Lock()
+		if s.resumeAt.Equal(end) {
+			s.resumeAt = time.Time{}
+		}
+		s.jobMutex.Unlock()
+		s.dispatch()
+	}()
+}
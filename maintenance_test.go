@@ -0,0 +1,127 @@
+package minici_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/citest"
+)
+
+func TestMaintenanceScheduleActive(t *testing.T) {
+	schedule := minici.MaintenanceSchedule{
+		Timezone: "Europe/London",
+		Windows: []minici.MaintenanceWindow{
+			{Name: "patching", Days: []string{"sun"}, Start: "23:00", Duration: 3 * time.Hour},
+			{Name: "nightly", Start: "04:00", Duration: 30 * time.Minute},
+		},
+	}
+	utc := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// London is an hour ahead of UTC in June, and the 2nd is a Sunday
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected string
+		end      time.Time
+	}{
+		{name: "before the window", now: utc(2, 21, 30)},
+		{name: "during the window", now: utc(2, 22, 30), expected: "patching", end: utc(3, 1, 0)},
+		{name: "after midnight", now: utc(3, 0, 59), expected: "patching", end: utc(3, 1, 0)},
+		{name: "as the window ends", now: utc(3, 1, 0)},
+		{name: "every day", now: utc(3, 3, 15), expected: "nightly", end: utc(3, 3, 30)},
+		{name: "on another day", now: utc(3, 22, 30)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			active := schedule.Active(test.now)
+			if test.expected == "" {
+				if active != nil {
+					t.Errorf("Expected no window at %v, got %+v", test.now, active)
+				}
+				return
+			}
+			if active == nil || active.Name != test.expected || !active.End.Equal(test.end) {
+				t.Errorf("Expected %s until %v, got %+v", test.expected, test.end, active)
+			}
+		})
+	}
+}
+
+func TestLoadMaintenanceSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance.yml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("timezone: Europe/London\nwindows:\n  - name: patching\n    days: [Saturday, sun]\n    start: \"02:00\"\n    duration: 2h\n")
+	schedule, err := minici.LoadMaintenanceSchedule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule.Windows) != 1 || schedule.Windows[0].Duration != 2*time.Hour || len(schedule.Windows[0].Days) != 2 {
+		t.Errorf("Unexpected schedule %+v", schedule)
+	}
+
+	for name, content := range map[string]string{
+		"timezone": "timezone: Mars/Olympus\n",
+		"day":      "windows:\n  - days: [someday]\n    start: \"02:00\"\n    duration: 1h\n",
+		"start":    "windows:\n  - start: 2am\n    duration: 1h\n",
+		"duration": "windows:\n  - start: \"02:00\"\n",
+	} {
+		write(content)
+		if _, err := minici.LoadMaintenanceSchedule(path); err == nil {
+			t.Errorf("Expected an invalid %s to return an error", name)
+		}
+	}
+}
+
+func TestMaintenancePausesJobs(t *testing.T) {
+	clock := citest.NewClock(citest.Start)
+	ci := minici.NewCIServer(minici.WithLocalAgent(false), minici.WithClock(clock), minici.WithMaintenance(minici.MaintenanceSchedule{
+		Windows: []minici.MaintenanceWindow{{Name: "patching", Start: "23:30", Duration: time.Hour}},
+	}))
+	pool := ci.(minici.AgentPool)
+	agent := minici.AgentInfo{Name: "box", Capacity: 2}
+
+	held, err := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	urgent, err := ci.Submit(minici.JobSpec{RepoURI: "repo", Commit: "main", Command: "hotfix", Urgent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only urgent jobs start until the window ends at 00:30
+	if job, err := pool.ClaimJob(agent); err != nil || job.ID != urgent {
+		t.Errorf("Expected the urgent job to start during maintenance, got %+v, %v", job, err)
+	}
+	if _, err := pool.ClaimJob(agent); !errors.Is(err, minici.ErrNoJobAvailable) {
+		t.Errorf("Expected other jobs to wait for maintenance to end, got %v", err)
+	}
+	stats := ci.QueueStats()
+	if stats.Maintenance == nil || stats.Maintenance.Name != "patching" || !stats.Maintenance.End.Equal(citest.Start.Add(30*time.Minute)) {
+		t.Errorf("Expected the queue to report maintenance, got %+v", stats.Maintenance)
+	}
+	if len(stats.Queue) != 1 || stats.Queue[0].EstimatedWait < 30*time.Minute {
+		t.Errorf("Expected the held job to wait for maintenance to end, got %+v", stats.Queue)
+	}
+
+	clock.Advance(30 * time.Minute)
+	if job, err := pool.ClaimJob(agent); err != nil || job.ID != held {
+		t.Errorf("Expected the held job to start once maintenance ended, got %+v, %v", job, err)
+	}
+	if ci.QueueStats().Maintenance != nil {
+		t.Error("Expected maintenance to have ended")
+	}
+}
@@ -0,0 +1,125 @@
+package minici
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// MigrationStats counts what MigrateClusterStore copied
+type MigrationStats struct {
+	Jobs   int
+	Lines  int
+	Claims int
+}
+
+// MigrateClusterStore copies every job, with its logs and claims, from one
+// cluster store to another, then reads them back from the destination to check
+// they match. A migration that failed part way can be run again: jobs are
+// overwritten and only the log lines the destination is missing are copied.
+// Servers shouldn't use either store while it runs, and heartbeats aren't
+// copied, since servers send new ones once they're restarted.
+func MigrateClusterStore(ctx context.Context, from, to ClusterStore) (MigrationStats, error) {
+	var stats MigrationStats
+	jobs, err := from.Jobs(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	logs := make(map[JobID][]string, len(jobs))
+	for _, job := range jobs {
+		lines, err := from.Logs(ctx, job.ID, 0)
+		if err != nil {
+			return stats, fmt.Errorf("failed to read logs of job %s: %w", job.ID, err)
+		}
+		logs[job.ID] = lines
+		copied, err := migrateLogs(ctx, to, job.ID, lines)
+		if err != nil {
+			return stats, err
+		}
+		stats.Lines += copied
+
+		for _, key := range []string{runClaim(job.ID), interruptClaim(job.ID)} {
+			owner, err := from.Claimant(ctx, key)
+			if err != nil {
+				return stats, fmt.Errorf("failed to read claim %s: %w", key, err)
+			}
+			if owner == "" {
+				continue
+			}
+			holder, err := to.Claim(ctx, key, owner)
+			if err != nil {
+				return stats, fmt.Errorf("failed to copy claim %s: %w", key, err)
+			}
+			if holder != owner {
+				return stats, fmt.Errorf("claim %s is held by %s in the destination, not %s", key, holder, owner)
+			}
+			stats.Claims++
+		}
+
+		if err := to.SaveJob(ctx, job); err != nil {
+			return stats, fmt.Errorf("failed to save job %s: %w", job.ID, err)
+		}
+		stats.Jobs++
+	}
+
+	return stats, verifyMigration(ctx, to, jobs, logs)
+}
+
+// migrateLogs appends the lines the destination doesn't have yet, returning
+// how many were copied
+func migrateLogs(ctx context.Context, to ClusterStore, id JobID, lines []string) (int, error) {
+	existing, err := to.Logs(ctx, id, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read logs of job %s from the destination: %w", id, err)
+	}
+	if len(existing) > len(lines) || !slices.Equal(existing, lines[:len(existing)]) {
+		return 0, fmt.Errorf("job %s already has different logs in the destination", id)
+	}
+	missing := lines[len(existing):]
+	if err := to.AppendLogs(ctx, id, missing); err != nil {
+		return 0, fmt.Errorf("failed to copy logs of job %s: %w", id, err)
+	}
+	return len(missing), nil
+}
+
+// verifyMigration checks the destination has every job and log line read
+// from the source
+func verifyMigration(ctx context.Context, to ClusterStore, jobs []Job, logs map[JobID][]string) error {
+	saved, err := to.Jobs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read jobs from the destination: %w", err)
+	}
+	byID := make(map[JobID]Job, len(saved))
+	for _, job := range saved {
+		byID[job.ID] = job
+	}
+	for _, job := range jobs {
+		copied, ok := byID[job.ID]
+		if !ok {
+			return fmt.Errorf("job %s is missing from the destination", job.ID)
+		}
+		// Compare the encoded jobs, as stores may drop details such as the
+		// monotonic clock readings in times
+		want, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		got, err := json.Marshal(copied)
+		if err != nil {
+			return err
+		}
+		if string(want) != string(got) {
+			return fmt.Errorf("job %s differs in the destination", job.ID)
+		}
+
+		lines, err := to.Logs(ctx, job.ID, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read logs of job %s from the destination: %w", job.ID, err)
+		}
+		if !slices.Equal(lines, logs[job.ID]) {
+			return fmt.Errorf("logs of job %s differ in the destination, %d lines instead of %d", job.ID, len(lines), len(logs[job.ID]))
+		}
+	}
+	return nil
+}
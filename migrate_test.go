@@ -0,0 +1,108 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateClusterStore(t *testing.T) {
+	ctx := context.Background()
+	from, to := newMemoryClusterStore(), newMemoryClusterStore()
+	done, running := NewJobID(), NewJobID()
+	from.jobs[done] = Job{ID: done, Status: JobStatusSuccess, Number: 1, Command: "make", FinishedAt: time.Now()}
+	from.jobs[running] = Job{ID: running, Status: JobStatusRunning, Number: 2, Command: "make test"}
+	from.logs[done] = []string{"one", "two", "three"}
+	from.claims[runClaim(done)] = "a"
+	from.claims[runClaim(running)] = "b"
+	from.alive["b"] = true
+	// A previous attempt copied some of the logs
+	to.logs[done] = []string{"one"}
+
+	stats, err := MigrateClusterStore(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (MigrationStats{Jobs: 2, Lines: 2, Claims: 2}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if !reflect.DeepEqual(to.logs[done], from.logs[done]) {
+		t.Errorf("Expected the missing lines to be copied, got %q", to.logs[done])
+	}
+	if to.claims[runClaim(running)] != "b" || len(to.alive) != 0 {
+		t.Errorf("Expected claims but not heartbeats to be copied, got %v and %v", to.claims, to.alive)
+	}
+
+	// Running it again copies nothing new
+	if stats, err := MigrateClusterStore(ctx, from, to); err != nil || stats.Lines != 0 {
+		t.Errorf("Expected nothing more to copy, got %+v, %v", stats, err)
+	}
+
+	to.logs[done] = []string{"other"}
+	if _, err := MigrateClusterStore(ctx, from, to); err == nil || !strings.Contains(err.Error(), "different logs") {
+		t.Errorf("Expected diverging logs to be refused, got %v", err)
+	}
+}
+
+func TestMigrateRedisClusterStore(t *testing.T) {
+	ctx := context.Background()
+	addr := startFakeRedis(t, "")
+	keyring, _ := NewKeyring(testKey(1))
+	from := &RedisClusterStore{Client: &RedisClient{Addr: addr}, Prefix: "old:"}
+	to := &RedisClusterStore{Client: &RedisClient{Addr: addr}, Prefix: "new:", Keyring: keyring}
+
+	done, running := NewJobID(), NewJobID()
+	for _, job := range []Job{
+		{ID: done, Status: JobStatusSuccess, Number: 1, Command: "make", Env: map[string]string{"TOKEN": "hunter2"}},
+		{ID: running, Status: JobStatusRunning, Number: 2, Command: "make test"},
+	} {
+		if err := from.SaveJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := from.AppendLogs(ctx, done, []string{"one", "token=hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	for key, instance := range map[string]string{runClaim(running): "b", interruptClaim(done): "a"} {
+		if _, err := from.Claim(ctx, key, instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := from.Heartbeat(ctx, "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := MigrateClusterStore(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (MigrationStats{Jobs: 2, Lines: 2, Claims: 2}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	jobs, err := to.Jobs(ctx)
+	if err != nil || len(jobs) != 2 || jobs[0].ID != done || jobs[0].Env["TOKEN"] != "hunter2" || jobs[1].Status != JobStatusRunning {
+		t.Errorf("Expected both jobs to be copied, got %+v, %v", jobs, err)
+	}
+	if lines, err := to.Logs(ctx, done, 0); err != nil || !reflect.DeepEqual(lines, []string{"one", "token=hunter2"}) {
+		t.Errorf("Expected the logs to be copied, got %q, %v", lines, err)
+	}
+	for key, instance := range map[string]string{runClaim(running): "b", interruptClaim(done): "a", runClaim(done): ""} {
+		if owner, err := to.Claimant(ctx, key); err != nil || owner != instance {
+			t.Errorf("Expected %s to be held by %q, got %q, %v", key, instance, owner, err)
+		}
+	}
+	if alive, err := to.Alive(ctx, "b"); err != nil || alive {
+		t.Errorf("Expected heartbeats not to be copied, got %v, %v", alive, err)
+	}
+
+	// The copy is encrypted with the destination's keys
+	stored, _ := to.Client.do(ctx, "HGETALL", "new:jobs")
+	logged, _ := to.Client.do(ctx, "LRANGE", "new:logs:"+string(done), "0", "-1")
+	if strings.Contains(fmt.Sprint(stored, logged), "hunter2") {
+		t.Errorf("Expected the copy to be encrypted, got %v and %v", stored, logged)
+	}
+}
@@ -0,0 +1,103 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+	"path"
+)
+
+// JobNeed is a job that must succeed before another job can start
+type JobNeed struct {
+	Job JobID
+	// Artifacts are patterns for the needed job's artifacts to restore into
+	// the workspace before the setup commands run, using path.Match syntax.
+	// Matching a directory restores everything inside it.
+	Artifacts []string
+}
+
+// validateNeeds checks that the jobs a spec needs exist and that their
+// artifacts can be restored. The caller must hold jobMutex.
+func (s *CIServer) validateNeeds(spec JobSpec) error {
+	for _, need := range spec.Needs {
+		if _, ok := s.jobs[need.Job]; !ok {
+			return fmt.Errorf("needed job %s: %w", need.Job, ErrJobNotFound)
+		}
+		if len(need.Artifacts) == 0 {
+			continue
+		}
+		// Remote agents have no artifact store, so only the server can restore artifacts
+		if s.artifacts == nil || s.local == nil {
+			return errors.New("restoring artifacts from needed jobs requires an artifact store and the local agent")
+		}
+		for _, pattern := range need.Artifacts {
+			if _, err := path.Match(pattern, ""); err != nil || validArtifactName(pattern) != nil {
+				return fmt.Errorf("invalid artifact pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveNeed returns the job that satisfies a need, following any requeues
+// of interrupted jobs. The caller must hold jobMutex.
+func (s *CIServer) resolveNeed(id JobID) (*Job, bool) {
+	job, ok := s.jobs[id]
+	for ok && job.Status == JobStatusInterrupted && s.requeueInterrupted {
+		var requeued *Job
+		for _, other := range s.jobs {
+			if other.RequeuedFrom == job.ID {
+				requeued = other
+				break
+			}
+		}
+		if requeued == nil {
+			// The replacement is about to be scheduled
+			return &Job{ID: id, Status: JobStatusPending}, true
+		}
+		job = requeued
+	}
+	return job, ok
+}
+
+// blockedBy returns whether a job is still waiting for the jobs it needs, and
+// the first needed job that didn't succeed, if any. The caller must hold jobMutex.
+func (s *CIServer) blockedBy(job *Job) (bool, *Job) {
+	blocked := false
+	for _, need := range job.Needs {
+		needed, ok := s.resolveNeed(need.Job)
+		if !ok {
+			return true, &Job{ID: need.Job, Status: JobStatusFailure}
+		}
+		if needed.Status == JobStatusSuccess {
+			continue
+		}
+		if needed.Status.Done() {
+			return true, needed
+		}
+		blocked = true
+	}
+	return blocked, nil
+}
+
+// resolvedNeeds returns a job's needs with requeued jobs replaced by the jobs
+// that ran instead. The caller must hold jobMutex.
+func (s *CIServer) resolvedNeeds(needs []JobNeed) []JobNeed {
+	resolved := make([]JobNeed, len(needs))
+	for i, need := range needs {
+		resolved[i] = need
+		if job, ok := s.resolveNeed(need.Job); ok {
+			resolved[i].Job = job.ID
+		}
+	}
+	return resolved
+}
+
+// needsArtifacts returns whether a job restores artifacts from the jobs it needs
+func needsArtifacts(job *Job) bool {
+	for _, need := range job.Needs {
+		if len(need.Artifacts) > 0 {
+			return true
+		}
+	}
+	return false
+}
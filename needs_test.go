@@ -0,0 +1,130 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobNeeds(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false))
+	pool := ci.(AgentPool)
+	agent := AgentInfo{Name: "box", Capacity: 2}
+
+	build, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "test", Needs: []JobNeed{{Job: build}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "deploy", Needs: []JobNeed{{Job: build}, {Job: test}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Jobs wait for the jobs they need, even with free capacity
+	job, err := pool.ClaimJob(agent)
+	if err != nil || job.ID != build {
+		t.Fatalf("Expected the build job to be claimed, got %+v, %v", job, err)
+	}
+	if _, err := pool.ClaimJob(agent); !errors.Is(err, ErrNoJobAvailable) {
+		t.Errorf("Expected blocked jobs not to be claimed, got %v", err)
+	}
+	if err := pool.FinishJob("box", build, JobResult{Status: JobStatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	job, err = pool.ClaimJob(agent)
+	if err != nil || job.ID != test || len(job.Needs) != 1 {
+		t.Fatalf("Expected the test job to be claimed once build succeeded, got %+v, %v", job, err)
+	}
+
+	// A failed need cancels the jobs that need it
+	if err := pool.FinishJob("box", test, JobResult{Status: JobStatusFailure}); err != nil {
+		t.Fatal(err)
+	}
+	detail := ci.JobDetail(deploy)
+	if detail.Status != JobStatusCancelled || !strings.Contains(strings.Join(ci.JobLogs(deploy), "\n"), "Needed job "+string(test)+" finished with status failure") {
+		t.Errorf("Expected the deploy job to be cancelled, got %+v", detail)
+	}
+	if queue := ci.QueueStats().Queue; len(queue) != 0 {
+		t.Errorf("Expected no waiting jobs, got %+v", queue)
+	}
+
+	if _, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "test", Needs: []JobNeed{{Job: "missing"}}}); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected an unknown need to be rejected, got %v", err)
+	}
+	if _, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "test", Needs: []JobNeed{{Job: build, Artifacts: []string{"dist"}}}}); err == nil {
+		t.Error("Expected needing artifacts without an artifact store to be rejected")
+	}
+}
+
+func TestCancelNeededJob(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false))
+	build, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "test", Needs: []JobNeed{{Job: build}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.CancelJob(build); err != nil {
+		t.Fatal(err)
+	}
+	if status := ci.JobDetail(test).Status; status != JobStatusCancelled {
+		t.Errorf("Expected the dependent job to be cancelled, got %s", status)
+	}
+}
+
+func TestRestoreArtifacts(t *testing.T) {
+	ctx := context.Background()
+	store := &FileArtifactStore{Dir: t.TempDir()}
+	for name, content := range map[string]string{"dist/app": "binary", "dist/lib/a.so": "library", "coverage.out": "coverage"} {
+		if err := store.Save(ctx, "01BUILD", name, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lines []string
+	log := func(line string) {
+		lines = append(lines, line)
+	}
+	dir := t.TempDir()
+	if err := restoreArtifacts(ctx, store, dir, []JobNeed{{Job: "01OTHER"}, {Job: "01BUILD", Artifacts: []string{"dist"}}}, log); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"dist/app": "binary", "dist/lib/a.so": "library"} {
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(content) != expected {
+			t.Errorf("Expected %s to be restored, got %q, %v", name, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "coverage.out")); err == nil {
+		t.Error("Expected unmatched artifacts not to be restored")
+	}
+	if len(lines) != 2 || lines[0] != "Restored artifact dist/app from job 01BUILD" {
+		t.Errorf("Unexpected logs %v", lines)
+	}
+
+	if err := restoreArtifacts(ctx, store, dir, []JobNeed{{Job: "01BUILD", Artifacts: []string{"*.zip"}}}, log); err == nil {
+		t.Error("Expected a pattern matching nothing to return an error")
+	}
+
+	// A symlink in the repository can't redirect artifacts outside the workspace
+	outside := t.TempDir()
+	linked := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(linked, "dist")); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreArtifacts(ctx, store, linked, []JobNeed{{Job: "01BUILD", Artifacts: []string{"dist/app"}}}, log); err == nil {
+		t.Error("Expected restoring through a symlink to return an error")
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing to be written outside the workspace, found %v", entries)
+	}
+}
@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ocuroot/minici"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the file format for configuring notifications
+type Config struct {
+	// BaseURL is the externally reachable URL of the minici server, used for links
+	BaseURL   string           `yaml:"base_url"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	Rules     []RuleConfig     `yaml:"rules"`
+}
+
+// NotifierConfig configures a single notification target
+type NotifierConfig struct {
+	Name string `yaml:"name"`
+	// Type selects the backend: "slack", "discord", "matrix" or "webhook"
+	Type string `yaml:"type"`
+
+	// WebhookURL is used by the slack and discord backends
+	WebhookURL string `yaml:"webhook_url"`
+	Channel    string `yaml:"channel"`
+	Username   string `yaml:"username"`
+
+	// Homeserver, Room and AccessToken are used by the matrix backend
+	Homeserver  string `yaml:"homeserver"`
+	Room        string `yaml:"room"`
+	AccessToken string `yaml:"access_token"`
+
+	// URL and Secret are used by the generic webhook backend
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+
+	Repos       []string `yaml:"repos"`
+	Projects    []string `yaml:"projects"`
+	Statuses    []string `yaml:"statuses"`
+	Transitions []string `yaml:"transitions"`
+
+	// Template optionally customizes the message text, see ParseTemplate
+	Template string `yaml:"template"`
+}
+
+// RuleConfig configures a routing rule, see Rule
+type RuleConfig struct {
+	Name     string            `yaml:"name"`
+	Repos    []string          `yaml:"repos"`
+	Projects []string          `yaml:"projects"`
+	Branches []string          `yaml:"branches"`
+	Statuses []string          `yaml:"statuses"`
+	Labels   map[string]string `yaml:"labels"`
+	// Transitions limits the rule to completed jobs with these transitions:
+	// "passed", "fixed", "failed" or "still_failing"
+	Transitions []string `yaml:"transitions"`
+
+	// Notifiers names the notifiers to fire. An empty list silences matching jobs.
+	Notifiers []string `yaml:"notifiers"`
+	Template  string   `yaml:"template"`
+}
+
+// LoadConfig reads a notification config from a YAML file
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read notification config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("failed to parse notification config: %w", err)
+	}
+	return config, nil
+}
+
+// Targets builds the notification targets described by the config
+func (c Config) Targets() ([]Target, error) {
+	var targets []Target
+	for i, nc := range c.Notifiers {
+		name := nc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", nc.Type, i)
+		}
+
+		notifier, err := nc.notifier()
+		if err != nil {
+			return nil, fmt.Errorf("notifier %q: %w", name, err)
+		}
+
+		target := Target{
+			Name:     name,
+			Notifier: notifier,
+			Repos:    nc.Repos,
+			Projects: nc.Projects,
+			// Generic webhooks are told about every transition, not just completion
+			AllTransitions: nc.Type == "webhook",
+		}
+		for _, status := range nc.Statuses {
+			s, err := ParseStatus(status, target.AllTransitions)
+			if err != nil {
+				return nil, fmt.Errorf("notifier %q: %w", name, err)
+			}
+			target.Statuses = append(target.Statuses, s)
+		}
+		target.Transitions, err = parseTransitions(nc.Transitions)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %q: %w", name, err)
+		}
+		if nc.Template != "" {
+			target.Template, err = ParseTemplate(name, nc.Template)
+			if err != nil {
+				return nil, fmt.Errorf("notifier %q: %w", name, err)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// RoutingRules builds the routing rules described by the config, checking that every
+// notifier they reference exists.
+func (c Config) RoutingRules() ([]Rule, error) {
+	targets, err := c.Targets()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, target := range targets {
+		known[target.Name] = true
+	}
+
+	var rules []Rule
+	for i, rc := range c.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i)
+		}
+
+		rule := Rule{
+			Name:     name,
+			Repos:    rc.Repos,
+			Projects: rc.Projects,
+			Branches: rc.Branches,
+			Labels:   rc.Labels,
+			Targets:  rc.Notifiers,
+		}
+		for _, status := range rc.Statuses {
+			s, err := ParseStatus(status, false)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", name, err)
+			}
+			rule.Statuses = append(rule.Statuses, s)
+		}
+		rule.Transitions, err = parseTransitions(rc.Transitions)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+		for _, notifier := range rc.Notifiers {
+			if !known[notifier] {
+				return nil, fmt.Errorf("rule %q: unknown notifier %q", name, notifier)
+			}
+		}
+		if rc.Template != "" {
+			rule.Template, err = ParseTemplate(name, rc.Template)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", name, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseStatus validates a status name for use in a target's status filter.
+// Only final statuses are allowed unless allTransitions is set.
+func ParseStatus(status string, allTransitions bool) (minici.JobStatus, error) {
+	s := minici.JobStatus(status)
+	switch {
+	case s.Done():
+		return s, nil
+	case allTransitions && (s == minici.JobStatusPending || s == minici.JobStatusRunning):
+		return s, nil
+	default:
+		return "", fmt.Errorf("unsupported status %q", status)
+	}
+}
+
+func parseTransitions(names []string) ([]Transition, error) {
+	var transitions []Transition
+	for _, name := range names {
+		t, ok := ParseTransition(name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported transition %q", name)
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, nil
+}
+
+func (nc NotifierConfig) notifier() (Notifier, error) {
+	switch nc.Type {
+	case "slack":
+		if nc.WebhookURL == "" {
+			return nil, fmt.Errorf("webhook_url is required")
+		}
+		return &Slack{
+			WebhookURL: nc.WebhookURL,
+			Channel:    nc.Channel,
+			Username:   nc.Username,
+		}, nil
+	case "discord":
+		if nc.WebhookURL == "" {
+			return nil, fmt.Errorf("webhook_url is required")
+		}
+		return &Discord{
+			WebhookURL: nc.WebhookURL,
+			Username:   nc.Username,
+		}, nil
+	case "matrix":
+		if nc.Homeserver == "" || nc.Room == "" || nc.AccessToken == "" {
+			return nil, fmt.Errorf("homeserver, room and access_token are required")
+		}
+		return &Matrix{
+			Homeserver:  nc.Homeserver,
+			RoomID:      nc.Room,
+			AccessToken: nc.AccessToken,
+		}, nil
+	case "webhook":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &Webhook{
+			URL:    nc.URL,
+			Secret: nc.Secret,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", nc.Type)
+	}
+}
@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+)
+
+// Discord posts notifications to a Discord channel webhook
+type Discord struct {
+	WebhookURL string
+
+	// Username optionally overrides the webhook's default name
+	Username string
+}
+
+type discordMessage struct {
+	Content  string         `json:"content"`
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title  string         `json:"title"`
+	URL    string         `json:"url,omitempty"`
+	Color  int            `json:"color"`
+	Fields []discordField `json:"fields"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Request builds the webhook call for a notification
+func (d *Discord) Request(n Notification) (delivery.Request, error) {
+	if d.WebhookURL == "" {
+		return delivery.Request{}, fmt.Errorf("discord webhook URL is required")
+	}
+
+	// Discord embed colors are RGB integers
+	color := 0x2eb886
+	if n.Job.Status != minici.JobStatusSuccess {
+		color = 0xd40e0d
+	}
+
+	content := fmt.Sprintf("%s: %s", n.Title(), n.Job.Command)
+	if n.Message != "" {
+		content = n.Message
+	}
+
+	body, err := json.Marshal(discordMessage{
+		Content:  content,
+		Username: d.Username,
+		Embeds: []discordEmbed{
+			{
+				Title: string(n.Job.ID),
+				URL:   n.LogsURL,
+				Color: color,
+				Fields: []discordField{
+					{Name: "Repo", Value: n.Job.RepoURI},
+					{Name: "Commit", Value: n.Job.Commit, Inline: true},
+					{Name: "Duration", Value: n.Duration().String(), Inline: true},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return delivery.Request{}, err
+	}
+
+	return delivery.Request{
+		Method:  http.MethodPost,
+		URL:     d.WebhookURL,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ocuroot/minici"
+)
+
+// Transition describes a job's outcome relative to the previous job for the same repo and ref
+type Transition string
+
+const (
+	// TransitionPassed is a success following a success, or the first recorded success
+	TransitionPassed Transition = "passed"
+	// TransitionFixed is a success following a failure
+	TransitionFixed Transition = "fixed"
+	// TransitionFailed is a failure following a success, or the first recorded failure
+	TransitionFailed Transition = "failed"
+	// TransitionStillFailing is a failure following a failure
+	TransitionStillFailing Transition = "still_failing"
+)
+
+// ParseTransition validates a transition name
+func ParseTransition(transition string) (Transition, bool) {
+	switch t := Transition(transition); t {
+	case TransitionPassed, TransitionFixed, TransitionFailed, TransitionStillFailing:
+		return t, true
+	default:
+		return "", false
+	}
+}
+
+// historyRef names the jobs a job's outcome follows on from: those for its
+// pull request, or else its branch, so jobs for different commits on a branch
+// are compared
+func historyRef(job minici.Job) string {
+	if job.PullRequest != nil {
+		return fmt.Sprintf("pull/%d", job.PullRequest.Number)
+	}
+	return jobBranch(job)
+}
+
+// outcomeHistory remembers the last outcome for each repo and ref
+type outcomeHistory struct {
+	mutex sync.Mutex
+	last  map[string]minici.JobStatus
+}
+
+func newOutcomeHistory() *outcomeHistory {
+	return &outcomeHistory{
+		last: make(map[string]minici.JobStatus),
+	}
+}
+
+// record stores a completed job's outcome and returns how it compares to the previous one.
+// Cancelled jobs don't have an outcome and return an empty transition.
+func (h *outcomeHistory) record(job minici.Job) Transition {
+	if job.Status != minici.JobStatusSuccess && job.Status != minici.JobStatusFailure {
+		return ""
+	}
+	key := job.RepoURI + "@" + historyRef(job)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	previous, seen := h.last[key]
+	h.last[key] = job.Status
+
+	if job.Status == minici.JobStatusSuccess {
+		if seen && previous != minici.JobStatusSuccess {
+			return TransitionFixed
+		}
+		return TransitionPassed
+	}
+	if seen && previous != minici.JobStatusSuccess {
+		return TransitionStillFailing
+	}
+	return TransitionFailed
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ocuroot/minici/delivery"
+)
+
+// Matrix sends notifications as messages to a Matrix room
+type Matrix struct {
+	// Homeserver is the base URL of the homeserver, e.g. https://matrix.org
+	Homeserver string
+	// RoomID is the internal room ID, e.g. !abcdefg:matrix.org
+	RoomID string
+	// AccessToken authenticates as the user sending messages, who must have joined the room
+	AccessToken string
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// Request builds the room message call for a notification
+func (m *Matrix) Request(n Notification) (delivery.Request, error) {
+	if m.Homeserver == "" || m.RoomID == "" || m.AccessToken == "" {
+		return delivery.Request{}, fmt.Errorf("matrix homeserver, room ID and access token are required")
+	}
+
+	plain := fmt.Sprintf("%s: %s\nRepo: %s\nCommit: %s\nDuration: %s",
+		n.Title(), n.Job.Command, n.Job.RepoURI, n.Job.Commit, n.Duration())
+	title := html.EscapeString(n.Title())
+	if n.LogsURL != "" {
+		plain += "\nLogs: " + n.LogsURL
+		title = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(n.LogsURL), title)
+	}
+	formatted := fmt.Sprintf("<b>%s</b>: <code>%s</code><br>Repo: %s<br>Commit: <code>%s</code><br>Duration: %s",
+		title,
+		html.EscapeString(n.Job.Command),
+		html.EscapeString(n.Job.RepoURI),
+		html.EscapeString(n.Job.Commit),
+		n.Duration(),
+	)
+
+	message := matrixMessage{
+		MsgType:       "m.notice",
+		Body:          plain,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	}
+	if n.Message != "" {
+		message = matrixMessage{
+			MsgType: "m.notice",
+			Body:    n.Message,
+		}
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return delivery.Request{}, err
+	}
+
+	// The transaction ID makes retries idempotent, so a message is only posted
+	// once even if an earlier attempt succeeded but the response was lost.
+	txnID := fmt.Sprintf("minici-%s-%s", n.Job.ID, n.Job.Status)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(m.Homeserver, "/"),
+		url.PathEscape(m.RoomID),
+		url.PathEscape(txnID),
+	)
+
+	return delivery.Request{
+		Method: http.MethodPut,
+		URL:    endpoint,
+		Headers: map[string]string{
+			"Content-Type":  "application/json",
+			"Authorization": "Bearer " + m.AccessToken,
+		},
+		Body: body,
+	}, nil
+}
@@ -0,0 +1,271 @@
+// Package notify sends messages to chat services and other endpoints when
+// minici jobs complete.
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+)
+
+// Notification describes a job that a notifier should report on
+type Notification struct {
+	Job            minici.Job
+	PreviousStatus minici.JobStatus
+
+	// LogsURL links to the job's logs, if the server's base URL is known
+	LogsURL string
+
+	// Transition compares a completed job to the previous one for the same repo
+	// and ref. It is empty for jobs that have not completed.
+	Transition Transition
+
+	// Message is custom text rendered from a template. If empty, notifiers
+	// use their default message format.
+	Message string
+}
+
+// Duration returns the job's run time rounded for display
+func (n Notification) Duration() time.Duration {
+	return n.Job.Duration().Round(time.Second)
+}
+
+// Title returns a short human readable summary of the notification
+func (n Notification) Title() string {
+	switch n.Transition {
+	case TransitionFixed:
+		return "Job fixed"
+	case TransitionStillFailing:
+		return "Job still failing"
+	}
+
+	switch n.Job.Status {
+	case minici.JobStatusSuccess:
+		return "Job succeeded"
+	case minici.JobStatusFailure:
+		return "Job failed"
+	default:
+		return fmt.Sprintf("Job %s", n.Job.Status)
+	}
+}
+
+// Notifier converts a notification into an outbound HTTP request for a
+// particular service. Requests are delivered via a delivery.Queue so they are
+// retried if the service is unavailable.
+type Notifier interface {
+	Request(n Notification) (delivery.Request, error)
+}
+
+// Target routes notifications for matching jobs to a notifier
+type Target struct {
+	Name     string
+	Notifier Notifier
+
+	// Repos is a list of path.Match patterns for repo URIs. If empty, all repos match.
+	Repos []string
+	// Projects limits notifications to jobs in these projects. If empty, all projects match.
+	Projects []string
+	// Statuses limits notifications to jobs finishing with these statuses.
+	// If empty, both success and failure are notified.
+	Statuses []minici.JobStatus
+
+	// AllTransitions notifies the target of every status change, not just completion.
+	// Statuses may then also include pending and running.
+	AllTransitions bool
+
+	// Transitions limits notifications for completed jobs to these transitions, so
+	// repeated failures can be reported once. If empty, all transitions are notified.
+	Transitions []Transition
+
+	// Template optionally customizes the message text for this target
+	Template *template.Template
+}
+
+// Matches returns true if the target should be notified about the job
+func (t Target) Matches(job minici.Job) bool {
+	if !job.Status.Done() && !t.AllTransitions {
+		return false
+	}
+
+	if len(t.Statuses) > 0 {
+		found := false
+		for _, status := range t.Statuses {
+			if status == job.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return matchAny(t.Repos, job.RepoURI) && matchAny(t.Projects, job.Project)
+}
+
+// matchTransition returns true if the transition is in the list, or the list is empty.
+// Jobs that haven't completed have no transition and always match.
+func matchTransition(transitions []Transition, transition Transition) bool {
+	if len(transitions) == 0 || transition == "" {
+		return true
+	}
+	for _, t := range transitions {
+		if t == transition {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher listens for job events and queues notifications for matching targets
+type Dispatcher struct {
+	queue   *delivery.Queue
+	baseURL string
+
+	targetMutex sync.RWMutex
+	targets     []Target
+	rules       []Rule
+
+	history *outcomeHistory
+}
+
+// NewDispatcher creates a dispatcher that delivers notifications via the queue.
+// The baseURL is used to build links back to the minici server.
+func NewDispatcher(queue *delivery.Queue, baseURL string, targets []Target) *Dispatcher {
+	return &Dispatcher{
+		queue:   queue,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		targets: targets,
+		history: newOutcomeHistory(),
+	}
+}
+
+// AddTarget registers an additional target. Targets are matched by name, so
+// adding a target with an existing name replaces it.
+func (d *Dispatcher) AddTarget(target Target) {
+	d.targetMutex.Lock()
+	defer d.targetMutex.Unlock()
+
+	for i, existing := range d.targets {
+		if existing.Name == target.Name {
+			d.targets[i] = target
+			return
+		}
+	}
+	d.targets = append(d.targets, target)
+}
+
+// RemoveTarget unregisters the named target, returning false if it didn't exist
+func (d *Dispatcher) RemoveTarget(name string) bool {
+	d.targetMutex.Lock()
+	defer d.targetMutex.Unlock()
+
+	for i, existing := range d.targets {
+		if existing.Name == name {
+			d.targets = append(d.targets[:i], d.targets[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetRules replaces the routing rules used to select targets
+func (d *Dispatcher) SetRules(rules []Rule) {
+	d.targetMutex.Lock()
+	defer d.targetMutex.Unlock()
+
+	d.rules = rules
+}
+
+// route returns the targets to notify about a job. If a rule selected a target
+// and has a template, the returned target uses the rule's template.
+// Targets receiving all transitions, such as generic webhooks, are machine
+// integrations and are not subject to rules.
+func (d *Dispatcher) route(n Notification) []Target {
+	job := n.Job
+
+	d.targetMutex.RLock()
+	defer d.targetMutex.RUnlock()
+
+	var selected []Target
+	for _, target := range d.targets {
+		if target.AllTransitions && target.Matches(job) && matchTransition(target.Transitions, n.Transition) {
+			selected = append(selected, target)
+		}
+	}
+
+	for _, rule := range d.rules {
+		if !rule.Matches(job) || !matchTransition(rule.Transitions, n.Transition) {
+			continue
+		}
+		for _, name := range rule.Targets {
+			for _, target := range d.targets {
+				if target.Name == name && !target.AllTransitions && target.Matches(job) && matchTransition(target.Transitions, n.Transition) {
+					if rule.Template != nil {
+						target.Template = rule.Template
+					}
+					selected = append(selected, target)
+				}
+			}
+		}
+		return selected
+	}
+
+	for _, target := range d.targets {
+		if !target.AllTransitions && target.Matches(job) && matchTransition(target.Transitions, n.Transition) {
+			selected = append(selected, target)
+		}
+	}
+	return selected
+}
+
+// Targets returns the currently registered targets
+func (d *Dispatcher) Targets() []Target {
+	d.targetMutex.RLock()
+	defer d.targetMutex.RUnlock()
+
+	return append([]Target(nil), d.targets...)
+}
+
+// HandleJobEvent is a minici.JobListener that notifies matching targets of a
+// job status change.
+func (d *Dispatcher) HandleJobEvent(event minici.JobEvent) {
+	n := Notification{
+		Job:            event.Job,
+		PreviousStatus: event.PreviousStatus,
+	}
+	if d.baseURL != "" {
+		n.LogsURL = fmt.Sprintf("%s/api/jobs/%s/logs", d.baseURL, event.Job.ID)
+	}
+	if event.Job.Status.Done() {
+		n.Transition = d.history.record(event.Job)
+	}
+
+	for _, target := range d.route(n) {
+		n := n
+		if target.Template != nil {
+			message, err := render(target.Template, n)
+			if err != nil {
+				slog.Error("Failed to build notification", "target", target.Name, "job_id", event.Job.ID, "error", err)
+				continue
+			}
+			n.Message = message
+		}
+
+		req, err := target.Notifier.Request(n)
+		if err != nil {
+			slog.Error("Failed to build notification", "target", target.Name, "job_id", event.Job.ID, "error", err)
+			continue
+		}
+		req.JobID = string(event.Job.ID)
+		if err := d.queue.Enqueue(req); err != nil {
+			slog.Error("Failed to queue notification", "target", target.Name, "job_id", event.Job.ID, "error", err)
+		}
+	}
+}
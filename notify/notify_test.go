@@ -0,0 +1,265 @@
+package notify
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testJob(status minici.JobStatus) minici.Job {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	return minici.Job{
+		ID:         "job-1",
+		Status:     status,
+		RepoURI:    "https://github.com/ocuroot/minici",
+		Commit:     "main",
+		Command:    "go test ./...",
+		StartedAt:  start,
+		FinishedAt: start.Add(90 * time.Second),
+	}
+}
+
+func TestTargetMatches(t *testing.T) {
+	target := Target{
+		Repos:    []string{"https://github.com/ocuroot/*"},
+		Statuses: []minici.JobStatus{minici.JobStatusFailure},
+	}
+
+	assert.True(t, target.Matches(testJob(minici.JobStatusFailure)))
+	assert.False(t, target.Matches(testJob(minici.JobStatusSuccess)))
+
+	other := testJob(minici.JobStatusFailure)
+	other.RepoURI = "https://github.com/example/other"
+	assert.False(t, target.Matches(other))
+
+	assert.True(t, Target{}.Matches(testJob(minici.JobStatusSuccess)))
+}
+
+func TestSlackRequest(t *testing.T) {
+	slack := &Slack{WebhookURL: "https://hooks.slack.com/services/T/B/X", Channel: "#ci"}
+
+	req, err := slack.Request(Notification{
+		Job:     testJob(minici.JobStatusFailure),
+		LogsURL: "http://ci.example.com/api/jobs/job-1/logs",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://hooks.slack.com/services/T/B/X", req.URL)
+	assert.Equal(t, "application/json", req.Headers["Content-Type"])
+
+	var msg slackMessage
+	require.NoError(t, json.Unmarshal(req.Body, &msg))
+	assert.Equal(t, ":x: Job failed: <http://ci.example.com/api/jobs/job-1/logs|go test ./...>", msg.Text)
+	assert.Equal(t, "#ci", msg.Channel)
+	require.Len(t, msg.Attachments, 1)
+	assert.Equal(t, "danger", msg.Attachments[0].Color)
+	assert.Contains(t, msg.Attachments[0].Fields, slackField{Title: "Duration", Value: "1m30s", Short: true})
+	assert.Contains(t, msg.Attachments[0].Fields, slackField{Title: "Repo", Value: "https://github.com/ocuroot/minici"})
+}
+
+func TestDiscordRequest(t *testing.T) {
+	discord := &Discord{WebhookURL: "https://discord.com/api/webhooks/1/abc"}
+
+	req, err := discord.Request(Notification{
+		Job:     testJob(minici.JobStatusSuccess),
+		LogsURL: "http://ci.example.com/api/jobs/job-1/logs",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://discord.com/api/webhooks/1/abc", req.URL)
+
+	var msg discordMessage
+	require.NoError(t, json.Unmarshal(req.Body, &msg))
+	assert.Equal(t, "Job succeeded: go test ./...", msg.Content)
+	require.Len(t, msg.Embeds, 1)
+	assert.Equal(t, "http://ci.example.com/api/jobs/job-1/logs", msg.Embeds[0].URL)
+	assert.Equal(t, 0x2eb886, msg.Embeds[0].Color)
+	assert.Contains(t, msg.Embeds[0].Fields, discordField{Name: "Commit", Value: "main", Inline: true})
+}
+
+func TestMatrixRequest(t *testing.T) {
+	matrix := &Matrix{
+		Homeserver:  "https://matrix.example.com/",
+		RoomID:      "!room:example.com",
+		AccessToken: "secret",
+	}
+
+	req, err := matrix.Request(Notification{Job: testJob(minici.JobStatusFailure)})
+	require.NoError(t, err)
+	assert.Equal(t, "PUT", req.Method)
+	assert.Equal(t, "https://matrix.example.com/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/minici-job-1-failure", req.URL)
+	assert.Equal(t, "Bearer secret", req.Headers["Authorization"])
+
+	var msg matrixMessage
+	require.NoError(t, json.Unmarshal(req.Body, &msg))
+	assert.Equal(t, "m.notice", msg.MsgType)
+	assert.Contains(t, msg.Body, "Job failed: go test ./...")
+	assert.Contains(t, msg.FormattedBody, "<code>go test ./...</code>")
+
+	_, err = (&Matrix{Homeserver: "https://matrix.example.com"}).Request(Notification{Job: testJob(minici.JobStatusFailure)})
+	assert.Error(t, err)
+}
+
+func TestWebhookRequest(t *testing.T) {
+	webhook := &Webhook{URL: "https://deploy.example.com/hook", Secret: "s3cret"}
+
+	job := testJob(minici.JobStatusRunning)
+	job.FinishedAt = time.Time{}
+	req, err := webhook.Request(Notification{Job: job, PreviousStatus: minici.JobStatusPending})
+	require.NoError(t, err)
+	assert.Equal(t, "https://deploy.example.com/hook", req.URL)
+	assert.Equal(t, EventJobStatus, req.Headers[EventHeader])
+	assert.True(t, VerifySignature("s3cret", req.Body, req.Headers[SignatureHeader]))
+	assert.False(t, VerifySignature("wrong", req.Body, req.Headers[SignatureHeader]))
+
+	var payload WebhookPayload
+	require.NoError(t, json.Unmarshal(req.Body, &payload))
+	assert.Equal(t, "running", payload.Job.Status)
+	assert.Equal(t, "pending", payload.Job.PreviousStatus)
+	assert.NotNil(t, payload.Job.StartedAt)
+	assert.Nil(t, payload.Job.FinishedAt)
+
+	unsigned, err := (&Webhook{URL: "https://deploy.example.com/hook"}).Request(Notification{Job: job})
+	require.NoError(t, err)
+	assert.NotContains(t, unsigned.Headers, SignatureHeader)
+}
+
+func TestDispatcherAllTransitions(t *testing.T) {
+	queue, err := delivery.NewQueue(delivery.Config{})
+	require.NoError(t, err)
+
+	d := NewDispatcher(queue, "", nil)
+	d.AddTarget(Target{Name: "hook", Notifier: &Webhook{URL: "http://hook"}, AllTransitions: true})
+
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusPending)})
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusRunning), PreviousStatus: minici.JobStatusPending})
+	assert.Len(t, queue.Pending(), 2)
+
+	assert.True(t, d.RemoveTarget("hook"))
+	assert.False(t, d.RemoveTarget("hook"))
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusSuccess), PreviousStatus: minici.JobStatusRunning})
+	assert.Len(t, queue.Pending(), 2)
+}
+
+func TestDispatcherQueuesMatchingTargets(t *testing.T) {
+	queue, err := delivery.NewQueue(delivery.Config{})
+	require.NoError(t, err)
+
+	d := NewDispatcher(queue, "http://ci.example.com/", []Target{
+		{Name: "failures", Notifier: &Slack{WebhookURL: "http://failures"}, Statuses: []minici.JobStatus{minici.JobStatusFailure}},
+		{Name: "all", Notifier: &Slack{WebhookURL: "http://all"}},
+	})
+
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusRunning), PreviousStatus: minici.JobStatusPending})
+	assert.Empty(t, queue.Pending())
+
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusSuccess), PreviousStatus: minici.JobStatusRunning})
+	pending := queue.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "http://all", pending[0].URL)
+	assert.Contains(t, string(pending[0].Body), "http://ci.example.com/api/jobs/job-1/logs")
+
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusFailure), PreviousStatus: minici.JobStatusRunning})
+	assert.Len(t, queue.Pending(), 3)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+base_url: http://ci.example.com
+notifiers:
+  - name: team
+    type: slack
+    webhook_url: https://hooks.slack.com/services/T/B/X
+    repos: ["https://github.com/ocuroot/*"]
+    statuses: [failure]
+`), 0644))
+
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "http://ci.example.com", config.BaseURL)
+
+	targets, err := config.Targets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "team", targets[0].Name)
+	assert.Equal(t, []minici.JobStatus{minici.JobStatusFailure}, targets[0].Statuses)
+
+	_, err = Config{Notifiers: []NotifierConfig{{Type: "carrier-pigeon"}}}.Targets()
+	assert.Error(t, err)
+	_, err = Config{Notifiers: []NotifierConfig{{Type: "slack", WebhookURL: "x", Statuses: []string{"running"}}}}.Targets()
+	assert.Error(t, err)
+}
+
+func TestOutcomeHistory(t *testing.T) {
+	h := newOutcomeHistory()
+
+	assert.Equal(t, TransitionFailed, h.record(testJob(minici.JobStatusFailure)))
+	assert.Equal(t, TransitionStillFailing, h.record(testJob(minici.JobStatusFailure)))
+	assert.Equal(t, TransitionFixed, h.record(testJob(minici.JobStatusSuccess)))
+	assert.Equal(t, TransitionPassed, h.record(testJob(minici.JobStatusSuccess)))
+	assert.Equal(t, TransitionFailed, h.record(testJob(minici.JobStatusFailure)))
+
+	// Other refs are tracked separately
+	other := testJob(minici.JobStatusSuccess)
+	other.Commit = "feature"
+	assert.Equal(t, TransitionPassed, h.record(other))
+
+	// Jobs for different commits on a branch follow on from each other
+	onBranch := func(commit string, status minici.JobStatus) minici.Job {
+		job := testJob(status)
+		job.Commit = commit
+		job.Branch = "release"
+		return job
+	}
+	assert.Equal(t, TransitionFailed, h.record(onBranch("1111111", minici.JobStatusFailure)))
+	assert.Equal(t, TransitionStillFailing, h.record(onBranch("2222222", minici.JobStatusFailure)))
+	assert.Equal(t, TransitionFixed, h.record(onBranch("3333333", minici.JobStatusSuccess)))
+
+	// As do jobs for a pull request, whatever ref they build
+	pr := testJob(minici.JobStatusFailure)
+	pr.Commit = "refs/pull/7/merge"
+	pr.PullRequest = &minici.PullRequest{Number: 7}
+	assert.Equal(t, TransitionFailed, h.record(pr))
+	pr.Commit = "4444444"
+	pr.Status = minici.JobStatusSuccess
+	assert.Equal(t, TransitionFixed, h.record(pr))
+}
+
+func TestDispatcherTransitions(t *testing.T) {
+	queue, err := delivery.NewQueue(delivery.Config{})
+	require.NoError(t, err)
+
+	d := NewDispatcher(queue, "", []Target{
+		{
+			Name:        "team",
+			Notifier:    &Slack{WebhookURL: "http://team"},
+			Transitions: []Transition{TransitionFailed, TransitionFixed},
+		},
+	})
+
+	for _, status := range []minici.JobStatus{
+		minici.JobStatusSuccess,
+		minici.JobStatusFailure,
+		minici.JobStatusFailure,
+		minici.JobStatusFailure,
+		minici.JobStatusSuccess,
+		minici.JobStatusSuccess,
+	} {
+		d.HandleJobEvent(minici.JobEvent{Job: testJob(status)})
+	}
+
+	pending := queue.Pending()
+	require.Len(t, pending, 2)
+
+	var msg slackMessage
+	require.NoError(t, json.Unmarshal(pending[0].Body, &msg))
+	assert.Equal(t, ":x: Job failed: go test ./...", msg.Text)
+	require.NoError(t, json.Unmarshal(pending[1].Body, &msg))
+	assert.Equal(t, ":white_check_mark: Job fixed: go test ./...", msg.Text)
+}
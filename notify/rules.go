@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"text/template"
+
+	"github.com/ocuroot/minici"
+)
+
+// Rule decides which targets are notified about matching jobs.
+// Rules are evaluated in order and the first matching rule wins. If no rule
+// matches, every target whose own filters match is notified.
+type Rule struct {
+	Name string
+
+	// Repos is a list of path.Match patterns for repo URIs. If empty, all repos match.
+	Repos []string
+	// Projects limits the rule to jobs in these projects. If empty, all projects match.
+	Projects []string
+	// Branches is a list of path.Match patterns for the job's branch, or else
+	// the commit ref it was scheduled with. If empty, all refs match.
+	Branches []string
+	// Statuses limits the rule to jobs with these statuses. If empty, all statuses match.
+	Statuses []minici.JobStatus
+	// Labels must all be present on the job with identical values
+	Labels map[string]string
+	// Transitions limits the rule to completed jobs with these transitions.
+	// If empty, all transitions match.
+	Transitions []Transition
+
+	// Targets names the targets to notify. An empty list silences matching jobs.
+	Targets []string
+	// Template optionally overrides the message text sent to the targets
+	Template *template.Template
+}
+
+// Matches returns true if the rule applies to the job
+func (r Rule) Matches(job minici.Job) bool {
+	if len(r.Statuses) > 0 {
+		found := false
+		for _, status := range r.Statuses {
+			if status == job.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !matchAny(r.Repos, job.RepoURI) || !matchAny(r.Projects, job.Project) || !matchAny(r.Branches, jobBranch(job)) {
+		return false
+	}
+
+	for key, value := range r.Labels {
+		if job.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// jobBranch returns the branch a job was scheduled with, or else its commit
+// ref, which is the branch name for jobs scheduled against a branch
+func jobBranch(job minici.Job) string {
+	if job.Branch != "" {
+		return job.Branch
+	}
+	return job.Commit
+}
+
+// matchAny returns true if the value matches any of the patterns, or there are no patterns
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTemplate compiles a message template. Templates are executed with the
+// Notification as their data, for example:
+//
+//	{{.Title}}: {{.Job.RepoURI}}@{{.Job.Commit}} took {{.Duration}}
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// render executes a message template for a notification
+func render(tmpl *template.Template, n Notification) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
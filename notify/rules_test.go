@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleMatches(t *testing.T) {
+	rule := Rule{
+		Repos:    []string{"https://github.com/ocuroot/*"},
+		Branches: []string{"main", "release/*"},
+		Statuses: []minici.JobStatus{minici.JobStatusFailure},
+		Labels:   map[string]string{"team": "payments"},
+	}
+
+	job := testJob(minici.JobStatusFailure)
+	job.Labels = map[string]string{"team": "payments", "kind": "nightly"}
+	assert.True(t, rule.Matches(job))
+
+	job.Commit = "release/1.2"
+	assert.True(t, rule.Matches(job))
+
+	job.Commit = "feature"
+	assert.False(t, rule.Matches(job))
+
+	// Jobs scheduled by hash match on their branch
+	job.Commit = "0123abcd"
+	job.Branch = "main"
+	assert.True(t, rule.Matches(job))
+	job.Branch = ""
+
+	job.Commit = "main"
+	job.Labels = map[string]string{"team": "frontend"}
+	assert.False(t, rule.Matches(job))
+
+	assert.True(t, Rule{}.Matches(testJob(minici.JobStatusSuccess)))
+
+	job = testJob(minici.JobStatusFailure)
+	job.Project = "payments"
+	assert.True(t, Rule{Projects: []string{"payments"}}.Matches(job))
+	job.Project = "frontend"
+	assert.False(t, Rule{Projects: []string{"payments"}}.Matches(job))
+}
+
+func TestDispatcherRules(t *testing.T) {
+	queue, err := delivery.NewQueue(delivery.Config{})
+	require.NoError(t, err)
+
+	tmpl, err := ParseTemplate("page", "PAGE: {{.Job.RepoURI}} failed on {{.Job.Commit}} after {{.Duration}}")
+	require.NoError(t, err)
+
+	d := NewDispatcher(queue, "", []Target{
+		{Name: "team", Notifier: &Slack{WebhookURL: "http://team"}},
+		{Name: "oncall", Notifier: &Slack{WebhookURL: "http://oncall"}},
+		{Name: "hook", Notifier: &Webhook{URL: "http://hook"}, AllTransitions: true},
+	})
+	d.SetRules([]Rule{
+		{Name: "quiet-success", Statuses: []minici.JobStatus{minici.JobStatusSuccess}},
+		{Name: "page", Branches: []string{"main"}, Statuses: []minici.JobStatus{minici.JobStatusFailure}, Targets: []string{"team", "oncall"}, Template: tmpl},
+	})
+
+	// Success is silenced for chat, but webhooks are not subject to rules
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusSuccess)})
+	pending := queue.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "http://hook", pending[0].URL)
+
+	// Failures on main page both channels with the rule's template
+	d.HandleJobEvent(minici.JobEvent{Job: testJob(minici.JobStatusFailure)})
+	pending = queue.Pending()
+	require.Len(t, pending, 4)
+	var urls []string
+	for _, req := range pending[1:] {
+		urls = append(urls, req.URL)
+		if req.URL == "http://hook" {
+			continue
+		}
+		var msg slackMessage
+		require.NoError(t, json.Unmarshal(req.Body, &msg))
+		assert.Equal(t, "PAGE: https://github.com/ocuroot/minici failed on main after 1m30s", msg.Text)
+	}
+	assert.ElementsMatch(t, []string{"http://team", "http://oncall", "http://hook"}, urls)
+
+	// Failures elsewhere fall through to every target
+	job := testJob(minici.JobStatusFailure)
+	job.Commit = "feature"
+	d.HandleJobEvent(minici.JobEvent{Job: job})
+	assert.Len(t, queue.Pending(), 7)
+}
+
+func TestConfigRules(t *testing.T) {
+	config := Config{
+		Notifiers: []NotifierConfig{
+			{Name: "team", Type: "slack", WebhookURL: "http://team", Template: "{{.Title}}"},
+		},
+		Rules: []RuleConfig{
+			{Statuses: []string{"success"}},
+			{Statuses: []string{"failure"}, Notifiers: []string{"team"}, Template: "{{.Job.ID}} failed"},
+		},
+	}
+
+	rules, err := config.RoutingRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "rule-0", rules[0].Name)
+	assert.Empty(t, rules[0].Targets)
+	assert.NotNil(t, rules[1].Template)
+
+	config.Rules = []RuleConfig{{Notifiers: []string{"missing"}}}
+	_, err = config.RoutingRules()
+	assert.Error(t, err)
+
+	config.Rules = []RuleConfig{{Template: "{{.Broken"}}
+	_, err = config.RoutingRules()
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/delivery"
+)
+
+// Slack posts notifications to a Slack incoming webhook
+type Slack struct {
+	WebhookURL string
+
+	// Channel and Username optionally override the webhook's defaults
+	Channel  string
+	Username string
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Fields []slackField `json:"fields"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Request builds the webhook call for a notification
+func (s *Slack) Request(n Notification) (delivery.Request, error) {
+	if s.WebhookURL == "" {
+		return delivery.Request{}, fmt.Errorf("slack webhook URL is required")
+	}
+
+	text := fmt.Sprintf("%s %s: %s", slackEmoji(n.Job.Status), n.Title(), n.Job.Command)
+	if n.LogsURL != "" {
+		text = fmt.Sprintf("%s %s: <%s|%s>", slackEmoji(n.Job.Status), n.Title(), n.LogsURL, n.Job.Command)
+	}
+	if n.Message != "" {
+		text = n.Message
+	}
+
+	color := "good"
+	if n.Job.Status != minici.JobStatusSuccess {
+		color = "danger"
+	}
+
+	body, err := json.Marshal(slackMessage{
+		Text:     text,
+		Channel:  s.Channel,
+		Username: s.Username,
+		Attachments: []slackAttachment{
+			{
+				Color: color,
+				Fields: []slackField{
+					{Title: "Repo", Value: n.Job.RepoURI},
+					{Title: "Commit", Value: n.Job.Commit, Short: true},
+					{Title: "Duration", Value: n.Duration().String(), Short: true},
+					{Title: "Job", Value: string(n.Job.ID)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return delivery.Request{}, err
+	}
+
+	return delivery.Request{
+		Method:  http.MethodPost,
+		URL:     s.WebhookURL,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+	}, nil
+}
+
+func slackEmoji(status minici.JobStatus) string {
+	if status == minici.JobStatusSuccess {
+		return ":white_check_mark:"
+	}
+	return ":x:"
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ocuroot/minici/delivery"
+	"github.com/ocuroot/minici/webhookverify"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the request body, formatted as "sha256=<hex>"
+	SignatureHeader = webhookverify.MiniciHeader
+	// EventHeader identifies the type of event in the payload
+	EventHeader = "X-Minici-Event"
+
+	// EventJobStatus is sent whenever a job changes status
+	EventJobStatus = "job.status"
+)
+
+// Webhook posts a JSON payload describing job status changes to an arbitrary URL
+type Webhook struct {
+	URL string
+	// Secret is used to sign payloads. If empty, payloads are not signed.
+	Secret string
+}
+
+// WebhookPayload is the body sent to generic webhooks
+type WebhookPayload struct {
+	Event string     `json:"event"`
+	Job   WebhookJob `json:"job"`
+	// Transition is set for completed jobs, see Transition
+	Transition string    `json:"transition,omitempty"`
+	LogsURL    string    `json:"logs_url,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	SentAt     time.Time `json:"sent_at"`
+}
+
+// WebhookJob describes the job in a webhook payload
+type WebhookJob struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	PreviousStatus string     `json:"previous_status,omitempty"`
+	Number         int        `json:"number,omitempty"`
+	RepoURI        string     `json:"repo_uri"`
+	Commit         string     `json:"commit"`
+	Command        string     `json:"command"`
+	Name           string     `json:"name,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// Request builds the webhook call for a notification
+func (w *Webhook) Request(n Notification) (delivery.Request, error) {
+	if w.URL == "" {
+		return delivery.Request{}, fmt.Errorf("webhook URL is required")
+	}
+
+	payload := WebhookPayload{
+		Event: EventJobStatus,
+		Job: WebhookJob{
+			ID:             string(n.Job.ID),
+			Status:         string(n.Job.Status),
+			PreviousStatus: string(n.PreviousStatus),
+			Number:         n.Job.Number,
+			RepoURI:        n.Job.RepoURI,
+			Commit:         n.Job.Commit,
+			Command:        n.Job.Command,
+			Name:           n.Job.Name,
+			CreatedAt:      n.Job.CreatedAt,
+		},
+		Transition: string(n.Transition),
+		LogsURL:    n.LogsURL,
+		Message:    n.Message,
+		SentAt:     time.Now().UTC(),
+	}
+	if !n.Job.StartedAt.IsZero() {
+		payload.Job.StartedAt = &n.Job.StartedAt
+	}
+	if !n.Job.FinishedAt.IsZero() {
+		payload.Job.FinishedAt = &n.Job.FinishedAt
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return delivery.Request{}, err
+	}
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		EventHeader:    EventJobStatus,
+	}
+	if w.Secret != "" {
+		headers[SignatureHeader] = Sign(w.Secret, body)
+	}
+
+	return delivery.Request{
+		Method:  http.MethodPost,
+		URL:     w.URL,
+		Headers: headers,
+		Body:    body,
+	}, nil
+}
+
+// Sign returns the signature header value for a payload
+func Sign(secret string, body []byte) string {
+	return webhookverify.HMAC{Secret: secret, Header: SignatureHeader, Prefix: "sha256="}.Sign(body)
+}
+
+// VerifySignature checks a signature header value against a payload. Receivers
+// can use webhookverify.Minici to check requests directly.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return webhookverify.Minici(secret).Verify(http.Header{SignatureHeader: {signature}}, body) == nil
+}
@@ -0,0 +1,30 @@
+package minici
+
+// JobPinner is implemented by CIs that can pin jobs, such as the job that
+// built a release, so that retention never removes their artifacts
+type JobPinner interface {
+	// PinJob pins or unpins a job, returning ErrJobNotFound if it doesn't
+	// exist. Jobs can be pinned whether or not they have finished.
+	PinJob(jobID JobID, pinned bool) error
+}
+
+var _ JobPinner = &CIServer{}
+
+// PinJob pins or unpins a job
+func (s *CIServer) PinJob(jobID JobID, pinned bool) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.Pinned == pinned {
+		return nil
+	}
+	job.Pinned = pinned
+	s.jobsUpdated()
+	if s.cluster != nil {
+		s.cluster.changed(jobID)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoPipelineFile is the name of the optional Starlark program at the root
+// of a repository that generates its config, in place of RepoConfigFile.
+// The program calls pipeline() once, with the settings a RepoConfigFile
+// would have as keyword arguments.
+const RepoPipelineFile = ".minici.star"
+
+// pipelineLimits bound the work a pipeline program can do, so a mistake like
+// an endless loop fails the job rather than the worker
+var pipelineLimits = starLimits{
+	steps:   1_000_000,
+	size:    4 << 20,
+	timeout: 10 * time.Second,
+}
+
+// pipelineMaxDepth is how deeply the values passed to pipeline() can be
+// nested, as a list can contain itself
+const pipelineMaxDepth = 100
+
+// loadJobConfig reads a repo's config for a job, running its pipeline
+// program if it has one instead of a config file
+func loadJobConfig(ctx context.Context, git GitClient, job Job, dir, commit string, templates TemplateStore, log func(string)) (RepoConfig, error) {
+	content, err := os.ReadFile(filepath.Join(dir, RepoPipelineFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return LoadRepoConfigWithTemplates(ctx, dir, templates)
+	}
+	if err != nil {
+		return RepoConfig{}, fmt.Errorf("failed to read %s: %w", RepoPipelineFile, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, RepoConfigFile)); err == nil {
+		return RepoConfig{}, fmt.Errorf("a repo can't have both %s and %s", RepoConfigFile, RepoPipelineFile)
+	}
+
+	conditions, err := stepConditions(ctx, git, job, job.Spec(), dir, commit)
+	if err != nil {
+		return RepoConfig{}, err
+	}
+	config, err := evalPipeline(ctx, content, conditions, func(line string) {
+		log("pipeline: " + line)
+	})
+	if err != nil {
+		return RepoConfig{}, err
+	}
+	loader := configLoader{dir: dir, templates: templates}
+	return loader.includes(ctx, RepoPipelineFile, config, nil, false)
+}
+
+// evalPipeline runs a pipeline program, returning the config it generates.
+// The program reads the job through the ci module, which has the same values
+// as step conditions.
+func evalPipeline(ctx context.Context, content []byte, conditions *conditionContext, print func(string)) (RepoConfig, error) {
+	generated, err := generatePipeline(ctx, content, conditions, print)
+	if err != nil {
+		return RepoConfig{}, err
+	}
+	var config RepoConfig
+	if err := yaml.Unmarshal(generated, &config); err != nil {
+		problems := pipelineProblems(generated, yamlProblems(err))
+		messages := make([]string, len(problems))
+		for i, problem := range problems {
+			messages[i] = problem.String()
+		}
+		return RepoConfig{}, fmt.Errorf("%s generated an invalid config: %s", RepoPipelineFile, strings.Join(messages, ", "))
+	}
+	return config, nil
+}
+
+// ValidatePipeline checks a pipeline program by running it without a job,
+// so ci.branch and the other values it reads are empty and no files have
+// changed, then checking the
+// config it generates as ValidateRepoConfig would. Problems in the program
+// have its line and column, while problems in the config have the field
+// they're in.
+func ValidatePipeline(content []byte) []ConfigProblem {
+	conditions := &conditionContext{Changed: func() ([]string, error) { return nil, nil }}
+	generated, err := generatePipeline(context.Background(), content, conditions, nil)
+	if err != nil {
+		problem := ConfigProblem{Severity: SeverityError, Message: err.Error()}
+		var starErr *starError
+		if errors.As(err, &starErr) {
+			problem.Line, problem.Column, problem.Message = starErr.line, starErr.col, starErr.message
+		}
+		return []ConfigProblem{problem}
+	}
+	return pipelineProblems(generated, validateConfig(generated, false))
+}
+
+// pipelineProblems names the fields of the problems found in a generated
+// config. Their lines are in the generated YAML rather than the program, so
+// they're dropped.
+func pipelineProblems(generated []byte, problems []ConfigProblem) []ConfigProblem {
+	var root yaml.Node
+	yaml.Unmarshal(generated, &root)
+	for i, problem := range problems {
+		if problem.Field == "" && problem.Line > 0 && len(root.Content) > 0 {
+			problems[i].Field = yamlFieldAt(root.Content[0], problem.Line, "")
+		}
+		problems[i].Line, problems[i].Column = 0, 0
+	}
+	return problems
+}
+
+// yamlFieldAt returns the path of the setting on a line of a document, such
+// as "steps[1].run"
+func yamlFieldAt(node *yaml.Node, line int, field string) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			name := key.Value
+			if field != "" {
+				name = field + "." + name
+			}
+			if value.Line == line {
+				return name
+			}
+			if found := yamlFieldAt(value, line, name); found != "" {
+				return found
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			name := fmt.Sprintf("%s[%d]", field, i)
+			if found := yamlFieldAt(item, line, name); found != "" {
+				return found
+			}
+			if item.Line == line {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// generatePipeline runs a pipeline program, returning the arguments it
+// called pipeline() with as a YAML config
+func generatePipeline(ctx context.Context, content []byte, conditions *conditionContext, print func(string)) ([]byte, error) {
+	known := yamlFieldNames(reflect.TypeOf(RepoConfig{}))
+	var generated *yaml.Node
+	pipeline := starlark.NewBuiltin("pipeline", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if generated != nil {
+			return nil, errors.New("pipeline() can only be called once")
+		}
+		if len(args) > 0 {
+			return nil, errors.New("pipeline() only takes keyword arguments, such as pipeline(image = \"golang\")")
+		}
+		doc := &yaml.Node{Kind: yaml.MappingNode}
+		for _, kwarg := range kwargs {
+			name := string(kwarg[0].(starlark.String))
+			found := false
+			for _, field := range known {
+				found = found || name == field
+			}
+			if !found {
+				message := fmt.Sprintf("pipeline() got an unexpected keyword argument %q", name)
+				if suggestion := closestField(name, known); suggestion != "" {
+					message += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				return nil, errors.New(message)
+			}
+			value, err := starToYAML(kwarg[1], name, 0)
+			if err != nil {
+				return nil, err
+			}
+			doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+		}
+		generated = doc
+		return starlark.None, nil
+	})
+
+	predeclared := starlark.StringDict{
+		"pipeline": pipeline,
+		"ci":       pipelineModule(conditions),
+	}
+	if _, err := execStarlark(ctx, RepoPipelineFile, content, predeclared, pipelineLimits, print); err != nil {
+		return nil, err
+	}
+	if generated == nil {
+		return nil, fmt.Errorf("%s didn't call pipeline()", RepoPipelineFile)
+	}
+	return yaml.Marshal(generated)
+}
+
+// pipelineModule returns the ci module pipeline programs read the job from
+func pipelineModule(conditions *conditionContext) *starlarkstruct.Module {
+	changedFiles := func() ([]string, error) {
+		if conditions.Changed == nil {
+			return nil, errors.New("changed files aren't supported by this git client")
+		}
+		files, err := conditions.Changed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list changed files: %w", err)
+		}
+		return files, nil
+	}
+	return &starlarkstruct.Module{Name: "ci", Members: starlark.StringDict{
+		"branch":  starlark.String(conditions.Branch),
+		"tag":     starlark.String(conditions.Tag),
+		"ref":     starlark.String(conditions.Ref),
+		"commit":  starlark.String(conditions.Commit),
+		"repo":    starlark.String(conditions.RepoURI),
+		"project": starlark.String(conditions.Project),
+		"env":     stringDict(conditions.Env),
+		"labels":  stringDict(conditions.Labels),
+		"changed_files": starlark.NewBuiltin("changed_files", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			files, err := changedFiles()
+			if err != nil {
+				return nil, err
+			}
+			elems := make([]starlark.Value, len(files))
+			for i, file := range files {
+				elems[i] = starlark.String(file)
+			}
+			return starlark.NewList(elems), nil
+		}),
+		// changed matches patterns as changed() in step conditions does
+		"changed": starlark.NewBuiltin("changed", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(kwargs) > 0 {
+				return nil, errors.New("changed() doesn't take keyword arguments")
+			}
+			if len(args) == 0 {
+				return nil, errors.New("changed() needs at least one pattern")
+			}
+			patterns := make([]string, len(args))
+			for i, arg := range args {
+				pattern, ok := starlark.AsString(arg)
+				if !ok {
+					return nil, fmt.Errorf("changed() patterns must be strings, got %s", arg.Type())
+				}
+				patterns[i] = pattern
+			}
+			files, err := changedFiles()
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				for _, pattern := range patterns {
+					if matchChanged(pattern, file) {
+						return starlark.True, nil
+					}
+				}
+			}
+			return starlark.False, nil
+		}),
+	}}
+}
+
+// stringDict converts a map to a dict, sorted by key so programs see the
+// same order every time
+func stringDict(values map[string]string) *starlark.Dict {
+	dict := starlark.NewDict(len(values))
+	for _, key := range sortedKeys(values) {
+		dict.SetKey(starlark.String(key), starlark.String(values[key]))
+	}
+	return dict
+}
+
+// starToYAML converts a value passed to pipeline() to a YAML node. field is
+// the path of the value, for errors.
+func starToYAML(value starlark.Value, field string, depth int) (*yaml.Node, error) {
+	if depth > pipelineMaxDepth {
+		return nil, fmt.Errorf("pipeline() argument %s is nested too deeply", field)
+	}
+	switch value := value.(type) {
+	case starlark.NoneType:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case starlark.Bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(bool(value))}, nil
+	case starlark.Int:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value.String()}, nil
+	case starlark.Float:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(float64(value), 'g', -1, 64)}, nil
+	case starlark.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(value)}, nil
+	case *starlark.List, starlark.Tuple:
+		seq := &yaml.Node{Kind: yaml.SequenceNode}
+		indexable := value.(starlark.Indexable)
+		for i := 0; i < indexable.Len(); i++ {
+			node, err := starToYAML(indexable.Index(i), fmt.Sprintf("%s[%d]", field, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			seq.Content = append(seq.Content, node)
+		}
+		return seq, nil
+	case *starlark.Dict:
+		mapping := &yaml.Node{Kind: yaml.MappingNode}
+		for _, item := range value.Items() {
+			name, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("pipeline() argument %s has a %s key, but keys must be strings", field, item[0].Type())
+			}
+			node, err := starToYAML(item[1], field+"."+string(name), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(name)}, node)
+		}
+		return mapping, nil
+	}
+	return nil, fmt.Errorf("pipeline() argument %s can't be a %s", field, value.Type())
+}
@@ -0,0 +1,177 @@
+package minici
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvalPipeline(t *testing.T) {
+	src := `
+GO_VERSIONS = ["1.23", "1.24"]
+
+def go_step(version, command):
+    return {"name": "test-go" + version.replace(".", ""), "run": "docker run golang:%s %s" % (version, command)}
+
+steps = [go_step(v, "go test ./...") for v in GO_VERSIONS]
+if ci.branch == "main" and ci.changed("deploy/**"):
+    steps.append({"name": "deploy", "run": "./deploy.sh " + ci.env["TARGET"]})
+if ci.tag:
+    steps.append({"run": "echo releasing " + ci.tag})
+print("generated", len(steps), "steps")
+
+pipeline(
+    image = "golang:" + GO_VERSIONS[-1],
+    env = {"CGO_ENABLED": "0", "COUNT": 3, "DEBUG": False},
+    setup = ["go mod download"],
+    steps = steps,
+)
+`
+	conditions := &conditionContext{
+		Branch: "main",
+		Env:    map[string]string{"TARGET": "prod"},
+		Changed: func() ([]string, error) {
+			return []string{"deploy/app.yaml"}, nil
+		},
+	}
+	var printed []string
+	config, err := evalPipeline(context.Background(), []byte(src), conditions, func(line string) {
+		printed = append(printed, line)
+	})
+	if err != nil {
+		t.Fatalf("Failed to evaluate pipeline: %v", err)
+	}
+	expected := RepoConfig{
+		Image: "golang:1.24",
+		Env:   map[string]string{"CGO_ENABLED": "0", "COUNT": "3", "DEBUG": "false"},
+		Setup: []string{"go mod download"},
+		Steps: []StepConfig{
+			{Name: "test-go123", Run: "docker run golang:1.23 go test ./..."},
+			{Name: "test-go124", Run: "docker run golang:1.24 go test ./..."},
+			{Name: "deploy", Run: "./deploy.sh prod"},
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected config %+v, got %+v", expected, config)
+	}
+	if len(printed) != 1 || printed[0] != "generated 3 steps" {
+		t.Errorf("Expected the step count to be printed, got %q", printed)
+	}
+
+	config, err = evalPipeline(context.Background(), []byte(src), &conditionContext{Tag: "v1.0.0"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to evaluate pipeline for a tag: %v", err)
+	}
+	if len(config.Steps) != 3 || config.Steps[2].Run != "echo releasing v1.0.0" {
+		t.Errorf("Expected a release step for the tag, got %+v", config.Steps)
+	}
+}
+
+func TestEvalPipelineErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{name: "no pipeline", src: "x = 1", expected: ".minici.star didn't call pipeline()"},
+		{name: "called twice", src: "pipeline()\npipeline()", expected: ".minici.star:2:9: pipeline() can only be called once"},
+		{name: "unknown setting", src: "pipeline(stpes = [])", expected: `.minici.star:1:9: pipeline() got an unexpected keyword argument "stpes", did you mean "steps"?`},
+		{name: "positional", src: "pipeline({})", expected: "pipeline() only takes keyword arguments"},
+		{name: "function value", src: "def f():\n    pass\npipeline(setup = [f])", expected: "pipeline() argument setup[0] can't be a function"},
+		{name: "int key", src: "pipeline(env = {1: 'x'})", expected: "pipeline() argument env has a int key, but keys must be strings"},
+		{name: "wrong type", src: "pipeline(steps = [{'run': ['a']}])", expected: ".minici.star generated an invalid config: error: steps[0].run: cannot unmarshal !!seq into string"},
+		{name: "changed without git", src: "pipeline(image = 'x' if ci.changed('a') else 'y')", expected: "changed files aren't supported by this git client"},
+		{name: "endless loop", src: "for i in range(1000000):\n    for j in range(1000000):\n        pass\n", expected: "exceeded the limit of 1000000 steps"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := evalPipeline(context.Background(), []byte(test.src), &conditionContext{}, nil)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected error %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected []ConfigProblem
+	}{
+		{name: "valid", src: "pipeline(steps = [{'name': 'test', 'run': 'go test ./...'}])"},
+		{
+			name:     "syntax error",
+			src:      "pipeline(\n  image = 'x',,\n)",
+			expected: []ConfigProblem{{Severity: SeverityError, Line: 2, Column: 15, Message: "got ',', want primary expression"}},
+		},
+		{
+			name: "config problems",
+			src:  "pipeline(steps = [{'run': 'a', 'nmae': 'x'}, {'name': 'b'}], coverage = '../c.out')",
+			expected: []ConfigProblem{
+				{Severity: SeverityWarning, Field: "steps[0]", Message: `unknown field "nmae" is ignored, did you mean "name"?`},
+				{Severity: SeverityError, Field: "steps[1]", Message: "step has nothing to run"},
+				{Severity: SeverityError, Field: "coverage", Message: `invalid path "../c.out": must be relative to the repository root`},
+			},
+		},
+		{
+			name:     "type error",
+			src:      "pipeline(setup = 'make')",
+			expected: []ConfigProblem{{Severity: SeverityError, Field: "setup", Message: "cannot unmarshal !!str `make` into []string"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := ValidatePipeline([]byte(test.src))
+			if len(problems) != len(test.expected) || (len(problems) > 0 && !reflect.DeepEqual(problems, test.expected)) {
+				t.Errorf("Expected problems %+v, got %+v", test.expected, problems)
+			}
+		})
+	}
+}
+
+func TestPipelineInJobs(t *testing.T) {
+	git := &configGit{
+		changed: []string{"docs/index.md"},
+		pipeline: `
+steps = [{"name": "build", "run": "echo built"}]
+if ci.changed("docs/**"):
+    steps.append({"run": "echo docs changed on " + ci.branch})
+if ci.labels.get("deploy") == "yes":
+    steps.append({"run": "echo deploying"})
+print("branch", ci.branch)
+pipeline(setup = ["echo set up"], steps = steps)
+`,
+	}
+	run := func() (JobResult, string) {
+		var lines []string
+		job := Job{ID: "01ABC", RepoURI: "https://example.com/repo.git", Commit: "main", Command: "echo command"}
+		result := RunJob(context.Background(), job, &LocalExecutor{}, JobStores{Git: git}, func(line string) {
+			lines = append(lines, line)
+		})
+		return result, strings.Join(lines, "\n")
+	}
+
+	result, logs := run()
+	if result.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %+v: %s", result, logs)
+	}
+	for _, expected := range []string{"pipeline: branch main", "> set up", "> built", "> docs changed on main"} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected %q in the logs, got:\n%s", expected, logs)
+		}
+	}
+	if strings.Contains(logs, "deploying") {
+		t.Errorf("Expected the deploy step not to be generated, got:\n%s", logs)
+	}
+
+	git.pipeline = "pipeline(steps = [{'run': 'echo ' + ci.nope}])"
+	result, logs = run()
+	if result.Status != JobStatusFailure || result.FailedPhase != PhasePrepare {
+		t.Errorf("Expected a failing pipeline to fail preparing the job, got %+v", result)
+	}
+	if !strings.Contains(logs, ".minici.star:1:39: module has no .nope field or method") {
+		t.Errorf("Expected the pipeline's error to be logged, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,122 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrJobRejected is returned when a job isn't allowed by a JobPolicy
+var ErrJobRejected = errors.New("job rejected by policy")
+
+// JobPolicy restricts which repos, commands, images and environment variables
+// jobs may be submitted with. Empty lists allow anything.
+type JobPolicy struct {
+	// Repos are path.Match patterns for repo URIs, such as https://github.com/ocuroot/*
+	Repos []string `yaml:"repos"`
+	// Commands are prefixes a job's command must start with, such as "make ".
+	// Commands containing shell operators are rejected when this is set, as
+	// they could run anything after an allowed prefix, as are environment
+	// variables in shellEnv.
+	Commands []string `yaml:"commands"`
+	// Images are path.Match patterns for the images jobs may ask to run in,
+	// such as golang:*. Jobs that don't name one use their repo's config.
+	Images []string `yaml:"images"`
+	// Env are path.Match patterns for the names of the environment variables
+	// jobs may set, such as GOFLAGS or TEST_*
+	Env []string `yaml:"env"`
+}
+
+// shellOperators can chain further commands onto an allowed one
+var shellOperators = []string{";", "&", "|", "`", "$(", "\n", "\r"}
+
+// shellEnv are environment variables that can make the shell or the programs
+// it starts run other commands, getting around restricted commands
+var shellEnv = []string{"BASH_ENV", "ENV", "SHELLOPTS", "BASHOPTS", "PS4", "PROMPT_COMMAND", "IFS", "PATH", "LD_PRELOAD", "LD_LIBRARY_PATH", "LD_AUDIT"}
+
+// LoadJobPolicy reads a job policy from a YAML file
+func LoadJobPolicy(path string) (JobPolicy, error) {
+	var policy JobPolicy
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return policy, fmt.Errorf("failed to read job policy: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &policy); err != nil {
+		return policy, fmt.Errorf("failed to parse job policy %s: %w", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return policy, fmt.Errorf("invalid job policy %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+func (p JobPolicy) validate() error {
+	for _, pattern := range p.Repos {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid repo pattern %q", pattern)
+		}
+	}
+	for _, pattern := range p.Images {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid image pattern %q", pattern)
+		}
+	}
+	for _, pattern := range p.Env {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid env pattern %q", pattern)
+		}
+	}
+	for _, prefix := range p.Commands {
+		if prefix == "" {
+			return errors.New("command prefixes can't be empty")
+		}
+	}
+	return nil
+}
+
+// Check returns an error wrapping ErrJobRejected if the policy doesn't allow
+// the job
+func (p JobPolicy) Check(spec JobSpec) error {
+	if len(p.Repos) > 0 && !matchesAny(p.Repos, spec.RepoURI) {
+		return fmt.Errorf("%w: repo %s is not allowed", ErrJobRejected, spec.RepoURI)
+	}
+	if spec.Image != "" && len(p.Images) > 0 && !matchesAny(p.Images, spec.Image) {
+		return fmt.Errorf("%w: image %s is not allowed", ErrJobRejected, spec.Image)
+	}
+	for _, name := range sortedKeys(spec.Env) {
+		if len(p.Env) > 0 && !matchesAny(p.Env, name) {
+			return fmt.Errorf("%w: environment variable %s is not allowed", ErrJobRejected, name)
+		}
+		if len(p.Commands) > 0 && (slices.Contains(shellEnv, name) || strings.HasPrefix(name, "DYLD_")) {
+			return fmt.Errorf("%w: environment variable %s can't be set when commands are restricted", ErrJobRejected, name)
+		}
+	}
+	if len(p.Commands) == 0 {
+		return nil
+	}
+	for _, operator := range shellOperators {
+		if strings.Contains(spec.Command, operator) {
+			return fmt.Errorf("%w: commands can't contain %q", ErrJobRejected, operator)
+		}
+	}
+	for _, prefix := range p.Commands {
+		if strings.HasPrefix(spec.Command, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: command %q is not allowed", ErrJobRejected, spec.Command)
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
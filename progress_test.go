@@ -0,0 +1,66 @@
+package minici
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatEnv(t *testing.T) {
+	executor := &recordingExecutor{specs: make(chan JobSpec, 1)}
+	ci := NewCIServer(
+		WithGitClient(&fakeGit{}),
+		WithExecutor(executor),
+		WithServerURL("http://ci.example.com/"),
+		WithJobStarter(func(run func()) { run() }),
+	)
+	id := ci.ScheduleJob("https://example.com/repo.git", "main", "deploy")
+	spec := <-executor.specs
+	if spec.Env[EnvJobToken] != ci.JobDetail(id).Token {
+		t.Errorf("Expected the job's token in $%s, got %q", EnvJobToken, spec.Env[EnvJobToken])
+	}
+	if want := "http://ci.example.com/api/jobs/" + string(id) + "/heartbeat"; spec.Env[EnvHeartbeatURL] != want {
+		t.Errorf("Expected $%s to be %q, got %q", EnvHeartbeatURL, want, spec.Env[EnvHeartbeatURL])
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false), WithClock(&stepClock{now: time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)})).(*CIServer)
+	id, err := ci.Submit(JobSpec{RepoURI: "https://example.com/repo.git", Commit: "main", Command: "make deploy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ci.JobDetail(id).Token
+	if !IsJobToken(token) {
+		t.Fatalf("Expected the job to have a token, got %q", token)
+	}
+
+	percent := 25.0
+	if err := ci.Heartbeat(id, token, &percent, "Uploading"); err != nil {
+		t.Fatal(err)
+	}
+	first := ci.JobDetail(id).Progress.UpdatedAt
+	if err := ci.Heartbeat(id, token, nil, "Verifying"); err != nil {
+		t.Fatal(err)
+	}
+	progress := ci.JobDetail(id).Progress
+	if progress == nil || progress.Percent == nil || *progress.Percent != 25 || progress.Message != "Verifying" || !progress.UpdatedAt.After(first) {
+		t.Errorf("Expected the percent to be kept with the new message, got %+v", progress)
+	}
+
+	if err := ci.Heartbeat(id, "minici_job_wrong", nil, ""); err != ErrInvalidJobToken {
+		t.Errorf("Expected a wrong token to be rejected, got %v", err)
+	}
+	if err := ci.Heartbeat("missing", token, nil, ""); err != ErrJobNotFound {
+		t.Errorf("Expected a missing job to be reported, got %v", err)
+	}
+	tooMuch := 101.0
+	if err := ci.Heartbeat(id, token, &tooMuch, ""); err == nil {
+		t.Error("Expected a percent over 100 to be rejected")
+	}
+	if err := ci.CancelJob(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.Heartbeat(id, token, nil, ""); err != ErrJobFinished {
+		t.Errorf("Expected heartbeats to stop once the job finished, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package minici
+
+import "fmt"
+
+// PullRequest describes a GitHub pull request or GitLab merge request that a
+// job builds
+type PullRequest struct {
+	Number int
+	// SourceBranch is the branch with the changes, which may be in a fork
+	SourceBranch string
+	// TargetBranch is the branch the changes are to be merged into
+	TargetBranch string
+	// Author is the username of whoever opened the pull request
+	Author string
+}
+
+// PullRequestMergeRef returns the ref a provider, github or gitlab, keeps the
+// result of merging a pull request into its target branch at, such as
+// refs/pull/42/merge, so a job can test the merged result
+func PullRequestMergeRef(provider string, number int) (string, error) {
+	switch provider {
+	case "github":
+		return fmt.Sprintf("refs/pull/%d/merge", number), nil
+	case "gitlab":
+		return fmt.Sprintf("refs/merge-requests/%d/merge", number), nil
+	}
+	return "", fmt.Errorf("unknown pull request provider %q, expected github or gitlab", provider)
+}
@@ -0,0 +1,179 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFilter is returned when querying jobs with a filter that can't match
+var ErrInvalidFilter = errors.New("invalid job filter")
+
+// Cursor marks the end of a page of QueryJobs results, to continue from with
+// JobFilter.After. An empty cursor means there are no more jobs.
+type Cursor string
+
+func newCursor(job Job) Cursor {
+	return Cursor(job.CreatedAt.UTC().Format(time.RFC3339Nano) + "_" + string(job.ID))
+}
+
+// position returns when the last job of a page was created and its ID
+func (c Cursor) position() (time.Time, JobID, error) {
+	created, id, ok := strings.Cut(string(c), "_")
+	parsed, err := time.Parse(time.RFC3339Nano, created)
+	if !ok || err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: invalid cursor %q", ErrInvalidFilter, c)
+	}
+	return parsed, JobID(id), nil
+}
+
+// JobFilter selects jobs for QueryJobs. Empty fields match every job.
+type JobFilter struct {
+	// Statuses matches jobs with any of the statuses
+	Statuses []JobStatus
+	RepoURI  string
+	Project  string
+	// Group matches the jobs in a group
+	Group string
+	// Number matches the repo's job with a build number
+	Number int
+	// Labels must all be set on a job to the given values
+	Labels map[string]string
+	// CreatedAfter and CreatedBefore limit when jobs were scheduled
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Pinned matches only pinned jobs
+	Pinned bool
+
+	// Limit is the most jobs to return, 0 for every matching job
+	Limit int
+	// After continues from the cursor returned with a previous page
+	After Cursor
+}
+
+// validate returns an error wrapping ErrInvalidFilter if the filter is malformed
+func (f JobFilter) validate() error {
+	for _, status := range f.Statuses {
+		switch status {
+		case JobStatusPending, JobStatusRunning, JobStatusSuccess, JobStatusFailure, JobStatusCancelled, JobStatusInterrupted:
+		default:
+			return fmt.Errorf("%w: unknown status %q", ErrInvalidFilter, status)
+		}
+	}
+	if f.Limit < 0 {
+		return fmt.Errorf("%w: limit can't be negative", ErrInvalidFilter)
+	}
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && !f.CreatedAfter.Before(f.CreatedBefore) {
+		return fmt.Errorf("%w: created after must be before created before", ErrInvalidFilter)
+	}
+	if f.After != "" {
+		if _, _, err := f.After.position(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the job meets every condition of the filter, ignoring pagination
+func (f JobFilter) Matches(job *Job) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if job.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if (f.RepoURI != "" && job.RepoURI != f.RepoURI) || (f.Project != "" && job.Project != f.Project) {
+		return false
+	}
+	if f.Group != "" && job.Group != f.Group {
+		return false
+	}
+	if f.Number != 0 && job.Number != f.Number {
+		return false
+	}
+	if f.Pinned && !job.Pinned {
+		return false
+	}
+	for key, value := range f.Labels {
+		if actual, ok := job.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	if !f.CreatedAfter.IsZero() && !job.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !job.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// FilterJobs returns a page of the jobs matching a filter, in the order they
+// were created, and the cursor for the next page. It is for CI
+// implementations that hold their jobs in a slice.
+func FilterJobs(jobs []Job, filter JobFilter) ([]Job, Cursor, error) {
+	if err := filter.validate(); err != nil {
+		return nil, "", err
+	}
+	var matched []Job
+	for i := range jobs {
+		if filter.Matches(&jobs[i]) {
+			matched = append(matched, jobs[i])
+		}
+	}
+	page, cursor := pageJobs(matched, filter)
+	return page, cursor, nil
+}
+
+// pageJobs sorts jobs by when they were scheduled and returns those after the
+// filter's cursor up to its limit. The filter must be valid.
+func pageJobs(jobs []Job, filter JobFilter) ([]Job, Cursor) {
+	before := func(a Job, created time.Time, id JobID) bool {
+		if !a.CreatedAt.Equal(created) {
+			return a.CreatedAt.Before(created)
+		}
+		return a.ID < id
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return before(jobs[i], jobs[j].CreatedAt, jobs[j].ID)
+	})
+	if filter.After != "" {
+		created, id, _ := filter.After.position()
+		start := sort.Search(len(jobs), func(i int) bool {
+			return !before(jobs[i], created, id) && !(jobs[i].CreatedAt.Equal(created) && jobs[i].ID == id)
+		})
+		jobs = jobs[start:]
+	}
+	if filter.Limit == 0 || len(jobs) <= filter.Limit {
+		return jobs, ""
+	}
+	jobs = jobs[:filter.Limit]
+	return jobs, newCursor(jobs[len(jobs)-1])
+}
+
+// QueryJobs returns a page of the jobs matching a filter, oldest first, and
+// the cursor for the next page
+func (s *CIServer) QueryJobs(filter JobFilter) ([]Job, Cursor, error) {
+	if err := filter.validate(); err != nil {
+		return nil, "", err
+	}
+	s.jobMutex.RLock()
+	var matched []Job
+	for _, job := range s.jobs {
+		if filter.Matches(job) {
+			matched = append(matched, *job)
+		}
+	}
+	s.jobMutex.RUnlock()
+
+	page, cursor := pageJobs(matched, filter)
+	return page, cursor, nil
+}
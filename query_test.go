@@ -0,0 +1,75 @@
+package minici
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryJobs(t *testing.T) {
+	s := NewCIServer(WithLocalAgent(false)).(*CIServer)
+	start := time.Now()
+	for _, job := range []*Job{
+		{ID: "01D", Status: JobStatusFailure, RepoURI: "repo", Labels: map[string]string{"branch": "main"}, CreatedAt: start.Add(3 * time.Minute)},
+		{ID: "01A", Status: JobStatusSuccess, RepoURI: "repo", Labels: map[string]string{"branch": "main"}, CreatedAt: start},
+		{ID: "01C", Status: JobStatusRunning, RepoURI: "other", Group: "backfill", CreatedAt: start.Add(2 * time.Minute)},
+		{ID: "01B", Status: JobStatusFailure, RepoURI: "repo", Labels: map[string]string{"branch": "dev"}, CreatedAt: start.Add(time.Minute)},
+	} {
+		s.jobs[job.ID] = job
+	}
+	ids := func(filter JobFilter) ([]JobID, Cursor) {
+		t.Helper()
+		jobs, cursor, err := s.QueryJobs(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []JobID
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return ids, cursor
+	}
+
+	for _, test := range []struct {
+		name     string
+		filter   JobFilter
+		expected []JobID
+	}{
+		{"everything", JobFilter{}, []JobID{"01A", "01B", "01C", "01D"}},
+		{"status", JobFilter{Statuses: []JobStatus{JobStatusFailure, JobStatusRunning}}, []JobID{"01B", "01C", "01D"}},
+		{"repo", JobFilter{RepoURI: "other"}, []JobID{"01C"}},
+		{"group", JobFilter{Group: "backfill"}, []JobID{"01C"}},
+		{"label", JobFilter{Labels: map[string]string{"branch": "main"}}, []JobID{"01A", "01D"}},
+		{"time range", JobFilter{CreatedAfter: start, CreatedBefore: start.Add(3 * time.Minute)}, []JobID{"01B", "01C"}},
+	} {
+		if actual, cursor := ids(test.filter); !reflect.DeepEqual(actual, test.expected) || cursor != "" {
+			t.Errorf("%s: expected %v, got %v with cursor %q", test.name, test.expected, actual, cursor)
+		}
+	}
+
+	// Pages continue from the cursor until it is empty
+	var pages [][]JobID
+	filter := JobFilter{RepoURI: "repo", Limit: 2}
+	for {
+		page, cursor := ids(filter)
+		pages = append(pages, page)
+		if cursor == "" {
+			break
+		}
+		filter.After = cursor
+	}
+	if expected := [][]JobID{{"01A", "01B"}, {"01D"}}; !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, pages)
+	}
+
+	for _, invalid := range []JobFilter{
+		{Statuses: []JobStatus{"done"}},
+		{Limit: -1},
+		{CreatedAfter: start, CreatedBefore: start},
+	} {
+		if _, _, err := s.QueryJobs(invalid); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected %+v to be invalid, got %v", invalid, err)
+		}
+	}
+}
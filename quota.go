@@ -0,0 +1,111 @@
+package minici
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQueueFull is returned when submitting a job while the server, or the
+// job's project, already has as many jobs waiting as it allows
+var ErrQueueFull = errors.New("queue is full")
+
+// ProjectQuota limits a project's share of the agents. Zero values are
+// unlimited.
+type ProjectQuota struct {
+	// MaxConcurrent limits how many of the project's jobs run at once
+	MaxConcurrent int
+	// MaxQueued limits how many of the project's jobs can wait to run
+	MaxQueued int
+}
+
+// ProjectScheduler is implemented by servers that can limit each project's
+// jobs
+type ProjectScheduler interface {
+	SetProjectQuotas(quotas map[string]ProjectQuota)
+}
+
+// WithProjectQuotas limits the jobs of each project by name
+func WithProjectQuotas(quotas map[string]ProjectQuota) Option {
+	return func(s *CIServer) {
+		s.quotas = quotas
+	}
+}
+
+// WithMaxQueuedJobs limits how many jobs can wait to run across every
+// project, so a burst of submissions is rejected with ErrQueueFull rather than
+// piling up work the server can't get through. 0 is unlimited. Interrupted
+// jobs that are requeued are always accepted.
+func WithMaxQueuedJobs(n int) Option {
+	return func(s *CIServer) {
+		s.maxQueued = n
+	}
+}
+
+// SetProjectQuotas replaces the project quotas. Jobs already waiting are kept
+// even if a project now has more than MaxQueued.
+func (s *CIServer) SetProjectQuotas(quotas map[string]ProjectQuota) {
+	s.jobMutex.Lock()
+	s.quotas = quotas
+	s.jobMutex.Unlock()
+	s.dispatch()
+}
+
+// checkQueue returns an error wrapping ErrQueueFull if the server or a
+// project has no room for another waiting job. The caller must hold jobMutex.
+func (s *CIServer) checkQueue(project string) error {
+	if s.maxQueued > 0 && len(s.waiting) >= s.maxQueued {
+		return fmt.Errorf("%w: %d jobs waiting", ErrQueueFull, len(s.waiting))
+	}
+	limit := s.quotas[project].MaxQueued
+	if limit <= 0 {
+		return nil
+	}
+	queued := 0
+	for _, waiting := range s.waiting {
+		if waiting.job.Project == project {
+			queued++
+		}
+	}
+	if queued >= limit {
+		return fmt.Errorf("%w: project %s has %d jobs waiting", ErrQueueFull, project, queued)
+	}
+	return nil
+}
+
+// runningByProject counts the jobs assigned to agents in each project. The
+// caller must hold jobMutex.
+func (s *CIServer) runningByProject() map[string]int {
+	running := map[string]int{}
+	for _, a := range s.allAgents() {
+		for id := range a.running {
+			running[s.jobs[id].Project]++
+		}
+	}
+	return running
+}
+
+// nextJob returns the index of the waiting job to start next, or -1 if none
+// can start. Projects share agents fairly: of the jobs eligible to start, the
+// oldest from the project with the fewest running jobs goes first, and
+// projects at their MaxConcurrent are skipped. During maintenance windows,
+// only urgent jobs can start. The caller must hold jobMutex.
+func (s *CIServer) nextJob(running map[string]int, eligible func(*Job) bool) int {
+	maintenance := s.maintenance.Active(s.clock.Now()) != nil
+	next := -1
+	for i, queued := range s.waiting {
+		job := queued.job
+		if blocked, failed := s.blockedBy(job); blocked || failed != nil || !eligible(job) {
+			continue
+		}
+		if maintenance && !job.Urgent {
+			continue
+		}
+		if limit := s.quotas[job.Project].MaxConcurrent; limit > 0 && running[job.Project] >= limit {
+			continue
+		}
+		if next < 0 || running[job.Project] < running[s.waiting[next].job.Project] {
+			next = i
+		}
+	}
+	return next
+}
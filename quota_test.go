@@ -0,0 +1,72 @@
+package minici
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProjectQuotas(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false), WithProjectQuotas(map[string]ProjectQuota{
+		"a": {MaxConcurrent: 1, MaxQueued: 2},
+	}))
+	pool := ci.(AgentPool)
+
+	submit := func(project string) JobID {
+		t.Helper()
+		id, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build", Project: project})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	a1, a2 := submit("a"), submit("a")
+	if _, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build", Project: "a"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected project a's queue to be full, got %v", err)
+	}
+	b1, b2, c1 := submit("b"), submit("b"), submit("c")
+
+	// Projects take turns, oldest first, and a can't run two jobs at once
+	agent := AgentInfo{Name: "box", Capacity: 10}
+	for _, expected := range []JobID{a1, b1, c1, b2} {
+		job, err := pool.ClaimJob(agent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID != expected {
+			t.Errorf("Expected job %s (%s) to be claimed, got %s (%s)", expected, ci.JobDetail(expected).Project, job.ID, job.Project)
+		}
+	}
+	if _, err := pool.ClaimJob(agent); !errors.Is(err, ErrNoJobAvailable) {
+		t.Errorf("Expected project a to be at its limit, got %v", err)
+	}
+
+	if err := pool.FinishJob("box", a1, JobResult{Status: JobStatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := pool.ClaimJob(agent); err != nil || job.ID != a2 {
+		t.Errorf("Expected a2 to be claimed once a1 finished, got %+v, %v", job, err)
+	}
+}
+
+func TestMaxQueuedJobs(t *testing.T) {
+	ci := NewCIServer(WithLocalAgent(false), WithMaxQueuedJobs(2))
+
+	first := ci.ScheduleJob("repo", "main", "build")
+	if _, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build", Project: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ci.Submit(JobSpec{RepoURI: "repo", Commit: "main", Command: "build", Project: "b"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected the queue to be full for every project, got %v", err)
+	}
+	if id := ci.ScheduleJob("repo", "main", "build"); id != "" {
+		t.Errorf("Expected no job to be scheduled while the queue is full, got %s", id)
+	}
+
+	// Claiming a job makes room for another
+	if _, err := ci.(AgentPool).ClaimJob(AgentInfo{Name: "box"}); err != nil {
+		t.Fatal(err)
+	}
+	if id := ci.ScheduleJob("repo", "main", "build"); id == "" || id == first {
+		t.Errorf("Expected a job to be scheduled once one started, got %q", id)
+	}
+}
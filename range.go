@@ -0,0 +1,37 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+var _ CommitLister = &CIServer{}
+
+// ListCommits lists the commits in a range with the server's git client, if
+// it's a CommitLister
+func (s *CIServer) ListCommits(ctx context.Context, repoURI, from, to string) ([]string, error) {
+	lister, ok := s.git.(CommitLister)
+	if !ok {
+		return nil, ErrListCommitsUnsupported
+	}
+	commits, err := lister.ListCommits(ctx, repoURI, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s..%s in %s: %w", from, to, repoURI, err)
+	}
+	return commits, nil
+}
+
+// ParseCommitRange splits a range such as v1.0.0..main into the commit it
+// starts after and the commit it ends with. Neither can start with -, so they
+// can't be taken for options by git.
+func ParseCommitRange(commits string) (from, to string, err error) {
+	from, to, ok := strings.Cut(commits, "..")
+	if !ok || from == "" || to == "" || strings.HasPrefix(to, ".") {
+		return "", "", fmt.Errorf("invalid range %q: must be from..to", commits)
+	}
+	if strings.HasPrefix(from, "-") || strings.HasPrefix(to, "-") {
+		return "", "", fmt.Errorf("invalid range %q: commits can't start with -", commits)
+	}
+	return from, to, nil
+}
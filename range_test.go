@@ -0,0 +1,14 @@
+package minici
+
+import "testing"
+
+func TestParseCommitRange(t *testing.T) {
+	if from, to, err := ParseCommitRange("v1.0.0..main"); err != nil || from != "v1.0.0" || to != "main" {
+		t.Errorf("Expected v1.0.0 and main, got %q, %q, %v", from, to, err)
+	}
+	for _, commits := range []string{"main", "..main", "v1.0.0..", "v1.0.0...main", "--output=x..main", "v1.0.0..-main", "-n1..main"} {
+		if _, _, err := ParseCommitRange(commits); err == nil {
+			t.Errorf("Expected %q to be rejected", commits)
+		}
+	}
+}
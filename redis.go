@@ -0,0 +1,509 @@
+package minici
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisClient is a connection to a Redis server. Commands are sent one at a
+// time, so blocking commands need a client of their own.
+type RedisClient struct {
+	// Addr is the server's host and port, defaults to localhost:6379
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	// TLS connects to the server over TLS
+	TLS bool
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// ParseRedisURL configures a client from a URL such as
+// redis://:password@host:6379/0, or rediss:// to connect over TLS
+func ParseRedisURL(raw string) (*RedisClient, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL %q, expected redis:// or rediss://", raw)
+	}
+	client := &RedisClient{Addr: u.Host, TLS: u.Scheme == "rediss"}
+	if u.User != nil {
+		client.Username = u.User.Username()
+		client.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		client.DB, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return client, nil
+}
+
+// RedisClusterStore shares jobs between clustered servers through a Redis
+// server. Jobs are kept in the <prefix>jobs hash, each job's logs in a
+// <prefix>logs:<job id> list, claims in <prefix>claims:<key> and heartbeats in
+// <prefix>instances:<name>, which expire.
+type RedisClusterStore struct {
+	Client *RedisClient
+	// Prefix is prepended to every key, defaults to "minici:"
+	Prefix string
+	// Keyring encrypts jobs and log lines before they're saved, if set. Data
+	// saved before it was set can still be read.
+	Keyring *Keyring
+}
+
+var _ ClusterStore = &RedisClusterStore{}
+
+func (r *RedisClusterStore) key(parts ...string) string {
+	prefix := r.Prefix
+	if prefix == "" {
+		prefix = "minici:"
+	}
+	return prefix + strings.Join(parts, "")
+}
+
+func (r *RedisClusterStore) SaveJob(ctx context.Context, job Job) error {
+	content, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if content, err = r.Keyring.encryptIfEnabled(content); err != nil {
+		return err
+	}
+	_, err = r.Client.do(ctx, "HSET", r.key("jobs"), string(job.ID), string(content))
+	return err
+}
+
+func (r *RedisClusterStore) Jobs(ctx context.Context) ([]Job, error) {
+	reply, err := r.Client.do(ctx, "HGETALL", r.key("jobs"))
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]any)
+	var jobs []Job
+	for i := 1; i < len(fields); i += 2 {
+		field, _ := fields[i].(string)
+		content, err := r.Keyring.decryptIfEncrypted([]byte(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid job %v: %w", fields[i-1], err)
+		}
+		var job Job
+		if err := json.Unmarshal(content, &job); err != nil {
+			return nil, fmt.Errorf("invalid job %v: %w", fields[i-1], err)
+		}
+		jobs = append(jobs, job)
+	}
+	// IDs are ULIDs, so sort in the order jobs were created
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
+
+func (r *RedisClusterStore) AppendLogs(ctx context.Context, id JobID, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	args := []string{"RPUSH", r.key("logs:", string(id))}
+	for _, line := range lines {
+		stored, err := r.Keyring.encryptIfEnabled([]byte(line))
+		if err != nil {
+			return err
+		}
+		args = append(args, string(stored))
+	}
+	_, err := r.Client.do(ctx, args...)
+	return err
+}
+
+func (r *RedisClusterStore) Logs(ctx context.Context, id JobID, from int) ([]string, error) {
+	reply, err := r.Client.do(ctx, "LRANGE", r.key("logs:", string(id)), strconv.Itoa(max(from, 0)), "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]any)
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		stored, _ := item.(string)
+		line, err := r.Keyring.decryptIfEncrypted([]byte(stored))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log line of job %s: %w", id, err)
+		}
+		lines = append(lines, string(line))
+	}
+	return lines, nil
+}
+
+func (r *RedisClusterStore) Claim(ctx context.Context, key, owner string) (string, error) {
+	if _, err := r.Client.do(ctx, "SET", r.key("claims:", key), owner, "NX"); err != nil {
+		return "", err
+	}
+	return r.Claimant(ctx, key)
+}
+
+func (r *RedisClusterStore) Claimant(ctx context.Context, key string) (string, error) {
+	reply, err := r.Client.do(ctx, "GET", r.key("claims:", key))
+	if err != nil {
+		return "", err
+	}
+	owner, _ := reply.(string)
+	return owner, nil
+}
+
+func (r *RedisClusterStore) Heartbeat(ctx context.Context, instance string, ttl time.Duration) error {
+	_, err := r.Client.do(ctx, "SET", r.key("instances:", instance), "alive", "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+func (r *RedisClusterStore) Alive(ctx context.Context, instance string) (bool, error) {
+	reply, err := r.Client.do(ctx, "EXISTS", r.key("instances:", instance))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+// redisError is an error reply from the server, which leaves the connection usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends a command and returns its reply: a string, an int64, a []any, or
+// nil. Commands are sent one at a time over a single connection, which is
+// reopened if it fails.
+func (r *RedisClient) do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		if err := r.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.roundTrip(ctx, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials the server, then authenticates and selects the database
+func (r *RedisClient) connect(ctx context.Context) error {
+	addr := r.Addr
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+	if r.TLS {
+		dialer = &tls.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.Password != "" {
+		if r.Username != "" {
+			setup = append(setup, []string{"AUTH", r.Username, r.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", r.Password})
+		}
+	}
+	if r.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.DB)})
+	}
+	for _, args := range setup {
+		if _, err := r.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			r.conn = nil
+			return fmt.Errorf("failed to %s: %w", strings.ToLower(args[0]), err)
+		}
+	}
+	return nil
+}
+
+func (r *RedisClient) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(clusterCallTimeout)
+	}
+	r.conn.SetDeadline(deadline)
+	if _, err := r.conn.Write(encodeRedisCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRedisReply(r.reader)
+}
+
+// encodeRedisCommand encodes a command as an array of bulk strings
+func encodeRedisCommand(args []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// readRedisReply reads a reply in the Redis protocol
+func readRedisReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("invalid Redis reply")
+	}
+	kind, value := line[0], line[1:]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis reply %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		content := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, err
+		}
+		return string(content[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis reply %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, count)
+		for range count {
+			item, err := readRedisReply(reader)
+			var replyErr redisError
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid Redis reply %q", line)
+}
+
+// RedisJobQueue is a JobQueue kept in a Redis stream and read through a
+// consumer group. Deliveries that are neither acknowledged nor extended for
+// RedeliverAfter are claimed by the next consumer to receive a job. Receive
+// blocks its connection, so the queue needs a client it doesn't share.
+type RedisJobQueue struct {
+	Client *RedisClient
+	// Stream is the stream's key, defaults to "minici:queue"
+	Stream string
+	// Group is the consumer group servers read through, defaults to "minici"
+	Group string
+	// RedeliverAfter defaults to a minute
+	RedeliverAfter time.Duration
+
+	mu      sync.Mutex
+	created bool
+	// cursor is where the next search for timed out deliveries starts
+	cursor string
+}
+
+var _ JobQueue = &RedisJobQueue{}
+
+func (r *RedisJobQueue) names() (stream, group string) {
+	stream, group = r.Stream, r.Group
+	if stream == "" {
+		stream = "minici:queue"
+	}
+	if group == "" {
+		group = "minici"
+	}
+	return stream, group
+}
+
+// createGroup creates the stream and consumer group the first time they're
+// needed, reading the stream from its start
+func (r *RedisJobQueue) createGroup(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.created {
+		return nil
+	}
+	stream, group := r.names()
+	_, err := r.Client.do(ctx, "XGROUP", "CREATE", stream, group, "0", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "redis: BUSYGROUP") {
+		return err
+	}
+	r.created = true
+	return nil
+}
+
+func (r *RedisJobQueue) Publish(ctx context.Context, job Job) error {
+	if err := r.createGroup(ctx); err != nil {
+		return err
+	}
+	content, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	stream, _ := r.names()
+	_, err = r.Client.do(ctx, "XADD", stream, "*", "job", string(content))
+	return err
+}
+
+func (r *RedisJobQueue) Receive(ctx context.Context, consumer string) (JobDelivery, error) {
+	if err := r.createGroup(ctx); err != nil {
+		return JobDelivery{}, err
+	}
+	stream, group := r.names()
+	redeliverAfter := r.RedeliverAfter
+	if redeliverAfter <= 0 {
+		redeliverAfter = time.Minute
+	}
+
+	r.mu.Lock()
+	cursor := r.cursor
+	r.mu.Unlock()
+	if cursor == "" {
+		cursor = "0-0"
+	}
+	reply, err := r.Client.do(ctx, "XAUTOCLAIM", stream, group, consumer, strconv.FormatInt(redeliverAfter.Milliseconds(), 10), cursor, "COUNT", "1")
+	if err != nil {
+		return JobDelivery{}, err
+	}
+	next, deliveries, err := parseAutoClaim(reply)
+	if err != nil {
+		return JobDelivery{}, err
+	}
+	r.mu.Lock()
+	r.cursor = next
+	r.mu.Unlock()
+
+	if len(deliveries) == 0 {
+		// Wait for a new job for less time than a call may take
+		reply, err := r.Client.do(ctx, "XREADGROUP", "GROUP", group, consumer, "COUNT", "1", "BLOCK", "5000", "STREAMS", stream, ">")
+		if err != nil {
+			return JobDelivery{}, err
+		}
+		deliveries, err = parseStreamRead(reply)
+		if err != nil {
+			return JobDelivery{}, err
+		}
+	}
+	if len(deliveries) == 0 {
+		return JobDelivery{}, ErrNoJobAvailable
+	}
+	return deliveries[0], nil
+}
+
+func (r *RedisJobQueue) Extend(ctx context.Context, consumer string, deliveries []JobDelivery) error {
+	stream, group := r.names()
+	args := []string{"XCLAIM", stream, group, consumer, "0"}
+	for _, delivery := range deliveries {
+		args = append(args, delivery.ID)
+	}
+	_, err := r.Client.do(ctx, append(args, "JUSTID")...)
+	return err
+}
+
+func (r *RedisJobQueue) Ack(ctx context.Context, delivery JobDelivery) error {
+	stream, group := r.names()
+	if _, err := r.Client.do(ctx, "XACK", stream, group, delivery.ID); err != nil {
+		return err
+	}
+	_, err := r.Client.do(ctx, "XDEL", stream, delivery.ID)
+	return err
+}
+
+// parseStreamRead parses the reply to XREADGROUP, which is nil if no entries
+// arrived, or each stream read with its entries
+func parseStreamRead(reply any) ([]JobDelivery, error) {
+	streams, _ := reply.([]any)
+	var deliveries []JobDelivery
+	for _, stream := range streams {
+		parts, _ := stream.([]any)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid stream reply %v", stream)
+		}
+		entries, err := parseStreamEntries(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, entries...)
+	}
+	return deliveries, nil
+}
+
+// parseAutoClaim parses the reply to XAUTOCLAIM: the cursor to continue
+// from, the entries claimed, then on newer servers the IDs of deleted entries
+func parseAutoClaim(reply any) (string, []JobDelivery, error) {
+	parts, _ := reply.([]any)
+	if len(parts) < 2 {
+		return "", nil, fmt.Errorf("invalid XAUTOCLAIM reply %v", reply)
+	}
+	cursor, _ := parts[0].(string)
+	deliveries, err := parseStreamEntries(parts[1])
+	return cursor, deliveries, err
+}
+
+// parseStreamEntries parses stream entries, each an ID and its fields. The
+// fields of entries deleted while pending are nil, so they're skipped.
+func parseStreamEntries(reply any) ([]JobDelivery, error) {
+	entries, _ := reply.([]any)
+	var deliveries []JobDelivery
+	for _, entry := range entries {
+		parts, _ := entry.([]any)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid stream entry %v", entry)
+		}
+		id, _ := parts[0].(string)
+		fields, _ := parts[1].([]any)
+		for i := 1; i < len(fields); i += 2 {
+			if fields[i-1] != "job" {
+				continue
+			}
+			content, _ := fields[i].(string)
+			delivery := JobDelivery{ID: id}
+			if err := json.Unmarshal([]byte(content), &delivery.Job); err != nil {
+				return nil, fmt.Errorf("invalid job in entry %s: %w", id, err)
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,545 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile is the name of the optional config file at the root of a repository
+const RepoConfigFile = ".minici.yml"
+
+// RepoConfig holds settings a repository provides for its own jobs
+type RepoConfig struct {
+	// Include lists configs from the same repo or the template store to
+	// merge underneath this one, so repos can share their settings. Later
+	// includes take precedence over earlier ones, and this config over all
+	// of them.
+	Include []IncludeConfig `yaml:"include"`
+	// Image is the container image to run commands in
+	Image string `yaml:"image"`
+	// Env sets environment variables for every job
+	Env map[string]string `yaml:"env"`
+	// Inputs declare the values jobs can be given when they're submitted,
+	// such as the version to deploy. They're passed to the job's commands
+	// as environment variables, see InputEnv.
+	Inputs map[string]InputConfig `yaml:"inputs"`
+	// Setup lists commands that provision the job's environment, such as
+	// "asdf install" or a bootstrap script. They run in order before the
+	// job's command, with the same image and env, and the job fails if any
+	// of them do.
+	Setup []string `yaml:"setup"`
+	// Artifacts are patterns for files to keep once the job's command has run,
+	// such as "dist/*" or "coverage.out". Matching a directory keeps every file
+	// inside it.
+	Artifacts []string `yaml:"artifacts"`
+	// Cache lists directories to restore before the job runs, and save once
+	// it has succeeded
+	Cache []CacheConfig `yaml:"cache"`
+	// Steps run in order after the job's command, each if its condition
+	// holds, so one config can cover pull requests, branches and releases
+	Steps []StepConfig `yaml:"steps"`
+	// OnFailure hooks run after the steps if the job's setup commands,
+	// command or steps failed, such as to collect diagnostics
+	OnFailure []StepConfig `yaml:"on_failure"`
+	// Always hooks run after the steps and any on failure hooks, whatever
+	// the job's outcome, such as to upload reports or clean up. Hooks
+	// failing doesn't fail the job, their outcomes are recorded separately.
+	Always []StepConfig `yaml:"always"`
+	// Images are built and pushed once the job's command has succeeded
+	Images []ImageConfig `yaml:"images"`
+	// TestReports are patterns for JUnit XML or go test -json files to read
+	// test results from once the job's command has run
+	TestReports []string `yaml:"test_reports"`
+	// Coverage is the Go coverprofile, Cobertura XML or lcov file to read the
+	// job's coverage from once its command has run
+	Coverage string `yaml:"coverage"`
+}
+
+// LoadRepoConfig reads the repo config from a checked out repository.
+// A missing file results in an empty config. Templates can't be included, see
+// LoadRepoConfigWithTemplates.
+func LoadRepoConfig(dir string) (RepoConfig, error) {
+	return LoadRepoConfigWithTemplates(context.Background(), dir, nil)
+}
+
+// LoadRepoConfigWithTemplates reads the repo config from a checked out
+// repository, along with the configs it includes. Templates are read from
+// templates, which may be nil if there are none.
+func LoadRepoConfigWithTemplates(ctx context.Context, dir string, templates TemplateStore) (RepoConfig, error) {
+	content, err := os.ReadFile(filepath.Join(dir, RepoConfigFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return RepoConfig{}, nil
+	}
+	if err != nil {
+		return RepoConfig{}, fmt.Errorf("failed to read %s: %w", RepoConfigFile, err)
+	}
+	loader := configLoader{dir: dir, templates: templates}
+	return loader.load(ctx, RepoConfigFile, content, nil, nil, false)
+}
+
+// Apply fills in a job spec with the repo's settings. Settings in the spec
+// take precedence.
+func (c RepoConfig) Apply(spec JobSpec) JobSpec {
+	if spec.Image == "" {
+		spec.Image = c.Image
+	}
+	if len(c.Env) > 0 {
+		env := make(map[string]string, len(c.Env)+len(spec.Env))
+		for key, value := range c.Env {
+			env[key] = value
+		}
+		for key, value := range spec.Env {
+			env[key] = value
+		}
+		spec.Env = env
+	}
+	return spec
+}
+
+// ConfigProblem is a mistake found in a repo config by ValidateRepoConfig
+type ConfigProblem struct {
+	Severity Severity
+	// Line and Column are where the problem is in the file, counting from 1,
+	// or 0 if it isn't known
+	Line   int
+	Column int
+	// Field is the path of the setting with the problem, such as
+	// "images[0].tags[1]", or empty for problems with the whole file
+	Field   string
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "%d:%d: ", p.Line, p.Column)
+	}
+	b.WriteString(string(p.Severity) + ": ")
+	if p.Field != "" {
+		b.WriteString(p.Field + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// yamlErrorLine finds the line number in yaml's error messages
+var yamlErrorLine = regexp.MustCompile(`line (\d+): `)
+
+// ValidateRepoConfig checks the content of a repo config without running
+// anything, returning its problems in the order they appear. Errors are
+// mistakes that would fail loading the config or the jobs using it, such as
+// invalid YAML or an image without a name. Warnings are probably mistakes
+// that would otherwise be silently ignored, such as misspelled fields.
+func ValidateRepoConfig(content []byte) []ConfigProblem {
+	return validateConfig(content, true)
+}
+
+// validateConfig checks a config, substituting the parameters it declares
+// if params is set
+func validateConfig(content []byte, params bool) []ConfigProblem {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return yamlProblems(err)
+	}
+	// An empty file is a valid, empty config
+	if len(root.Content) == 0 {
+		return nil
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return []ConfigProblem{{Severity: SeverityError, Line: doc.Line, Column: doc.Column, Message: "config must be a mapping of settings"}}
+	}
+
+	var c configChecker
+	if params {
+		c.params(doc)
+	}
+	var config RepoConfig
+	if err := doc.Decode(&config); err != nil {
+		c.problems = yamlProblems(err)
+	}
+	c.check(doc)
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.problems
+}
+
+// yamlProblems turns yaml's errors into problems, one for each line it
+// reports
+func yamlProblems(err error) []ConfigProblem {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+	problems := make([]ConfigProblem, 0, len(messages))
+	for _, message := range messages {
+		problem := ConfigProblem{Severity: SeverityError, Message: strings.TrimPrefix(message, "yaml: ")}
+		if match := yamlErrorLine.FindStringSubmatchIndex(problem.Message); match != nil {
+			problem.Line, _ = strconv.Atoi(problem.Message[match[2]:match[3]])
+			problem.Message = problem.Message[:match[0]] + problem.Message[match[1]:]
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// configChecker collects the problems found in a repo config's YAML nodes.
+// Values of the wrong type are reported by decoding, so they're skipped here.
+type configChecker struct {
+	problems []ConfigProblem
+}
+
+func (c *configChecker) add(severity Severity, node *yaml.Node, field, format string, args ...any) {
+	c.problems = append(c.problems, ConfigProblem{
+		Severity: severity,
+		Line:     node.Line,
+		Column:   node.Column,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *configChecker) check(doc *yaml.Node) {
+	c.fields(doc, "", reflect.TypeOf(templateFile{}))
+
+	for i, include := range yamlItems(mappingValue(doc, "include")) {
+		field := fmt.Sprintf("include[%d]", i)
+		if include.Kind != yaml.MappingNode {
+			continue
+		}
+		c.fields(include, field, reflect.TypeOf(IncludeConfig{}))
+		path, template := mappingValue(include, "path"), mappingValue(include, "template")
+		switch {
+		case path != nil && template != nil:
+			c.add(SeverityError, include, field, "include can't have both a path and a template")
+		case path == nil && template == nil:
+			c.add(SeverityError, include, field, "include needs a path or a template")
+		case path != nil:
+			c.path(path, field+".path")
+		case !templateNamePattern.MatchString(template.Value):
+			c.add(SeverityError, template, field+".template", "invalid template name %q", template.Value)
+		}
+	}
+
+	if env := mappingValue(doc, "env"); env != nil && env.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(env.Content); i += 2 {
+			key := env.Content[i]
+			if key.Value == "" || strings.ContainsAny(key.Value, "= ") {
+				c.add(SeverityError, key, "env", "invalid environment variable name %q", key.Value)
+			}
+		}
+	}
+	if inputs := mappingValue(doc, "inputs"); inputs != nil && inputs.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(inputs.Content); i += 2 {
+			key, value := inputs.Content[i], inputs.Content[i+1]
+			field := "inputs." + key.Value
+			if !inputNamePattern.MatchString(key.Value) {
+				c.add(SeverityError, key, "inputs", "invalid input name %q", key.Value)
+			}
+			if value.Kind != yaml.MappingNode {
+				continue
+			}
+			c.fields(value, field, reflect.TypeOf(InputConfig{}))
+			var input InputConfig
+			if value.Decode(&input) != nil {
+				continue
+			}
+			if err := input.check(); err != nil {
+				c.add(SeverityError, value, field, "%s", err)
+			}
+		}
+	}
+	for i, command := range yamlScalars(mappingValue(doc, "setup")) {
+		if strings.TrimSpace(command.Value) == "" {
+			c.add(SeverityWarning, command, fmt.Sprintf("setup[%d]", i), "setup command is empty")
+		}
+	}
+	for _, field := range []string{"artifacts", "test_reports"} {
+		for i, pattern := range yamlScalars(mappingValue(doc, field)) {
+			c.pattern(pattern, fmt.Sprintf("%s[%d]", field, i))
+		}
+	}
+	if coverage := mappingValue(doc, "coverage"); coverage != nil && coverage.Kind == yaml.ScalarNode && coverage.Value != "" {
+		c.path(coverage, "coverage")
+	}
+
+	for i, cache := range yamlItems(mappingValue(doc, "cache")) {
+		field := fmt.Sprintf("cache[%d]", i)
+		if cache.Kind != yaml.MappingNode {
+			continue
+		}
+		c.fields(cache, field, reflect.TypeOf(CacheConfig{}))
+		paths := yamlScalars(mappingValue(cache, "paths"))
+		if len(paths) == 0 {
+			c.add(SeverityError, cache, field, "cache needs at least one path")
+		}
+		for j, path := range paths {
+			c.path(path, fmt.Sprintf("%s.paths[%d]", field, j))
+		}
+		for j, path := range yamlScalars(mappingValue(cache, "key_files")) {
+			c.path(path, fmt.Sprintf("%s.key_files[%d]", field, j))
+		}
+	}
+
+	// Hooks are checked like steps, and may read the steps' outcomes
+	names := map[string]bool{}
+	for _, key := range []string{"steps", "on_failure", "always"} {
+		c.steps(mappingValue(doc, key), key, names)
+	}
+
+	for i, image := range yamlItems(mappingValue(doc, "images")) {
+		field := fmt.Sprintf("images[%d]", i)
+		if image.Kind != yaml.MappingNode {
+			continue
+		}
+		c.fields(image, field, reflect.TypeOf(ImageConfig{}))
+		name := mappingValue(image, "image")
+		switch {
+		case name == nil || name.Value == "":
+			c.add(SeverityError, image, field, "image needs a name to push to")
+		case strings.ContainsAny(name.Value, "@ "):
+			c.add(SeverityError, name, field+".image", "invalid image %q", name.Value)
+		}
+		for _, key := range []string{"context", "dockerfile"} {
+			if path := mappingValue(image, key); path != nil && path.Kind == yaml.ScalarNode && path.Value != "" {
+				c.path(path, field+"."+key)
+			}
+		}
+		for j, tag := range yamlScalars(mappingValue(image, "tags")) {
+			if !imageTagPattern.MatchString(tag.Value) {
+				c.add(SeverityError, tag, fmt.Sprintf("%s.tags[%d]", field, j), "invalid tag %q", tag.Value)
+			}
+		}
+	}
+}
+
+// steps checks a list of steps or hooks, given the names of the steps before
+// them
+func (c *configChecker) steps(node *yaml.Node, key string, names map[string]bool) {
+	for i, step := range yamlItems(node) {
+		field := fmt.Sprintf("%s[%d]", key, i)
+		if step.Kind != yaml.MappingNode {
+			continue
+		}
+		parallel := mappingValue(step, "parallel")
+		if parallel == nil {
+			c.step(step, field, names, names, true)
+			continue
+		}
+		switch {
+		case key != "steps":
+			c.add(SeverityError, parallel, field+".parallel", "parallel is only supported in steps")
+		case mappingValue(step, "run") != nil:
+			c.add(SeverityError, step, field, "step can't have both run and parallel")
+		case len(yamlItems(parallel)) == 0:
+			c.add(SeverityError, step, field, "step has nothing to run")
+		}
+		// The steps of a parallel group can't read each other's outcomes
+		before := maps.Clone(names)
+		c.step(step, field, names, names, false)
+		for j, child := range yamlItems(parallel) {
+			childField := fmt.Sprintf("%s.parallel[%d]", field, j)
+			if child.Kind != yaml.MappingNode {
+				continue
+			}
+			nested := mappingValue(child, "parallel")
+			if nested != nil {
+				c.add(SeverityError, nested, childField+".parallel", "parallel steps can't be nested")
+			}
+			c.step(child, childField, names, before, nested == nil)
+		}
+	}
+}
+
+// step checks a step's fields, name and condition. The name must not be in
+// names, the condition may only read the outcomes of steps in reads, and
+// runs says whether the step needs a command.
+func (c *configChecker) step(step *yaml.Node, field string, names, reads map[string]bool, runs bool) {
+	c.fields(step, field, reflect.TypeOf(StepConfig{}))
+	if run := mappingValue(step, "run"); runs && (run == nil || strings.TrimSpace(run.Value) == "") {
+		c.add(SeverityError, step, field, "step has nothing to run")
+	}
+	name := mappingValue(step, "name")
+	if name != nil && name.Value != "" {
+		switch {
+		case !stepNamePattern.MatchString(name.Value):
+			c.add(SeverityError, name, field+".name", "invalid step name %q: must only contain letters, digits, _ and -", name.Value)
+		case names[name.Value]:
+			c.add(SeverityError, name, field+".name", "step name %q is used more than once", name.Value)
+		}
+	}
+	if source := mappingValue(step, "if"); source != nil && source.Kind == yaml.ScalarNode && source.Value != "" {
+		cond, err := parseCondition(source.Value)
+		if err != nil {
+			c.add(SeverityError, source, field+".if", "invalid condition: %v", err)
+		} else {
+			for _, name := range cond.steps {
+				if !reads[name] {
+					c.add(SeverityError, source, field+".if", "condition reads the outcome of %q, which isn't an earlier step", name)
+				}
+			}
+		}
+	}
+	if name != nil {
+		names[name.Value] = true
+	}
+}
+
+// params checks the parameters a template declares, and substitutes them
+// into the rest of it so the other checks see real values. Parameters without
+// a default are replaced with their names.
+func (c *configChecker) params(doc *yaml.Node) {
+	values := map[string]string{}
+	if params := mappingValue(doc, "params"); params != nil && params.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(params.Content); i += 2 {
+			key, value := params.Content[i], params.Content[i+1]
+			if !paramNamePattern.MatchString(key.Value) {
+				c.add(SeverityError, key, "params", "invalid parameter name %q: must only contain letters, digits and _", key.Value)
+			}
+			values[key.Value] = value.Value
+			if value.Tag == "!!null" {
+				values[key.Value] = key.Value
+			}
+		}
+	}
+	substituteParams(doc, values, func(node *yaml.Node, name string) {
+		c.add(SeverityError, node, "", "unknown parameter %q", name)
+	})
+}
+
+// fields warns about keys of a mapping that aren't fields of its type, as
+// they're ignored when the config is loaded
+func (c *configChecker) fields(node *yaml.Node, field string, typ reflect.Type) {
+	known := yamlFieldNames(typ)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		found := false
+		for _, name := range known {
+			found = found || key.Value == name
+		}
+		if found {
+			continue
+		}
+		message := fmt.Sprintf("unknown field %q is ignored", key.Value)
+		if suggestion := closestField(key.Value, known); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		c.add(SeverityWarning, key, field, "%s", message)
+	}
+}
+
+// yamlFieldNames returns the keys a struct is decoded from
+func yamlFieldNames(typ reflect.Type) []string {
+	var names []string
+	for i := range typ.NumField() {
+		name, options, _ := strings.Cut(typ.Field(i).Tag.Get("yaml"), ",")
+		if options == "inline" {
+			// Inlined structs' fields are the mapping's own
+			names = append(names, yamlFieldNames(typ.Field(i).Type)...)
+		} else if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// path reports paths that aren't within the repository
+func (c *configChecker) path(node *yaml.Node, field string) {
+	if !filepath.IsLocal(filepath.FromSlash(node.Value)) {
+		c.add(SeverityError, node, field, "invalid path %q: must be relative to the repository root", node.Value)
+	}
+}
+
+// pattern reports glob patterns that are invalid or match outside the
+// repository
+func (c *configChecker) pattern(node *yaml.Node, field string) {
+	if !filepath.IsLocal(filepath.FromSlash(node.Value)) {
+		c.add(SeverityError, node, field, "invalid pattern %q: must be relative to the repository root", node.Value)
+		return
+	}
+	if _, err := filepath.Match(node.Value, ""); err != nil {
+		c.add(SeverityError, node, field, "invalid pattern %q: %v", node.Value, err)
+	}
+}
+
+// mappingValue returns the value of a key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlItems returns the items of a sequence node
+func yamlItems(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+// yamlScalars returns the items of a sequence node, or nil if any aren't scalars
+// and so fail decoding
+func yamlScalars(node *yaml.Node) []*yaml.Node {
+	for _, item := range yamlItems(node) {
+		if item.Kind != yaml.ScalarNode {
+			return nil
+		}
+	}
+	return yamlItems(node)
+}
+
+// closestField returns the known field within two edits of name, to suggest
+// for misspellings, or an empty string if there isn't one
+func closestField(name string, known []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range known {
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,210 @@
+package minici
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRepoConfig(t *testing.T) {
+	valid := `image: golang:1.24
+env:
+  CGO_ENABLED: "0"
+setup:
+  - go mod download
+artifacts:
+  - dist/*
+cache:
+  - paths: [.cache/go-build]
+    key_files: [go.sum]
+images:
+  - image: registry.example.com/app
+    tags: [latest]
+test_reports: [report.xml]
+coverage: coverage.out
+steps:
+  - name: deploy
+    run: ./deploy.sh
+    if: branch == 'main' && changed('deploy/**')
+  - run: ./notify.sh
+    if: failure() || steps.deploy.outcome == 'failure'
+`
+	if problems := ValidateRepoConfig([]byte(valid)); len(problems) != 0 {
+		t.Errorf("Expected a valid config, got %v", problems)
+	}
+	if problems := ValidateRepoConfig(nil); len(problems) != 0 {
+		t.Errorf("Expected an empty config to be valid, got %v", problems)
+	}
+
+	content := `image: golang:1.24
+imags:
+  - image: app
+setup: ["  "]
+artifacts: [../outside]
+cache:
+  - key: deps
+images:
+  - dockerfile: /etc/Dockerfile
+    tags: ["bad tag"]
+test_reports: ["report[.xml"]
+`
+	var got []string
+	for _, problem := range ValidateRepoConfig([]byte(content)) {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		`2:1: warning: unknown field "imags" is ignored, did you mean "image"?`,
+		`4:9: warning: setup[0]: setup command is empty`,
+		`5:13: error: artifacts[0]: invalid pattern "../outside": must be relative to the repository root`,
+		`7:5: error: cache[0]: cache needs at least one path`,
+		`9:5: error: images[0]: image needs a name to push to`,
+		`9:17: error: images[0].dockerfile: invalid path "/etc/Dockerfile": must be relative to the repository root`,
+		`10:12: error: images[0].tags[0]: invalid tag "bad tag"`,
+		`11:16: error: test_reports[0]: invalid pattern "report[.xml": syntax error in pattern`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestValidateRepoConfigYAMLErrors(t *testing.T) {
+	problems := ValidateRepoConfig([]byte("image: [\n"))
+	if len(problems) != 1 || problems[0].Severity != SeverityError || problems[0].Line == 0 {
+		t.Errorf("Expected a syntax error with its line, got %v", problems)
+	}
+
+	problems = ValidateRepoConfig([]byte("image: golang\nsetup: make\n"))
+	if len(problems) != 1 || problems[0].Line != 2 || !strings.Contains(problems[0].Message, "cannot unmarshal") {
+		t.Errorf("Expected a type error on line 2, got %v", problems)
+	}
+
+	problems = ValidateRepoConfig([]byte("- image: golang\n"))
+	if len(problems) != 1 || problems[0].Message != "config must be a mapping of settings" {
+		t.Errorf("Expected an error for a config that isn't a mapping, got %v", problems)
+	}
+}
+
+func TestValidateRepoConfigSteps(t *testing.T) {
+	content := `steps:
+  - name: build
+    run: make
+  - name: build
+    run: make again
+    iff: always()
+  - if: steps.later.outcome == 'success'
+  - name: later
+    run: ./later.sh
+    if: branch = 'main'
+`
+	var got []string
+	for _, problem := range ValidateRepoConfig([]byte(content)) {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		`4:11: error: steps[1].name: step name "build" is used more than once`,
+		`6:5: warning: steps[1]: unknown field "iff" is ignored, did you mean "if"?`,
+		`7:5: error: steps[2]: step has nothing to run`,
+		`7:9: error: steps[2].if: condition reads the outcome of "later", which isn't an earlier step`,
+		`10:9: error: steps[3].if: invalid condition: unexpected '='`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestValidateRepoConfigHooks(t *testing.T) {
+	content := `steps:
+  - name: build
+    run: make
+on_failure:
+  - name: build
+    run: ./collect.sh
+  - run: ./report.sh
+    if: steps.build.outcome == 'failure' && steps.upload.outcome == 'success'
+always:
+  - name: upload
+    run: ./upload.sh
+  - name: cleanup
+`
+	var got []string
+	for _, problem := range ValidateRepoConfig([]byte(content)) {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		`5:11: error: on_failure[0].name: step name "build" is used more than once`,
+		`8:9: error: on_failure[1].if: condition reads the outcome of "upload", which isn't an earlier step`,
+		`12:5: error: always[1]: step has nothing to run`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestValidateRepoConfigParallel(t *testing.T) {
+	content := `steps:
+  - name: build
+    run: make
+  - name: checks
+    run: make check
+    parallel:
+      - name: lint
+        run: make lint
+      - name: test
+        run: make test
+        if: steps.build.outcome == 'success' && steps.lint.outcome == 'success'
+      - parallel:
+          - run: make vet
+  - run: ./deploy.sh
+    if: steps.checks.outcome == 'success' && steps.test.outcome == 'success'
+always:
+  - parallel:
+      - run: ./upload.sh
+`
+	var got []string
+	for _, problem := range ValidateRepoConfig([]byte(content)) {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		`4:5: error: steps[1]: step can't have both run and parallel`,
+		`11:13: error: steps[1].parallel[1].if: condition reads the outcome of "lint", which isn't an earlier step`,
+		`13:11: error: steps[1].parallel[2].parallel: parallel steps can't be nested`,
+		`18:7: error: always[0].parallel: parallel is only supported in steps`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestValidateRepoConfigIncludes(t *testing.T) {
+	content := `params:
+  registry: ~
+  go-version: "1.24"
+include:
+  - template: go
+    with:
+      version: ${{ go_version }}
+  - path: ../shared.yml
+  - template: go
+    path: ci/base.yml
+  - with: {}
+images:
+  - image: ${{ registry }}/app
+    tags:
+      - v${{ version }}
+`
+	var got []string
+	for _, problem := range ValidateRepoConfig([]byte(content)) {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		`3:3: error: params: invalid parameter name "go-version": must only contain letters, digits and _`,
+		`7:16: error: unknown parameter "go_version"`,
+		`8:11: error: include[1].path: invalid path "../shared.yml": must be relative to the repository root`,
+		`9:5: error: include[2]: include can't have both a path and a template`,
+		`11:5: error: include[3]: include needs a path or a template`,
+		`15:9: error: unknown parameter "version"`,
+		`15:9: error: images[0].tags[0]: invalid tag "v${{ version }}"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,194 @@
+package minici
+
+import (
+	"math"
+	"time"
+)
+
+// StatsCollector is implemented by CI servers that keep statistics about each
+// repo's builds as jobs finish
+type StatsCollector interface {
+	RepoStats(query StatsQuery) []RepoStats
+}
+
+// StatsRetention is the longest window statistics are kept for
+const StatsRetention = 90 * 24 * time.Hour
+
+// DefaultStatsWindows are used when a StatsQuery has no windows
+var DefaultStatsWindows = []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+// StatsQuery describes the statistics to return for a repo
+type StatsQuery struct {
+	RepoURI string
+	// Project limits the statistics to one project's jobs if set
+	Project string
+	// Windows are how far back to summarize jobs, rounded up to whole hours
+	// and at most StatsRetention. Defaults to DefaultStatsWindows.
+	Windows []time.Duration
+}
+
+// RepoStats summarizes a repo's jobs that succeeded or failed within a window.
+// Cancelled and unfinished jobs are ignored.
+type RepoStats struct {
+	Window time.Duration
+
+	Total     int
+	Succeeded int
+	Failed    int
+
+	// Durations are accurate to within 10%, as they are counted in
+	// exponentially sized buckets rather than kept for every job
+	P50 time.Duration
+	P90 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// SuccessRate returns the fraction of jobs in the window that succeeded, or 0 if there were none
+func (s RepoStats) SuccessRate() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Succeeded) / float64(s.Total)
+}
+
+// RepoStats returns the statistics for a repo over each window
+func (s *CIServer) RepoStats(query StatsQuery) []RepoStats {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	return s.repoStats.query(query, s.clock.Now())
+}
+
+// durationGrowth is the ratio between the upper bounds of neighbouring
+// duration buckets
+const durationGrowth = 1.1
+
+// durationBucket returns the index of the bucket counting a duration. Bucket
+// i holds durations up to durationGrowth^i milliseconds.
+func durationBucket(d time.Duration) int {
+	if d <= time.Millisecond {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(d)/float64(time.Millisecond)) / math.Log(durationGrowth)))
+}
+
+func durationBucketBound(i int) time.Duration {
+	return time.Duration(math.Pow(durationGrowth, float64(i)) * float64(time.Millisecond))
+}
+
+// statsBucket counts the jobs for a repo and project that finished within an hour
+type statsBucket struct {
+	total     int
+	succeeded int
+	max       time.Duration
+	// durations counts jobs by durationBucket
+	durations map[int]int
+}
+
+// repoStats accumulates statistics for each repo as jobs finish, so queries
+// don't need to scan every job
+type repoStats struct {
+	// repos holds buckets by repo, hour and project
+	repos map[string]map[int64]map[string]*statsBucket
+}
+
+// add counts a job that has just succeeded or failed
+func (r *repoStats) add(job Job) {
+	if r.repos == nil {
+		r.repos = map[string]map[int64]map[string]*statsBucket{}
+	}
+	hours, ok := r.repos[job.RepoURI]
+	if !ok {
+		hours = map[int64]map[string]*statsBucket{}
+		r.repos[job.RepoURI] = hours
+	}
+	hour := job.FinishedAt.Truncate(time.Hour).Unix()
+	projects, ok := hours[hour]
+	if !ok {
+		// Buckets are only created about once an hour, so this is a cheap
+		// time to drop those that have expired
+		oldest := job.FinishedAt.Add(-StatsRetention).Truncate(time.Hour).Unix()
+		for h := range hours {
+			if h < oldest {
+				delete(hours, h)
+			}
+		}
+		projects = map[string]*statsBucket{}
+		hours[hour] = projects
+	}
+	bucket, ok := projects[job.Project]
+	if !ok {
+		bucket = &statsBucket{durations: map[int]int{}}
+		projects[job.Project] = bucket
+	}
+
+	bucket.total++
+	if job.Status == JobStatusSuccess {
+		bucket.succeeded++
+	}
+	duration := job.Duration()
+	bucket.durations[durationBucket(duration)]++
+	if duration > bucket.max {
+		bucket.max = duration
+	}
+}
+
+func (r *repoStats) query(query StatsQuery, now time.Time) []RepoStats {
+	windows := query.Windows
+	if len(windows) == 0 {
+		windows = DefaultStatsWindows
+	}
+	hours := r.repos[query.RepoURI]
+	current := now.Truncate(time.Hour)
+
+	var results []RepoStats
+	for _, window := range windows {
+		window = min(window, StatsRetention)
+		count := int((window + time.Hour - 1) / time.Hour)
+		stats := RepoStats{Window: window}
+		durations := map[int]int{}
+		for i := 0; i < count; i++ {
+			for project, bucket := range hours[current.Add(-time.Duration(i)*time.Hour).Unix()] {
+				if query.Project != "" && project != query.Project {
+					continue
+				}
+				stats.Total += bucket.total
+				stats.Succeeded += bucket.succeeded
+				stats.Max = max(stats.Max, bucket.max)
+				for d, n := range bucket.durations {
+					durations[d] += n
+				}
+			}
+		}
+		stats.Failed = stats.Total - stats.Succeeded
+		stats.P50 = percentile(durations, stats.Total, 0.5, stats.Max)
+		stats.P90 = percentile(durations, stats.Total, 0.9, stats.Max)
+		stats.P95 = percentile(durations, stats.Total, 0.95, stats.Max)
+		stats.P99 = percentile(durations, stats.Total, 0.99, stats.Max)
+		results = append(results, stats)
+	}
+	return results
+}
+
+// percentile returns the upper bound of the bucket holding the pth fraction
+// of total durations, capped at the longest duration seen
+func percentile(durations map[int]int, total int, p float64, longest time.Duration) time.Duration {
+	if total == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(total)))
+	minBucket, maxBucket := math.MaxInt, 0
+	for i := range durations {
+		minBucket = min(minBucket, i)
+		maxBucket = max(maxBucket, i)
+	}
+	seen := 0
+	for i := minBucket; i <= maxBucket; i++ {
+		seen += durations[i]
+		if seen >= rank {
+			return min(durationBucketBound(i), longest)
+		}
+	}
+	return longest
+}
@@ -0,0 +1,71 @@
+package minici
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepoStats(t *testing.T) {
+	var stats repoStats
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	finished := func(age, duration time.Duration, status JobStatus, project string) {
+		stats.add(Job{
+			RepoURI:    "repo",
+			Project:    project,
+			Status:     status,
+			StartedAt:  now.Add(-age - duration),
+			FinishedAt: now.Add(-age),
+		})
+	}
+	for i := 1; i <= 100; i++ {
+		finished(time.Minute, time.Duration(i)*time.Second, JobStatusSuccess, "")
+	}
+	finished(2*time.Hour, time.Hour, JobStatusFailure, "")
+	finished(3*24*time.Hour, time.Second, JobStatusFailure, "other")
+	finished(100*24*time.Hour, time.Second, JobStatusFailure, "")
+
+	results := stats.query(StatsQuery{RepoURI: "repo", Windows: []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 365 * 24 * time.Hour}}, now)
+	if len(results) != 4 {
+		t.Fatalf("Expected a result for each window, got %+v", results)
+	}
+
+	hour := results[0]
+	if hour.Total != 100 || hour.Succeeded != 100 || hour.SuccessRate() != 1 {
+		t.Errorf("Unexpected counts for the last hour: %+v", hour)
+	}
+	for _, p := range []struct {
+		name     string
+		got      time.Duration
+		expected time.Duration
+	}{
+		{"p50", hour.P50, 50 * time.Second},
+		{"p90", hour.P90, 90 * time.Second},
+		{"p99", hour.P99, 99 * time.Second},
+	} {
+		if p.got < p.expected || p.got > p.expected*11/10 {
+			t.Errorf("Expected %s to be within 10%% of %v, got %v", p.name, p.expected, p.got)
+		}
+	}
+	if hour.Max != 100*time.Second {
+		t.Errorf("Expected max of 100s, got %v", hour.Max)
+	}
+
+	if day := results[1]; day.Total != 101 || day.Failed != 1 || day.Max != time.Hour {
+		t.Errorf("Unexpected stats for the last day: %+v", day)
+	}
+	if week := results[2]; week.Total != 102 {
+		t.Errorf("Expected 102 jobs in the last week, got %+v", week)
+	}
+	// Windows are capped at the retention period
+	if all := results[3]; all.Window != StatsRetention || all.Total != 102 {
+		t.Errorf("Expected jobs older than the retention period to be dropped, got %+v", all)
+	}
+
+	results = stats.query(StatsQuery{RepoURI: "repo", Project: "other"}, now)
+	if len(results) != len(DefaultStatsWindows) || results[0].Total != 0 || results[1].Total != 1 {
+		t.Errorf("Expected only the other project's job, got %+v", results)
+	}
+	if results = stats.query(StatsQuery{RepoURI: "missing"}, now); results[2].Total != 0 || results[2].P50 != 0 {
+		t.Errorf("Expected no jobs for an unknown repo, got %+v", results)
+	}
+}
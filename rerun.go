@@ -0,0 +1,51 @@
+package minici
+
+import "maps"
+
+// JobOverrides are changes to make to a job when running it again. Empty
+// fields keep the original job's settings.
+type JobOverrides struct {
+	Commit  string
+	Command string
+	Name    string
+	Image   string
+	// Env, Inputs and Labels are combined with the original job's, with
+	// these taking precedence
+	Env    map[string]string
+	Inputs map[string]string
+	Labels map[string]string
+}
+
+// RerunSpec returns the spec job was scheduled with, changed by overrides, so
+// a job can be run again without having to describe it from scratch
+func RerunSpec(job Job, overrides JobOverrides) JobSpec {
+	spec := job.Spec()
+	if overrides.Commit != "" {
+		spec.Commit = overrides.Commit
+	}
+	if overrides.Command != "" {
+		spec.Command = overrides.Command
+	}
+	if overrides.Name != "" {
+		spec.Name = overrides.Name
+	}
+	if overrides.Image != "" {
+		spec.Image = overrides.Image
+	}
+	spec.Env = overrideMap(spec.Env, overrides.Env)
+	spec.Inputs = overrideMap(spec.Inputs, overrides.Inputs)
+	spec.Labels = overrideMap(spec.Labels, overrides.Labels)
+	return spec
+}
+
+// overrideMap returns a copy of m with over's values on top, leaving m
+// unchanged since it's shared with the original job
+func overrideMap(m, over map[string]string) map[string]string {
+	if len(over) == 0 {
+		return m
+	}
+	merged := make(map[string]string, len(m)+len(over))
+	maps.Copy(merged, m)
+	maps.Copy(merged, over)
+	return merged
+}
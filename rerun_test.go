@@ -0,0 +1,35 @@
+package minici
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRerunSpec(t *testing.T) {
+	job := Job{
+		ID: "1", Status: JobStatusFailure, RepoURI: "api", Commit: "main", Command: "./deploy.sh", Name: "Deploy",
+		Environment: "staging", Image: "golang:1.22", Env: map[string]string{"REGION": "eu", "DEBUG": "0"},
+		Inputs: map[string]string{"version": "1.0.0"}, Labels: map[string]string{"team": "api"},
+		RunsOn: []string{"linux"}, Needs: []JobNeed{{Job: "build"}},
+	}
+
+	if got := RerunSpec(job, JobOverrides{}); !reflect.DeepEqual(got, job.Spec()) {
+		t.Errorf("Expected a re-run without overrides to match the job's spec %+v, got %+v", job.Spec(), got)
+	}
+
+	got := RerunSpec(job, JobOverrides{
+		Commit: "abc123",
+		Env:    map[string]string{"DEBUG": "1"},
+		Inputs: map[string]string{"version": "1.0.1"},
+	})
+	expected := job.Spec()
+	expected.Commit = "abc123"
+	expected.Env = map[string]string{"REGION": "eu", "DEBUG": "1"}
+	expected.Inputs = map[string]string{"version": "1.0.1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected spec %+v, got %+v", expected, got)
+	}
+	if job.Env["DEBUG"] != "0" || job.Inputs["version"] != "1.0.0" {
+		t.Errorf("Expected the original job to be unchanged, got %+v", job)
+	}
+}
@@ -0,0 +1,35 @@
+package restapi
+
+import (
+	"net/http"
+	"strings"
+)
+
+// adminPrefix is the path the admin API is served under
+const adminPrefix = "/api/admin/"
+
+// AdminHandler serves the admin API, such as reloading config and minting
+// agent tokens, on its own, so it can be served on a listener that's
+// firewalled separately from the rest of the API. Once it's called, the rest
+// of the API no longer serves the admin endpoints. Tokens are checked as for
+// the rest of the API.
+func (s *Server) AdminHandler() http.Handler {
+	s.adminSeparate = true
+	s.buildHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.admin.ServeHTTP(w, r)
+	})
+}
+
+// scoped serves the router's admin endpoints if admin is true, and the rest
+// of its endpoints otherwise. Everything is served from the router until
+// AdminHandler is called.
+func (s *Server) scoped(admin bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminSeparate && strings.HasPrefix(r.URL.Path, adminPrefix) != admin {
+			s.writeError(w, "Not found", http.StatusNotFound)
+			return
+		}
+		s.router.ServeHTTP(w, r)
+	})
+}
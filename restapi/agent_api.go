@@ -0,0 +1,203 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ocuroot/minici"
+)
+
+// AgentRequest describes the agent claiming a job
+type AgentRequest struct {
+	Labels   []string `json:"labels,omitempty"`
+	Capacity int      `json:"capacity,omitempty"`
+}
+
+// AgentJobResponse describes a job assigned to an agent, including everything
+// needed to run it
+type AgentJobResponse struct {
+	JobResponse
+	Env map[string]string `json:"env,omitempty"`
+	// Token lets the job's commands send heartbeats to the server
+	Token string `json:"token,omitempty"`
+}
+
+// AgentLogsRequest represents the request body for reporting a job's output
+type AgentLogsRequest struct {
+	Lines []string `json:"lines"`
+}
+
+// AgentFinishRequest represents the request body for reporting a job's outcome
+type AgentFinishRequest struct {
+	Status string `json:"status"`
+	// Outputs are values the job recorded, such as the digests of images it pushed
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// Tests are the results from the job's test reports
+	Tests []TestResultResponse `json:"tests,omitempty"`
+	// Coverage is the percentage from the job's coverage report
+	Coverage *float64 `json:"coverage,omitempty"`
+	// Hooks are the outcomes of the repo's hooks
+	Hooks []HookResponse `json:"hooks,omitempty"`
+	// FailedPhase is the phase the job failed in, such as clone or command
+	FailedPhase string `json:"failed_phase,omitempty"`
+	// TimedOut is set if FailedPhase ran out of time
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Stalled is set if the job's commands stopped producing output
+	Stalled bool `json:"stalled,omitempty"`
+	// CommitHash is the hash of the commit the job checked out
+	CommitHash string `json:"commit_hash,omitempty"`
+}
+
+// NewAgentFinishRequest reports the outcome of a job an agent ran
+func NewAgentFinishRequest(result minici.JobResult) AgentFinishRequest {
+	return AgentFinishRequest{
+		Status:   string(result.Status),
+		Outputs:  result.Outputs,
+		Tests:    testResultsToResponse(result.Tests),
+		Coverage: result.Coverage,
+		Hooks:    hooksToResponse(result.Hooks),
+
+		FailedPhase: string(result.FailedPhase),
+		TimedOut:    result.TimedOut,
+		Stalled:     result.Stalled,
+		CommitHash:  result.CommitHash,
+	}
+}
+
+// EnableAgents registers endpoints for remote agents to claim jobs and report
+// their progress
+func (s *Server) EnableAgents(pool minici.AgentPool) {
+	s.agents = pool
+
+	// Handles /api/agents/<name>/claim, /api/agents/<name>/jobs/<id>/logs and
+	// /api/agents/<name>/jobs/<id>/finish
+	s.router.HandleFunc("/api/agents/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		pathSegments := strings.Split(strings.TrimRight(r.URL.Path, "/"), "/")
+		switch {
+		case len(pathSegments) == 5 && pathSegments[4] == "claim":
+			s.handleClaimJob(w, r, pathSegments[3])
+		case len(pathSegments) == 7 && pathSegments[4] == "jobs" && pathSegments[6] == "logs":
+			s.handleReportLogs(w, r, pathSegments[3], pathSegments[5])
+		case len(pathSegments) == 7 && pathSegments[4] == "jobs" && pathSegments[6] == "finish":
+			s.handleFinishJob(w, r, pathSegments[3], pathSegments[5])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+// handleClaimJob assigns the oldest waiting job the agent can run, responding
+// with 204 No Content if there isn't one
+func (s *Server) handleClaimJob(w http.ResponseWriter, r *http.Request, name string) {
+	var req AgentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.agents.ClaimJob(minici.AgentInfo{
+		Name:     name,
+		Labels:   req.Labels,
+		Capacity: req.Capacity,
+	})
+	if errors.Is(err, minici.ErrNoJobAvailable) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if errors.Is(err, minici.ErrAgentNameInUse) {
+		s.writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, AgentJobResponse{
+		JobResponse: JobResponse{
+			ID:     string(job.ID),
+			Status: string(job.Status),
+			Number: job.Number,
+
+			RepoURI:     job.RepoURI,
+			Commit:      job.Commit,
+			Branch:      job.Branch,
+			Command:     job.Command,
+			Name:        job.Name,
+			Project:     job.Project,
+			Environment: job.Environment,
+			Group:       job.Group,
+			Labels:      job.Labels,
+			Image:       job.Image,
+			Inputs:      job.Inputs,
+			RunsOn:      job.RunsOn,
+			Agent:       job.Agent,
+
+			StallTimeoutSeconds: job.StallTimeout.Seconds(),
+			PullRequest:         pullRequestFromSpec(job.PullRequest),
+		},
+		Env:   job.Env,
+		Token: job.Token,
+	}, http.StatusOK)
+}
+
+// handleReportLogs appends lines to a job's logs. It responds with 409 Conflict
+// once the job has finished, such as if it was cancelled or interrupted.
+func (s *Server) handleReportLogs(w http.ResponseWriter, r *http.Request, name, jobID string) {
+	var req AgentLogsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := s.agents.ReportLogs(name, minici.JobID(jobID), req.Lines)
+	s.writeAgentResult(w, err)
+}
+
+// handleFinishJob records the final status of a job
+func (s *Server) handleFinishJob(w http.ResponseWriter, r *http.Request, name, jobID string) {
+	var req AgentFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	status := minici.JobStatus(req.Status)
+	if status != minici.JobStatusSuccess && status != minici.JobStatusFailure && status != minici.JobStatusCancelled {
+		s.writeError(w, "Status must be success, failure or cancelled", http.StatusBadRequest)
+		return
+	}
+
+	err := s.agents.FinishJob(name, minici.JobID(jobID), minici.JobResult{
+		Status:   status,
+		Outputs:  req.Outputs,
+		Tests:    testResultsFromRequest(req.Tests),
+		Coverage: req.Coverage,
+		Hooks:    hooksFromRequest(req.Hooks),
+
+		FailedPhase: minici.JobPhase(req.FailedPhase),
+		TimedOut:    req.TimedOut,
+		Stalled:     req.Stalled,
+		CommitHash:  req.CommitHash,
+	})
+	s.writeAgentResult(w, err)
+}
+
+func (s *Server) writeAgentResult(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, minici.ErrJobNotFound):
+		s.writeError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, minici.ErrJobFinished):
+		s.writeError(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
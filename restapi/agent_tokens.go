@@ -0,0 +1,328 @@
+package restapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// AgentTokenRequest represents the request body for minting an agent token
+type AgentTokenRequest struct {
+	Description string `json:"description,omitempty"`
+	// Agent optionally restricts the token to the agent with this name
+	Agent string `json:"agent,omitempty"`
+	// ExpiresIn is a duration such as "720h", the token never expires if empty
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// AgentTokenResponse describes an agent token. The token itself is only
+// included when it is minted.
+type AgentTokenResponse struct {
+	ID          string     `json:"id"`
+	Token       string     `json:"token,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Agent       string     `json:"agent,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Expired     bool       `json:"expired"`
+}
+
+// ListAgentTokensResponse represents the response for listing agent tokens
+type ListAgentTokensResponse struct {
+	Tokens []AgentTokenResponse `json:"tokens"`
+}
+
+// agentTokenPrefix makes agent tokens recognizable, for example by secret scanners
+const agentTokenPrefix = "minici_agent_"
+
+// agentToken is a credential that only allows access to the agent endpoints.
+// Only a hash of the token is kept.
+type agentToken struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description,omitempty"`
+	Agent       string    `json:"agent,omitempty"`
+	Hash        string    `json:"hash"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+}
+
+func (t *agentToken) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+func (t *agentToken) response(now time.Time) AgentTokenResponse {
+	resp := AgentTokenResponse{
+		ID:          t.ID,
+		Description: t.Description,
+		Agent:       t.Agent,
+		CreatedAt:   t.CreatedAt,
+		Expired:     t.expired(now),
+	}
+	if !t.ExpiresAt.IsZero() {
+		expires := t.ExpiresAt
+		resp.ExpiresAt = &expires
+	}
+	return resp
+}
+
+// agentTokenStore holds agent tokens, optionally persisting them to a file so
+// they survive restarts
+type agentTokenStore struct {
+	mutex  sync.RWMutex
+	path   string
+	tokens map[string]*agentToken
+}
+
+// newAgentTokenStore creates a store, loading any tokens saved at path. If
+// path is empty, tokens are only held in memory.
+func newAgentTokenStore(path string) (*agentTokenStore, error) {
+	store := &agentTokenStore{
+		path:   path,
+		tokens: make(map[string]*agentToken),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent tokens: %w", err)
+	}
+	var tokens []*agentToken
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse agent tokens in %s: %w", path, err)
+	}
+	for _, token := range tokens {
+		store.tokens[token.ID] = token
+	}
+	return store, nil
+}
+
+func hashAgentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// mint creates a token, returning its details and the secret to give to the agent
+func (s *agentTokenStore) mint(description, agent string, ttl time.Duration) (*agentToken, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	value := agentTokenPrefix + hex.EncodeToString(secret)
+
+	now := time.Now().UTC()
+	token := &agentToken{
+		ID:          ulid.Make().String(),
+		Description: description,
+		Agent:       agent,
+		Hash:        hashAgentToken(value),
+		CreatedAt:   now,
+	}
+	if ttl > 0 {
+		token.ExpiresAt = now.Add(ttl)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens[token.ID] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, token.ID)
+		return nil, "", err
+	}
+	return token, value, nil
+}
+
+// list returns all tokens, oldest first
+func (s *agentTokenStore) list() []*agentToken {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tokens := make([]*agentToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens
+}
+
+// revoke deletes a token, returning false if it doesn't exist
+func (s *agentTokenStore) revoke(id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = token
+		return false, err
+	}
+	return true, nil
+}
+
+// allows returns true if value is a valid token for the agent request path
+func (s *agentTokenStore) allows(value, path string, now time.Time) bool {
+	if !strings.HasPrefix(value, agentTokenPrefix) || !strings.HasPrefix(path, "/api/agents/") {
+		return false
+	}
+	hash := []byte(hashAgentToken(value))
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var matched *agentToken
+	for _, token := range s.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			matched = token
+		}
+	}
+	if matched == nil || matched.expired(now) {
+		return false
+	}
+	if matched.Agent != "" {
+		// Paths are /api/agents/<name>/...
+		name, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/agents/"), "/")
+		return name == matched.Agent
+	}
+	return true
+}
+
+// save writes the tokens to the store's file, if it has one. The caller must
+// hold the mutex.
+func (s *agentTokenStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	tokens := make([]*agentToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID < tokens[j].ID
+	})
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave a partial file
+	temp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save agent tokens: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to save agent tokens: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to save agent tokens: %w", err)
+	}
+	if err := os.Rename(temp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save agent tokens: %w", err)
+	}
+	return nil
+}
+
+// EnableAgentTokens registers admin endpoints for minting and revoking tokens
+// that only allow access to the agent endpoints. Tokens are saved to path, if
+// it isn't empty, so they survive restarts.
+func (s *Server) EnableAgentTokens(path string) error {
+	store, err := newAgentTokenStore(path)
+	if err != nil {
+		return err
+	}
+	s.agentTokens = store
+
+	s.router.HandleFunc("/api/admin/agent-tokens", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleListAgentTokens(w, r)
+		case http.MethodPost:
+			s.handleMintAgentToken(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/admin/agent-tokens/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleRevokeAgentToken(w, r, strings.TrimPrefix(r.URL.Path, "/api/admin/agent-tokens/"))
+	})
+	return nil
+}
+
+// handleMintAgentToken processes requests to create an agent token
+func (s *Server) handleMintAgentToken(w http.ResponseWriter, r *http.Request) {
+	var req AgentTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			s.writeError(w, "expires_in must be a positive duration such as 720h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, value, err := s.agentTokens.mint(req.Description, req.Agent, ttl)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := token.response(time.Now())
+	resp.Token = value
+	s.writeJSON(w, resp, http.StatusCreated)
+}
+
+// handleListAgentTokens processes requests to list agent tokens, without their secrets
+func (s *Server) handleListAgentTokens(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	tokens := []AgentTokenResponse{}
+	for _, token := range s.agentTokens.list() {
+		tokens = append(tokens, token.response(now))
+	}
+	s.writeJSON(w, ListAgentTokensResponse{Tokens: tokens}, http.StatusOK)
+}
+
+// handleRevokeAgentToken processes requests to revoke an agent token
+func (s *Server) handleRevokeAgentToken(w http.ResponseWriter, r *http.Request, id string) {
+	ok, err := s.agentTokens.revoke(id)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.writeError(w, "Agent token not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
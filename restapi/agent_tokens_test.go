@@ -0,0 +1,88 @@
+package restapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-tokens.json")
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	restServer.RequireToken("admin")
+	restServer.AddReadOnlyToken("viewer")
+	restServer.EnableAgents(ci.(minici.AgentPool))
+	require.NoError(t, restServer.EnableAgentTokens(path))
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	mint := func(body string) AgentTokenResponse {
+		rr := request("POST", "/api/admin/agent-tokens", "admin", body)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var token AgentTokenResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&token))
+		return token
+	}
+	claim := func(agent, token string) int {
+		return request("POST", "/api/agents/"+agent+"/claim", token, `{}`).Code
+	}
+
+	token := mint(`{"description": "build box", "expires_in": "720h"}`)
+	assert.True(t, strings.HasPrefix(token.Token, agentTokenPrefix))
+	require.NotNil(t, token.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(720*time.Hour), *token.ExpiresAt, time.Minute)
+
+	// Agent tokens only work for the agent endpoints
+	assert.Equal(t, http.StatusNoContent, claim("box", token.Token))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/jobs", token.Token, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/admin/agent-tokens", token.Token, "").Code)
+
+	// Tokens bound to an agent only work for that agent
+	bound := mint(`{"agent": "gpu-box"}`)
+	assert.Nil(t, bound.ExpiresAt)
+	assert.Equal(t, http.StatusNoContent, claim("gpu-box", bound.Token))
+	assert.Equal(t, http.StatusUnauthorized, claim("other", bound.Token))
+
+	expired := mint(`{"expires_in": "1ns"}`)
+	assert.Equal(t, http.StatusUnauthorized, claim("box", expired.Token))
+
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/api/admin/agent-tokens", "admin", `{"expires_in": "soon"}`).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/admin/agent-tokens", "viewer", "").Code)
+
+	// Listing never includes the tokens themselves
+	rr := request("GET", "/api/admin/agent-tokens", "admin", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), token.Token)
+	var list ListAgentTokensResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+	require.Len(t, list.Tokens, 3)
+	assert.Equal(t, token.ID, list.Tokens[0].ID)
+	assert.Equal(t, "build box", list.Tokens[0].Description)
+	assert.True(t, list.Tokens[2].Expired)
+
+	// Revoked tokens stop working immediately
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/api/admin/agent-tokens/"+token.ID, "admin", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, claim("box", token.Token))
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/api/admin/agent-tokens/"+token.ID, "admin", "").Code)
+
+	// Tokens are loaded again after a restart
+	store, err := newAgentTokenStore(path)
+	require.NoError(t, err)
+	assert.Len(t, store.list(), 2)
+	assert.True(t, store.allows(bound.Token, "/api/agents/gpu-box/claim", time.Now()))
+	assert.False(t, store.allows(token.Token, "/api/agents/box/claim", time.Now()))
+}
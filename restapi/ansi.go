@@ -0,0 +1,253 @@
+package restapi
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Values of the ansi query parameter of the logs endpoints, choosing what
+// happens to the escape sequences commands print to color their output
+const (
+	// ANSIKeep returns lines as they were logged, the default
+	ANSIKeep = "keep"
+	// ANSIStrip removes escape sequences, leaving plain text
+	ANSIStrip = "strip"
+	// ANSIHTML escapes lines as HTML, with colors and styles as spans
+	ANSIHTML = "html"
+)
+
+// ansiColors are the names of the 8 standard colors, in SGR order
+var ansiColors = [8]string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ansiStyle is the effect of the SGR sequences seen so far on a line
+type ansiStyle struct {
+	bold, faint, italic, underline bool
+	// fg and bg are class suffixes such as "red" or "bright-red", or an
+	// #rrggbb color for those without a class
+	fg, bg string
+}
+
+// apply updates the style with the parameters of an SGR sequence
+func (s *ansiStyle) apply(params []int) {
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			*s = ansiStyle{}
+		case p == 1:
+			s.bold = true
+		case p == 2:
+			s.faint = true
+		case p == 3:
+			s.italic = true
+		case p == 4:
+			s.underline = true
+		case p == 22:
+			s.bold, s.faint = false, false
+		case p == 23:
+			s.italic = false
+		case p == 24:
+			s.underline = false
+		case p >= 30 && p <= 37:
+			s.fg = ansiColors[p-30]
+		case p == 38 || p == 48:
+			color, used := extendedColor(params[i+1:])
+			i += used
+			if p == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+		case p == 39:
+			s.fg = ""
+		case p >= 40 && p <= 47:
+			s.bg = ansiColors[p-40]
+		case p == 49:
+			s.bg = ""
+		case p >= 90 && p <= 97:
+			s.fg = "bright-" + ansiColors[p-90]
+		case p >= 100 && p <= 107:
+			s.bg = "bright-" + ansiColors[p-100]
+		}
+	}
+}
+
+// extendedColor reads the color following a 38 or 48 parameter, either 5;n
+// from the 256 color palette or 2;r;g;b, returning how many parameters it used
+func extendedColor(params []int) (string, int) {
+	switch {
+	case len(params) >= 2 && params[0] == 5:
+		return paletteColor(params[1]), 2
+	case len(params) >= 4 && params[0] == 2:
+		return fmt.Sprintf("#%02x%02x%02x", clampByte(params[1]), clampByte(params[2]), clampByte(params[3])), 4
+	}
+	// The rest of the sequence can't be interpreted
+	return "", len(params)
+}
+
+// paletteColor converts a color from the 256 color palette
+func paletteColor(n int) string {
+	switch {
+	case n < 0 || n > 255:
+		return ""
+	case n < 8:
+		return ansiColors[n]
+	case n < 16:
+		return "bright-" + ansiColors[n-8]
+	case n < 232:
+		// A 6x6x6 cube of colors
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + 40*v
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default:
+		// A ramp of greys
+		grey := 8 + 10*(n-232)
+		return fmt.Sprintf("#%02x%02x%02x", grey, grey, grey)
+	}
+}
+
+func clampByte(v int) int {
+	return min(max(v, 0), 255)
+}
+
+// open returns the tag starting a span in the style, or an empty string for
+// the default style
+func (s ansiStyle) open() string {
+	var classes, styles []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{s.bold, "ansi-bold"}, {s.faint, "ansi-faint"}, {s.italic, "ansi-italic"}, {s.underline, "ansi-underline"}} {
+		if flag.set {
+			classes = append(classes, flag.name)
+		}
+	}
+	if strings.HasPrefix(s.fg, "#") {
+		styles = append(styles, "color:"+s.fg)
+	} else if s.fg != "" {
+		classes = append(classes, "ansi-"+s.fg)
+	}
+	if strings.HasPrefix(s.bg, "#") {
+		styles = append(styles, "background-color:"+s.bg)
+	} else if s.bg != "" {
+		classes = append(classes, "ansi-bg-"+s.bg)
+	}
+	if len(classes) == 0 && len(styles) == 0 {
+		return ""
+	}
+	tag := "<span"
+	if len(classes) > 0 {
+		tag += ` class="` + strings.Join(classes, " ") + `"`
+	}
+	if len(styles) > 0 {
+		tag += ` style="` + strings.Join(styles, ";") + `"`
+	}
+	return tag + ">"
+}
+
+// scanANSI splits a line into text, passed to onText, and escape sequences.
+// The parameters of SGR sequences, which set colors and styles, are passed to
+// onSGR, and other sequences, such as those moving the cursor, are dropped.
+func scanANSI(line string, onText func(text string), onSGR func(params []int)) {
+	for {
+		start := strings.IndexByte(line, '\x1b')
+		if start < 0 {
+			break
+		}
+		if start > 0 {
+			onText(line[:start])
+		}
+		line = line[start+1:]
+		if line == "" {
+			return
+		}
+		switch line[0] {
+		case '[':
+			// Control sequences end with a byte from @ to ~
+			end := strings.IndexFunc(line[1:], func(r rune) bool { return r >= '@' && r <= '~' })
+			if end < 0 {
+				return
+			}
+			end++
+			if line[end] == 'm' {
+				onSGR(sgrParams(line[1:end]))
+			}
+			line = line[end+1:]
+		case ']':
+			// Operating system commands, such as hyperlinks, end with BEL or ESC \
+			end := strings.IndexAny(line, "\a\x1b")
+			if end < 0 {
+				return
+			}
+			if line[end] == '\x1b' && end+1 < len(line) && line[end+1] == '\\' {
+				end++
+			}
+			line = line[end+1:]
+		default:
+			line = line[1:]
+		}
+	}
+	if line != "" {
+		onText(line)
+	}
+}
+
+// sgrParams parses the parameters of an SGR sequence, where an empty
+// parameter is 0
+func sgrParams(params string) []int {
+	if params == "" {
+		return []int{0}
+	}
+	var values []int
+	for _, param := range strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' }) {
+		value, err := strconv.Atoi(param)
+		if err != nil {
+			value = -1
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// stripANSI removes escape sequences from a line
+func stripANSI(line string) string {
+	if !strings.Contains(line, "\x1b") {
+		return line
+	}
+	var text strings.Builder
+	scanANSI(line, func(s string) { text.WriteString(s) }, func([]int) {})
+	return text.String()
+}
+
+// ansiToHTML escapes a line as HTML, with its colors and styles as spans. Each
+// line starts in the default style.
+func ansiToHTML(line string) string {
+	var out strings.Builder
+	var style ansiStyle
+	open := false
+	scanANSI(line, func(text string) {
+		if !open {
+			if tag := style.open(); tag != "" {
+				out.WriteString(tag)
+				open = true
+			}
+		}
+		out.WriteString(html.EscapeString(text))
+	}, func(params []int) {
+		if open {
+			out.WriteString("</span>")
+			open = false
+		}
+		style.apply(params)
+	})
+	if open {
+		out.WriteString("</span>")
+	}
+	return out.String()
+}
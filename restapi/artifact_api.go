@@ -0,0 +1,204 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// ArtifactResponse describes a file saved from a job
+type ArtifactResponse struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// ListArtifactsResponse represents the response for listing a job's artifacts
+type ListArtifactsResponse struct {
+	Artifacts []ArtifactResponse `json:"artifacts"`
+}
+
+// EnableArtifacts registers endpoints for listing and downloading the
+// artifacts jobs have saved to store
+func (s *Server) EnableArtifacts(store minici.ArtifactStore) {
+	s.artifacts = store
+}
+
+// handleArtifacts handles /api/jobs/<id>/artifacts and
+// /api/jobs/<id>/artifacts/<name>, where the name may contain slashes
+func (s *Server) handleArtifacts(w http.ResponseWriter, r *http.Request, jobID string) {
+	if s.artifacts == nil {
+		s.writeError(w, "Artifacts are not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix := "/api/jobs/" + jobID + "/artifacts"
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if name == "" {
+		s.handleListArtifacts(w, r, minici.JobID(jobID))
+		return
+	}
+	s.handleDownloadArtifact(w, r, minici.JobID(jobID), name)
+}
+
+// handleListArtifacts processes requests to list a job's artifacts. Artifacts
+// outlive the server's record of jobs, so unknown jobs aren't an error.
+func (s *Server) handleListArtifacts(w http.ResponseWriter, r *http.Request, jobID minici.JobID) {
+	artifacts, err := s.artifacts.List(r.Context(), jobID)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := ListArtifactsResponse{Artifacts: []ArtifactResponse{}}
+	for _, artifact := range artifacts {
+		resp.Artifacts = append(resp.Artifacts, ArtifactResponse{
+			Name:       artifact.Name,
+			Size:       artifact.Size,
+			ModifiedAt: artifact.ModTime,
+		})
+	}
+	s.writeJSON(w, resp, http.StatusOK)
+}
+
+// handleDownloadArtifact streams an artifact's content, supporting range
+// requests so large downloads can be resumed
+func (s *Server) handleDownloadArtifact(w http.ResponseWriter, r *http.Request, jobID minici.JobID, name string) {
+	reader, err := s.artifacts.Open(r.Context(), jobID, name)
+	if errors.Is(err, minici.ErrArtifactNotFound) {
+		s.writeError(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	artifact := reader.Artifact()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		// Rather than sniffing, which would need an extra request to S3
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	// Artifacts are untrusted job output, so don't let browsers render them as pages on the server's origin
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	http.ServeContent(w, r, name, artifact.ModTime, reader)
+}
+
+// PrunedArtifactsResponse describes a job whose artifacts were removed
+type PrunedArtifactsResponse struct {
+	ID    string `json:"id"`
+	Repo  string `json:"repo,omitempty"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+}
+
+// PruneArtifactsResponse represents the response for pruning artifacts
+type PruneArtifactsResponse struct {
+	Jobs []PrunedArtifactsResponse `json:"jobs"`
+	// FreedBytes is the total size of the removed artifacts
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// EnableArtifactRetention registers an admin endpoint that prunes artifacts
+// according to retention immediately, rather than waiting for the sweeper
+func (s *Server) EnableArtifactRetention(retention minici.ArtifactRetention) {
+	s.SetArtifactRetention(retention)
+	s.router.HandleFunc("/api/admin/artifacts/prune", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if s.artifacts == nil {
+			s.writeError(w, "Artifacts are not enabled", http.StatusNotFound)
+			return
+		}
+
+		pruned, err := minici.PruneArtifacts(r.Context(), s.artifacts, s.ArtifactRetention(), time.Now())
+		resp := PruneArtifactsResponse{Jobs: []PrunedArtifactsResponse{}}
+		for _, job := range pruned {
+			resp.Jobs = append(resp.Jobs, PrunedArtifactsResponse{
+				ID:    string(job.JobID),
+				Repo:  job.Repo,
+				Count: job.Count,
+				Size:  job.Size,
+			})
+			resp.FreedBytes += job.Size
+		}
+		if err != nil {
+			s.writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, resp, http.StatusOK)
+	})
+}
+
+// SetArtifactRetention replaces the retention used by the prune endpoint and
+// SweepArtifacts, such as when config is reloaded
+func (s *Server) SetArtifactRetention(retention minici.ArtifactRetention) {
+	s.retentionMutex.Lock()
+	defer s.retentionMutex.Unlock()
+	s.retention = retention
+}
+
+// ArtifactRetention returns the current retention, keeping the artifacts of
+// pinned jobs
+func (s *Server) ArtifactRetention() minici.ArtifactRetention {
+	s.retentionMutex.RLock()
+	retention := s.retention
+	s.retentionMutex.RUnlock()
+
+	pinned, _, err := s.ci.QueryJobs(minici.JobFilter{Pinned: true})
+	if err != nil {
+		slog.Error("Failed to find pinned jobs", "error", err)
+	}
+	for _, job := range pinned {
+		if retention.Pinned == nil {
+			retention.Pinned = map[minici.JobID]bool{}
+		}
+		retention.Pinned[job.ID] = true
+	}
+	return retention
+}
+
+// SweepArtifacts prunes artifacts according to the current retention until
+// ctx is cancelled. Nothing is pruned while retention isn't configured.
+func SweepArtifacts(ctx context.Context, clock minici.Clock, store minici.ArtifactStore, retention func() minici.ArtifactRetention) {
+	for {
+		current := retention()
+		if current.RetentionPolicy != (minici.RetentionPolicy{}) || len(current.Repos) > 0 {
+			pruned, err := minici.PruneArtifacts(ctx, store, current, clock.Now())
+			if err != nil {
+				slog.Error("Failed to prune artifacts", "error", err)
+			}
+			if len(pruned) > 0 {
+				slog.Info("Pruned artifacts", "jobs", len(pruned))
+			}
+		}
+
+		interval := current.Interval
+		if interval <= 0 {
+			interval = minici.DefaultRetentionInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(interval):
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/citest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactAPI(t *testing.T) {
+	store := &minici.FileArtifactStore{Dir: t.TempDir()}
+	for name, content := range map[string]string{
+		"dist/report.html": "<h1>Coverage</h1>",
+		"dist/app":         "0123456789",
+	} {
+		require.NoError(t, store.Save(context.Background(), "01JOB", name, strings.NewReader(content), int64(len(content))))
+	}
+
+	restServer := NewServer(&mockCI{}, ":0")
+	request := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusNotFound, request("GET", "/api/jobs/01JOB/artifacts", nil).Code, "Artifacts should 404 until enabled")
+	restServer.EnableArtifacts(store)
+
+	rr := request("GET", "/api/jobs/01JOB/artifacts", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list ListArtifactsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+	require.Len(t, list.Artifacts, 2)
+	assert.Equal(t, "dist/app", list.Artifacts[0].Name)
+	assert.Equal(t, int64(10), list.Artifacts[0].Size)
+
+	rr = request("GET", "/api/jobs/01EMPTY/artifacts/", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"artifacts": []}`, rr.Body.String())
+
+	rr = request("GET", "/api/jobs/01JOB/artifacts/dist/report.html", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "<h1>Coverage</h1>", rr.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=report.html`, rr.Header().Get("Content-Disposition"))
+	assert.Equal(t, "sandbox", rr.Header().Get("Content-Security-Policy"))
+
+	rr = request("GET", "/api/jobs/01JOB/artifacts/dist/app", http.Header{"Range": {"bytes=4-"}})
+	require.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "456789", rr.Body.String())
+	assert.Equal(t, "bytes 4-9/10", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "application/octet-stream", rr.Header().Get("Content-Type"))
+
+	rr = request("HEAD", "/api/jobs/01JOB/artifacts/dist/app", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("Content-Length"))
+	assert.Empty(t, rr.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, request("GET", "/api/jobs/01JOB/artifacts/missing", nil).Code)
+	assert.NotEqual(t, http.StatusOK, request("GET", "/api/jobs/01JOB/artifacts/dist/../../secret", nil).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request("DELETE", "/api/jobs/01JOB/artifacts/dist/app", nil).Code)
+}
+
+func TestPruneArtifactsAPI(t *testing.T) {
+	store := &minici.FileArtifactStore{Dir: t.TempDir()}
+	ctx := context.Background()
+	for _, jobID := range []minici.JobID{"01A", "01B", "01C"} {
+		require.NoError(t, store.SetRepo(ctx, jobID, "https://example.com/repo.git"))
+		require.NoError(t, store.Save(ctx, jobID, "app", strings.NewReader("12345"), 5))
+	}
+
+	restServer := NewServer(&mockCI{}, ":0")
+	restServer.RequireToken("admin")
+	restServer.AddReadOnlyToken("viewer")
+	restServer.EnableArtifacts(store)
+	restServer.EnableArtifactRetention(minici.ArtifactRetention{RetentionPolicy: minici.RetentionPolicy{KeepLast: 1}})
+	prune := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/admin/artifacts/prune", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusForbidden, prune("viewer").Code)
+
+	rr := prune("admin")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp PruneArtifactsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Len(t, resp.Jobs, 2)
+	assert.Equal(t, "01A", resp.Jobs[0].ID)
+	assert.Equal(t, "https://example.com/repo.git", resp.Jobs[0].Repo)
+	assert.Equal(t, int64(10), resp.FreedBytes)
+
+	jobs, err := store.Jobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, minici.JobID("01C"), jobs[0].JobID)
+}
+
+func TestSweepArtifacts(t *testing.T) {
+	store := &minici.FileArtifactStore{Dir: t.TempDir()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, store.Save(ctx, "01A", "app", strings.NewReader("12345"), 5))
+
+	clock := citest.NewClock(time.Now())
+	retention := minici.ArtifactRetention{RetentionPolicy: minici.RetentionPolicy{MaxAge: time.Hour}, Interval: time.Minute}
+	go SweepArtifacts(ctx, clock, store, func() minici.ArtifactRetention { return retention })
+
+	clock.WaitForWaiters(t, 1)
+	jobs, err := store.Jobs(ctx)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1, "Artifacts shouldn't be pruned before they expire")
+
+	clock.Advance(2 * time.Hour)
+	clock.WaitForWaiters(t, 1)
+	jobs, err = store.Jobs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, jobs, "Artifacts should be pruned by the next sweep once expired")
+}
+
+func TestPinJob(t *testing.T) {
+	store := &minici.FileArtifactStore{Dir: t.TempDir()}
+	ctx := context.Background()
+	ci := citest.New()
+	for _, jobID := range []minici.JobID{"01A", "01B", "01C"} {
+		ci.Add(minici.Job{ID: jobID, RepoURI: "https://example.com/repo.git", Status: minici.JobStatusSuccess})
+		require.NoError(t, store.SetRepo(ctx, jobID, "https://example.com/repo.git"))
+		require.NoError(t, store.Save(ctx, jobID, "app", strings.NewReader("12345"), 5))
+	}
+
+	restServer := NewServer(ci, ":0")
+	restServer.RequireToken("admin")
+	restServer.AddReadOnlyToken("viewer")
+	restServer.EnableArtifacts(store)
+	restServer.EnableArtifactRetention(minici.ArtifactRetention{RetentionPolicy: minici.RetentionPolicy{KeepLast: 1}})
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("PUT", "/api/jobs/01A/pin", "viewer").Code)
+	assert.Equal(t, http.StatusNotFound, request("PUT", "/api/jobs/missing/pin", "admin").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request("POST", "/api/jobs/01A/pin", "admin").Code)
+
+	rr := request("PUT", "/api/jobs/01A/pin", "admin")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.True(t, job.Pinned)
+	assert.True(t, ci.JobDetail("01A").Pinned)
+
+	rr = request("GET", "/api/jobs?pinned=true", "viewer")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"jobs": ["01A"]}`, rr.Body.String())
+	assert.Equal(t, http.StatusBadRequest, request("GET", "/api/jobs?pinned=maybe", "viewer").Code)
+
+	// The pinned job is kept without counting towards keep_last
+	rr = request("POST", "/api/admin/artifacts/prune", "admin")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	jobs, err := store.Jobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, minici.JobID("01A"), jobs[0].JobID)
+	assert.Equal(t, minici.JobID("01C"), jobs[1].JobID)
+
+	rr = request("DELETE", "/api/jobs/01A/pin", "admin")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var unpinned JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&unpinned))
+	assert.False(t, unpinned.Pinned)
+	assert.Empty(t, restServer.ArtifactRetention().Pinned)
+
+	require.Equal(t, http.StatusOK, request("POST", "/api/admin/artifacts/prune", "admin").Code)
+	jobs, err = store.Jobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, minici.JobID("01C"), jobs[0].JobID)
+}
@@ -0,0 +1,79 @@
+package restapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// writeCacheableJSON writes data like writeJSON, along with an ETag of its
+// contents and modified as Last-Modified, or 304 Not Modified if the
+// request's conditional headers show the client already has it. As
+// Last-Modified is to the second and a running job can change several times a
+// second, If-Modified-Since is only honoured if final is set, once the job is
+// done.
+func (s *Server) writeCacheableJSON(w http.ResponseWriter, r *http.Request, data any, modified time.Time, final bool) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	// Clients may keep responses, but must check they're still current
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modified, final) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// notModified returns true if the client's copy of a response is current.
+// If-None-Match takes precedence over If-Modified-Since, as HTTP requires.
+func notModified(r *http.Request, etag string, modified time.Time, final bool) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if !final || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// jobModified returns when a job last changed, as far as it records
+func jobModified(job minici.Job) time.Time {
+	modified := job.CreatedAt
+	for _, at := range []time.Time{job.StartedAt, job.FinishedAt} {
+		if at.After(modified) {
+			modified = at
+		}
+	}
+	if job.Progress != nil && job.Progress.UpdatedAt.After(modified) {
+		modified = job.Progress.UpdatedAt
+	}
+	return modified
+}
@@ -0,0 +1,472 @@
+package restapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// Client talks to a minici REST server
+type Client struct {
+	Server string
+	Token  string
+	HTTP   *http.Client
+}
+
+// NewClient creates a client for the server at the given base URL
+func NewClient(server, token string) *Client {
+	return &Client{
+		Server: strings.TrimRight(server, "/"),
+		Token:  token,
+		HTTP:   &http.Client{},
+	}
+}
+
+// APIError is returned when the server responds with an unexpected status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// do sends a request and decodes a JSON response into out, if provided.
+// Any status not listed in accept results in an APIError.
+func (c *Client) do(method, path string, body interface{}, out interface{}, accept ...int) (int, error) {
+	return c.doWithHeader(method, path, nil, body, out, accept...)
+}
+
+// doWithHeader is do with extra request headers
+func (c *Client) doWithHeader(method, path string, header http.Header, body interface{}, out interface{}, accept ...int) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, c.Server+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if len(accept) == 0 {
+		accept = []int{http.StatusOK}
+	}
+	accepted := false
+	for _, code := range accept {
+		if resp.StatusCode == code {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		var errResp ErrorResponse
+		content, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(content, &errResp) != nil {
+			errResp.Error = strings.TrimSpace(string(content))
+		}
+		return resp.StatusCode, &APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// Submit schedules a job
+func (c *Client) Submit(req JobRequest) (JobResponse, error) {
+	var resp JobResponse
+	_, err := c.do(http.MethodPost, "/api/jobs", req, &resp, http.StatusCreated)
+	return resp, err
+}
+
+// SubmitRange schedules a job for each commit in a range
+func (c *Client) SubmitRange(req RangeRequest) (RangeResponse, error) {
+	var resp RangeResponse
+	_, err := c.do(http.MethodPost, "/api/jobs/range", req, &resp, http.StatusCreated)
+	return resp, err
+}
+
+// DryRun checks a job would be scheduled, returning the job without
+// scheduling it. If checkRepo is set, the server also checks it can reach the
+// repo and finds the commit.
+func (c *Client) DryRun(req JobRequest, checkRepo bool) (DryRunResponse, error) {
+	path := "/api/jobs?dry_run=true"
+	if checkRepo {
+		path += "&check_repo=true"
+	}
+	var resp DryRunResponse
+	_, err := c.do(http.MethodPost, path, req, &resp)
+	return resp, err
+}
+
+// Validate checks the content of a repo config, without scheduling anything
+func (c *Client) Validate(content []byte) (ValidateResponse, error) {
+	var resp ValidateResponse
+	_, err := c.do(http.MethodPost, "/api/validate", ValidateRequest{Content: string(content)}, &resp)
+	return resp, err
+}
+
+// ValidatePipeline checks the content of a Starlark pipeline program, without
+// scheduling anything
+func (c *Client) ValidatePipeline(content []byte) (ValidateResponse, error) {
+	var resp ValidateResponse
+	_, err := c.do(http.MethodPost, "/api/validate", ValidateRequest{Content: string(content), Format: FormatStarlark}, &resp)
+	return resp, err
+}
+
+// List returns the IDs of all jobs
+func (c *Client) List() ([]string, error) {
+	var resp ListJobsResponse
+	_, err := c.do(http.MethodGet, "/api/jobs", nil, &resp)
+	return resp.Jobs, err
+}
+
+// Environments returns the commit each repo last deployed to each
+// environment, only for one repo if repo isn't empty
+func (c *Client) Environments(repo string) ([]EnvironmentResponse, error) {
+	path := "/api/environments"
+	if repo != "" {
+		path += "?repo=" + url.QueryEscape(repo)
+	}
+	var resp EnvironmentsResponse
+	_, err := c.do(http.MethodGet, path, nil, &resp)
+	return resp.Environments, err
+}
+
+// Rollback schedules a job rolling a repo in an environment back to the
+// commit it deployed before its current one. repo may be empty if only one
+// repo deploys to the environment.
+func (c *Client) Rollback(environment, repo string) (JobResponse, error) {
+	var resp JobResponse
+	_, err := c.do(http.MethodPost, "/api/environments/"+url.PathEscape(environment)+"/rollback", RollbackRequest{RepoURI: repo}, &resp, http.StatusCreated)
+	return resp, err
+}
+
+// Rerun schedules a copy of a job, changed by req
+func (c *Client) Rerun(jobID string, req RerunRequest) (JobResponse, error) {
+	var resp JobResponse
+	_, err := c.do(http.MethodPost, "/api/jobs/"+url.PathEscape(jobID)+"/rerun", req, &resp, http.StatusCreated)
+	return resp, err
+}
+
+// Group returns the status of a group of jobs
+func (c *Client) Group(id string) (GroupResponse, error) {
+	var resp GroupResponse
+	_, err := c.do(http.MethodGet, "/api/groups/"+url.PathEscape(id), nil, &resp)
+	return resp, err
+}
+
+// WaitGroup blocks until every job in a group has finished, or the server's
+// wait times out after timeout
+func (c *Client) WaitGroup(id string, timeout time.Duration) (GroupResponse, error) {
+	var resp GroupResponse
+	_, err := c.do(http.MethodGet, "/api/groups/"+url.PathEscape(id)+"/wait?timeout="+url.QueryEscape(timeout.String()), nil, &resp)
+	return resp, err
+}
+
+// WaitMatching blocks until every job matching query, such as
+// group=nightly or label=team=payments, has finished, or the server's wait
+// times out after timeout. The query takes the same filters as listing jobs.
+func (c *Client) WaitMatching(query url.Values, timeout time.Duration) (GroupResponse, error) {
+	values := url.Values{"timeout": {timeout.String()}}
+	for key, value := range query {
+		values[key] = value
+	}
+	var resp GroupResponse
+	_, err := c.do(http.MethodGet, "/api/wait?"+values.Encode(), nil, &resp)
+	return resp, err
+}
+
+// CancelGroup cancels every pending or running job in a group
+func (c *Client) CancelGroup(id string) (GroupResponse, error) {
+	var resp GroupResponse
+	_, err := c.do(http.MethodPost, "/api/groups/"+url.PathEscape(id)+"/cancel", nil, &resp)
+	return resp, err
+}
+
+// CommitSummary returns the verdict of every job run for a commit, limited
+// to repo if it isn't empty. The commit stays pending until a job named after
+// each of required has run.
+func (c *Client) CommitSummary(repo, commit string, required ...string) (CommitSummaryResponse, error) {
+	query := url.Values{"required": required}
+	if repo != "" {
+		query.Set("repo", repo)
+	}
+	var resp CommitSummaryResponse
+	_, err := c.do(http.MethodGet, "/api/commits/"+url.PathEscape(commit)+"/summary?"+query.Encode(), nil, &resp)
+	return resp, err
+}
+
+// Status returns the status and configuration of a job
+func (c *Client) Status(jobID string) (JobResponse, error) {
+	var resp JobResponse
+	_, err := c.do(http.MethodGet, "/api/jobs/"+url.PathEscape(jobID), nil, &resp)
+	return resp, err
+}
+
+// LogOptions choose how the server presents a job's log lines
+type LogOptions struct {
+	// Timestamps prefixes each line with when it was logged, as
+	// TimestampsAbsolute or TimestampsElapsed. Lines are bare by default.
+	Timestamps string
+	// ANSI is what happens to escape sequences in the lines, ANSIKeep,
+	// ANSIStrip or ANSIHTML. They're kept by default.
+	ANSI string
+}
+
+// query adds the options to the query parameters of a logs request
+func (o LogOptions) query(query url.Values) url.Values {
+	if o.Timestamps != "" {
+		query.Set("timestamps", o.Timestamps)
+	}
+	if o.ANSI != "" {
+		query.Set("ansi", o.ANSI)
+	}
+	return query
+}
+
+// Logs returns the logs of a job
+func (c *Client) Logs(jobID string) ([]string, error) {
+	return c.LogsWithOptions(jobID, LogOptions{})
+}
+
+// LogsWithOptions returns the logs of a job, presented as opts chooses
+func (c *Client) LogsWithOptions(jobID string, opts LogOptions) ([]string, error) {
+	path := "/api/jobs/" + url.PathEscape(jobID) + "/logs"
+	if query := opts.query(url.Values{}); len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var resp JobResponse
+	_, err := c.do(http.MethodGet, path, nil, &resp)
+	return resp.Logs, err
+}
+
+// FollowLogs streams a job's logs, calling onLine for each line until the job
+// completes, and returns the job's final status. If the stream is interrupted
+// it is resumed from the last line received.
+func (c *Client) FollowLogs(jobID string, onLine func(line string)) (string, error) {
+	return c.FollowLogsWithOptions(jobID, LogOptions{}, onLine)
+}
+
+// FollowLogsWithOptions streams a job's logs like FollowLogs, presented as
+// opts chooses
+func (c *Client) FollowLogsWithOptions(jobID string, opts LogOptions, onLine func(line string)) (string, error) {
+	next := 0
+	failures := 0
+	for {
+		status, received, err := c.streamLogs(jobID, next, opts, onLine)
+		next += received
+		if err == nil {
+			return status, nil
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return "", err
+		}
+		// Only give up if the stream repeatedly fails without making progress
+		if received == 0 {
+			failures++
+		} else {
+			failures = 0
+		}
+		if failures >= 5 {
+			return "", err
+		}
+		time.Sleep(time.Duration(failures) * time.Second)
+	}
+}
+
+// streamLogs reads the log stream once from the given line, returning the final
+// status if the job completed and the number of lines received.
+func (c *Client) streamLogs(jobID string, from int, opts LogOptions, onLine func(line string)) (string, int, error) {
+	query := opts.query(url.Values{"from": {strconv.Itoa(from)}})
+	path := fmt.Sprintf("/api/jobs/%s/logs/stream?%s", url.PathEscape(jobID), query.Encode())
+	req, err := http.NewRequest(http.MethodGet, c.Server+path, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		content, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(content, &errResp) != nil {
+			errResp.Error = strings.TrimSpace(string(content))
+		}
+		return "", 0, &APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	received := 0
+	var event string
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line dispatches the event
+			switch event {
+			case "log":
+				onLine(strings.Join(data, "\n"))
+				received++
+			case "done":
+				var job JobResponse
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &job); err != nil {
+					return "", received, fmt.Errorf("failed to decode done event: %w", err)
+				}
+				return job.Status, received, nil
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(line, "data:")
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+		// IDs are ignored, progress is tracked by counting lines instead
+	}
+	if err := scanner.Err(); err != nil {
+		return "", received, err
+	}
+	return "", received, io.ErrUnexpectedEOF
+}
+
+// Cancel stops a pending or running job
+func (c *Client) Cancel(jobID string) (JobResponse, error) {
+	var resp JobResponse
+	_, err := c.do(http.MethodPost, "/api/jobs/"+url.PathEscape(jobID)+"/cancel", nil, &resp)
+	return resp, err
+}
+
+// Pin keeps a job's artifacts however old they are, or hands them back to
+// retention if pinned is false
+func (c *Client) Pin(jobID string, pinned bool) (JobResponse, error) {
+	method := http.MethodPut
+	if !pinned {
+		method = http.MethodDelete
+	}
+	var resp JobResponse
+	_, err := c.do(method, "/api/jobs/"+url.PathEscape(jobID)+"/pin", nil, &resp)
+	return resp, err
+}
+
+// Heartbeat tells the server a running job is alive, along with its progress
+// if req sets any. The client's token must be the job's token.
+func (c *Client) Heartbeat(jobID string, req HeartbeatRequest) error {
+	_, err := c.do(http.MethodPost, "/api/jobs/"+url.PathEscape(jobID)+"/heartbeat", req, nil, http.StatusNoContent)
+	return err
+}
+
+// ClaimJob asks the server for a job for the named agent to run, returning
+// nil if none are waiting
+func (c *Client) ClaimJob(agent string, req AgentRequest) (*AgentJobResponse, error) {
+	var resp AgentJobResponse
+	status, err := c.do(http.MethodPost, "/api/agents/"+url.PathEscape(agent)+"/claim", req, &resp, http.StatusOK, http.StatusNoContent)
+	if err != nil || status == http.StatusNoContent {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ReportLogs appends lines to the logs of a job claimed by the agent
+func (c *Client) ReportLogs(agent, jobID string, lines []string) error {
+	_, err := c.do(http.MethodPost, "/api/agents/"+url.PathEscape(agent)+"/jobs/"+url.PathEscape(jobID)+"/logs",
+		AgentLogsRequest{Lines: lines}, nil, http.StatusNoContent)
+	return err
+}
+
+// FinishJob reports the result of a job claimed by the agent
+func (c *Client) FinishJob(agent, jobID string, result AgentFinishRequest) error {
+	_, err := c.do(http.MethodPost, "/api/agents/"+url.PathEscape(agent)+"/jobs/"+url.PathEscape(jobID)+"/finish",
+		result, nil, http.StatusNoContent)
+	return err
+}
+
+// WaitAll blocks until every job on the server has completed, returning true
+// if none failed along with the server's description of the outcome.
+func (c *Client) WaitAll() (bool, string, error) {
+	// The wait endpoint sends its headers immediately, so the outcome is only
+	// available from the body.
+	var resp WaitResponse
+	header := http.Header{"Accept": {WaitResponseType}}
+	_, err := c.doWithHeader(http.MethodGet, "/api/wait", header, nil, &resp, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return false, "", err
+	}
+	return resp.Success, resp.Message, nil
+}
+
+// WaitJobs polls the given jobs until they have all completed, returning
+// their final state.
+func (c *Client) WaitJobs(jobIDs []string, interval time.Duration) ([]JobResponse, error) {
+	results := make([]JobResponse, len(jobIDs))
+	remaining := make(map[int]bool)
+	for i := range jobIDs {
+		remaining[i] = true
+	}
+
+	for {
+		for i := range remaining {
+			status, err := c.Status(jobIDs[i])
+			if err != nil {
+				return nil, err
+			}
+			if minici.JobStatus(status.Status).Done() {
+				results[i] = status
+				delete(remaining, i)
+			}
+		}
+		if len(remaining) == 0 {
+			return results, nil
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,165 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, token string) (*Client, *mockCI) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	if token != "" {
+		restServer.RequireToken(token)
+	}
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+
+	return NewClient(srv.URL+"/", token), ci
+}
+
+func TestClient(t *testing.T) {
+	client, ci := newTestClient(t, "")
+
+	resp, err := client.Submit(JobRequest{
+		RepoURI: "https://github.com/ocuroot/minici",
+		Commit:  "main",
+		Command: "go test ./...",
+		Labels:  map[string]string{"team": "core"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "job-1", resp.ID)
+
+	jobs, err := client.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"job-1"}, jobs)
+
+	ci.createCompletedJob("job-done", "https://github.com/ocuroot/minici", "main", "go test ./...")
+
+	status, err := client.Status("job-done")
+	require.NoError(t, err)
+	assert.Equal(t, "success", status.Status)
+	assert.Equal(t, "main", status.Commit)
+
+	logs, err := client.Logs("job-done")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Job scheduled", "Job started", "Job completed successfully"}, logs)
+
+	_, err = client.Cancel("job-done")
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+
+	_, err = client.Cancel("missing")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	results, err := client.WaitJobs([]string{"job-done"}, time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "success", results[0].Status)
+
+	_, err = client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	validation, err := client.Validate([]byte("images:\n  - tags: [latest]\n"))
+	require.NoError(t, err)
+	assert.False(t, validation.Valid)
+	require.Len(t, validation.Errors, 1)
+	assert.Equal(t, "images[0]", validation.Errors[0].Field)
+
+	validation, err = client.ValidatePipeline([]byte("pipeline(images = [{'tags': ['latest']}])"))
+	require.NoError(t, err)
+	assert.False(t, validation.Valid)
+	require.Len(t, validation.Errors, 1)
+	assert.Equal(t, "images[0]", validation.Errors[0].Field)
+}
+
+func TestClientCancel(t *testing.T) {
+	client, ci := newTestClient(t, "")
+
+	ci.jobs["job-running"] = &minici.Job{ID: "job-running", Status: minici.JobStatusRunning}
+
+	resp, err := client.Cancel("job-running")
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", resp.Status)
+}
+
+func TestClientWaitAll(t *testing.T) {
+	client, ci := newTestClient(t, "")
+	ci.createCompletedJob("job-done", "https://github.com/ocuroot/minici", "main", "go test ./...")
+
+	ok, message, err := client.WaitAll()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "all jobs completed successfully", message)
+
+	// Clients that don't accept a WaitResponse get the message alone, as
+	// older clients expect
+	resp, err := http.Get(client.Server + "/api/wait")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var plain string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&plain))
+	assert.Equal(t, "all jobs completed successfully", plain)
+
+	ci.updateJob("job-done", func(job *minici.Job) { job.Status = minici.JobStatusFailure })
+	ok, message, err = client.WaitAll()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "one or more jobs failed", message)
+}
+
+func TestClientToken(t *testing.T) {
+	client, _ := newTestClient(t, "s3cret")
+
+	_, err := client.List()
+	require.NoError(t, err)
+
+	client.Token = "wrong"
+	_, err = client.List()
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestClientFollowLogs(t *testing.T) {
+	client, ci := newTestClient(t, "")
+
+	job := &minici.Job{ID: "job-follow", Status: minici.JobStatusRunning}
+	ci.jobs["job-follow"] = job
+	ci.logs["job-follow"] = []string{"first"}
+
+	var lines []string
+	done := make(chan string)
+	go func() {
+		status, err := client.FollowLogs("job-follow", func(line string) {
+			lines = append(lines, line)
+		})
+		assert.NoError(t, err)
+		done <- status
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	ci.mu.Lock()
+	ci.logs["job-follow"] = append(ci.logs["job-follow"], "second", "third")
+	job.Status = minici.JobStatusFailure
+	ci.mu.Unlock()
+
+	select {
+	case status := <-done:
+		assert.Equal(t, "failure", status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out following logs")
+	}
+	assert.Equal(t, []string{"first", "second", "third"}, lines)
+}
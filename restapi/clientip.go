@@ -0,0 +1,133 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies, such as nginx or a cloud load
+// balancer, whose X-Forwarded-For and X-Real-IP headers say which client
+// made a request
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	// unix trusts connections over Unix sockets, which have no address
+	unix bool
+}
+
+// ParseTrustedProxies parses CIDR ranges such as 10.0.0.0/8, single
+// addresses, and unix for proxies connecting over a Unix socket
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, value := range values {
+		if value == "unix" {
+			proxies.unix = true
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return TrustedProxies{}, fmt.Errorf("invalid trusted proxy %q, expected a CIDR range, an IP address or unix", value)
+		}
+		addr = addr.Unmap()
+		proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// trusts returns true if addr, an IP address, is a trusted proxy
+func (p TrustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made a request. If the
+// request came from a trusted proxy, the forwarding headers are followed back
+// through any other trusted proxies to the first address that isn't one.
+// Otherwise, the headers could be forged, so the connection's address is used.
+func (p TrustedProxies) clientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil {
+		// Connections over Unix sockets have no address
+		if !p.unix {
+			return peer
+		}
+	} else if !p.trusts(addr) {
+		return addr.Unmap().String()
+	}
+
+	// Each proxy appends the address it received the request from
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(hop))
+		}
+	}
+	client := ""
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(forwarded[i])
+		if err != nil {
+			break
+		}
+		client = hop.Unmap().String()
+		if !p.trusts(hop) {
+			return client
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+	return peer
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the address of the client that made the API request ctx
+// belongs to, as forwarded by trusted proxies, such as for rate limiting in
+// middleware. It's an empty string outside a request.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// clientIP returns the address of the client that made a request, even if it
+// was handled without the middleware recording it
+func (s *Server) clientIP(r *http.Request) string {
+	if ip := ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	return s.trustedProxies.clientIP(r)
+}
+
+// SetTrustedProxies honours the X-Forwarded-For and X-Real-IP headers of
+// requests from the proxies, for ClientIP and the addresses logged for each
+// request. Without trusted proxies the connection's address is used.
+func (s *Server) SetTrustedProxies(proxies TrustedProxies) {
+	s.trustedProxies = proxies
+}
+
+// WithTrustedProxies honours forwarding headers from the proxies. See
+// SetTrustedProxies.
+func WithTrustedProxies(proxies TrustedProxies) Option {
+	return func(s *Server) {
+		s.SetTrustedProxies(proxies)
+	}
+}
@@ -0,0 +1,98 @@
+package restapi
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ocuroot/minici"
+)
+
+// commitSHAPattern matches the full or abbreviated hashes commits can be
+// summarized by
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// CommitSummaryResponse is the verdict of every job run for a commit
+type CommitSummaryResponse struct {
+	Commit  string `json:"commit"`
+	RepoURI string `json:"repo_uri,omitempty"`
+	// Status is failure if any check's latest job didn't succeed, pending
+	// while any is still to finish, no jobs have run or a required check is
+	// missing, and success once every check has succeeded
+	Status string `json:"status"`
+	// Passed is set once the status is success
+	Passed bool `json:"passed"`
+	// Counts are how many checks' latest jobs have each status
+	Counts map[string]int        `json:"counts"`
+	Checks []CommitCheckResponse `json:"checks"`
+	// Missing are the required checks no job has been run for
+	Missing []string `json:"missing,omitempty"`
+}
+
+// CommitCheckResponse describes one of the checks run for a commit
+type CommitCheckResponse struct {
+	// Name is its jobs' name, or their command if they weren't named
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Attempts counts the jobs run for the check, including re-runs
+	Attempts int `json:"attempts"`
+	// Job is the latest job run for the check
+	Job JobResponse `json:"job"`
+}
+
+// NewCommitSummaryResponse describes a commit's summary, without its jobs'
+// logs
+func NewCommitSummaryResponse(summary minici.CommitSummary, repo string) CommitSummaryResponse {
+	resp := CommitSummaryResponse{
+		Commit:  summary.Commit,
+		RepoURI: repo,
+		Status:  string(summary.Status),
+		Passed:  summary.Passed(),
+		Counts:  map[string]int{},
+		Checks:  []CommitCheckResponse{},
+		Missing: summary.Missing,
+	}
+	for _, check := range summary.Checks {
+		resp.Counts[string(check.Job.Status)]++
+		resp.Checks = append(resp.Checks, CommitCheckResponse{
+			Name:     check.Name,
+			Status:   string(check.Job.Status),
+			Attempts: check.Attempts,
+			Job:      NewJobResponse(check.Job),
+		})
+	}
+	return resp
+}
+
+// handleCommits routes requests for /api/commits/<sha>/summary
+func (s *Server) handleCommits(w http.ResponseWriter, r *http.Request) {
+	commit, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/commits/"), "/")
+	if commit == "" || action != "summary" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.handleCommitSummary(w, r, commit)
+}
+
+// handleCommitSummary aggregates the jobs run for a commit, optionally in
+// one repo, into a single verdict. Each required parameter names a check
+// that must have passed, so the commit stays pending until it has run.
+func (s *Server) handleCommitSummary(w http.ResponseWriter, r *http.Request, commit string) {
+	if !commitSHAPattern.MatchString(commit) {
+		s.writeError(w, "Commit must be a hash of at least 7 hex digits", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	repo := query.Get("repo")
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{RepoURI: repo, Project: projectFilter(r)})
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	summary := minici.SummarizeCommit(strings.ToLower(commit), jobs, query["required"])
+	s.writeJSON(w, NewCommitSummaryResponse(summary, repo), http.StatusOK)
+}
@@ -0,0 +1,42 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ocuroot/minici"
+)
+
+// coverageBadge is a flat badge in the style of shields.io
+const coverageBadge = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="coverage: %[3]s">
+  <rect width="62" height="20" fill="#555"/>
+  <rect x="62" width="%[2]d" height="20" fill="%[4]s"/>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">
+    <text x="31" y="14">coverage</text>
+    <text x="%[5]d" y="14">%[3]s</text>
+  </g>
+</svg>
+`
+
+// handleCoverageBadge renders the coverage of the repo's most recent job that reported it
+func (s *Server) handleCoverageBadge(w http.ResponseWriter, r *http.Request, repo string) {
+	label, color := "unknown", "#9f9f9f"
+	jobs, _, _ := s.ci.QueryJobs(minici.JobFilter{RepoURI: repo, Project: projectFilter(r)})
+	if coverage, ok := minici.LatestCoverage(jobs, repo); ok {
+		label = fmt.Sprintf("%.0f%%", coverage)
+		switch {
+		case coverage >= 80:
+			color = "#4c1"
+		case coverage >= 60:
+			color = "#dfb317"
+		default:
+			color = "#e05d44"
+		}
+	}
+
+	// Roughly 7px per character, plus padding
+	width := 7*len(label) + 12
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, coverageBadge, 62+width, width, label, color, 62+width/2)
+}
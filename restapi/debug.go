@@ -0,0 +1,67 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+// RuntimeResponse describes the state of the server's Go runtime
+type RuntimeResponse struct {
+	GoVersion  string `json:"go_version"`
+	CPUs       int    `json:"cpus"`
+	Goroutines int    `json:"goroutines"`
+	// HeapAlloc is the bytes of live and not yet freed heap objects
+	HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	// Sys is the bytes of memory obtained from the OS
+	Sys   uint64 `json:"sys_bytes"`
+	NumGC uint32 `json:"num_gc"`
+}
+
+// DebugHandler serves Go's pprof profiles under /debug/pprof/, along with
+// /debug/goroutines, a dump of every goroutine's stack, and /debug/runtime,
+// goroutine and memory counts. It isn't authenticated and profiles reveal
+// the server's internals, so serve it on a listener only admins can reach.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", handleGoroutines)
+	mux.HandleFunc("/debug/runtime", handleRuntime)
+	return mux
+}
+
+// handleGoroutines writes the stacks of every goroutine as text. Goroutines
+// with the same stack are grouped, with the ID of the job they run labelled,
+// unless full=1 is given for each goroutine's stack separately.
+func handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	debug := 1
+	if r.URL.Query().Get("full") == "1" {
+		debug = 2
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%d goroutines\n\n", runtime.NumGoroutine())
+	rpprof.Lookup("goroutine").WriteTo(w, debug)
+}
+
+func handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RuntimeResponse{
+		GoVersion:   runtime.Version(),
+		CPUs:        runtime.NumCPU(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   stats.HeapAlloc,
+		HeapObjects: stats.HeapObjects,
+		Sys:         stats.Sys,
+		NumGC:       stats.NumGC,
+	})
+}
@@ -0,0 +1,51 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(t *testing.T) {
+	srv := httptest.NewServer(DebugHandler())
+	t.Cleanup(srv.Close)
+
+	// A goroutine labelled as a job's, as the CI server labels them
+	stop := make(chan struct{})
+	defer close(stop)
+	started := make(chan struct{})
+	go pprof.Do(context.Background(), pprof.Labels("job_id", "job-1"), func(context.Context) {
+		close(started)
+		<-stop
+	})
+	<-started
+
+	resp, err := http.Get(srv.URL + "/debug/goroutines")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "goroutines\n")
+	assert.Contains(t, string(body), `"job_id":"job-1"`)
+
+	resp, err = http.Get(srv.URL + "/debug/runtime")
+	require.NoError(t, err)
+	var runtime RuntimeResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&runtime))
+	resp.Body.Close()
+	assert.Greater(t, runtime.Goroutines, 1)
+	assert.NotEmpty(t, runtime.GoVersion)
+
+	resp, err = http.Get(srv.URL + "/debug/pprof/heap?debug=1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
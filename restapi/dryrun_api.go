@@ -0,0 +1,102 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// dryRunResolveTimeout limits how long a dry run waits for a repo to respond
+const dryRunResolveTimeout = 30 * time.Second
+
+// DryRunResponse describes the job scheduling a request would create
+type DryRunResponse struct {
+	// Job is the job that would be scheduled, without an ID
+	Job JobResponse `json:"job"`
+	// ResolvedCommit is the hash the job's commit refers to, if check_repo
+	// was set
+	ResolvedCommit string `json:"resolved_commit,omitempty"`
+	// Warnings are things that weren't checked or may not go as expected
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// IsDryRun reports whether a request to schedule a job is a dry run, so
+// hooks given to OnBeforeSchedule can avoid side effects such as counting
+// the job towards a quota
+func IsDryRun(r *http.Request) bool {
+	dryRun, _ := queryBool(r, "dry_run")
+	return dryRun
+}
+
+// queryBool parses a query parameter as a boolean, false if it's missing
+func queryBool(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return parsed, nil
+}
+
+// handleDryRun responds with the job spec would schedule, once the request
+// has passed the hooks and policy checks, without scheduling it
+func (s *Server) handleDryRun(w http.ResponseWriter, r *http.Request, spec minici.JobSpec, checkRepo bool) {
+	runner, ok := s.ci.(minici.DryRunner)
+	if !ok {
+		s.writeError(w, "Dry runs aren't supported by this server", http.StatusNotImplemented)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dryRunResolveTimeout)
+	defer cancel()
+	run, err := runner.DryRun(ctx, spec, checkRepo)
+	switch {
+	case errors.Is(err, minici.ErrQueueFull):
+		w.Header().Set("Retry-After", strconv.Itoa(int(queueFullRetryAfter/time.Second)))
+		s.writeError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, minici.ErrRefResolveUnsupported):
+		s.writeError(w, "This server can't check repos without cloning them", http.StatusNotImplemented)
+		return
+	case err != nil:
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := DryRunResponse{
+		Job: NewJobResponse(minici.Job{
+			Status:      minici.JobStatusPending,
+			Number:      run.Number,
+			RepoURI:     spec.RepoURI,
+			Commit:      spec.Commit,
+			Branch:      spec.Branch,
+			Command:     spec.Command,
+			Name:        spec.Name,
+			Project:     spec.Project,
+			Environment: spec.Environment,
+			Group:       spec.Group,
+			Labels:      spec.Labels,
+			Image:       spec.Image,
+			RunsOn:      spec.RunsOn,
+			Needs:       spec.Needs,
+
+			StallTimeout: spec.StallTimeout,
+			PullRequest:  spec.PullRequest,
+			Urgent:       spec.Urgent,
+		}),
+		ResolvedCommit: run.Commit,
+	}
+	if !checkRepo {
+		resp.Warnings = append(resp.Warnings, "The repo wasn't checked, set check_repo=true to check the commit exists")
+	} else if run.Commit == spec.Commit {
+		resp.Warnings = append(resp.Warnings, "The repo is reachable, but commit hashes can't be checked without cloning it")
+	}
+	s.writeJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,113 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// EnvironmentsResponse lists the environments jobs have deployed to
+type EnvironmentsResponse struct {
+	Environments []EnvironmentResponse `json:"environments"`
+}
+
+// EnvironmentResponse is an environment with what each repo last deployed to it
+type EnvironmentResponse struct {
+	Name        string               `json:"name"`
+	Deployments []DeploymentResponse `json:"deployments"`
+}
+
+// DeploymentResponse is the latest job that successfully deployed a repo to
+// an environment
+type DeploymentResponse struct {
+	RepoURI    string    `json:"repo_uri"`
+	Commit     string    `json:"commit"`
+	JobID      string    `json:"job_id"`
+	Number     int       `json:"number,omitempty"`
+	DeployedAt time.Time `json:"deployed_at"`
+}
+
+// RollbackRequest is the optional body of a request to roll back an
+// environment
+type RollbackRequest struct {
+	// RepoURI is the repo to roll back, required if several deploy to the
+	// environment
+	RepoURI string `json:"repo_uri,omitempty"`
+}
+
+// handleEnvironments processes requests for the commit each repo last
+// deployed to each environment, optionally for one repo
+func (s *Server) handleEnvironments(w http.ResponseWriter, r *http.Request) {
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{
+		Statuses: []minici.JobStatus{minici.JobStatusSuccess},
+		RepoURI:  r.URL.Query().Get("repo"),
+		Project:  projectFilter(r),
+	})
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := EnvironmentsResponse{Environments: []EnvironmentResponse{}}
+	for _, environment := range minici.Environments(jobs) {
+		environmentResponse := EnvironmentResponse{Name: environment.Name, Deployments: []DeploymentResponse{}}
+		for _, deployment := range environment.Deployments {
+			environmentResponse.Deployments = append(environmentResponse.Deployments, DeploymentResponse{
+				RepoURI:    deployment.RepoURI,
+				Commit:     deployment.Commit,
+				JobID:      string(deployment.JobID),
+				Number:     deployment.Number,
+				DeployedAt: deployment.DeployedAt,
+			})
+		}
+		resp.Environments = append(resp.Environments, environmentResponse)
+	}
+	s.writeJSON(w, resp, http.StatusOK)
+}
+
+// handleRollback processes requests to roll a repo in an environment back to
+// the commit it deployed before its current one, scheduling the job that
+// deployed that commit again. Like scheduling a job, it supports dry runs.
+func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request, environment string) {
+	dryRun, err := queryBool(r, "dry_run")
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{
+		Statuses: []minici.JobStatus{minici.JobStatusSuccess},
+		RepoURI:  req.RepoURI,
+		Project:  tokenProject(r),
+	})
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spec, err := minici.RollbackSpec(jobs, environment, req.RepoURI)
+	switch {
+	case errors.Is(err, minici.ErrUnknownEnvironment):
+		s.writeError(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, minici.ErrRepoRequired):
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		s.writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	job, ok := s.schedule(w, r, spec, dryRun, false)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, NewJobResponse(job), http.StatusCreated)
+}
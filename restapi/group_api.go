@@ -0,0 +1,187 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// defaultGroupWait and maxGroupWait limit how long waiting for a group
+// blocks, keeping it within the server's write timeout. Clients wait longer
+// by asking again.
+const (
+	defaultGroupWait = time.Minute
+	maxGroupWait     = 4 * time.Minute
+)
+
+// GroupResponse describes a group of jobs and their status as a whole
+type GroupResponse struct {
+	// ID is empty when waiting for jobs matching other filters
+	ID string `json:"id,omitempty"`
+	// Status is running while any job is pending or running, then failure
+	// if any failed, cancelled if any were cancelled, or else success
+	Status string `json:"status"`
+	// Counts are how many of the jobs have each status
+	Counts map[string]int `json:"counts"`
+	Jobs   []JobResponse  `json:"jobs"`
+}
+
+// NewGroupResponse describes a group, without its jobs' logs
+func NewGroupResponse(group minici.Group) GroupResponse {
+	resp := GroupResponse{
+		ID:     group.ID,
+		Status: string(group.Status),
+		Counts: map[string]int{},
+		Jobs:   []JobResponse{},
+	}
+	for status, count := range group.Counts {
+		resp.Counts[string(status)] = count
+	}
+	for _, job := range group.Jobs {
+		resp.Jobs = append(resp.Jobs, NewJobResponse(job))
+	}
+	return resp
+}
+
+// handleGroups routes requests for a group at /api/groups/<id>, optionally
+// followed by wait or cancel
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/groups/"), "/")
+	if id == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	method := http.MethodGet
+	if action == "cancel" {
+		method = http.MethodPost
+	}
+	switch {
+	case action != "" && action != "wait" && action != "cancel":
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case r.Method != method:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	group, ok := s.group(w, r, id)
+	if !ok {
+		return
+	}
+	switch action {
+	case "wait":
+		s.handleWaitGroup(w, r, group)
+	case "cancel":
+		s.handleCancelGroup(w, r, group)
+	default:
+		s.writeJSON(w, NewGroupResponse(group), http.StatusOK)
+	}
+}
+
+// group looks up a group's jobs, responding with 404 if it has none the
+// request can see
+func (s *Server) group(w http.ResponseWriter, r *http.Request, id string) (minici.Group, bool) {
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{Group: id, Project: tokenProject(r)})
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return minici.Group{}, false
+	}
+	if len(jobs) == 0 {
+		s.writeError(w, "Group not found", http.StatusNotFound)
+		return minici.Group{}, false
+	}
+	return minici.NewGroup(id, jobs), true
+}
+
+// waitFilters are the query parameters that make /api/wait wait for the jobs
+// matching them, rather than every job
+var waitFilters = []string{"group", "repo", "label", "since", "until", "status", "project"}
+
+// isFilteredWait reports whether a wait request selects the jobs to wait for
+func isFilteredWait(r *http.Request) bool {
+	query := r.URL.Query()
+	for _, name := range waitFilters {
+		if query.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWaitMatching blocks until every job matching the request's filters,
+// such as a group or label, has finished, responding with them as a group.
+// Jobs that start matching while waiting aren't waited for.
+func (s *Server) handleWaitMatching(w http.ResponseWriter, r *http.Request) {
+	filter, err := jobFilter(r)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, _, err := s.ci.QueryJobs(filter)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(jobs) == 0 {
+		s.writeError(w, "No jobs match", http.StatusNotFound)
+		return
+	}
+	s.handleWaitGroup(w, r, minici.NewGroup(filter.Group, jobs))
+}
+
+// handleWaitGroup blocks until every job in a group has finished, up to the
+// timeout query parameter. Jobs added to the group while waiting aren't
+// waited for.
+func (s *Server) handleWaitGroup(w http.ResponseWriter, r *http.Request, group minici.Group) {
+	timeout := defaultGroupWait
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxGroupWait {
+			s.writeError(w, fmt.Sprintf("timeout must be a duration up to %s", maxGroupWait), http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	jobs, err := s.ci.WaitForJobs(ctx, group.JobIDs()...)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.writeError(w, "Timed out waiting for the group's jobs to finish", http.StatusRequestTimeout)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, NewGroupResponse(minici.NewGroup(group.ID, jobs)), http.StatusOK)
+}
+
+// handleCancelGroup cancels every pending or running job in a group,
+// responding with the group once they're cancelled. Jobs that finish first
+// are left as they are.
+func (s *Server) handleCancelGroup(w http.ResponseWriter, r *http.Request, group minici.Group) {
+	var failed []string
+	for _, job := range group.Jobs {
+		if job.Status.Done() {
+			continue
+		}
+		err := s.ci.CancelJob(job.ID)
+		if err != nil && !errors.Is(err, minici.ErrJobFinished) {
+			failed = append(failed, fmt.Sprintf("%s: %v", job.ID, err))
+		}
+	}
+	if len(failed) > 0 {
+		s.writeError(w, "Failed to cancel "+strings.Join(failed, ", "), http.StatusConflict)
+		return
+	}
+	group, ok := s.group(w, r, group.ID)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, NewGroupResponse(group), http.StatusOK)
+}
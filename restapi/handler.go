@@ -0,0 +1,151 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/notify"
+)
+
+// Option configures the handler returned by NewHandler
+type Option func(*Server)
+
+// WithToken rejects API requests that don't present the token, or a token
+// given to WithReadOnlyToken, as a bearer token
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.RequireToken(token)
+	}
+}
+
+// WithReadOnlyToken accepts a token that may only make requests that don't
+// modify anything. It has no effect without WithToken.
+func WithReadOnlyToken(token string) Option {
+	return func(s *Server) {
+		s.AddReadOnlyToken(token)
+	}
+}
+
+// WithWebhooks serves the endpoints for registering webhooks, which are added
+// to the dispatcher as targets
+func WithWebhooks(dispatcher *notify.Dispatcher) Option {
+	return func(s *Server) {
+		s.EnableWebhooks(dispatcher)
+	}
+}
+
+// WithArtifacts serves the artifacts jobs saved to store
+func WithArtifacts(store minici.ArtifactStore) Option {
+	return func(s *Server) {
+		s.EnableArtifacts(store)
+	}
+}
+
+// WithJobPolicy restricts the repos and commands jobs can be scheduled with
+func WithJobPolicy(policy minici.JobPolicy) Option {
+	return func(s *Server) {
+		s.SetJobPolicy(policy)
+	}
+}
+
+// WithMiddleware wraps every request in middleware, such as to authenticate
+// requests another way or to change them before they're routed. See Use.
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.Use(middleware...)
+	}
+}
+
+// WithBeforeSchedule calls hook before each job is scheduled. See
+// OnBeforeSchedule.
+func WithBeforeSchedule(hook BeforeScheduleHook) Option {
+	return func(s *Server) {
+		s.OnBeforeSchedule(hook)
+	}
+}
+
+// WithScheduled calls hook after each job is scheduled. See OnScheduled.
+func WithScheduled(hook ScheduledHook) Option {
+	return func(s *Server) {
+		s.OnScheduled(hook)
+	}
+}
+
+// NewHandler serves the API and dashboard for ci, so they can be mounted in an
+// existing HTTP server. The API is under /api, so mount the handler at the
+// root, or strip the prefix it's mounted at with http.StripPrefix. The agent,
+// log search and repo stats endpoints are served if ci supports them. Use
+// NewServer for settings that can be changed while running, such as tokens
+// and projects.
+func NewHandler(ci minici.CI, opts ...Option) http.Handler {
+	s := NewServer(ci, "")
+	if pool, ok := ci.(minici.AgentPool); ok {
+		s.EnableAgents(pool)
+	}
+	if searcher, ok := ci.(minici.LogSearcher); ok {
+		s.EnableLogSearch(searcher)
+	}
+	if collector, ok := ci.(minici.StatsCollector); ok {
+		s.EnableRepoStats(collector)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ServeHTTP serves the API and dashboard, requiring tokens if RequireToken
+// was used, so a Server can be mounted in another HTTP server instead of
+// calling Start
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.server.Handler.ServeHTTP(w, r)
+}
+
+// BeforeScheduleHook is called with each job about to be scheduled through the
+// API, and may change it. Returning an error rejects the job, with a 403
+// unless the error is an *APIError, whose status code and message are used.
+type BeforeScheduleHook func(r *http.Request, spec *minici.JobSpec) error
+
+// ScheduledHook is called with each job scheduled through the API
+type ScheduledHook func(r *http.Request, job minici.Job)
+
+// Use wraps every request in middleware, the first given outermost. Middleware
+// runs before tokens are checked, so it can authenticate requests itself or
+// reject them first.
+func (s *Server) Use(middleware ...func(http.Handler) http.Handler) {
+	s.middleware = append(s.middleware, middleware...)
+	s.buildHandler()
+}
+
+// OnBeforeSchedule calls hook before each job is scheduled, once the request
+// has been checked and before the job policy is, such as to check quotas or
+// fill in defaults. Hooks run in the order they were added, and the first to
+// return an error rejects the job.
+func (s *Server) OnBeforeSchedule(hook BeforeScheduleHook) {
+	s.beforeSchedule = append(s.beforeSchedule, hook)
+}
+
+// OnScheduled calls hook after each job is scheduled, before the response is
+// sent
+func (s *Server) OnScheduled(hook ScheduledHook) {
+	s.onScheduled = append(s.onScheduled, hook)
+}
+
+// buildHandler wraps the router in the token check and middleware, for the
+// admin API and the rest of the API
+func (s *Server) buildHandler() {
+	s.server.Handler = s.wrap(s.scoped(false))
+	s.admin = s.wrap(s.scoped(true))
+}
+
+// wrap wraps handler in the token check and middleware, with request IDs and
+// client IPs recorded outermost so middleware can use them too
+func (s *Server) wrap(handler http.Handler) http.Handler {
+	if s.requireAuth {
+		handler = s.requireToken(handler)
+	}
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	return s.withRequestInfo(handler)
+}
@@ -0,0 +1,77 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// HeartbeatRequest is the optional body of a heartbeat from a job's commands
+type HeartbeatRequest struct {
+	// Percent is how complete the job is, from 0 to 100
+	Percent *float64 `json:"percent,omitempty"`
+	// Message describes what the job is doing
+	Message string `json:"message,omitempty"`
+}
+
+// ProgressResponse is what a running job last reported through a heartbeat
+type ProgressResponse struct {
+	Percent   *float64  `json:"percent,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func progressToResponse(progress *minici.JobProgress) *ProgressResponse {
+	if progress == nil {
+		return nil
+	}
+	return &ProgressResponse{
+		Percent:   progress.Percent,
+		Message:   progress.Message,
+		UpdatedAt: progress.UpdatedAt,
+	}
+}
+
+// isHeartbeatPath returns true for /api/jobs/<id>/heartbeat, the only path
+// job tokens can be used for
+func isHeartbeatPath(path string) bool {
+	id, action, ok := strings.Cut(strings.TrimPrefix(path, "/api/jobs/"), "/")
+	return ok && strings.HasPrefix(path, "/api/jobs/") && id != "" && action == "heartbeat"
+}
+
+// handleHeartbeat records that a running job is alive, and its progress if the
+// body includes any. The request must present the job's token, which its
+// commands have as $MINICI_JOB_TOKEN.
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request, jobID string) {
+	reporter, ok := s.ci.(minici.ProgressReporter)
+	if !ok {
+		s.writeError(w, "Heartbeats are not supported", http.StatusNotImplemented)
+		return
+	}
+	var req HeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, _ := bearerToken(r)
+	err := reporter.Heartbeat(minici.JobID(jobID), token, req.Percent, req.Message)
+	switch {
+	case errors.Is(err, minici.ErrInvalidJobToken):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.writeError(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, minici.ErrJobNotFound):
+		s.writeError(w, "Job not found", http.StatusNotFound)
+	case errors.Is(err, minici.ErrJobFinished):
+		s.writeError(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,116 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// Values of the timestamps query parameter of the logs endpoints
+const (
+	// TimestampsNone returns bare lines, the default
+	TimestampsNone = "none"
+	// TimestampsAbsolute prefixes each line with the UTC time it was logged
+	TimestampsAbsolute = "absolute"
+	// TimestampsElapsed prefixes each line with how long after the job
+	// started it was logged
+	TimestampsElapsed = "elapsed"
+)
+
+// logFormat is how the logs endpoints present each line, chosen with query
+// parameters
+type logFormat struct {
+	timestamps string
+	ansi       string
+	// start is what elapsed times are measured from
+	start time.Time
+}
+
+// parseLogFormat reads the format of a request for a job's logs
+func parseLogFormat(r *http.Request) (logFormat, error) {
+	query := r.URL.Query()
+	format := logFormat{timestamps: query.Get("timestamps"), ansi: query.Get("ansi")}
+	switch format.timestamps {
+	case "":
+		format.timestamps = TimestampsNone
+	case TimestampsNone, TimestampsAbsolute, TimestampsElapsed:
+	default:
+		return logFormat{}, fmt.Errorf("timestamps must be %s, %s or %s", TimestampsNone, TimestampsAbsolute, TimestampsElapsed)
+	}
+	switch format.ansi {
+	case "":
+		format.ansi = ANSIKeep
+	case ANSIKeep, ANSIStrip, ANSIHTML:
+	default:
+		return logFormat{}, fmt.Errorf("ansi must be %s, %s or %s", ANSIKeep, ANSIStrip, ANSIHTML)
+	}
+	return format, nil
+}
+
+// raw returns true if lines are returned exactly as they were logged
+func (f logFormat) raw() bool {
+	return f.timestamps == TimestampsNone && f.ansi == ANSIKeep
+}
+
+// forJob measures elapsed times from when the job started, or was created if
+// it hasn't started yet
+func (f logFormat) forJob(job minici.Job) logFormat {
+	f.start = job.StartedAt
+	if f.start.IsZero() {
+		f.start = job.CreatedAt
+	}
+	return f
+}
+
+// line formats a line of the logs. Lines whose time wasn't recorded are left
+// without a timestamp.
+func (f logFormat) line(line minici.TimedLine) string {
+	text := line.Line
+	switch f.ansi {
+	case ANSIStrip:
+		text = stripANSI(text)
+	case ANSIHTML:
+		text = ansiToHTML(text)
+	}
+	if line.Time.IsZero() {
+		return text
+	}
+	switch f.timestamps {
+	case TimestampsAbsolute:
+		return line.Time.UTC().Format("2006-01-02T15:04:05.000Z") + " " + text
+	case TimestampsElapsed:
+		return formatElapsed(line.Time.Sub(f.start)) + " " + text
+	}
+	return text
+}
+
+// formatElapsed formats d as hours, minutes and seconds to the millisecond,
+// such as 00:01:02.345
+func formatElapsed(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	d = d.Round(time.Millisecond)
+	hours := d / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	seconds := (d % time.Minute) / time.Second
+	millis := (d % time.Second) / time.Millisecond
+	return fmt.Sprintf("%s%02d:%02d:%02d.%03d", sign, hours, minutes, seconds, millis)
+}
+
+// timedLogs returns a job's lines from index from on, along with when they
+// were logged if the CI records it
+func (s *Server) timedLogs(jobID minici.JobID, from int) []minici.TimedLine {
+	if reader, ok := s.ci.(minici.TimedLogReader); ok {
+		return reader.JobTimedLogsFrom(jobID, from)
+	}
+	lines := s.ci.JobLogsFrom(jobID, from)
+	timed := make([]minici.TimedLine, len(lines))
+	for i, line := range lines {
+		timed[i] = minici.TimedLine{Line: line}
+	}
+	return timed
+}
@@ -0,0 +1,133 @@
+package restapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader carries the ID of each API request. The ID a client or
+// proxy sends is kept, otherwise one is generated, and it's returned in the
+// response and included in everything logged while handling the request.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the IDs accepted from clients, so they can't
+// flood the logs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the API request ctx belongs to, or an empty
+// string outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// SetLogger sets what the server logs to. It defaults to slog's default
+// logger.
+func (s *Server) SetLogger(logger *slog.Logger) {
+	s.log = logger
+}
+
+// WithLogger sets what the handler logs to. See SetLogger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.SetLogger(logger)
+	}
+}
+
+// logger returns what the server logs to
+func (s *Server) logger() *slog.Logger {
+	if s.log != nil {
+		return s.log
+	}
+	return slog.Default()
+}
+
+// requestLogger returns the server's logger with the ID of the request
+func (s *Server) requestLogger(r *http.Request) *slog.Logger {
+	id := RequestID(r.Context())
+	if id == "" {
+		return s.logger()
+	}
+	return s.logger().With("request_id", id)
+}
+
+// withRequestInfo gives each request an ID, records which client made it and
+// logs it once it's handled, at debug level
+func (s *Server) withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, clientIPKey{}, s.trustedProxies.clientIP(r))
+		r = r.WithContext(ctx)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		s.requestLogger(r).Debug("Handled request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client_ip", ClientIP(r.Context()),
+			"status", recorder.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// validRequestID returns true for IDs that are safe to log, made of letters,
+// digits and a few punctuation characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, such as to
+// flush streams
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
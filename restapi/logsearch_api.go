@@ -0,0 +1,86 @@
+package restapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// LogSearchResponse lists the jobs whose logs matched a search, oldest first
+type LogSearchResponse struct {
+	Query string `json:"query"`
+	// TotalJobs counts every matching job, including any beyond the limit
+	TotalJobs int                    `json:"total_jobs"`
+	Jobs      []LogSearchJobResponse `json:"jobs"`
+}
+
+// LogSearchJobResponse is a job with excerpts of its matching lines
+type LogSearchJobResponse struct {
+	ID         string            `json:"id"`
+	Status     string            `json:"status"`
+	RepoURI    string            `json:"repo_uri"`
+	Commit     string            `json:"commit"`
+	CreatedAt  time.Time         `json:"created_at"`
+	TotalLines int               `json:"total_lines"`
+	Lines      []LogLineResponse `json:"lines"`
+}
+
+// LogLineResponse is a line from a job's logs
+type LogLineResponse struct {
+	// Line is the index of the line in the job's logs, starting from 0
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// EnableLogSearch registers the endpoint for searching job logs
+func (s *Server) EnableLogSearch(searcher minici.LogSearcher) {
+	s.logSearch = searcher
+}
+
+// handleLogSearch processes requests to search job logs, optionally for one
+// repo. The number of jobs returned defaults to 50.
+func (s *Server) handleLogSearch(w http.ResponseWriter, r *http.Request) {
+	if s.logSearch == nil {
+		s.writeError(w, "Log search is not enabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		s.writeError(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 500 {
+			s.writeError(w, "limit must be a number between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	result := s.logSearch.SearchLogs(minici.LogSearch{
+		Query:   query,
+		RepoURI: r.URL.Query().Get("repo"),
+		Project: projectFilter(r),
+		Limit:   limit,
+	})
+	resp := LogSearchResponse{Query: query, TotalJobs: result.TotalJobs, Jobs: []LogSearchJobResponse{}}
+	for _, match := range result.Jobs {
+		job := LogSearchJobResponse{
+			ID:         string(match.Job.ID),
+			Status:     string(match.Job.Status),
+			RepoURI:    match.Job.RepoURI,
+			Commit:     match.Job.Commit,
+			CreatedAt:  match.Job.CreatedAt,
+			TotalLines: match.TotalLines,
+		}
+		for _, line := range match.Lines {
+			job.Lines = append(job.Lines, LogLineResponse{Line: line.Index, Text: line.Text})
+		}
+		resp.Jobs = append(resp.Jobs, job)
+	}
+	s.writeJSON(w, resp, http.StatusOK)
+}
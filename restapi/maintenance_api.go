@@ -0,0 +1,43 @@
+package restapi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// MaintenanceResponse describes the maintenance window holding jobs back
+type MaintenanceResponse struct {
+	Name      string    `json:"name,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+func maintenanceToResponse(active *minici.ActiveMaintenance) *MaintenanceResponse {
+	if active == nil {
+		return nil
+	}
+	return &MaintenanceResponse{
+		Name:      active.Name,
+		StartedAt: active.Start,
+		EndsAt:    active.End,
+	}
+}
+
+// SetMaintenance replaces the maintenance windows during which only urgent
+// jobs start. An empty schedule never pauses jobs.
+func (s *Server) SetMaintenance(schedule minici.MaintenanceSchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	scheduler, ok := s.ci.(minici.MaintenanceScheduler)
+	if !ok {
+		if len(schedule.Windows) > 0 {
+			return errors.New("maintenance windows aren't supported by this server")
+		}
+		return nil
+	}
+	scheduler.SetMaintenance(schedule)
+	return nil
+}
@@ -0,0 +1,28 @@
+package restapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ocuroot/minici"
+)
+
+// handlePinJob pins or unpins a job, responding with the job. Pinned jobs'
+// artifacts are never pruned.
+func (s *Server) handlePinJob(w http.ResponseWriter, r *http.Request, jobID string, pinned bool) {
+	pinner, ok := s.ci.(minici.JobPinner)
+	if !ok {
+		s.writeError(w, "Pinning jobs is not supported", http.StatusNotImplemented)
+		return
+	}
+	err := pinner.PinJob(minici.JobID(jobID), pinned)
+	if errors.Is(err, minici.ErrJobNotFound) {
+		s.writeError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, NewJobResponse(s.ci.JobDetail(minici.JobID(jobID))), http.StatusOK)
+}
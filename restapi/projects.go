@@ -0,0 +1,134 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ocuroot/minici"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectsConfig is the file format for configuring projects, which let teams
+// share a server without seeing or changing each other's jobs
+type ProjectsConfig struct {
+	Projects map[string]ProjectConfig `yaml:"projects"`
+}
+
+// ProjectConfig configures a single project
+type ProjectConfig struct {
+	// The policy restricts the repos, commands, images and environment
+	// variables of the project's jobs
+	minici.JobPolicy `yaml:",inline"`
+	// Tokens only allow access to the project's jobs
+	Tokens []ProjectToken `yaml:"tokens"`
+	// MaxConcurrentJobs limits how many of the project's jobs run at once
+	MaxConcurrentJobs int `yaml:"max_concurrent_jobs"`
+	// MaxQueuedJobs limits how many of the project's jobs can wait to run
+	MaxQueuedJobs int `yaml:"max_queued_jobs"`
+}
+
+// ProjectToken is a bearer token scoped to a project
+type ProjectToken struct {
+	Token    string `yaml:"token"`
+	ReadOnly bool   `yaml:"read_only"`
+}
+
+// LoadProjectsConfig reads a projects config file
+func LoadProjectsConfig(path string) (ProjectsConfig, error) {
+	var config ProjectsConfig
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read projects config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("failed to parse projects config %s: %w", path, err)
+	}
+	for name, project := range config.Projects {
+		for _, token := range project.Tokens {
+			if token.Token == "" {
+				return config, fmt.Errorf("project %q has an empty token", name)
+			}
+		}
+		if project.MaxConcurrentJobs < 0 || project.MaxQueuedJobs < 0 {
+			return config, fmt.Errorf("project %q has a negative job limit", name)
+		}
+	}
+	return config, nil
+}
+
+// SetProjects replaces the projects jobs can be scheduled in, their tokens
+// and their job limits. Project tokens need RequireToken, as the API is
+// otherwise unauthenticated.
+func (s *Server) SetProjects(config ProjectsConfig) error {
+	var tokens []accessToken
+	quotas := map[string]minici.ProjectQuota{}
+	for name, project := range config.Projects {
+		for _, token := range project.Tokens {
+			tokens = append(tokens, accessToken{token: token.Token, readOnly: token.ReadOnly, project: name})
+		}
+		if project.MaxConcurrentJobs > 0 || project.MaxQueuedJobs > 0 {
+			quotas[name] = minici.ProjectQuota{MaxConcurrent: project.MaxConcurrentJobs, MaxQueued: project.MaxQueuedJobs}
+		}
+	}
+	scheduler, canLimit := s.ci.(minici.ProjectScheduler)
+	if len(quotas) > 0 && !canLimit {
+		return errors.New("project job limits aren't supported by this server")
+	}
+
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if len(tokens) > 0 && len(s.tokens) == 0 {
+		return errors.New("project tokens require -token to be set")
+	}
+	s.projects = config.Projects
+	s.projectTokens = tokens
+	if canLimit {
+		scheduler.SetProjectQuotas(quotas)
+	}
+	return nil
+}
+
+// project returns a project's config, and false if there is no such project
+func (s *Server) project(name string) (ProjectConfig, bool) {
+	s.tokenMutex.RLock()
+	defer s.tokenMutex.RUnlock()
+	project, ok := s.projects[name]
+	return project, ok
+}
+
+type projectKey struct{}
+
+// tokenProject returns the project the request's token is scoped to, or ""
+// if it can access every project
+func tokenProject(r *http.Request) string {
+	project, _ := r.Context().Value(projectKey{}).(string)
+	return project
+}
+
+func withTokenProject(r *http.Request, project string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), projectKey{}, project))
+}
+
+// projectFilter returns the project list endpoints are limited to: the
+// project of the request's token, or else the project query parameter
+func projectFilter(r *http.Request) string {
+	if project := tokenProject(r); project != "" {
+		return project
+	}
+	return r.URL.Query().Get("project")
+}
+
+// projectTokenAllowed returns false for the endpoints a project token can't
+// use, as they aren't limited to one project
+func projectTokenAllowed(path string) bool {
+	for _, prefix := range []string{adminPrefix, "/api/webhooks", "/api/agents/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
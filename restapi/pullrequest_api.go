@@ -0,0 +1,320 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/webhookverify"
+	"gopkg.in/yaml.v3"
+)
+
+// pullRequestHookPath receives GitHub's pull_request and GitLab's merge
+// request webhooks
+const pullRequestHookPath = "/api/hooks/pull-requests"
+
+// PullRequest describes the pull request a job builds
+type PullRequest struct {
+	Number       int    `json:"number"`
+	SourceBranch string `json:"source_branch,omitempty"`
+	TargetBranch string `json:"target_branch,omitempty"`
+	Author       string `json:"author,omitempty"`
+}
+
+// Spec returns the pull request for a job spec, nil if p is
+func (p *PullRequest) Spec() *minici.PullRequest {
+	if p == nil {
+		return nil
+	}
+	return &minici.PullRequest{
+		Number:       p.Number,
+		SourceBranch: p.SourceBranch,
+		TargetBranch: p.TargetBranch,
+		Author:       p.Author,
+	}
+}
+
+func pullRequestFromSpec(pr *minici.PullRequest) *PullRequest {
+	if pr == nil {
+		return nil
+	}
+	return &PullRequest{
+		Number:       pr.Number,
+		SourceBranch: pr.SourceBranch,
+		TargetBranch: pr.TargetBranch,
+		Author:       pr.Author,
+	}
+}
+
+// PullRequestConfig is the file format for configuring the jobs scheduled
+// when GitHub or GitLab sends a webhook for a pull request
+type PullRequestConfig struct {
+	// Secret is the secret GitHub signs webhooks with, or the secret token
+	// GitLab sends
+	Secret string            `yaml:"secret"`
+	Repos  []PullRequestRepo `yaml:"repos"`
+}
+
+// PullRequestRepo configures the job scheduled for each of a repo's pull
+// requests
+type PullRequestRepo struct {
+	// Repo is the URI jobs clone the repo from. Webhooks are matched to it by
+	// any of the repo's URLs, with or without .git.
+	Repo    string `yaml:"repo"`
+	Command string `yaml:"command"`
+	// Project is the project the jobs are scheduled in, if any
+	Project string `yaml:"project"`
+	// RunsOn lists labels an agent must have to run the jobs
+	RunsOn []string `yaml:"runs_on"`
+}
+
+// LoadPullRequestConfig reads a pull request config file
+func LoadPullRequestConfig(path string) (PullRequestConfig, error) {
+	var config PullRequestConfig
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read pull request config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("failed to parse pull request config %s: %w", path, err)
+	}
+	if config.Secret == "" {
+		return config, fmt.Errorf("pull request config %s has no secret", path)
+	}
+	for i, repo := range config.Repos {
+		if repo.Repo == "" || repo.Command == "" {
+			return config, fmt.Errorf("pull request config %s: repos[%d] needs a repo and a command", path, i)
+		}
+	}
+	return config, nil
+}
+
+// SetPullRequests replaces the config for scheduling jobs for pull request
+// webhooks, which are received on /api/hooks/pull-requests. An empty config
+// ignores them.
+func (s *Server) SetPullRequests(config PullRequestConfig) {
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+	s.pullRequests = config
+}
+
+// WithPullRequests schedules jobs for pull request webhooks. See
+// SetPullRequests.
+func WithPullRequests(config PullRequestConfig) Option {
+	return func(s *Server) {
+		s.SetPullRequests(config)
+	}
+}
+
+func (s *Server) pullRequestConfig() PullRequestConfig {
+	s.policyMutex.RLock()
+	defer s.policyMutex.RUnlock()
+	return s.pullRequests
+}
+
+// pullRequestEvent is what a webhook says about a pull request
+type pullRequestEvent struct {
+	provider string
+	// build is false for events that don't change the code, such as closing
+	build       bool
+	title       string
+	repoURLs    []string
+	pullRequest minici.PullRequest
+}
+
+// gitHubPullRequestEvent is the part of GitHub's pull_request webhook used
+type gitHubPullRequestEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		User   struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Head struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		HTMLURL  string `json:"html_url"`
+		SSHURL   string `json:"ssh_url"`
+	} `json:"repository"`
+}
+
+// gitLabMergeRequestEvent is the part of GitLab's merge request webhook used
+type gitLabMergeRequestEvent struct {
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		HTTPURL string `json:"git_http_url"`
+		SSHURL  string `json:"git_ssh_url"`
+		WebURL  string `json:"web_url"`
+	} `json:"project"`
+	Attributes struct {
+		IID          int    `json:"iid"`
+		Title        string `json:"title"`
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		// OldRev is only set for updates that pushed new commits
+		OldRev string `json:"oldrev"`
+	} `json:"object_attributes"`
+}
+
+// errIgnoredEvent is returned for webhooks about something other than pull
+// requests, such as GitHub's ping when a webhook is added
+var errIgnoredEvent = errors.New("not a pull request event")
+
+// parsePullRequestEvent reads a GitHub or GitLab webhook's body, telling
+// them apart by their event headers
+func parsePullRequestEvent(header http.Header, body []byte) (pullRequestEvent, error) {
+	switch {
+	case header.Get("X-GitHub-Event") != "":
+		if header.Get("X-GitHub-Event") != "pull_request" {
+			return pullRequestEvent{}, errIgnoredEvent
+		}
+		var payload gitHubPullRequestEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return pullRequestEvent{}, fmt.Errorf("invalid pull_request webhook: %w", err)
+		}
+		pr := payload.PullRequest
+		switch payload.Action {
+		case "opened", "reopened", "synchronize", "ready_for_review":
+		default:
+			return pullRequestEvent{provider: "github"}, nil
+		}
+		return pullRequestEvent{
+			provider: "github",
+			build:    true,
+			title:    fmt.Sprintf("Pull request #%d: %s", pr.Number, pr.Title),
+			repoURLs: []string{payload.Repository.CloneURL, payload.Repository.HTMLURL, payload.Repository.SSHURL},
+			pullRequest: minici.PullRequest{
+				Number:       pr.Number,
+				SourceBranch: pr.Head.Ref,
+				TargetBranch: pr.Base.Ref,
+				Author:       pr.User.Login,
+			},
+		}, nil
+
+	case header.Get("X-Gitlab-Event") != "":
+		if header.Get("X-Gitlab-Event") != "Merge Request Hook" {
+			return pullRequestEvent{}, errIgnoredEvent
+		}
+		var payload gitLabMergeRequestEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return pullRequestEvent{}, fmt.Errorf("invalid merge request webhook: %w", err)
+		}
+		mr := payload.Attributes
+		// Updates also cover changes to the description, labels and so on
+		if mr.Action != "open" && mr.Action != "reopen" && (mr.Action != "update" || mr.OldRev == "") {
+			return pullRequestEvent{provider: "gitlab"}, nil
+		}
+		return pullRequestEvent{
+			provider: "gitlab",
+			build:    true,
+			title:    fmt.Sprintf("Merge request !%d: %s", mr.IID, mr.Title),
+			repoURLs: []string{payload.Project.HTTPURL, payload.Project.SSHURL, payload.Project.WebURL},
+			pullRequest: minici.PullRequest{
+				Number:       mr.IID,
+				SourceBranch: mr.SourceBranch,
+				TargetBranch: mr.TargetBranch,
+				// GitLab's webhooks name whoever opened or pushed to it
+				Author: payload.User.Username,
+			},
+		}, nil
+	}
+	return pullRequestEvent{}, errIgnoredEvent
+}
+
+// normalizeRepoURL strips the differences between URLs for the same repo
+func normalizeRepoURL(url string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimRight(url, "/"), ".git"))
+}
+
+// repo returns the config for the repo a webhook came from, and false if it
+// isn't configured
+func (c PullRequestConfig) repo(urls []string) (PullRequestRepo, bool) {
+	for _, repo := range c.Repos {
+		for _, url := range urls {
+			if url != "" && normalizeRepoURL(url) == normalizeRepoURL(repo.Repo) {
+				return repo, true
+			}
+		}
+	}
+	return PullRequestRepo{}, false
+}
+
+// handlePullRequestHook schedules a job for a pull request when it's opened
+// or has new commits pushed to it, checking out the provider's merge ref so
+// it tests the result of merging it. Other webhooks are acknowledged with 204
+// No Content.
+func (s *Server) handlePullRequestHook(w http.ResponseWriter, r *http.Request) {
+	config := s.pullRequestConfig()
+	if config.Secret == "" {
+		s.writeError(w, "Pull request webhooks aren't configured", http.StatusNotFound)
+		return
+	}
+	verifier := webhookverify.Any(webhookverify.GitHub(config.Secret), webhookverify.GitLab(config.Secret))
+	body, err := webhookverify.Request(verifier, r, 0)
+	if errors.Is(err, webhookverify.ErrBodyTooLarge) {
+		s.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	event, err := parsePullRequestEvent(r.Header, body)
+	if errors.Is(err, errIgnoredEvent) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !event.build {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	repo, ok := config.repo(event.repoURLs)
+	if !ok {
+		s.writeError(w, "No pull request jobs are configured for this repo", http.StatusNotFound)
+		return
+	}
+	ref, err := minici.PullRequestMergeRef(event.provider, event.pullRequest.Number)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pullRequest := event.pullRequest
+	job, ok := s.schedule(w, r, minici.JobSpec{
+		RepoURI:     repo.Repo,
+		Commit:      ref,
+		Command:     repo.Command,
+		Name:        event.title,
+		Project:     repo.Project,
+		Labels:      map[string]string{"pull_request": strconv.Itoa(pullRequest.Number)},
+		RunsOn:      repo.RunsOn,
+		PullRequest: &pullRequest,
+	}, false, false)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, JobResponse{
+		ID:     string(job.ID),
+		Number: job.Number,
+	}, http.StatusCreated)
+}
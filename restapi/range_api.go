@@ -0,0 +1,120 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// maxRangeCommits is the most commits a range request can build, so a
+// mistyped range doesn't flood the queue
+const maxRangeCommits = 100
+
+// listCommitsTimeout limits how long listing a range's commits can take, as
+// it fetches the repo's history
+const listCommitsTimeout = 2 * time.Minute
+
+// RangeRequest is the request body for building each commit in a range. The
+// job's commit is left empty, as the range gives the commits.
+type RangeRequest struct {
+	JobRequest
+	// Range is the commits to build, such as v1.0.0..main for the commits
+	// after v1.0.0 up to and including main
+	Range string `json:"range"`
+}
+
+// RangeResponse lists the jobs scheduled for a range, oldest commit first
+type RangeResponse struct {
+	// Group is shared by the jobs
+	Group string        `json:"group"`
+	Jobs  []JobResponse `json:"jobs"`
+}
+
+// handleScheduleRange processes requests to schedule a job for each commit in
+// a range, such as to fill in the history of a repo that's new to the server.
+// The jobs share a group, which is generated unless the request names one.
+func (s *Server) handleScheduleRange(w http.ResponseWriter, r *http.Request) {
+	var req RangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RepoURI == "" || req.Range == "" || req.Command == "" {
+		s.writeError(w, "Missing required fields: repo_uri, range, and command are required", http.StatusBadRequest)
+		return
+	}
+	if req.Commit != "" {
+		s.writeError(w, "commit can't be set, as the range gives the commits", http.StatusBadRequest)
+		return
+	}
+	from, to, err := minici.ParseCommitRange(req.Range)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	project := req.Project
+	if scoped := tokenProject(r); scoped != "" {
+		if project != "" && project != scoped {
+			s.writeError(w, "Token is limited to project "+scoped, http.StatusForbidden)
+			return
+		}
+		project = scoped
+	}
+
+	// Listing the commits clones the repo, so the range's repo and command
+	// are checked first, with its last commit standing in for each of them
+	check := req.Spec()
+	check.Commit = to
+	check.Project = project
+	if !s.admit(w, r, &check) {
+		return
+	}
+
+	lister, ok := s.ci.(minici.CommitLister)
+	if !ok {
+		s.writeError(w, "This server can't list a repo's commits", http.StatusNotImplemented)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), listCommitsTimeout)
+	defer cancel()
+	commits, err := lister.ListCommits(ctx, req.RepoURI, from, to)
+	switch {
+	case errors.Is(err, minici.ErrListCommitsUnsupported):
+		s.writeError(w, "This server can't list a repo's commits", http.StatusNotImplemented)
+		return
+	case err != nil:
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	case len(commits) == 0:
+		s.writeError(w, fmt.Sprintf("No commits in %s", req.Range), http.StatusBadRequest)
+		return
+	case len(commits) > maxRangeCommits:
+		s.writeError(w, fmt.Sprintf("%s has %d commits, more than the %d that can be built at once", req.Range, len(commits), maxRangeCommits), http.StatusBadRequest)
+		return
+	}
+
+	resp := RangeResponse{Group: req.Group, Jobs: []JobResponse{}}
+	if resp.Group == "" {
+		resp.Group = minici.NewGroupID()
+	}
+	for _, commit := range commits {
+		spec := req.Spec()
+		spec.Commit = commit
+		spec.Project = project
+		spec.Group = resp.Group
+		// Jobs already scheduled are kept if a later one is rejected, such
+		// as when the queue fills up
+		job, ok := s.schedule(w, r, spec, false, false)
+		if !ok {
+			return
+		}
+		resp.Jobs = append(resp.Jobs, NewJobResponse(job))
+	}
+	s.writeJSON(w, resp, http.StatusCreated)
+}
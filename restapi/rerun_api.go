@@ -0,0 +1,60 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ocuroot/minici"
+)
+
+// RerunRequest is the optional body of a request to run a job again. Empty
+// fields keep the original job's settings.
+type RerunRequest struct {
+	Commit  string `json:"commit,omitempty"`
+	Command string `json:"command,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Image   string `json:"image,omitempty"`
+	// Env, Inputs and Labels are combined with the original job's, replacing
+	// the values it set
+	Env    map[string]string `json:"env,omitempty"`
+	Inputs map[string]string `json:"inputs,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// handleRerun processes requests to schedule a copy of a job, with any
+// changes given in the body. Like scheduling a job, it supports dry runs.
+func (s *Server) handleRerun(w http.ResponseWriter, r *http.Request, jobID string) {
+	dryRun, err := queryBool(r, "dry_run")
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req RerunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	original := s.ci.JobDetail(minici.JobID(jobID))
+	if original.RepoURI == "" {
+		s.writeError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	spec := minici.RerunSpec(original, minici.JobOverrides{
+		Commit:  req.Commit,
+		Command: req.Command,
+		Name:    req.Name,
+		Image:   req.Image,
+		Env:     req.Env,
+		Inputs:  req.Inputs,
+		Labels:  req.Labels,
+	})
+
+	job, ok := s.schedule(w, r, spec, dryRun, false)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, NewJobResponse(job), http.StatusCreated)
+}
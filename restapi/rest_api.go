@@ -0,0 +1,1587 @@
+// Package restapi serves minici's REST API and dashboard, and is a client for
+// the API, so programs can embed the server or talk to one.
+package restapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/notify"
+	"github.com/oklog/ulid/v2"
+)
+
+// Server wraps a CI implementation and provides HTTP endpoints to interact with it
+type Server struct {
+	ci         minici.CI
+	dispatcher *notify.Dispatcher
+	agents     minici.AgentPool
+	artifacts  minici.ArtifactStore
+	logSearch  minici.LogSearcher
+	repoStats  minici.StatsCollector
+	router     *http.ServeMux
+	server     *http.Server
+	address    string
+	// tlsCert and tlsKey are the files to serve HTTPS with, if set
+	tlsCert string
+	tlsKey  string
+	// tokenMutex guards tokens, which can be replaced when config is reloaded
+	tokenMutex sync.RWMutex
+	tokens     []accessToken
+	// projects are the projects jobs can be scheduled in, and projectTokens
+	// their tokens, guarded by tokenMutex
+	projects      map[string]ProjectConfig
+	projectTokens []accessToken
+	// retentionMutex guards retention for the same reason
+	retentionMutex sync.RWMutex
+	retention      minici.ArtifactRetention
+	// policyMutex guards policy for the same reason
+	policyMutex sync.RWMutex
+	policy      minici.JobPolicy
+	// agentTokens only allow access to the agent endpoints, nil if disabled
+	agentTokens *agentTokenStore
+	// requireAuth is set by RequireToken
+	requireAuth bool
+	// middleware wraps every request, the first outermost
+	middleware []func(http.Handler) http.Handler
+	// beforeSchedule and onScheduled are called around scheduling each job
+	beforeSchedule []BeforeScheduleHook
+	onScheduled    []ScheduledHook
+	// log is set by SetLogger, slog's default logger is used if nil
+	log *slog.Logger
+	// admin serves the admin API, which the rest of the API stops serving
+	// once adminSeparate is set by AdminHandler
+	admin         http.Handler
+	adminSeparate bool
+	// trustedProxies are the proxies whose forwarding headers are honoured
+	trustedProxies TrustedProxies
+	// pullRequests configures the jobs scheduled for pull request webhooks,
+	// guarded by policyMutex as it can be replaced when config is reloaded
+	pullRequests PullRequestConfig
+}
+
+// JobRequest represents the request body for scheduling a new CI job
+type JobRequest struct {
+	RepoURI string `json:"repo_uri"`
+	Commit  string `json:"commit"`
+	Command string `json:"command"`
+	// Branch is the branch the commit is on, for jobs scheduled by commit hash
+	Branch string `json:"branch,omitempty"`
+	// Name optionally describes the job for people
+	Name    string            `json:"name,omitempty"`
+	Project string            `json:"project,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	// Environment is the environment the job deploys to, such as prod
+	Environment string `json:"environment,omitempty"`
+	// Group is shared by related jobs, so they can be followed together
+	Group string `json:"group,omitempty"`
+	// Image optionally runs the command in a container
+	Image string            `json:"image,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+	// Inputs are values for the inputs the repo's config declares
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// RunsOn lists labels an agent must have to run the job
+	RunsOn []string `json:"runs_on,omitempty"`
+	// Needs lists jobs that must succeed first
+	Needs []JobNeed `json:"needs,omitempty"`
+	// StallTimeoutSeconds stops the job if its commands go this long without
+	// output, overriding the server's default
+	StallTimeoutSeconds float64 `json:"stall_timeout_seconds,omitempty"`
+	// PullRequest describes the pull request the job builds, if any
+	PullRequest *PullRequest `json:"pull_request,omitempty"`
+	// Urgent jobs start even during maintenance windows
+	Urgent bool `json:"urgent,omitempty"`
+}
+
+// JobNeed is a job that must succeed before another job starts
+type JobNeed struct {
+	Job string `json:"job"`
+	// Artifacts are patterns for the needed job's artifacts to restore into the workspace
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// Spec is the job the request asks for
+func (r JobRequest) Spec() minici.JobSpec {
+	return minici.JobSpec{
+		RepoURI:     r.RepoURI,
+		Commit:      r.Commit,
+		Branch:      r.Branch,
+		Command:     r.Command,
+		Name:        r.Name,
+		Project:     r.Project,
+		Environment: r.Environment,
+		Group:       r.Group,
+		Labels:      r.Labels,
+		Image:       r.Image,
+		Env:         r.Env,
+		Inputs:      r.Inputs,
+		RunsOn:      r.RunsOn,
+		Needs:       needsToSpec(r.Needs),
+
+		StallTimeout: time.Duration(r.StallTimeoutSeconds * float64(time.Second)),
+		PullRequest:  r.PullRequest.Spec(),
+		Urgent:       r.Urgent,
+	}
+}
+
+func needsToSpec(needs []JobNeed) []minici.JobNeed {
+	var spec []minici.JobNeed
+	for _, need := range needs {
+		spec = append(spec, minici.JobNeed{Job: minici.JobID(need.Job), Artifacts: need.Artifacts})
+	}
+	return spec
+}
+
+func needsFromSpec(needs []minici.JobNeed) []JobNeed {
+	var resp []JobNeed
+	for _, need := range needs {
+		resp = append(resp, JobNeed{Job: string(need.Job), Artifacts: need.Artifacts})
+	}
+	return resp
+}
+
+// JobResponse represents the response for job-related operations
+type JobResponse struct {
+	ID     string   `json:"id"`
+	Status string   `json:"status,omitempty"`
+	Logs   []string `json:"logs,omitempty"`
+	// Number counts the jobs scheduled for the repo, starting from 1
+	Number int `json:"number,omitempty"`
+
+	RepoURI string `json:"repo_uri"`
+	Commit  string `json:"commit"`
+	// CommitHash is the hash of the commit the job checked out, once it's done
+	CommitHash string `json:"commit_hash,omitempty"`
+	// Branch is the branch the commit is on, if the job was scheduled with one
+	Branch  string `json:"branch,omitempty"`
+	Command string `json:"command"`
+	Name    string `json:"name,omitempty"`
+	Project string `json:"project,omitempty"`
+	// Environment is the environment the job deploys to, if any
+	Environment string            `json:"environment,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Inputs      map[string]string `json:"inputs,omitempty"`
+	RunsOn      []string          `json:"runs_on,omitempty"`
+	Needs       []JobNeed         `json:"needs,omitempty"`
+	Agent       string            `json:"agent,omitempty"`
+	// Outputs are values the job recorded, such as the digests of images it pushed
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// Tests summarizes the job's test reports
+	Tests *TestSummaryResponse `json:"tests,omitempty"`
+	// Coverage is the percentage from the job's coverage report
+	Coverage *float64 `json:"coverage,omitempty"`
+	// Hooks are the outcomes of the repo's hooks, which don't change Status
+	Hooks []HookResponse `json:"hooks,omitempty"`
+	// FailedPhase is the phase the job failed in, such as clone or command
+	FailedPhase string `json:"failed_phase,omitempty"`
+	// TimedOut is set if FailedPhase ran out of time
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Stalled is set if the job was stopped because its commands stopped
+	// producing output
+	Stalled bool `json:"stalled,omitempty"`
+	// StallTimeoutSeconds is how long the job's commands can go without
+	// output, if the job set its own
+	StallTimeoutSeconds float64 `json:"stall_timeout_seconds,omitempty"`
+	// PullRequest is the pull request the job builds, if any
+	PullRequest *PullRequest `json:"pull_request,omitempty"`
+	// Progress is what the job's commands last reported through a heartbeat
+	Progress *ProgressResponse `json:"progress,omitempty"`
+	// Annotations mark the log lines that look like problems
+	Annotations []AnnotationResponse `json:"annotations,omitempty"`
+	// Sections group log lines the job marked as collapsible
+	Sections []LogSectionResponse `json:"sections,omitempty"`
+	// RequeuedFrom is the interrupted job this one replaces
+	RequeuedFrom string `json:"requeued_from,omitempty"`
+	// Attempt counts this job and the interrupted jobs it replaces
+	Attempt int `json:"attempt,omitempty"`
+	// Pinned jobs keep their artifacts however old they are
+	Pinned bool `json:"pinned,omitempty"`
+	// Urgent jobs start even during maintenance windows
+	Urgent bool `json:"urgent,omitempty"`
+}
+
+// ListJobsResponse represents the response for listing jobs
+type ListJobsResponse struct {
+	Jobs []string `json:"jobs"`
+	// NextCursor fetches the next page of jobs when passed as cursor, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// WaitResponseType is the media type a request to wait for every job accepts
+// to get a WaitResponse. Otherwise the response is a JSON string describing
+// the outcome, as older clients expect.
+const WaitResponseType = "application/vnd.minici.wait+json"
+
+// WaitResponse reports the outcome of waiting for every job on the server. The
+// wait endpoint sends its status before jobs finish, so Success carries the
+// outcome instead.
+type WaitResponse struct {
+	// Success is true if no job failed, including when none were scheduled
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// WebhookRequest represents the request body for registering a webhook
+type WebhookRequest struct {
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret,omitempty"`
+	Repos    []string `json:"repos,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// WebhookResponse describes a registered webhook. The secret is never returned.
+type WebhookResponse struct {
+	ID       string   `json:"id"`
+	URL      string   `json:"url,omitempty"`
+	Repos    []string `json:"repos,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// ListWebhooksResponse represents the response for listing webhooks
+type ListWebhooksResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+// AnnotationResponse marks a line in a job's logs that looks like a problem
+type AnnotationResponse struct {
+	// Line is the index of the line in the job's logs, starting from 0
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// LogSectionResponse is a collapsible group of lines in a job's logs
+type LogSectionResponse struct {
+	Name string `json:"name"`
+	// Start is the index of the line that opened the section, starting from 0
+	Start int `json:"start"`
+	// End is the index of the line that closed the section, omitted while it's open
+	End   *int `json:"end,omitempty"`
+	Depth int  `json:"depth"`
+}
+
+func sectionsToResponse(sections []minici.LogSection) []LogSectionResponse {
+	var resp []LogSectionResponse
+	for _, section := range sections {
+		sectionResponse := LogSectionResponse{Name: section.Name, Start: section.Start, Depth: section.Depth}
+		if section.End >= 0 {
+			end := section.End
+			sectionResponse.End = &end
+		}
+		resp = append(resp, sectionResponse)
+	}
+	return resp
+}
+
+func annotationsToResponse(annotations []minici.Annotation) []AnnotationResponse {
+	var resp []AnnotationResponse
+	for _, annotation := range annotations {
+		resp = append(resp, AnnotationResponse{
+			Line:     annotation.Line,
+			Severity: string(annotation.Severity),
+			Message:  annotation.Message,
+		})
+	}
+	return resp
+}
+
+// HookResponse is the outcome of one of a repo's hooks, which run after the
+// job's command whatever its outcome
+type HookResponse struct {
+	Name string `json:"name,omitempty"`
+	// Phase is on_failure or always
+	Phase string `json:"phase"`
+	Run   string `json:"run"`
+	// Outcome is success, failure or skipped
+	Outcome         string  `json:"outcome"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+func hooksToResponse(hooks []minici.HookResult) []HookResponse {
+	var resp []HookResponse
+	for _, hook := range hooks {
+		resp = append(resp, HookResponse{
+			Name:            hook.Name,
+			Phase:           hook.Phase,
+			Run:             hook.Run,
+			Outcome:         hook.Outcome,
+			DurationSeconds: hook.Duration.Seconds(),
+		})
+	}
+	return resp
+}
+
+func hooksFromRequest(hooks []HookResponse) []minici.HookResult {
+	var results []minici.HookResult
+	for _, hook := range hooks {
+		results = append(results, minici.HookResult{
+			Name:     hook.Name,
+			Phase:    hook.Phase,
+			Run:      hook.Run,
+			Outcome:  hook.Outcome,
+			Duration: time.Duration(hook.DurationSeconds * float64(time.Second)),
+		})
+	}
+	return results
+}
+
+// TrendsResponse represents the response for job trends
+type TrendsResponse struct {
+	Since    time.Time           `json:"since"`
+	Interval string              `json:"interval"`
+	Repos    []RepoTrendResponse `json:"repos"`
+}
+
+// RepoTrendResponse holds the trend buckets for one repo, oldest first
+type RepoTrendResponse struct {
+	RepoURI string                `json:"repo_uri"`
+	Buckets []TrendBucketResponse `json:"buckets"`
+}
+
+// TrendBucketResponse summarizes the jobs that finished in one interval
+type TrendBucketResponse struct {
+	Start       time.Time `json:"start"`
+	Total       int       `json:"total"`
+	Succeeded   int       `json:"succeeded"`
+	Failed      int       `json:"failed"`
+	SuccessRate float64   `json:"success_rate"`
+
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+	MaxDurationSeconds     float64 `json:"max_duration_seconds"`
+	// AverageCoverage is omitted if no jobs in the bucket reported coverage
+	AverageCoverage *float64 `json:"average_coverage,omitempty"`
+}
+
+// QueueResponse represents the response for queue and worker utilization
+type QueueResponse struct {
+	// Capacity is the number of jobs that can run at once, 0 if unlimited
+	Capacity int                 `json:"capacity"`
+	Pending  int                 `json:"pending"`
+	Running  int                 `json:"running"`
+	Workers  []WorkerResponse    `json:"workers"`
+	Queue    []QueuedJobResponse `json:"queue"`
+
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+	EstimatedWaitSeconds   float64 `json:"estimated_wait_seconds"`
+	// Maintenance is the maintenance window holding jobs back, if one is in
+	// progress
+	Maintenance *MaintenanceResponse `json:"maintenance,omitempty"`
+}
+
+// WorkerResponse describes the jobs running on a worker
+type WorkerResponse struct {
+	Name     string   `json:"name"`
+	Labels   []string `json:"labels"`
+	Capacity int      `json:"capacity"`
+	Running  []string `json:"running"`
+	// LastSeen is when a remote agent last contacted the server
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// QueuedJobResponse describes a job waiting to start
+type QueuedJobResponse struct {
+	ID      string `json:"id"`
+	RepoURI string `json:"repo_uri"`
+	Commit  string `json:"commit"`
+	Command string `json:"command"`
+
+	QueuedSeconds        float64 `json:"queued_seconds"`
+	EstimatedWaitSeconds float64 `json:"estimated_wait_seconds"`
+	// Urgent jobs start even during maintenance windows
+	Urgent bool `json:"urgent,omitempty"`
+}
+
+// SessionResponse describes what the caller is allowed to do
+type SessionResponse struct {
+	ReadOnly bool `json:"read_only"`
+	// Project is the project the caller is limited to, if any
+	Project string `json:"project,omitempty"`
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewServer creates a new REST API server for CI operations
+func NewServer(ci minici.CI, address string) *Server {
+	router := http.NewServeMux()
+
+	server := &Server{
+		ci:      ci,
+		router:  router,
+		address: address,
+		server: &http.Server{
+			Addr:         address,
+			Handler:      router,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 5 * time.Minute,
+			IdleTimeout:  60 * time.Second,
+		},
+	}
+
+	// Register routes
+	server.registerRoutes()
+	server.buildHandler()
+
+	return server
+}
+
+// registerRoutes sets up the HTTP endpoints
+func (s *Server) registerRoutes() {
+	// Dashboard, everything outside /api
+	s.router.Handle("/", uiHandler())
+
+	// API endpoints
+	s.router.HandleFunc("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleListJobs(w, r)
+		case http.MethodPost:
+			s.handleScheduleJob(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/jobs/range", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.handleScheduleRange(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/wait", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleWait(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/session", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.writeJSON(w, SessionResponse{ReadOnly: isReadOnly(r), Project: tokenProject(r)}, http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/stats/trends", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleTrends(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/stats/queue", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleQueue(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/logs/search", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleLogSearch(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/environments", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleEnvironments(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/environments/", func(w http.ResponseWriter, r *http.Request) {
+		environment, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/environments/"), "/")
+		if !ok || environment == "" || action != "rollback" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleRollback(w, r, environment)
+	})
+
+	s.router.HandleFunc("/api/groups/", s.handleGroups)
+
+	s.router.HandleFunc("/api/repos/", s.handleRepos)
+
+	s.router.HandleFunc("/api/commits/", s.handleCommits)
+
+	s.router.HandleFunc("/api/validate", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.handleValidate(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Pull request webhooks are authenticated by their signature
+	s.router.HandleFunc(pullRequestHookPath, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePullRequestHook(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Job detail handler - handles /api/jobs/<id>, /api/jobs/<id>/logs,
+	// /api/jobs/<id>/cancel, /api/jobs/<id>/rerun, /api/jobs/<id>/pin,
+	// /api/jobs/<id>/heartbeat and /api/jobs/<id>/artifacts
+	s.router.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		// Extract path components
+		path := r.URL.Path
+		pathSegments := strings.Split(strings.TrimRight(path, "/"), "/")
+
+		// Path should be /api/jobs/<id> optionally followed by an action
+		if len(pathSegments) < 4 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		jobID := pathSegments[3]
+
+		// Heartbeats are authenticated with the job's own token
+		if len(pathSegments) == 5 && pathSegments[4] == "heartbeat" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			s.handleHeartbeat(w, r, jobID)
+			return
+		}
+
+		// Jobs in other projects are hidden from project tokens
+		if project := tokenProject(r); project != "" && s.ci.JobDetail(minici.JobID(jobID)).Project != project {
+			s.writeError(w, "Job not found", http.StatusNotFound)
+			return
+		}
+
+		// Cancelling stops a pending or running job
+		if len(pathSegments) == 5 && pathSegments[4] == "cancel" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			s.handleCancelJob(w, r, jobID)
+			return
+		}
+
+		// Re-running schedules a new job, leaving this one as it is
+		if len(pathSegments) == 5 && pathSegments[4] == "rerun" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			s.handleRerun(w, r, jobID)
+			return
+		}
+
+		// Pinning keeps a job's artifacts, and unpinning hands them back to
+		// retention
+		if len(pathSegments) == 5 && pathSegments[4] == "pin" {
+			switch r.Method {
+			case http.MethodPut:
+				s.handlePinJob(w, r, jobID, true)
+			case http.MethodDelete:
+				s.handlePinJob(w, r, jobID, false)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		if len(pathSegments) >= 5 && pathSegments[4] == "artifacts" {
+			s.handleArtifacts(w, r, jobID)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Check if this is a logs request
+		if len(pathSegments) == 6 && pathSegments[4] == "logs" && pathSegments[5] == "stream" {
+			s.handleJobLogStream(w, r, jobID)
+			return
+		} else if len(pathSegments) == 5 && pathSegments[4] == "logs" {
+			s.handleJobLogs(w, r, jobID)
+			return
+		} else if len(pathSegments) == 4 {
+			s.handleJobStatus(w, r, jobID)
+			return
+		}
+
+		// If we get here, it's not a valid path
+		w.WriteHeader(http.StatusNotFound)
+	})
+}
+
+// EnableWebhooks registers endpoints for managing outbound webhooks at runtime.
+// Webhooks are added to the dispatcher as targets.
+func (s *Server) EnableWebhooks(dispatcher *notify.Dispatcher) {
+	s.dispatcher = dispatcher
+
+	s.router.HandleFunc("/api/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleListWebhooks(w, r)
+		case http.MethodPost:
+			s.handleRegisterWebhook(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	s.router.HandleFunc("/api/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleDeleteWebhook(w, r, strings.TrimPrefix(r.URL.Path, "/api/webhooks/"))
+	})
+}
+
+// EnableReload registers an admin endpoint that calls reload, such as to
+// pick up changes to the config file without sending SIGHUP
+func (s *Server) EnableReload(reload func() error) {
+	s.router.HandleFunc("/api/admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reload(); err != nil {
+			s.writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// RequireToken rejects API requests that don't present the token, or a
+// read-only token, as a bearer token.
+// The dashboard's static files are always served, it asks for the token itself.
+func (s *Server) RequireToken(token string) {
+	s.tokenMutex.Lock()
+	s.tokens = append(s.tokens, accessToken{token: token})
+	s.tokenMutex.Unlock()
+	s.requireAuth = true
+	s.buildHandler()
+}
+
+// AddReadOnlyToken accepts a token that may only make requests that don't
+// modify anything, such as for status screens. It has no effect unless
+// RequireToken is also used, as the API is otherwise unauthenticated.
+func (s *Server) AddReadOnlyToken(token string) {
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	s.tokens = append(s.tokens, accessToken{token: token, readOnly: true})
+}
+
+// ReplaceTokens swaps the tokens given to RequireToken and AddReadOnlyToken
+// while the server is running, with an empty readToken removing it. Turning
+// authentication on or off needs a restart.
+func (s *Server) ReplaceTokens(token, readToken string) error {
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if (token == "") != (len(s.tokens) == 0) {
+		return errors.New("turning authentication on or off requires a restart")
+	}
+	if token == "" {
+		if readToken != "" {
+			return errors.New("a read-only token requires a token to be set")
+		}
+		return nil
+	}
+	tokens := []accessToken{{token: token}}
+	if readToken != "" {
+		tokens = append(tokens, accessToken{token: readToken, readOnly: true})
+	}
+	s.tokens = tokens
+	return nil
+}
+
+// accessToken is a bearer token accepted by the API
+type accessToken struct {
+	token    string
+	readOnly bool
+	// project limits the token to one project's jobs, if set
+	project string
+}
+
+type readOnlyKey struct{}
+
+// isReadOnly returns true if the request was authenticated with a read-only token
+func isReadOnly(r *http.Request) bool {
+	readOnly, _ := r.Context().Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// bearerToken returns the token from a request's Authorization header, and
+// false unless it uses the Bearer scheme with a non-empty token
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		provided, bearer := bearerToken(r)
+		var matched *accessToken
+		s.tokenMutex.RLock()
+		// Check every token so timing doesn't reveal which matched
+		for _, tokens := range [][]accessToken{s.tokens, s.projectTokens} {
+			for _, token := range tokens {
+				if bearer && subtle.ConstantTimeCompare([]byte(provided), []byte(token.token)) == 1 {
+					matched = &token
+				}
+			}
+		}
+		s.tokenMutex.RUnlock()
+		if matched == nil && bearer && s.agentTokens != nil && s.agentTokens.allows(provided, r.URL.Path, time.Now()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The heartbeat handler checks job tokens against the job
+		if matched == nil && bearer && minici.IsJobToken(provided) && isHeartbeatPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// GitHub and GitLab can't send tokens, so their webhooks are signed
+		if matched == nil && r.URL.Path == pullRequestHookPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if matched == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeError(w, "Invalid or missing token", http.StatusUnauthorized)
+			return
+		}
+
+		if matched.readOnly {
+			if strings.HasPrefix(r.URL.Path, adminPrefix) {
+				s.writeError(w, "Token is read-only", http.StatusForbidden)
+				return
+			}
+			// Validating a config is a POST but doesn't modify anything
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && r.URL.Path != "/api/validate" {
+				s.writeError(w, "Token is read-only", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), readOnlyKey{}, true))
+		}
+		if matched.project != "" {
+			if !projectTokenAllowed(r.URL.Path) {
+				s.writeError(w, "Token is limited to project "+matched.project, http.StatusForbidden)
+				return
+			}
+			r = withTokenProject(r, matched.project)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start begins serving HTTP requests
+func (s *Server) Start() error {
+	s.logger().Info("REST API server starting", "address", s.address)
+	if s.tlsCert != "" {
+		return s.server.ListenAndServeTLS(s.tlsCert, s.tlsKey)
+	}
+	return s.server.ListenAndServe()
+}
+
+// Serve serves HTTP requests on a listener that's already open, such as one
+// passed in by systemd socket activation
+func (s *Server) Serve(listener net.Listener) error {
+	s.logger().Info("REST API server starting", "address", listener.Addr().String())
+	if s.tlsCert != "" {
+		return s.server.ServeTLS(listener, s.tlsCert, s.tlsKey)
+	}
+	return s.server.Serve(listener)
+}
+
+// Shutdown stops serving, waiting for requests in progress to finish until ctx
+// is done. Serve and Start return http.ErrServerClosed once it's called.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// SetJobPolicy restricts the repos and commands jobs can be scheduled with.
+// Rejected jobs are logged for auditing.
+func (s *Server) SetJobPolicy(policy minici.JobPolicy) {
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+	s.policy = policy
+}
+
+// JobPolicy returns the current job policy
+func (s *Server) JobPolicy() minici.JobPolicy {
+	s.policyMutex.RLock()
+	defer s.policyMutex.RUnlock()
+	return s.policy
+}
+
+// EnableTLS serves HTTPS with the certificate and private key files
+func (s *Server) EnableTLS(certFile, keyFile string) {
+	s.tlsCert = certFile
+	s.tlsKey = keyFile
+}
+
+// Stop gracefully shuts down the server
+func (s *Server) Stop() error {
+	return s.server.Close()
+}
+
+// queueFullRetryAfter is how long clients are asked to wait before submitting
+// again when the queue is full
+const queueFullRetryAfter = 30 * time.Second
+
+// handleScheduleJob processes requests to schedule a new CI job
+func (s *Server) handleScheduleJob(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := queryBool(r, "dry_run")
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	checkRepo, err := queryBool(r, "check_repo")
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate required fields
+	if req.RepoURI == "" || req.Commit == "" || req.Command == "" {
+		s.writeError(w, "Missing required fields: repo_uri, commit, and command are required", http.StatusBadRequest)
+		return
+	}
+
+	project := req.Project
+	if scoped := tokenProject(r); scoped != "" {
+		if project != "" && project != scoped {
+			s.writeError(w, "Token is limited to project "+scoped, http.StatusForbidden)
+			return
+		}
+		project = scoped
+	}
+	spec := req.Spec()
+	spec.Project = project
+	job, ok := s.schedule(w, r, spec, dryRun, checkRepo)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, JobResponse{
+		ID:     string(job.ID),
+		Number: job.Number,
+	}, http.StatusCreated)
+}
+
+// schedule checks a job against the schedule hooks and policies and submits
+// it, returning the job it scheduled. If it wasn't scheduled, such as for a
+// dry run, schedule has already responded and returns false.
+func (s *Server) schedule(w http.ResponseWriter, r *http.Request, spec minici.JobSpec, dryRun, checkRepo bool) (minici.Job, bool) {
+	if !s.admit(w, r, &spec) {
+		return minici.Job{}, false
+	}
+
+	if dryRun {
+		s.handleDryRun(w, r, spec, checkRepo)
+		return minici.Job{}, false
+	}
+
+	jobID, err := s.ci.Submit(spec)
+	if errors.Is(err, minici.ErrQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(queueFullRetryAfter/time.Second)))
+		s.writeError(w, err.Error(), http.StatusTooManyRequests)
+		return minici.Job{}, false
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return minici.Job{}, false
+	}
+
+	job := s.ci.JobDetail(jobID)
+	s.requestLogger(r).Info("Scheduled job", "job_id", jobID, "repo", spec.RepoURI, "commit", spec.Commit)
+	for _, hook := range s.onScheduled {
+		hook(r, job)
+	}
+	return job, true
+}
+
+// admit checks a job against its project, the schedule hooks and the
+// policies, which may change it. If the job isn't allowed, admit has already
+// responded and returns false.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request, spec *minici.JobSpec) bool {
+	projectConfig, ok := s.project(spec.Project)
+	if spec.Project != "" && !ok {
+		s.writeError(w, fmt.Sprintf("Unknown project %q", spec.Project), http.StatusBadRequest)
+		return false
+	}
+
+	for _, hook := range s.beforeSchedule {
+		if err := hook(r, spec); err != nil {
+			s.auditRejected(r, *spec, err)
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				s.writeError(w, apiErr.Message, apiErr.StatusCode)
+				return false
+			}
+			s.writeError(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	err := s.JobPolicy().Check(*spec)
+	if err == nil {
+		err = projectConfig.Check(*spec)
+	}
+	if err != nil {
+		s.auditRejected(r, *spec, err)
+		s.writeError(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// auditRejected logs a job that wasn't scheduled because a hook or policy
+// rejected it
+func (s *Server) auditRejected(r *http.Request, spec minici.JobSpec, err error) {
+	s.requestLogger(r).Warn("Rejected job",
+		"audit", true,
+		"client_ip", s.clientIP(r),
+		"repo", spec.RepoURI,
+		"commit", spec.Commit,
+		"command", spec.Command,
+		"error", err,
+	)
+}
+
+// handleListJobs processes requests to list CI jobs, oldest first. Jobs can be
+// filtered by status, repo, label and when they were created, and are all
+// returned unless a limit is given.
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	filter, err := jobFilter(r)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	found, cursor, err := s.ci.QueryJobs(filter)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobs := make([]string, len(found))
+	for i, job := range found {
+		jobs[i] = string(job.ID)
+	}
+	s.writeJSON(w, ListJobsResponse{Jobs: jobs, NextCursor: string(cursor)}, http.StatusOK)
+}
+
+// jobFilter builds a filter from the query parameters of a request to list jobs
+func jobFilter(r *http.Request) (minici.JobFilter, error) {
+	query := r.URL.Query()
+	filter := minici.JobFilter{
+		RepoURI: query.Get("repo"),
+		Project: projectFilter(r),
+		Group:   query.Get("group"),
+		After:   minici.Cursor(query.Get("cursor")),
+	}
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			filter.Statuses = append(filter.Statuses, minici.JobStatus(status))
+		}
+	}
+	for _, value := range query["label"] {
+		key, labelValue, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return filter, errors.New("label must be key=value")
+		}
+		if filter.Labels == nil {
+			filter.Labels = map[string]string{}
+		}
+		filter.Labels[key] = labelValue
+	}
+	for name, created := range map[string]*time.Time{"since": &filter.CreatedAfter, "until": &filter.CreatedBefore} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*created = parsed
+		}
+	}
+	pinned, err := queryBool(r, "pinned")
+	if err != nil {
+		return filter, err
+	}
+	filter.Pinned = pinned
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 1000 {
+			return filter, errors.New("limit must be a number between 1 and 1000")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// handleJobStatus processes requests to get a job's status
+func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request, jobIDStr string) {
+	job := s.ci.JobDetail(minici.JobID(jobIDStr))
+	response := NewJobResponse(job)
+	// Unknown jobs are described with the ID that was asked for
+	response.ID = jobIDStr
+	s.writeCacheableJSON(w, r, response, jobModified(job), job.Status.Done())
+}
+
+// NewJobResponse describes a job, without its logs
+func NewJobResponse(job minici.Job) JobResponse {
+	return JobResponse{
+		ID:     string(job.ID),
+		Status: string(job.Status),
+		Number: job.Number,
+
+		RepoURI:     job.RepoURI,
+		Commit:      job.Commit,
+		CommitHash:  job.CommitHash,
+		Branch:      job.Branch,
+		Command:     job.Command,
+		Name:        job.Name,
+		Project:     job.Project,
+		Environment: job.Environment,
+		Group:       job.Group,
+		Labels:      job.Labels,
+		Image:       job.Image,
+		Inputs:      job.Inputs,
+		RunsOn:      job.RunsOn,
+		Needs:       needsFromSpec(job.Needs),
+		Agent:       job.Agent,
+		Outputs:     job.Outputs,
+		Tests:       testSummaryResponse(job.Tests),
+		Coverage:    job.Coverage,
+		Hooks:       hooksToResponse(job.Hooks),
+
+		FailedPhase: string(job.FailedPhase),
+		TimedOut:    job.TimedOut,
+		Stalled:     job.Stalled,
+		Progress:    progressToResponse(job.Progress),
+
+		StallTimeoutSeconds: job.StallTimeout.Seconds(),
+		PullRequest:         pullRequestFromSpec(job.PullRequest),
+
+		Annotations: annotationsToResponse(job.Annotations),
+		Sections:    sectionsToResponse(job.Sections),
+
+		RequeuedFrom: string(job.RequeuedFrom),
+		Attempt:      job.Attempt,
+		Pinned:       job.Pinned,
+		Urgent:       job.Urgent,
+	}
+}
+
+// handleJobLogs processes requests to get a job's logs
+func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request, jobIDStr string) {
+	jobID := minici.JobID(jobIDStr)
+	detail := s.ci.JobDetail(jobID)
+	format, err := parseLogFormat(r)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var logs []string
+	if format.raw() {
+		logs = s.ci.JobLogs(jobID)
+	} else {
+		format = format.forJob(detail)
+		for _, line := range s.timedLogs(jobID, 0) {
+			logs = append(logs, format.line(line))
+		}
+	}
+
+	// Logs change whenever a line is added, as well as when the job does
+	modified := jobModified(detail)
+	if len(logs) > 0 {
+		if last := s.timedLogs(jobID, len(logs)-1); len(last) > 0 && last[0].Time.After(modified) {
+			modified = last[0].Time
+		}
+	}
+	s.writeCacheableJSON(w, r, JobResponse{
+		ID:       string(jobID),
+		Logs:     logs,
+		Sections: sectionsToResponse(detail.Sections),
+	}, modified, detail.Status.Done())
+}
+
+// handleTrends processes requests for daily success rate and duration trends per repo.
+// The number of days defaults to 30 and results can be limited to one repo.
+func (s *Server) handleTrends(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 365 {
+			s.writeError(w, "days must be a number between 1 and 365", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	repo := r.URL.Query().Get("repo")
+
+	day := 24 * time.Hour
+	until := time.Now().UTC().Truncate(day).Add(day)
+	since := until.Add(-time.Duration(days) * day)
+
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{RepoURI: repo, Project: projectFilter(r), CreatedBefore: until})
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := TrendsResponse{
+		Since:    since,
+		Interval: "day",
+		Repos:    []RepoTrendResponse{},
+	}
+	for _, trend := range minici.Trends(jobs, since, until, day) {
+		repoTrend := RepoTrendResponse{RepoURI: trend.RepoURI}
+		for _, bucket := range trend.Buckets {
+			var coverage *float64
+			if bucket.Covered > 0 {
+				average := bucket.AverageCoverage()
+				coverage = &average
+			}
+			repoTrend.Buckets = append(repoTrend.Buckets, TrendBucketResponse{
+				Start:       bucket.Start,
+				Total:       bucket.Total,
+				Succeeded:   bucket.Succeeded,
+				Failed:      bucket.Failed,
+				SuccessRate: bucket.SuccessRate(),
+
+				AverageDurationSeconds: bucket.AverageDuration().Seconds(),
+				MaxDurationSeconds:     bucket.MaxDuration.Seconds(),
+				AverageCoverage:        coverage,
+			})
+		}
+		response.Repos = append(response.Repos, repoTrend)
+	}
+
+	s.writeJSON(w, response, http.StatusOK)
+}
+
+// handleQueue processes requests for the current queue depth and worker utilization
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	stats := s.ci.QueueStats()
+	now := time.Now()
+
+	response := QueueResponse{
+		Capacity: stats.Capacity,
+		Pending:  len(stats.Queue),
+		Running:  stats.Running,
+		Workers:  []WorkerResponse{},
+		Queue:    []QueuedJobResponse{},
+
+		AverageDurationSeconds: stats.AverageDuration.Seconds(),
+		EstimatedWaitSeconds:   stats.EstimatedWait.Seconds(),
+		Maintenance:            maintenanceToResponse(stats.Maintenance),
+	}
+	for _, worker := range stats.Workers {
+		running := make([]string, len(worker.Running))
+		for i, id := range worker.Running {
+			running[i] = string(id)
+		}
+		workerResponse := WorkerResponse{
+			Name:     worker.Name,
+			Labels:   worker.Labels,
+			Capacity: worker.Capacity,
+			Running:  running,
+		}
+		if !worker.LastSeen.IsZero() {
+			workerResponse.LastSeen = &worker.LastSeen
+		}
+		response.Workers = append(response.Workers, workerResponse)
+	}
+	project := projectFilter(r)
+	for _, queued := range stats.Queue {
+		if project != "" && queued.Job.Project != project {
+			continue
+		}
+		response.Queue = append(response.Queue, QueuedJobResponse{
+			ID:      string(queued.Job.ID),
+			RepoURI: queued.Job.RepoURI,
+			Commit:  queued.Job.Commit,
+			Command: queued.Job.Command,
+
+			QueuedSeconds:        now.Sub(queued.Job.CreatedAt).Seconds(),
+			EstimatedWaitSeconds: queued.EstimatedWait.Seconds(),
+			Urgent:               queued.Job.Urgent,
+		})
+	}
+
+	s.writeJSON(w, response, http.StatusOK)
+}
+
+// handleRegisterWebhook processes requests to register a new webhook
+func (s *Server) handleRegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.URL == "" {
+		s.writeError(w, "Missing required field: url", http.StatusBadRequest)
+		return
+	}
+
+	target := notify.Target{
+		Name:           "webhook-" + ulid.Make().String(),
+		Notifier:       &notify.Webhook{URL: req.URL, Secret: req.Secret},
+		Repos:          req.Repos,
+		AllTransitions: true,
+	}
+	for _, status := range req.Statuses {
+		parsed, err := notify.ParseStatus(status, true)
+		if err != nil {
+			s.writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target.Statuses = append(target.Statuses, parsed)
+	}
+	s.dispatcher.AddTarget(target)
+
+	s.writeJSON(w, WebhookResponse{
+		ID: target.Name,
+	}, http.StatusCreated)
+}
+
+// handleListWebhooks processes requests to list registered webhooks
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks := []WebhookResponse{}
+	for _, target := range s.dispatcher.Targets() {
+		webhook, ok := target.Notifier.(*notify.Webhook)
+		if !ok {
+			continue
+		}
+		response := WebhookResponse{
+			ID:    target.Name,
+			URL:   webhook.URL,
+			Repos: target.Repos,
+		}
+		for _, status := range target.Statuses {
+			response.Statuses = append(response.Statuses, string(status))
+		}
+		webhooks = append(webhooks, response)
+	}
+
+	s.writeJSON(w, ListWebhooksResponse{Webhooks: webhooks}, http.StatusOK)
+}
+
+// handleDeleteWebhook processes requests to unregister a webhook
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	for _, target := range s.dispatcher.Targets() {
+		if _, ok := target.Notifier.(*notify.Webhook); ok && target.Name == id {
+			s.dispatcher.RemoveTarget(id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	s.writeError(w, "Webhook not found", http.StatusNotFound)
+}
+
+// handleJobLogStream streams a job's logs as Server-Sent Events until the job completes.
+// Each log line is sent as a "log" event with the line index as its ID, so clients can
+// resume with the Last-Event-ID header or a "from" query parameter. When the job
+// completes, a "done" event is sent with the final status.
+func (s *Server) handleJobLogStream(w http.ResponseWriter, r *http.Request, jobIDStr string) {
+	jobID := minici.JobID(jobIDStr)
+
+	next := 0
+	from := r.URL.Query().Get("from")
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		if id, err := strconv.Atoi(lastID); err == nil {
+			from = strconv.Itoa(id + 1)
+		}
+	}
+	if from != "" {
+		var err error
+		next, err = strconv.Atoi(from)
+		if err != nil || next < 0 {
+			s.writeError(w, "Invalid from parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format, err := parseLogFormat(r)
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Streams can outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		// Check the status before reading logs, so no lines are missed once the job is done
+		detail := s.ci.JobDetail(jobID)
+		status := detail.Status
+
+		lineFormat := format.forJob(detail)
+		for _, timed := range s.timedLogs(jobID, next) {
+			fmt.Fprintf(w, "id: %d\nevent: log\n", next)
+			next++
+			for _, line := range strings.Split(lineFormat.line(timed), "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+		}
+
+		if status.Done() {
+			data, _ := json.Marshal(JobResponse{
+				ID:     string(jobID),
+				Status: string(status),
+			})
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			rc.Flush()
+			return
+		}
+		rc.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleCancelJob processes requests to cancel a pending or running job
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request, jobIDStr string) {
+	jobID := minici.JobID(jobIDStr)
+
+	err := s.ci.CancelJob(jobID)
+	if errors.Is(err, minici.ErrJobNotFound) {
+		s.writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, minici.ErrJobFinished) || errors.Is(err, minici.ErrJobRunElsewhere) {
+		s.writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	detail := s.ci.JobDetail(jobID)
+	s.writeJSON(w, JobResponse{
+		ID:     string(jobID),
+		Status: string(detail.Status),
+		Number: detail.Number,
+
+		RepoURI:     detail.RepoURI,
+		Commit:      detail.Commit,
+		Command:     detail.Command,
+		Name:        detail.Name,
+		Project:     detail.Project,
+		Environment: detail.Environment,
+		Group:       detail.Group,
+		Labels:      detail.Labels,
+		Image:       detail.Image,
+		Inputs:      detail.Inputs,
+		RunsOn:      detail.RunsOn,
+		Needs:       needsFromSpec(detail.Needs),
+		Agent:       detail.Agent,
+		Outputs:     detail.Outputs,
+		Tests:       testSummaryResponse(detail.Tests),
+		Coverage:    detail.Coverage,
+		Hooks:       hooksToResponse(detail.Hooks),
+
+		FailedPhase: string(detail.FailedPhase),
+		TimedOut:    detail.TimedOut,
+		Stalled:     detail.Stalled,
+		Progress:    progressToResponse(detail.Progress),
+
+		StallTimeoutSeconds: detail.StallTimeout.Seconds(),
+
+		Annotations: annotationsToResponse(detail.Annotations),
+		Sections:    sectionsToResponse(detail.Sections),
+
+		RequeuedFrom: string(detail.RequeuedFrom),
+		Attempt:      detail.Attempt,
+	}, http.StatusOK)
+}
+
+// handleWait blocks until all jobs are complete, returning 200 if all succeeded or 500 if any failed
+// If no jobs are scheduled after 30s, returns 204 No Content.
+// Times out 5 minutes after this request or the start of the first job, whichever is later.
+// Project tokens only wait for their project's jobs.
+// Filters such as group or label wait for the matching jobs instead, see handleWaitMatching.
+func (s *Server) handleWait(w http.ResponseWriter, r *http.Request) {
+	if isFilteredWait(r) {
+		s.handleWaitMatching(w, r)
+		return
+	}
+	detailed := acceptsMediaType(r, WaitResponseType)
+	if detailed {
+		w.Header().Set("Content-Type", WaitResponseType)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	respond := func(success bool, message string, statusCode int) {
+		if detailed {
+			s.writeJSONNoContentType(w, WaitResponse{Success: success, Message: message}, statusCode)
+			return
+		}
+		s.writeJSONNoContentType(w, message, statusCode)
+	}
+
+	logger := s.requestLogger(r)
+	startTime := time.Now()
+	defer func() {
+		logger.Debug("Finished waiting for jobs", "duration", time.Since(startTime), "cancelled", r.Context().Err() != nil)
+	}()
+
+	var err error
+	project := tokenProject(r)
+	if project != "" {
+		// Project tokens wait up to 5 minutes for their project's jobs, as
+		// scheduled when they started waiting
+		jobs, _, _ := s.ci.QueryJobs(minici.JobFilter{Project: project})
+		if len(jobs) == 0 {
+			logger.Info("No jobs scheduled to wait for", "project", project)
+			respond(true, "no jobs scheduled", http.StatusNoContent)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+		_, err = s.ci.WaitForJobs(ctx, minici.NewGroup("", jobs).JobIDs()...)
+		cancel()
+	} else {
+		// Wait up to 30s for at least one job to have started, then up to 5
+		// minutes for all jobs to complete
+		firstCtx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		_, err = s.ci.WaitForJobs(firstCtx)
+		cancel()
+		if err != nil && r.Context().Err() == nil {
+			if len(s.ci.ListJobs()) == 0 {
+				logger.Info("No jobs scheduled to wait for")
+				respond(true, "no jobs scheduled", http.StatusNoContent)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+			_, err = s.ci.WaitForJobs(ctx)
+			cancel()
+		}
+	}
+	if err != nil {
+		logger.Warn("Timed out waiting for jobs to complete")
+		respond(false, "timeout waiting for jobs to complete", http.StatusRequestTimeout)
+		return
+	}
+
+	failed, _, _ := s.ci.QueryJobs(minici.JobFilter{
+		Statuses: []minici.JobStatus{minici.JobStatusFailure, minici.JobStatusCancelled, minici.JobStatusInterrupted},
+		Project:  project,
+		Limit:    1,
+	})
+	for _, job := range failed {
+		logger.Info("Job failed while waiting", "job_id", job.ID, "status", job.Status)
+		respond(false, "one or more jobs failed", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Debug("All jobs completed successfully")
+	respond(true, "all jobs completed successfully", http.StatusOK)
+}
+
+// acceptsMediaType reports whether the request's Accept header lists the
+// media type
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if accepted, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes a JSON response with the given status code
+func (s *Server) writeJSONNoContentType(w http.ResponseWriter, data interface{}, statusCode int) {
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// writeJSON writes a JSON response with the given status code
+func (s *Server) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// writeError writes an error response with the given message and status code
+func (s *Server) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(ErrorResponse{Error: message}); err != nil {
+		http.Error(w, message, statusCode)
+	}
+}
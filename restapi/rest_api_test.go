@@ -0,0 +1,2153 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/minici"
+	"github.com/ocuroot/minici/citest"
+	"github.com/ocuroot/minici/delivery"
+	"github.com/ocuroot/minici/notify"
+	"github.com/ocuroot/minici/webhookverify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockCI implements the CI interface for testing. Its jobs are changed by
+// the simulated runs and by tests while the server reads them, so tests
+// touching jobs or logs after the server starts do so under mu.
+type mockCI struct {
+	mu        sync.Mutex
+	jobs      map[minici.JobID]*minici.Job
+	logs      map[minici.JobID][]string
+	nextJobID minici.JobID
+}
+
+func newMockCI() *mockCI {
+	return &mockCI{
+		jobs:      make(map[minici.JobID]*minici.Job),
+		logs:      make(map[minici.JobID][]string),
+		nextJobID: minici.JobID("job-1"),
+	}
+}
+
+func (m *mockCI) ScheduleJob(repoURI string, commit string, command string) minici.JobID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.scheduleJob(repoURI, commit, command)
+}
+
+// scheduleJob adds a pending job and simulates running it, with mu held
+func (m *mockCI) scheduleJob(repoURI string, commit string, command string) minici.JobID {
+	jobID := m.nextJobID
+	job := &minici.Job{
+		ID:      jobID,
+		Status:  minici.JobStatusPending,
+		RepoURI: repoURI,
+		Commit:  commit,
+		Command: command,
+	}
+	m.jobs[jobID] = job
+	m.logs[jobID] = []string{"Job scheduled"}
+
+	// Simulate job execution
+	go func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		job.Status = minici.JobStatusRunning
+		m.logs[jobID] = append(m.logs[jobID], "Job started")
+
+		job.Status = minici.JobStatusSuccess
+		m.logs[jobID] = append(m.logs[jobID], "Job completed successfully")
+	}()
+
+	return jobID
+}
+
+func (m *mockCI) Submit(spec minici.JobSpec) (minici.JobID, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobID := m.scheduleJob(spec.RepoURI, spec.Commit, spec.Command)
+	m.jobs[jobID].Labels = spec.Labels
+	m.jobs[jobID].Image = spec.Image
+	m.jobs[jobID].Project = spec.Project
+	m.jobs[jobID].Name = spec.Name
+	m.jobs[jobID].Environment = spec.Environment
+	m.jobs[jobID].Inputs = spec.Inputs
+	return jobID, nil
+}
+
+func (m *mockCI) ListJobs() []minici.JobID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobIDs []minici.JobID
+	for id := range m.jobs {
+		jobIDs = append(jobIDs, id)
+	}
+	return jobIDs
+}
+
+func (m *mockCI) AllJobDetail() []minici.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []minici.Job
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+func (m *mockCI) QueryJobs(filter minici.JobFilter) ([]minici.Job, minici.Cursor, error) {
+	return minici.FilterJobs(m.AllJobDetail(), filter)
+}
+
+// WaitForJobs polls, since the mock's jobs are changed directly by tests
+func (m *mockCI) WaitForJobs(ctx context.Context, ids ...minici.JobID) ([]minici.Job, error) {
+	for {
+		var jobs []minici.Job
+		if len(ids) == 0 {
+			jobs = m.AllJobDetail()
+		}
+		for _, id := range ids {
+			jobs = append(jobs, m.JobDetail(id))
+		}
+		done := len(jobs) > 0
+		for _, job := range jobs {
+			done = done && job.Status.Done()
+		}
+		if done {
+			return jobs, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (m *mockCI) JobDetail(jobID minici.JobID) minici.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, exists := m.jobs[jobID]; exists {
+		return *job
+	}
+	return minici.Job{
+		ID:     jobID,
+		Status: minici.JobStatusFailure,
+	}
+}
+
+func (m *mockCI) JobLogs(jobID minici.JobID) []string {
+	return m.JobLogsFrom(jobID, 0)
+}
+
+func (m *mockCI) JobLogsFrom(jobID minici.JobID, from int) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if logs := m.logs[jobID]; from < len(logs) {
+		return slices.Clone(logs[from:])
+	}
+	return []string{}
+}
+
+func (m *mockCI) CancelJob(jobID minici.JobID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, exists := m.jobs[jobID]
+	if !exists {
+		return minici.ErrJobNotFound
+	}
+	if job.Status.Done() {
+		return minici.ErrJobFinished
+	}
+	job.Status = minici.JobStatusCancelled
+	return nil
+}
+
+func (m *mockCI) QueueStats() minici.QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := minici.QueueStats{Capacity: 2}
+	worker := minici.WorkerStats{Name: "test", Capacity: 2}
+	for _, job := range m.jobs {
+		switch job.Status {
+		case minici.JobStatusRunning:
+			stats.Running++
+			worker.Running = append(worker.Running, job.ID)
+		case minici.JobStatusPending:
+			stats.Queue = append(stats.Queue, minici.QueuedJob{Job: *job, EstimatedWait: time.Minute})
+		}
+	}
+	stats.Workers = []minici.WorkerStats{worker}
+	return stats
+}
+
+// createCompletedJob creates a job in completed state for testing
+func (m *mockCI) createCompletedJob(jobID minici.JobID, repoURI, commit, command string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[jobID] = &minici.Job{
+		ID:      jobID,
+		Status:  minici.JobStatusSuccess,
+		RepoURI: repoURI,
+		Commit:  commit,
+		Command: command,
+	}
+	m.logs[jobID] = []string{"Job scheduled", "Job started", "Job completed successfully"}
+}
+
+// updateJob changes a job the server may be reading
+func (m *mockCI) updateJob(jobID minici.JobID, update func(job *minici.Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	update(m.jobs[jobID])
+}
+
+func TestRESTServer(t *testing.T) {
+	// Create a mock CI implementation
+	ci := newMockCI()
+
+	// Create the REST server with the mock CI
+	restServer := NewServer(ci, ":8080")
+
+	t.Run("Schedule Job", func(t *testing.T) {
+		// Create request body
+		jobReq := JobRequest{
+			RepoURI: "https://github.com/ocuroot/minici",
+			Commit:  "main",
+			Command: "go test ./...",
+		}
+		body, _ := json.Marshal(jobReq)
+
+		// Create HTTP request
+		req := httptest.NewRequest("POST", "/api/jobs", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.router.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var response JobResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.Equal(t, "job-1", response.ID)
+	})
+
+	t.Run("List Jobs", func(t *testing.T) {
+		// Create HTTP request
+		req := httptest.NewRequest("GET", "/api/jobs", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.router.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response ListJobsResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.Len(t, response.Jobs, 1)
+	})
+
+	t.Run("Job Status", func(t *testing.T) {
+		// Create a completed job directly in the mock CI
+		ci.createCompletedJob(minici.JobID("job-test-status"), "https://github.com/ocuroot/minici", "main", "go test ./...")
+
+		// Create HTTP request
+		req := httptest.NewRequest("GET", "/api/jobs/job-test-status", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.server.Handler.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response JobResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+
+		// Verify specific job details from the mock CI
+		assert.Equal(t, "job-test-status", response.ID)
+		assert.Equal(t, "success", response.Status)
+		assert.Equal(t, "https://github.com/ocuroot/minici", response.RepoURI)
+		assert.Equal(t, "main", response.Commit)
+		assert.Equal(t, "go test ./...", response.Command)
+	})
+
+	t.Run("Job Logs", func(t *testing.T) {
+		// Create a completed job directly in the mock CI
+		ci.createCompletedJob(minici.JobID("job-test-logs"), "https://github.com/ocuroot/minici", "main", "go test ./...")
+
+		// Create HTTP request
+		req := httptest.NewRequest("GET", "/api/jobs/job-test-logs/logs", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.server.Handler.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response JobResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+
+		// Verify specific log messages from the mock CI
+		assert.Len(t, response.Logs, 3, "Expected exactly 3 log messages")
+		assert.Equal(t, "Job scheduled", response.Logs[0])
+		assert.Equal(t, "Job started", response.Logs[1])
+		assert.Equal(t, "Job completed successfully", response.Logs[2])
+
+		// Verify the job ID is correct
+		assert.Equal(t, "job-test-logs", response.ID)
+	})
+
+	t.Run("Job Status - Non-existent Job", func(t *testing.T) {
+		// Create HTTP request for non-existent job
+		req := httptest.NewRequest("GET", "/api/jobs/non-existent-job", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.server.Handler.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response JobResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+
+		// Verify mock CI returns failure status for non-existent jobs
+		assert.Equal(t, "non-existent-job", response.ID)
+		assert.Equal(t, "failure", response.Status)
+		assert.Empty(t, response.RepoURI)
+		assert.Empty(t, response.Commit)
+		assert.Empty(t, response.Command)
+	})
+
+	t.Run("Job Logs - Non-existent Job", func(t *testing.T) {
+		// Create HTTP request for non-existent job
+		req := httptest.NewRequest("GET", "/api/jobs/non-existent-job/logs", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.server.Handler.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response JobResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+
+		// Verify mock CI returns empty logs for non-existent jobs
+		assert.Equal(t, "non-existent-job", response.ID)
+		assert.Empty(t, response.Logs)
+	})
+}
+
+func TestInvalidRequests(t *testing.T) {
+	// Create a mock CI implementation
+	ci := newMockCI()
+
+	// Create the REST server with the mock CI
+	restServer := NewServer(ci, ":8080")
+
+	t.Run("Invalid Job Request", func(t *testing.T) {
+		// Create invalid request body (missing required fields)
+		jobReq := JobRequest{
+			RepoURI: "", // Missing required field
+			Commit:  "main",
+			Command: "go test ./...",
+		}
+		body, _ := json.Marshal(jobReq)
+
+		// Create HTTP request
+		req := httptest.NewRequest("POST", "/api/jobs", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.router.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Invalid Job ID", func(t *testing.T) {
+		// Create HTTP request with non-existent job ID
+		req := httptest.NewRequest("GET", "/api/jobs/nonexistent", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.router.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response JobResponse
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.Equal(t, "nonexistent", response.ID)
+		assert.Equal(t, string(minici.JobStatusFailure), response.Status)
+	})
+}
+
+func TestWait(t *testing.T) {
+	// Create a mock CI implementation
+	ci := newMockCI()
+
+	// Create the REST server with the mock CI
+	restServer := NewServer(ci, ":8080")
+
+	t.Run("Wait for Jobs", func(t *testing.T) {
+		go func() {
+			for i := 0; i < 10; i++ {
+				ci.ScheduleJob("https://github.com/ocuroot/minici", "main", "go test ./...")
+			}
+		}()
+
+		// Create HTTP request
+		req := httptest.NewRequest("GET", "/api/wait", nil)
+
+		// Create response recorder
+		rr := httptest.NewRecorder()
+
+		// Handle request
+		restServer.router.ServeHTTP(rr, req)
+
+		// Check response
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	queue, err := delivery.NewQueue(delivery.Config{})
+	require.NoError(t, err)
+	dispatcher := notify.NewDispatcher(queue, "", nil)
+
+	restServer := NewServer(newMockCI(), ":8080")
+	restServer.EnableWebhooks(dispatcher)
+
+	body, _ := json.Marshal(WebhookRequest{
+		URL:      "https://deploy.example.com/hook",
+		Secret:   "s3cret",
+		Statuses: []string{"running", "success"},
+	})
+	req := httptest.NewRequest("POST", "/api/webhooks", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var created WebhookResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	assert.NotEmpty(t, created.ID)
+
+	req = httptest.NewRequest("GET", "/api/webhooks", nil)
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var list ListWebhooksResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+	require.Len(t, list.Webhooks, 1)
+	assert.Equal(t, created.ID, list.Webhooks[0].ID)
+	assert.Equal(t, "https://deploy.example.com/hook", list.Webhooks[0].URL)
+	assert.Equal(t, []string{"running", "success"}, list.Webhooks[0].Statuses)
+	assert.NotContains(t, rr.Body.String(), "s3cret")
+
+	req = httptest.NewRequest("DELETE", "/api/webhooks/"+created.ID, nil)
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, dispatcher.Targets())
+
+	req = httptest.NewRequest("DELETE", "/api/webhooks/"+created.ID, nil)
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	body, _ = json.Marshal(WebhookRequest{URL: "https://deploy.example.com/hook", Statuses: []string{"exploded"}})
+	req = httptest.NewRequest("POST", "/api/webhooks", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestJobLogStream(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":8080")
+	ci.createCompletedJob(minici.JobID("job-stream"), "https://github.com/ocuroot/minici", "main", "go test ./...")
+
+	req := httptest.NewRequest("GET", "/api/jobs/job-stream/logs/stream", nil)
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "id: 0\nevent: log\ndata: Job scheduled\n\n"+
+		"id: 1\nevent: log\ndata: Job started\n\n"+
+		"id: 2\nevent: log\ndata: Job completed successfully\n\n"+
+		"event: done\ndata: {\"id\":\"job-stream\",\"status\":\"success\",\"repo_uri\":\"\",\"commit\":\"\",\"command\":\"\"}\n\n",
+		rr.Body.String())
+
+	// Resuming skips lines that were already received
+	req = httptest.NewRequest("GET", "/api/jobs/job-stream/logs/stream", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "id: 2\nevent: log\ndata: Job completed successfully\n\n"))
+
+	req = httptest.NewRequest("GET", "/api/jobs/job-stream/logs/stream?from=abc", nil)
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestListJobsFilters(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	created := time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)
+	for i, id := range []minici.JobID{"job-a", "job-b", "job-c"} {
+		ci.createCompletedJob(id, "https://github.com/ocuroot/minici", "main", "make")
+		ci.jobs[id].CreatedAt = created.Add(time.Duration(i) * time.Hour)
+	}
+	ci.jobs["job-b"].Status = minici.JobStatusFailure
+	ci.jobs["job-b"].Labels = map[string]string{"branch": "dev"}
+	ci.createCompletedJob("job-d", "https://github.com/ocuroot/other", "main", "make")
+	ci.jobs["job-d"].CreatedAt = created.Add(6 * time.Hour)
+
+	list := func(query string) (int, ListJobsResponse) {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs?"+query, nil))
+		var resp ListJobsResponse
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		}
+		return rr.Code, resp
+	}
+
+	_, resp := list("status=success&repo=" + url.QueryEscape("https://github.com/ocuroot/minici"))
+	assert.Equal(t, []string{"job-a", "job-c"}, resp.Jobs)
+	_, resp = list("label=branch=dev")
+	assert.Equal(t, []string{"job-b"}, resp.Jobs)
+	_, resp = list("since=2025-01-02T03:00:00Z&until=2025-01-02T06:00:00Z")
+	assert.Equal(t, []string{"job-b", "job-c"}, resp.Jobs)
+
+	_, resp = list("limit=2")
+	assert.Equal(t, []string{"job-a", "job-b"}, resp.Jobs)
+	require.NotEmpty(t, resp.NextCursor)
+	_, resp = list("limit=2&cursor=" + url.QueryEscape(resp.NextCursor))
+	assert.Equal(t, ListJobsResponse{Jobs: []string{"job-c", "job-d"}}, resp)
+
+	for _, invalid := range []string{"status=done", "label=branch", "since=yesterday", "limit=0", "cursor=job-b"} {
+		code, _ := list(invalid)
+		assert.Equal(t, http.StatusBadRequest, code, invalid)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+
+	now := time.Now()
+	ci.createCompletedJob("job-a", "https://github.com/ocuroot/minici", "main", "go test ./...")
+	ci.jobs["job-a"].StartedAt = now.Add(-time.Minute)
+	ci.jobs["job-a"].FinishedAt = now
+	ci.createCompletedJob("job-b", "https://github.com/ocuroot/other", "main", "make")
+	ci.jobs["job-b"].Status = minici.JobStatusFailure
+	ci.jobs["job-b"].FinishedAt = now
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stats/trends?days=7", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response TrendsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "day", response.Interval)
+	require.Len(t, response.Repos, 2)
+	assert.Equal(t, "https://github.com/ocuroot/minici", response.Repos[0].RepoURI)
+	require.Len(t, response.Repos[0].Buckets, 7)
+	today := response.Repos[0].Buckets[6]
+	assert.Equal(t, 1, today.Total)
+	assert.Equal(t, 1.0, today.SuccessRate)
+	assert.InDelta(t, 60, today.AverageDurationSeconds, 0.001)
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stats/trends?repo=https://github.com/ocuroot/other", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.Len(t, response.Repos, 1)
+	assert.Len(t, response.Repos[0].Buckets, 30)
+	assert.Equal(t, 0.0, response.Repos[0].Buckets[29].SuccessRate)
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stats/trends?days=0", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestFlakyTests(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+
+	repo := "https://github.com/ocuroot/minici"
+	now := time.Now()
+	for i, status := range []minici.TestStatus{minici.TestPassed, minici.TestFailed, minici.TestPassed} {
+		id := fmt.Sprintf("job-%d", i)
+		ci.createCompletedJob(minici.JobID(id), repo, "main", "go test ./...")
+		ci.jobs[minici.JobID(id)].FinishedAt = now.Add(time.Duration(i) * time.Minute)
+		ci.jobs[minici.JobID(id)].Tests = []minici.TestResult{
+			{Suite: "minici", Name: "TestStable", Status: minici.TestPassed},
+			{Suite: "minici", Name: "TestFlaky", Status: status},
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/repos/"+url.PathEscape(repo)+"/flaky-tests", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response FlakyTestsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, repo, response.RepoURI)
+	require.Len(t, response.Tests, 1)
+	assert.Equal(t, "TestFlaky", response.Tests[0].Name)
+	assert.Equal(t, 2, response.Tests[0].Flips)
+	assert.Equal(t, "job-1", response.Tests[0].LastFailed)
+
+	// Only the last two jobs are checked, in which the test flipped once
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/repos/"+url.PathEscape(repo)+"/flaky-tests?builds=2", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"repo_uri": "`+repo+`", "builds": 2, "tests": []}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/repos/"+url.PathEscape(repo)+"/flaky-tests?builds=1", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/repos/"+url.PathEscape(repo)+"/other", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestCoverageBadge(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	repo := "https://github.com/ocuroot/minici"
+	badge := func() string {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/repos/"+url.PathEscape(repo)+"/coverage.svg", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "image/svg+xml", rr.Header().Get("Content-Type"))
+		return rr.Body.String()
+	}
+
+	assert.Contains(t, badge(), ">unknown<")
+
+	coverage := 83.4
+	ci.createCompletedJob("job-covered", repo, "main", "go test -coverprofile=coverage.out ./...")
+	ci.jobs["job-covered"].Coverage = &coverage
+	assert.Contains(t, badge(), ">83%<")
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs/job-covered", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	require.NotNil(t, job.Coverage)
+	assert.Equal(t, 83.4, *job.Coverage)
+}
+
+func TestEnvironments(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	get := func(path string) EnvironmentsResponse {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp EnvironmentsResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp
+	}
+	assert.Equal(t, EnvironmentsResponse{Environments: []EnvironmentResponse{}}, get("/api/environments"))
+
+	deployed := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for id, job := range map[minici.JobID]struct {
+		repo, commit, environment string
+		status                    minici.JobStatus
+	}{
+		"job-api-1": {"https://github.com/ocuroot/api", "v1.0.0", "prod", minici.JobStatusSuccess},
+		"job-api-2": {"https://github.com/ocuroot/api", "v1.1.0", "prod", minici.JobStatusFailure},
+		"job-api-3": {"https://github.com/ocuroot/api", "v1.1.0", "staging", minici.JobStatusSuccess},
+		"job-web-1": {"https://github.com/ocuroot/web", "abc123", "prod", minici.JobStatusSuccess},
+	} {
+		ci.createCompletedJob(id, job.repo, job.commit, "./deploy.sh")
+		ci.jobs[id].Environment = job.environment
+		ci.jobs[id].Status = job.status
+		ci.jobs[id].FinishedAt = deployed
+	}
+
+	expected := []EnvironmentResponse{
+		{Name: "prod", Deployments: []DeploymentResponse{
+			{RepoURI: "https://github.com/ocuroot/api", Commit: "v1.0.0", JobID: "job-api-1", DeployedAt: deployed},
+			{RepoURI: "https://github.com/ocuroot/web", Commit: "abc123", JobID: "job-web-1", DeployedAt: deployed},
+		}},
+		{Name: "staging", Deployments: []DeploymentResponse{
+			{RepoURI: "https://github.com/ocuroot/api", Commit: "v1.1.0", JobID: "job-api-3", DeployedAt: deployed},
+		}},
+	}
+	assert.Equal(t, expected, get("/api/environments").Environments)
+	assert.Len(t, get("/api/environments?repo="+url.QueryEscape("https://github.com/ocuroot/web")).Environments, 1)
+
+	// Jobs are scheduled with their environment, which must be a valid name
+	submit := func(environment string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(JobRequest{RepoURI: "https://github.com/ocuroot/api", Commit: "v1.2.0", Command: "./deploy.sh", Environment: environment})
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs", bytes.NewReader(body)))
+		return rr
+	}
+	rr := submit("prod eu")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `invalid environment \"prod eu\"`)
+	rr = submit("prod")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs/"+created.ID, nil))
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.Equal(t, "prod", job.Environment)
+}
+
+func TestRollback(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	deployed := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, commit := range []string{"v1.0.0", "v1.1.0"} {
+		id := minici.JobID(fmt.Sprintf("job-api-%d", i+1))
+		ci.createCompletedJob(id, "https://github.com/ocuroot/api", commit, "./deploy.sh prod")
+		ci.jobs[id].Environment = "prod"
+		ci.jobs[id].FinishedAt = deployed.Add(time.Duration(i) * time.Hour)
+	}
+	rollback := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rr
+	}
+
+	// Rollbacks are checked like any other job
+	restServer.SetJobPolicy(minici.JobPolicy{Repos: []string{"https://github.com/someone/*"}})
+	assert.Equal(t, http.StatusForbidden, rollback("/api/environments/prod/rollback", "").Code)
+	assert.Len(t, ci.jobs, 2)
+	restServer.SetJobPolicy(minici.JobPolicy{})
+
+	rr := rollback("/api/environments/prod/rollback", "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.Equal(t, "https://github.com/ocuroot/api", job.RepoURI)
+	assert.Equal(t, "v1.0.0", job.Commit)
+	assert.Equal(t, "./deploy.sh prod", job.Command)
+	assert.Equal(t, "prod", job.Environment)
+	assert.Equal(t, "Roll back prod to v1.0.0", job.Name)
+
+	ci.createCompletedJob("job-web-1", "https://github.com/ocuroot/web", "abc123", "./deploy.sh prod")
+	ci.jobs["job-web-1"].Environment = "prod"
+	assert.Equal(t, http.StatusBadRequest, rollback("/api/environments/prod/rollback", "").Code)
+	assert.Equal(t, http.StatusConflict, rollback("/api/environments/prod/rollback", `{"repo_uri": "https://github.com/ocuroot/web"}`).Code)
+	assert.Equal(t, http.StatusCreated, rollback("/api/environments/prod/rollback", `{"repo_uri": "https://github.com/ocuroot/api"}`).Code)
+	assert.Equal(t, http.StatusNotFound, rollback("/api/environments/staging/rollback", "").Code)
+	assert.Equal(t, http.StatusNotFound, rollback("/api/environments/prod/promote", "").Code)
+	assert.Equal(t, http.StatusBadRequest, rollback("/api/environments/prod/rollback", "{").Code)
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/environments/prod/rollback", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestRerun(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	ci.createCompletedJob("job-deploy", "https://github.com/ocuroot/api", "v1.0.0", "./deploy.sh")
+	ci.jobs["job-deploy"].Environment = "prod"
+	ci.jobs["job-deploy"].Labels = map[string]string{"team": "api"}
+	ci.jobs["job-deploy"].Inputs = map[string]string{"version": "1.0.0", "target": "prod"}
+	rerun := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := rerun("/api/jobs/job-deploy/rerun", "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.NotEqual(t, "job-deploy", job.ID)
+	assert.Equal(t, "v1.0.0", job.Commit)
+	assert.Equal(t, "./deploy.sh", job.Command)
+	assert.Equal(t, "prod", job.Environment)
+	assert.Equal(t, map[string]string{"version": "1.0.0", "target": "prod"}, job.Inputs)
+
+	rr = rerun("/api/jobs/job-deploy/rerun", `{"commit": "v1.0.1", "inputs": {"version": "1.0.1"}, "labels": {"debug": "true"}}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.Equal(t, "v1.0.1", job.Commit)
+	assert.Equal(t, map[string]string{"version": "1.0.1", "target": "prod"}, job.Inputs)
+	assert.Equal(t, map[string]string{"team": "api", "debug": "true"}, job.Labels)
+	assert.Equal(t, map[string]string{"version": "1.0.0", "target": "prod"}, ci.jobs["job-deploy"].Inputs)
+
+	// Re-runs are checked like any other job
+	restServer.SetJobPolicy(minici.JobPolicy{Commands: []string{"./deploy.sh"}})
+	assert.Equal(t, http.StatusForbidden, rerun("/api/jobs/job-deploy/rerun", `{"command": "rm -rf /"}`).Code)
+	restServer.SetJobPolicy(minici.JobPolicy{})
+
+	assert.Equal(t, http.StatusNotFound, rerun("/api/jobs/missing/rerun", "").Code)
+	assert.Equal(t, http.StatusBadRequest, rerun("/api/jobs/job-deploy/rerun", "{").Code)
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs/job-deploy/rerun", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestJobProblemsAndSections(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	ci.createCompletedJob("job-failed", "https://github.com/ocuroot/minici", "main", "go build ./...")
+	ci.jobs["job-failed"].Status = minici.JobStatusFailure
+	ci.jobs["job-failed"].Annotations = []minici.Annotation{
+		{Line: 2, Severity: minici.SeverityError, Message: "main.go:3:1: syntax error"},
+	}
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs/job-failed", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.Equal(t, []AnnotationResponse{{Line: 2, Severity: "error", Message: "main.go:3:1: syntax error"}}, job.Annotations)
+
+	// Sections are also returned with the logs
+	end := 4
+	ci.jobs["job-failed"].Sections = []minici.LogSection{
+		{Name: "Setup", Start: 0, End: end},
+		{Name: "Build", Start: 5, End: -1},
+	}
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs/job-failed/logs", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var logs JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&logs))
+	assert.Equal(t, []LogSectionResponse{{Name: "Setup", Start: 0, End: &end}, {Name: "Build", Start: 5}}, logs.Sections)
+}
+
+func TestBuildNumbers(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	ci.createCompletedJob("job-142", "https://github.com/ocuroot/minici.git", "main", "make release")
+	ci.jobs["job-142"].Number = 142
+	ci.jobs["job-142"].Name = "Nightly release"
+
+	get := func(number string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/repos/"+url.PathEscape("https://github.com/ocuroot/minici.git")+"/builds/"+number, nil))
+		return rr
+	}
+	assert.Equal(t, http.StatusBadRequest, get("latest").Code)
+	assert.Equal(t, http.StatusNotFound, get("7").Code)
+
+	rr := get("142")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var job JobResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+	assert.Equal(t, "job-142", job.ID)
+	assert.Equal(t, 142, job.Number)
+	assert.Equal(t, "Nightly release", job.Name)
+}
+
+func TestQueue(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+
+	ci.createCompletedJob("job-running", "https://github.com/ocuroot/minici", "main", "go test ./...")
+	ci.jobs["job-running"].Status = minici.JobStatusRunning
+	ci.createCompletedJob("job-pending", "https://github.com/ocuroot/minici", "main", "go vet ./...")
+	ci.jobs["job-pending"].Status = minici.JobStatusPending
+	ci.jobs["job-pending"].CreatedAt = time.Now().Add(-time.Minute)
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stats/queue", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response QueueResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, 2, response.Capacity)
+	assert.Equal(t, 1, response.Pending)
+	assert.Equal(t, 1, response.Running)
+	require.Len(t, response.Workers, 1)
+	assert.Equal(t, []string{"job-running"}, response.Workers[0].Running)
+	require.Len(t, response.Queue, 1)
+	assert.Equal(t, "job-pending", response.Queue[0].ID)
+	assert.Equal(t, 60.0, response.Queue[0].EstimatedWaitSeconds)
+	assert.InDelta(t, 60, response.Queue[0].QueuedSeconds, 5)
+}
+
+func TestReadOnlyToken(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	restServer.RequireToken("admin")
+	restServer.AddReadOnlyToken("viewer")
+	ci.createCompletedJob("job-1", "https://github.com/ocuroot/minici", "main", "go test ./...")
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"repo_uri":"a","commit":"b","command":"c"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	session := func(token string) SessionResponse {
+		rr := request("GET", "/api/session", token)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var response SessionResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		return response
+	}
+	assert.False(t, session("admin").ReadOnly)
+	assert.True(t, session("viewer").ReadOnly)
+
+	assert.Equal(t, http.StatusOK, request("GET", "/api/jobs", "viewer").Code)
+	assert.Equal(t, http.StatusOK, request("GET", "/api/jobs/job-1/logs", "viewer").Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/jobs", "viewer").Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/jobs/job-1/cancel", "viewer").Code)
+	assert.Equal(t, http.StatusOK, request("POST", "/api/validate", "viewer").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/jobs", "wrong").Code)
+
+	// Tokens must be sent with the Bearer scheme
+	for _, header := range []string{"admin", "Basic admin", "bearer admin", "Bearer "} {
+		req := httptest.NewRequest("GET", "/api/jobs", nil)
+		req.Header.Set("Authorization", header)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected %q to be rejected", header)
+	}
+
+	assert.Equal(t, http.StatusCreated, request("POST", "/api/jobs", "admin").Code)
+}
+
+func TestTokensConcurrently(t *testing.T) {
+	restServer := NewServer(newMockCI(), ":0")
+	restServer.RequireToken("admin")
+	restServer.AddReadOnlyToken("viewer")
+	restServer.AddReadOnlyToken("status")
+	require.NoError(t, restServer.SetProjects(ProjectsConfig{Projects: map[string]ProjectConfig{
+		"frontend": {Tokens: []ProjectToken{{Token: "frontend-token"}}},
+	}}))
+
+	// Checking tokens mustn't write to the server's token lists, which go
+	// test -race finds
+	var wg sync.WaitGroup
+	for _, token := range []string{"admin", "viewer", "frontend-token", "wrong"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				req := httptest.NewRequest("GET", "/api/session", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rr := httptest.NewRecorder()
+				restServer.server.Handler.ServeHTTP(rr, req)
+				if token == "wrong" {
+					assert.Equal(t, http.StatusUnauthorized, rr.Code)
+				} else {
+					assert.Equal(t, http.StatusOK, rr.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// logSearcherFunc adapts a function to minici.LogSearcher
+type logSearcherFunc func(minici.LogSearch) minici.LogSearchResult
+
+func (f logSearcherFunc) SearchLogs(search minici.LogSearch) minici.LogSearchResult {
+	return f(search)
+}
+
+func TestLogSearch(t *testing.T) {
+	restServer := NewServer(newMockCI(), ":0")
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/logs/search?q=panic", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	var searched minici.LogSearch
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	restServer.EnableLogSearch(logSearcherFunc(func(search minici.LogSearch) minici.LogSearchResult {
+		searched = search
+		return minici.LogSearchResult{TotalJobs: 2, Jobs: []minici.LogSearchMatch{{
+			Job:        minici.Job{ID: "job-1", Status: minici.JobStatusFailure, RepoURI: "repo", Commit: "main", CreatedAt: created},
+			Lines:      []minici.LogLine{{Index: 4, Text: "> panic: oops"}},
+			TotalLines: 3,
+		}}}
+	}))
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/logs/search", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/logs/search?q=panic%3A&repo=repo&limit=1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, minici.LogSearch{Query: "panic:", RepoURI: "repo", Limit: 1}, searched)
+	var resp LogSearchResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, LogSearchResponse{
+		Query:     "panic:",
+		TotalJobs: 2,
+		Jobs: []LogSearchJobResponse{{
+			ID: "job-1", Status: "failure", RepoURI: "repo", Commit: "main", CreatedAt: created, TotalLines: 3,
+			Lines: []LogLineResponse{{Line: 4, Text: "> panic: oops"}},
+		}},
+	}, resp)
+}
+
+// statsCollectorFunc adapts a function to minici.StatsCollector
+type statsCollectorFunc func(minici.StatsQuery) []minici.RepoStats
+
+func (f statsCollectorFunc) RepoStats(query minici.StatsQuery) []minici.RepoStats {
+	return f(query)
+}
+
+func TestRepoStats(t *testing.T) {
+	restServer := NewServer(newMockCI(), ":0")
+	var queried minici.StatsQuery
+	restServer.EnableRepoStats(statsCollectorFunc(func(query minici.StatsQuery) []minici.RepoStats {
+		queried = query
+		return []minici.RepoStats{{Window: time.Hour, Total: 4, Succeeded: 3, Failed: 1, P50: time.Minute, Max: 2 * time.Minute}}
+	}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+	assert.Equal(t, http.StatusBadRequest, get("/api/repos/repo/stats?windows=1m").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/repos/repo/stats?windows=24h,forever").Code)
+
+	rr := get("/api/repos/" + url.PathEscape("https://github.com/ocuroot/minici") + "/stats?windows=1h,%20168h&project=backend")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, minici.StatsQuery{
+		RepoURI: "https://github.com/ocuroot/minici",
+		Project: "backend",
+		Windows: []time.Duration{time.Hour, 168 * time.Hour},
+	}, queried)
+	var resp RepoStatsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, RepoStatsResponse{
+		RepoURI: "https://github.com/ocuroot/minici",
+		Windows: []StatsWindowResponse{{
+			Window: "1h0m0s", Total: 4, Succeeded: 3, Failed: 1, SuccessRate: 0.75,
+			P50DurationSeconds: 60, MaxDurationSeconds: 120,
+		}},
+	}, resp)
+}
+
+func TestJobPolicyRejections(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	restServer.SetJobPolicy(minici.JobPolicy{Repos: []string{"https://github.com/ocuroot/*"}})
+
+	schedule := func(repo string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(JobRequest{RepoURI: repo, Commit: "main", Command: "make test"})
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs", bytes.NewReader(body)))
+		return rr
+	}
+	rr := schedule("https://github.com/someone/else")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "repo https://github.com/someone/else is not allowed")
+	assert.Empty(t, ci.jobs)
+
+	assert.Equal(t, http.StatusCreated, schedule("https://github.com/ocuroot/minici").Code)
+}
+
+func TestProjects(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+	assert.EqualError(t, restServer.SetProjects(ProjectsConfig{Projects: map[string]ProjectConfig{
+		"frontend": {Tokens: []ProjectToken{{Token: "frontend-token"}}},
+	}}), "project tokens require -token to be set")
+
+	restServer.RequireToken("admin")
+	require.NoError(t, restServer.SetProjects(ProjectsConfig{Projects: map[string]ProjectConfig{
+		"frontend": {
+			JobPolicy: minici.JobPolicy{Repos: []string{"https://github.com/acme/frontend*"}},
+			Tokens:    []ProjectToken{{Token: "frontend-token"}, {Token: "frontend-viewer", ReadOnly: true}},
+		},
+		"backend": {Tokens: []ProjectToken{{Token: "backend-token"}}},
+	}}))
+	ci.createCompletedJob("job-frontend", "https://github.com/acme/frontend", "main", "make")
+	ci.jobs["job-frontend"].Project = "frontend"
+	ci.createCompletedJob("job-backend", "https://github.com/acme/backend", "main", "make")
+	ci.jobs["job-backend"].Project = "backend"
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		content, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(content))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	listJobs := func(path, token string) []string {
+		rr := request("GET", path, token, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp ListJobsResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp.Jobs
+	}
+
+	// Project tokens only see their project's jobs
+	assert.Equal(t, []string{"job-frontend"}, listJobs("/api/jobs", "frontend-token"))
+	assert.Equal(t, []string{"job-frontend"}, listJobs("/api/jobs?project=backend", "frontend-viewer"))
+	assert.Equal(t, []string{"job-backend"}, listJobs("/api/jobs?project=backend", "admin"))
+	assert.Equal(t, http.StatusNotFound, request("GET", "/api/jobs/job-backend", "frontend-token", nil).Code)
+	assert.Equal(t, http.StatusNotFound, request("POST", "/api/jobs/job-backend/cancel", "frontend-token", nil).Code)
+	assert.Equal(t, http.StatusOK, request("GET", "/api/jobs/job-frontend/logs", "frontend-token", nil).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/webhooks", "frontend-token", nil).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/jobs", "frontend-viewer", nil).Code)
+
+	rr := request("GET", "/api/session", "frontend-viewer", nil)
+	var session SessionResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&session))
+	assert.Equal(t, SessionResponse{ReadOnly: true, Project: "frontend"}, session)
+
+	// Project tokens only wait for their project's jobs
+	ci.jobs["job-backend"].Status = minici.JobStatusFailure
+	wait := func(token string) string {
+		var message string
+		require.NoError(t, json.NewDecoder(request("GET", "/api/wait", token, nil).Body).Decode(&message))
+		return message
+	}
+	assert.Equal(t, "all jobs completed successfully", wait("frontend-viewer"))
+	assert.Equal(t, "one or more jobs failed", wait("backend-token"))
+	assert.Equal(t, "one or more jobs failed", wait("admin"))
+	ci.jobs["job-backend"].Status = minici.JobStatusSuccess
+
+	// Jobs scheduled with a project token are in its project and follow its policy
+	job := JobRequest{RepoURI: "https://github.com/acme/frontend", Commit: "main", Command: "make"}
+	require.Equal(t, http.StatusCreated, request("POST", "/api/jobs", "frontend-token", job).Code)
+	assert.Equal(t, "frontend", ci.jobs["job-1"].Project)
+	job.Project = "backend"
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/jobs", "frontend-token", job).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/jobs", "frontend-token", JobRequest{
+		RepoURI: "https://github.com/acme/backend", Commit: "main", Command: "make",
+	}).Code)
+	job.Project = "mobile"
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/api/jobs", "admin", job).Code)
+}
+
+func TestProjectQuotas(t *testing.T) {
+	assert.EqualError(t, NewServer(newMockCI(), ":0").SetProjects(ProjectsConfig{Projects: map[string]ProjectConfig{
+		"frontend": {MaxQueuedJobs: 1},
+	}}), "project job limits aren't supported by this server")
+
+	restServer := NewServer(minici.NewCIServer(minici.WithLocalAgent(false)), ":0")
+	require.NoError(t, restServer.SetProjects(ProjectsConfig{Projects: map[string]ProjectConfig{
+		"frontend": {MaxQueuedJobs: 1},
+	}}))
+	schedule := func() *httptest.ResponseRecorder {
+		content, _ := json.Marshal(JobRequest{RepoURI: "https://github.com/acme/frontend", Commit: "main", Command: "make", Project: "frontend"})
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs", bytes.NewReader(content)))
+		return rr
+	}
+	assert.Equal(t, http.StatusCreated, schedule().Code)
+	rr := schedule()
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+}
+
+func TestNewHandler(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	mux := http.NewServeMux()
+	mux.Handle("/ci/", http.StripPrefix("/ci", NewHandler(ci, WithToken("s3cret"))))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL+"/ci", "").List()
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+
+	client := NewClient(srv.URL+"/ci", "s3cret")
+	resp, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "go test ./..."})
+	require.NoError(t, err)
+	// The agent endpoints are served, as the CI server has an agent pool
+	job, err := client.ClaimJob("laptop", AgentRequest{})
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, resp.ID, job.ID)
+}
+
+func TestScheduleHooks(t *testing.T) {
+	ci := newMockCI()
+	var scheduled []minici.Job
+	handler := NewHandler(ci,
+		WithBeforeSchedule(func(r *http.Request, spec *minici.JobSpec) error {
+			if spec.Commit == "release" {
+				return &APIError{StatusCode: http.StatusTooManyRequests, Message: "Release quota used up"}
+			}
+			if spec.Commit == "blocked" {
+				return fmt.Errorf("commit %s is blocked", spec.Commit)
+			}
+			spec.Labels = map[string]string{"via": r.Header.Get("X-Team")}
+			return nil
+		}),
+		WithScheduled(func(r *http.Request, job minici.Job) {
+			scheduled = append(scheduled, job)
+		}),
+	)
+	schedule := func(commit string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"repo_uri": "https://github.com/ocuroot/minici", "commit": %q, "command": "make"}`, commit)
+		req := httptest.NewRequest("POST", "/api/jobs", strings.NewReader(body))
+		req.Header.Set("X-Team", "core")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := schedule("main")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.Len(t, scheduled, 1)
+	assert.Equal(t, map[string]string{"via": "core"}, scheduled[0].Labels)
+
+	rr = schedule("release")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Release quota used up")
+	rr = schedule("blocked")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "commit blocked is blocked")
+	assert.Len(t, scheduled, 1, "rejected jobs aren't scheduled")
+}
+
+func TestMiddleware(t *testing.T) {
+	var order []string
+	mark := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				if r.Header.Get("X-Api-Key") == "" {
+					http.Error(w, "missing key", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := NewHandler(newMockCI(), WithToken("s3cret"), WithMiddleware(mark("outer"), mark("inner")))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"outer"}, order)
+
+	// Middleware runs before the token is checked
+	order = nil
+	req := httptest.NewRequest("GET", "/api/jobs", nil)
+	req.Header.Set("X-Api-Key", "key")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid or missing token")
+	assert.Equal(t, []string{"outer", "inner"}, order)
+
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestValidate(t *testing.T) {
+	ci := newMockCI()
+	restServer := NewServer(ci, ":0")
+
+	send := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	validate := func(path, contentType, body string) ValidateResponse {
+		rr := send(path, contentType, body)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var response ValidateResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		return response
+	}
+
+	response := validate("/api/validate", "application/yaml", "image: golang\ncache:\n  - key: deps\nimags: []\n")
+	assert.False(t, response.Valid)
+	assert.Equal(t, []ConfigProblem{{Line: 3, Column: 5, Field: "cache[0]", Message: "cache needs at least one path"}}, response.Errors)
+	require.Len(t, response.Warnings, 1)
+	assert.Equal(t, 4, response.Warnings[0].Line)
+
+	response = validate("/api/validate", "application/json", `{"content":"image: golang\n"}`)
+	assert.True(t, response.Valid)
+	assert.Empty(t, response.Errors)
+	assert.Empty(t, response.Warnings)
+
+	// Pipeline programs are run to check the config they generate
+	response = validate("/api/validate?format=starlark", "text/plain", "pipeline(\n  image = ci.nope,\n)\n")
+	assert.Equal(t, []ConfigProblem{{Line: 2, Column: 13, Message: "module has no .nope field or method"}}, response.Errors)
+	response = validate("/api/validate", "application/json", `{"content":"pipeline(setup = [\"\"])","format":"starlark"}`)
+	assert.True(t, response.Valid)
+	assert.Equal(t, []ConfigProblem{{Field: "setup[0]", Message: "setup command is empty"}}, response.Warnings)
+
+	assert.Equal(t, http.StatusBadRequest, send("/api/validate?format=toml", "text/plain", "").Code)
+
+	// Nothing is scheduled
+	assert.Empty(t, ci.ListJobs())
+
+	// Read-only tokens may validate YAML but not run programs
+	restServer.RequireToken("s3cret")
+	restServer.AddReadOnlyToken("viewer")
+	sendAs := func(token, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, http.StatusOK, sendAs("viewer", "/api/validate", "image: golang\n").Code)
+	assert.Equal(t, http.StatusForbidden, sendAs("viewer", "/api/validate?format=starlark", "pipeline()").Code)
+	assert.Equal(t, http.StatusOK, sendAs("s3cret", "/api/validate?format=starlark", "pipeline()").Code)
+}
+
+// resolvingGit is a git client that only resolves refs, and only knows main
+type resolvingGit struct{}
+
+func (resolvingGit) Clone(ctx context.Context, repoURI, dir string) error {
+	return errors.New("not implemented")
+}
+
+func (resolvingGit) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (resolvingGit) ResolveRef(ctx context.Context, repoURI, ref string) (string, error) {
+	if ref != "main" {
+		return "", minici.ErrRefNotFound
+	}
+	return "0123abcd", nil
+}
+
+func TestDryRun(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithGitClient(resolvingGit{}), minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	restServer.SetJobPolicy(minici.JobPolicy{Repos: []string{"https://github.com/ocuroot/*"}})
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	req := JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make test", Labels: map[string]string{"team": "core"}}
+	resp, err := client.DryRun(req, false)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Job.ID)
+	assert.Equal(t, "pending", resp.Job.Status)
+	assert.Equal(t, 1, resp.Job.Number)
+	assert.Equal(t, map[string]string{"team": "core"}, resp.Job.Labels)
+	assert.Empty(t, resp.ResolvedCommit)
+	assert.Len(t, resp.Warnings, 1)
+	assert.Empty(t, ci.ListJobs())
+
+	resp, err = client.DryRun(req, true)
+	require.NoError(t, err)
+	assert.Equal(t, "0123abcd", resp.ResolvedCommit)
+	assert.Empty(t, resp.Warnings)
+
+	var apiErr *APIError
+	req.Commit = "missing"
+	_, err = client.DryRun(req, true)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "no branch or tag found")
+
+	// Policy checks apply to dry runs too
+	req.RepoURI = "https://github.com/someone/else"
+	_, err = client.DryRun(req, false)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs?dry_run=maybe", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// CIs that can't dry run say so rather than scheduling the job
+	mock := newMockCI()
+	body := `{"repo_uri":"a","commit":"b","command":"c"}`
+	rr = httptest.NewRecorder()
+	NewServer(mock, ":0").router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs?dry_run=true", strings.NewReader(body)))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	assert.Empty(t, mock.jobs)
+}
+
+// listingGit is a git client that only lists the commits from a to e,
+// counting how many times it's asked
+type listingGit struct {
+	listed *atomic.Int32
+}
+
+func (listingGit) Clone(ctx context.Context, repoURI, dir string) error {
+	return errors.New("not implemented")
+}
+
+func (listingGit) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (g listingGit) ListCommits(ctx context.Context, repoURI, from, to string) ([]string, error) {
+	g.listed.Add(1)
+	commits := []string{"a", "b", "c", "d", "e"}
+	start, end := slices.Index(commits, from), slices.Index(commits, to)
+	if start < 0 || end < 0 {
+		return nil, minici.ErrRefNotFound
+	}
+	if start > end {
+		return nil, nil
+	}
+	return commits[start+1 : end+1], nil
+}
+
+func TestScheduleRange(t *testing.T) {
+	git := listingGit{listed: &atomic.Int32{}}
+	ci := minici.NewCIServer(minici.WithGitClient(git), minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	req := RangeRequest{
+		JobRequest: JobRequest{RepoURI: "https://github.com/ocuroot/minici", Command: "make test", Labels: map[string]string{"backfill": "true"}},
+		Range:      "a..d",
+	}
+	resp, err := client.SubmitRange(req)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Group)
+	require.Len(t, resp.Jobs, 3)
+	for i, commit := range []string{"b", "c", "d"} {
+		job := ci.JobDetail(minici.JobID(resp.Jobs[i].ID))
+		assert.Equal(t, commit, job.Commit)
+		assert.Equal(t, resp.Group, job.Group)
+		assert.Equal(t, map[string]string{"backfill": "true"}, job.Labels)
+		assert.Equal(t, i+1, job.Number)
+	}
+
+	// A group can be given, such as to add to an earlier one
+	req.Group = resp.Group
+	req.Range = "d..e"
+	more, err := client.SubmitRange(req)
+	require.NoError(t, err)
+	assert.Equal(t, resp.Group, more.Group)
+	require.Len(t, more.Jobs, 1)
+	assert.Equal(t, "e", more.Jobs[0].Commit)
+
+	var apiErr *APIError
+	for _, bad := range []RangeRequest{
+		{JobRequest: JobRequest{RepoURI: req.RepoURI, Command: "make test"}, Range: "d"},
+		{JobRequest: JobRequest{RepoURI: req.RepoURI, Command: "make test"}, Range: "d..a"},
+		{JobRequest: JobRequest{RepoURI: req.RepoURI, Command: "make test"}, Range: "a..missing"},
+		{JobRequest: JobRequest{RepoURI: req.RepoURI, Command: "make test", Commit: "main"}, Range: "a..d"},
+		{JobRequest: JobRequest{RepoURI: req.RepoURI}, Range: "a..d"},
+	} {
+		_, err := client.SubmitRange(bad)
+		require.True(t, errors.As(err, &apiErr), "expected %+v to be rejected", bad)
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	}
+	assert.Len(t, ci.ListJobs(), 4)
+
+	// Ranges the policy rejects fail before their repo is cloned to list them
+	restServer.SetJobPolicy(minici.JobPolicy{Repos: []string{"https://github.com/ocuroot/*"}})
+	listed := git.listed.Load()
+	for _, bad := range []RangeRequest{
+		{JobRequest: JobRequest{RepoURI: "https://github.com/someone/else", Command: "make test"}, Range: "a..d"},
+		{JobRequest: JobRequest{RepoURI: req.RepoURI, Command: "make test", Project: "missing"}, Range: "a..d"},
+	} {
+		_, err := client.SubmitRange(bad)
+		require.True(t, errors.As(err, &apiErr), "expected %+v to be rejected", bad)
+	}
+	assert.Equal(t, listed, git.listed.Load())
+	assert.Len(t, ci.ListJobs(), 4)
+
+	// Servers that can't list commits say so
+	restServer = NewServer(newMockCI(), ":0")
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs/range", strings.NewReader(`{"repo_uri": "repo", "range": "a..d", "command": "make"}`)))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestGroups(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	var ids []string
+	for _, commit := range []string{"a", "b"} {
+		resp, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: commit, Command: "make test", Group: "nightly"})
+		require.NoError(t, err)
+		ids = append(ids, resp.ID)
+	}
+	other, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "c", Command: "make test"})
+	require.NoError(t, err)
+
+	group, err := client.Group("nightly")
+	require.NoError(t, err)
+	assert.Equal(t, "nightly", group.ID)
+	assert.Equal(t, "pending", group.Status)
+	assert.Equal(t, map[string]int{"pending": 2}, group.Counts)
+	require.Len(t, group.Jobs, 2)
+	assert.Equal(t, ids, []string{group.Jobs[0].ID, group.Jobs[1].ID})
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs?group=nightly", nil))
+	var list ListJobsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+	assert.Equal(t, ids, list.Jobs)
+
+	// Waiting for pending jobs times out
+	var apiErr *APIError
+	_, err = client.WaitGroup("nightly", 10*time.Millisecond)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusRequestTimeout, apiErr.StatusCode)
+
+	group, err = client.CancelGroup("nightly")
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", group.Status)
+	assert.Equal(t, map[string]int{"cancelled": 2}, group.Counts)
+	group, err = client.WaitGroup("nightly", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", group.Status)
+	// Jobs outside the group are left alone
+	assert.Equal(t, minici.JobStatusPending, ci.JobDetail(minici.JobID(other.ID)).Status)
+
+	_, err = client.Group("missing")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/api/groups/nightly/wait?timeout=forever"},
+		{"GET", "/api/groups/nightly/stop"},
+		{"GET", "/api/groups/nightly/cancel"},
+	} {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest(request.method, request.path, nil))
+		assert.NotEqual(t, http.StatusOK, rr.Code, request.path)
+	}
+}
+
+func TestWaitMatching(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	payments, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/payments", Commit: "main", Command: "make test", Labels: map[string]string{"team": "payments"}})
+	require.NoError(t, err)
+	other, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/web", Commit: "main", Command: "make test", Labels: map[string]string{"team": "web"}})
+	require.NoError(t, err)
+
+	// Only the matching jobs are waited for, so cancelling them is enough
+	require.NoError(t, ci.CancelJob(minici.JobID(payments.ID)))
+	resp, err := client.WaitMatching(url.Values{"label": {"team=payments"}}, time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, resp.ID)
+	assert.Equal(t, "cancelled", resp.Status)
+	require.Len(t, resp.Jobs, 1)
+	assert.Equal(t, payments.ID, resp.Jobs[0].ID)
+
+	var apiErr *APIError
+	_, err = client.WaitMatching(url.Values{"label": {"team=web"}}, 10*time.Millisecond)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusRequestTimeout, apiErr.StatusCode)
+	_, err = client.WaitMatching(url.Values{"label": {"team=web"}, "since": {time.Now().Add(time.Hour).Format(time.RFC3339)}}, time.Second)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, minici.JobStatusPending, ci.JobDetail(minici.JobID(other.ID)).Status)
+}
+
+func TestHeartbeat(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	restServer.RequireToken("admin")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+
+	submitted, err := NewClient(srv.URL, "admin").Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make deploy"})
+	require.NoError(t, err)
+	token := ci.JobDetail(minici.JobID(submitted.ID)).Token
+	require.True(t, minici.IsJobToken(token))
+
+	job := NewClient(srv.URL, token)
+	percent := 40.0
+	require.NoError(t, job.Heartbeat(submitted.ID, HeartbeatRequest{Percent: &percent, Message: "Waiting for rollout"}))
+	// A bare heartbeat keeps the progress already reported
+	require.NoError(t, job.Heartbeat(submitted.ID, HeartbeatRequest{}))
+
+	status, err := NewClient(srv.URL, "admin").Status(submitted.ID)
+	require.NoError(t, err)
+	require.NotNil(t, status.Progress)
+	require.NotNil(t, status.Progress.Percent)
+	assert.Equal(t, 40.0, *status.Progress.Percent)
+	assert.Equal(t, "Waiting for rollout", status.Progress.Message)
+	assert.False(t, status.Progress.UpdatedAt.IsZero())
+
+	var apiErr *APIError
+	tooMuch := 150.0
+	err = job.Heartbeat(submitted.ID, HeartbeatRequest{Percent: &tooMuch})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	// Job tokens only work for their own job's heartbeats
+	other, err := NewClient(srv.URL, "admin").Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make test"})
+	require.NoError(t, err)
+	err = job.Heartbeat(other.ID, HeartbeatRequest{})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	_, err = job.Status(submitted.ID)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+
+	require.NoError(t, ci.CancelJob(minici.JobID(submitted.ID)))
+	err = job.Heartbeat(submitted.ID, HeartbeatRequest{})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+}
+
+func TestLogTimestamps(t *testing.T) {
+	clock := citest.NewClock(citest.Start)
+	// The agent isn't expected to poll, so it mustn't time out as the clock moves
+	ci := minici.NewCIServer(minici.WithLocalAgent(false), minici.WithClock(clock), minici.WithAgentTimeout(time.Hour))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	submitted, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make test"})
+	require.NoError(t, err)
+	agents := ci.(minici.AgentPool)
+	_, err = agents.ClaimJob(minici.AgentInfo{Name: "builder"})
+	require.NoError(t, err)
+	clock.Advance(90 * time.Second)
+	require.NoError(t, agents.ReportLogs("builder", minici.JobID(submitted.ID), []string{"Compiling"}))
+	clock.Advance(2500 * time.Millisecond)
+	require.NoError(t, agents.FinishJob("builder", minici.JobID(submitted.ID), minici.JobResult{Status: minici.JobStatusSuccess}))
+
+	logs, err := client.Logs(submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Running on agent builder", "Compiling"}, logs)
+
+	logs, err = client.LogsWithOptions(submitted.ID, LogOptions{Timestamps: TimestampsAbsolute})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		citest.Start.UTC().Format("2006-01-02T15:04:05.000Z") + " Running on agent builder",
+		citest.Start.Add(90*time.Second).UTC().Format("2006-01-02T15:04:05.000Z") + " Compiling",
+	}, logs)
+
+	var lines []string
+	status, err := client.FollowLogsWithOptions(submitted.ID, LogOptions{Timestamps: TimestampsElapsed}, func(line string) {
+		lines = append(lines, line)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "success", status)
+	assert.Equal(t, []string{"00:00:00.000 Running on agent builder", "00:01:30.000 Compiling"}, lines)
+
+	var apiErr *APIError
+	_, err = client.LogsWithOptions(submitted.ID, LogOptions{Timestamps: "relative"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestFormatElapsed(t *testing.T) {
+	assert.Equal(t, "00:00:01.500", formatElapsed(1500*time.Millisecond))
+	assert.Equal(t, "26:03:04.000", formatElapsed(26*time.Hour+3*time.Minute+4*time.Second))
+	assert.Equal(t, "-00:00:00.250", formatElapsed(-250*time.Millisecond))
+}
+
+func TestLogANSI(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	submitted, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make test"})
+	require.NoError(t, err)
+	agents := ci.(minici.AgentPool)
+	_, err = agents.ClaimJob(minici.AgentInfo{Name: "builder"})
+	require.NoError(t, err)
+	require.NoError(t, agents.ReportLogs("builder", minici.JobID(submitted.ID), []string{"\x1b[1;31mFAIL\x1b[0m a < b"}))
+	require.NoError(t, agents.FinishJob("builder", minici.JobID(submitted.ID), minici.JobResult{Status: minici.JobStatusFailure}))
+
+	logs, err := client.Logs(submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "\x1b[1;31mFAIL\x1b[0m a < b", logs[1])
+
+	logs, err = client.LogsWithOptions(submitted.ID, LogOptions{ANSI: ANSIStrip})
+	require.NoError(t, err)
+	assert.Equal(t, "FAIL a < b", logs[1])
+
+	var lines []string
+	_, err = client.FollowLogsWithOptions(submitted.ID, LogOptions{ANSI: ANSIHTML}, func(line string) {
+		lines = append(lines, line)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `<span class="ansi-bold ansi-red">FAIL</span> a &lt; b`, lines[1])
+
+	var apiErr *APIError
+	_, err = client.LogsWithOptions(submitted.ID, LogOptions{ANSI: "color"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestANSIToHTML(t *testing.T) {
+	assert.Equal(t, "plain &amp; simple", ansiToHTML("plain & simple"))
+	assert.Equal(t, `<span class="ansi-green">ok</span> done`, ansiToHTML("\x1b[32mok\x1b[39m done"))
+	assert.Equal(t, `<span class="ansi-bright-yellow ansi-bg-blue">warn</span>`, ansiToHTML("\x1b[93;44mwarn"))
+	assert.Equal(t, `<span style="color:#ff8000">orange</span><span style="color:#5f87ff">blue</span>`, ansiToHTML("\x1b[38;2;255;128;0morange\x1b[38;5;69mblue\x1b[m"))
+	// Sequences that don't set colors are dropped
+	assert.Equal(t, "progress", ansiToHTML("\x1b[2Kprogress\x1b]8;;https://example.com\x1b\\"))
+}
+
+func TestStripANSI(t *testing.T) {
+	assert.Equal(t, "plain", stripANSI("plain"))
+	assert.Equal(t, "ok done", stripANSI("\x1b[32mok\x1b[0m \x1b[1Gdone"))
+	assert.Equal(t, "link", stripANSI("\x1b]8;;https://example.com\alink\x1b]8;;\a"))
+}
+
+func TestRequestLogging(t *testing.T) {
+	var logs bytes.Buffer
+	restServer := NewServer(minici.NewCIServer(minici.WithLocalAgent(false)), ":0")
+	restServer.SetLogger(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	srv := httptest.NewServer(restServer)
+	t.Cleanup(srv.Close)
+
+	body := `{"repo_uri": "https://github.com/ocuroot/minici", "commit": "main", "command": "make test"}`
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/jobs", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(RequestIDHeader, "deploy-42")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "deploy-42", resp.Header.Get(RequestIDHeader))
+
+	var entries []map[string]any
+	for line := range strings.Lines(logs.String()) {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Scheduled job", entries[0]["msg"])
+	assert.Equal(t, "deploy-42", entries[0]["request_id"])
+	assert.NotEmpty(t, entries[0]["job_id"])
+	assert.Equal(t, "Handled request", entries[1]["msg"])
+	assert.Equal(t, "deploy-42", entries[1]["request_id"])
+	assert.Equal(t, float64(http.StatusCreated), entries[1]["status"])
+
+	// IDs that aren't safe to log are replaced
+	req, err = http.NewRequest(http.MethodGet, srv.URL+"/api/jobs", nil)
+	require.NoError(t, err)
+	req.Header.Set(RequestIDHeader, "bad id")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Len(t, resp.Header.Get(RequestIDHeader), 32)
+}
+
+func TestAdminHandler(t *testing.T) {
+	restServer := NewServer(minici.NewCIServer(minici.WithLocalAgent(false)), ":0")
+	restServer.RequireToken("secret")
+	reloads := 0
+	restServer.EnableReload(func() error {
+		reloads++
+		return nil
+	})
+	public := httptest.NewServer(restServer)
+	t.Cleanup(public.Close)
+
+	reload := func(srv *httptest.Server, token string) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/admin/reload", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusNoContent, reload(public, "secret"))
+
+	admin := httptest.NewServer(restServer.AdminHandler())
+	t.Cleanup(admin.Close)
+	assert.Equal(t, http.StatusNotFound, reload(public, "secret"))
+	assert.Equal(t, http.StatusUnauthorized, reload(admin, "wrong"))
+	assert.Equal(t, http.StatusNoContent, reload(admin, "secret"))
+	assert.Equal(t, 2, reloads)
+
+	// The rest of the API is only served on the public listener
+	client := NewClient(admin.URL, "secret")
+	_, err := client.List()
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	_, err = NewClient(public.URL, "secret").List()
+	assert.NoError(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7", "unix"})
+	require.NoError(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy.internal"})
+	assert.Error(t, err)
+
+	request := func(remoteAddr string, headers map[string]string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/jobs", nil)
+		r.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		return r
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct", "203.0.113.5:4321", nil, "203.0.113.5"},
+		{"untrusted peer forging headers", "203.0.113.5:4321", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1, 192.0.2.7, 10.9.9.9"}, "198.51.100.1"},
+		{"client forging the start of the chain", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"real IP header", "192.0.2.7:80", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"unix socket proxy", "@", map[string]string{"X-Forwarded-For": "2001:db8::1"}, "2001:db8::1"},
+		{"trusted proxy without headers", "10.1.2.3:80", nil, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, proxies.clientIP(request(tt.remoteAddr, tt.headers)))
+		})
+	}
+
+	// Without trusted proxies, headers are ignored
+	assert.Equal(t, "10.1.2.3", TrustedProxies{}.clientIP(request("10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1"})))
+
+	restServer := NewServer(minici.NewCIServer(minici.WithLocalAgent(false)), ":0")
+	restServer.SetTrustedProxies(proxies)
+	var seen string
+	restServer.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = ClientIP(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	})
+	restServer.ServeHTTP(httptest.NewRecorder(), request("10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1"}))
+	assert.Equal(t, "198.51.100.1", seen)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	clock := citest.NewClock(citest.Start)
+	ci := minici.NewCIServer(minici.WithLocalAgent(false), minici.WithClock(clock), minici.WithAgentTimeout(time.Hour))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	submitted, err := client.Submit(JobRequest{RepoURI: "https://github.com/ocuroot/minici", Commit: "main", Command: "make test"})
+	require.NoError(t, err)
+	agents := ci.(minici.AgentPool)
+	_, err = agents.ClaimJob(minici.AgentInfo{Name: "builder"})
+	require.NoError(t, err)
+
+	get := func(path string, header map[string]string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, path := range []string{"/api/jobs/" + submitted.ID, "/api/jobs/" + submitted.ID + "/logs"} {
+		resp := get(path, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		etag := resp.Header.Get("ETag")
+		require.NotEmpty(t, etag)
+		assert.Equal(t, citest.Start.UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+
+		resp = get(path, map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode, path)
+		assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+		// A running job can change within the second Last-Modified is given to
+		resp = get(path, map[string]string{"If-Modified-Since": citest.Start.UTC().Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+
+	etag := get("/api/jobs/"+submitted.ID+"/logs", nil).Header.Get("ETag")
+	clock.Advance(90 * time.Second)
+	require.NoError(t, agents.ReportLogs("builder", minici.JobID(submitted.ID), []string{"Compiling"}))
+	resp := get("/api/jobs/"+submitted.ID+"/logs", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, citest.Start.Add(90*time.Second).UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+
+	etag = get("/api/jobs/"+submitted.ID, nil).Header.Get("ETag")
+	clock.Advance(time.Second)
+	require.NoError(t, agents.FinishJob("builder", minici.JobID(submitted.ID), minici.JobResult{Status: minici.JobStatusSuccess}))
+	resp = get("/api/jobs/"+submitted.ID, map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	finished := citest.Start.Add(91 * time.Second).UTC().Format(http.TimeFormat)
+	for _, path := range []string{"/api/jobs/" + submitted.ID, "/api/jobs/" + submitted.ID + "/logs"} {
+		resp := get(path, map[string]string{"If-Modified-Since": finished})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode, path)
+		assert.Equal(t, finished, resp.Header.Get("Last-Modified"))
+	}
+}
+
+func TestPullRequestHooks(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	restServer.RequireToken("admin-token")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+
+	post := func(header map[string]string, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/hooks/pull-requests", strings.NewReader(body))
+		require.NoError(t, err)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	github := func(event, body string) *http.Response {
+		return post(map[string]string{
+			"X-GitHub-Event":           event,
+			webhookverify.GitHubHeader: webhookverify.HMAC{Secret: "s3cret", Prefix: "sha256="}.Sign([]byte(body)),
+		}, body)
+	}
+	opened := `{
+		"action": "opened",
+		"pull_request": {"number": 42, "title": "Fix typo", "user": {"login": "octocat"}, "head": {"ref": "fix-typo"}, "base": {"ref": "main"}},
+		"repository": {"clone_url": "https://github.com/ocuroot/minici.git", "html_url": "https://github.com/ocuroot/minici"}
+	}`
+
+	assert.Equal(t, http.StatusNotFound, github("pull_request", opened).StatusCode, "not configured")
+
+	restServer.SetPullRequests(PullRequestConfig{
+		Secret: "s3cret",
+		Repos:  []PullRequestRepo{{Repo: "https://github.com/ocuroot/minici", Command: "make test"}},
+	})
+	resp := github("pull_request", opened)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created JobResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	job := ci.JobDetail(minici.JobID(created.ID))
+	assert.Equal(t, "https://github.com/ocuroot/minici", job.RepoURI)
+	assert.Equal(t, "refs/pull/42/merge", job.Commit)
+	assert.Equal(t, "make test", job.Command)
+	assert.Equal(t, "Pull request #42: Fix typo", job.Name)
+	assert.Equal(t, map[string]string{"pull_request": "42"}, job.Labels)
+	assert.Equal(t, &minici.PullRequest{Number: 42, SourceBranch: "fix-typo", TargetBranch: "main", Author: "octocat"}, job.PullRequest)
+	assert.Equal(t, &PullRequest{Number: 42, SourceBranch: "fix-typo", TargetBranch: "main", Author: "octocat"}, NewJobResponse(job).PullRequest)
+
+	// Events that don't change the code are acknowledged without a job
+	assert.Equal(t, http.StatusNoContent, github("ping", `{"zen": "Keep it logically awesome."}`).StatusCode)
+	assert.Equal(t, http.StatusNoContent, github("pull_request", strings.Replace(opened, "opened", "closed", 1)).StatusCode)
+
+	assert.Equal(t, http.StatusUnauthorized, post(map[string]string{"X-GitHub-Event": "pull_request"}, opened).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, post(map[string]string{
+		"X-GitHub-Event":           "pull_request",
+		webhookverify.GitHubHeader: webhookverify.HMAC{Secret: "wrong", Prefix: "sha256="}.Sign([]byte(opened)),
+	}, opened).StatusCode)
+	assert.Equal(t, http.StatusNotFound, github("pull_request", strings.ReplaceAll(opened, "ocuroot/minici", "ocuroot/other")).StatusCode)
+
+	gitlab := func(body string) *http.Response {
+		return post(map[string]string{"X-Gitlab-Event": "Merge Request Hook", webhookverify.GitLabHeader: "s3cret"}, body)
+	}
+	restServer.SetPullRequests(PullRequestConfig{
+		Secret: "s3cret",
+		Repos:  []PullRequestRepo{{Repo: "git@gitlab.com:ocuroot/minici.git", Command: "make test", RunsOn: []string{"linux"}}},
+	})
+	update := `{
+		"user": {"username": "tanuki"},
+		"project": {"git_http_url": "https://gitlab.com/ocuroot/minici.git", "git_ssh_url": "git@gitlab.com:ocuroot/minici.git"},
+		"object_attributes": {"iid": 7, "title": "Add docs", "action": "update", "source_branch": "docs", "target_branch": "main", "oldrev": "0123abcd"}
+	}`
+	resp = gitlab(update)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	job = ci.JobDetail(minici.JobID(created.ID))
+	assert.Equal(t, "git@gitlab.com:ocuroot/minici.git", job.RepoURI)
+	assert.Equal(t, "refs/merge-requests/7/merge", job.Commit)
+	assert.Equal(t, []string{"linux"}, job.RunsOn)
+	assert.Equal(t, &minici.PullRequest{Number: 7, SourceBranch: "docs", TargetBranch: "main", Author: "tanuki"}, job.PullRequest)
+
+	// Updates without new commits, such as editing the description, aren't built
+	assert.Equal(t, http.StatusNoContent, gitlab(strings.Replace(update, `"0123abcd"`, `""`, 1)).StatusCode)
+}
+
+func TestCommitSummary(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	srv := httptest.NewServer(restServer.server.Handler)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "")
+
+	const repo = "https://github.com/ocuroot/minici"
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	agents := ci.(minici.AgentPool)
+	run := func(name, command string, status minici.JobStatus) string {
+		submitted, err := client.Submit(JobRequest{RepoURI: repo, Commit: commit, Command: command, Name: name})
+		require.NoError(t, err)
+		if status != minici.JobStatusPending {
+			_, err = agents.ClaimJob(minici.AgentInfo{Name: "builder"})
+			require.NoError(t, err)
+		}
+		if status.Done() {
+			require.NoError(t, agents.FinishJob("builder", minici.JobID(submitted.ID), minici.JobResult{Status: status}))
+		}
+		return submitted.ID
+	}
+
+	summary, err := client.CommitSummary(repo, commit)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", summary.Status)
+	assert.False(t, summary.Passed)
+	assert.Empty(t, summary.Checks)
+
+	run("test", "go test ./...", minici.JobStatusFailure)
+	run("", "go vet ./...", minici.JobStatusSuccess)
+	summary, err = client.CommitSummary(repo, commit[:7])
+	require.NoError(t, err)
+	assert.Equal(t, "failure", summary.Status)
+	assert.Equal(t, map[string]int{"failure": 1, "success": 1}, summary.Counts)
+
+	// Re-running the failed check replaces its verdict
+	rerun := run("test", "go test ./...", minici.JobStatusSuccess)
+	summary, err = client.CommitSummary(repo, commit, "test", "go vet ./...")
+	require.NoError(t, err)
+	assert.Equal(t, "success", summary.Status)
+	assert.True(t, summary.Passed)
+	require.Len(t, summary.Checks, 2)
+	assert.Equal(t, CommitCheckResponse{Name: "test", Status: "success", Attempts: 2, Job: summary.Checks[0].Job}, summary.Checks[0])
+	assert.Equal(t, rerun, summary.Checks[0].Job.ID)
+	assert.Equal(t, "go vet ./...", summary.Checks[1].Name)
+
+	summary, err = client.CommitSummary("", commit, "test", "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, "pending", summary.Status)
+	assert.Equal(t, []string{"deploy"}, summary.Missing)
+
+	run("deploy", "make deploy", minici.JobStatusRunning)
+	summary, err = client.CommitSummary("", commit, "test", "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, "pending", summary.Status)
+	assert.Empty(t, summary.Missing)
+
+	summary, err = client.CommitSummary("https://github.com/ocuroot/other", commit)
+	require.NoError(t, err)
+	assert.Empty(t, summary.Checks)
+
+	var apiErr *APIError
+	_, err = client.CommitSummary(repo, "main")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestMaintenance(t *testing.T) {
+	ci := minici.NewCIServer(minici.WithLocalAgent(false))
+	restServer := NewServer(ci, ":0")
+	schedule := minici.MaintenanceSchedule{Windows: []minici.MaintenanceWindow{
+		{Name: "patching", Start: time.Now().UTC().Add(-time.Minute).Format("15:04"), Duration: time.Hour},
+	}}
+	require.NoError(t, restServer.SetMaintenance(schedule))
+	assert.Error(t, NewServer(newMockCI(), ":0").SetMaintenance(schedule), "Servers that can't pause jobs should reject windows")
+
+	submit := func(body string) JobResponse {
+		rr := httptest.NewRecorder()
+		restServer.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/jobs", strings.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var job JobResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&job))
+		return job
+	}
+	held := submit(`{"repo_uri": "https://example.com/repo.git", "commit": "main", "command": "make"}`)
+	urgent := submit(`{"repo_uri": "https://example.com/repo.git", "commit": "main", "command": "make hotfix", "urgent": true}`)
+
+	rr := httptest.NewRecorder()
+	restServer.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stats/queue", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var queue QueueResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&queue))
+	require.NotNil(t, queue.Maintenance)
+	assert.Equal(t, "patching", queue.Maintenance.Name)
+	require.Len(t, queue.Queue, 2)
+	assert.False(t, queue.Queue[0].Urgent)
+	assert.True(t, queue.Queue[1].Urgent)
+
+	job, err := ci.(minici.AgentPool).ClaimJob(minici.AgentInfo{Name: "agent", Capacity: 2})
+	require.NoError(t, err)
+	assert.Equal(t, minici.JobID(urgent.ID), job.ID)
+	assert.True(t, ci.JobDetail(minici.JobID(urgent.ID)).Urgent)
+
+	// Removing the window lets held jobs start
+	require.NoError(t, restServer.SetMaintenance(minici.MaintenanceSchedule{}))
+	job, err = ci.(minici.AgentPool).ClaimJob(minici.AgentInfo{Name: "agent", Capacity: 2})
+	require.NoError(t, err)
+	assert.Equal(t, minici.JobID(held.ID), job.ID)
+}
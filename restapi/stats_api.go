@@ -0,0 +1,74 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// RepoStatsResponse summarizes a repo's builds over each requested window
+type RepoStatsResponse struct {
+	RepoURI string                `json:"repo_uri"`
+	Windows []StatsWindowResponse `json:"windows"`
+}
+
+// StatsWindowResponse summarizes the jobs that succeeded or failed within a window
+type StatsWindowResponse struct {
+	Window      string  `json:"window"`
+	Total       int     `json:"total"`
+	Succeeded   int     `json:"succeeded"`
+	Failed      int     `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+
+	P50DurationSeconds float64 `json:"p50_duration_seconds"`
+	P90DurationSeconds float64 `json:"p90_duration_seconds"`
+	P95DurationSeconds float64 `json:"p95_duration_seconds"`
+	P99DurationSeconds float64 `json:"p99_duration_seconds"`
+	MaxDurationSeconds float64 `json:"max_duration_seconds"`
+}
+
+// EnableRepoStats registers the endpoint for a repo's build statistics
+func (s *Server) EnableRepoStats(collector minici.StatsCollector) {
+	s.repoStats = collector
+}
+
+// handleRepoStats processes requests for a repo's build counts, success rate
+// and duration percentiles. Windows are a comma separated list of durations,
+// such as 24h,168h, defaulting to the last day, week and 30 days.
+func (s *Server) handleRepoStats(w http.ResponseWriter, r *http.Request, repo string) {
+	if s.repoStats == nil {
+		s.writeError(w, "Repo stats are not enabled", http.StatusNotFound)
+		return
+	}
+	var windows []time.Duration
+	if value := r.URL.Query().Get("windows"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			window, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil || window < time.Hour || window > minici.StatsRetention {
+				s.writeError(w, fmt.Sprintf("windows must be durations between 1h and %v", minici.StatsRetention), http.StatusBadRequest)
+				return
+			}
+			windows = append(windows, window)
+		}
+	}
+
+	resp := RepoStatsResponse{RepoURI: repo, Windows: []StatsWindowResponse{}}
+	for _, stats := range s.repoStats.RepoStats(minici.StatsQuery{RepoURI: repo, Project: projectFilter(r), Windows: windows}) {
+		resp.Windows = append(resp.Windows, StatsWindowResponse{
+			Window:             stats.Window.String(),
+			Total:              stats.Total,
+			Succeeded:          stats.Succeeded,
+			Failed:             stats.Failed,
+			SuccessRate:        stats.SuccessRate(),
+			P50DurationSeconds: stats.P50.Seconds(),
+			P90DurationSeconds: stats.P90.Seconds(),
+			P95DurationSeconds: stats.P95.Seconds(),
+			P99DurationSeconds: stats.P99.Seconds(),
+			MaxDurationSeconds: stats.Max.Seconds(),
+		})
+	}
+	s.writeJSON(w, resp, http.StatusOK)
+}
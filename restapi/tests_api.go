@@ -0,0 +1,170 @@
+package restapi
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/minici"
+)
+
+// defaultFlakyBuilds is how many of a repo's recent jobs are checked for flaky tests
+const defaultFlakyBuilds = 20
+
+// TestResultResponse describes one test from a job's test reports
+type TestResultResponse struct {
+	Suite           string  `json:"suite,omitempty"`
+	Name            string  `json:"name"`
+	Status          string  `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Message         string  `json:"message,omitempty"`
+}
+
+// TestSummaryResponse counts a job's test results and lists its failures
+type TestSummaryResponse struct {
+	Passed   int                  `json:"passed"`
+	Failed   int                  `json:"failed"`
+	Skipped  int                  `json:"skipped"`
+	Failures []TestResultResponse `json:"failures,omitempty"`
+}
+
+// FlakyTestResponse describes a test whose outcome has alternated in recent jobs
+type FlakyTestResponse struct {
+	Suite      string `json:"suite,omitempty"`
+	Name       string `json:"name"`
+	Passed     int    `json:"passed"`
+	Failed     int    `json:"failed"`
+	Flips      int    `json:"flips"`
+	LastFailed string `json:"last_failed"`
+}
+
+// FlakyTestsResponse represents the response for listing a repo's flaky tests
+type FlakyTestsResponse struct {
+	RepoURI string              `json:"repo_uri"`
+	Builds  int                 `json:"builds"`
+	Tests   []FlakyTestResponse `json:"tests"`
+}
+
+func testResultResponse(result minici.TestResult) TestResultResponse {
+	return TestResultResponse{
+		Suite:           result.Suite,
+		Name:            result.Name,
+		Status:          string(result.Status),
+		DurationSeconds: result.Duration.Seconds(),
+		Message:         result.Message,
+	}
+}
+
+func testResultsToResponse(results []minici.TestResult) []TestResultResponse {
+	var resp []TestResultResponse
+	for _, result := range results {
+		resp = append(resp, testResultResponse(result))
+	}
+	return resp
+}
+
+func testResultsFromRequest(results []TestResultResponse) []minici.TestResult {
+	var tests []minici.TestResult
+	for _, result := range results {
+		tests = append(tests, minici.TestResult{
+			Suite:    result.Suite,
+			Name:     result.Name,
+			Status:   minici.TestStatus(result.Status),
+			Duration: time.Duration(result.DurationSeconds * float64(time.Second)),
+			Message:  result.Message,
+		})
+	}
+	return tests
+}
+
+// testSummaryResponse summarizes a job's tests, or returns nil if it had no test reports
+func testSummaryResponse(results []minici.TestResult) *TestSummaryResponse {
+	if len(results) == 0 {
+		return nil
+	}
+	summary := minici.SummarizeTests(results)
+	return &TestSummaryResponse{
+		Passed:   summary.Passed,
+		Failed:   summary.Failed,
+		Skipped:  summary.Skipped,
+		Failures: testResultsToResponse(summary.Failures),
+	}
+}
+
+// handleRepos handles the per repo endpoints under /api/repos/<repo>/, where
+// the repo is a path escaped repository URI
+func (s *Server) handleRepos(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	escaped, action, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/api/repos/"), "/")
+	repo, err := url.PathUnescape(escaped)
+	if !ok || err != nil || repo == "" {
+		s.writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	switch action {
+	case "flaky-tests":
+		s.handleFlakyTests(w, r, repo)
+	case "coverage.svg":
+		s.handleCoverageBadge(w, r, repo)
+	case "stats":
+		s.handleRepoStats(w, r, repo)
+	default:
+		if number, ok := strings.CutPrefix(action, "builds/"); ok {
+			s.handleBuild(w, r, repo, number)
+			return
+		}
+		s.writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleBuild returns the repo's job with a build number, such as 142 for
+// "minici #142"
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request, repo, number string) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		s.writeError(w, "Build number must be a number", http.StatusBadRequest)
+		return
+	}
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{RepoURI: repo, Project: tokenProject(r), Number: n, Limit: 1})
+	if err != nil || len(jobs) == 0 {
+		s.writeError(w, "Build not found", http.StatusNotFound)
+		return
+	}
+	s.handleJobStatus(w, r, string(jobs[0].ID))
+}
+
+// handleFlakyTests lists the tests whose outcome has alternated in the repo's recent jobs
+func (s *Server) handleFlakyTests(w http.ResponseWriter, r *http.Request, repo string) {
+	builds := defaultFlakyBuilds
+	if value := r.URL.Query().Get("builds"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 2 || parsed > 500 {
+			s.writeError(w, "builds must be a number between 2 and 500", http.StatusBadRequest)
+			return
+		}
+		builds = parsed
+	}
+
+	jobs, _, err := s.ci.QueryJobs(minici.JobFilter{RepoURI: repo, Project: projectFilter(r)})
+	if err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := FlakyTestsResponse{RepoURI: repo, Builds: builds, Tests: []FlakyTestResponse{}}
+	for _, test := range minici.FlakyTests(jobs, repo, builds) {
+		resp.Tests = append(resp.Tests, FlakyTestResponse{
+			Suite:      test.Suite,
+			Name:       test.Name,
+			Passed:     test.Passed,
+			Failed:     test.Failed,
+			Flips:      test.Flips,
+			LastFailed: string(test.LastFailed),
+		})
+	}
+	s.writeJSON(w, resp, http.StatusOK)
+}
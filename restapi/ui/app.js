@@ -0,0 +1,590 @@
+// minici dashboard. Talks to the REST API served from the same origin.
+"use strict";
+
+const app = document.getElementById("app");
+let current = null; // AbortController for the active view
+
+const doneStatuses = ["success", "failure", "cancelled", "interrupted"];
+
+// api calls an endpoint, asking for a token if the server requires one
+async function api(path, options = {}, retry = true) {
+  const headers = Object.assign({}, options.headers);
+  const token = localStorage.getItem("minici-token");
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  const resp = await fetch(path, Object.assign({}, options, { headers }));
+  if (resp.status === 401 && retry) {
+    const entered = prompt("This server requires a token");
+    if (entered) {
+      localStorage.setItem("minici-token", entered);
+      return api(path, options, false);
+    }
+  }
+  return resp;
+}
+
+async function apiJSON(path, options) {
+  const resp = await api(path, options);
+  const body = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(body.error || resp.statusText);
+  }
+  return body;
+}
+
+function render(templateID) {
+  const view = document.getElementById(templateID).content.cloneNode(true);
+  app.replaceChildren(view);
+  return app;
+}
+
+function statusBadge(el, status) {
+  el.textContent = status || "unknown";
+  el.className = "status status-" + (status || "unknown");
+}
+
+// route renders the view for the current location hash
+function route() {
+  if (current) {
+    current.abort();
+  }
+  current = new AbortController();
+
+  const match = location.hash.match(/^#\/jobs\/([^/]+)(?:\/L(\d+))?$/);
+  if (location.hash === "#/trends") {
+    showTrends(current.signal);
+  } else if (location.hash === "#/queue") {
+    showQueue(current.signal);
+  } else if (match) {
+    showJob(decodeURIComponent(match[1]), match[2] ? Number(match[2]) : null, current.signal);
+  } else {
+    showJobs(current.signal);
+  }
+}
+
+async function showJobs(signal) {
+  const view = render("jobs-view");
+  const tbody = view.querySelector("tbody");
+  const empty = view.querySelector(".empty");
+
+  view.querySelector("#schedule").addEventListener("submit", async (event) => {
+    event.preventDefault();
+    const form = new FormData(event.target);
+    try {
+      const job = await apiJSON("/api/jobs", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(Object.fromEntries(form)),
+      });
+      location.hash = "#/jobs/" + encodeURIComponent(job.id);
+    } catch (err) {
+      alert("Failed to schedule job: " + err.message);
+    }
+  });
+
+  while (!signal.aborted) {
+    try {
+      const list = await apiJSON("/api/jobs");
+      const ids = (list.jobs || []).slice().sort().reverse();
+      const jobs = await Promise.all(ids.map((id) => apiJSON("/api/jobs/" + encodeURIComponent(id))));
+      if (signal.aborted) {
+        return;
+      }
+
+      tbody.replaceChildren(...jobs.map((job) => {
+        const row = document.createElement("tr");
+        const cells = [job.id, job.status, job.repo_uri, job.commit, job.command].map(() => document.createElement("td"));
+        const link = document.createElement("a");
+        link.href = "#/jobs/" + encodeURIComponent(job.id);
+        link.textContent = job.id;
+        cells[0].append(link);
+        const badge = document.createElement("span");
+        statusBadge(badge, job.status);
+        cells[1].append(badge);
+        cells[2].textContent = job.repo_uri;
+        cells[3].textContent = job.commit;
+        cells[4].textContent = job.command;
+        cells[3].className = cells[4].className = "optional";
+        row.append(...cells);
+        return row;
+      }));
+      empty.hidden = jobs.length > 0;
+    } catch (err) {
+      console.error(err);
+    }
+    await sleep(3000, signal);
+  }
+}
+
+async function showJob(id, line, signal) {
+  const view = render("job-view");
+  const status = view.querySelector(".status");
+  const logs = view.querySelector(".logs");
+  const search = view.querySelector(".search");
+  const matches = view.querySelector(".matches");
+  const pause = view.querySelector(".pause");
+  const cancel = view.querySelector(".cancel");
+  const pin = view.querySelector(".pin");
+  const firstError = view.querySelector(".first-error");
+  view.querySelector(".job-id").textContent = id;
+
+  let paused = line !== null;
+  let query = "";
+  let matchIndex = -1;
+  // annotations are keyed by line number, which counts from 1
+  let annotations = new Map();
+
+  const updateStatus = (job) => {
+    statusBadge(status, job.status);
+    cancel.hidden = doneStatuses.includes(job.status);
+    pin.dataset.pinned = job.pinned ? "true" : "";
+    pin.textContent = job.pinned ? "Unpin job" : "Pin job";
+    pin.title = job.pinned ? "Let retention remove this job's artifacts" : "Keep this job's artifacts however old they are";
+  };
+
+  const markProblem = (li, n) => {
+    const annotation = annotations.get(n);
+    li.classList.toggle("problem-error", annotation?.severity === "error");
+    li.classList.toggle("problem-warning", annotation?.severity === "warning");
+    li.title = annotation ? annotation.message : "";
+  };
+  const updateAnnotations = (job) => {
+    annotations = new Map((job.annotations || []).map((a) => [a.line + 1, a]));
+    Array.from(logs.children).forEach((li, i) => markProblem(li, i + 1));
+    const first = (job.annotations || []).find((a) => a.severity === "error");
+    firstError.hidden = !first;
+    if (first) {
+      firstError.href = "#/jobs/" + encodeURIComponent(id) + "/L" + (first.line + 1);
+      firstError.title = first.message;
+    }
+  };
+
+  try {
+    const job = await apiJSON("/api/jobs/" + encodeURIComponent(id));
+    updateStatus(job);
+    view.querySelector(".repo").textContent = job.repo_uri;
+    view.querySelector(".commit").textContent = job.commit;
+    view.querySelector(".command").textContent = job.command;
+    if (job.agent) {
+      view.querySelectorAll(".agent").forEach((el) => { el.hidden = false; });
+      view.querySelector("dd.agent").textContent = job.agent;
+    }
+    updateAnnotations(job);
+  } catch (err) {
+    statusBadge(status, "unknown");
+  }
+
+  pause.addEventListener("click", () => {
+    paused = !paused;
+    pause.textContent = paused ? "Resume" : "Pause";
+    if (!paused) {
+      scrollToBottom();
+    }
+  });
+  pause.textContent = paused ? "Resume" : "Pause";
+
+  cancel.addEventListener("click", async () => {
+    try {
+      updateStatus(await apiJSON("/api/jobs/" + encodeURIComponent(id) + "/cancel", { method: "POST" }));
+    } catch (err) {
+      alert("Failed to cancel job: " + err.message);
+    }
+  });
+
+  pin.addEventListener("click", async () => {
+    const method = pin.dataset.pinned ? "DELETE" : "PUT";
+    try {
+      updateStatus(await apiJSON("/api/jobs/" + encodeURIComponent(id) + "/pin", { method }));
+    } catch (err) {
+      alert("Failed to pin job: " + err.message);
+    }
+  });
+
+  // Lines between group markers can be collapsed. A group folds once it ends,
+  // unless it holds the linked line.
+  const openGroups = [];
+  const collapsed = new Set();
+  const updateHidden = (li) => {
+    li.hidden = li.dataset.groups.split(" ").some((group) => collapsed.has(group));
+  };
+  const toggleGroup = (header, collapse) => {
+    header.classList.toggle("collapsed", collapse);
+    if (collapse) {
+      collapsed.add(header.id);
+    } else {
+      collapsed.delete(header.id);
+    }
+    logs.querySelectorAll('li[data-groups~="' + header.id + '"]').forEach(updateHidden);
+  };
+  const reveal = (li) => {
+    for (const group of (li.dataset.groups || "").split(" ")) {
+      if (collapsed.has(group)) {
+        toggleGroup(logs.querySelector("#" + group), false);
+      }
+    }
+  };
+
+  const matchesQuery = (li) => query !== "" && li.dataset.text.toLowerCase().includes(query);
+  const updateMatches = () => {
+    const found = logs.querySelectorAll("li.match");
+    matches.textContent = query === "" ? "" : found.length + " matches";
+    return found;
+  };
+
+  search.addEventListener("input", () => {
+    query = search.value.toLowerCase();
+    matchIndex = -1;
+    for (const li of logs.children) {
+      li.classList.toggle("match", matchesQuery(li));
+    }
+    updateMatches();
+  });
+  // Enter jumps to the next match, shift+enter to the previous one
+  search.addEventListener("keydown", (event) => {
+    if (event.key !== "Enter") {
+      return;
+    }
+    const found = updateMatches();
+    if (found.length === 0) {
+      return;
+    }
+    matchIndex = (matchIndex + (event.shiftKey ? -1 : 1) + found.length) % found.length;
+    logs.querySelectorAll("li.current").forEach((li) => li.classList.remove("current"));
+    found[matchIndex].classList.add("current");
+    reveal(found[matchIndex]);
+    found[matchIndex].scrollIntoView({ block: "center" });
+    paused = true;
+    pause.textContent = "Resume";
+  });
+
+  // Lines are streamed as HTML, escaped by the server with their colors as
+  // spans, so searches and group markers use the text without them
+  const appendLine = (html) => {
+    const n = logs.children.length + 1;
+    const li = document.createElement("li");
+    li.id = "L" + n;
+    const anchor = document.createElement("a");
+    anchor.className = "line-number";
+    anchor.href = "#/jobs/" + encodeURIComponent(id) + "/L" + n;
+    anchor.textContent = n;
+    const content = document.createElement("span");
+    content.innerHTML = html;
+    const text = content.textContent;
+    li.dataset.text = text;
+    li.append(anchor, content);
+    li.classList.toggle("match", matchesQuery(li));
+    li.classList.toggle("anchored", n === line);
+    markProblem(li, n);
+    if (openGroups.length > 0) {
+      li.dataset.groups = openGroups.map((group) => group.id).join(" ");
+      updateHidden(li);
+    }
+    logs.append(li);
+
+    const start = text.match(groupStart);
+    if (start) {
+      li.classList.add("group");
+      content.textContent = start[1].trim() || "Group";
+      content.addEventListener("click", () => toggleGroup(li, !li.classList.contains("collapsed")));
+      openGroups.push(li);
+    } else if (groupEnd.test(text) && openGroups.length > 0) {
+      li.classList.add("group-end");
+      const header = openGroups.pop();
+      const startLine = Number(header.id.slice(1));
+      if (line === null || line < startLine || line > n) {
+        toggleGroup(header, true);
+      }
+    }
+
+    if (n === line) {
+      li.scrollIntoView({ block: "center" });
+    } else if (!paused) {
+      scrollToBottom();
+    }
+  };
+
+  streamLogs(id, appendLine, async (job) => {
+    updateStatus(job);
+    updateMatches();
+    // The done event only has the status, so fetch the job for its annotations
+    try {
+      updateAnnotations(await apiJSON("/api/jobs/" + encodeURIComponent(id)));
+    } catch (err) {
+      console.error(err);
+    }
+  }, () => logs.children.length, signal);
+}
+
+async function showQueue(signal) {
+  const view = render("queue-view");
+  const workers = view.querySelector(".workers tbody");
+  const queue = view.querySelector(".queue tbody");
+  const empty = view.querySelector(".empty");
+  const jobLink = (id) => {
+    const link = document.createElement("a");
+    link.href = "#/jobs/" + encodeURIComponent(id);
+    link.textContent = id;
+    return link;
+  };
+  const cell = (...children) => {
+    const td = document.createElement("td");
+    td.append(...children);
+    return td;
+  };
+
+  while (!signal.aborted) {
+    try {
+      const stats = await apiJSON("/api/stats/queue");
+      if (signal.aborted) {
+        return;
+      }
+
+      view.querySelector(".pending").textContent = stats.pending;
+      view.querySelector(".running").textContent = stats.running;
+      view.querySelector(".capacity").textContent = stats.capacity || "unlimited";
+      view.querySelector(".wait").textContent = formatSeconds(stats.estimated_wait_seconds);
+      view.querySelector(".average").textContent = stats.average_duration_seconds ? formatSeconds(stats.average_duration_seconds) : "-";
+      const maintenance = view.querySelector(".maintenance");
+      maintenance.hidden = !stats.maintenance;
+      if (stats.maintenance) {
+        const name = stats.maintenance.name ? " " + stats.maintenance.name : "";
+        maintenance.textContent = "Maintenance window" + name + " in progress until " +
+          new Date(stats.maintenance.ends_at).toLocaleString() + ". Only urgent jobs will start.";
+      }
+
+      workers.replaceChildren(...stats.workers.map((worker) => {
+        const row = document.createElement("tr");
+        const meter = document.createElement("meter");
+        meter.max = worker.capacity || Math.max(worker.running.length, 1);
+        meter.value = worker.running.length;
+        const label = worker.running.length + (worker.capacity ? " / " + worker.capacity : "");
+        const links = worker.running.flatMap((id, i) => i === 0 ? [jobLink(id)] : [", ", jobLink(id)]);
+        const labels = cell((worker.labels || []).join(", "));
+        labels.classList.add("optional");
+        row.append(cell(worker.name), labels, cell(meter, " " + label), cell(...links));
+        return row;
+      }));
+
+      queue.replaceChildren(...stats.queue.map((job) => {
+        const row = document.createElement("tr");
+        const repo = cell(job.repo_uri);
+        const command = cell(job.command);
+        repo.className = command.className = "optional";
+        row.append(
+          cell(jobLink(job.id)),
+          repo,
+          command,
+          cell(formatSeconds(job.queued_seconds)),
+          cell(formatSeconds(job.estimated_wait_seconds)),
+        );
+        return row;
+      }));
+      empty.hidden = stats.queue.length > 0;
+    } catch (err) {
+      console.error(err);
+    }
+    await sleep(2000, signal);
+  }
+}
+
+async function showTrends(signal) {
+  const view = render("trends-view");
+  const container = view.querySelector(".trends");
+  const empty = view.querySelector(".empty");
+  const repoFilter = view.querySelector(".repo-filter");
+  const days = view.querySelector(".days");
+
+  const load = async () => {
+    const params = new URLSearchParams({ days: days.value });
+    if (repoFilter.value) {
+      params.set("repo", repoFilter.value);
+    }
+    let trends;
+    try {
+      trends = await apiJSON("/api/stats/trends?" + params);
+    } catch (err) {
+      console.error(err);
+      return;
+    }
+    if (signal.aborted) {
+      return;
+    }
+
+    // Keep every repo seen in the filter so it can be switched back
+    for (const repo of trends.repos) {
+      if (![...repoFilter.options].some((option) => option.value === repo.repo_uri)) {
+        repoFilter.append(new Option(repo.repo_uri, repo.repo_uri));
+      }
+    }
+
+    container.replaceChildren(...trends.repos.map(trendCard));
+    empty.hidden = trends.repos.length > 0;
+  };
+
+  repoFilter.addEventListener("change", load);
+  days.addEventListener("change", load);
+  while (!signal.aborted) {
+    await load();
+    await sleep(30000, signal);
+  }
+}
+
+// trendCard charts a repo's success rate and duration per day
+function trendCard(repo) {
+  const card = document.getElementById("trend-card").content.cloneNode(true);
+  card.querySelector(".trend-repo").textContent = repo.repo_uri;
+
+  const total = repo.buckets.reduce((sum, b) => sum + b.total, 0);
+  const succeeded = repo.buckets.reduce((sum, b) => sum + b.succeeded, 0);
+  const duration = repo.buckets.reduce((sum, b) => sum + b.average_duration_seconds * b.total, 0);
+  card.querySelector(".trend-summary").textContent = total + " builds, " +
+    Math.round(100 * succeeded / Math.max(total, 1)) + "% succeeded, " +
+    formatSeconds(duration / Math.max(total, 1)) + " average duration";
+
+  const width = 600;
+  const height = 120;
+  const step = width / repo.buckets.length;
+  const title = (bucket, text) => new Date(bucket.start).toLocaleDateString() + ": " + text;
+
+  const success = card.querySelector(".success-chart");
+  repo.buckets.forEach((bucket, i) => {
+    if (bucket.total === 0) {
+      return;
+    }
+    const barHeight = Math.max(bucket.success_rate * height, 2);
+    const bar = svg("rect", {
+      x: i * step + 1, y: height - barHeight, width: Math.max(step - 2, 1), height: barHeight,
+      class: bucket.success_rate === 1 ? "bar-success" : "bar-failure",
+    });
+    bar.append(svg("title", {}, title(bucket, bucket.succeeded + "/" + bucket.total + " succeeded")));
+    success.append(bar);
+  });
+
+  const durations = card.querySelector(".duration-chart");
+  const longest = Math.max(...repo.buckets.map((b) => b.max_duration_seconds), 1);
+  const y = (seconds) => height - (seconds / longest) * (height - 4) - 2;
+  const points = [];
+  repo.buckets.forEach((bucket, i) => {
+    if (bucket.total === 0) {
+      return;
+    }
+    const x = i * step + step / 2;
+    points.push(x + "," + y(bucket.average_duration_seconds));
+    const max = svg("line", { x1: x, x2: x, y1: y(bucket.max_duration_seconds), y2: y(0), class: "duration-range" });
+    max.append(svg("title", {}, title(bucket, "max " + formatSeconds(bucket.max_duration_seconds))));
+    durations.append(max);
+    const dot = svg("circle", { cx: x, cy: y(bucket.average_duration_seconds), r: 3, class: "duration-point" });
+    dot.append(svg("title", {}, title(bucket, "average " + formatSeconds(bucket.average_duration_seconds))));
+    durations.append(dot);
+  });
+  durations.prepend(svg("polyline", { points: points.join(" "), class: "duration-line" }));
+
+  return card;
+}
+
+function svg(name, attributes, text) {
+  const el = document.createElementNS("http://www.w3.org/2000/svg", name);
+  for (const [key, value] of Object.entries(attributes)) {
+    el.setAttribute(key, value);
+  }
+  if (text) {
+    el.textContent = text;
+  }
+  return el;
+}
+
+function formatSeconds(seconds) {
+  if (seconds < 60) {
+    return seconds.toFixed(1) + "s";
+  }
+  const minutes = Math.floor(seconds / 60);
+  return minutes + "m " + Math.round(seconds % 60) + "s";
+}
+
+// groupStart and groupEnd match the markers minici uses to find log sections
+const groupStart = /^(?:> )?(?:::group::|##\[group\])(.*)$/;
+const groupEnd = /^(?:> )?\s*(?:::endgroup::|##\[endgroup\])\s*$/;
+
+// streamLogs follows the SSE log stream, resuming from the last line received
+// if the connection drops. fetch is used rather than EventSource so the token
+// can be sent in a header.
+async function streamLogs(id, onLine, onDone, received, signal) {
+  let failures = 0;
+  while (!signal.aborted) {
+    try {
+      const resp = await api("/api/jobs/" + encodeURIComponent(id) + "/logs/stream?ansi=html&from=" + received(), {
+        headers: { "Accept": "text/event-stream" },
+        signal,
+      });
+      if (!resp.ok) {
+        throw new Error(resp.statusText);
+      }
+
+      const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = "";
+      let event = "";
+      let data = [];
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+          break;
+        }
+        failures = 0;
+        buffer += value;
+        const lines = buffer.split("\n");
+        buffer = lines.pop();
+        for (const line of lines) {
+          if (line === "") {
+            if (event === "log") {
+              onLine(data.join("\n"));
+            } else if (event === "done") {
+              onDone(JSON.parse(data.join("\n")));
+              return;
+            }
+            event = "";
+            data = [];
+          } else if (line.startsWith("event:")) {
+            event = line.slice(6).trim();
+          } else if (line.startsWith("data:")) {
+            data.push(line.slice(5).replace(/^ /, ""));
+          }
+        }
+      }
+    } catch (err) {
+      if (signal.aborted) {
+        return;
+      }
+      console.error(err);
+    }
+    failures++;
+    await sleep(Math.min(failures, 10) * 1000, signal);
+  }
+}
+
+function scrollToBottom() {
+  window.scrollTo(0, document.body.scrollHeight);
+}
+
+function sleep(ms, signal) {
+  return new Promise((resolve) => {
+    const timer = setTimeout(resolve, ms);
+    signal.addEventListener("abort", () => {
+      clearTimeout(timer);
+      resolve();
+    }, { once: true });
+  });
+}
+
+// loadSession hides controls that modify jobs when using a read-only token
+async function loadSession() {
+  try {
+    const session = await apiJSON("/api/session");
+    document.body.classList.toggle("read-only", session.read_only);
+  } catch (err) {
+    console.error(err);
+  }
+}
+
+window.addEventListener("hashchange", route);
+loadSession().then(route);
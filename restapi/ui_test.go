@@ -0,0 +1,33 @@
+package restapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	restServer := NewServer(newMockCI(), ":0")
+	restServer.RequireToken("secret")
+
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		rr := httptest.NewRecorder()
+		restServer.server.Handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+
+	rr := httptest.NewRecorder()
+	restServer.server.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, rr.Body.String(), "<title>minici</title>")
+
+	// The API still requires the token
+	rr = httptest.NewRecorder()
+	restServer.server.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/jobs", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	restServer.server.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/missing.js", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
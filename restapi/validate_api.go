@@ -0,0 +1,103 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/ocuroot/minici"
+)
+
+// maxValidateBody limits the size of configs sent to be validated
+const maxValidateBody = 1 << 20
+
+// Formats a repo config can be validated in
+const (
+	// FormatYAML is a .minici.yml config, the default
+	FormatYAML = "yaml"
+	// FormatStarlark is a .minici.star pipeline program
+	FormatStarlark = "starlark"
+)
+
+// ValidateRequest is the JSON body for validating a repo config. The config
+// may also be sent as the raw body, with its format in the format query
+// parameter.
+type ValidateRequest struct {
+	Content string `json:"content"`
+	// Format is FormatYAML or FormatStarlark, defaulting to FormatYAML
+	Format string `json:"format,omitempty"`
+}
+
+// ValidateResponse lists the problems found in a repo config. The config is
+// valid if it has no errors, even if it has warnings.
+type ValidateResponse struct {
+	Valid    bool            `json:"valid"`
+	Errors   []ConfigProblem `json:"errors"`
+	Warnings []ConfigProblem `json:"warnings"`
+}
+
+// ConfigProblem is a mistake found in a repo config
+type ConfigProblem struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// NewValidateResponse sorts problems into errors and warnings
+func NewValidateResponse(problems []minici.ConfigProblem) ValidateResponse {
+	resp := ValidateResponse{Errors: []ConfigProblem{}, Warnings: []ConfigProblem{}}
+	for _, problem := range problems {
+		converted := ConfigProblem{Line: problem.Line, Column: problem.Column, Field: problem.Field, Message: problem.Message}
+		if problem.Severity == minici.SeverityError {
+			resp.Errors = append(resp.Errors, converted)
+		} else {
+			resp.Warnings = append(resp.Warnings, converted)
+		}
+	}
+	resp.Valid = len(resp.Errors) == 0
+	return resp
+}
+
+// handleValidate checks a repo config without scheduling anything
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxValidateBody+1))
+	if err != nil {
+		s.writeError(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxValidateBody {
+		s.writeError(w, "Config is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	content := body
+	format := r.URL.Query().Get("format")
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req ValidateRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		content = []byte(req.Content)
+		if req.Format != "" {
+			format = req.Format
+		}
+	}
+
+	switch format {
+	case "", FormatYAML:
+		s.writeJSON(w, NewValidateResponse(minici.ValidateRepoConfig(content)), http.StatusOK)
+	case FormatStarlark:
+		// Running a program takes far more work than parsing YAML, so only
+		// tokens that could schedule a job with it may do so
+		if isReadOnly(r) {
+			s.writeError(w, "Token is read-only", http.StatusForbidden)
+			return
+		}
+		s.writeJSON(w, NewValidateResponse(minici.ValidatePipeline(content)), http.StatusOK)
+	default:
+		s.writeError(w, "Unknown format "+format+", expected yaml or starlark", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,183 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRetentionInterval is how often artifacts are pruned if the retention
+// config doesn't say
+const DefaultRetentionInterval = time.Hour
+
+// RetentionPolicy limits which jobs' artifacts are kept. Zero values are unlimited.
+type RetentionPolicy struct {
+	// MaxAge removes a job's artifacts once they are older than this
+	MaxAge time.Duration `yaml:"max_age"`
+	// KeepLast keeps only the artifacts of the most recent jobs for each repo
+	KeepLast int `yaml:"keep_last"`
+	// MaxSize removes the oldest jobs' artifacts until their total size fits
+	MaxSize ByteSize `yaml:"max_size"`
+}
+
+// ArtifactRetention configures how long artifacts are kept. The global
+// policy's MaxAge and KeepLast apply to each repo unless it overrides them,
+// while its MaxSize limits the store as a whole. A repo's MaxSize limits that
+// repo's artifacts.
+type ArtifactRetention struct {
+	RetentionPolicy `yaml:",inline"`
+	// Repos overrides the policy for particular repos, by repository URI
+	Repos map[string]RetentionPolicy `yaml:"repos"`
+	// Interval is how often artifacts are pruned, defaults to DefaultRetentionInterval
+	Interval time.Duration `yaml:"interval"`
+	// Pinned are the jobs whose artifacts are always kept. They don't count
+	// towards any limit.
+	Pinned map[JobID]bool `yaml:"-"`
+}
+
+// LoadArtifactRetention reads a retention config from a YAML file
+func LoadArtifactRetention(path string) (ArtifactRetention, error) {
+	var retention ArtifactRetention
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return retention, fmt.Errorf("failed to read retention config: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &retention); err != nil {
+		return retention, fmt.Errorf("failed to parse retention config %s: %w", path, err)
+	}
+	return retention, nil
+}
+
+// policy returns the age and count limits for a repo
+func (r ArtifactRetention) policy(repo string) RetentionPolicy {
+	policy := r.RetentionPolicy
+	override, ok := r.Repos[repo]
+	if !ok {
+		policy.MaxSize = 0
+		return policy
+	}
+	if override.MaxAge != 0 {
+		policy.MaxAge = override.MaxAge
+	}
+	if override.KeepLast != 0 {
+		policy.KeepLast = override.KeepLast
+	}
+	policy.MaxSize = override.MaxSize
+	return policy
+}
+
+// PruneArtifacts deletes the artifacts that retention no longer keeps,
+// returning the jobs whose artifacts were removed
+func PruneArtifacts(ctx context.Context, store ArtifactStore, retention ArtifactRetention, now time.Time) ([]ArtifactJob, error) {
+	stored, err := store.Jobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []ArtifactJob
+	for _, job := range stored {
+		if !retention.Pinned[job.JobID] {
+			jobs = append(jobs, job)
+		}
+	}
+
+	// Group jobs by repo, newest first
+	byRepo := map[string][]ArtifactJob{}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		byRepo[jobs[i].Repo] = append(byRepo[jobs[i].Repo], jobs[i])
+	}
+
+	expired := map[JobID]bool{}
+	for repo, repoJobs := range byRepo {
+		policy := retention.policy(repo)
+		var size int64
+		full := false
+		for i, job := range repoJobs {
+			full = full || policy.MaxSize > 0 && size+job.Size > int64(policy.MaxSize)
+			if full || policy.MaxAge > 0 && now.Sub(job.ModTime) > policy.MaxAge || policy.KeepLast > 0 && i >= policy.KeepLast {
+				expired[job.JobID] = true
+				continue
+			}
+			size += job.Size
+		}
+	}
+
+	// The global size limit removes the oldest remaining jobs across all repos
+	if retention.MaxSize > 0 {
+		var size int64
+		full := false
+		for i := len(jobs) - 1; i >= 0; i-- {
+			if expired[jobs[i].JobID] {
+				continue
+			}
+			full = full || size+jobs[i].Size > int64(retention.MaxSize)
+			if full {
+				expired[jobs[i].JobID] = true
+				continue
+			}
+			size += jobs[i].Size
+		}
+	}
+
+	var pruned []ArtifactJob
+	var errs []error
+	for _, job := range jobs {
+		if !expired[job.JobID] {
+			continue
+		}
+		if err := store.Delete(ctx, job.JobID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete artifacts of job %s: %w", job.JobID, err))
+			continue
+		}
+		pruned = append(pruned, job)
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// ByteSize is a number of bytes, which can be written in YAML with a unit
+// such as "500MB" or "10GiB"
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// ParseByteSize parses a size such as "1024", "500MB" or "10GiB"
+func ParseByteSize(value string) (ByteSize, error) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRightFunc(value, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	multiplier, ok := byteUnits[strings.ToUpper(strings.TrimSpace(value[len(number):]))]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes with an optional unit such as MB or GiB", value)
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", value, err)
+	}
+	return ByteSize(n * multiplier), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
@@ -0,0 +1,132 @@
+package minici
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticArtifactStore reports a fixed set of jobs and records deletions
+type staticArtifactStore struct {
+	ArtifactStore
+	jobs    []ArtifactJob
+	deleted []JobID
+}
+
+func (s *staticArtifactStore) Jobs(ctx context.Context) ([]ArtifactJob, error) {
+	return s.jobs, nil
+}
+
+func (s *staticArtifactStore) Delete(ctx context.Context, jobID JobID) error {
+	s.deleted = append(s.deleted, jobID)
+	return nil
+}
+
+func TestPruneArtifacts(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	jobs := []ArtifactJob{
+		{JobID: "01A", Repo: "web", Size: 100, ModTime: now.Add(-10 * day)},
+		{JobID: "01B", Repo: "web", Size: 100, ModTime: now.Add(-3 * day)},
+		{JobID: "01C", Repo: "api", Size: 300, ModTime: now.Add(-3 * day)},
+		{JobID: "01D", Repo: "web", Size: 100, ModTime: now.Add(-2 * day)},
+		{JobID: "01E", Repo: "web", Size: 100, ModTime: now.Add(-1 * day)},
+		{JobID: "01F", Repo: "api", Size: 300, ModTime: now.Add(-1 * day)},
+	}
+
+	tests := []struct {
+		name      string
+		retention ArtifactRetention
+		expected  string
+	}{
+		{name: "unlimited", expected: ""},
+		{name: "max age", retention: ArtifactRetention{RetentionPolicy: RetentionPolicy{MaxAge: 7 * day}}, expected: "01A"},
+		{name: "keep last per repo", retention: ArtifactRetention{RetentionPolicy: RetentionPolicy{KeepLast: 2}}, expected: "01A,01B"},
+		{name: "global max size", retention: ArtifactRetention{RetentionPolicy: RetentionPolicy{MaxSize: 600}}, expected: "01A,01B,01C"},
+		{
+			name: "repo overrides",
+			retention: ArtifactRetention{
+				RetentionPolicy: RetentionPolicy{MaxAge: 7 * day, KeepLast: 3},
+				Repos: map[string]RetentionPolicy{
+					"api": {KeepLast: 1},
+					"web": {MaxAge: 30 * day, MaxSize: 250},
+				},
+			},
+			expected: "01A,01B,01C",
+		},
+		{
+			name: "pinned jobs are kept and not counted",
+			retention: ArtifactRetention{
+				RetentionPolicy: RetentionPolicy{KeepLast: 2},
+				Pinned:          map[JobID]bool{"01A": true, "01B": true},
+			},
+			expected: "",
+		},
+		{
+			name: "pinned jobs don't count towards max size",
+			retention: ArtifactRetention{
+				RetentionPolicy: RetentionPolicy{MaxSize: 600},
+				Pinned:          map[JobID]bool{"01C": true},
+			},
+			expected: "01A",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &staticArtifactStore{jobs: jobs}
+			pruned, err := PruneArtifacts(context.Background(), store, test.retention, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, job := range pruned {
+				ids = append(ids, string(job.JobID))
+			}
+			if strings.Join(ids, ",") != test.expected || len(store.deleted) != len(pruned) {
+				t.Errorf("Expected %q to be pruned, got %v, deleted %v", test.expected, ids, store.deleted)
+			}
+		})
+	}
+}
+
+func TestLoadArtifactRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention.yml")
+	content := "max_age: 720h\nmax_size: 10GiB\nkeep_last: 20\ninterval: 15m\nrepos:\n  https://example.com/big.git:\n    max_size: 500MB\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	retention, err := LoadArtifactRetention(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retention.MaxAge != 720*time.Hour || retention.MaxSize != 10<<30 || retention.KeepLast != 20 || retention.Interval != 15*time.Minute {
+		t.Errorf("Unexpected retention %+v", retention)
+	}
+	if retention.Repos["https://example.com/big.git"].MaxSize != 500_000_000 {
+		t.Errorf("Unexpected repo retention %+v", retention.Repos)
+	}
+
+	if err := os.WriteFile(path, []byte("max_size: lots\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadArtifactRetention(path); err == nil {
+		t.Error("Expected an invalid size to return an error")
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for value, expected := range map[string]ByteSize{"1024": 1024, "5 MB": 5_000_000, "2gib": 2 << 30, "7B": 7} {
+		size, err := ParseByteSize(value)
+		if err != nil || size != expected {
+			t.Errorf("Expected %q to be %d, got %d, %v", value, expected, size, err)
+		}
+	}
+	for _, value := range []string{"", "MB", "1.5GB", "10 parsecs"} {
+		if _, err := ParseByteSize(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package minici
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3ArtifactStore keeps artifacts in an S3 bucket, or a compatible service
+// such as MinIO. Artifacts are named <prefix><job id>/files/<artifact name>,
+// and the repository a job ran for is saved in <prefix><job id>/repo.
+type S3ArtifactStore struct {
+	// Endpoint is the service's base URL, defaults to AWS's endpoint for the region
+	Endpoint string
+	// Region is the bucket's region, defaults to us-east-1
+	Region string
+	Bucket string
+	// Prefix is prepended to every object name, such as "minici/"
+	Prefix string
+	// PathStyle puts the bucket in the URL path rather than the host name,
+	// which MinIO and most other compatible services require
+	PathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is needed for temporary credentials
+	SessionToken string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// emptySHA256 is the hash of an empty request body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *S3ArtifactStore) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+func (s *S3ArtifactStore) key(jobID JobID, name string) string {
+	return s.Prefix + string(jobID) + "/files/" + name
+}
+
+// url returns the URL of an object, or of the bucket if key is empty
+func (s *S3ArtifactStore) url(key string, query url.Values) (*url.URL, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region())
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if s.PathStyle {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + key
+	}
+	// Escape the path the way it is signed, rather than leaving it to net/url
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+// do signs and sends a request, returning an error for unsuccessful responses
+func (s *S3ArtifactStore) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u, err := s.url(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptySHA256
+	if body != nil {
+		req.ContentLength = size
+		// Signing the payload would mean reading it twice
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	signAWSRequest(req, "s3", s.region(), s.AccessKeyID, s.SecretAccessKey, s.SessionToken, payloadHash, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrArtifactNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.NewDecoder(resp.Body).Decode(&s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("S3 %s failed: %s: %s", method, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("S3 %s failed: %s", method, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *S3ArtifactStore) Save(ctx context.Context, jobID JobID, name string, content io.Reader, size int64) error {
+	if err := validArtifactName(name); err != nil {
+		return err
+	}
+	// Hide other methods, such as Seek, so the body is streamed as is
+	body := struct{ io.Reader }{content}
+	resp, err := s.do(ctx, http.MethodPut, s.key(jobID, name), nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3ArtifactStore) SetRepo(ctx context.Context, jobID JobID, repo string) error {
+	key := s.Prefix + string(jobID) + "/repo"
+	resp, err := s.do(ctx, http.MethodPut, key, nil, nil, strings.NewReader(repo), int64(len(repo)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type s3ListResult struct {
+	Contents              []s3Object
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// listObjects returns every object whose name starts with prefix, in name order
+func (s *S3ArtifactStore) listObjects(ctx context.Context, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if errors.Is(err, ErrArtifactNotFound) {
+			return nil, fmt.Errorf("S3 bucket %s not found", s.Bucket)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing: %w", err)
+		}
+
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3ArtifactStore) List(ctx context.Context, jobID JobID) ([]Artifact, error) {
+	prefix := s.key(jobID, "")
+	objects, err := s.listObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var artifacts []Artifact
+	for _, object := range objects {
+		artifacts = append(artifacts, Artifact{
+			Name:    strings.TrimPrefix(object.Key, prefix),
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Name < artifacts[j].Name
+	})
+	return artifacts, nil
+}
+
+func (s *S3ArtifactStore) Jobs(ctx context.Context) ([]ArtifactJob, error) {
+	objects, err := s.listObjects(ctx, s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := map[JobID][]Artifact{}
+	repos := map[JobID]bool{}
+	var jobIDs []JobID
+	for _, object := range objects {
+		jobID, rest, ok := strings.Cut(strings.TrimPrefix(object.Key, s.Prefix), "/")
+		if !ok {
+			continue
+		}
+		if _, seen := artifacts[JobID(jobID)]; !seen {
+			artifacts[JobID(jobID)] = nil
+			jobIDs = append(jobIDs, JobID(jobID))
+		}
+		if rest == "repo" {
+			repos[JobID(jobID)] = true
+		} else if name, ok := strings.CutPrefix(rest, "files/"); ok {
+			artifacts[JobID(jobID)] = append(artifacts[JobID(jobID)], Artifact{Name: name, Size: object.Size, ModTime: object.LastModified})
+		}
+	}
+	sort.Slice(jobIDs, func(i, j int) bool {
+		return jobIDs[i] < jobIDs[j]
+	})
+
+	var jobs []ArtifactJob
+	for _, jobID := range jobIDs {
+		repo := ""
+		if repos[jobID] {
+			if repo, err = s.readRepo(ctx, jobID); err != nil {
+				return nil, err
+			}
+		}
+		jobs = append(jobs, summarizeArtifacts(jobID, repo, artifacts[jobID]))
+	}
+	return jobs, nil
+}
+
+func (s *S3ArtifactStore) readRepo(ctx context.Context, jobID JobID) (string, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.Prefix+string(jobID)+"/repo", nil, nil, nil, 0)
+	if errors.Is(err, ErrArtifactNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	repo, err := io.ReadAll(resp.Body)
+	return string(repo), err
+}
+
+func (s *S3ArtifactStore) Open(ctx context.Context, jobID JobID, name string) (ArtifactReader, error) {
+	if validArtifactName(name) != nil {
+		return nil, ErrArtifactNotFound
+	}
+	key := s.key(jobID, name)
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &s3Artifact{
+		ctx:      ctx,
+		store:    s,
+		key:      key,
+		artifact: Artifact{Name: name, Size: resp.ContentLength, ModTime: modTime},
+	}, nil
+}
+
+func (s *S3ArtifactStore) Delete(ctx context.Context, jobID JobID) error {
+	objects, err := s.listObjects(ctx, s.Prefix+string(jobID)+"/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		resp, err := s.do(ctx, http.MethodDelete, object.Key, nil, nil, nil, 0)
+		if errors.Is(err, ErrArtifactNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// s3Artifact reads an object, requesting the range from the current offset
+// whenever it seeks
+type s3Artifact struct {
+	ctx      context.Context
+	store    *S3ArtifactStore
+	key      string
+	artifact Artifact
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func (a *s3Artifact) Artifact() Artifact {
+	return a.artifact
+}
+
+func (a *s3Artifact) Read(p []byte) (int, error) {
+	if a.offset >= a.artifact.Size {
+		return 0, io.EOF
+	}
+	if a.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(a.offset, 10) + "-"}}
+		resp, err := a.store.do(a.ctx, http.MethodGet, a.key, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		a.body = resp.Body
+	}
+	n, err := a.body.Read(p)
+	a.offset += int64(n)
+	return n, err
+}
+
+func (a *s3Artifact) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += a.offset
+	case io.SeekEnd:
+		offset += a.artifact.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	if offset != a.offset && a.body != nil {
+		a.body.Close()
+		a.body = nil
+	}
+	a.offset = offset
+	return offset, nil
+}
+
+func (a *s3Artifact) Close() error {
+	if a.body != nil {
+		return a.body.Close()
+	}
+	return nil
+}
+
+// signAWSRequest adds AWS Signature Version 4 authentication to a request for
+// a service such as s3, signing the host and every header already set
+func signAWSRequest(req *http.Request, service, region, accessKeyID, secretAccessKey, sessionToken, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything except unreserved characters, and
+// slashes unless escapeSlash is set
+func s3Escape(s string, escapeSlash bool) string {
+	escaped := &strings.Builder{}
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/' && !escapeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
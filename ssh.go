@@ -0,0 +1,174 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSHExecutor runs commands on another host over SSH, using the ssh CLI. The
+// workspace is copied to a temporary directory on the host before the command
+// runs, and removed afterwards. The host must provide sh and tar.
+type SSHExecutor struct {
+	// Host to connect to, optionally as user@host
+	Host string
+	// Port to connect to, defaults to the ssh configuration's port
+	Port int
+	// IdentityFile is the private key to authenticate with, defaults to
+	// the ssh configuration's keys
+	IdentityFile string
+	// Options are extra ssh options, such as "StrictHostKeyChecking=accept-new"
+	Options []string
+	// WorkDir is the directory on the host that workspaces are created in,
+	// defaults to /tmp
+	WorkDir string
+	// SSH is the ssh binary to use, defaults to "ssh"
+	SSH string
+}
+
+// sshPIDMarker prefixes the line the remote shell prints with its process ID,
+// so the command can be killed if the job is cancelled
+const sshPIDMarker = "minici-pid "
+
+// Run copies the workspace to the host and runs the command there, streaming
+// its output back
+func (e *SSHExecutor) Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error) {
+	log := workspace.Log
+	cmdParts := strings.Fields(spec.Command)
+	if len(cmdParts) == 0 {
+		log("Error: empty command")
+		return Result{ExitCode: -1}, fmt.Errorf("empty command")
+	}
+
+	workDir := e.WorkDir
+	if workDir == "" {
+		workDir = "/tmp"
+	}
+	remoteDir := strings.TrimRight(workDir, "/") + "/" + containerName(workspace.JobID)
+	log(fmt.Sprintf("Executing command on %s in %s: %s", e.Host, remoteDir, spec.Command))
+
+	if err := e.copyWorkspace(ctx, workspace.Dir, remoteDir); err != nil {
+		log("Failed to copy workspace: " + err.Error())
+		return Result{ExitCode: -1}, err
+	}
+	defer e.removeWorkspace(remoteDir)
+
+	// The script is passed on stdin so environment variables don't appear in
+	// process listings. exec replaces the shell, so its PID is the command's.
+	script := &strings.Builder{}
+	fmt.Fprintf(script, "echo %s$$\n", sshPIDMarker)
+	fmt.Fprintf(script, "cd %s || exit 1\n", shellQuote(remoteDir))
+	fmt.Fprintf(script, "mkdir -p %s || exit 1\n", shellQuote(remoteScratchDir(remoteDir)))
+	env := workspaceEnv(spec.Env, remoteDir, remoteScratchDir(remoteDir))
+	for _, key := range sortedKeys(env) {
+		fmt.Fprintf(script, "export %s=%s\n", key, shellQuote(env[key]))
+	}
+	quoted := make([]string, len(cmdParts))
+	for i, part := range cmdParts {
+		quoted[i] = shellQuote(part)
+	}
+	fmt.Fprintf(script, "exec %s\n", strings.Join(quoted, " "))
+
+	var pidMutex sync.Mutex
+	var pid string
+	cmd := e.command(ctx, "sh -s")
+	cmd.Stdin = strings.NewReader(script.String())
+	output := &lineWriter{log: func(line string) {
+		pidMutex.Lock()
+		if pid == "" && strings.HasPrefix(line, sshPIDMarker) {
+			pid = strings.TrimPrefix(line, sshPIDMarker)
+			pidMutex.Unlock()
+			return
+		}
+		pidMutex.Unlock()
+		if line != "" {
+			log("> " + line)
+		}
+	}}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Closing the connection doesn't stop the remote command, so kill it explicitly
+	cmd.Cancel = func() error {
+		pidMutex.Lock()
+		remotePID := pid
+		pidMutex.Unlock()
+		if _, err := strconv.Atoi(remotePID); err == nil {
+			killCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			e.command(killCtx, "kill -TERM "+remotePID).Run()
+		}
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 10 * time.Second
+
+	err := cmd.Run()
+	output.Flush()
+	result := Result{ExitCode: cmd.ProcessState.ExitCode()}
+	if err != nil {
+		log("Command execution failed: " + err.Error())
+		return result, err
+	}
+
+	log("Command executed successfully")
+	return result, nil
+}
+
+// command builds an ssh command that runs remoteCommand on the host
+func (e *SSHExecutor) command(ctx context.Context, remoteCommand string) *exec.Cmd {
+	ssh := e.SSH
+	if ssh == "" {
+		ssh = "ssh"
+	}
+	// Never prompt for passwords or passphrases, there's nobody to answer
+	args := []string{"-o", "BatchMode=yes"}
+	if e.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(e.Port))
+	}
+	if e.IdentityFile != "" {
+		args = append(args, "-i", e.IdentityFile)
+	}
+	for _, option := range e.Options {
+		args = append(args, "-o", option)
+	}
+	args = append(args, "--", e.Host, remoteCommand)
+	return exec.CommandContext(ctx, ssh, args...)
+}
+
+// copyWorkspace streams the workspace to the host as a tar archive
+func (e *SSHExecutor) copyWorkspace(ctx context.Context, dir, remoteDir string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, dir))
+	}()
+
+	cmd := e.command(ctx, fmt.Sprintf("mkdir -p %[1]s && tar -x -f - -C %[1]s", shellQuote(remoteDir)))
+	cmd.Stdin = reader
+	if output, err := cmd.CombinedOutput(); err != nil {
+		reader.CloseWithError(err)
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (e *SSHExecutor) removeWorkspace(remoteDir string) {
+	// The job's context may already be cancelled, so clean up independently
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	e.command(ctx, "rm -rf "+shellQuote(remoteDir)+" "+shellQuote(remoteScratchDir(remoteDir))).Run()
+}
+
+// remoteScratchDir is where the job's scratch directory is created on the
+// host, next to its workspace
+func remoteScratchDir(remoteDir string) string {
+	return remoteDir + "-scratch"
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,128 @@
+package minici
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSSHScript runs the remote command locally, recording its arguments in
+// $FAKE_SSH
+const fakeSSHScript = `#!/bin/sh
+echo "$@" >> "$FAKE_SSH/calls"
+while [ "$1" != "--" ]; do shift; done
+shift 2
+exec sh -c "$1"
+`
+
+func newFakeSSH(t *testing.T) (string, string) {
+	state := t.TempDir()
+	t.Setenv("FAKE_SSH", state)
+	ssh := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(ssh, []byte(fakeSSHScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return ssh, state
+}
+
+func TestSSHExecutor(t *testing.T) {
+	ssh, state := newFakeSSH(t)
+	remote := t.TempDir()
+
+	dir := t.TempDir()
+	script := "cat message.txt\necho \"FOO=$FOO\"\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(dir, "build.sh"), []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "message.txt"), []byte("hello from host\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	workspace := Workspace{JobID: "01ABC", Dir: dir, Log: func(line string) {
+		lines = append(lines, line)
+	}}
+	executor := &SSHExecutor{
+		SSH:          ssh,
+		Host:         "ci@build1",
+		Port:         2222,
+		IdentityFile: "/keys/ci",
+		Options:      []string{"StrictHostKeyChecking=accept-new"},
+		WorkDir:      remote,
+	}
+
+	result, err := executor.Run(context.Background(), workspace, JobSpec{
+		Command: "sh build.sh",
+		Env:     map[string]string{"FOO": "it's here"},
+	})
+	if err == nil {
+		t.Error("Expected a non-zero exit code to return an error")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	output := strings.Join(lines, "\n")
+	for _, expected := range []string{"> hello from host", "> FOO=it's here"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got %v", expected, lines)
+		}
+	}
+	if strings.Contains(output, sshPIDMarker) {
+		t.Errorf("Expected the PID marker to be hidden, got %v", lines)
+	}
+
+	calls, err := os.ReadFile(filepath.Join(state, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"-o BatchMode=yes", "-p 2222", "-i /keys/ci", "-o StrictHostKeyChecking=accept-new", "-- ci@build1"} {
+		if !strings.Contains(string(calls), expected) {
+			t.Errorf("Expected ssh to be called with %q, got:\n%s", expected, calls)
+		}
+	}
+	if strings.Contains(string(calls), "it's here") {
+		t.Errorf("Expected environment variables not to be passed as arguments, got:\n%s", calls)
+	}
+
+	entries, err := os.ReadDir(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected the remote workspace to be removed, found %v", entries)
+	}
+}
+
+func TestSSHExecutorCancel(t *testing.T) {
+	ssh, state := newFakeSSH(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	workspace := Workspace{JobID: "01ABC", Dir: t.TempDir(), Log: func(line string) {
+		if strings.Contains(line, "Executing command") {
+			go func() {
+				time.Sleep(500 * time.Millisecond)
+				cancel()
+			}()
+		}
+	}}
+	executor := &SSHExecutor{SSH: ssh, Host: "build1", WorkDir: t.TempDir()}
+
+	start := time.Now()
+	if _, err := executor.Run(ctx, workspace, JobSpec{Command: "sleep 30"}); err == nil {
+		t.Error("Expected a cancelled job to return an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the job to stop promptly, took %v", elapsed)
+	}
+
+	calls, err := os.ReadFile(filepath.Join(state, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(calls), "kill -TERM") {
+		t.Errorf("Expected the remote command to be killed, got:\n%s", calls)
+	}
+}
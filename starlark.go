@@ -0,0 +1,212 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Programs are run with go.starlark.net. They can use if and for statements
+// at the top level and reassign globals, but there are no while loops,
+// recursion or load(), so a program can't read anything it isn't given, and
+// every program runs with limits on the steps it takes, the size of the values
+// it builds and its running time.
+var starFileOptions = &syntax.FileOptions{TopLevelControl: true, GlobalReassign: true}
+
+// starError is an error in a Starlark program, at the position it happened
+type starError struct {
+	file    string
+	line    int
+	col     int
+	message string
+}
+
+func (e *starError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.file, e.line, e.col, e.message)
+}
+
+// starLimits bound the resources a program can use
+type starLimits struct {
+	// steps is how many steps of the Starlark interpreter can run
+	steps uint64
+	// size is roughly how many bytes the values the program can reach may
+	// take up, as counted by starSizer
+	size    int
+	timeout time.Duration
+}
+
+// starSizeCheckSteps is how many steps a program runs between checks of the
+// size of its values. Doubling a string or list takes several steps, so a
+// program can only grow its values a few times over between checks.
+const starSizeCheckSteps = 16
+
+// execStarlark runs a program with Starlark's builtins and the predeclared
+// values, returning its global variables
+func execStarlark(ctx context.Context, file string, src []byte, predeclared starlark.StringDict, limits starLimits, print func(string)) (starlark.StringDict, error) {
+	var stepsExceeded, sizeExceeded bool
+	thread := &starlark.Thread{
+		Name: file,
+		Print: func(_ *starlark.Thread, msg string) {
+			if print != nil {
+				print(msg)
+			}
+		},
+		OnMaxSteps: func(thread *starlark.Thread) {
+			if thread.Steps >= limits.steps {
+				stepsExceeded = true
+				thread.Cancel("too many steps")
+				return
+			}
+			if starValuesSize(thread, limits.size) > limits.size {
+				sizeExceeded = true
+				thread.Cancel("values too large")
+				return
+			}
+			thread.SetMaxExecutionSteps(min(thread.Steps+starSizeCheckSteps, limits.steps))
+		},
+	}
+	thread.SetMaxExecutionSteps(min(starSizeCheckSteps, limits.steps))
+	ctx, cancel := context.WithTimeout(ctx, limits.timeout)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		thread.Cancel(ctx.Err().Error())
+	})
+	defer stop()
+
+	globals, err := starlark.ExecFileOptions(starFileOptions, thread, file, src, predeclared)
+	if err != nil {
+		err = positionedError(file, err)
+		var starErr *starError
+		if errors.As(err, &starErr) {
+			switch {
+			case stepsExceeded:
+				starErr.message = fmt.Sprintf("exceeded the limit of %d steps", limits.steps)
+			case sizeExceeded:
+				starErr.message = fmt.Sprintf("values exceeded the limit of %d bytes", limits.size)
+			}
+		}
+		return nil, err
+	}
+	sizer := starSizer{limit: limits.size}
+	for _, value := range globals {
+		sizer.add(value)
+	}
+	if sizer.size > limits.size {
+		return nil, fmt.Errorf("%s: values exceeded the limit of %d bytes", file, limits.size)
+	}
+	return globals, nil
+}
+
+// starValuesSize adds up the size of the values in the local and global
+// variables of the program running in thread, stopping once it passes limit
+func starValuesSize(thread *starlark.Thread, limit int) int {
+	sizer := starSizer{limit: limit}
+	for depth := range thread.CallStackDepth() {
+		frame := thread.DebugFrame(depth)
+		for i := range frame.NumLocals() {
+			_, value := frame.Local(i)
+			sizer.add(value)
+		}
+		if fn, ok := frame.Callable().(*starlark.Function); ok {
+			for i := range fn.NumFreeVars() {
+				_, value := fn.FreeVar(i)
+				sizer.add(value)
+			}
+			if depth == thread.CallStackDepth()-1 {
+				for _, value := range fn.Globals() {
+					sizer.add(value)
+				}
+			}
+		}
+	}
+	return sizer.size
+}
+
+// starSizer estimates how many bytes Starlark values take up, counting the
+// contents of strings and big numbers and a word for each element of a
+// list, tuple or dict. Values reachable more than once are counted once.
+type starSizer struct {
+	limit int
+	size  int
+	seen  map[starlark.Value]bool
+}
+
+func (s *starSizer) add(value starlark.Value) {
+	if s.size > s.limit {
+		return
+	}
+	switch v := value.(type) {
+	case starlark.String:
+		s.size += len(v)
+	case starlark.Bytes:
+		s.size += len(v)
+	case starlark.Int:
+		if _, ok := v.Int64(); !ok {
+			s.size += v.BigInt().BitLen() / 8
+		}
+	case starlark.Tuple:
+		s.size += 8 * len(v)
+		for _, elem := range v {
+			s.add(elem)
+		}
+	case *starlark.List:
+		if s.visit(v) {
+			s.size += 8 * v.Len()
+			for i := range v.Len() {
+				s.add(v.Index(i))
+			}
+		}
+	case *starlark.Dict:
+		if s.visit(v) {
+			s.size += 16 * v.Len()
+			for _, item := range v.Items() {
+				s.add(item[0])
+				s.add(item[1])
+			}
+		}
+	}
+}
+
+// visit reports whether a mutable value is being counted for the first time
+func (s *starSizer) visit(value starlark.Value) bool {
+	if s.seen == nil {
+		s.seen = map[starlark.Value]bool{}
+	}
+	if s.seen[value] {
+		return false
+	}
+	s.seen[value] = true
+	return true
+}
+
+// positionedError adds the position in the program an error happened at, from
+// the innermost call in the program rather than in a builtin
+func positionedError(file string, err error) error {
+	var syntaxErr syntax.Error
+	if errors.As(err, &syntaxErr) {
+		return &starError{file: file, line: int(syntaxErr.Pos.Line), col: int(syntaxErr.Pos.Col), message: syntaxErr.Msg}
+	}
+	var resolveErrs resolve.ErrorList
+	if errors.As(err, &resolveErrs) && len(resolveErrs) > 0 {
+		first := resolveErrs[0]
+		return &starError{file: file, line: int(first.Pos.Line), col: int(first.Pos.Col), message: first.Msg}
+	}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		for i := range evalErr.CallStack {
+			if pos := evalErr.CallStack.At(i).Pos; pos.Filename() == file && pos.Line > 0 {
+				return &starError{file: file, line: int(pos.Line), col: int(pos.Col), message: evalErr.Msg}
+			}
+		}
+		return errors.New(evalErr.Msg)
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package minici
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+var testStarLimits = starLimits{steps: 100_000, size: 1 << 20, timeout: 10 * time.Second}
+
+func TestExecStarlark(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{name: "top level control", src: `
+result = []
+for i, name in enumerate(["a", "b", "c", "d"]):
+    if name == "b":
+        continue
+    if i == 3:
+        break
+    result.append(name)
+`, expected: `["a", "c"]`},
+		{name: "reassigned globals", src: "result = 1\nresult += 1", expected: "2"},
+		{name: "functions", src: `
+def greet(name, greeting = "hello"):
+    return "%s %s" % (greeting, name)
+
+result = [greet("a"), greet("b", greeting = "hi")]
+`, expected: `["hello a", "hi b"]`},
+		{name: "floats", src: "result = 1.5 * 2", expected: "3.0"},
+		{name: "predeclared", src: "result = greeting.upper()", expected: `"HELLO"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			predeclared := starlark.StringDict{"greeting": starlark.String("hello")}
+			globals, err := execStarlark(context.Background(), "test.star", []byte(test.src), predeclared, testStarLimits, nil)
+			if err != nil {
+				t.Fatalf("Failed to run program: %v", err)
+			}
+			if result := globals["result"].String(); result != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestExecStarlarkErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{name: "syntax", src: "x = (1,\n", expected: "test.star:2:1: got end of file, want ')'"},
+		{name: "undefined", src: "x = y", expected: "test.star:1:5: undefined: y"},
+		{name: "while", src: "while True:\n    pass\n", expected: "test.star:1:1: this Starlark dialect does not support while loops"},
+		{name: "load", src: `load("x.star", "y")`, expected: "test.star:1:1: load not implemented by this application"},
+		{name: "type error", src: `x = 1 + "a"`, expected: "test.star:1:7: unknown binary op: int + string"},
+		{name: "fail", src: "def check():\n    fail('bad', 1)\ncheck()", expected: "test.star:2:9: fail: bad 1"},
+		{name: "recursion", src: "def f(n):\n    return f(n)\nf(1)", expected: "test.star:2:12: function f called recursively"},
+		{name: "steps", src: "for i in range(10):\n    for j in range(100000):\n        pass\n", expected: "test.star:2:5: exceeded the limit of 100000 steps"},
+		{name: "doubled string", src: "x = 'a'\nfor i in range(40):\n    x += x\n", expected: "test.star:3:10: values exceeded the limit of 1048576 bytes"},
+		{name: "doubled list", src: "def grow():\n    x = [0]\n    for i in range(40):\n        x = x + x\n    return x\ngrow()", expected: "values exceeded the limit of 1048576 bytes"},
+		{name: "large result", src: "x = 'a' * 2000000\n", expected: "test.star: values exceeded the limit of 1048576 bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := execStarlark(context.Background(), "test.star", []byte(test.src), nil, testStarLimits, nil)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected error %q, got %v", test.expected, err)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := execStarlark(ctx, "test.star", []byte("for i in range(5000):\n    pass\n"), nil, testStarLimits, nil); err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("Expected a canceled context to stop the program, got %v", err)
+	}
+}
+
+func TestExecStarlarkPrint(t *testing.T) {
+	var printed []string
+	_, err := execStarlark(context.Background(), "test.star", []byte(`print("a", 1, [2], sep = "-")`), nil, testStarLimits, func(line string) {
+		printed = append(printed, line)
+	})
+	if err != nil || len(printed) != 1 || printed[0] != "a-1-[2]" {
+		t.Errorf("Expected a-1-[2] to be printed, got %q, %v", printed, err)
+	}
+}
@@ -0,0 +1,268 @@
+package minici
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TrendBucket summarizes the jobs for a repo that finished within an interval
+type TrendBucket struct {
+	Start time.Time
+
+	Total     int
+	Succeeded int
+	Failed    int
+
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+
+	// Covered counts the jobs that reported coverage, and TotalCoverage sums their percentages
+	Covered       int
+	TotalCoverage float64
+}
+
+// SuccessRate returns the fraction of jobs in the bucket that succeeded, or 0 if there were none
+func (b TrendBucket) SuccessRate() float64 {
+	if b.Total == 0 {
+		return 0
+	}
+	return float64(b.Succeeded) / float64(b.Total)
+}
+
+// AverageDuration returns the mean duration of jobs in the bucket
+func (b TrendBucket) AverageDuration() time.Duration {
+	if b.Total == 0 {
+		return 0
+	}
+	return b.TotalDuration / time.Duration(b.Total)
+}
+
+// AverageCoverage returns the mean coverage of jobs in the bucket that reported it
+func (b TrendBucket) AverageCoverage() float64 {
+	if b.Covered == 0 {
+		return 0
+	}
+	return b.TotalCoverage / float64(b.Covered)
+}
+
+// RepoTrend holds the buckets for a single repo, oldest first
+type RepoTrend struct {
+	RepoURI string
+	Buckets []TrendBucket
+}
+
+// Trends groups jobs that succeeded or failed between since and until by repo
+// and into buckets of the given interval. Every repo has a bucket for every
+// interval, including those without any jobs, so they can be charted directly.
+// Cancelled and unfinished jobs are ignored.
+func Trends(jobs []Job, since, until time.Time, interval time.Duration) []RepoTrend {
+	if interval <= 0 || !until.After(since) {
+		return nil
+	}
+	count := int((until.Sub(since) + interval - 1) / interval)
+
+	byRepo := make(map[string][]TrendBucket)
+	for _, job := range jobs {
+		if job.Status != JobStatusSuccess && job.Status != JobStatusFailure {
+			continue
+		}
+		if job.FinishedAt.Before(since) || !job.FinishedAt.Before(until) {
+			continue
+		}
+
+		buckets, ok := byRepo[job.RepoURI]
+		if !ok {
+			buckets = make([]TrendBucket, count)
+			for i := range buckets {
+				buckets[i].Start = since.Add(time.Duration(i) * interval)
+			}
+			byRepo[job.RepoURI] = buckets
+		}
+
+		bucket := &buckets[int(job.FinishedAt.Sub(since)/interval)]
+		bucket.Total++
+		if job.Status == JobStatusSuccess {
+			bucket.Succeeded++
+		} else {
+			bucket.Failed++
+		}
+		duration := job.Duration()
+		bucket.TotalDuration += duration
+		if duration > bucket.MaxDuration {
+			bucket.MaxDuration = duration
+		}
+		if job.Coverage != nil {
+			bucket.Covered++
+			bucket.TotalCoverage += *job.Coverage
+		}
+	}
+
+	trends := make([]RepoTrend, 0, len(byRepo))
+	for repo, buckets := range byRepo {
+		trends = append(trends, RepoTrend{RepoURI: repo, Buckets: buckets})
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		return trends[i].RepoURI < trends[j].RepoURI
+	})
+	return trends
+}
+
+// recentJobs is how many of the most recently finished jobs are used to estimate durations
+const recentJobs = 20
+
+// QueueStats describes the jobs waiting to run and the workers running them
+type QueueStats struct {
+	// Capacity is the number of jobs that can run at once, 0 if unlimited
+	Capacity int
+	Running  int
+	Workers  []WorkerStats
+	Queue    []QueuedJob
+
+	// AverageDuration is the mean duration of recently finished jobs
+	AverageDuration time.Duration
+	// EstimatedWait is how long a job scheduled now is expected to wait before starting
+	EstimatedWait time.Duration
+	// Maintenance is the maintenance window holding jobs back, nil outside
+	// of one
+	Maintenance *ActiveMaintenance
+}
+
+// WorkerStats describes the jobs running on a single worker
+type WorkerStats struct {
+	Name     string
+	Labels   []string
+	Capacity int
+	Running  []JobID
+	// LastSeen is when a remote agent last contacted the server, zero for the local agent
+	LastSeen time.Time
+}
+
+// QueuedJob is a job waiting to start
+type QueuedJob struct {
+	Job           Job
+	EstimatedWait time.Duration
+}
+
+// QueueStats returns the current queue depth and worker utilization
+func (s *CIServer) QueueStats() QueueStats {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+
+	now := s.clock.Now()
+	stats := QueueStats{}
+
+	var running []Job
+	var finished []Job
+	for _, job := range s.jobs {
+		switch {
+		case job.Status == JobStatusRunning:
+			running = append(running, *job)
+		case job.Status == JobStatusSuccess || job.Status == JobStatusFailure:
+			finished = append(finished, *job)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].StartedAt.Before(running[j].StartedAt)
+	})
+	stats.Running = len(running)
+	stats.AverageDuration = averageRecentDuration(finished)
+
+	unlimited := false
+	for _, a := range s.allAgents() {
+		// Agents that have gone quiet are about to be removed
+		if a.expired(now, s.timeout()) {
+			continue
+		}
+		stats.Workers = append(stats.Workers, s.agentStats(a, running))
+		stats.Capacity += a.Capacity
+		unlimited = unlimited || (a.local && a.Capacity == 0)
+	}
+	if unlimited {
+		stats.Capacity = 0
+	}
+
+	waits := estimateWaits(now, running, len(s.waiting), stats.Capacity, stats.AverageDuration)
+	// Jobs other than urgent ones wait for maintenance to end
+	stats.Maintenance = s.maintenance.Active(now)
+	held := func(wait time.Duration, job *Job) time.Duration {
+		if stats.Maintenance == nil || (job != nil && job.Urgent) {
+			return wait
+		}
+		return max(wait, stats.Maintenance.End.Sub(now))
+	}
+	for i, queued := range s.waiting {
+		stats.Queue = append(stats.Queue, QueuedJob{Job: *queued.job, EstimatedWait: held(waits[i], queued.job)})
+	}
+	stats.EstimatedWait = held(waits[len(s.waiting)], nil)
+	return stats
+}
+
+// averageRecentDuration returns the mean duration of the most recently finished jobs
+func averageRecentDuration(finished []Job) time.Duration {
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.After(finished[j].FinishedAt)
+	})
+	if len(finished) > recentJobs {
+		finished = finished[:recentJobs]
+	}
+	if len(finished) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, job := range finished {
+		total += job.Duration()
+	}
+	return total / time.Duration(len(finished))
+}
+
+// estimateWaits estimates how long each of the queued jobs, plus one more
+// scheduled now, will wait to start. Each job is assumed to take the average
+// duration, and queued jobs take the first slot to become free.
+func estimateWaits(now time.Time, running []Job, queued, capacity int, average time.Duration) []time.Duration {
+	waits := make([]time.Duration, queued+1)
+	if capacity == 0 {
+		return waits
+	}
+
+	// free holds when each slot is expected to become available
+	free := make([]time.Duration, capacity)
+	for i, job := range running {
+		if i >= capacity {
+			break
+		}
+		if remaining := average - now.Sub(job.StartedAt); remaining > 0 {
+			free[i] = remaining
+		}
+	}
+
+	for i := range waits {
+		next := 0
+		for slot := range free {
+			if free[slot] < free[next] {
+				next = slot
+			}
+		}
+		waits[i] = free[next]
+		free[next] += average
+	}
+	return waits
+}
+
+var (
+	hostnameOnce  sync.Once
+	hostnameValue string
+)
+
+// hostname names the local worker
+func hostname() string {
+	hostnameOnce.Do(func() {
+		name, err := os.Hostname()
+		if err != nil || name == "" {
+			name = "local"
+		}
+		hostnameValue = name
+	})
+	return hostnameValue
+}
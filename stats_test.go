@@ -0,0 +1,99 @@
+package minici
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrends(t *testing.T) {
+	since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	job := func(repo string, status JobStatus, finished time.Time, duration time.Duration) Job {
+		return Job{
+			RepoURI:    repo,
+			Status:     status,
+			StartedAt:  finished.Add(-duration),
+			FinishedAt: finished,
+		}
+	}
+
+	jobs := []Job{
+		job("b", JobStatusSuccess, since.Add(time.Hour), time.Minute),
+		job("b", JobStatusFailure, since.Add(2*time.Hour), 3*time.Minute),
+		job("b", JobStatusSuccess, since.Add(day+time.Hour), 2*time.Minute),
+		job("a", JobStatusSuccess, since.Add(2*day+time.Hour), time.Minute),
+		// Ignored: cancelled, unfinished and out of range
+		job("a", JobStatusCancelled, since.Add(time.Hour), time.Minute),
+		{RepoURI: "a", Status: JobStatusRunning},
+		job("c", JobStatusSuccess, since.Add(-time.Hour), time.Minute),
+		job("c", JobStatusSuccess, since.Add(3*day), time.Minute),
+	}
+
+	coverage := 80.0
+	jobs[0].Coverage = &coverage
+
+	trends := Trends(jobs, since, since.Add(3*day), day)
+	if len(trends) != 2 {
+		t.Fatalf("Expected trends for 2 repos, but got %d", len(trends))
+	}
+	if trends[0].RepoURI != "a" || trends[1].RepoURI != "b" {
+		t.Fatalf("Expected repos to be sorted, got %s, %s", trends[0].RepoURI, trends[1].RepoURI)
+	}
+
+	for _, trend := range trends {
+		if len(trend.Buckets) != 3 {
+			t.Fatalf("Expected 3 buckets for %s, but got %d", trend.RepoURI, len(trend.Buckets))
+		}
+	}
+
+	first := trends[1].Buckets[0]
+	if first.Total != 2 || first.Succeeded != 1 || first.Failed != 1 {
+		t.Errorf("Unexpected counts in first bucket: %+v", first)
+	}
+	if first.SuccessRate() != 0.5 {
+		t.Errorf("Expected success rate of 0.5, but got %f", first.SuccessRate())
+	}
+	if first.AverageDuration() != 2*time.Minute || first.MaxDuration != 3*time.Minute {
+		t.Errorf("Unexpected durations in first bucket: %+v", first)
+	}
+	if first.Covered != 1 || first.AverageCoverage() != 80 {
+		t.Errorf("Expected only the covered job to count towards coverage: %+v", first)
+	}
+	if !trends[1].Buckets[1].Start.Equal(since.Add(day)) {
+		t.Errorf("Expected second bucket to start a day later, but got %s", trends[1].Buckets[1].Start)
+	}
+	if trends[0].Buckets[0].Total != 0 || trends[0].Buckets[2].Total != 1 {
+		t.Errorf("Unexpected buckets for a: %+v", trends[0].Buckets)
+	}
+}
+
+func TestEstimateWaits(t *testing.T) {
+	now := time.Now()
+	running := []Job{
+		{StartedAt: now.Add(-4 * time.Minute)},
+		{StartedAt: now.Add(-time.Minute)},
+	}
+
+	waits := estimateWaits(now, running, 2, 2, 5*time.Minute)
+	expected := []time.Duration{time.Minute, 4 * time.Minute, 6 * time.Minute}
+	if len(waits) != len(expected) {
+		t.Fatalf("Expected %d waits, but got %d", len(expected), len(waits))
+	}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Errorf("Expected wait %d to be %s, but got %s", i, expected[i], waits[i])
+		}
+	}
+
+	// Unlimited capacity never waits
+	for _, wait := range estimateWaits(now, running, 3, 0, 5*time.Minute) {
+		if wait != 0 {
+			t.Errorf("Expected no wait with unlimited capacity, but got %s", wait)
+		}
+	}
+
+	// Free slots start immediately
+	if waits := estimateWaits(now, running[:1], 0, 2, 5*time.Minute); waits[0] != 0 {
+		t.Errorf("Expected no wait with a free slot, but got %s", waits[0])
+	}
+}
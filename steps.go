@@ -0,0 +1,422 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StepConfig is a command a repo runs after each job's command, if its
+// condition holds
+type StepConfig struct {
+	// Name identifies the step in the logs and in other steps' conditions,
+	// as steps.<name>.outcome
+	Name string `yaml:"name"`
+	// Run is the command to run
+	Run string `yaml:"run"`
+	// If is the condition for running the step, such as
+	// "branch == 'main' && changed('deploy/**')". Without success(),
+	// failure() or always(), steps only run if everything before them
+	// succeeded.
+	If string `yaml:"if"`
+	// Parallel runs these steps at the same time in place of Run, in the
+	// same workspace. Their conditions may read the outcomes of the steps
+	// before the group but not of each other, and the group fails if any of
+	// them fails.
+	Parallel []StepConfig `yaml:"parallel"`
+}
+
+// Step outcomes, as read by steps.<name>.outcome
+const (
+	StepSuccess = "success"
+	StepFailure = "failure"
+	StepSkipped = "skipped"
+)
+
+// stepNamePattern matches the names steps can be referred to by
+var stepNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// step is a step with its condition parsed
+type step struct {
+	StepConfig
+	condition *condition
+	// parallel holds the steps of a parallel group
+	parallel []step
+}
+
+// parseSteps checks the repo's steps, parsing their conditions. Steps may
+// only read the outcomes of steps before them.
+func parseSteps(configs []StepConfig) ([]step, error) {
+	return parseStepList(configs, map[string]bool{}, stepLabel)
+}
+
+// parseStepList parses steps or hooks, given the names of the steps before
+// them, adding their own names. labelOf names them in errors.
+func parseStepList(configs []StepConfig, names map[string]bool, labelOf func(config StepConfig, i int) string) ([]step, error) {
+	steps := make([]step, len(configs))
+	for i, config := range configs {
+		label := labelOf(config, i)
+		if len(config.Parallel) > 0 && strings.TrimSpace(config.Run) != "" {
+			return nil, fmt.Errorf("%s can't have both run and parallel", label)
+		}
+		if strings.TrimSpace(config.Run) == "" && len(config.Parallel) == 0 {
+			return nil, fmt.Errorf("%s has nothing to run", label)
+		}
+		if config.Name != "" {
+			if !stepNamePattern.MatchString(config.Name) {
+				return nil, fmt.Errorf("invalid step name %q: must only contain letters, digits, _ and -", config.Name)
+			}
+			if names[config.Name] {
+				return nil, fmt.Errorf("step name %q is used more than once", config.Name)
+			}
+		}
+		steps[i].StepConfig = config
+		if config.If != "" {
+			cond, err := parseCondition(config.If)
+			if err != nil {
+				return nil, fmt.Errorf("invalid condition for %s: %w", label, err)
+			}
+			for _, name := range cond.steps {
+				if !names[name] {
+					return nil, fmt.Errorf("condition for %s reads the outcome of %q, which isn't an earlier step", label, name)
+				}
+			}
+			steps[i].condition = cond
+		}
+		// The steps of a parallel group can't read each other's outcomes, so
+		// each is checked against the names from before the group
+		before := maps.Clone(names)
+		names[config.Name] = true
+		for j, child := range config.Parallel {
+			if len(child.Parallel) > 0 {
+				return nil, fmt.Errorf("%s: parallel steps can't be nested", parallelLabel(child, i, j))
+			}
+			if child.Name != "" && names[child.Name] {
+				return nil, fmt.Errorf("step name %q is used more than once", child.Name)
+			}
+			parsed, err := parseStepList([]StepConfig{child}, maps.Clone(before), func(config StepConfig, _ int) string {
+				return parallelLabel(config, i, j)
+			})
+			if err != nil {
+				return nil, err
+			}
+			steps[i].parallel = append(steps[i].parallel, parsed[0])
+			names[child.Name] = true
+		}
+	}
+	return steps, nil
+}
+
+// stepLabel names a step in messages
+func stepLabel(config StepConfig, i int) string {
+	if config.Name != "" {
+		return "step " + config.Name
+	}
+	return fmt.Sprintf("step %d", i+1)
+}
+
+// parallelLabel names the jth step of the parallel group at index i in
+// messages
+func parallelLabel(config StepConfig, i, j int) string {
+	if config.Name != "" {
+		return "step " + config.Name
+	}
+	return fmt.Sprintf("step %d.%d", i+1, j+1)
+}
+
+// runSteps runs the steps whose conditions hold, after the job's command
+// finished with err, returning the job's error once they have run. Each is
+// logged under its own heading, like the setup commands.
+func runSteps(ctx context.Context, executor Executor, workspace Workspace, spec JobSpec, steps []step, conditions *conditionContext, err error) error {
+	conditions.Failed = err != nil
+	conditions.Steps = map[string]string{}
+	for i, step := range steps {
+		label := stepLabel(step.StepConfig, i)
+		outcome := StepSkipped
+		run := !conditions.Failed
+		if step.condition != nil {
+			var evalErr error
+			run, evalErr = step.condition.eval(conditions)
+			if evalErr != nil {
+				workspace.Log(fmt.Sprintf("=== Failed to evaluate the condition for %s: %v", label, evalErr))
+				run, outcome = false, StepFailure
+				if err == nil {
+					err = fmt.Errorf("%s: %w", label, evalErr)
+				}
+			}
+		}
+		// Nothing more runs once the job is cancelled or out of time
+		if run && ctx.Err() != nil {
+			run = false
+		}
+
+		if run && len(step.parallel) > 0 {
+			var groupErr error
+			outcome, groupErr = runParallel(ctx, executor, workspace, spec, step, i, len(steps), conditions)
+			if err == nil {
+				err = groupErr
+			}
+		} else if run {
+			workspace.Log(fmt.Sprintf("=== Step %d/%d: %s", i+1, len(steps), step.Run))
+			start := time.Now()
+			command := spec
+			command.Command = step.Run
+			_, stepErr := executor.Run(ctx, workspace, command)
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if stepErr != nil {
+				workspace.Log(fmt.Sprintf("=== Step failed after %s", elapsed))
+				outcome = StepFailure
+				if err == nil {
+					err = stepErr
+				}
+			} else {
+				workspace.Log(fmt.Sprintf("=== Step finished in %s", elapsed))
+				outcome = StepSuccess
+			}
+		} else if outcome == StepSkipped {
+			workspace.Log(fmt.Sprintf("=== Skipped %s", label))
+		}
+		if step.Name != "" {
+			conditions.Steps[step.Name] = outcome
+		}
+		if !run {
+			for _, child := range step.parallel {
+				if child.Name != "" {
+					conditions.Steps[child.Name] = StepSkipped
+				}
+			}
+		}
+		conditions.Failed = conditions.Failed || outcome == StepFailure
+	}
+	return err
+}
+
+// runParallel runs the steps of a parallel group whose conditions hold at
+// the same time, returning the group's outcome and the first of its steps'
+// errors. The group failed if any of its steps failed, succeeded if any ran,
+// and was skipped otherwise. Each step's logs are held back until it
+// finishes and then logged together under its own heading, so the steps'
+// output doesn't interleave.
+func runParallel(ctx context.Context, executor Executor, workspace Workspace, spec JobSpec, group step, i, n int, conditions *conditionContext) (string, error) {
+	workspace.Log(fmt.Sprintf("=== Step %d/%d: %d steps in parallel", i+1, n, len(group.parallel)))
+	start := time.Now()
+	outcomes := make([]string, len(group.parallel))
+	errs := make([]error, len(group.parallel))
+	var run []int
+	for j, child := range group.parallel {
+		outcomes[j] = StepSkipped
+		ok := !conditions.Failed
+		if child.condition != nil {
+			var evalErr error
+			ok, evalErr = child.condition.eval(conditions)
+			if evalErr != nil {
+				label := parallelLabel(child.StepConfig, i, j)
+				workspace.Log(fmt.Sprintf("=== Failed to evaluate the condition for %s: %v", label, evalErr))
+				ok, outcomes[j], errs[j] = false, StepFailure, fmt.Errorf("%s: %w", label, evalErr)
+			}
+		}
+		if ok && ctx.Err() == nil {
+			run = append(run, j)
+		} else if outcomes[j] == StepSkipped {
+			workspace.Log(fmt.Sprintf("=== Skipped %s", parallelLabel(child.StepConfig, i, j)))
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, j := range run {
+		child := group.parallel[j]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lines []string
+			var linesMu sync.Mutex
+			stepWorkspace := workspace
+			stepWorkspace.Log = func(line string) {
+				linesMu.Lock()
+				defer linesMu.Unlock()
+				lines = append(lines, line)
+			}
+			stepStart := time.Now()
+			command := spec
+			command.Command = child.Run
+			_, errs[j] = executor.Run(ctx, stepWorkspace, command)
+			elapsed := time.Since(stepStart).Round(time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			linesMu.Lock()
+			defer linesMu.Unlock()
+			workspace.Log(fmt.Sprintf("=== Step %d.%d: %s", i+1, j+1, child.Run))
+			for _, line := range lines {
+				workspace.Log(line)
+			}
+			if errs[j] != nil {
+				workspace.Log(fmt.Sprintf("=== Step %d.%d failed after %s", i+1, j+1, elapsed))
+				outcomes[j] = StepFailure
+			} else {
+				workspace.Log(fmt.Sprintf("=== Step %d.%d finished in %s", i+1, j+1, elapsed))
+				outcomes[j] = StepSuccess
+			}
+		}()
+	}
+	wg.Wait()
+
+	outcome := StepSkipped
+	var err error
+	for j, child := range group.parallel {
+		if child.Name != "" {
+			conditions.Steps[child.Name] = outcomes[j]
+		}
+		switch {
+		case outcomes[j] == StepFailure:
+			outcome = StepFailure
+		case outcomes[j] == StepSuccess && outcome == StepSkipped:
+			outcome = StepSuccess
+		}
+		if err == nil {
+			err = errs[j]
+		}
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if outcome == StepFailure {
+		workspace.Log(fmt.Sprintf("=== Parallel steps failed after %s", elapsed))
+	} else {
+		workspace.Log(fmt.Sprintf("=== Parallel steps finished in %s", elapsed))
+	}
+	return outcome, err
+}
+
+// CheckoutDescriber is implemented by git clients that can describe a
+// checked out commit, for the branch, tag and changed() in step conditions
+type CheckoutDescriber interface {
+	// DescribeRef returns the branch or tag ref names in the clone at dir.
+	// Both are empty for commit hashes.
+	DescribeRef(ctx context.Context, dir, ref string) (branch, tag string, err error)
+	// ChangedFiles lists the files the checked out commit changed from its
+	// first parent, or every file if it has no parents
+	ChangedFiles(ctx context.Context, dir string) ([]string, error)
+}
+
+func (GitCLI) DescribeRef(ctx context.Context, dir, ref string) (string, string, error) {
+	if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return branch, "", nil
+	}
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return "", tag, nil
+	}
+	if ref == "" || ref == "HEAD" {
+		// The clone's HEAD is the remote's default branch, if the remote
+		// said which that is
+		head, err := gitOutput(ctx, dir, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+		if err != nil {
+			return "", "", nil
+		}
+		return strings.TrimPrefix(strings.TrimSpace(head), "origin/"), "", nil
+	}
+	ref = strings.TrimPrefix(ref, "origin/")
+	if _, err := gitOutput(ctx, dir, "show-ref", "--verify", "--quiet", "refs/remotes/origin/"+ref); err == nil {
+		return ref, "", nil
+	}
+	if _, err := gitOutput(ctx, dir, "show-ref", "--verify", "--quiet", "refs/tags/"+ref); err == nil {
+		return "", ref, nil
+	}
+	return "", "", nil
+}
+
+func (GitCLI) ChangedFiles(ctx context.Context, dir string) ([]string, error) {
+	var output string
+	var err error
+	if _, parentErr := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD^1"); parentErr == nil {
+		output, err = gitOutput(ctx, dir, "diff", "--name-only", "HEAD^1", "HEAD")
+	} else {
+		output, err = gitOutput(ctx, dir, "ls-tree", "-r", "--name-only", "HEAD")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// DescribeRef and ChangedFiles aren't limited, as they only touch the local
+// clone
+func (l cloneLimiter) DescribeRef(ctx context.Context, dir, ref string) (string, string, error) {
+	describer, ok := l.GitClient.(CheckoutDescriber)
+	if !ok {
+		branch, tag := describeRefName(ref)
+		return branch, tag, nil
+	}
+	return describer.DescribeRef(ctx, dir, ref)
+}
+
+func (l cloneLimiter) ChangedFiles(ctx context.Context, dir string) ([]string, error) {
+	describer, ok := l.GitClient.(CheckoutDescriber)
+	if !ok {
+		return nil, fmt.Errorf("changed() isn't supported by this git client")
+	}
+	return describer.ChangedFiles(ctx, dir)
+}
+
+// describeRefName guesses the branch or tag a ref names from the ref alone,
+// for git clients that can't describe their checkouts
+func describeRefName(ref string) (branch, tag string) {
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return "", tag
+	}
+	ref = strings.TrimPrefix(ref, "refs/heads/")
+	if ref == "" || ref == "HEAD" || commitHashPattern.MatchString(ref) {
+		return "", ""
+	}
+	return ref, ""
+}
+
+// stepConditions builds the context step conditions are evaluated in
+func stepConditions(ctx context.Context, git GitClient, job Job, spec JobSpec, dir, commit string) (*conditionContext, error) {
+	conditions := &conditionContext{
+		Ref:     job.Commit,
+		Commit:  commit,
+		RepoURI: job.RepoURI,
+		Project: job.Project,
+		Env:     spec.Env,
+		Labels:  job.Labels,
+	}
+	describer, ok := git.(CheckoutDescriber)
+	if !ok {
+		conditions.Branch, conditions.Tag = describeRefName(job.Commit)
+		return conditions, nil
+	}
+	var err error
+	conditions.Branch, conditions.Tag, err = describer.DescribeRef(ctx, dir, job.Commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the branch or tag of %s: %w", job.Commit, err)
+	}
+	var changed []string
+	var changedErr error
+	listed := false
+	conditions.Changed = func() ([]string, error) {
+		if !listed {
+			changed, changedErr = describer.ChangedFiles(ctx, dir)
+			listed = true
+		}
+		return changed, changedErr
+	}
+	return conditions, nil
+}
+
+// gitOutput runs git in dir, returning its output
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(output), err
+}
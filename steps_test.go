@@ -0,0 +1,256 @@
+package minici
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configGit clones a repo holding a repo config, or a pipeline program if
+// one is given, whose commit changed the given files
+type configGit struct {
+	fakeGit
+	config   string
+	pipeline string
+	changed  []string
+}
+
+func (g *configGit) Clone(ctx context.Context, repoURI, dir string) error {
+	if g.pipeline != "" {
+		return os.WriteFile(filepath.Join(dir, RepoPipelineFile), []byte(g.pipeline), 0o644)
+	}
+	return os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(g.config), 0o644)
+}
+
+func (g *configGit) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	return "0123abcd", nil
+}
+
+func (g *configGit) DescribeRef(ctx context.Context, dir, ref string) (string, string, error) {
+	branch, tag := describeRefName(ref)
+	return branch, tag, nil
+}
+
+func (g *configGit) ChangedFiles(ctx context.Context, dir string) ([]string, error) {
+	return g.changed, nil
+}
+
+func TestSteps(t *testing.T) {
+	git := &configGit{
+		changed: []string{"deploy/app.yaml"},
+		config: `steps:
+  - name: build
+    run: echo built
+  - name: deploy
+    run: echo deploying
+    if: branch == 'main' && changed('deploy/**')
+  - name: release
+    run: echo releasing
+    if: tag != ''
+  - name: broken
+    run: exit 3
+    if: env.BREAK == '1'
+  - name: notify
+    run: echo notifying
+    if: failure()
+  - run: echo cleaning up
+    if: always() && steps.build.outcome == 'success'
+`,
+	}
+	run := func(commit string, env map[string]string) (JobResult, string) {
+		var lines []string
+		job := Job{ID: "01ABC", RepoURI: "https://example.com/repo.git", Commit: commit, Command: "echo command", Env: env}
+		result := RunJob(context.Background(), job, &LocalExecutor{}, JobStores{Git: git}, func(line string) {
+			lines = append(lines, line)
+		})
+		return result, strings.Join(lines, "\n")
+	}
+
+	result, logs := run("main", nil)
+	if result.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %+v: %s", result, logs)
+	}
+	for _, expected := range []string{"> built", "=== Step 2/6: echo deploying", "=== Skipped step release", "=== Skipped step notify", "> cleaning up"} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected %q in the logs, got:\n%s", expected, logs)
+		}
+	}
+
+	result, logs = run("refs/tags/v1.0.0", map[string]string{"BREAK": "1"})
+	if result.Status != JobStatusFailure || result.FailedPhase != PhaseCommand {
+		t.Errorf("Expected a failing step to fail the job's command, got %+v", result)
+	}
+	for _, expected := range []string{"=== Skipped step deploy", "> releasing", "=== Step failed after", "> notifying", "> cleaning up"} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected %q in the logs, got:\n%s", expected, logs)
+		}
+	}
+
+	git.config = "steps:\n  - run: echo hi\n    if: brnch == 'main'\n"
+	result, logs = run("main", nil)
+	if result.Status != JobStatusFailure || result.FailedPhase != PhasePrepare {
+		t.Errorf("Expected an invalid condition to fail preparing the job, got %+v", result)
+	}
+	if !strings.Contains(logs, `invalid condition for step 1: unknown name "brnch"`) {
+		t.Errorf("Expected the invalid condition to be logged, got:\n%s", logs)
+	}
+}
+
+func TestParallelSteps(t *testing.T) {
+	git := &configGit{
+		config: `steps:
+  - name: build
+    run: echo built
+  - name: checks
+    parallel:
+      - name: lint
+        run: sleep 1
+      - name: vet
+        run: sleep 1
+      - name: test
+        run: echo testing
+      - run: echo releasing
+        if: tag != ''
+      - name: broken
+        run: false
+        if: env.BREAK == '1'
+  - run: echo checks passed
+    if: steps.checks.outcome == 'success' && steps.lint.outcome == 'success'
+  - run: echo checks failed
+    if: failure() && steps.broken.outcome == 'failure'
+`,
+	}
+	run := func(env map[string]string) (JobResult, []string) {
+		var lines []string
+		job := Job{ID: "01ABC", RepoURI: "https://example.com/repo.git", Commit: "main", Command: "echo command", Env: env}
+		result := RunJob(context.Background(), job, &LocalExecutor{}, JobStores{Git: git}, func(line string) {
+			lines = append(lines, line)
+		})
+		return result, lines
+	}
+
+	start := time.Now()
+	result, lines := run(nil)
+	logs := strings.Join(lines, "\n")
+	if result.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %+v: %s", result, logs)
+	}
+	if elapsed := time.Since(start); elapsed > 1900*time.Millisecond {
+		t.Errorf("Expected the parallel steps to run at the same time, took %s", elapsed)
+	}
+	for _, expected := range []string{"=== Step 2/4: 5 steps in parallel", "=== Skipped step 2.4", "=== Skipped step broken", "=== Parallel steps finished in", "> checks passed"} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected %q in the logs, got:\n%s", expected, logs)
+		}
+	}
+	// Each step's logs are kept together, and the quickest step's come first
+	for i, line := range lines {
+		if line == "=== Step 2.1: sleep 1" || line == "=== Step 2.2: sleep 1" {
+			t.Fatalf("Expected the test step to finish before the sleeping steps, got:\n%s", logs)
+		}
+		if line == "=== Step 2.3: echo testing" {
+			expected := []string{line, "Executing command: echo testing", "> testing", "Command executed successfully"}
+			if i+len(expected) >= len(lines) || strings.Join(lines[i:i+len(expected)], "\n") != strings.Join(expected, "\n") || !strings.HasPrefix(lines[i+len(expected)], "=== Step 2.3 finished in") {
+				t.Errorf("Expected the test step's logs together, got:\n%s", logs)
+			}
+			break
+		}
+	}
+
+	result, lines = run(map[string]string{"BREAK": "1"})
+	logs = strings.Join(lines, "\n")
+	if result.Status != JobStatusFailure || result.FailedPhase != PhaseCommand {
+		t.Errorf("Expected a failing parallel step to fail the job's command, got %+v", result)
+	}
+	for _, expected := range []string{"=== Step 2.5 failed after", "=== Parallel steps failed after", "> checks failed"} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected %q in the logs, got:\n%s", expected, logs)
+		}
+	}
+	if strings.Contains(logs, "checks passed") {
+		t.Errorf("Expected the step after the failed group to be skipped, got:\n%s", logs)
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	for expected, steps := range map[string][]StepConfig{
+		"step 1 has nothing to run":            {{If: "always()"}},
+		`invalid step name "a b"`:              {{Name: "a b", Run: "true"}},
+		`step name "a" is used more than once`: {{Name: "a", Run: "true"}, {Name: "a", Run: "true"}},
+		`condition for step a reads the outcome of "b", which isn't an earlier step`: {
+			{Name: "a", Run: "true", If: "steps.b.outcome == 'success'"},
+			{Name: "b", Run: "true"},
+		},
+		"step 1 can't have both run and parallel":  {{Run: "true", Parallel: []StepConfig{{Run: "true"}}}},
+		"step 1.1: parallel steps can't be nested": {{Parallel: []StepConfig{{Parallel: []StepConfig{{Run: "true"}}}}}},
+		`step name "c" is used more than once`:     {{Parallel: []StepConfig{{Name: "c", Run: "true"}, {Name: "c", Run: "true"}}}},
+		`condition for step b reads the outcome of "a", which isn't an earlier step`: {
+			{Parallel: []StepConfig{{Name: "a", Run: "true"}, {Name: "b", Run: "true", If: "steps.a.outcome == 'success'"}}},
+		},
+	} {
+		if _, err := parseSteps(steps); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestDescribeCheckout(t *testing.T) {
+	origin := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	git(origin, "init", "-q", "-b", "main")
+	os.WriteFile(filepath.Join(origin, "README.md"), []byte("readme"), 0o644)
+	git(origin, "add", ".")
+	git(origin, "commit", "-q", "-m", "first")
+	os.MkdirAll(filepath.Join(origin, "docs"), 0o755)
+	os.WriteFile(filepath.Join(origin, "docs", "guide.md"), []byte("guide"), 0o644)
+	git(origin, "add", ".")
+	git(origin, "commit", "-q", "-m", "second")
+	git(origin, "tag", "v1.0.0")
+	head := git(origin, "rev-parse", "HEAD")
+
+	ctx := context.Background()
+	for _, client := range gitClients {
+		t.Run(client.name, func(t *testing.T) {
+			clone := t.TempDir()
+			if err := client.client.Clone(ctx, origin, clone); err != nil {
+				t.Fatal(err)
+			}
+			for ref, expected := range map[string][2]string{
+				"main":             {"main", ""},
+				"HEAD":             {"main", ""},
+				"refs/heads/dev":   {"dev", ""},
+				"v1.0.0":           {"", "v1.0.0"},
+				"refs/tags/v2.0.0": {"", "v2.0.0"},
+				head:               {"", ""},
+			} {
+				branch, tag, err := client.client.DescribeRef(ctx, clone, ref)
+				if err != nil || branch != expected[0] || tag != expected[1] {
+					t.Errorf("Expected %s to be branch %q and tag %q, got %q, %q, %v", ref, expected[0], expected[1], branch, tag, err)
+				}
+			}
+
+			changed, err := client.client.ChangedFiles(ctx, clone)
+			if err != nil || strings.Join(changed, ",") != "docs/guide.md" {
+				t.Errorf("Expected the commit's changed files, got %v, %v", changed, err)
+			}
+			git(clone, "checkout", "-q", "HEAD^1")
+			changed, err = client.client.ChangedFiles(ctx, clone)
+			if err != nil || strings.Join(changed, ",") != "README.md" {
+				t.Errorf("Expected every file of the first commit, got %v, %v", changed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogShipper sends job log lines to a syslog server as RFC 5424 messages,
+// with the job_id, repo and commit as structured data
+type SyslogShipper struct {
+	// Address is udp://host:port, tcp://host:port or unix:///dev/log
+	Address string
+	// Facility is the facility code, such as 1 for user or 16 for local0
+	Facility int
+	// Tag is the app name, defaults to minici
+	Tag string
+
+	mutex sync.Mutex
+	conn  net.Conn
+	// stream is set for connections that need messages framed by length
+	stream bool
+}
+
+// syslogFacilities maps facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func parseSyslogFacility(name string) (int, error) {
+	if name == "" {
+		return syslogFacilities["user"], nil
+	}
+	facility, ok := syslogFacilities[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// syslogInfo is the severity lines are sent with
+const syslogInfo = 6
+
+func (s *SyslogShipper) Ship(ctx context.Context, entries []LogEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	for _, entry := range entries {
+		message := s.format(entry)
+		if s.stream {
+			message = fmt.Sprintf("%d %s", len(message), message)
+		}
+		if _, err := s.conn.Write([]byte(message)); err != nil {
+			// Reconnect on the next attempt
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SyslogShipper) dial(ctx context.Context) error {
+	u, err := url.Parse(s.Address)
+	if err != nil {
+		return fmt.Errorf("syslog: invalid address: %w", err)
+	}
+	var network, address string
+	switch u.Scheme {
+	case "udp", "tcp":
+		network, address = u.Scheme, u.Host
+	case "unix":
+		network, address = "unixgram", u.Path
+	default:
+		return fmt.Errorf("syslog: invalid address %q, expected udp://, tcp:// or unix://", s.Address)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+	s.conn = conn
+	s.stream = network == "tcp"
+	return nil
+}
+
+// format writes an entry as an RFC 5424 message
+func (s *SyslogShipper) format(entry LogEntry) string {
+	tag := s.Tag
+	if tag == "" {
+		tag = "minici"
+	}
+	data := fmt.Sprintf(`[minici@32473 job_id="%s"`, syslogEscape(string(entry.JobID)))
+	if entry.RepoURI != "" {
+		data += fmt.Sprintf(` repo="%s"`, syslogEscape(entry.RepoURI))
+	}
+	if entry.Commit != "" {
+		data += fmt.Sprintf(` commit="%s"`, syslogEscape(entry.Commit))
+	}
+	data += "]"
+	return fmt.Sprintf("<%d>1 %s %s %s - - %s %s\n",
+		s.Facility*8+syslogInfo, entry.Time.UTC().Format(time.RFC3339Nano), hostname(), tag, data, entry.Line)
+}
+
+// syslogEscape escapes the characters structured data values can't contain
+func syslogEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
@@ -0,0 +1,403 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IncludeConfig is a config a repo config includes, so repos can share the
+// same settings. Exactly one of Path and Template is set.
+type IncludeConfig struct {
+	// Path is a config file in the same repo, relative to its root
+	Path string `yaml:"path"`
+	// Template is the name of a config in the server's template store, such
+	// as "go-service"
+	Template string `yaml:"template"`
+	// With gives the included config's parameters
+	With map[string]string `yaml:"with"`
+}
+
+// templateFile is a config that can be included, with the parameters it
+// takes
+type templateFile struct {
+	RepoConfig `yaml:",inline"`
+	// Params are the parameters' defaults, null for parameters that must be
+	// given. Values are substituted for ${{ name }} in the rest of the file.
+	Params map[string]*string `yaml:"params"`
+}
+
+// maxIncludeDepth limits how deeply includes can nest
+const maxIncludeDepth = 10
+
+// templateParam matches the placeholders parameters are substituted for
+var templateParam = regexp.MustCompile(`\$\{\{\s*([^}\s]*)\s*\}\}`)
+
+// paramNamePattern matches valid parameter names
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateNamePattern matches template names, which may be in directories
+// but can't leave the store
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(/[A-Za-z0-9_][A-Za-z0-9_.-]*)*$`)
+
+// ErrTemplateNotFound is returned by template stores for templates they
+// don't have
+var ErrTemplateNotFound = errors.New("template not found")
+
+// TemplateStore provides the configs repo configs include by name
+type TemplateStore interface {
+	// Template returns the content of the named template
+	Template(ctx context.Context, name string) ([]byte, error)
+}
+
+// WithTemplates lets the repo configs of jobs the server runs itself include
+// templates from store
+func WithTemplates(store TemplateStore) Option {
+	return func(s *CIServer) {
+		s.templates = store
+	}
+}
+
+// DirTemplates keeps templates as YAML files in a directory, named without
+// their .yml or .yaml extension
+type DirTemplates struct {
+	Dir string
+}
+
+var _ TemplateStore = &DirTemplates{}
+
+func (d *DirTemplates) Template(ctx context.Context, name string) ([]byte, error) {
+	if !templateNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid template name %q", name)
+	}
+	files := []string{name + ".yml", name + ".yaml"}
+	if ext := path.Ext(name); ext == ".yml" || ext == ".yaml" {
+		files = []string{name}
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(filepath.Join(d.Dir, filepath.FromSlash(file)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+		}
+		return content, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+// DefaultTemplateRefresh is how often GitTemplates fetches its repo by
+// default
+const DefaultTemplateRefresh = 5 * time.Minute
+
+// GitTemplates keeps templates in a git repo, so changes to them can be
+// reviewed like any other code. The repo is cloned when a template is first
+// needed, and cloned again once Refresh has passed.
+type GitTemplates struct {
+	// RepoURI is the repo holding the templates
+	RepoURI string
+	// Ref is the branch, tag or commit to read, defaults to the repo's
+	// default branch
+	Ref string
+	// Dir is the directory in the repo holding the templates, defaults to
+	// its root
+	Dir string
+	// Refresh is how long a clone is used before cloning again, defaults to
+	// DefaultTemplateRefresh
+	Refresh time.Duration
+	// Git clones the repo, defaults to GitCLI
+	Git GitClient
+
+	mu      sync.Mutex
+	clone   string
+	fetched time.Time
+}
+
+var _ TemplateStore = &GitTemplates{}
+
+func (g *GitTemplates) Template(ctx context.Context, name string) ([]byte, error) {
+	if !templateNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid template name %q", name)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.update(ctx); err != nil {
+		return nil, err
+	}
+	dir := &DirTemplates{Dir: filepath.Join(g.clone, filepath.FromSlash(g.Dir))}
+	return dir.Template(ctx, name)
+}
+
+// update clones the repo if there's no clone yet or it's due a refresh. If
+// cloning again fails the previous clone is kept, so jobs don't fail while
+// the repo is unreachable.
+func (g *GitTemplates) update(ctx context.Context) error {
+	refresh := g.Refresh
+	if refresh <= 0 {
+		refresh = DefaultTemplateRefresh
+	}
+	if g.clone != "" && time.Since(g.fetched) < refresh {
+		return nil
+	}
+	git := g.Git
+	if git == nil {
+		git = GitCLI{}
+	}
+	dir, err := os.MkdirTemp("", "minici-templates-")
+	if err != nil {
+		return err
+	}
+	err = git.Clone(ctx, g.RepoURI, dir)
+	if err == nil && g.Ref != "" {
+		_, err = git.Checkout(ctx, dir, g.Ref)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		if g.clone != "" {
+			g.fetched = time.Now()
+			return nil
+		}
+		return fmt.Errorf("failed to clone template repo %s: %w", g.RepoURI, err)
+	}
+	if g.clone != "" {
+		os.RemoveAll(g.clone)
+	}
+	g.clone, g.fetched = dir, time.Now()
+	return nil
+}
+
+// Close removes the clone of the repo
+func (g *GitTemplates) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.clone == "" {
+		return nil
+	}
+	err := os.RemoveAll(g.clone)
+	g.clone = ""
+	return err
+}
+
+// configLoader reads a repo config and the configs it includes
+type configLoader struct {
+	// dir is the checked out repo
+	dir       string
+	templates TemplateStore
+}
+
+// load parses a config, substituting its parameters and merging the configs
+// it includes underneath it. chain lists the configs including it, outermost
+// first, and template is set if it came from the template store.
+func (l configLoader) load(ctx context.Context, name string, content []byte, with map[string]string, chain []string, template bool) (RepoConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return RepoConfig{}, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	var own RepoConfig
+	if len(root.Content) > 0 {
+		doc := root.Content[0]
+		values, err := paramValues(doc, with)
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		var unknown []string
+		substituteParams(doc, values, func(node *yaml.Node, param string) {
+			unknown = append(unknown, fmt.Sprintf("line %d: unknown parameter %q", node.Line, param))
+		})
+		if len(unknown) > 0 {
+			return RepoConfig{}, fmt.Errorf("failed to parse %s: %s", name, strings.Join(unknown, ", "))
+		}
+		if err := doc.Decode(&own); err != nil {
+			return RepoConfig{}, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+	} else if len(with) > 0 {
+		return RepoConfig{}, fmt.Errorf("failed to parse %s: it has no parameters", name)
+	}
+
+	return l.includes(ctx, name, own, chain, template)
+}
+
+// includes merges the configs a config includes underneath it
+func (l configLoader) includes(ctx context.Context, name string, own RepoConfig, chain []string, template bool) (RepoConfig, error) {
+	chain = append(chain, name)
+	var config RepoConfig
+	for i, include := range own.Include {
+		included, err := l.include(ctx, include, chain, template)
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("%s: include %d: %w", name, i+1, err)
+		}
+		config = config.merge(included)
+	}
+	return config.merge(own), nil
+}
+
+// include loads an included config
+func (l configLoader) include(ctx context.Context, include IncludeConfig, chain []string, template bool) (RepoConfig, error) {
+	if len(chain) >= maxIncludeDepth {
+		return RepoConfig{}, fmt.Errorf("includes are nested more than %d deep", maxIncludeDepth)
+	}
+	var name string
+	var content []byte
+	var err error
+	switch {
+	case include.Path != "" && include.Template != "":
+		return RepoConfig{}, errors.New("can't have both a path and a template")
+	case include.Path != "":
+		if template {
+			return RepoConfig{}, fmt.Errorf("templates can only include other templates, not %s", include.Path)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(include.Path)) {
+			return RepoConfig{}, fmt.Errorf("invalid path %q: must be relative to the repository root", include.Path)
+		}
+		name = path.Clean(filepath.ToSlash(include.Path))
+		if err := includeCycle(chain, name); err != nil {
+			return RepoConfig{}, err
+		}
+		content, err = os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(name)))
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	case include.Template != "":
+		if l.templates == nil {
+			return RepoConfig{}, fmt.Errorf("can't include template %q, no template store is configured", include.Template)
+		}
+		name = "template " + include.Template
+		if err := includeCycle(chain, name); err != nil {
+			return RepoConfig{}, err
+		}
+		content, err = l.templates.Template(ctx, include.Template)
+		if err != nil {
+			return RepoConfig{}, err
+		}
+	default:
+		return RepoConfig{}, errors.New("needs a path or a template")
+	}
+	return l.load(ctx, name, content, include.With, chain, include.Template != "")
+}
+
+// includeCycle returns an error if name already includes the config being
+// loaded
+func includeCycle(chain []string, name string) error {
+	for _, including := range chain {
+		if including == name {
+			return fmt.Errorf("include cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	return nil
+}
+
+// paramValues returns the values of the parameters a config declares, from
+// with or their defaults
+func paramValues(doc *yaml.Node, with map[string]string) (map[string]string, error) {
+	var params map[string]*string
+	if node := mappingValue(doc, "params"); node != nil {
+		if err := node.Decode(&params); err != nil {
+			return nil, err
+		}
+	}
+	for name := range with {
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	values := make(map[string]string, len(params))
+	for name, value := range params {
+		if !paramNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid parameter name %q: must only contain letters, digits and _", name)
+		}
+		given, ok := with[name]
+		switch {
+		case ok:
+			values[name] = given
+		case value != nil:
+			values[name] = *value
+		default:
+			return nil, fmt.Errorf("parameter %q is required", name)
+		}
+	}
+	return values, nil
+}
+
+// substituteParams replaces the ${{ name }} placeholders in a config's
+// scalars with values, except in its params, calling unknown for
+// placeholders without a value
+func substituteParams(doc *yaml.Node, values map[string]string, unknown func(node *yaml.Node, name string)) {
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${{") {
+			node.Value = templateParam.ReplaceAllStringFunc(node.Value, func(placeholder string) string {
+				name := templateParam.FindStringSubmatch(placeholder)[1]
+				value, ok := values[name]
+				if !ok {
+					unknown(node, name)
+					return placeholder
+				}
+				return value
+			})
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	if doc.Kind != yaml.MappingNode {
+		walk(doc)
+		return
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == "params" {
+			continue
+		}
+		walk(doc.Content[i])
+		walk(doc.Content[i+1])
+	}
+}
+
+// merge returns c with over's settings on top. Over's image and coverage
+// replace c's if set, env vars and inputs are combined with over's taking
+// precedence, and lists are appended to c's.
+func (c RepoConfig) merge(over RepoConfig) RepoConfig {
+	merged := c
+	if over.Image != "" {
+		merged.Image = over.Image
+	}
+	if over.Coverage != "" {
+		merged.Coverage = over.Coverage
+	}
+	if len(over.Env) > 0 {
+		merged.Env = make(map[string]string, len(c.Env)+len(over.Env))
+		for key, value := range c.Env {
+			merged.Env[key] = value
+		}
+		for key, value := range over.Env {
+			merged.Env[key] = value
+		}
+	}
+	if len(over.Inputs) > 0 {
+		merged.Inputs = make(map[string]InputConfig, len(c.Inputs)+len(over.Inputs))
+		for name, input := range c.Inputs {
+			merged.Inputs[name] = input
+		}
+		for name, input := range over.Inputs {
+			merged.Inputs[name] = input
+		}
+	}
+	merged.Setup = append(append([]string(nil), c.Setup...), over.Setup...)
+	merged.Artifacts = append(append([]string(nil), c.Artifacts...), over.Artifacts...)
+	merged.Cache = append(append([]CacheConfig(nil), c.Cache...), over.Cache...)
+	merged.Steps = append(append([]StepConfig(nil), c.Steps...), over.Steps...)
+	merged.OnFailure = append(append([]StepConfig(nil), c.OnFailure...), over.OnFailure...)
+	merged.Always = append(append([]StepConfig(nil), c.Always...), over.Always...)
+	merged.Images = append(append([]ImageConfig(nil), c.Images...), over.Images...)
+	merged.TestReports = append(append([]string(nil), c.TestReports...), over.TestReports...)
+	return merged
+}
@@ -0,0 +1,251 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRepoConfigIncludes(t *testing.T) {
+	templates := t.TempDir()
+	writeFiles(t, templates, map[string]string{
+		"go.yml": `params:
+  version: "1.24"
+  package: ~
+image: golang:${{ version }}
+env:
+  CGO_ENABLED: "0"
+  PACKAGE: ${{ package }}
+setup:
+  - go mod download
+include:
+  - template: base/lint
+`,
+		"base/lint.yaml": `steps:
+  - name: lint
+    run: go vet ./...
+`,
+	})
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		RepoConfigFile: `include:
+  - template: go
+    with:
+      package: ./cmd/app
+  - path: ci/release.yml
+env:
+  CGO_ENABLED: "1"
+setup:
+  - make generate
+`,
+		"ci/release.yml": `image: golang:1.25
+artifacts:
+  - dist/*
+`,
+	})
+
+	config, err := LoadRepoConfigWithTemplates(context.Background(), repo, &DirTemplates{Dir: templates})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := RepoConfig{
+		Image:     "golang:1.25",
+		Env:       map[string]string{"CGO_ENABLED": "1", "PACKAGE": "./cmd/app"},
+		Setup:     []string{"go mod download", "make generate"},
+		Artifacts: []string{"dist/*"},
+		Steps:     []StepConfig{{Name: "lint", Run: "go vet ./..."}},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected config %+v, got %+v", expected, config)
+	}
+}
+
+func TestLoadRepoConfigIncludeErrors(t *testing.T) {
+	templates := t.TempDir()
+	writeFiles(t, templates, map[string]string{
+		"required.yml": "params:\n  name: ~\nimage: ${{ name }}\n",
+		"unknown.yml":  "image: ${{ name }}\n",
+		"local.yml":    "include:\n  - path: ci/base.yml\n",
+		"loop.yml":     "include:\n  - template: loop\n",
+	})
+	tests := []struct {
+		name     string
+		config   string
+		files    map[string]string
+		expected string
+	}{
+		{
+			name:     "missing parameter",
+			config:   "include:\n  - template: required\n",
+			expected: `.minici.yml: include 1: failed to parse template required: parameter "name" is required`,
+		},
+		{
+			name:     "undeclared parameter",
+			config:   "include:\n  - template: required\n    with:\n      name: x\n      other: y\n",
+			expected: `.minici.yml: include 1: failed to parse template required: unknown parameter "other"`,
+		},
+		{
+			name:     "unknown placeholder",
+			config:   "include:\n  - template: unknown\n",
+			expected: `.minici.yml: include 1: failed to parse template unknown: line 1: unknown parameter "name"`,
+		},
+		{
+			name:     "missing template",
+			config:   "include:\n  - template: missing\n",
+			expected: `.minici.yml: include 1: template not found: missing`,
+		},
+		{
+			name:     "template including a path",
+			config:   "include:\n  - template: local\n",
+			expected: `.minici.yml: include 1: template local: include 1: templates can only include other templates, not ci/base.yml`,
+		},
+		{
+			name:     "cycle",
+			config:   "include:\n  - path: ci/a.yml\n",
+			files:    map[string]string{"ci/a.yml": "include:\n  - path: ci/b.yml\n", "ci/b.yml": "include:\n  - path: ./ci/a.yml\n"},
+			expected: `.minici.yml: include 1: ci/a.yml: include 1: ci/b.yml: include 1: include cycle: .minici.yml -> ci/a.yml -> ci/b.yml -> ci/a.yml`,
+		},
+		{
+			name:     "template cycle",
+			config:   "include:\n  - template: loop\n",
+			expected: `.minici.yml: include 1: template loop: include 1: include cycle: .minici.yml -> template loop -> template loop`,
+		},
+		{
+			name:     "path outside the repo",
+			config:   "include:\n  - path: ../other/.minici.yml\n",
+			expected: `.minici.yml: include 1: invalid path "../other/.minici.yml": must be relative to the repository root`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := t.TempDir()
+			files := map[string]string{RepoConfigFile: test.config}
+			for name, content := range test.files {
+				files[name] = content
+			}
+			writeFiles(t, repo, files)
+			_, err := LoadRepoConfigWithTemplates(context.Background(), repo, &DirTemplates{Dir: templates})
+			if err == nil || err.Error() != test.expected {
+				t.Errorf("Expected error %q, got %v", test.expected, err)
+			}
+		})
+	}
+
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{RepoConfigFile: "include:\n  - template: go\n"})
+	if _, err := LoadRepoConfig(repo); err == nil || !strings.Contains(err.Error(), "no template store is configured") {
+		t.Errorf("Expected an error without a template store, got %v", err)
+	}
+}
+
+func TestDirTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.yml": "image: golang\n", "node/app.yaml": "image: node\n"})
+	store := &DirTemplates{Dir: dir}
+	ctx := context.Background()
+
+	for name, expected := range map[string]string{"go": "image: golang\n", "go.yml": "image: golang\n", "node/app": "image: node\n"} {
+		content, err := store.Template(ctx, name)
+		if err != nil || string(content) != expected {
+			t.Errorf("Expected template %s to be %q, got %q, %v", name, expected, content, err)
+		}
+	}
+	if _, err := store.Template(ctx, "rust"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+	for _, name := range []string{"../go", "/go", "node/../go", ""} {
+		if _, err := store.Template(ctx, name); err == nil || errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("Expected template name %q to be invalid, got %v", name, err)
+		}
+	}
+}
+
+// templateGit clones a template repo whose go template has the current image
+type templateGit struct {
+	image  string
+	fail   bool
+	clones int
+	ref    string
+}
+
+func (g *templateGit) Clone(ctx context.Context, repoURI, dir string) error {
+	if g.fail {
+		return errors.New("unreachable")
+	}
+	g.clones++
+	if err := os.MkdirAll(filepath.Join(dir, "templates"), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "templates", "go.yml"), []byte("image: "+g.image+"\n"), 0o644)
+}
+
+func (g *templateGit) Checkout(ctx context.Context, dir, ref string) (string, error) {
+	g.ref = ref
+	return "0123abcd", nil
+}
+
+func TestGitTemplates(t *testing.T) {
+	git := &templateGit{image: "golang:1.24"}
+	store := &GitTemplates{RepoURI: "https://example.com/templates.git", Ref: "v1", Dir: "templates", Git: git}
+	defer store.Close()
+	ctx := context.Background()
+
+	template := func() string {
+		t.Helper()
+		content, err := store.Template(ctx, "go")
+		if err != nil {
+			t.Fatalf("Failed to read template: %v", err)
+		}
+		return string(content)
+	}
+	if content := template(); content != "image: golang:1.24\n" {
+		t.Errorf("Expected the cloned template, got %q", content)
+	}
+	if git.ref != "v1" {
+		t.Errorf("Expected v1 to be checked out, got %q", git.ref)
+	}
+
+	// The clone is reused until it's due a refresh
+	git.image = "golang:1.25"
+	if content := template(); content != "image: golang:1.24\n" || git.clones != 1 {
+		t.Errorf("Expected the first clone to be reused, got %q after %d clones", content, git.clones)
+	}
+	store.Refresh = time.Nanosecond
+	if content := template(); content != "image: golang:1.25\n" || git.clones != 2 {
+		t.Errorf("Expected the repo to be cloned again, got %q after %d clones", content, git.clones)
+	}
+
+	// Failing to refresh keeps the previous clone
+	git.fail = true
+	if content := template(); content != "image: golang:1.25\n" {
+		t.Errorf("Expected the previous clone to be kept, got %q", content)
+	}
+
+	store.Close()
+	if _, err := store.Template(ctx, "go"); err == nil {
+		t.Errorf("Expected an error cloning an unreachable repo")
+	}
+}
+
+func TestTemplatesInJobs(t *testing.T) {
+	templates := t.TempDir()
+	writeFiles(t, templates, map[string]string{"greet.yml": "params:\n  name: world\nsetup:\n  - echo hello ${{ name }}\n"})
+	git := &configGit{config: "include:\n  - template: greet\n    with:\n      name: templates\n"}
+	var lines []string
+	job := Job{ID: "01ABC", RepoURI: "https://example.com/repo.git", Commit: "main", Command: "echo command"}
+	result := RunJob(context.Background(), job, &LocalExecutor{}, JobStores{Git: git, Templates: &DirTemplates{Dir: templates}}, func(line string) {
+		lines = append(lines, line)
+	})
+	logs := strings.Join(lines, "\n")
+	if result.Status != JobStatusSuccess {
+		t.Fatalf("Expected the job to succeed, got %+v: %s", result, logs)
+	}
+	if !strings.Contains(logs, "> hello templates") {
+		t.Errorf("Expected the template's setup command in the logs, got:\n%s", logs)
+	}
+}
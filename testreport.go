@@ -0,0 +1,348 @@
+package minici
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestStatus is the outcome of a single test
+type TestStatus string
+
+const (
+	TestPassed  TestStatus = "passed"
+	TestFailed  TestStatus = "failed"
+	TestSkipped TestStatus = "skipped"
+)
+
+// maxTestMessage limits how much of a failing test's output is kept
+const maxTestMessage = 4096
+
+// TestResult is one test from a job's test reports
+type TestResult struct {
+	// Suite is the test's package or class
+	Suite    string
+	Name     string
+	Status   TestStatus
+	Duration time.Duration
+	// Message explains why the test failed
+	Message string
+}
+
+// TestSummary counts a job's test results
+type TestSummary struct {
+	Passed  int
+	Failed  int
+	Skipped int
+	// Failures are the tests that failed, in report order
+	Failures []TestResult
+}
+
+// SummarizeTests counts the results by status
+func SummarizeTests(results []TestResult) TestSummary {
+	var summary TestSummary
+	for _, result := range results {
+		switch result.Status {
+		case TestPassed:
+			summary.Passed++
+		case TestFailed:
+			summary.Failed++
+			summary.Failures = append(summary.Failures, result)
+		case TestSkipped:
+			summary.Skipped++
+		}
+	}
+	return summary
+}
+
+// ParseTestReport reads a JUnit XML report, or the output of go test -json
+func ParseTestReport(r io.Reader) ([]TestResult, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return parseJUnit(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return parseGoTestJSON(trimmed)
+	case len(trimmed) == 0:
+		return nil, nil
+	}
+	return nil, errors.New("unrecognized test report, expected JUnit XML or go test -json output")
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// parseJUnit accepts either a testsuites or a single testsuite root element
+func parseJUnit(content []byte) ([]TestResult, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("invalid JUnit report: %w", err)
+	}
+	var results []TestResult
+	var walk func(suite junitSuite)
+	walk = func(suite junitSuite) {
+		for _, c := range suite.Cases {
+			result := TestResult{Suite: c.Classname, Name: c.Name, Status: TestPassed}
+			if result.Suite == "" {
+				result.Suite = suite.Name
+			}
+			if seconds, err := strconv.ParseFloat(c.Time, 64); err == nil {
+				result.Duration = time.Duration(seconds * float64(time.Second))
+			}
+			failure := c.Failure
+			if failure == nil {
+				failure = c.Error
+			}
+			switch {
+			case failure != nil:
+				result.Status = TestFailed
+				result.Message = truncateMessage(strings.TrimSpace(failure.Message + "\n" + failure.Text))
+			case c.Skipped != nil:
+				result.Status = TestSkipped
+			}
+			results = append(results, result)
+		}
+		for _, child := range suite.Suites {
+			walk(child)
+		}
+	}
+	walk(root)
+	return results, nil
+}
+
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseGoTestJSON reads the events written by go test -json, keeping the
+// output of failed tests as their message
+func parseGoTestJSON(content []byte) ([]TestResult, error) {
+	var results []TestResult
+	output := map[string]*strings.Builder{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var event goTestEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("invalid go test -json output: %w", err)
+		}
+		if event.Test == "" {
+			continue
+		}
+		key := event.Package + "\x00" + event.Test
+		var status TestStatus
+		switch event.Action {
+		case "output":
+			if output[key] == nil {
+				output[key] = &strings.Builder{}
+			}
+			if output[key].Len() < maxTestMessage {
+				output[key].WriteString(event.Output)
+			}
+			continue
+		case "pass":
+			status = TestPassed
+		case "fail":
+			status = TestFailed
+		case "skip":
+			status = TestSkipped
+		default:
+			continue
+		}
+		result := TestResult{
+			Suite:    event.Package,
+			Name:     event.Test,
+			Status:   status,
+			Duration: time.Duration(event.Elapsed * float64(time.Second)),
+		}
+		if status == TestFailed && output[key] != nil {
+			result.Message = truncateMessage(strings.TrimSpace(output[key].String()))
+		}
+		delete(output, key)
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+func truncateMessage(message string) string {
+	if len(message) > maxTestMessage {
+		return message[:maxTestMessage]
+	}
+	return message
+}
+
+// collectTestReports parses the test reports in dir matching patterns. Reports
+// that can't be read are logged rather than failing the job, since the job's
+// own status already says whether its tests passed.
+func collectTestReports(dir string, patterns []string, log func(string)) []TestResult {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		log(err.Error())
+		return nil
+	}
+	var results []TestResult
+	for _, pattern := range patterns {
+		if !filepath.IsLocal(filepath.FromSlash(pattern)) {
+			log(fmt.Sprintf("Invalid test report pattern %q: must be relative to the repository root", pattern))
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			log(fmt.Sprintf("Invalid test report pattern %q: %v", pattern, err))
+			continue
+		}
+		if len(matches) == 0 {
+			log("No test reports matched " + pattern)
+		}
+		for _, match := range matches {
+			name, _ := filepath.Rel(dir, match)
+			parsed, err := readTestReport(root, match)
+			if err != nil {
+				log(fmt.Sprintf("Failed to read test report %s: %v", name, err))
+				continue
+			}
+			summary := SummarizeTests(parsed)
+			log(fmt.Sprintf("Read test report %s: %d passed, %d failed, %d skipped", name, summary.Passed, summary.Failed, summary.Skipped))
+			results = append(results, parsed...)
+		}
+	}
+	return results
+}
+
+func readTestReport(root, file string) ([]TestResult, error) {
+	f, err := openReport(root, file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTestReport(f)
+}
+
+// openReport opens a report written by a job, refusing symlinks so a job
+// can't read files from outside its workspace
+func openReport(root, file string) (*os.File, error) {
+	if err := checkWithin(root, filepath.Dir(file)); err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(file)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+	return os.Open(file)
+}
+
+// FlakyTest is a test that has both passed and failed in a repo's recent jobs
+type FlakyTest struct {
+	Suite string
+	Name  string
+	// Passed and Failed count the recent jobs in which the test had each outcome
+	Passed int
+	Failed int
+	// Flips is how many times the outcome changed from one job to the next
+	Flips int
+	// LastFailed is the most recent job in which the test failed
+	LastFailed JobID
+}
+
+// FlakyTests finds the tests in a repo's most recent jobs with test results
+// whose outcome flipped between passing and failing more than once. A test
+// that broke and stayed broken, or was fixed, flips only once.
+func FlakyTests(jobs []Job, repo string, recent int) []FlakyTest {
+	var history []Job
+	for _, job := range jobs {
+		if job.RepoURI == repo && len(job.Tests) > 0 && (job.Status == JobStatusSuccess || job.Status == JobStatusFailure) {
+			history = append(history, job)
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].FinishedAt.Before(history[j].FinishedAt)
+	})
+	if recent > 0 && len(history) > recent {
+		history = history[len(history)-recent:]
+	}
+
+	type testKey struct{ suite, name string }
+	byTest := map[testKey]*FlakyTest{}
+	last := map[testKey]TestStatus{}
+	var order []testKey
+	for _, job := range history {
+		for _, result := range job.Tests {
+			if result.Status == TestSkipped {
+				continue
+			}
+			key := testKey{result.Suite, result.Name}
+			test, ok := byTest[key]
+			if !ok {
+				test = &FlakyTest{Suite: result.Suite, Name: result.Name}
+				byTest[key] = test
+				order = append(order, key)
+			}
+			if previous, ok := last[key]; ok && previous != result.Status {
+				test.Flips++
+			}
+			last[key] = result.Status
+			if result.Status == TestPassed {
+				test.Passed++
+			} else {
+				test.Failed++
+				test.LastFailed = job.ID
+			}
+		}
+	}
+
+	var flaky []FlakyTest
+	for _, key := range order {
+		if test := byTest[key]; test.Flips > 1 {
+			flaky = append(flaky, *test)
+		}
+	}
+	sort.SliceStable(flaky, func(i, j int) bool {
+		if flaky[i].Flips != flaky[j].Flips {
+			return flaky[i].Flips > flaky[j].Flips
+		}
+		return flaky[i].Failed > flaky[j].Failed
+	})
+	return flaky
+}
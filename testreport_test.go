@@ -0,0 +1,142 @@
+package minici
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJUnit(t *testing.T) {
+	results, err := ParseTestReport(strings.NewReader(`<?xml version="1.0"?>
+<testsuites>
+  <testsuite name="api">
+    <testcase classname="api.Users" name="creates" time="0.5"/>
+    <testcase classname="api.Users" name="deletes" time="1">
+      <failure message="expected 204">got 500</failure>
+    </testcase>
+    <testsuite name="nested">
+      <testcase name="skips"><skipped/></testcase>
+      <testcase name="errors"><error message="panic"/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TestResult{
+		{Suite: "api.Users", Name: "creates", Status: TestPassed, Duration: 500 * time.Millisecond},
+		{Suite: "api.Users", Name: "deletes", Status: TestFailed, Duration: time.Second, Message: "expected 204\ngot 500"},
+		{Suite: "nested", Name: "skips", Status: TestSkipped},
+		{Suite: "nested", Name: "errors", Status: TestFailed, Message: "panic"},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %+v", len(expected), results)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], results[i])
+		}
+	}
+}
+
+func TestParseGoTestJSON(t *testing.T) {
+	results, err := ParseTestReport(strings.NewReader(`{"Action":"run","Package":"example.com/app","Test":"TestOK"}
+{"Action":"pass","Package":"example.com/app","Test":"TestOK","Elapsed":0.25}
+{"Action":"output","Package":"example.com/app","Test":"TestBad","Output":"    app_test.go:12: wrong answer\n"}
+{"Action":"fail","Package":"example.com/app","Test":"TestBad","Elapsed":1}
+{"Action":"skip","Package":"example.com/app","Test":"TestLater"}
+{"Action":"fail","Package":"example.com/app","Elapsed":1.3}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := SummarizeTests(results)
+	if summary.Passed != 1 || summary.Failed != 1 || summary.Skipped != 1 {
+		t.Fatalf("Expected one of each status, got %+v", summary)
+	}
+	if failure := summary.Failures[0]; failure.Name != "TestBad" || failure.Message != "app_test.go:12: wrong answer" {
+		t.Errorf("Expected the failure's output as its message, got %+v", failure)
+	}
+
+	if _, err := ParseTestReport(strings.NewReader("ok example.com/app")); err == nil {
+		t.Error("Expected plain go test output to be rejected")
+	}
+}
+
+func TestCollectTestReports(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"reports/unit.xml": `<testsuite name="unit"><testcase name="a"/><testcase name="b"><failure/></testcase></testsuite>`,
+		"reports/bad.xml":  `<testsuite`,
+	})
+	secret := filepath.Join(t.TempDir(), "secret.xml")
+	if err := os.WriteFile(secret, []byte(`<testsuite><testcase name="stolen"/></testsuite>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "reports", "link.xml")); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	results := collectTestReports(dir, []string{"reports/*.xml", "missing/*.xml", "../*.xml"}, func(line string) {
+		lines = append(lines, line)
+	})
+	if len(results) != 2 {
+		t.Errorf("Expected only the valid report to be read, got %+v", results)
+	}
+	output := strings.Join(lines, "\n")
+	for _, expected := range []string{
+		"Read test report " + filepath.Join("reports", "unit.xml") + ": 1 passed, 1 failed, 0 skipped",
+		"Failed to read test report " + filepath.Join("reports", "bad.xml"),
+		"Failed to read test report " + filepath.Join("reports", "link.xml") + ": not a regular file",
+		"No test reports matched missing/*.xml",
+		`Invalid test report pattern "../*.xml"`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestFlakyTests(t *testing.T) {
+	now := time.Now()
+	var jobs []Job
+	// The flaky test alternates, the broken one fails from the third job on
+	for i, flaky := range []TestStatus{TestPassed, TestFailed, TestPassed, TestFailed, TestPassed} {
+		broken := TestPassed
+		if i >= 2 {
+			broken = TestFailed
+		}
+		jobs = append(jobs, Job{
+			ID:         JobID(string(rune('a' + i))),
+			RepoURI:    "repo",
+			Status:     JobStatusFailure,
+			FinishedAt: now.Add(time.Duration(i) * time.Minute),
+			Tests: []TestResult{
+				{Name: "TestFlaky", Status: flaky},
+				{Name: "TestBroken", Status: broken},
+				{Name: "TestSkipped", Status: TestSkipped},
+			},
+		})
+	}
+	// Other repos and cancelled jobs are ignored
+	jobs = append(jobs,
+		Job{ID: "other", RepoURI: "other", Status: JobStatusFailure, Tests: []TestResult{{Name: "TestBroken", Status: TestPassed}}},
+		Job{ID: "cancelled", RepoURI: "repo", Status: JobStatusCancelled, FinishedAt: now.Add(time.Hour), Tests: []TestResult{{Name: "TestBroken", Status: TestPassed}}},
+	)
+
+	flaky := FlakyTests(jobs, "repo", 0)
+	if len(flaky) != 1 {
+		t.Fatalf("Expected one flaky test, got %+v", flaky)
+	}
+	expected := FlakyTest{Name: "TestFlaky", Passed: 3, Failed: 2, Flips: 4, LastFailed: "d"}
+	if flaky[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, flaky[0])
+	}
+
+	if flaky := FlakyTests(jobs, "repo", 2); len(flaky) != 0 {
+		t.Errorf("Expected a single flip in the last two jobs not to count, got %+v", flaky)
+	}
+}
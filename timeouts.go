@@ -0,0 +1,90 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JobPhase is a step of running a job, recorded when a job fails so that
+// problems fetching the repo can be told apart from failing commands
+type JobPhase string
+
+const (
+	PhaseClone    JobPhase = "clone"
+	PhaseCheckout JobPhase = "checkout"
+	// PhasePrepare loads the repo's config and restores its caches and needed artifacts
+	PhasePrepare JobPhase = "prepare"
+	// PhaseCommand runs the repo's setup commands and the job's command
+	PhaseCommand JobPhase = "command"
+	// PhasePublish saves the job's artifacts and pushes its images
+	PhasePublish JobPhase = "publish"
+)
+
+// Timeouts limit how long each phase of a job can take. A job that runs out of
+// time fails, with TimedOut set. Zero is unlimited.
+type Timeouts struct {
+	Clone    time.Duration
+	Checkout time.Duration
+	// Command limits the repo's setup commands and the job's command together
+	Command time.Duration
+	// Stall stops the command phase if it goes this long without producing
+	// output, so a hung command fails early rather than waiting out Command.
+	// Jobs can set their own with JobSpec.StallTimeout.
+	Stall time.Duration
+}
+
+// errStalled is the cause of a command being stopped for producing no output
+var errStalled = errors.New("no output")
+
+// WithTimeouts limits how long each phase of a job run on the server can take
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *CIServer) {
+		s.timeouts = timeouts
+	}
+}
+
+// runPhase calls f with a context limited to timeout, if set. It reports
+// whether f ran out of time, rather than the job being cancelled, logging
+// that it did.
+func runPhase(ctx context.Context, phase JobPhase, timeout time.Duration, log func(string), f func(ctx context.Context) error) (timedOut bool, err error) {
+	if timeout <= 0 {
+		return false, f(ctx)
+	}
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = f(phaseCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+		log(fmt.Sprintf("The %s phase timed out after %s", phase, timeout))
+		return true, err
+	}
+	return false, err
+}
+
+// runWatched calls f with a workspace whose log restarts a timer on each line,
+// cancelling f's context if the timer runs out first. It reports whether f
+// was stopped for going quiet, logging that it was.
+func runWatched(ctx context.Context, timeout time.Duration, workspace Workspace, f func(ctx context.Context, workspace Workspace) error) (stalled bool, err error) {
+	if timeout <= 0 {
+		return false, f(ctx, workspace)
+	}
+	watchCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timer := time.AfterFunc(timeout, func() {
+		cancel(errStalled)
+	})
+	defer timer.Stop()
+
+	log := workspace.Log
+	workspace.Log = func(line string) {
+		timer.Reset(timeout)
+		log(line)
+	}
+	err = f(watchCtx, workspace)
+	if err != nil && ctx.Err() == nil && errors.Is(context.Cause(watchCtx), errStalled) {
+		log(fmt.Sprintf("The command produced no output for %s and was stopped", timeout))
+		return true, err
+	}
+	return false, err
+}
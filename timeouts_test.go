@@ -0,0 +1,106 @@
+package minici
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stuckGit is a git server that never answers until the clone is cancelled
+type stuckGit struct {
+	fakeGit
+}
+
+func (g *stuckGit) Clone(ctx context.Context, repoURI, dir string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeouts(t *testing.T) {
+	timeouts := Timeouts{Clone: 20 * time.Millisecond, Command: 100 * time.Millisecond}
+	newServer := func(git GitClient) CI {
+		return NewCIServer(
+			WithGitClient(git),
+			WithExecutor(&LocalExecutor{}),
+			WithTimeouts(timeouts),
+			WithJobStarter(func(run func()) { run() }),
+		)
+	}
+
+	ci := newServer(&stuckGit{})
+	id := ci.ScheduleJob("https://example.com/repo.git", "main", "true")
+	job := ci.JobDetail(id)
+	if job.Status != JobStatusFailure || job.FailedPhase != PhaseClone || !job.TimedOut {
+		t.Errorf("Expected a stuck clone to time out, got %s in %q, timed out %v", job.Status, job.FailedPhase, job.TimedOut)
+	}
+	if !strings.Contains(strings.Join(ci.JobLogs(id), "\n"), "The clone phase timed out after 20ms") {
+		t.Errorf("Expected the timeout to be logged, got %q", ci.JobLogs(id))
+	}
+
+	ci = newServer(&fakeGit{})
+	for _, test := range []struct {
+		ref, command string
+		phase        JobPhase
+		timedOut     bool
+	}{
+		{ref: "main", command: "sleep 5", phase: PhaseCommand, timedOut: true},
+		{ref: "main", command: "false", phase: PhaseCommand},
+		{ref: "missing", command: "true", phase: PhaseCheckout},
+		{ref: "main", command: "true"},
+	} {
+		start := time.Now()
+		job := ci.JobDetail(ci.ScheduleJob("https://example.com/repo.git", test.ref, test.command))
+		if job.FailedPhase != test.phase || job.TimedOut != test.timedOut {
+			t.Errorf("%s at %s: expected phase %q, timed out %v, got %q, %v", test.command, test.ref, test.phase, test.timedOut, job.FailedPhase, job.TimedOut)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s at %s: expected the command timeout to stop the job, took %s", test.command, test.ref, elapsed)
+		}
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	ci := NewCIServer(
+		WithGitClient(&fakeGit{}),
+		WithExecutor(&LocalExecutor{}),
+		WithTimeouts(Timeouts{Stall: 200 * time.Millisecond}),
+		WithJobStarter(func(run func()) { run() }),
+	)
+
+	for _, test := range []struct {
+		command string
+		stall   time.Duration
+		stalled bool
+	}{
+		{command: "sleep 5", stalled: true},
+		{command: "for i in 1 2 3 4 5 6; do echo $i; sleep 0.05; done"},
+		{command: "sleep 0.3", stall: time.Second},
+		{command: "false"},
+	} {
+		start := time.Now()
+		id, err := ci.Submit(JobSpec{RepoURI: "https://example.com/repo.git", Commit: "main", Command: test.command, StallTimeout: test.stall})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := ci.JobDetail(id)
+		if job.Stalled != test.stalled || job.TimedOut {
+			t.Errorf("%s: expected stalled %v, got %v, timed out %v", test.command, test.stalled, job.Stalled, job.TimedOut)
+		}
+		if test.stalled {
+			if job.Status != JobStatusFailure || job.FailedPhase != PhaseCommand {
+				t.Errorf("%s: expected a stalled command to fail, got %s in %q", test.command, job.Status, job.FailedPhase)
+			}
+			if !strings.Contains(strings.Join(ci.JobLogs(id), "\n"), "The command produced no output for 200ms and was stopped") {
+				t.Errorf("%s: expected the stall to be logged, got %q", test.command, ci.JobLogs(id))
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: expected the stall timeout to stop the job, took %s", test.command, elapsed)
+		}
+	}
+
+	if _, err := ci.Submit(JobSpec{RepoURI: "https://example.com/repo.git", Commit: "main", Command: "true", StallTimeout: -time.Second}); err == nil {
+		t.Error("Expected a negative stall timeout to be rejected")
+	}
+}
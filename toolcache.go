@@ -0,0 +1,90 @@
+package minici
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ContainerCacheDir is where tool caches are mounted inside containers, as a
+// directory per environment variable
+const ContainerCacheDir = "/minici/cache"
+
+// DefaultToolCaches are the environment variables given a tool cache if none
+// are configured
+var DefaultToolCaches = []string{"GOMODCACHE", "GOCACHE", "PIP_CACHE_DIR", "npm_config_cache"}
+
+// ToolCaches are directories on the machine running jobs that tools like Go
+// and pip download into, so each fresh clone doesn't download everything
+// again. Unlike build caches they are used directly rather than restored, so
+// concurrent jobs share them and changes are kept even if a job fails.
+type ToolCaches struct {
+	// Dir contains the cache directories
+	Dir string
+	// Env lists the environment variables that point tools at their cache,
+	// such as GOMODCACHE. Defaults to DefaultToolCaches.
+	Env []string
+	// Shared lists variables whose cache is used by every repo. Other caches
+	// are separate for each repo, so one repo can't change what another's
+	// jobs run. Variables listed here don't also need to be listed in Env.
+	Shared []string
+}
+
+// WithToolCaches gives jobs the server runs itself the tool caches in caches
+func WithToolCaches(caches *ToolCaches) Option {
+	return func(s *CIServer) {
+		s.tools = caches
+	}
+}
+
+// dirs creates the repo's cache directories, returning them by environment variable
+func (c *ToolCaches) dirs(repo string) (map[string]string, error) {
+	sum := sha256.Sum256([]byte(repo))
+	repoDir := filepath.Join(c.Dir, "repos", hex.EncodeToString(sum[:8]))
+
+	env := c.Env
+	if len(env) == 0 {
+		env = DefaultToolCaches
+	}
+	dirs := map[string]string{}
+	for _, key := range env {
+		dirs[key] = filepath.Join(repoDir, key)
+	}
+	for _, key := range c.Shared {
+		dirs[key] = filepath.Join(c.Dir, "shared", key)
+	}
+	for key, dir := range dirs {
+		if key == "" || filepath.Base(key) != key || !filepath.IsLocal(key) {
+			return nil, fmt.Errorf("invalid tool cache variable %q", key)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create tool cache: %w", err)
+		}
+	}
+	return dirs, nil
+}
+
+// cacheEnv adds variables pointing at the workspace's tool caches to env,
+// where cachePath gives each cache's path as the command sees it. Variables
+// already set in env are left alone, so a job can opt out of a cache.
+func cacheEnv(env map[string]string, workspace Workspace, cachePath func(key, dir string) string) map[string]string {
+	if len(workspace.Caches) == 0 {
+		return env
+	}
+	merged := make(map[string]string, len(env)+len(workspace.Caches))
+	for key, dir := range workspace.Caches {
+		merged[key] = cachePath(key, dir)
+	}
+	for key, value := range env {
+		merged[key] = value
+	}
+	return merged
+}
+
+// containerCachePath returns where a tool cache is mounted in containers
+func containerCachePath(key, dir string) string {
+	return path.Join(ContainerCacheDir, key)
+}
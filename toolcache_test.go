@@ -0,0 +1,63 @@
+package minici
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToolCaches(t *testing.T) {
+	caches := &ToolCaches{Dir: t.TempDir(), Env: []string{"GOCACHE"}, Shared: []string{"GOMODCACHE"}}
+	first, err := caches.dirs("https://example.com/first.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := caches.dirs("https://example.com/second.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first["GOCACHE"] == second["GOCACHE"] {
+		t.Errorf("Expected each repo to have its own GOCACHE, got %v and %v", first, second)
+	}
+	if first["GOMODCACHE"] != filepath.Join(caches.Dir, "shared", "GOMODCACHE") || first["GOMODCACHE"] != second["GOMODCACHE"] {
+		t.Errorf("Expected GOMODCACHE to be shared, got %v and %v", first, second)
+	}
+
+	defaults, err := (&ToolCaches{Dir: t.TempDir()}).dirs("repo")
+	if err != nil || len(defaults) != len(DefaultToolCaches) {
+		t.Errorf("Expected the default caches, got %v, %v", defaults, err)
+	}
+	if _, err := (&ToolCaches{Dir: t.TempDir(), Env: []string{"../GOCACHE"}}).dirs("repo"); err == nil {
+		t.Error("Expected an invalid variable to return an error")
+	}
+}
+
+func TestToolCachesContainer(t *testing.T) {
+	var lines []string
+	dir := t.TempDir()
+	workspace := Workspace{JobID: "01ABC", Dir: dir, Log: func(line string) {
+		lines = append(lines, line)
+	}, Caches: map[string]string{"FOO": "/var/cache/foo", "GOCACHE": "/var/cache/go"}}
+	executor := &ContainerExecutor{Runtime: fakeRuntime(t, "docker")}
+
+	// The job's own GOCACHE takes precedence over the cache
+	_, err := executor.Run(context.Background(), workspace, JobSpec{
+		Command: "go test ./...",
+		Image:   "golang:1.24",
+		Env:     map[string]string{"GOCACHE": "/tmp/go"},
+	})
+	if err != nil {
+		t.Fatalf("Expected command to succeed, got %v", err)
+	}
+
+	expectedArgs := "> args: run --rm --name minici-01abc --volume " + dir + ":/workspace --workdir /workspace " +
+		"--volume /var/cache/foo:/minici/cache/FOO --volume /var/cache/go:/minici/cache/GOCACHE --env FOO --env GOCACHE --env MINICI_WORKSPACE golang:1.24 go test ./..."
+	output := strings.Join(lines, "\n")
+	if !strings.Contains(output, expectedArgs) {
+		t.Errorf("Expected runtime to be called with %q, got:\n%s", expectedArgs, output)
+	}
+	if !strings.Contains(output, "> FOO=/minici/cache/FOO") {
+		t.Errorf("Expected the cache variable to point at its mount, got:\n%s", output)
+	}
+}
@@ -0,0 +1,62 @@
+package minici
+
+import (
+	"context"
+	"fmt"
+)
+
+// WaitForJobs blocks until each of the jobs has finished, returning them in
+// the order given. Without IDs, it waits until at least one job has been
+// scheduled and none are pending or running, returning every job oldest
+// first. It returns ctx's error if ctx is done first.
+func (s *CIServer) WaitForJobs(ctx context.Context, ids ...JobID) ([]Job, error) {
+	for {
+		s.jobMutex.RLock()
+		jobs, done, err := s.waitingFor(ids)
+		changed := s.jobsChanged
+		s.jobMutex.RUnlock()
+		if err != nil || done {
+			return jobs, err
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitingFor returns the jobs WaitForJobs waits for, and whether they have all
+// finished. The caller must hold jobMutex.
+func (s *CIServer) waitingFor(ids []JobID) ([]Job, bool, error) {
+	var jobs []Job
+	if len(ids) == 0 {
+		for _, job := range s.jobs {
+			jobs = append(jobs, *job)
+		}
+		jobs, _ = pageJobs(jobs, JobFilter{})
+	} else {
+		for _, id := range ids {
+			job, ok := s.jobs[id]
+			if !ok {
+				return nil, false, fmt.Errorf("job %s: %w", id, ErrJobNotFound)
+			}
+			jobs = append(jobs, *job)
+		}
+	}
+
+	for _, job := range jobs {
+		if !job.Status.Done() {
+			return jobs, false, nil
+		}
+	}
+	return jobs, len(jobs) > 0, nil
+}
+
+// jobsUpdated wakes anything waiting in WaitForJobs. The caller must hold
+// jobMutex for writing.
+func (s *CIServer) jobsUpdated() {
+	close(s.jobsChanged)
+	s.jobsChanged = make(chan struct{})
+}
@@ -0,0 +1,55 @@
+package minici
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForJobs(t *testing.T) {
+	git := &blockingGit{started: make(chan struct{}, 2), release: make(chan struct{})}
+	ci := NewCIServer(WithGitClient(git), WithExecutor(&LocalExecutor{}))
+
+	// Waiting for every job waits for one to be scheduled
+	all := make(chan []Job, 1)
+	go func() {
+		jobs, err := ci.WaitForJobs(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		all <- jobs
+	}()
+
+	first := ci.ScheduleJob("https://example.com/repo.git", "main", "true")
+	second := ci.ScheduleJob("https://example.com/repo.git", "main", "false")
+	<-git.started
+	<-git.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ci.WaitForJobs(ctx, first); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected waiting for a running job to time out, got %v", err)
+	}
+	if _, err := ci.WaitForJobs(context.Background(), "missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected waiting for an unknown job to fail, got %v", err)
+	}
+
+	close(git.release)
+	jobs, err := ci.WaitForJobs(context.Background(), second, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].ID != second || jobs[0].Status != JobStatusFailure || jobs[1].ID != first || jobs[1].Status != JobStatusSuccess {
+		t.Errorf("Expected both jobs to have finished in the order given, got %+v", jobs)
+	}
+
+	select {
+	case jobs := <-all:
+		if len(jobs) != 2 || jobs[0].ID != first {
+			t.Errorf("Expected every job oldest first, got %+v", jobs)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected waiting for every job to finish")
+	}
+}
@@ -0,0 +1,199 @@
+// Package webhookverify checks that webhook requests were sent by whoever
+// holds a shared secret, for the signature schemes used by GitHub, GitLab,
+// Gitea and minici's own webhooks. It only needs the standard library, so it
+// can be used behind any router.
+package webhookverify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// GitHubHeader carries GitHub's HMAC-SHA256 of the body, as "sha256=<hex>"
+	GitHubHeader = "X-Hub-Signature-256"
+	// GitLabHeader carries the secret token configured for a GitLab webhook
+	GitLabHeader = "X-Gitlab-Token"
+	// GiteaHeader carries Gitea's HMAC-SHA256 of the body, as bare hex
+	GiteaHeader = "X-Gitea-Signature"
+	// MiniciHeader carries the HMAC-SHA256 of minici's webhook bodies, as
+	// "sha256=<hex>"
+	MiniciHeader = "X-Minici-Signature-256"
+
+	// DefaultMaxBodySize limits the bodies Middleware reads, 25MB like GitHub's
+	// payload limit
+	DefaultMaxBodySize = 25 << 20
+)
+
+var (
+	// ErrMissingSignature is returned when a request has no signature header
+	ErrMissingSignature = errors.New("webhook request isn't signed")
+	// ErrInvalidSignature is returned when a signature doesn't match the body
+	ErrInvalidSignature = errors.New("webhook signature doesn't match")
+	// ErrBodyTooLarge is returned by Request for bodies over its limit
+	ErrBodyTooLarge = errors.New("webhook body is too large")
+)
+
+// Verifier checks a webhook request's signature against its body
+type Verifier interface {
+	Verify(header http.Header, body []byte) error
+}
+
+// HMAC verifies a header holding the hex encoded HMAC of the body, after an
+// optional prefix. It covers most generic webhook senders.
+type HMAC struct {
+	Secret string
+	Header string
+	// Prefix comes before the hex digest, such as "sha256="
+	Prefix string
+	// Hash defaults to SHA-256
+	Hash func() hash.Hash
+}
+
+// Sign returns the header value for a body
+func (h HMAC) Sign(body []byte) string {
+	hashFunc := h.Hash
+	if hashFunc == nil {
+		hashFunc = sha256.New
+	}
+	mac := hmac.New(hashFunc, []byte(h.Secret))
+	mac.Write(body)
+	return h.Prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h HMAC) Verify(header http.Header, body []byte) error {
+	signature := header.Get(h.Header)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	// Hex digits may be in either case
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(strings.ToLower(h.Sign(body)))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// GitHub verifies GitHub's X-Hub-Signature-256 header
+func GitHub(secret string) Verifier {
+	return HMAC{Secret: secret, Header: GitHubHeader, Prefix: "sha256="}
+}
+
+// GitHubSHA1 verifies GitHub's older X-Hub-Signature header, for senders such
+// as GitHub Enterprise versions that don't send the SHA-256 one
+func GitHubSHA1(secret string) Verifier {
+	return HMAC{Secret: secret, Header: "X-Hub-Signature", Prefix: "sha1=", Hash: sha1.New}
+}
+
+// Gitea verifies Gitea's X-Gitea-Signature header, which Forgejo also sends
+func Gitea(secret string) Verifier {
+	return HMAC{Secret: secret, Header: GiteaHeader}
+}
+
+// Minici verifies the signatures of minici's own webhooks
+func Minici(secret string) Verifier {
+	return HMAC{Secret: secret, Header: MiniciHeader, Prefix: "sha256="}
+}
+
+// GitLab verifies GitLab's X-Gitlab-Token header. GitLab sends the secret
+// itself rather than signing the body, so only use it over HTTPS.
+func GitLab(secret string) Verifier {
+	return gitLab{secret: secret}
+}
+
+type gitLab struct {
+	secret string
+}
+
+func (g gitLab) Verify(header http.Header, body []byte) error {
+	token := header.Get(GitLabHeader)
+	if token == "" {
+		return ErrMissingSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(g.secret)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Any accepts requests any of the verifiers accept, so one endpoint can take
+// webhooks from several providers. Requests are checked by the first verifier
+// whose header they have.
+func Any(verifiers ...Verifier) Verifier {
+	return anyVerifier(verifiers)
+}
+
+type anyVerifier []Verifier
+
+func (a anyVerifier) Verify(header http.Header, body []byte) error {
+	for _, v := range a {
+		err := v.Verify(header, body)
+		if !errors.Is(err, ErrMissingSignature) {
+			return err
+		}
+	}
+	return ErrMissingSignature
+}
+
+// Provider returns the verifier for a provider's name: github, gitlab,
+// gitea or minici
+func Provider(name, secret string) (Verifier, error) {
+	switch strings.ToLower(name) {
+	case "github":
+		return GitHub(secret), nil
+	case "gitlab":
+		return GitLab(secret), nil
+	case "gitea", "forgejo":
+		return Gitea(secret), nil
+	case "minici":
+		return Minici(secret), nil
+	}
+	return nil, fmt.Errorf("unknown webhook provider %q, expected github, gitlab, gitea or minici", name)
+}
+
+// Request reads a request's body and verifies it, replacing the body so
+// handlers can read it again. Bodies larger than maxBodySize are refused,
+// or DefaultMaxBodySize if it's 0.
+func Request(v Verifier, r *http.Request, maxBodySize int64) ([]byte, error) {
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	if int64(len(body)) > maxBodySize {
+		return nil, fmt.Errorf("%w, the limit is %d bytes", ErrBodyTooLarge, maxBodySize)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := v.Verify(r.Header, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Middleware only passes requests v accepts on to next, responding 401 to
+// the rest
+func Middleware(v Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := Request(v, r, 0)
+		switch {
+		case errors.Is(err, ErrBodyTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
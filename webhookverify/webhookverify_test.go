@@ -0,0 +1,85 @@
+package webhookverify
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviders(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	// Computed with openssl dgst -sha256 -hmac s3cret
+	const signed = "232a5e067b6a5aa567ed5a1d21a0dffaf6ef0eeecb6bd9bcf43b3d5ef3fc4f43"
+	require.Equal(t, signed, HMAC{Secret: "s3cret"}.Sign(body))
+
+	tests := []struct {
+		name     string
+		verifier Verifier
+		header   http.Header
+	}{
+		{"github", GitHub("s3cret"), http.Header{GitHubHeader: {"sha256=" + signed}}},
+		{"github uppercase hex", GitHub("s3cret"), http.Header{GitHubHeader: {"sha256=" + strings.ToUpper(signed)}}},
+		{"gitea", Gitea("s3cret"), http.Header{GiteaHeader: {signed}}},
+		{"minici", Minici("s3cret"), http.Header{MiniciHeader: {"sha256=" + signed}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, test.verifier.Verify(test.header, body))
+			assert.ErrorIs(t, test.verifier.Verify(test.header, []byte("tampered")), ErrInvalidSignature)
+			assert.ErrorIs(t, test.verifier.Verify(http.Header{}, body), ErrMissingSignature)
+		})
+	}
+}
+
+func TestGitLabIgnoresBody(t *testing.T) {
+	header := http.Header{GitLabHeader: {"s3cret"}}
+	assert.NoError(t, GitLab("s3cret").Verify(header, []byte("anything")))
+	assert.ErrorIs(t, GitLab("other").Verify(header, nil), ErrInvalidSignature)
+}
+
+func TestAny(t *testing.T) {
+	body := []byte("payload")
+	verifier := Any(GitHub("one"), GitLab("two"))
+	assert.NoError(t, verifier.Verify(http.Header{GitLabHeader: {"two"}}, body))
+	assert.NoError(t, verifier.Verify(http.Header{GitHubHeader: {"sha256=" + HMAC{Secret: "one"}.Sign(body)}}, body))
+	// A request with a bad GitHub signature isn't checked against GitLab
+	assert.ErrorIs(t, verifier.Verify(http.Header{GitHubHeader: {"sha256=bad"}, GitLabHeader: {"two"}}, body), ErrInvalidSignature)
+	assert.ErrorIs(t, verifier.Verify(http.Header{}, body), ErrMissingSignature)
+}
+
+func TestProvider(t *testing.T) {
+	verifier, err := Provider("GitHub", "s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, GitHub("s3cret"), verifier)
+	_, err = Provider("bitbucket", "s3cret")
+	assert.ErrorContains(t, err, "unknown webhook provider")
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(Gitea("s3cret"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body can still be read after it was verified
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader("payload"))
+	req.Header.Set(GiteaHeader, HMAC{Secret: "s3cret"}.Sign([]byte("payload")))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "payload", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader("payload"))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader("payload"))
+	_, err := Request(Gitea("s3cret"), req, 3)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
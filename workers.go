@@ -0,0 +1,24 @@
+package minici
+
+// startWorkers returns a job starter that runs jobs on n goroutines, reused
+// from one job to the next, or each job in a new goroutine if n is 0. The
+// local agent never has more than n jobs assigned, so starting a job never
+// blocks.
+func startWorkers(n int) func(run func()) {
+	if n <= 0 {
+		return func(run func()) {
+			go run()
+		}
+	}
+	runs := make(chan func(), n)
+	for range n {
+		go func() {
+			for run := range runs {
+				run()
+			}
+		}()
+	}
+	return func(run func()) {
+		runs <- run
+	}
+}
@@ -0,0 +1,47 @@
+package minici
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// panickingExecutor panics for commands starting with "panic"
+type panickingExecutor struct{}
+
+func (panickingExecutor) Run(ctx context.Context, workspace Workspace, spec JobSpec) (Result, error) {
+	if strings.HasPrefix(spec.Command, "panic") {
+		panic("executor bug")
+	}
+	return Result{}, nil
+}
+
+func TestJobPanic(t *testing.T) {
+	ci := NewCIServer(
+		WithGitClient(&fakeGit{}),
+		WithExecutor(panickingExecutor{}),
+		WithMaxConcurrentJobs(1),
+	)
+
+	panicked := ci.ScheduleJob("https://example.com/repo.git", "main", "panic")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	jobs, err := ci.WaitForJobs(ctx, panicked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Status != JobStatusFailure {
+		t.Errorf("Expected a panicking job to fail, got %s", jobs[0].Status)
+	}
+	logs := strings.Join(ci.JobLogs(panicked), "\n")
+	if !strings.Contains(logs, "Job panicked: executor bug") || !strings.Contains(logs, "panickingExecutor.Run") {
+		t.Errorf("Expected the panic and its stack to be logged, got %q", logs)
+	}
+
+	// The worker that ran it goes on to run the next job
+	next := ci.ScheduleJob("https://example.com/repo.git", "main", "build")
+	if jobs, err := ci.WaitForJobs(ctx, next); err != nil || jobs[0].Status != JobStatusSuccess {
+		t.Errorf("Expected the next job to succeed, got %+v, %v", jobs, err)
+	}
+}